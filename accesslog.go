@@ -0,0 +1,79 @@
+package unregistry
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/accesslog"
+	"github.com/sirupsen/logrus"
+)
+
+// distributionAccessLogMessage is the message of the info log the registry app writes for every successful request.
+const distributionAccessLogMessage = "response completed"
+
+// accessLogHandler wraps the registry handler to write an access log entry for every request once it's served.
+func (r *Registry) accessLogHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rw := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		var body *countingReader
+		if req.Body != nil && req.Body != http.NoBody {
+			body = &countingReader{ReadCloser: req.Body}
+			req.Body = body
+		}
+		next.ServeHTTP(rw, req)
+
+		repo, _ := requestRoute(req.URL.Path)
+		entry := accesslog.Entry{
+			Time:      start,
+			Remote:    remoteIP(req),
+			Method:    req.Method,
+			Path:      req.URL.Path,
+			Status:    rw.status,
+			Repo:      repo,
+			Digest:    requestDigest(req, rw.Header()),
+			BytesOut:  rw.written,
+			Duration:  time.Since(start),
+			UserAgent: req.UserAgent(),
+		}
+		if body != nil {
+			entry.BytesIn = body.read
+		}
+		entry.User = r.requestUser(req)
+		r.accessLog.Log(entry)
+	})
+}
+
+// requestDigest returns the digest of the manifest or blob the request is for. It's taken from the response header
+// if the registry app set it, otherwise from the request path or the digest query parameter of an upload.
+func requestDigest(req *http.Request, header http.Header) string {
+	if dgst, err := digest.Parse(header.Get("Docker-Content-Digest")); err == nil {
+		return dgst.String()
+	}
+	for _, re := range []*regexp.Regexp{blobPathRegexp, manifestPathRegexp} {
+		if m := re.FindStringSubmatch(req.URL.Path); m != nil {
+			if dgst, err := digest.Parse(m[2]); err == nil {
+				return dgst.String()
+			}
+		}
+	}
+	if dgst, err := digest.Parse(req.URL.Query().Get("digest")); err == nil {
+		return dgst.String()
+	}
+	return ""
+}
+
+// accessLogFilterFormatter drops the access logs of the registry app from the application logs as they're replaced by
+// the configured access log.
+type accessLogFilterFormatter struct {
+	logrus.Formatter
+}
+
+func (f accessLogFilterFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if entry.Message == distributionAccessLogMessage {
+		return nil, nil
+	}
+	return f.Formatter.Format(entry)
+}
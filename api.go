@@ -0,0 +1,597 @@
+package unregistry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/psviderski/unregistry/internal/events"
+	"github.com/psviderski/unregistry/internal/metadata"
+	"github.com/psviderski/unregistry/internal/progress"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/psviderski/unregistry/internal/transfer"
+	"github.com/sirupsen/logrus"
+)
+
+// adminHandler returns the HTTP handler for the admin API that exposes privileged endpoints. It must only be served
+// on the local admin socket and never on the registry listener.
+func (r *Registry) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/config", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, r.cfg.Redacted())
+	})
+	mux.HandleFunc("GET /api/uploads", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, r.progress.List())
+	})
+	mux.Handle("GET /api/uploads/{id}/progress", uploadProgressHandler(r.progress))
+	mux.HandleFunc("POST /api/uploads/purge", r.purgeUploadsHandler)
+	mux.HandleFunc("GET /api/compat", r.compatHandler)
+	mux.HandleFunc("GET /api/history", r.tagHistoryHandler)
+	mux.HandleFunc("GET /api/dry-run", r.dryRunHandler)
+	mux.HandleFunc("GET /api/verification", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, r.verifier.Stats())
+	})
+	mux.HandleFunc("GET /api/reports", r.transferReportHandler)
+	mux.HandleFunc("GET /api/availability", r.availabilityHandler)
+	mux.HandleFunc("POST /api/exists", r.existsHandler)
+	mux.HandleFunc("GET /api/events", r.eventsHandler)
+	mux.HandleFunc("GET /api/blobs/{digest}", r.blobHandler)
+	mux.HandleFunc("GET /api/images", r.listImagesHandler)
+	mux.HandleFunc("GET /api/images/{ref...}", r.imageHandler)
+	mux.HandleFunc("DELETE /api/images/{ref...}", r.removeImageHandler)
+	mux.HandleFunc("POST "+importPath, r.importImagesHandler)
+	mux.HandleFunc("POST /api/tag", r.tagImageHandler)
+	mux.HandleFunc("GET /api/stats", r.statsHandler)
+	mux.HandleFunc("GET /api/deliveries", r.deliveriesHandler)
+	mux.HandleFunc("POST /api/shutdown", r.shutdownHandler)
+	if r.cfg.UI {
+		mux.HandleFunc("GET /{$}", uiHandler)
+	}
+
+	return sameOriginHandler(mux)
+}
+
+// sameOriginHandler rejects the requests changing the state that a browser sends on behalf of another website.
+// The admin socket is commonly forwarded to a local TCP port to open the web UI, so any website the user visits could
+// otherwise send simple cross-origin requests to it, e.g. POST /api/shutdown. Non-browser clients don't send
+// the Origin and Sec-Fetch-Site headers and aren't affected.
+func sameOriginHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, req)
+			return
+		}
+		if site := req.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+			http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+			return
+		}
+		if origin := req.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != req.Host {
+				http.Error(w, "cross-origin requests are not allowed", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// deliveriesHandler returns the delivery state of each peer registry with the images pending delivery to it, or
+// the replication state on each peer of the image specified in the optional "image" query parameter.
+func (r *Registry) deliveriesHandler(w http.ResponseWriter, req *http.Request) {
+	if image := req.URL.Query().Get("image"); image != "" {
+		named, err := reference.ParseNormalizedNamed(image)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid 'image' query parameter: %v", err), http.StatusBadRequest)
+			return
+		}
+		ref := reference.TagNameOnly(named).String()
+		replications, err := r.forwarder.ImageStatus(ref)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"image": ref,
+			"peers": replications,
+		})
+		return
+	}
+
+	peers, err := r.forwarder.Status()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"peers": peers})
+}
+
+// compatHandler returns the compatibility report for the image specified in the "image" query parameter explaining
+// how it's identified by Docker with the containerd image store and with a classic graphdriver storage.
+func (r *Registry) compatHandler(w http.ResponseWriter, req *http.Request) {
+	ref := req.URL.Query().Get("image")
+	if ref == "" {
+		http.Error(w, "'image' query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	report, err := containerd.Compat(req.Context(), r.client, ref, r.platform)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errdefs.IsNotFound(err) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	for _, warning := range report.Warnings {
+		logrus.WithField("image", report.Image).Warn(warning)
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// tagHistoryHandler returns the history of digests the tagged image specified in the "image" query parameter
+// pointed to.
+func (r *Registry) tagHistoryHandler(w http.ResponseWriter, req *http.Request) {
+	named, err := reference.ParseNormalizedNamed(req.URL.Query().Get("image"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid 'image' query parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	ref := reference.TagNameOnly(named).String()
+
+	history, err := metadata.TagHistory(r.metadata, ref)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if history == nil {
+		history = []metadata.TagHistoryEntry{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"image":   ref,
+		"history": history,
+	})
+}
+
+// dryRunHandler returns what would be transferred by the pushes received in the dry-run mode. The plan is returned
+// as human-readable text if the client accepts "text/plain".
+func (r *Registry) dryRunHandler(w http.ResponseWriter, req *http.Request) {
+	if r.dryRun == nil {
+		http.Error(w, "dry-run mode is disabled", http.StatusNotFound)
+		return
+	}
+
+	plan := r.dryRun.Plan()
+	if strings.Contains(req.Header.Get("Accept"), "text/plain") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = fmt.Fprint(w, plan.String())
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// transferReportHandler returns the latest transfer report for the image specified in the "image" query parameter.
+// The report is returned as human-readable text if the client accepts "text/plain".
+func (r *Registry) transferReportHandler(w http.ResponseWriter, req *http.Request) {
+	named, err := reference.ParseNormalizedNamed(req.URL.Query().Get("image"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid 'image' query parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+	ref := reference.TagNameOnly(named).String()
+
+	report, err := transfer.GetReport(r.metadata, ref)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, metadata.ErrNotFound) {
+			status = http.StatusNotFound
+			err = fmt.Errorf("no transfer report for image '%s'", ref)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if strings.Contains(req.Header.Get("Accept"), "text/plain") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = fmt.Fprint(w, report.String())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// availabilityHandler reports whether the image specified in the "image" query parameter and all its content for
+// the registry platform are present on the host. It lets cluster agents decide whether the image needs to be
+// fetched from a peer.
+func (r *Registry) availabilityHandler(w http.ResponseWriter, req *http.Request) {
+	ref, err := parseImageRef(req.URL.Query().Get("image"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid 'image' query parameter: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	availability, err := containerd.ImageAvailability(req.Context(), r.client, ref, r.platform)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, availability)
+}
+
+// existsRequest is the request body of the batch image existence check.
+type existsRequest struct {
+	Images []string `json:"images"`
+}
+
+// existsResult is the existence of an image in the batch image existence check.
+type existsResult struct {
+	Image  string        `json:"image"`
+	Exists bool          `json:"exists"`
+	Digest digest.Digest `json:"digest,omitempty"`
+}
+
+// existsHandler checks whether each of the images in the request body and all their content are present on the host.
+// An image referenced by a tag and digest only exists if the tag points to the digest. It lets CI pipelines skip
+// pushing images that already exist on the target hosts.
+func (r *Registry) existsHandler(w http.ResponseWriter, req *http.Request) {
+	var body existsRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	refs := make([]reference.Named, len(body.Images))
+	for i, image := range body.Images {
+		ref, err := parseImageRef(image)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid image reference '%s': %v", image, err), http.StatusBadRequest)
+			return
+		}
+		refs[i] = ref
+	}
+
+	results := make([]existsResult, len(refs))
+	for i, ref := range refs {
+		availability, err := containerd.ImageAvailability(req.Context(), r.client, ref, r.platform)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		results[i] = existsResult{
+			Image:  body.Images[i],
+			Exists: availability.Available,
+			Digest: availability.Digest,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"images": results})
+}
+
+// removeImageHandler removes the image with the reference in the path, e.g. /api/images/ubuntu:24.04, along with
+// the garbage collection roots unregistry created for its exclusive content, and reports what became collectible.
+func (r *Registry) removeImageHandler(w http.ResponseWriter, req *http.Request) {
+	ref, err := parseImageRef(req.PathValue("ref"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid image reference: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	removal, err := containerd.RemoveImage(req.Context(), r.client, ref)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errdefs.IsNotFound(err) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"image":       removal.Image,
+		"collectible": transfer.HumanSize(removal.CollectibleBytes),
+	}).Info("Removed image.")
+	r.events.Publish(events.Event{
+		Type:   events.TypeDelete,
+		Image:  removal.Image,
+		Digest: removal.Digest,
+	})
+
+	writeJSON(w, http.StatusOK, removal)
+}
+
+// listImagesHandler returns the summaries of all images on the host including their sizes and platforms.
+func (r *Registry) listImagesHandler(w http.ResponseWriter, req *http.Request) {
+	imgs, err := containerd.ListImages(req.Context(), r.client, r.cfg.Snapshotter, r.platform)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"images": imgs})
+}
+
+// statsHandler returns the number of images and tags on the host and how much disk space they consume in
+// the containerd content store in total and per repository.
+func (r *Registry) statsHandler(w http.ResponseWriter, req *http.Request) {
+	stats, err := containerd.Stats(req.Context(), r.client)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// imageManifestsHandler returns the platform manifests of the image with the reference in the path, e.g.
+// /api/images/ubuntu:24.04, with the sizes of their layers.
+func (r *Registry) imageManifestsHandler(w http.ResponseWriter, req *http.Request) {
+	ref, err := parseImageRef(req.PathValue("ref"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid image reference: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	manifests, err := containerd.ImageManifests(req.Context(), r.client, ref)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			msg := err.Error()
+			if hint := r.namespaceHint(req.Context(), ref); hint != "" {
+				msg += ". " + hint
+			}
+			http.Error(w, msg, http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"image":     ref.String(),
+		"manifests": manifests,
+	})
+}
+
+// tagRequest is the request body of the image tagging.
+type tagRequest struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// tagImageHandler tags the source image in the request body with the target reference like "docker tag" does.
+func (r *Registry) tagImageHandler(w http.ResponseWriter, req *http.Request) {
+	var body tagRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	source, err := parseImageRef(body.Source)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid source image reference '%s': %v", body.Source, err), http.StatusBadRequest)
+		return
+	}
+	target, err := parseImageRef(body.Target)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid target image reference '%s': %v", body.Target, err), http.StatusBadRequest)
+		return
+	}
+	if _, ok := target.(reference.Digested); ok {
+		http.Error(w, fmt.Sprintf("target image reference '%s' must not have a digest", body.Target),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Images pushed by digest aren't verified until they're tagged.
+	if err = r.signatures.VerifyImage(req.Context(), r.client, source); err != nil {
+		status := http.StatusInternalServerError
+		if containerd.IsSignatureMissing(err) {
+			status = http.StatusForbidden
+		} else if errdefs.IsNotFound(err) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if err = containerd.TagImage(req.Context(), r.client, source, target); err != nil {
+		status := http.StatusInternalServerError
+		if errdefs.IsNotFound(err) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"source": source.String(),
+		"target": target.String(),
+	}).Info("Tagged image.")
+
+	writeJSON(w, http.StatusCreated, map[string]string{"image": target.String()})
+}
+
+// parseImageRef parses the image reference normalizing it the way containerd image store expects it. The "latest" tag
+// is added if the reference has neither a tag nor a digest.
+func parseImageRef(image string) (reference.Named, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return named, nil
+	}
+	return reference.TagNameOnly(named), nil
+}
+
+// eventsHandler streams push, pull, and delete events as server-sent events (SSE) until the client disconnects.
+// Each event is a JSON-encoded events.Event named after its type. The optional "type" query parameter is
+// a comma-separated list of the event types to stream, e.g. ?type=push,delete.
+func (r *Registry) eventsHandler(w http.ResponseWriter, req *http.Request) {
+	types := map[events.Type]bool{events.TypePush: true, events.TypePull: true, events.TypeDelete: true}
+	if q := req.URL.Query().Get("type"); q != "" {
+		selected := make(map[events.Type]bool)
+		for _, t := range strings.Split(q, ",") {
+			t := events.Type(strings.TrimSpace(t))
+			if !types[t] {
+				http.Error(w, fmt.Sprintf("invalid event type '%s': expected push, pull, or delete", t),
+					http.StatusBadRequest)
+				return
+			}
+			selected[t] = true
+		}
+		types = selected
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	ch, unsubscribe := r.events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case e := <-ch:
+			if !types[e.Type] {
+				continue
+			}
+			if err := writeSSE(w, string(e.Type), e); err != nil {
+				logrus.WithError(err).Debug("Failed to write registry event.")
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// blobHandler serves the content of the blob with the given digest from the containerd content store regardless of
+// the repository it was pushed to. It lets cluster agents fetch blobs for their peers.
+func (r *Registry) blobHandler(w http.ResponseWriter, req *http.Request) {
+	dgst, err := digest.Parse(req.PathValue("digest"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid digest: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	release, err := containerd.LeaseContent(req.Context(), r.client, dgst)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer release()
+
+	ra, err := r.client.ContentStore().ReaderAt(req.Context(), ocispec.Descriptor{Digest: dgst})
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errdefs.IsNotFound(err) {
+			status = http.StatusNotFound
+			err = fmt.Errorf("blob '%s' not found", dgst)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer ra.Close()
+
+	if err = r.serveBlob(w, req, dgst, ra); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// listenUnix listens on the unix socket at the given path removing a stale socket file left from a previous run.
+// The socket is only accessible by the owner.
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("remove stale socket '%s': %w", path, err)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0o600); err != nil {
+		_ = ln.Close()
+		return nil, fmt.Errorf("set permissions on socket '%s': %w", path, err)
+	}
+
+	return ln, nil
+}
+
+// uploadProgressHandler streams progress events of a blob upload as server-sent events (SSE) until the upload
+// is finished or the client disconnects. Each event is a JSON-encoded progress.Event.
+func uploadProgressHandler(tracker *progress.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		events, unsubscribe, ok := tracker.Subscribe(id)
+		if !ok {
+			http.Error(w, fmt.Sprintf("upload '%s' not found", id), http.StatusNotFound)
+			return
+		}
+		defer unsubscribe()
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		log := logrus.WithField("upload.id", id)
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				if err := writeSSE(w, string(e.State), e); err != nil {
+					log.WithError(err).Debug("Failed to write upload progress event.")
+					return
+				}
+				flusher.Flush()
+
+				if e.State.Final() {
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeSSE writes a server-sent event with the given name and JSON-encoded data.
+func writeSSE(w http.ResponseWriter, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("marshal event data: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
+
+// writeJSON writes the JSON-encoded value with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.WithError(err).Debug("Failed to write JSON response.")
+	}
+}
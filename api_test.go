@@ -0,0 +1,77 @@
+package unregistry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSameOriginHandler(t *testing.T) {
+	handler := sameOriginHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{name: "CLI request", method: http.MethodPost, want: http.StatusNoContent},
+		{
+			name:    "cross-origin GET",
+			method:  http.MethodGet,
+			headers: map[string]string{"Origin": "https://evil.example", "Sec-Fetch-Site": "cross-site"},
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "same-origin POST",
+			method:  http.MethodPost,
+			headers: map[string]string{"Origin": "http://localhost:8080", "Sec-Fetch-Site": "same-origin"},
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "typed URL",
+			method:  http.MethodDelete,
+			headers: map[string]string{"Sec-Fetch-Site": "none"},
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "cross-site POST",
+			method:  http.MethodPost,
+			headers: map[string]string{"Origin": "https://evil.example", "Sec-Fetch-Site": "cross-site"},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "same-site POST from another port",
+			method:  http.MethodPost,
+			headers: map[string]string{"Sec-Fetch-Site": "same-site"},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "cross-origin POST without fetch metadata",
+			method:  http.MethodPost,
+			headers: map[string]string{"Origin": "https://evil.example"},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "opaque origin",
+			method:  http.MethodPost,
+			headers: map[string]string{"Origin": "null"},
+			want:    http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://localhost:8080/api/shutdown", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
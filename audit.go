@@ -0,0 +1,179 @@
+package unregistry
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/audit"
+	"github.com/sirupsen/logrus"
+)
+
+// blobPathRegexp matches the path of a blob: /v2/<name>/blobs/<digest>
+var blobPathRegexp = regexp.MustCompile(`^/v2/(.+)/blobs/([^/]+)$`)
+
+// auditHandler wraps the registry handler to write an audit record for every manifest push, pull, and deletion, blob
+// upload completion, blob deletion, and image import so that operators can reconstruct what was deployed where and
+// by whom.
+func (r *Registry) auditHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		record, ok := auditRecord(req)
+		if !ok {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		aw := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		var body *countingReader
+		if req.Body != nil && req.Body != http.NoBody {
+			body = &countingReader{ReadCloser: req.Body}
+			req.Body = body
+		}
+		next.ServeHTTP(aw, req)
+
+		record.Status = aw.status
+		record.Result = audit.ResultFailure
+		if aw.status < 300 {
+			record.Result = audit.ResultSuccess
+		}
+		record.User = r.requestUser(req)
+		if dgst, err := digest.Parse(aw.Header().Get("Docker-Content-Digest")); err == nil {
+			record.Digest = dgst
+		}
+
+		if record.Result == audit.ResultSuccess {
+			switch record.Action {
+			case audit.ActionManifestGet:
+				record.Size = aw.written
+			case audit.ActionManifestPut, audit.ActionImageImport:
+				if body != nil {
+					record.Size = body.read
+				}
+			case audit.ActionBlobCommit:
+				// The blob is uploaded in chunks by multiple requests so get its size from the content store.
+				if size, err := r.blobSize(req.Context(), record.Digest); err == nil {
+					record.Size = size
+				}
+			}
+		}
+
+		if err := r.audit.Log(record); err != nil {
+			logrus.WithError(err).Error("Failed to write audit record.")
+		}
+	})
+}
+
+// auditRecord returns the audit record prefilled from the request if the request should be audited.
+func auditRecord(req *http.Request) (audit.Record, bool) {
+	record := audit.Record{RemoteAddr: remoteIP(req)}
+
+	if m := manifestPathRegexp.FindStringSubmatch(req.URL.Path); m != nil {
+		switch req.Method {
+		case http.MethodGet:
+			record.Action = audit.ActionManifestGet
+		case http.MethodPut:
+			record.Action = audit.ActionManifestPut
+		case http.MethodDelete:
+			record.Action = audit.ActionManifestDelete
+		default:
+			return record, false
+		}
+		record.Repo = m[1]
+		if dgst, err := digest.Parse(m[2]); err == nil {
+			record.Digest = dgst
+		} else if reference.TagRegexp.MatchString(m[2]) {
+			record.Tag = m[2]
+		}
+		return record, true
+	}
+
+	m := uploadSessionPathRegexp.FindStringSubmatch(req.URL.Path)
+	if req.Method != http.MethodPut {
+		m = nil
+	}
+	if isMonolithicUpload(req) {
+		m = uploadsPathRegexp.FindStringSubmatch(req.URL.Path)
+	}
+	if m != nil {
+		record.Action = audit.ActionBlobCommit
+		record.Repo = m[1]
+		if dgst, err := digest.Parse(req.URL.Query().Get("digest")); err == nil {
+			record.Digest = dgst
+		}
+		return record, true
+	}
+
+	if req.URL.Path == importPath && req.Method == http.MethodPost {
+		record.Action = audit.ActionImageImport
+		record.Repo = req.URL.Query().Get("repo")
+		return record, true
+	}
+
+	if m := blobPathRegexp.FindStringSubmatch(req.URL.Path); m != nil && req.Method == http.MethodDelete {
+		record.Action = audit.ActionBlobDelete
+		record.Repo = m[1]
+		if dgst, err := digest.Parse(m[2]); err == nil {
+			record.Digest = dgst
+		}
+		return record, true
+	}
+
+	return record, false
+}
+
+// remoteIP returns the IP address of the client that sent the request.
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// recordingResponseWriter records the response status and the number of bytes written.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	written     int64
+	wroteHeader bool
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *recordingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// countingReader counts the number of bytes read from the request body.
+type countingReader struct {
+	io.ReadCloser
+	read int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+	return n, err
+}
@@ -0,0 +1,119 @@
+package unregistry
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// sharedAccessControllerName is the name of the access controller that makes the registry app use the access
+// controller instance passed in the "controller" option, so that the app and the handlers wrapping it share
+// the credentials cache.
+const sharedAccessControllerName = "unregistry-shared"
+
+// credentialCacheTTL is how long verified credentials are trusted without comparing them with the bcrypt hashes in
+// the htpasswd file again. It bounds how long a removed user or a changed password keeps working.
+const credentialCacheTTL = time.Minute
+
+// maxCachedCredentials is the number of cached credentials over which the expired ones are forgotten.
+const maxCachedCredentials = 256
+
+func init() {
+	err := auth.Register(sharedAccessControllerName, func(options map[string]interface{}) (auth.AccessController, error) {
+		ac, ok := options["controller"].(auth.AccessController)
+		if !ok || ac == nil {
+			return nil, fmt.Errorf("access controller instance is required")
+		}
+		return ac, nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// newAccessController creates the access controller that authenticates requests with the htpasswd file and caches
+// the verified credentials.
+func newAccessController(cfg Config) (auth.AccessController, error) {
+	name, params := accessControllerConfig(cfg)
+	ac, err := auth.GetAccessController(name, params)
+	if err != nil {
+		return nil, err
+	}
+	return &cachingAccessController{AccessController: ac, users: make(map[[sha256.Size]byte]cachedUser)}, nil
+}
+
+// appAuthConfig returns the auth configuration of the registry app that authenticates requests with the access
+// controller instance.
+func appAuthConfig(ac auth.AccessController) configuration.Auth {
+	return configuration.Auth{sharedAccessControllerName: configuration.Parameters{"controller": ac}}
+}
+
+// cachingAccessController wraps an access controller to remember the credentials it verified. A request is authorized
+// by the registry app and by several handlers wrapping it, e.g. to rate limit or audit it by user, and comparing
+// a bcrypt hash takes up to a second on small devices, so every chunk of an upload would pay for it multiple times.
+// The credentials are cached by the hash of the Authorization header. Invalid credentials aren't cached.
+type cachingAccessController struct {
+	auth.AccessController
+	mu    sync.Mutex
+	users map[[sha256.Size]byte]cachedUser
+}
+
+// cachedUser is a user with verified credentials.
+type cachedUser struct {
+	name    string
+	expires time.Time
+}
+
+func (c *cachingAccessController) Authorized(req *http.Request, access ...auth.Access) (*auth.Grant, error) {
+	if _, _, ok := req.BasicAuth(); !ok {
+		return c.AccessController.Authorized(req, access...)
+	}
+
+	key := sha256.Sum256([]byte(req.Header.Get("Authorization")))
+	now := time.Now()
+	c.mu.Lock()
+	user, ok := c.users[key]
+	c.mu.Unlock()
+	if ok && now.Before(user.expires) {
+		return &auth.Grant{User: auth.UserInfo{Name: user.name}, Resources: resources(access)}, nil
+	}
+
+	grant, err := c.AccessController.Authorized(req, access...)
+	if err != nil || grant == nil || grant.User.Name == "" {
+		return grant, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.users) >= maxCachedCredentials {
+		for k, u := range c.users {
+			if !now.Before(u.expires) {
+				delete(c.users, k)
+			}
+		}
+	}
+	c.users[key] = cachedUser{name: grant.User.Name, expires: now.Add(credentialCacheTTL)}
+	return grant, nil
+}
+
+// requestUser returns the name of the user the request is authenticated as. It's empty if authentication is disabled
+// or the request has no valid credentials. The credentials are verified rather than taken from the Authorization header
+// as is because the handlers wrapping the registry app may respond before the app checks them, e.g. when rejecting
+// an oversized manifest or a request over the rate limit.
+func (r *Registry) requestUser(req *http.Request) string {
+	if r.accessController == nil {
+		return ""
+	}
+	if _, _, ok := req.BasicAuth(); !ok {
+		return ""
+	}
+	grant, err := r.accessController.Authorized(req)
+	if err != nil || grant == nil {
+		return ""
+	}
+	return grant.User.Name
+}
@@ -0,0 +1,141 @@
+package unregistry
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/psviderski/unregistry/internal/audit"
+)
+
+// newTestAccessController returns the access controller the registry creates for the htpasswd file of writeHtpasswd.
+func newTestAccessController(t *testing.T) auth.AccessController {
+	t.Helper()
+	ac, err := newAccessController(Config{Htpasswd: writeHtpasswd(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ac
+}
+
+func TestRequestUser(t *testing.T) {
+	r := &Registry{accessController: newTestAccessController(t)}
+
+	tests := []struct {
+		name string
+		auth func(req *http.Request)
+		want string
+	}{
+		{name: "no credentials", want: ""},
+		{name: "valid credentials", auth: func(req *http.Request) { req.SetBasicAuth("alice", "secret") }, want: "alice"},
+		{name: "wrong password", auth: func(req *http.Request) { req.SetBasicAuth("alice", "wrong") }, want: ""},
+		{name: "forged user", auth: func(req *http.Request) { req.SetBasicAuth("admin", "x") }, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+			if tt.auth != nil {
+				tt.auth(req)
+			}
+			if got := r.requestUser(req); got != tt.want {
+				t.Fatalf("expected user %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/", nil)
+	req.SetBasicAuth("alice", "secret")
+	if got := (&Registry{}).requestUser(req); got != "" {
+		t.Fatalf("expected no user with authentication disabled, got %q", got)
+	}
+}
+
+func TestAuditRejectedRequestWithForgedUser(t *testing.T) {
+	var buf bytes.Buffer
+	r := &Registry{accessController: newTestAccessController(t), audit: audit.NewLogger(&buf)}
+	// The manifest is rejected before the registry app checks the credentials.
+	handler := r.auditHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	}))
+
+	req := httptest.NewRequest(http.MethodPut, "/v2/myapp/manifests/latest", nil)
+	req.SetBasicAuth("admin", "x")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var record audit.Record
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Action != audit.ActionManifestPut || record.Result != audit.ResultFailure {
+		t.Fatalf("expected a failed manifest push to be audited, got %+v", record)
+	}
+	if record.User != "" {
+		t.Fatalf("expected no user for unverified credentials, got %q", record.User)
+	}
+}
+
+// countingAccessController counts the requests it authorizes.
+type countingAccessController struct {
+	auth.AccessController
+	calls int
+}
+
+func (c *countingAccessController) Authorized(req *http.Request, access ...auth.Access) (*auth.Grant, error) {
+	c.calls++
+	return c.AccessController.Authorized(req, access...)
+}
+
+func TestCachingAccessController(t *testing.T) {
+	name, params := accessControllerConfig(Config{Htpasswd: writeHtpasswd(t)})
+	htpasswd, err := auth.GetAccessController(name, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counting := &countingAccessController{AccessController: htpasswd}
+	ac := &cachingAccessController{AccessController: counting, users: make(map[[32]byte]cachedUser)}
+
+	authorize := func(user, password string) (*auth.Grant, error) {
+		req := httptest.NewRequest(http.MethodPatch, "/v2/myapp/blobs/uploads/id", nil)
+		req.SetBasicAuth(user, password)
+		return ac.Authorized(req)
+	}
+
+	for i := 0; i < 3; i++ {
+		grant, err := authorize("alice", "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grant.User.Name != "alice" {
+			t.Fatalf("expected user alice, got %q", grant.User.Name)
+		}
+	}
+	if counting.calls != 1 {
+		t.Fatalf("expected the credentials to be verified once, got %d verifications", counting.calls)
+	}
+
+	// Invalid credentials are verified every time.
+	for i := 0; i < 2; i++ {
+		if _, err = authorize("alice", "wrong"); err == nil {
+			t.Fatal("expected invalid credentials to be rejected")
+		}
+	}
+	if counting.calls != 3 {
+		t.Fatalf("expected invalid credentials not to be cached, got %d verifications", counting.calls)
+	}
+
+	// Expired credentials are verified again.
+	for key, user := range ac.users {
+		user.expires = time.Now().Add(-time.Second)
+		ac.users[key] = user
+	}
+	if _, err = authorize("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if counting.calls != 4 {
+		t.Fatalf("expected expired credentials to be verified again, got %d verifications", counting.calls)
+	}
+}
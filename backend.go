@@ -0,0 +1,304 @@
+package unregistry
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/psviderski/unregistry/internal/storage/docker"
+	"github.com/psviderski/unregistry/internal/storage/fallback"
+	"github.com/psviderski/unregistry/internal/storage/ocilayout"
+	"github.com/psviderski/unregistry/internal/storage/routing"
+	"github.com/psviderski/unregistry/pkg/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// readFallbackContainerd is the read fallback kind of another namespace of the containerd image store.
+	readFallbackContainerd = "containerd"
+	// readFallbackOCI is the read fallback kind of an OCI image layout directory.
+	readFallbackOCI = "oci"
+)
+
+const (
+	// BackendContainerd stores images directly in the containerd image store.
+	BackendContainerd = containerd.BackendName
+	// BackendDocker stores images in the Docker image store through the Docker Engine API.
+	BackendDocker = docker.BackendName
+)
+
+// newContainerdClient creates a containerd client connected to the configured endpoint that uses the namespace by
+// default.
+func newContainerdClient(cfg Config, namespace string) (*client.Client, error) {
+	if cfg.dialContainerd != nil {
+		return cfg.dialContainerd(namespace)
+	}
+	tlsConfig, err := containerdTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return containerd.NewClient(cfg.ContainerdSock, namespace, containerd.WithTLS(tlsConfig))
+}
+
+// NamespaceAuto is the containerd namespace value that makes the registry detect the namespace to use at startup.
+const NamespaceAuto = "auto"
+
+// detectedNamespaces are the well-known containerd namespaces in the order of preference for the auto-detection:
+// Docker, Kubernetes (CRI), and nerdctl or ctr.
+var detectedNamespaces = []string{"moby", "k8s.io", "default"}
+
+// resolveNamespace returns the containerd namespace to store images in. It detects the namespace if it's configured
+// as NamespaceAuto: the first of detectedNamespaces that has images, or "moby" if none of them has. Otherwise, it
+// returns the configured namespace and warns if it has no images while other namespaces have.
+func resolveNamespace(cfg Config) string {
+	fallbackNamespace := cfg.ContainerdNamespace
+	if fallbackNamespace == NamespaceAuto {
+		fallbackNamespace = detectedNamespaces[0]
+	}
+	cli, err := newContainerdClient(cfg, fallbackNamespace)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to check images in containerd namespaces.")
+		return fallbackNamespace
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	counts, err := containerd.NamespaceImageCounts(ctx, cli)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to check images in containerd namespaces.")
+		return fallbackNamespace
+	}
+
+	if cfg.ContainerdNamespace == NamespaceAuto {
+		for _, ns := range detectedNamespaces {
+			if counts[ns] > 0 {
+				logrus.WithFields(logrus.Fields{
+					"namespace": ns,
+					"images":    counts[ns],
+				}).Info("Detected containerd namespace with images.")
+				return ns
+			}
+		}
+		logrus.WithField("namespace", fallbackNamespace).Info(
+			"No images found in well-known containerd namespaces, using the default one.")
+		return fallbackNamespace
+	}
+
+	if counts[cfg.ContainerdNamespace] > 0 {
+		return cfg.ContainerdNamespace
+	}
+	var others []string
+	for _, ns := range slices.Sorted(maps.Keys(counts)) {
+		if counts[ns] > 0 && !slices.Contains(cfg.MergeNamespaces, ns) {
+			others = append(others, fmt.Sprintf("%s (%d images)", ns, counts[ns]))
+		}
+	}
+	if len(others) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"namespace": cfg.ContainerdNamespace,
+			"others":    strings.Join(others, ", "),
+		}).Warn("Configured containerd namespace has no images but other namespaces have. Pulls will not find " +
+			"them: set --namespace to the namespace of your runtime, e.g. 'k8s.io' for Kubernetes or 'default' for " +
+			"nerdctl, or '" + NamespaceAuto + "' to detect it.")
+	}
+	return cfg.ContainerdNamespace
+}
+
+// newDockerBackend connects to the Docker daemon and creates the blob store of the "docker" backend. It fails fast if
+// the daemon is unreachable or too old to save images in the OCI image layout.
+func newDockerBackend(cfg Config) (*docker.Client, *docker.Store, error) {
+	cli, err := docker.NewClient(cfg.DockerSock)
+	if err != nil {
+		return nil, nil, err
+	}
+	apiVersion, err := cli.Ping(context.Background())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dir := cfg.DockerDataDir
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "unregistry-docker")
+	}
+	store, err := docker.NewStore(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"sock":        cfg.DockerSock,
+		"api_version": apiVersion,
+		"data_dir":    dir,
+	}).Info("Using Docker Engine API backend.")
+
+	return cli, store, nil
+}
+
+// blobSize returns the size of the blob in the content store of the backend.
+func (r *Registry) blobSize(ctx context.Context, dgst digest.Digest) (int64, error) {
+	if r.dockerStore != nil {
+		return r.dockerStore.Stat(dgst)
+	}
+	if r.client == nil {
+		return 0, fmt.Errorf("blob size is not available for the '%s' backend", r.cfg.Backend)
+	}
+	info, err := r.client.ContentStore().Info(ctx, dgst)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// parseReadFallback splits the read fallback in the "<kind>:<target>" form into its kind and target.
+func parseReadFallback(fallback string) (string, string, error) {
+	kind, target, ok := strings.Cut(fallback, ":")
+	if !ok || target == "" || (kind != readFallbackContainerd && kind != readFallbackOCI) {
+		return "", "", fmt.Errorf("invalid read fallback '%s': expected 'containerd:<namespace>' or 'oci:<dir>'",
+			fallback)
+	}
+	return kind, target, nil
+}
+
+// validateReadFallback checks the read fallback without accessing its storage.
+func (c Config) validateReadFallback(fallback string) error {
+	kind, target, err := parseReadFallback(fallback)
+	if err != nil {
+		return err
+	}
+	if kind == readFallbackContainerd && c.Backend != BackendDocker && target == c.ContainerdNamespace {
+		return fmt.Errorf("invalid read fallback '%s': namespace '%s' is already the primary storage", fallback,
+			target)
+	}
+	return nil
+}
+
+// newReadFallbacks creates the read-only backends of the merged namespaces and read fallbacks in the configured order.
+// It returns the containerd clients created for them that must be closed with the registry. The repository filter
+// hides the repositories that can't be accessed from the merged catalog.
+func newReadFallbacks(
+	cfg Config, repoFilter *containerd.RepositoryFilter,
+) ([]fallback.Backend, []*client.Client, error) {
+	var (
+		backends []fallback.Backend
+		clients  []*client.Client
+	)
+	closeClients := func() {
+		for _, cli := range clients {
+			_ = cli.Close()
+		}
+	}
+	// The merged namespaces are consulted before the other read fallbacks.
+	readFallbacks := make([]string, 0, len(cfg.MergeNamespaces)+len(cfg.ReadFallbacks))
+	for _, ns := range cfg.MergeNamespaces {
+		readFallbacks = append(readFallbacks, readFallbackContainerd+":"+ns)
+	}
+	readFallbacks = append(readFallbacks, cfg.ReadFallbacks...)
+
+	for _, f := range readFallbacks {
+		kind, target, err := parseReadFallback(f)
+		if err != nil {
+			closeClients()
+			return nil, nil, err
+		}
+
+		var ns distribution.Namespace
+		switch kind {
+		case readFallbackContainerd:
+			cli, err := newContainerdClient(cfg, target)
+			if err != nil {
+				closeClients()
+				return nil, nil, err
+			}
+			clients = append(clients, cli)
+			ns, err = storage.New(context.Background(), containerd.BackendName, storage.Options{
+				"client":     cli,
+				"repofilter": repoFilter,
+			})
+			if err != nil {
+				closeClients()
+				return nil, nil, fmt.Errorf("create read fallback '%s': %w", f, err)
+			}
+		case readFallbackOCI:
+			if ns, err = ocilayout.New(target); err != nil {
+				closeClients()
+				return nil, nil, fmt.Errorf("create read fallback '%s': %w", f, err)
+			}
+		}
+		backends = append(backends, fallback.Backend{Name: f, Namespace: ns})
+	}
+	if len(backends) > 0 {
+		logrus.WithField("fallbacks", readFallbacks).Info("Reading images missing in the primary storage " +
+			"from the fallback storages.")
+	}
+	return backends, clients, nil
+}
+
+// parseNamespaceRoute splits the namespace route in the "<pattern>=<namespace>" form into the repository pattern and
+// the containerd namespace. The pattern is either a repository name or a repository name prefix followed by "/*".
+func parseNamespaceRoute(route string) (string, string, error) {
+	pattern, namespace, ok := strings.Cut(route, "=")
+	pattern, namespace = strings.TrimSpace(pattern), strings.TrimSpace(namespace)
+	if !ok || pattern == "" || namespace == "" {
+		return "", "", fmt.Errorf("invalid namespace route '%s': expected '<repo>=<namespace>' or "+
+			"'<repo prefix>/*=<namespace>'", route)
+	}
+	if _, err := reference.WithName(strings.TrimSuffix(pattern, "/*")); err != nil {
+		return "", "", fmt.Errorf("invalid namespace route '%s': invalid repository pattern '%s'", route, pattern)
+	}
+	return pattern, namespace, nil
+}
+
+// newNamespaceRoutes creates the containerd backends of the namespaces the namespace routes send repositories to.
+// The routes to the primary namespace are served by the primary backend. It returns the containerd clients created for
+// the other namespaces that must be closed with the registry.
+func newNamespaceRoutes(
+	cfg Config, primary distribution.Namespace, options func(*client.Client) storage.Options,
+) ([]routing.Route, []*client.Client, error) {
+	var (
+		routes  []routing.Route
+		clients []*client.Client
+	)
+	closeClients := func() {
+		for _, cli := range clients {
+			_ = cli.Close()
+		}
+	}
+	backends := map[string]distribution.Namespace{cfg.ContainerdNamespace: primary}
+	for _, r := range cfg.NamespaceRoutes {
+		pattern, namespace, err := parseNamespaceRoute(r)
+		if err != nil {
+			closeClients()
+			return nil, nil, err
+		}
+
+		backend, ok := backends[namespace]
+		if !ok {
+			cli, err := newContainerdClient(cfg, namespace)
+			if err != nil {
+				closeClients()
+				return nil, nil, err
+			}
+			clients = append(clients, cli)
+			if backend, err = storage.New(context.Background(), containerd.BackendName, options(cli)); err != nil {
+				closeClients()
+				return nil, nil, fmt.Errorf("create storage backend for containerd namespace '%s': %w", namespace, err)
+			}
+			backends[namespace] = backend
+		}
+		routes = append(routes, routing.Route{Pattern: pattern, Namespace: backend})
+	}
+	if len(routes) > 0 {
+		logrus.WithField("routes", cfg.NamespaceRoutes).Info("Routing repositories to containerd namespaces.")
+	}
+	return routes, clients, nil
+}
@@ -0,0 +1,98 @@
+package unregistry
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/sirupsen/logrus"
+)
+
+// globalBlobPathRegexp matches the repository-agnostic blob path and captures the blob digest. The "_blobs" name
+// can't be a repository name as repository path components must start with a lowercase letter or digit.
+var globalBlobPathRegexp = regexp.MustCompile(`^/v2/_blobs/([^/]+)$`)
+
+// globalBlobsHandler serves any blob from the containerd content store by its digest at /v2/_blobs/<digest> without
+// a repository name. Content in containerd isn't namespaced per repository so the repository in the regular blob path
+// is meaningless for fetching blobs. Other requests are passed to the next handler.
+func (r *Registry) globalBlobsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		m := globalBlobPathRegexp.FindStringSubmatch(req.URL.Path)
+		if m == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeOCIError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "only GET and HEAD are supported")
+			return
+		}
+
+		if r.accessController != nil {
+			if _, err := r.accessController.Authorized(req); err != nil {
+				if challenge, ok := err.(auth.Challenge); ok {
+					challenge.SetHeaders(req, w)
+					writeOCIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+					return
+				}
+				logrus.WithError(err).Error("Failed to authorize request.")
+				writeOCIError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+				return
+			}
+		}
+
+		dgst, err := digest.Parse(m[1])
+		if err != nil {
+			writeOCIError(w, http.StatusBadRequest, "DIGEST_INVALID", fmt.Sprintf("invalid digest: %v", err))
+			return
+		}
+		release, err := containerd.LeaseContent(req.Context(), r.client, dgst)
+		if err != nil {
+			writeOCIError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+		defer release()
+
+		ra, err := r.client.ContentStore().ReaderAt(req.Context(), ocispec.Descriptor{Digest: dgst})
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				writeOCIError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+				return
+			}
+			writeOCIError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+		defer ra.Close()
+
+		if err = r.serveBlob(w, req, dgst, ra); err != nil {
+			writeOCIError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		}
+	})
+}
+
+// serveBlob writes the blob content with the registry blob headers. Range requests are supported to resume
+// interrupted fetches. The content is verified first if verification on read is enabled in which case nothing is
+// written if the verification fails.
+func (r *Registry) serveBlob(
+	w http.ResponseWriter, req *http.Request, dgst digest.Digest, ra content.ReaderAt,
+) error {
+	if req.Method != http.MethodHead {
+		if err := r.verifier.VerifyRead(io.NewSectionReader(ra, 0, ra.Size()), dgst); err != nil {
+			logrus.WithError(err).Error("Failed to verify blob digest.")
+			return err
+		}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, dgst))
+	http.ServeContent(w, req, "", time.Time{}, io.NewSectionReader(ra, 0, ra.Size()))
+	return nil
+}
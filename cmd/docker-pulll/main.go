@@ -0,0 +1,85 @@
+// Command docker-pulll is a Docker CLI plugin that pulls an image from the Docker of a remote host over SSH without
+// an external registry. It's the reverse of docker-pussh.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/psviderski/unregistry/internal/sshremote"
+	"github.com/psviderski/unregistry/internal/version"
+	"github.com/spf13/cobra"
+)
+
+// pluginMetadata is the metadata the Docker CLI requests from plugins: https://github.com/docker/cli/pull/1564
+type pluginMetadata struct {
+	SchemaVersion    string
+	Vendor           string
+	Version          string
+	ShortDescription string
+}
+
+const shortDescription = "Pull image from remote Docker daemon via SSH without external registry"
+
+func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "docker-cli-plugin-metadata" {
+		_ = json.NewEncoder(os.Stdout).Encode(pluginMetadata{
+			SchemaVersion:    "0.1.0",
+			Vendor:           "https://github.com/psviderski",
+			Version:          version.Version,
+			ShortDescription: shortDescription,
+		})
+		return
+	}
+	// The Docker CLI passes the plugin command name as the first argument.
+	if len(args) > 0 && args[0] == "pulll" {
+		args = args[1:]
+	}
+
+	var opts pullOptions
+	cmd := &cobra.Command{
+		Use:   "docker pulll [OPTIONS] [USER@]HOST[:PORT] IMAGE[:TAG]",
+		Short: shortDescription,
+		Long: `Pull an image from the Docker of a remote host over SSH without an external registry, e.g. to grab
+an image built on a server. A temporary unregistry container is started on the remote host using Docker, its port is
+forwarded over SSH, and the local Docker pulls the image through the forwarded port. Only the layers the local Docker
+doesn't have yet are transferred.`,
+		Example: `  docker pulll user@host myimage:latest
+  docker pulll --platform linux/arm64 host myimage
+  docker pulll user@host:2222 myimage:1.2.3 -i ~/.ssh/id_ed25519
+  docker pulll user@[2001:db8::1]:2222 myimage:1.2.3`,
+		Version:               version.String(),
+		Args:                  cobra.ExactArgs(2),
+		DisableFlagsInUseLine: true,
+		SilenceUsage:          true,
+		SilenceErrors:         true,
+		PreRun: func(cmd *cobra.Command, args []string) {
+			if value := os.Getenv("UNREGISTRY_IMAGE"); value != "" && !cmd.Flags().Changed("unregistry-image") {
+				opts.unregistryImage = value
+			}
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return pull(cmd.Context(), args[0], args[1], opts)
+		},
+	}
+	cmd.SetArgs(args)
+	cmd.SetVersionTemplate("docker-pulll version {{.Version}}\n")
+
+	cmd.Flags().BoolP("help", "h", false, "Show this help message")
+	cmd.Flags().BoolVar(&opts.noHostKeyCheck, "no-host-key-check", false,
+		"Skip SSH host key checking (use with caution)")
+	cmd.Flags().StringVar(&opts.platform, "platform", "",
+		"Pull a specific platform of a multi-platform image, e.g. linux/amd64 (default is the local Docker platform)")
+	cmd.Flags().StringVarP(&opts.sshKey, "ssh-key", "i", "",
+		"Path to SSH private key for remote login (if not already added to SSH agent)")
+	cmd.Flags().StringVar(&opts.unregistryImage, "unregistry-image", sshremote.DefaultUnregistryImage,
+		"Unregistry image to run on the remote host")
+	cmd.Flags().BoolP("version", "v", false, "Show the version")
+
+	if err := cmd.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/psviderski/unregistry/internal/dockercli"
+	"github.com/psviderski/unregistry/internal/sshremote"
+)
+
+// pullAttempts is the number of times the local Docker tries to pull the image, e.g. if the forwarded connection
+// drops.
+const pullAttempts = 3
+
+// pullOptions are the options of the pulll command.
+type pullOptions struct {
+	sshKey          string
+	noHostKeyCheck  bool
+	platform        string
+	unregistryImage string
+}
+
+// pull pulls the image from the Docker of the remote host with the [USER@]HOST[:PORT] address into the local Docker
+// through a temporary unregistry on the remote host.
+func pull(ctx context.Context, address, image string, opts pullOptions) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return fmt.Errorf("invalid image reference '%s': %w", image, err)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return fmt.Errorf("pulling image '%s' by digest is not supported, specify a tag instead", image)
+	}
+	// The registry host of the image becomes a part of the repository name in unregistry which can't contain a port.
+	if strings.Contains(reference.Domain(named), ":") {
+		return fmt.Errorf("pulling image '%s' with a registry port is not supported", image)
+	}
+	named = reference.TagNameOnly(named)
+	image = reference.FamiliarString(named)
+
+	info("Connecting to %s...", address)
+	sshOpts := sshremote.Options{Key: opts.sshKey, HostKeyChecking: os.Getenv("SSH_STRICT_HOST_KEY_CHECKING")}
+	if opts.noHostKeyCheck {
+		sshOpts.HostKeyChecking = sshremote.HostKeyCheckingNo
+	}
+	conn, err := sshremote.Dial(ctx, address, sshOpts)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	dockerCmd, err := sshremote.RemoteDocker(ctx, conn, "")
+	if err != nil {
+		return err
+	}
+	if _, err = conn.Run(ctx, dockerCmd+" image inspect "+sshremote.ShellQuote(image)); err != nil {
+		return fmt.Errorf("image '%s' not found on remote host: %w", image, err)
+	}
+	// Images in the classic image store aren't in containerd so unregistry has to export them through Docker.
+	driverStatus, err := conn.Run(ctx, dockerCmd+" info -f '{{ .DriverStatus }}'")
+	if err != nil {
+		return fmt.Errorf("check image store of remote Docker: %w", err)
+	}
+	dockerBackend := !strings.Contains(driverStatus, "containerd.snapshotter")
+
+	info("Starting unregistry container on remote host...")
+	container, port, err := sshremote.StartUnregistry(ctx, conn, sshremote.UnregistryOptions{
+		Image:         opts.unregistryImage,
+		NamePrefix:    "unregistry-pulll",
+		Docker:        dockerCmd,
+		DockerBackend: dockerBackend,
+		PullOutput:    os.Stderr,
+	})
+	if err != nil {
+		return err
+	}
+	defer container.Remove(context.WithoutCancel(ctx), conn)
+
+	localPort, err := conn.Forward(ctx, "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		return err
+	}
+	if err = sshremote.WaitRegistry(ctx, "127.0.0.1:"+strconv.Itoa(localPort)); err != nil {
+		return err
+	}
+	success("Forwarded localhost:%d to unregistry over SSH connection.", localPort)
+
+	pullPort := localPort
+	if dockercli.VMProxyNeeded(ctx) {
+		info("Detected virtualised Docker locally, running proxy to localhost:%d...", localPort)
+		proxy := fmt.Sprintf("docker-pulll-proxy-%d", os.Getpid())
+		proxyPort, err := dockercli.RunVMProxy(ctx, proxy, localPort)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_, _ = dockercli.Output(context.WithoutCancel(ctx), "rm", "-f", proxy)
+		}()
+		pullPort = proxyPort
+		success("Proxy running: localhost:%d → localhost:%d", pullPort, localPort)
+	}
+
+	registryImage := fmt.Sprintf("localhost:%d/%s", pullPort, image)
+	info("Pulling %s from unregistry...", registryImage)
+	pullArgs := []string{"pull"}
+	if opts.platform != "" {
+		pullArgs = append(pullArgs, "--platform", opts.platform)
+	}
+	pullArgs = append(pullArgs, registryImage)
+	for attempt := 1; ; attempt++ {
+		cmd := exec.CommandContext(ctx, "docker", pullArgs...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err = cmd.Run(); err == nil {
+			break
+		}
+		if attempt == pullAttempts || ctx.Err() != nil {
+			return fmt.Errorf("pull image after %d attempts: %w", attempt, err)
+		}
+		warning("Pull attempt %d failed, retrying in 3 seconds...", attempt)
+		time.Sleep(3 * time.Second)
+	}
+	// Remove the temporary tag even if retagging fails so that it doesn't clutter the local images.
+	defer func() {
+		_, _ = dockercli.Output(context.WithoutCancel(ctx), "rmi", registryImage)
+	}()
+
+	if _, err = dockercli.Output(ctx, "tag", registryImage, image); err != nil {
+		return fmt.Errorf("retag image %s → %s: %w", registryImage, image, err)
+	}
+	success("Successfully pulled %s from %s", image, address)
+	return nil
+}
+
+// info prints a progress step.
+func info(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, " • "+format+"\n", args...)
+}
+
+// success prints a completed step.
+func success(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, " ✓ "+format+"\n", args...)
+}
+
+// warning prints a problem that doesn't stop the pull.
+func warning(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, " ! "+format+"\n", args...)
+}
@@ -0,0 +1,129 @@
+// Command docker-pussh is a Docker CLI plugin that pushes an image to the Docker of a remote host over SSH without
+// an external registry.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/psviderski/unregistry/internal/sshremote"
+	"github.com/psviderski/unregistry/internal/version"
+	"github.com/spf13/cobra"
+)
+
+// pluginMetadata is the metadata the Docker CLI requests from plugins: https://github.com/docker/cli/pull/1564
+type pluginMetadata struct {
+	SchemaVersion    string
+	Vendor           string
+	Version          string
+	ShortDescription string
+}
+
+const shortDescription = "Upload image to remote Docker daemon via SSH without external registry"
+
+func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "docker-cli-plugin-metadata" {
+		_ = json.NewEncoder(os.Stdout).Encode(pluginMetadata{
+			SchemaVersion:    "0.1.0",
+			Vendor:           "https://github.com/psviderski",
+			Version:          version.Version,
+			ShortDescription: shortDescription,
+		})
+		return
+	}
+	// The Docker CLI passes the plugin command name as the first argument.
+	if len(args) > 0 && args[0] == "pussh" {
+		args = args[1:]
+	}
+
+	opts := pushOptions{
+		remoteDocker:     os.Getenv("REMOTE_DOCKER_PATH"),
+		containerdSocket: os.Getenv("REMOTE_CONTAINERD_SOCKET"),
+		hostKeyChecking:  os.Getenv("SSH_STRICT_HOST_KEY_CHECKING"),
+		unregistryImage:  sshremote.DefaultUnregistryImage,
+	}
+	if image := os.Getenv("UNREGISTRY_IMAGE"); image != "" {
+		opts.unregistryImage = image
+	}
+	var noHostKeyCheck bool
+	cmd := &cobra.Command{
+		Use:   "docker pussh [OPTIONS] IMAGE[:TAG] [USER@]HOST[:PORT]",
+		Short: shortDescription,
+		Long: fmt.Sprintf(`Upload a Docker image to a remote Docker daemon via SSH without an external registry.
+
+Environment variables:
+  REMOTE_DOCKER_PATH            Path to docker binary on remote host (default: auto-detected)
+  REMOTE_CONTAINERD_SOCKET      Path to containerd socket on remote host (default: auto-detected)
+  SSH_STRICT_HOST_KEY_CHECKING  SSH host key checking mode: yes, no, ask, or accept-new (default: ask)
+  UNREGISTRY_IMAGE              Unregistry image to use on remote host (default: %s)`,
+			sshremote.DefaultUnregistryImage),
+		Example: `  docker pussh myimage:latest user@host
+  docker pussh --platform linux/amd64 myimage host
+  docker pussh myimage:1.2.3 user@host:2222 -i ~/.ssh/id_ed25519
+  docker pussh myimage:1.2.3 user@[2001:db8::1]:2222
+
+  # Set custom docker binary path and containerd socket on remote host:
+  REMOTE_DOCKER_PATH=/usr/local/bin/docker REMOTE_CONTAINERD_SOCKET=/var/run/docker/containerd/containerd.sock \
+    docker pussh myimage:1.2.3 user@host`,
+		Version:               version.String(),
+		Args:                  cobra.ExactArgs(2),
+		DisableFlagsInUseLine: true,
+		SilenceUsage:          true,
+		SilenceErrors:         true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if opts.platform != "" && opts.allPlatforms {
+				return errors.New("--platform and --all-platforms options are mutually exclusive")
+			}
+			if opts.maxConcurrentUploads < 0 {
+				return errors.New("--max-concurrent-uploads must be a positive number")
+			}
+			if opts.sshKey != "" {
+				if _, err := os.Stat(opts.sshKey); err != nil {
+					return fmt.Errorf("SSH key file not found: %s", opts.sshKey)
+				}
+			}
+			if noHostKeyCheck {
+				opts.hostKeyChecking = sshremote.HostKeyCheckingNo
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return push(cmd.Context(), args[0], args[1], opts)
+		},
+	}
+	cmd.SetArgs(args)
+	cmd.SetVersionTemplate("docker-pussh version {{.Version}}\nunregistry image: " + opts.unregistryImage + "\n")
+
+	cmd.Flags().BoolVar(&opts.allPlatforms, "all-platforms", false,
+		"Push all platforms of a multi-platform image instead of only the remote one")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false,
+		"Show what would be transferred to the remote host without uploading anything")
+	cmd.Flags().BoolP("help", "h", false, "Show this help message")
+	cmd.Flags().IntVar(&opts.maxConcurrentUploads, "max-concurrent-uploads", 0,
+		"Maximum number of layers uploaded at the same time. Use 1 on slow or unreliable links to upload layers "+
+			"one by one and avoid timeouts on large layers")
+	cmd.Flags().BoolVar(&noHostKeyCheck, "no-host-key-check", false,
+		"Skip SSH host key checking (use with caution)")
+	cmd.Flags().StringVar(&opts.platform, "platform", "",
+		"Push a specific platform for a multi-platform image, e.g. linux/amd64. Local Docker has to use containerd "+
+			"image store to support multi-platform images. Defaults to the platform of the remote Docker if the image "+
+			"provides it")
+	cmd.Flags().StringVarP(&opts.sshKey, "ssh-key", "i", "",
+		"Path to SSH private key for remote login (if not already added to SSH agent)")
+	cmd.Flags().BoolP("version", "v", false, "Show the version")
+
+	// Cancel the push on interrupt so that the remote container and temporary tags are cleaned up.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := cmd.ExecuteContext(ctx); err != nil {
+		printError(err)
+		stop()
+		os.Exit(1)
+	}
+}
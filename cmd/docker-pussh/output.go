@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ANSI escape codes to format the output on a terminal.
+const (
+	red    = "\033[0;31m"
+	green  = "\033[0;32m"
+	yellow = "\033[0;33m"
+	blue   = "\033[0;34m"
+	bolded = "\033[1m"
+	reset  = "\033[0m"
+)
+
+// colored is whether stdout is a terminal that the output can be formatted for.
+var colored = isTerminal(os.Stdout)
+
+// info prints a progress step.
+func info(format string, args ...any) {
+	fmt.Printf(" %s %s\n", color(blue, "•"), fmt.Sprintf(format, args...))
+}
+
+// success prints a completed step.
+func success(format string, args ...any) {
+	fmt.Printf(" %s %s\n", color(green, "✓"), fmt.Sprintf(format, args...))
+}
+
+// warning prints a problem that doesn't stop the push.
+func warning(format string, args ...any) {
+	fmt.Printf(" %s %s\n", color(yellow, "!"), fmt.Sprintf(format, args...))
+}
+
+// printError prints the error that stopped the push to stderr.
+func printError(err error) {
+	prefix := "ERROR:"
+	if isTerminal(os.Stderr) {
+		prefix = red + prefix + reset
+	}
+	fmt.Fprintln(os.Stderr, prefix, err)
+}
+
+// bold formats the string in bold on a terminal.
+func bold(s string) string {
+	return color(bolded, s)
+}
+
+func color(code, s string) string {
+	if !colored {
+		return s
+	}
+	return code + s + reset
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/psviderski/unregistry/internal/dockercli"
+	"github.com/psviderski/unregistry/internal/sshremote"
+)
+
+// adminSock is the path to the admin API socket inside the unregistry container.
+const adminSock = "/run/unregistry-admin.sock"
+
+// pushAttempts is the number of times the local Docker tries to push the image, e.g. if the forwarded connection
+// drops.
+const pushAttempts = 3
+
+// pushOptions are the options of the pussh command.
+type pushOptions struct {
+	sshKey               string
+	hostKeyChecking      string
+	platform             string
+	allPlatforms         bool
+	dryRun               bool
+	maxConcurrentUploads int
+	remoteDocker         string
+	containerdSocket     string
+	unregistryImage      string
+}
+
+// registryPortRegexp matches an image with a port in its registry host, e.g. "localhost:5000/myimage".
+var registryPortRegexp = regexp.MustCompile(`^([^/]+):([0-9]+)(/.*)$`)
+
+// platformRegexp matches a platform in the OS/ARCH format, e.g. "linux/amd64".
+var platformRegexp = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9]+$`)
+
+// hintRegexp matches the remediation hint unregistry logs for a failed request.
+var hintRegexp = regexp.MustCompile(`hint="([^"]*)"`)
+
+// push pushes the local image to the Docker of the remote host with the [USER@]HOST[:PORT] address through
+// a temporary unregistry on the remote host.
+func push(ctx context.Context, image, address string, opts pushOptions) (err error) {
+	cleanupCtx := context.WithoutCancel(ctx)
+
+	info("Connecting to %s...", address)
+	conn, err := sshremote.Dial(ctx, address, sshremote.Options{
+		Key:             opts.sshKey,
+		HostKeyChecking: opts.hostKeyChecking,
+	})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer func() {
+		if err != nil {
+			warning("Cleaning up after error...")
+		}
+	}()
+	dockerCmd, err := sshremote.RemoteDocker(ctx, conn, opts.remoteDocker)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"--admin-sock", adminSock}
+	if opts.dryRun {
+		args = append(args, "--dry-run")
+	}
+	// Docker pushes up to 5 layers concurrently by default which can only be changed in the Docker daemon
+	// configuration, so unregistry makes the excess uploads wait for their turn instead.
+	if opts.maxConcurrentUploads > 0 {
+		args = append(args, "--max-concurrent-uploads", strconv.Itoa(opts.maxConcurrentUploads))
+	}
+	info("Starting unregistry container on remote host...")
+	container, remotePort, err := sshremote.StartUnregistry(ctx, conn, sshremote.UnregistryOptions{
+		Image:            opts.unregistryImage,
+		NamePrefix:       "unregistry-pussh",
+		Docker:           dockerCmd,
+		ContainerdSocket: opts.containerdSocket,
+		Args:             args,
+		PullOutput:       os.Stdout,
+	})
+	if err != nil {
+		return err
+	}
+	containerRunning := true
+	defer func() {
+		if containerRunning {
+			container.Remove(cleanupCtx, conn)
+		}
+	}()
+	success("Unregistry is listening localhost:%d on remote host.", remotePort)
+
+	localPort, err := conn.Forward(ctx, "127.0.0.1:"+strconv.Itoa(remotePort))
+	if err != nil {
+		return err
+	}
+	if err = sshremote.WaitRegistry(ctx, "127.0.0.1:"+strconv.Itoa(localPort)); err != nil {
+		return err
+	}
+	success("Forwarded localhost:%d to unregistry over SSH connection.", localPort)
+
+	pushPort := localPort
+	if dockercli.VMProxyNeeded(ctx) {
+		info("Detected virtualised Docker locally, running proxy to localhost:%d...", localPort)
+		proxy := fmt.Sprintf("docker-pussh-proxy-%d", os.Getpid())
+		if pushPort, err = dockercli.RunVMProxy(ctx, proxy, localPort); err != nil {
+			return err
+		}
+		defer func() {
+			_, _ = dockercli.Output(cleanupCtx, "rm", "-f", proxy)
+		}()
+		success("Proxy running: localhost:%d → localhost:%d", pushPort, localPort)
+	}
+
+	localContainerdStore := dockercli.UsesContainerdStore(ctx)
+	platform := opts.platform
+	// Push only the platform the remote Docker runs on from a local multi-platform image unless the user chose
+	// otherwise. It's only possible if the local Docker uses the containerd image store.
+	if platform == "" && !opts.allPlatforms && localContainerdStore {
+		if p := remotePlatform(ctx, conn, dockerCmd); p != "" {
+			// Older Docker versions don't support --platform for 'image inspect' in which case all platforms are
+			// pushed.
+			if _, err := dockercli.Output(ctx, "image", "inspect", "--platform", p, image); err == nil {
+				platform = p
+				info("Detected remote platform %s, pushing only this platform (use --all-platforms to push all).", p)
+			}
+		}
+	}
+
+	remoteImage := normaliseRegistryPort(image)
+	registryImage := fmt.Sprintf("localhost:%d/%s", pushPort, remoteImage)
+	if _, err = dockercli.Output(ctx, "tag", image, registryImage); err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = dockercli.Output(cleanupCtx, "rmi", registryImage)
+	}()
+
+	info("Pushing %s to unregistry...", registryImage)
+	pushArgs := []string{"push"}
+	if platform != "" {
+		pushArgs = append(pushArgs, "--platform", platform)
+	}
+	pushArgs = append(pushArgs, registryImage)
+	for attempt := 1; ; attempt++ {
+		cmd := exec.CommandContext(ctx, "docker", pushArgs...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err = cmd.Run(); err == nil {
+			break
+		}
+		// Retrying won't help if unregistry recognised the problem, so print how to fix it instead.
+		if hints := unregistryHints(cleanupCtx, conn, container); len(hints) > 0 {
+			for _, hint := range hints {
+				warning("%s", hint)
+			}
+			return errors.New("failed to push image")
+		}
+		if attempt == pushAttempts || ctx.Err() != nil {
+			return fmt.Errorf("failed to push image after %d attempts: %w", attempt, err)
+		}
+		warning("Push attempt %d failed, retrying in 3 seconds...", attempt)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(3 * time.Second):
+		}
+	}
+
+	admin := fmt.Sprintf("%s exec %s unregistry admin --admin-sock %s", container.Docker, container.Name, adminSock)
+	if opts.dryRun {
+		if err = conn.Stream(ctx, admin+" --text /api/dry-run", os.Stdout, os.Stderr); err != nil {
+			return fmt.Errorf("get the dry-run result from unregistry: %w", err)
+		}
+		success("Dry run completed. Nothing was transferred to %s", bold(address))
+		return nil
+	}
+
+	// Print the transfer report of the pushed image for an audit trail of what exactly was deployed to the host.
+	report := admin + " --text " + sshremote.ShellQuote("/api/reports?image="+remoteImage)
+	if err = conn.Stream(ctx, report, os.Stdout, os.Stderr); err != nil {
+		warning("Failed to get the transfer report from unregistry.")
+	}
+
+	retagImage := ""
+	if remoteImage != image {
+		retagImage = remoteImage
+	}
+	remoteContainerdStore := false
+	if driverStatus, err := conn.Run(ctx, dockerCmd+" info -f '{{ .DriverStatus }}'"); err == nil {
+		remoteContainerdStore = strings.Contains(driverStatus, "containerd.snapshotter")
+	}
+	// Pull the image from unregistry if the remote Docker doesn't use the containerd image store unregistry
+	// pushes to.
+	if !remoteContainerdStore {
+		info("Remote Docker doesn't use containerd image store. Pulling image from unregistry...")
+		remoteRegistryImage := fmt.Sprintf("localhost:%d/%s", remotePort, remoteImage)
+		pull := dockerCmd + " pull " + sshremote.ShellQuote(remoteRegistryImage)
+		if err = conn.Stream(ctx, pull, os.Stdout, os.Stderr); err != nil {
+			return fmt.Errorf("pull image from unregistry on remote host: %w", err)
+		}
+		retagImage = remoteRegistryImage
+	}
+
+	// Retag the image to the original name if needed and remove the temporary tag.
+	if retagImage != "" {
+		tag := fmt.Sprintf("%s tag %s %s", dockerCmd, sshremote.ShellQuote(retagImage), sshremote.ShellQuote(image))
+		if _, err = conn.Run(ctx, tag); err != nil {
+			return fmt.Errorf("retag image on remote host %s → %s: %w", retagImage, image, err)
+		}
+		_, _ = conn.Run(ctx, dockerCmd+" rmi "+sshremote.ShellQuote(retagImage))
+		success("Retagged image on remote host %s → %s", retagImage, image)
+	}
+
+	// Explain why image IDs differ between hosts when local and remote Docker use different image stores.
+	if localContainerdStore != remoteContainerdStore {
+		localStore, remoteStore := imageStoreName(localContainerdStore), imageStoreName(remoteContainerdStore)
+		localID, _ := dockercli.Output(ctx, "image", "inspect", "-f", "{{ .Id }}", image)
+		remoteID, _ := conn.Run(ctx, dockerCmd+" image inspect -f '{{ .Id }}' "+sshremote.ShellQuote(image))
+		warning("Local Docker uses %s image store while remote Docker uses %s image store.", localStore, remoteStore)
+		warning("Image IDs differ between the hosts (local: %s, remote: %s) but the image content is the same.",
+			idOrUnknown(localID), idOrUnknown(remoteID))
+		warning("The containerd image store identifies images by the index/manifest digest, " +
+			"the classic one by the config digest.")
+	}
+
+	info("Stopping unregistry on remote host...")
+	// Ask unregistry to shut down gracefully. The container is removed automatically once it exits.
+	if _, err := conn.Run(ctx, admin+" -X POST /api/shutdown"); err == nil {
+		containerRunning = false
+	}
+
+	success("Successfully pushed %s to %s", bold(image), bold(address))
+	return nil
+}
+
+// remotePlatform returns the platform of the remote Docker, e.g. "linux/amd64", or an empty string if it can't be
+// determined.
+func remotePlatform(ctx context.Context, conn *sshremote.Conn, dockerCmd string) string {
+	output, err := conn.Run(ctx, dockerCmd+" version -f '{{ .Server.Os }}/{{ .Server.Arch }}'")
+	if err != nil {
+		return ""
+	}
+	p := strings.TrimSpace(output)
+	if !platformRegexp.MatchString(p) {
+		return ""
+	}
+	return p
+}
+
+// normaliseRegistryPort replaces the colon in the registry host of the image with a dash to make it a valid
+// repository name component, e.g. "localhost:5000/myimage" becomes "localhost-5000/myimage".
+func normaliseRegistryPort(image string) string {
+	return registryPortRegexp.ReplaceAllString(image, "$1-$2$3")
+}
+
+// unregistryHints returns the remediation hints for known problems that unregistry logged while handling the push
+// requests.
+func unregistryHints(ctx context.Context, conn *sshremote.Conn, container sshremote.Container) []string {
+	logs, _ := conn.Run(ctx, container.Docker+" logs "+container.Name)
+	var hints []string
+	for _, m := range hintRegexp.FindAllStringSubmatch(logs, -1) {
+		if !slices.Contains(hints, m[1]) {
+			hints = append(hints, m[1])
+		}
+	}
+	slices.Sort(hints)
+	return hints
+}
+
+func imageStoreName(containerd bool) string {
+	if containerd {
+		return "containerd"
+	}
+	return "classic"
+}
+
+func idOrUnknown(id string) string {
+	if id = strings.TrimSpace(id); id == "" || strings.Contains(id, " ") {
+		return "unknown"
+	}
+	return id
+}
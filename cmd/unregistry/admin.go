@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// newAdminCommand creates a command that sends a request to the admin API of a running unregistry over its unix
+// socket and prints the response body. It's useful inside the unregistry container which has no curl.
+func newAdminCommand() *cobra.Command {
+	var (
+		sock   string
+		method string
+		data   string
+		text   bool
+	)
+	cmd := &cobra.Command{
+		Use:   "admin PATH",
+		Short: "Send a request to the admin API of a running unregistry",
+		Example: `  unregistry admin --admin-sock /run/unregistry/admin.sock /api/uploads
+  unregistry admin --admin-sock /run/unregistry/admin.sock --text /api/dry-run
+  unregistry admin --admin-sock /run/unregistry/admin.sock -X POST -d '{"images": ["myapp:1.0"]}' /api/exists`,
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return bindEnvToFlags(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return adminRequest(cmd.Context(), sock, method, args[0], data, text, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVar(&sock, "admin-sock", "",
+		"Path to unix socket the admin API is served on")
+	cmd.Flags().StringVarP(&method, "method", "X", http.MethodGet,
+		"HTTP method of the request")
+	cmd.Flags().StringVarP(&data, "data", "d", "",
+		"JSON body of the request")
+	cmd.Flags().BoolVar(&text, "text", false,
+		"Request a human-readable text response instead of JSON if the endpoint supports it")
+
+	return cmd
+}
+
+// adminRequest sends a request with the optional JSON body to the admin API served on the unix socket and writes
+// the response body to out.
+func adminRequest(ctx context.Context, sock, method, path, data string, text bool, out io.Writer) error {
+	if sock == "" {
+		return fmt.Errorf("admin API socket path is required")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	httpClient := adminHTTPClient(sock)
+
+	// The host is ignored as the connection is always made to the unix socket.
+	var body io.Reader
+	if data != "" {
+		body = strings.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://unregistry/"+strings.TrimPrefix(path, "/"), body)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	if data != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	accept := "application/json"
+	if text {
+		accept = "text/plain"
+	}
+	req.Header.Set("Accept", accept)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("send request to admin API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("admin API returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if _, err = io.Copy(out, resp.Body); err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+
+	return nil
+}
+
+// adminHTTPClient returns an HTTP client that sends requests to the admin API served on the unix socket. The host in
+// request URLs is ignored.
+func adminHTTPClient(sock string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sock)
+			},
+		},
+		Timeout: 30 * time.Second,
+	}
+}
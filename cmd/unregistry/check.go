@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/spf13/cobra"
+)
+
+// checkOptions are the options of the check command.
+type checkOptions struct {
+	sock      string
+	namespace string
+}
+
+// newCheckCommand creates a command that verifies the registry can work with the containerd namespace.
+func newCheckCommand() *cobra.Command {
+	var opts checkOptions
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Verify that containerd is reachable and its content store is writable",
+		Long: `Verify that unregistry can work with the containerd namespace: connect to containerd, create a lease,
+write a tiny test blob, read it back, and delete both. The command exits with a non-zero status and an
+error naming the failed step if anything fails, so it can be used in init containers and provisioning
+scripts before starting the registry.`,
+		Example: `  unregistry check
+  unregistry check --sock /run/k3s/containerd/containerd.sock --namespace k8s.io`,
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return bindEnvToFlags(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return check(cmd.Context(), opts, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.sock, "sock", "s", "/run/containerd/containerd.sock",
+		"Path to containerd socket file")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "moby",
+		"Containerd namespace to check")
+
+	return cmd
+}
+
+// check runs the containerd self-test and prints the passed steps to out.
+func check(ctx context.Context, opts checkOptions, out io.Writer) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cli, err := containerd.NewClient(opts.sock, opts.namespace)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	err = containerd.Check(ctx, cli, opts.namespace, func(step containerd.CheckStep) {
+		if step.Detail != "" {
+			_, _ = fmt.Fprintf(out, "OK  %s: %s\n", step.Name, step.Detail)
+		} else {
+			_, _ = fmt.Fprintf(out, "OK  %s\n", step.Name)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("containerd at '%s': %w", opts.sock, err)
+	}
+	_, _ = fmt.Fprintln(out, "All checks passed.")
+	return nil
+}
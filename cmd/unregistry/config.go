@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/psviderski/unregistry"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// newConfigCommand creates a command with subcommands to validate and print the registry configuration resolved from
+// the flags, environment variables, and configuration file the same way the registry server resolves it.
+func newConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Validate or print the effective registry configuration",
+		Args:  cobra.NoArgs,
+	}
+	cmd.AddCommand(newConfigValidateCommand())
+	cmd.AddCommand(newConfigPrintCommand())
+	return cmd
+}
+
+// newConfigValidateCommand creates a command that checks the registry configuration without starting the registry.
+func newConfigValidateCommand() *cobra.Command {
+	var (
+		cfg        unregistry.Config
+		configPath string
+	)
+	cmd := &cobra.Command{
+		Use:   "validate [flags]",
+		Short: "Check the registry configuration for invalid values and combinations of options",
+		Long: `Resolve the registry configuration from the given flags, UNREGISTRY_* environment variables, and
+the configuration file like the registry server does, and check it for invalid values and combinations
+of options, e.g. a TLS certificate without a key. It exits with a non-zero status listing all problems
+found. Neither containerd nor the files referenced by the configuration are accessed.`,
+		Example: `  unregistry config validate --config /etc/unregistry/config.yaml
+  UNREGISTRY_TLS_CERT=/certs/tls.crt unregistry config validate`,
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return loadServerConfig(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			_, err := fmt.Fprintln(os.Stdout, "Configuration is valid.")
+			return err
+		},
+	}
+	addServerFlags(cmd.Flags(), &cfg, &configPath)
+
+	return cmd
+}
+
+// newConfigPrintCommand creates a command that prints the effective registry configuration.
+func newConfigPrintCommand() *cobra.Command {
+	var (
+		cfg        unregistry.Config
+		configPath string
+	)
+	cmd := &cobra.Command{
+		Use:   "print [flags]",
+		Short: "Print the effective registry configuration with defaults resolved",
+		Long: `Resolve the registry configuration from the given flags, UNREGISTRY_* environment variables, and
+the configuration file like the registry server does, validate it, and print all options including
+the defaults in the configuration file format. Credentials embedded in URLs are redacted.`,
+		Example: `  unregistry config print --config /etc/unregistry/config.yaml`,
+		Args:    cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return loadServerConfig(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			return printConfig(cmd.Flags(), os.Stdout)
+		},
+	}
+	addServerFlags(cmd.Flags(), &cfg, &configPath)
+
+	return cmd
+}
+
+// printConfig prints the values of the registry server flags as a YAML configuration file that can be loaded
+// with --config. The values of the options that can hold secrets are redacted.
+func printConfig(flags *pflag.FlagSet, out io.Writer) error {
+	values := make(map[string]any)
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Name == configFlag || f.Name == "help" || err != nil {
+			return
+		}
+		values[f.Name], err = yamlFlagValue(f)
+	})
+	if err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	if err = enc.Encode(values); err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+	return enc.Close()
+}
+
+// yamlFlagValue returns the value of the flag typed as it should appear in a YAML configuration file.
+func yamlFlagValue(f *pflag.Flag) (any, error) {
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		items := sv.GetSlice()
+		for i := range items {
+			items[i] = unregistry.RedactURL(items[i])
+		}
+		return items, nil
+	}
+
+	value := f.Value.String()
+	switch f.Value.Type() {
+	case "bool":
+		return strconv.ParseBool(value)
+	case "int":
+		return strconv.Atoi(value)
+	case "float64":
+		return strconv.ParseFloat(value, 64)
+	default:
+		return unregistry.RedactURL(value), nil
+	}
+}
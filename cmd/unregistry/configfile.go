@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// configFlag is the name of the flag with the path to the YAML configuration file.
+const configFlag = "config"
+
+// loadConfigFile sets the flags of the command from the YAML configuration file at path. The file is a mapping of
+// flag names to values, e.g. "addr: :5000" or "allow-repo: [myorg/*]". Flags set on the command line or through
+// environment variables take precedence over the file, so it must be loaded after binding the environment variables.
+func loadConfigFile(cmd *cobra.Command, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	var values map[string]any
+	if err = yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("parse config file '%s': %w", path, err)
+	}
+
+	for name, value := range values {
+		f := cmd.Flags().Lookup(name)
+		if f == nil || name == configFlag {
+			return fmt.Errorf("invalid config file '%s': unknown option '%s'", path, name)
+		}
+		if f.Changed || value == nil {
+			continue
+		}
+		if err = setFlagValue(f, value); err != nil {
+			return fmt.Errorf("invalid config file '%s': option '%s': %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+// setFlagValue sets the flag to the value decoded from YAML. A list value is only allowed for the flags that can be
+// repeated.
+func setFlagValue(f *pflag.Flag, value any) error {
+	if list, ok := value.([]any); ok {
+		sv, ok := f.Value.(pflag.SliceValue)
+		if !ok {
+			return fmt.Errorf("expected a single value, got a list")
+		}
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			s, err := scalarString(item)
+			if err != nil {
+				return err
+			}
+			items = append(items, s)
+		}
+		return sv.Replace(items)
+	}
+
+	s, err := scalarString(value)
+	if err != nil {
+		return err
+	}
+	// A repeatable flag set to a single value holds only that value rather than splitting it by commas.
+	if sv, ok := f.Value.(pflag.SliceValue); ok {
+		return sv.Replace([]string{s})
+	}
+	return f.Value.Set(s)
+}
+
+// scalarString formats a YAML scalar value as a flag value.
+func scalarString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("expected a string, number, or boolean, got %T", value)
+	}
+}
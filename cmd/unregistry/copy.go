@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/psviderski/unregistry/internal/remote"
+	"github.com/spf13/cobra"
+)
+
+// copyOptions are the options of the copy command.
+type copyOptions struct {
+	local    string
+	srcCreds string
+	dstCreds string
+}
+
+// newCopyCommand creates a command that copies an image between two registries, e.g. to move images on or off a host
+// without installing skopeo or crane.
+func newCopyCommand() *cobra.Command {
+	var opts copyOptions
+	cmd := &cobra.Command{
+		Use:   "copy SOURCE DESTINATION",
+		Short: "Copy an image between registries",
+		Long: `Copy the image with all its platforms, attestations, and content from the source registry to
+the destination registry. Content the destination already has is skipped.
+
+An image without a registry host, e.g. myapp:1.0, refers to the local registry at --local. An image with
+a host, e.g. ghcr.io/myorg/myapp:1.0 or docker.io/library/nginx, refers to that registry which is accessed
+over HTTPS unless it's localhost. Prefix the image with http:// to access a registry over plain HTTP, e.g.
+http://host-1:5000/myapp:1.0. If the destination has no tag or digest, the tag of the source is used.`,
+		Example: `  # Copy an image from GHCR to the local unregistry.
+  unregistry copy ghcr.io/myorg/myapp:1.0 myapp:1.0
+
+  # Copy an image from the local unregistry to another host.
+  unregistry copy myapp:1.0 http://host-1:5000/myapp
+
+  # Copy a private image to the local unregistry with authentication enabled.
+  unregistry copy --src-creds user:token --dst-creds admin:secret ghcr.io/myorg/app:1.0 app`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return copyImage(cmd.Context(), args[0], args[1], opts, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.dstCreds, "dst-creds", "",
+		"Credentials for the destination registry in the USERNAME:PASSWORD form")
+	cmd.Flags().StringVar(&opts.local, "local", "localhost:5000",
+		"URL or host of the local registry images without a registry host refer to")
+	cmd.Flags().StringVar(&opts.srcCreds, "src-creds", "",
+		"Credentials for the source registry in the USERNAME:PASSWORD form")
+
+	return cmd
+}
+
+// copyEndpoint is an image in a registry, the source or destination of a copy.
+type copyEndpoint struct {
+	registry *remote.Registry
+	repo     string
+	tag      string
+	digest   digest.Digest
+}
+
+// String returns the image in the registry, e.g. "http://localhost:5000/myapp:1.0".
+func (e copyEndpoint) String() string {
+	s := e.registry.URL + "/" + e.repo
+	if e.tag != "" {
+		s += ":" + e.tag
+	}
+	if e.digest != "" {
+		s += "@" + e.digest.String()
+	}
+	return s
+}
+
+// copyImage copies the source image to the destination and prints the result to out.
+func copyImage(ctx context.Context, source, destination string, opts copyOptions, out io.Writer) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	src, err := parseCopyEndpoint(source, opts.local, opts.srcCreds)
+	if err != nil {
+		return fmt.Errorf("invalid source: %w", err)
+	}
+	dst, err := parseCopyEndpoint(destination, opts.local, opts.dstCreds)
+	if err != nil {
+		return fmt.Errorf("invalid destination: %w", err)
+	}
+
+	var desc ocispec.Descriptor
+	if src.digest != "" {
+		desc, err = src.registry.ResolveDigest(ctx, src.repo, src.digest)
+	} else {
+		if src.tag == "" {
+			src.tag = "latest"
+		}
+		desc, err = src.registry.Resolve(ctx, src.repo, src.tag)
+	}
+	if err != nil {
+		return err
+	}
+	switch {
+	case dst.digest != "" && dst.digest != desc.Digest:
+		return fmt.Errorf("destination digest '%s' doesn't match source image digest '%s'", dst.digest, desc.Digest)
+	case dst.tag == "" && dst.digest == "":
+		dst.tag = src.tag
+	}
+	dst.digest = ""
+
+	if err = remote.CopyImage(ctx, src.registry, src.repo, desc, dst.registry, dst.repo, dst.tag); err != nil {
+		return fmt.Errorf("copy image '%s' to '%s': %w", src, dst, err)
+	}
+	dst.digest = desc.Digest
+	_, _ = fmt.Fprintf(out, "Copied %s to %s.\n", src, dst)
+	return nil
+}
+
+// parseCopyEndpoint parses the image with an optional registry host and URL scheme, e.g. "myapp:1.0",
+// "ghcr.io/myorg/myapp:1.0", or "http://host-1:5000/myapp", into the registry and repository. Images without
+// a registry host refer to the local registry.
+func parseCopyEndpoint(image, local, creds string) (copyEndpoint, error) {
+	var opts []remote.RegistryOpt
+	if creds != "" {
+		username, password, ok := strings.Cut(creds, ":")
+		if !ok || username == "" {
+			return copyEndpoint{}, fmt.Errorf("invalid credentials: expected USERNAME:PASSWORD")
+		}
+		opts = append(opts, remote.WithCredentials(username, password))
+	}
+
+	scheme, name, hasScheme := strings.Cut(image, "://")
+	if !hasScheme {
+		scheme, name = "", image
+	}
+	registryURL, domain := local, "localhost"
+	// The first path component is a registry host the way Docker tells them apart from repository names.
+	if host, path, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		registryURL, domain, name = host, host, path
+		if hasScheme {
+			registryURL = scheme + "://" + host
+		}
+	} else if hasScheme {
+		return copyEndpoint{}, fmt.Errorf("image '%s' with a URL scheme must include the registry host", image)
+	}
+
+	// Parse with the domain to get the repository path the registry expects, e.g. "library/nginx" on Docker Hub.
+	named, err := reference.ParseNormalizedNamed(domain + "/" + name)
+	if err != nil {
+		return copyEndpoint{}, fmt.Errorf("invalid image reference '%s': %w", image, err)
+	}
+	registry, err := remote.NewRegistry(registryURL, opts...)
+	if err != nil {
+		return copyEndpoint{}, err
+	}
+
+	e := copyEndpoint{registry: registry, repo: reference.Path(named)}
+	if tagged, ok := named.(reference.Tagged); ok {
+		e.tag = tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		e.digest = digested.Digest()
+	}
+	return e, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containerd/platforms"
+	"github.com/distribution/reference"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/spf13/cobra"
+)
+
+// exportOptions are the options of the export command.
+type exportOptions struct {
+	sock      string
+	namespace string
+	output    string
+	platform  string
+}
+
+// newExportCommand creates a command that writes an image from the containerd image store as an OCI tarball.
+func newExportCommand() *cobra.Command {
+	var opts exportOptions
+	cmd := &cobra.Command{
+		Use:   "export IMAGE",
+		Short: "Export an image from the containerd namespace as an OCI tarball",
+		Long: `Export the image from the containerd namespace the registry serves as a tarball in the OCI image
+layout assembled directly from the containerd content store. The tarball is an exact copy of
+the image including all its platforms present on the host unless --platform is set. It talks
+to containerd directly so it works without docker, nerdctl, or a running unregistry.`,
+		Example: `  unregistry export myapp:1.0 -o myapp.tar
+  ssh server unregistry export myapp:1.0 > myapp.tar
+  unregistry export --platform linux/arm64 myapp:1.0 | docker load`,
+		Args: cobra.ExactArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return bindEnvToFlags(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return exportImage(cmd.Context(), args[0], opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.sock, "sock", "s", "/run/containerd/containerd.sock",
+		"Path to containerd socket file")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "moby",
+		"Containerd namespace to export the image from")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "",
+		"Write the tarball to a file instead of stdout")
+	cmd.Flags().StringVar(&opts.platform, "platform", "",
+		"Export only the platform of a multi-platform image, e.g. linux/amd64")
+
+	return cmd
+}
+
+// exportImage writes the image as an OCI tarball to the output file or stdout.
+func exportImage(ctx context.Context, image string, opts exportOptions) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return fmt.Errorf("invalid image reference '%s': %w", image, err)
+	}
+	ref := named
+	if _, ok := named.(reference.Digested); !ok {
+		ref = reference.TagNameOnly(named)
+	}
+	var platform platforms.MatchComparer
+	if opts.platform != "" {
+		p, err := platforms.Parse(opts.platform)
+		if err != nil {
+			return fmt.Errorf("invalid platform '%s': %w", opts.platform, err)
+		}
+		platform = platforms.Only(p)
+	}
+
+	var out io.Writer = os.Stdout
+	if opts.output == "" {
+		if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			return errors.New("refusing to write the tarball to a terminal, redirect stdout or set --output")
+		}
+	} else {
+		f, err := os.Create(opts.output)
+		if err != nil {
+			return fmt.Errorf("create output file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	cli, err := containerd.NewClient(opts.sock, opts.namespace)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	if err = containerd.ExportImage(ctx, cli, ref, platform, out); err != nil {
+		if opts.output != "" {
+			_ = os.Remove(opts.output)
+		}
+		return err
+	}
+	if f, ok := out.(*os.File); ok && f != os.Stdout {
+		if err = f.Close(); err != nil {
+			return fmt.Errorf("close output file: %w", err)
+		}
+	}
+	return nil
+}
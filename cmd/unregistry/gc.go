@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/psviderski/unregistry/internal/transfer"
+	"github.com/spf13/cobra"
+)
+
+// gcOptions are the options of the gc command.
+type gcOptions struct {
+	sock      string
+	namespace string
+	minAge    time.Duration
+	delete    bool
+	json      bool
+}
+
+// newGCCommand creates a command that finds and deletes the containerd leases, ingests, and content left behind by
+// failed or interrupted pushes.
+func newGCCommand() *cobra.Command {
+	var opts gcOptions
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Find and delete content left behind by failed pushes",
+		Long: `Find the garbage in the containerd namespace left behind by failed or interrupted pushes: expired
+unregistry leases, upload ingests that haven't received data for --min-age, and content not referenced by
+any image or live lease. The garbage is only listed unless --delete is set. The content of pushes in
+progress is younger than --min-age and left alone.`,
+		Example: `  unregistry gc
+  unregistry gc --delete --min-age 24h`,
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return bindEnvToFlags(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return collectGarbage(cmd.Context(), opts, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.sock, "sock", "s", "/run/containerd/containerd.sock",
+		"Path to containerd socket file")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "moby",
+		"Containerd namespace to collect garbage in")
+	cmd.Flags().DurationVar(&opts.minAge, "min-age", time.Hour,
+		"Only collect ingests and content not updated for this duration to spare pushes in progress")
+	cmd.Flags().BoolVar(&opts.delete, "delete", false,
+		"Delete the found garbage instead of only listing it")
+	cmd.Flags().BoolVar(&opts.json, "json", false,
+		"Print the result as JSON")
+
+	return cmd
+}
+
+// collectGarbage finds and optionally deletes the garbage in the containerd namespace and prints the result to out.
+func collectGarbage(ctx context.Context, opts gcOptions, out io.Writer) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if opts.minAge < 0 {
+		return fmt.Errorf("invalid minimum age: %s", opts.minAge)
+	}
+	cli, err := containerd.NewClient(opts.sock, opts.namespace)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	report, err := containerd.CollectGarbage(ctx, cli, opts.minAge, opts.delete)
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		if report.Garbage == nil {
+			report.Garbage = []containerd.Garbage{}
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	if len(report.Garbage) > 0 {
+		w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+		_, _ = fmt.Fprintln(w, "KIND\tID\tSIZE\tUPDATED")
+		for _, g := range report.Garbage {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\n", g.Kind, g.ID, transfer.HumanSize(g.Size),
+				time.Since(g.UpdatedAt).Round(time.Second))
+		}
+		if err = w.Flush(); err != nil {
+			return err
+		}
+	}
+	if opts.delete {
+		_, _ = fmt.Fprintf(out, "Deleted %d item(s), reclaimed %s.\n", len(report.Garbage),
+			transfer.HumanSize(report.Reclaimable))
+	} else {
+		_, _ = fmt.Fprintf(out, "Found %d item(s), %s reclaimable. Run with --delete to delete them.\n",
+			len(report.Garbage), transfer.HumanSize(report.Reclaimable))
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/psviderski/unregistry"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// newHealthcheckCommand creates a command that checks the readiness of a running unregistry on the local host. It
+// exits with a non-zero code if the registry or containerd doesn't respond, so it can be used as the Docker
+// HEALTHCHECK of the unregistry container which has no curl. The address and TLS of the registry are resolved from
+// the flags, UNREGISTRY_* environment variables, and configuration file the same way the registry server resolves them.
+func newHealthcheckCommand() *cobra.Command {
+	var (
+		cfg        unregistry.Config
+		configPath string
+		useTLS     bool
+		timeout    time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "healthcheck",
+		Short: "Check the readiness of a running unregistry including its containerd connection",
+		Args:  cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := loadServerConfig(cmd); err != nil {
+				return err
+			}
+			// TLS is enabled in the registry with either of these.
+			if !cmd.Flags().Changed("tls") {
+				useTLS = cfg.TLSCert != "" || len(cfg.ACMEDomains) > 0
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return healthcheck(cmd.Context(), cfg.Addr, useTLS, timeout)
+		},
+	}
+
+	// The registry server flags are accepted so that the configuration file of the registry can be loaded but only
+	// the ones relevant to connecting to the registry are shown.
+	addServerFlags(cmd.Flags(), &cfg, &configPath)
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		f.Hidden = f.Name != "addr" && f.Name != configFlag && f.Name != "tls-cert" && f.Name != "acme-domain"
+	})
+	cmd.Flags().BoolVar(&useTLS, "tls", false,
+		"Connect over HTTPS (enabled if --tls-cert or --acme-domain is set)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Second,
+		"Maximum time to wait for the response")
+
+	return cmd
+}
+
+// healthcheck requests the readiness endpoint of the registry listening on addr on the local host.
+func healthcheck(ctx context.Context, addr string, useTLS bool, timeout time.Duration) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address '%s': %w", addr, err)
+	}
+	// The registry listening on all interfaces is reachable on the loopback interface.
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			// The certificate is issued for the public name of the registry rather than localhost.
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		Timeout: timeout,
+	}
+	url := fmt.Sprintf("%s://%s/readyz", scheme, net.JoinHostPort(host, port))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request registry readiness: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry isn't ready (%s): %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
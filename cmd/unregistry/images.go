@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/containerd/platforms"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/psviderski/unregistry/internal/transfer"
+	"github.com/spf13/cobra"
+)
+
+// imagesOptions are the options of the images command.
+type imagesOptions struct {
+	sock        string
+	namespace   string
+	snapshotter string
+	platform    string
+	json        bool
+	noTrunc     bool
+}
+
+// newImagesCommand creates a command that lists the images in a containerd namespace the way the registry serves them.
+func newImagesCommand() *cobra.Command {
+	var opts imagesOptions
+	cmd := &cobra.Command{
+		Use:   "images",
+		Short: "List images in the containerd namespace",
+		Long: `List the images in the containerd namespace the registry serves with their repository, tag, digest,
+platforms, the size of their content present on the host, and the size of their layers unpacked
+into the snapshotter. It talks to containerd directly so it
+works without docker, nerdctl, or a running unregistry.`,
+		Example: `  unregistry images
+  unregistry images --namespace k8s.io --json`,
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return bindEnvToFlags(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listImages(cmd.Context(), opts, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.sock, "sock", "s", "/run/containerd/containerd.sock",
+		"Path to containerd socket file")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "moby",
+		"Containerd namespace to list images in")
+	cmd.Flags().StringVar(&opts.snapshotter, "snapshotter", "overlayfs",
+		"Containerd snapshotter to report the unpacked image sizes in")
+	cmd.Flags().StringVar(&opts.platform, "default-platform", "",
+		"Platform of multi-platform images to report the unpacked sizes for, e.g. linux/amd64 (host platform if empty)")
+	cmd.Flags().BoolVar(&opts.json, "json", false,
+		"Print the images as JSON including the referrer artifacts such as signatures")
+	cmd.Flags().BoolVar(&opts.noTrunc, "no-trunc", false,
+		"Print full image digests")
+
+	return cmd
+}
+
+// listImages prints the images in the containerd namespace to out as a table or JSON.
+func listImages(ctx context.Context, opts imagesOptions, out io.Writer) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	platform := platforms.Default()
+	if opts.platform != "" {
+		p, err := platforms.Parse(opts.platform)
+		if err != nil {
+			return fmt.Errorf("invalid default platform: %w", err)
+		}
+		platform = platforms.Only(p)
+	}
+	cli, err := containerd.NewClient(opts.sock, opts.namespace)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	summaries, err := containerd.ListImages(ctx, cli, opts.snapshotter, platform)
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summaries)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	_, _ = fmt.Fprintln(w, "REPOSITORY\tTAG\tDIGEST\tPLATFORMS\tSIZE\tUNPACKED")
+	for _, s := range summaries {
+		tag := s.Tag
+		if tag == "" {
+			tag = "<none>"
+		}
+		dgst := s.Digest.String()
+		if !opts.noTrunc {
+			dgst = s.Digest.Encoded()[:min(12, len(s.Digest.Encoded()))]
+		}
+		platforms := strings.Join(s.Platforms, ",")
+		if platforms == "" {
+			platforms = "-"
+		}
+		unpacked := "-"
+		if s.UnpackedSize > 0 {
+			unpacked = transfer.HumanSize(s.UnpackedSize)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Repo, tag, dgst, platforms,
+			transfer.HumanSize(s.Size), unpacked)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/containerd/containerd/v2/core/remotes/docker"
+	"github.com/containerd/platforms"
+	"github.com/distribution/reference"
+	"github.com/psviderski/unregistry/internal/sshremote"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/spf13/cobra"
+)
+
+// importFromOptions are the options of the import-from command.
+type importFromOptions struct {
+	sock       string
+	namespace  string
+	platform   string
+	sshKey     string
+	remoteAddr string
+	image      string
+}
+
+// newImportFromCommand creates a command that copies images from the containerd image store of another host into
+// the local one over SSH without going through a workstation or an external registry.
+func newImportFromCommand() *cobra.Command {
+	var opts importFromOptions
+	cmd := &cobra.Command{
+		Use:   "import-from [USER@]HOST[:PORT] [IMAGE...]",
+		Short: "Copy images from another host over SSH into the local containerd",
+		Long: `Copy images from the containerd image store of another host over SSH into the local containerd image
+store, e.g. to clone the images of one server to another. A temporary unregistry container is started on the remote
+host using Docker unless --remote-addr points to an unregistry already running there. Its port is forwarded over SSH
+and the images are fetched directly into the local containerd. All images on the remote host are imported if no
+images are specified.`,
+		Example: `  unregistry import-from user@server myapp:1.2.3 postgres:16
+  unregistry import-from server:2222 -i ~/.ssh/id_ed25519 --platform linux/arm64 myapp:1.2.3
+  unregistry import-from server --remote-addr 127.0.0.1:5000`,
+		Args: cobra.MinimumNArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return bindEnvToFlags(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return importFrom(cmd.Context(), opts, args[0], args[1:], os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.sock, "sock", "s", "/run/containerd/containerd.sock",
+		"Path to containerd socket file")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "moby",
+		"Containerd namespace to import images to")
+	cmd.Flags().StringVar(&opts.platform, "platform", "",
+		"Platform of multi-platform images to import, e.g. linux/amd64 (default is the local platform)")
+	cmd.Flags().StringVarP(&opts.sshKey, "ssh-key", "i", "",
+		"Path to SSH private key for remote login (if not already added to SSH agent)")
+	cmd.Flags().StringVar(&opts.remoteAddr, "remote-addr", "",
+		"Address of an unregistry already running on the remote host, e.g. 127.0.0.1:5000, instead of starting one")
+	cmd.Flags().StringVar(&opts.image, "unregistry-image", sshremote.DefaultUnregistryImage,
+		"Unregistry image to run on the remote host")
+
+	return cmd
+}
+
+// importFrom copies the images from the remote host into the local containerd image store and prints the result for
+// each image to out.
+func importFrom(ctx context.Context, opts importFromOptions, address string, refs []string, out io.Writer) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	var images []reference.Named
+	for _, ref := range refs {
+		named, err := reference.ParseNormalizedNamed(ref)
+		if err != nil {
+			return fmt.Errorf("invalid image reference '%s': %w", ref, err)
+		}
+		images = append(images, reference.TagNameOnly(named))
+	}
+	platform := platforms.Default()
+	if opts.platform != "" {
+		p, err := platforms.Parse(opts.platform)
+		if err != nil {
+			return fmt.Errorf("invalid platform '%s': %w", opts.platform, err)
+		}
+		platform = platforms.Only(p)
+	}
+
+	cli, err := containerd.NewClient(opts.sock, opts.namespace)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	conn, err := sshremote.Dial(ctx, address, sshremote.Options{Key: opts.sshKey})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	remoteAddr := opts.remoteAddr
+	if remoteAddr == "" {
+		container, port, err := sshremote.StartUnregistry(ctx, conn, sshremote.UnregistryOptions{
+			Image:      opts.image,
+			NamePrefix: "unregistry-import",
+		})
+		if err != nil {
+			return err
+		}
+		defer container.Remove(context.WithoutCancel(ctx), conn)
+		remoteAddr = "127.0.0.1:" + strconv.Itoa(port)
+	}
+
+	localPort, err := conn.Forward(ctx, remoteAddr)
+	if err != nil {
+		return err
+	}
+	registryHost := "127.0.0.1:" + strconv.Itoa(localPort)
+	if err = sshremote.WaitRegistry(ctx, registryHost); err != nil {
+		return err
+	}
+
+	if len(images) == 0 {
+		if images, err = listRemoteImages(ctx, registryHost); err != nil {
+			return err
+		}
+	}
+
+	resolver := docker.NewResolver(docker.ResolverOptions{
+		Hosts: docker.ConfigureDefaultRegistries(docker.WithPlainHTTP(docker.MatchAllHosts)),
+	})
+	failed := 0
+	for _, named := range images {
+		remoteRef := registryHost + "/" + reference.FamiliarString(named)
+		desc, err := containerd.FetchImage(ctx, cli, resolver, remoteRef, named, platform)
+		if err != nil {
+			failed++
+			_, _ = fmt.Fprintf(out, "%-9s %s: %v\n", "failed", reference.FamiliarString(named), err)
+			continue
+		}
+		_, _ = fmt.Fprintf(out, "%-9s %s %s\n", "imported", reference.FamiliarString(named), desc.Digest)
+	}
+	_, _ = fmt.Fprintf(out, "Imported %d of %d image(s) from %s.\n", len(images)-failed, len(images), address)
+
+	if failed > 0 {
+		return fmt.Errorf("failed to import %d image(s)", failed)
+	}
+	return nil
+}
+
+// listRemoteImages returns the tagged images of all repositories in the registry at the host.
+func listRemoteImages(ctx context.Context, host string) ([]reference.Named, error) {
+	var catalog struct {
+		Repositories []string `json:"repositories"`
+	}
+	if err := getJSON(ctx, "http://"+host+"/v2/_catalog", &catalog); err != nil {
+		return nil, fmt.Errorf("list remote repositories: %w", err)
+	}
+
+	var images []reference.Named
+	for _, repo := range catalog.Repositories {
+		var tags struct {
+			Tags []string `json:"tags"`
+		}
+		if err := getJSON(ctx, "http://"+host+"/v2/"+repo+"/tags/list", &tags); err != nil {
+			return nil, fmt.Errorf("list tags of remote repository '%s': %w", repo, err)
+		}
+		for _, tag := range tags.Tags {
+			named, err := reference.ParseNormalizedNamed(repo + ":" + tag)
+			if err != nil {
+				continue
+			}
+			images = append(images, named)
+		}
+	}
+	return images, nil
+}
+
+// getJSON sends a GET request to the URL and decodes the JSON response body into v.
+func getJSON(ctx context.Context, rawURL string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
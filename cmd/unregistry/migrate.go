@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/spf13/cobra"
+)
+
+// newMigrateCommand creates a command that re-registers images from one containerd namespace in another one, e.g.
+// to recover images pushed to the wrong namespace without pushing them again.
+func newMigrateCommand() *cobra.Command {
+	var (
+		sock  string
+		from  string
+		to    string
+		force bool
+	)
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Copy images from one containerd namespace to another",
+		Long: `Copy images from one containerd namespace to another, e.g. images pushed to the wrong namespace when
+Docker runs with userns-remap or images pushed for Docker that Kubernetes should run. The image names are
+re-registered in the target namespace and their content is made available there without copying the data
+if the containerd content sharing policy allows it.`,
+		Example: `  unregistry migrate --from-namespace moby --to-namespace k8s.io`,
+		Args:    cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return bindEnvToFlags(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return migrate(cmd.Context(), sock, from, to, force, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVarP(&sock, "sock", "s", "/run/containerd/containerd.sock",
+		"Path to containerd socket file")
+	cmd.Flags().StringVar(&from, "from-namespace", "",
+		"Containerd namespace to copy images from")
+	cmd.Flags().StringVar(&to, "to-namespace", "",
+		"Containerd namespace to copy images to")
+	cmd.Flags().BoolVar(&force, "force", false,
+		"Overwrite images that exist in the target namespace with a different digest")
+	_ = cmd.MarkFlagRequired("from-namespace")
+	_ = cmd.MarkFlagRequired("to-namespace")
+
+	return cmd
+}
+
+// migrate copies the images between the containerd namespaces and prints the result for each image to out.
+func migrate(ctx context.Context, sock, from, to string, force bool, out io.Writer) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cli, err := containerd.NewClient(sock, to)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	results, err := containerd.MigrateImages(ctx, cli, from, to, force)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range results {
+		line := fmt.Sprintf("%-9s %s", r.Status, r.Image)
+		if r.Error != "" {
+			line += ": " + r.Error
+		}
+		if r.Status == containerd.MigrationFailed || r.Status == containerd.MigrationConflict {
+			failed++
+		}
+		_, _ = fmt.Fprintln(out, line)
+	}
+	_, _ = fmt.Fprintf(out, "Processed %d image(s) from namespace '%s' to '%s'.\n", len(results), from, to)
+
+	if failed > 0 {
+		return fmt.Errorf("failed to migrate %d image(s)", failed)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/psviderski/unregistry/internal/remote"
+	"github.com/spf13/cobra"
+)
+
+// syncOptions are the options of the sync command.
+type syncOptions struct {
+	images  []string
+	include []string
+	exclude []string
+	dryRun  bool
+}
+
+// newSyncCommand creates a command that copies images from one registry to others, e.g. to keep the images of a fleet
+// of unregistry hosts up to date with a central registry.
+func newSyncCommand() *cobra.Command {
+	var opts syncOptions
+	cmd := &cobra.Command{
+		Use:   "sync SOURCE DESTINATION...",
+		Short: "Copy images from one registry to other registries",
+		Long: `Copy the tagged images from the source registry to the destination registries under the same names, e.g.
+to keep the images of a fleet of hosts up to date. Registries are specified by their URL, e.g.
+https://registry.example.com or http://host:5000, or their host, e.g. localhost:5000. Credentials can be
+specified in the URL user info. Images the destinations already have are skipped.
+
+All repositories in the source catalog are synced unless selected with --image. Registries that don't serve
+the catalog, e.g. Docker Hub, require selecting the images.`,
+		Example: `  # Sync all images of a central registry to two hosts.
+  unregistry sync https://registry.example.com http://host-1:5000 http://host-2:5000
+
+  # Show what would be copied for the release tags of the myorg repositories.
+  unregistry sync localhost:5000 http://host-1:5000 --include 'myorg/*:v*' --dry-run`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return syncImages(cmd.Context(), args[0], args[1:], opts, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&opts.images, "image", nil,
+		"Repository, e.g. myapp, or tagged image, e.g. myapp:1.0, to sync (can be repeated, all repositories "+
+			"in the source catalog if empty)")
+	cmd.Flags().StringSliceVar(&opts.include, "include", nil,
+		"Pattern of images to sync, e.g. 'myorg/*' or 'myapp:v1.*' (can be repeated, all if empty)")
+	cmd.Flags().StringSliceVar(&opts.exclude, "exclude", nil,
+		"Pattern of images to skip even if included, e.g. '*:dev' (can be repeated)")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false,
+		"Show the images that would be copied without copying them")
+
+	return cmd
+}
+
+// syncImages copies the selected images from the source registry to the destination registries and prints the result
+// for each image and destination to out.
+func syncImages(ctx context.Context, source string, destinations []string, opts syncOptions, out io.Writer) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	src, err := remote.NewRegistry(source)
+	if err != nil {
+		return err
+	}
+	dsts := make([]*remote.Registry, len(destinations))
+	for i, d := range destinations {
+		if dsts[i], err = remote.NewRegistry(d); err != nil {
+			return err
+		}
+	}
+
+	results, err := remote.Sync(ctx, src, dsts, remote.SyncOptions{
+		Images:  opts.images,
+		Include: opts.include,
+		Exclude: opts.exclude,
+		DryRun:  opts.dryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range results {
+		line := fmt.Sprintf("%-10s %s -> %s", r.Status, r.Image, r.Destination)
+		if r.Error != "" {
+			line += ": " + r.Error
+		}
+		if r.Status == remote.SyncFailed {
+			failed++
+		}
+		_, _ = fmt.Fprintln(out, line)
+	}
+	_, _ = fmt.Fprintf(out, "Processed %d image(s) from %s to %d registry(ies).\n", len(results), src.URL, len(dsts))
+
+	if failed > 0 {
+		return fmt.Errorf("failed to sync %d image(s)", failed)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/psviderski/unregistry/internal/tui"
+	"github.com/spf13/cobra"
+)
+
+// newTUICommand creates a command that runs an interactive terminal UI to browse and manage the images on the host
+// of a running unregistry through its admin API.
+func newTUICommand() *cobra.Command {
+	var sock string
+	cmd := &cobra.Command{
+		Use:   "tui",
+		Short: "Browse and manage images on the host of a running unregistry interactively",
+		Long: `Browse repositories and tags on the host of a running unregistry, inspect their sizes and platforms,
+and delete or retag images in an interactive terminal UI. It connects to the admin API unix socket which can be
+forwarded from a remote host over SSH, e.g.:
+
+  ssh -L /tmp/unregistry.sock:/run/unregistry/admin.sock user@server`,
+		Example: `  unregistry tui --admin-sock /tmp/unregistry.sock`,
+		Args:    cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return bindEnvToFlags(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if sock == "" {
+				return fmt.Errorf("admin API socket path is required")
+			}
+			api := &adminAPI{client: adminHTTPClient(sock)}
+			// Check the connection before switching the terminal to the UI.
+			if _, err := api.Images(cmd.Context()); err != nil {
+				return err
+			}
+			return tui.Run(cmd.Context(), api, os.Stdin, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVar(&sock, "admin-sock", "",
+		"Path to unix socket the admin API is served on")
+
+	return cmd
+}
+
+// adminAPI implements tui.Client using the admin API.
+type adminAPI struct {
+	client *http.Client
+}
+
+func (a *adminAPI) Images(ctx context.Context) ([]containerd.ImageSummary, error) {
+	var resp struct {
+		Images []containerd.ImageSummary `json:"images"`
+	}
+	if err := a.do(ctx, http.MethodGet, "/api/images", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Images, nil
+}
+
+func (a *adminAPI) RemoveImage(ctx context.Context, image string) error {
+	return a.do(ctx, http.MethodDelete, "/api/images/"+image, nil, nil)
+}
+
+func (a *adminAPI) TagImage(ctx context.Context, source, target string) error {
+	body := map[string]string{"source": source, "target": target}
+	return a.do(ctx, http.MethodPost, "/api/tag", body, nil)
+}
+
+// do sends a request with the optional JSON-encoded body to the admin API and decodes the JSON response into out
+// if it's not nil.
+func (a *adminAPI) do(ctx context.Context, method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("marshal request body: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://unregistry"+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send request to admin API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("admin API returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decode response: %w", err)
+		}
+	}
+	return nil
+}
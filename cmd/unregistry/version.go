@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/psviderski/unregistry/internal/version"
+	"github.com/spf13/cobra"
+)
+
+// newVersionCommand creates a command that prints the version and build metadata of unregistry.
+func newVersionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print the version and build information",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			printVersion(os.Stdout)
+		},
+	}
+}
+
+// printVersion prints the version, commit, build date, and the Go toolchain and platform of the binary to out.
+func printVersion(out io.Writer) {
+	unknown := func(s string) string {
+		if s == "" {
+			return "unknown"
+		}
+		return s
+	}
+	_, _ = fmt.Fprintf(out, "Version:    %s\n", version.Version)
+	_, _ = fmt.Fprintf(out, "Commit:     %s\n", unknown(version.Commit))
+	_, _ = fmt.Fprintf(out, "Built:      %s\n", unknown(version.BuildDate))
+	_, _ = fmt.Fprintf(out, "Go version: %s\n", runtime.Version())
+	_, _ = fmt.Fprintf(out, "OS/Arch:    %s/%s\n", runtime.GOOS, runtime.GOARCH)
+}
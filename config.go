@@ -1,15 +1,488 @@
 package unregistry
 
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/pkg/identifiers"
+	"github.com/containerd/platforms"
+	"github.com/psviderski/unregistry/internal/accesslog"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/psviderski/unregistry/pkg/storage"
+	"github.com/sirupsen/logrus"
+)
+
 // Config represents the registry configuration.
 type Config struct {
 	// Addr is the address on which the registry server will listen.
 	Addr string
-	// ContainerdSock is the path to the containerd.sock socket.
+	// AdminSock is the path to the unix socket on which the admin API server will listen. The admin API is disabled
+	// if empty. Privileged endpoints are only served on this socket and never on Addr.
+	AdminSock string
+	// ReadHeaderTimeout is how long the registry server waits for the headers of a request. No timeout if 0.
+	ReadHeaderTimeout time.Duration
+	// WriteTimeout is the maximum duration of a request from the end of reading its headers until the response is
+	// written, including reading the request body. It must exceed the longest blob upload and download as slower
+	// transfers are cut off. No timeout if 0.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long an idle keep-alive connection is kept open waiting for the next request. No timeout
+	// if 0.
+	IdleTimeout time.Duration
+	// DisableKeepAlives closes every connection after serving a single request.
+	DisableKeepAlives bool
+	// TCPKeepAlive is the interval of TCP keep-alive probes on the client connections detecting dead peers. Defaults
+	// to 15 seconds if 0. Probes are disabled if negative.
+	TCPKeepAlive time.Duration
+	// Backend is the name of the storage backend the registry is backed by: "containerd" (default) to access
+	// the containerd image store directly, "docker" to go through the Docker Engine API, or a backend registered with
+	// the pkg/storage package.
+	Backend string
+	// BackendOptions are passed to the factory of a backend registered with the pkg/storage package. They're ignored
+	// by the built-in backends.
+	BackendOptions map[string]any
+	// DockerSock is the path to the docker.sock socket of the Docker daemon used by the "docker" backend.
+	DockerSock string
+	// DockerDataDir is the directory where the "docker" backend stages uploaded blobs and the blobs of the images
+	// saved from the Docker daemon. The blobs are deleted once they haven't been used for StaleUploadAge. A directory
+	// in the system temporary directory is used if empty.
+	DockerDataDir string
+	// ContainerdSock is the containerd endpoint: the path to the containerd.sock socket optionally prefixed with
+	// "unix://", an abstract unix socket name prefixed with "@", or "tcp://<host>:<port>" for containerd listening on
+	// a TCP address.
 	ContainerdSock string
-	// ContainerdNamespace is the containerd namespace to use for storing images.
+	// ContainerdTLSCA is the path to a PEM-encoded CA certificate to verify the certificate of containerd on a TCP
+	// endpoint. The system roots are used if empty. TLS is enabled if it or ContainerdTLSCert is set.
+	ContainerdTLSCA string
+	// ContainerdTLSCert is the path to a PEM-encoded client certificate to authenticate to containerd with over TLS.
+	// It must be set together with ContainerdTLSKey.
+	ContainerdTLSCert string
+	// ContainerdTLSKey is the path to a PEM-encoded private key of the containerd TLS client certificate.
+	ContainerdTLSKey string
+	// ContentStoreDir is the directory of the containerd content store to check the available disk space in before
+	// accepting blob uploads. It's detected from containerd if empty. The check is disabled if the directory isn't
+	// accessible, e.g. not mounted into the unregistry container.
+	ContentStoreDir string
+	// ContainerdNamespace is the containerd namespace to use for storing images. NamespaceAuto detects it at startup
+	// as the first of "moby", "k8s.io", and "default" that has images.
 	ContainerdNamespace string
+	// MergeNamespaces are the other containerd namespaces, e.g. "k8s.io", whose images are served along with
+	// the images in ContainerdNamespace. Pulls fall back to them in order for the images missing in ContainerdNamespace
+	// and the catalog lists the repositories of all namespaces. Pushes always go to ContainerdNamespace.
+	MergeNamespaces []string
+	// NamespaceAnnotation is the image index or manifest annotation, e.g. "unregistry.target-namespace", which value
+	// is the containerd namespace to tag a pushed image in instead of ContainerdNamespace. Routing is disabled
+	// if empty.
+	NamespaceAnnotation string
+	// NamespaceRoutes is the list of "<pattern>=<namespace>" rules storing the repositories matching the pattern
+	// in another containerd namespace instead of ContainerdNamespace, e.g. "k8s/*=k8s.io" for all repositories under
+	// "k8s/". The pattern is either a repository name or a repository name prefix followed by "/*". The first matching
+	// rule wins. Routing is disabled if empty.
+	NamespaceRoutes []string
+	// DefaultPlatform is the platform used to resolve multi-platform images, e.g. "linux/amd64". The host platform
+	// is used if empty.
+	DefaultPlatform string
+	// GlobalBlobs enables serving any blob by digest at /v2/_blobs/<digest> regardless of the repository it was
+	// pushed to, e.g. for cluster peers fetching layers. It can't be combined with AllowRepos or DenyRepos.
+	GlobalBlobs bool
+	// Deltas enables accepting blob uploads encoded as binary deltas against layers that already exist in the content
+	// store and listing the candidate base layers of a repository at /v2/<name>/_deltas/bases.
+	Deltas bool
+	// EnableDelete enables deleting manifests, tags, and blobs with the DELETE endpoints of the registry API. They're
+	// rejected with an UNSUPPORTED error if disabled. Deleting images through the admin API is always enabled.
+	EnableDelete bool
+	// EnableImport enables importing image tarballs at POST /api/images/import on the registry listener in addition
+	// to the admin socket. It can't be used with NamespaceRoutes or NamespaceAnnotation as imports don't route
+	// images to other namespaces.
+	EnableImport bool
+	// ForwardPeers is the list of peer registry URLs, e.g. "http://edge-1:5000", to deliver pushed images to. Images
+	// pushed while a peer is unreachable are queued and delivered when it becomes reachable. Forwarding is disabled
+	// if empty.
+	ForwardPeers []string
+	// ForwardTTL is how long the images are kept queued for delivery to an unreachable peer before being dropped.
+	ForwardTTL time.Duration
+	// DisableHTTP2 restricts the registry server to HTTP/1.1. Otherwise, HTTP/2 is negotiated over TLS and cleartext
+	// HTTP/2 (h2c) with prior knowledge is accepted over plain HTTP.
+	DisableHTTP2 bool
+	// Htpasswd is the path to an htpasswd file with bcrypt-hashed passwords of users allowed to push and pull images
+	// using HTTP Basic authentication. Authentication is disabled if empty.
+	Htpasswd string
+	// LeaseTTL is the expiration of the containerd leases retaining the content of blob uploads until an image
+	// references it. Leases of uploads in progress are renewed while the data is written so that slow uploads aren't
+	// garbage collected mid-transfer. Content of abandoned uploads is garbage collected after it. Defaults to 1 hour
+	// if 0.
+	LeaseTTL time.Duration
+	// MirrorCompat allows Docker daemons using the registry in "registry-mirrors" to pull without credentials when
+	// Htpasswd authentication is enabled, as the daemons don't send credentials to mirrors. Pushes and requests with
+	// credentials are still authenticated.
+	MirrorCompat bool
+	// MetadataDB is the path to the database file for persisting registry-specific state, such as tag history.
+	// The state is kept in memory and lost on restart if empty.
+	MetadataDB string
+	// MaxProcs limits the number of CPUs the registry can use simultaneously. No limit if 0.
+	MaxProcs int
+	// MemoryLimit is a soft memory limit for the registry process, e.g. "512MiB". No limit if empty.
+	MemoryLimit string
+	// MaxConcurrentCopies limits the number of blob copy and digest verification operations into the containerd
+	// content store running at the same time. If 0, no limit on 64-bit platforms and 2 on 32-bit ones.
+	MaxConcurrentCopies int
+	// MaxConcurrentUploads limits the number of blob uploads transferring data at the same time. Excess uploads wait
+	// for their turn rather than failing. No limit if 0.
+	MaxConcurrentUploads int
+	// MaxUploadWait is how long an upload over MaxConcurrentUploads waits for its turn before it's rejected with
+	// 429 Too Many Requests and a Retry-After header. Excess uploads wait indefinitely if 0.
+	MaxUploadWait time.Duration
+	// MaxManifestSize is the maximum size of a pushed manifest, e.g. "512KiB". Larger manifests are rejected before
+	// they're buffered in memory. Defaults to and can't exceed 4MiB.
+	MaxManifestSize string
+	// MaxPressure is the CPU and IO pressure of the host in percent, as reported by Linux pressure stall information
+	// (PSI) averaged over 10 seconds, above which new upload sessions are rejected with 503 Service Unavailable and
+	// a Retry-After header. Uploads in progress and pulls are not affected. Disabled if 0.
+	MaxPressure float64
+	// RateLimit is the number of registry requests per second a single client can make on average. Requests over
+	// the limit are rejected with 429 Too Many Requests. Clients are identified by the authenticated user if
+	// authentication is enabled, otherwise by the remote IP. No limit if 0.
+	RateLimit float64
+	// RateLimitBurst is the number of requests a client can make at once above RateLimit. Defaults to RateLimit
+	// rounded up if 0.
+	RateLimitBurst int
+	// RateLimitBandwidth is the data transfer rate per second of a single client for both uploads and downloads,
+	// e.g. "10MiB". Transfers over the limit are slowed down rather than rejected. No limit if empty.
+	RateLimitBandwidth string
+	// OTLPEndpoint is the URL of the OpenTelemetry collector to export traces of registry requests and containerd
+	// storage operations to. The scheme selects the OTLP protocol: "grpc://host:4317" for gRPC, "http://host:4318"
+	// or "https://host:4318" for HTTP. Tracing is disabled if empty.
+	OTLPEndpoint string
+	// TraceSampleRatio is the fraction of requests to trace, from 0 to 1, unless the client propagates a sampled
+	// trace context. Defaults to 1 (all requests).
+	TraceSampleRatio float64
+	// ProgressLogInterval is the interval of logging the progress and rate of the blob uploads receiving data.
+	// Progress logging is disabled if 0.
+	ProgressLogInterval time.Duration
+	// LowPriority lowers the CPU and IO scheduling priority of the registry process so that it doesn't starve other
+	// workloads on the host. Only supported on Linux. The content of pushed images is written to disk by containerd
+	// which keeps its own IO priority, so it mostly lowers the priority of hashing and serving blobs.
+	LowPriority bool
+	// StaleUploadAge is how long an unfinished blob upload can go without receiving data before its partial content
+	// is discarded, e.g. left behind by a crashed push. Stale uploads are purged in the background and on request
+	// through the admin API. Background purging is disabled if 0.
+	StaleUploadAge time.Duration
+	// AllowRepos are the patterns of repository names that can be pushed to and pulled from. All repositories are
+	// allowed if empty. A pattern is a glob, e.g. "myorg/*", or a regular expression if prefixed with "regex:".
+	AllowRepos []string
+	// DenyRepos are the patterns of repository names that can't be pushed to or pulled from even if allowed by
+	// AllowRepos.
+	DenyRepos []string
+	// ReadFallbacks are the storages consulted in order for the images, tags and blobs missing in the primary storage
+	// when pulling, e.g. "containerd:k8s.io" for another containerd namespace or "oci:/srv/images" for an OCI image
+	// layout directory. Pushes always go to the primary storage. Fallbacks are disabled if empty.
+	ReadFallbacks []string
+	// PullRewrites is the list of "<from>=<to>" rules rewriting the repository names of pulled tags that aren't found
+	// under the requested name, e.g. "docker.io/library=registry.example.com".
+	PullRewrites []string
+	// ProxyUpstreams are the upstream registry URLs, e.g. "https://docker.io", to fetch the pulled images and blobs
+	// missing in the containerd store from, turning the registry into a pull-through cache. The upstream of
+	// a repository is selected by the registry domain of its name. Credentials for an upstream can be specified in
+	// the URL user info. The pull-through proxy is disabled if empty.
+	ProxyUpstreams []string
+	// ProxyTTL is how long an image fetched by tag from an upstream is served from the cache before the tag is
+	// resolved against the upstream again. Cached tags are never revalidated if zero.
+	ProxyTTL time.Duration
+	// AuditLog is the sink to write audit records of manifest pushes and pulls, and blob uploads to as JSON lines.
+	// It's either a file path or "-" for stdout. Auditing is disabled if empty.
+	AuditLog string
+	// AccessLog is the sink to write a structured log line per registry request to, replacing the access logs of
+	// the registry app in the application logs. It's either a file path or "-" for stdout. Access logs are written
+	// regardless of LogLevel. Access logging is disabled if empty.
+	AccessLog string
+	// AccessLogFormat is the format of the access log lines. Either "json" or "text".
+	AccessLogFormat string
+	// AccessLogFields are the fields to include in the access log lines, e.g. "remote", "repo", "digest",
+	// "bytes_in", "bytes_out", "duration". All fields are included if empty.
+	AccessLogFields []string
+	// PushTimeout is the time after the last blob or manifest upload to a repository after which the content of
+	// the push that isn't referenced by an image is deleted as the push is considered failed. Push transactions are
+	// disabled if 0 and the content of failed pushes is retained until the upload leases expire.
+	PushTimeout time.Duration
+	// DryRun makes the registry accept pushes without storing anything and only record what would be transferred.
+	// The result is available in the admin API.
+	DryRun bool
+	// RepoQuota is the maximum size of the images stored in each repository, e.g. "10GB". Pushes to repositories
+	// that reach it are rejected with 507 Insufficient Storage. No quota if empty.
+	RepoQuota string
+	// ReportSigningKey is the path to an ed25519 private key in the PEM format to sign transfer reports of pushed
+	// images with. Reports are not signed if empty.
+	ReportSigningKey string
+	// SignatureKeys are the paths to the PEM-encoded public keys, e.g. cosign.pub, of which a valid cosign signature
+	// must be present in the repository to push an image by tag. Unsigned images are rejected with a DENIED error.
+	// Signatures aren't required if empty.
+	SignatureKeys []string
+	// SpecStrict enables the exact OCI distribution spec behaviours (status codes, headers, error bodies) even where
+	// Docker clients are lenient.
+	SpecStrict bool
+	// IdleExit is the duration after which the registry shuts down if it hasn't received any requests. It's useful
+	// for an ephemeral registry started for a single push. Disabled if 0.
+	IdleExit time.Duration
+	// ACMEDomains are the domain names to automatically obtain and renew TLS certificates for from an ACME
+	// certificate authority (Let's Encrypt). The registry must be reachable on port 443 of the domains to complete
+	// the TLS-ALPN-01 challenge. Automatic TLS is disabled if empty. It can't be used with TLSCert.
+	ACMEDomains []string
+	// ACMECacheDir is the directory to store obtained certificates and the ACME account key in.
+	ACMECacheDir string
+	// ACMEEmail is the optional contact email for the ACME account to receive certificate expiry notices.
+	ACMEEmail string
+	// TLSCert is the path to a PEM-encoded TLS certificate (chain) to serve the registry over HTTPS. It must be set
+	// together with TLSKey. The certificate is reloaded when the file changes.
+	TLSCert string
+	// TLSKey is the path to a PEM-encoded private key of the TLS certificate.
+	TLSKey string
+	// UI enables serving the embedded web UI for browsing and deleting the images on the host at the root of
+	// the admin API socket.
+	UI bool
+	// Unpack enables unpacking pushed images for DefaultPlatform into Snapshotter right after they're tagged in
+	// the background, so that containers start from them without paying the unpack cost on the first run. A
+	// failed unpack is logged and doesn't fail the push.
+	Unpack bool
+	// Snapshotter is the containerd snapshotter to unpack pushed images into and to report the unpacked image sizes
+	// in, e.g. "overlayfs", "native", "stargz", or "zfs". Unpacked sizes aren't reported if empty.
+	Snapshotter string
+	// ValidateSchema enables validating pushed image manifests, indexes and image configs against the OCI image spec
+	// JSON schemas. Pushes of invalid manifests are rejected with a MANIFEST_INVALID error listing all violations.
+	ValidateSchema bool
+	// VerifyOnRead enables verifying blob digests every time blobs are served to detect content corrupted on disk.
+	VerifyOnRead bool
+	// VerifyOnWrite enables hashing uploaded blobs while they're streamed to reject mismatching uploads before they're
+	// committed. Containerd still verifies the digests on commit if disabled, so it only saves hashing them twice.
+	VerifyOnWrite bool
 	// LogLevel is one of "debug", "info", "warn", "error".
 	LogLevel string
 	// LogFormatter to use for the logs. Either "text" or "json".
 	LogFormatter string
+	// LogRequests enables dumping the headers of registry requests and responses at the debug level. Sensitive
+	// headers such as Authorization are redacted.
+	LogRequests bool
+	// LogRequestsInclude are the regular expressions of URL paths to dump requests for if LogRequests is enabled.
+	// All requests are dumped if empty.
+	LogRequestsInclude []string
+	// LogRequestsExclude are the regular expressions of URL paths to not dump requests for even if included.
+	LogRequestsExclude []string
+
+	// dialContainerd creates the containerd clients instead of connecting to ContainerdSock if set, e.g. to serve
+	// the registry from in-memory containerd services in tests.
+	dialContainerd func(namespace string) (*client.Client, error)
+}
+
+// Validate checks the configuration for invalid values and combinations of options without creating the registry or
+// accessing containerd, e.g. to fail fast when provisioning a host. NewRegistry validates the configuration as well.
+func (c Config) Validate() error {
+	var errs []error
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("invalid log level: %w", err))
+	}
+	if c.LogFormatter != "json" && c.LogFormatter != "text" {
+		errs = append(errs, fmt.Errorf("invalid log formatter: '%s'; expected 'json' or 'text'", c.LogFormatter))
+	}
+	if c.DefaultPlatform != "" {
+		if _, err := platforms.Parse(c.DefaultPlatform); err != nil {
+			errs = append(errs, fmt.Errorf("invalid default platform: %w", err))
+		}
+	}
+	if c.MemoryLimit != "" {
+		if _, err := parseSize(c.MemoryLimit); err != nil {
+			errs = append(errs, fmt.Errorf("invalid memory limit: %w", err))
+		}
+	}
+	if _, err := manifestSize(c); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newRateLimiter(c); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := repoQuota(c); err != nil {
+		errs = append(errs, err)
+	}
+	if c.MaxPressure < 0 || c.MaxPressure > 100 {
+		errs = append(errs, fmt.Errorf("invalid max pressure %v: expected a percentage between 0 and 100",
+			c.MaxPressure))
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("invalid trace sample ratio: %v; expected a number from 0 to 1",
+			c.TraceSampleRatio))
+	}
+
+	if len(c.ACMEDomains) > 0 {
+		if c.TLSCert != "" || c.TLSKey != "" {
+			errs = append(errs, errors.New("TLS certificate files can't be used with automatic TLS for ACME domains"))
+		}
+		if c.ACMECacheDir == "" {
+			errs = append(errs, errors.New("ACME cache directory must be set"))
+		}
+	} else if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, errors.New("both TLS certificate and key must be set"))
+	}
+
+	if network, _, err := containerd.ParseEndpoint(c.ContainerdSock); err != nil {
+		errs = append(errs, err)
+	} else if network != "tcp" && (c.ContainerdTLSCA != "" || c.ContainerdTLSCert != "" || c.ContainerdTLSKey != "") {
+		errs = append(errs, errors.New("containerd TLS is only supported for TCP containerd endpoints"))
+	}
+	if c.EnableImport && (len(c.NamespaceRoutes) > 0 || c.NamespaceAnnotation != "") {
+		errs = append(errs, errors.New("importing images can't be enabled with namespace routes or annotation"))
+	}
+	if c.UI && c.AdminSock == "" {
+		errs = append(errs, errors.New("admin socket must be set to serve the web UI"))
+	}
+	if c.Unpack && c.Snapshotter == "" {
+		errs = append(errs, errors.New("snapshotter must be set to unpack pushed images"))
+	} else if c.Snapshotter != "" {
+		if err := identifiers.Validate(c.Snapshotter); err != nil {
+			errs = append(errs, fmt.Errorf("invalid snapshotter '%s': %w", c.Snapshotter, err))
+		}
+	}
+	if (c.ContainerdTLSCert == "") != (c.ContainerdTLSKey == "") {
+		errs = append(errs, errors.New("both containerd TLS certificate and key must be set"))
+	}
+
+	switch name := c.backendName(); {
+	case name == BackendContainerd:
+	case !storage.Registered(name):
+		errs = append(errs, fmt.Errorf("invalid backend: '%s'; expected one of: %s", name,
+			strings.Join(storage.Backends(), ", ")))
+	default:
+		errs = append(errs, c.validateBackend(name)...)
+	}
+
+	if _, err := containerd.NewRepositoryFilter(c.AllowRepos, c.DenyRepos); err != nil {
+		errs = append(errs, err)
+	}
+	// Blobs fetched by digest don't belong to a repository so they would bypass the repository filter.
+	if c.GlobalBlobs && (len(c.AllowRepos) > 0 || len(c.DenyRepos) > 0) {
+		errs = append(errs, errors.New("global blobs can't be enabled with allowed or denied repositories"))
+	}
+	if _, err := containerd.NewPullRewrites(c.PullRewrites); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := containerd.NewPullThrough(c.ProxyUpstreams, c.ProxyTTL, nil); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := containerd.NewSignaturePolicy(c.SignatureKeys); err != nil {
+		errs = append(errs, err)
+	}
+	if c.ProxyTTL < 0 {
+		errs = append(errs, errors.New("proxy TTL must not be negative"))
+	}
+	for _, r := range c.NamespaceRoutes {
+		if _, _, err := parseNamespaceRoute(r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i, ns := range c.MergeNamespaces {
+		if ns == "" || ns == c.ContainerdNamespace || slices.Contains(c.MergeNamespaces[:i], ns) {
+			errs = append(errs, fmt.Errorf("invalid merged namespace '%s': namespaces must be non-empty and unique",
+				ns))
+		}
+	}
+	for _, f := range c.ReadFallbacks {
+		if err := c.validateReadFallback(f); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := newRequestLogFilter(c.LogRequestsInclude, c.LogRequestsExclude); err != nil {
+		errs = append(errs, err)
+	}
+	if c.AccessLog != "" {
+		if err := accesslog.Validate(c.AccessLogFormat, c.AccessLogFields); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// backendName returns the name of the storage backend defaulting to the containerd one.
+func (c Config) backendName() string {
+	if c.Backend == "" {
+		return BackendContainerd
+	}
+	return c.Backend
+}
+
+// validateBackend returns the errors for the options that require direct access to containerd and aren't
+// supported by the backend other than the containerd one.
+func (c Config) validateBackend(name string) []error {
+	unsupported := []struct {
+		option string
+		set    bool
+	}{
+		{"admin socket", c.AdminSock != ""},
+		{"content store directory", c.ContentStoreDir != ""},
+		{"deltas", c.Deltas},
+		{"dry run", c.DryRun},
+		{"importing images", c.EnableImport},
+		{"forwarding to peers", len(c.ForwardPeers) > 0},
+		{"global blobs", c.GlobalBlobs},
+		{"namespace annotation", c.NamespaceAnnotation != ""},
+		{"namespace routes", len(c.NamespaceRoutes) > 0},
+		{"pull-through proxy", len(c.ProxyUpstreams) > 0},
+		{"pull rewrites", len(c.PullRewrites) > 0},
+		{"report signing key", c.ReportSigningKey != ""},
+		{"repository quota", c.RepoQuota != ""},
+		{"schema validation", c.ValidateSchema},
+		{"signature verification", len(c.SignatureKeys) > 0},
+		{"unpacking", c.Unpack},
+		{"verify on read", c.VerifyOnRead},
+	}
+	var errs []error
+	for _, u := range unsupported {
+		if u.set {
+			errs = append(errs, fmt.Errorf("%s is not supported by the '%s' backend", u.option, name))
+		}
+	}
+	if name == BackendDocker && c.DockerSock == "" {
+		errs = append(errs, errors.New("docker socket must be set for the 'docker' backend"))
+	}
+	return errs
+}
+
+// Redacted returns a copy of the configuration safe to expose with the credentials removed from the URLs, e.g. proxy
+// upstreams and forward peers. The values of the backend options are replaced as they may be arbitrary secrets.
+func (c Config) Redacted() Config {
+	redactAll := func(values []string) []string {
+		if values == nil {
+			return nil
+		}
+		redacted := make([]string, len(values))
+		for i, v := range values {
+			redacted[i] = RedactURL(v)
+		}
+		return redacted
+	}
+	c.ForwardPeers = redactAll(c.ForwardPeers)
+	c.ProxyUpstreams = redactAll(c.ProxyUpstreams)
+	c.ReadFallbacks = redactAll(c.ReadFallbacks)
+	c.OTLPEndpoint = RedactURL(c.OTLPEndpoint)
+	if c.BackendOptions != nil {
+		c.BackendOptions = maps.Clone(c.BackendOptions)
+		for k := range c.BackendOptions {
+			c.BackendOptions[k] = redactedValue
+		}
+	}
+	return c
+}
+
+// redactedValue replaces the secrets in the redacted configuration the same way url.URL.Redacted does.
+const redactedValue = "xxxxx"
+
+// RedactURL replaces the password in the value with "xxxxx" if the value is a URL with credentials, e.g. a peer
+// registry or OpenTelemetry collector URL. Other values are returned as is.
+func RedactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	return u.Redacted()
 }
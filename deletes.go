@@ -0,0 +1,21 @@
+package unregistry
+
+import (
+	"net/http"
+)
+
+// untagUnsupportedHandler rejects deleting tags with 405 UNSUPPORTED when deletes are disabled. The storage backends
+// return distribution.ErrUnsupported from Untag in this case but the registry app reports any Untag error other than
+// an unknown tag as 500 UNKNOWN.
+func untagUnsupportedHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodDelete {
+			if _, ok := parseManifestPathTag(req.URL.Path); ok {
+				writeOCIError(w, http.StatusMethodNotAllowed, "UNSUPPORTED",
+					"deleting tags is disabled, start the registry with --enable-delete to enable it")
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,149 @@
+package unregistry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/containerd/errdefs"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/psviderski/unregistry/internal/delta"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/sirupsen/logrus"
+)
+
+// maxDeltaBases is the maximum number of delta base candidates returned for a repository.
+const maxDeltaBases = 100
+
+// deltaBasesPathRegexp matches the path listing the delta base candidates of a repository: /v2/<name>/_deltas/bases
+var deltaBasesPathRegexp = regexp.MustCompile(`^/v2/(.+)/_deltas/bases$`)
+
+// deltaHandler accepts blob uploads encoded as binary deltas against blobs that already exist in the content store.
+// The client picks a base from the layers listed at /v2/<name>/_deltas/bases, e.g. the same layer of the previous
+// image version, and sends the delta as the body of a monolithic PATCH or PUT upload request with the base digest in
+// the Unregistry-Delta-Base header. The original blob is reconstructed on the fly and passed to the next handler so
+// the upload commits and verifies the digest of the original blob as usual. The size of the original blob must be
+// declared in the Unregistry-Delta-Size header and the upload fails as soon as the delta expands beyond it. Other
+// requests are passed to the next handler.
+func (r *Registry) deltaHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if m := deltaBasesPathRegexp.FindStringSubmatch(req.URL.Path); m != nil {
+			r.deltaBasesHandler(w, req, m[1])
+			return
+		}
+
+		baseHeader := req.Header.Get(delta.BaseHeader)
+		if baseHeader == "" || (req.Method != http.MethodPatch && req.Method != http.MethodPut) ||
+			!uploadSessionPathRegexp.MatchString(req.URL.Path) {
+			next.ServeHTTP(w, req)
+			return
+		}
+		// Offsets of chunks refer to the original blob which size isn't known until the delta is applied.
+		if req.Header.Get("Content-Range") != "" {
+			writeOCIError(w, http.StatusBadRequest, "UNSUPPORTED", "delta uploads can't be chunked")
+			return
+		}
+		base, err := digest.Parse(baseHeader)
+		if err != nil {
+			writeOCIError(w, http.StatusBadRequest, "DIGEST_INVALID", fmt.Sprintf("invalid delta base digest: %v", err))
+			return
+		}
+		// The declared size of the original blob bounds how much data the delta may expand to.
+		size, err := strconv.ParseInt(req.Header.Get(delta.SizeHeader), 10, 64)
+		if err != nil || size < 0 {
+			writeOCIError(w, http.StatusBadRequest, "SIZE_INVALID",
+				fmt.Sprintf("delta uploads require the size of the original blob in the %s header", delta.SizeHeader))
+			return
+		}
+
+		release, err := containerd.LeaseContent(req.Context(), r.client, base)
+		if err != nil {
+			writeOCIError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+		defer release()
+
+		ra, err := r.client.ContentStore().ReaderAt(req.Context(), ocispec.Descriptor{Digest: base})
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				writeOCIError(w, http.StatusNotFound, "BLOB_UNKNOWN", "delta base blob unknown to registry")
+				return
+			}
+			writeOCIError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+		defer ra.Close()
+
+		body, err := delta.NewReader(req.Body, ra, ra.Size(), size)
+		if err != nil {
+			if errors.Is(err, delta.ErrInvalidDelta) {
+				writeOCIError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+				return
+			}
+			writeOCIError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+		defer body.Close()
+
+		logrus.WithFields(logrus.Fields{
+			"path": req.URL.Path,
+			"base": base,
+			"size": size,
+		}).Debug("Reconstructing blob upload from delta.")
+
+		req.Body = body
+		req.ContentLength = size
+		req.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+		next.ServeHTTP(w, req)
+	})
+}
+
+// deltaBasesHandler lists the layers of the images in the repository that can be used as delta bases, from the most
+// recently created images first.
+func (r *Registry) deltaBasesHandler(w http.ResponseWriter, req *http.Request, name string) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeOCIError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "only GET is supported")
+		return
+	}
+	if r.accessController != nil {
+		if _, err := r.accessController.Authorized(req); err != nil {
+			if challenge, ok := err.(auth.Challenge); ok {
+				challenge.SetHeaders(req, w)
+				writeOCIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+				return
+			}
+			logrus.WithError(err).Error("Failed to authorize request.")
+			writeOCIError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+	}
+
+	repo, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		writeOCIError(w, http.StatusBadRequest, "NAME_INVALID", fmt.Sprintf("invalid repository name: %v", err))
+		return
+	}
+	// The filter patterns match familiar names the same way the registry backend does for the registry API.
+	if familiar := reference.FamiliarName(repo); !r.repoFilter.Allowed(familiar) {
+		writeOCIError(w, http.StatusForbidden, "DENIED",
+			fmt.Sprintf("access to repository '%s' is not allowed by the registry configuration", familiar))
+		return
+	}
+
+	bases, err := containerd.DeltaBases(req.Context(), r.client, repo, maxDeltaBases)
+	if err != nil {
+		writeOCIError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(map[string]any{"bases": bases}); err != nil {
+		logrus.WithError(err).Debug("Failed to write delta bases response.")
+	}
+}
@@ -0,0 +1,87 @@
+package unregistry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/psviderski/unregistry/internal/transfer"
+	"github.com/sirupsen/logrus"
+)
+
+// diskSpaceReserve is the space that must stay available on the content store filesystem in addition to the uploaded
+// data so that containerd can still update its metadata and commit the uploads in progress.
+const diskSpaceReserve = 64 << 20
+
+// contentStoreDir returns the directory of the containerd content store to check the available disk space in before
+// accepting blob uploads. If not configured, it's detected from containerd. It returns an empty string if the
+// directory isn't accessible, e.g. not mounted into the unregistry container, or containerd is remote, so the check is
+// disabled.
+func contentStoreDir(ctx context.Context, cfg Config, cli *client.Client) string {
+	dir := cfg.ContentStoreDir
+	if dir == "" {
+		// The content store of containerd on a TCP endpoint is on another host.
+		if network, _, _ := containerd.ParseEndpoint(cfg.ContainerdSock); network == "tcp" {
+			return ""
+		}
+		var err error
+		if dir, err = containerd.ContentStoreRoot(ctx, cli); err != nil {
+			logrus.WithError(err).Debug("Failed to detect containerd content store directory.")
+			return ""
+		}
+	}
+	if _, err := availableSpace(dir); err != nil {
+		log := logrus.WithField("dir", dir).WithError(err)
+		// The detected directory is on the host and likely not mounted into the unregistry container.
+		if cfg.ContentStoreDir != "" {
+			log.Warn("Disk space check for blob uploads is disabled as the content store directory is inaccessible.")
+		} else {
+			log.Debug("Disk space check for blob uploads is disabled as the content store directory is inaccessible.")
+		}
+		return ""
+	}
+	return dir
+}
+
+// diskSpaceHandler wraps the registry handler to reject blob uploads with 507 Insufficient Storage when the filesystem
+// of the content store in dir doesn't have enough space for the declared Content-Length of the request. Clients get
+// a clear error before any data is transferred instead of an opaque failure when the disk fills up mid-write.
+// Uploads of unknown length, e.g. Docker streaming a layer, are only rejected if the disk is already nearly full.
+func diskSpaceHandler(next http.Handler, dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upload := false
+		switch r.Method {
+		case http.MethodPost:
+			upload = (uploadsPathRegexp.MatchString(r.URL.Path) && r.URL.Query().Get("mount") == "") ||
+				r.URL.Path == importPath
+		case http.MethodPatch, http.MethodPut:
+			upload = uploadSessionPathRegexp.MatchString(r.URL.Path)
+		}
+		if !upload {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		available, err := availableSpace(dir)
+		if err != nil {
+			logrus.WithField("dir", dir).WithError(err).Debug("Failed to check available disk space.")
+			next.ServeHTTP(w, r)
+			return
+		}
+		required := diskSpaceReserve + max(r.ContentLength, 0)
+		if available < required {
+			logrus.WithFields(logrus.Fields{
+				"path":      r.URL.Path,
+				"required":  transfer.HumanSize(required),
+				"available": transfer.HumanSize(available),
+			}).Warn("Rejected blob upload due to insufficient disk space.")
+			writeOCIError(w, http.StatusInsufficientStorage, "BLOB_UPLOAD_INVALID", fmt.Sprintf(
+				"insufficient storage on the registry host: %s required, %s available",
+				transfer.HumanSize(required), transfer.HumanSize(available)))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package unregistry
+
+import "errors"
+
+// availableSpace is only supported on Linux and macOS.
+func availableSpace(string) (int64, error) {
+	return 0, errors.New("checking available disk space is only supported on Linux and macOS")
+}
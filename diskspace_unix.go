@@ -0,0 +1,14 @@
+//go:build linux || darwin
+
+package unregistry
+
+import "golang.org/x/sys/unix"
+
+// availableSpace returns the number of bytes available to unprivileged users on the filesystem containing the path.
+func availableSpace(path string) (int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
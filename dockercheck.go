@@ -0,0 +1,136 @@
+package unregistry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/psviderski/unregistry/internal/events"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/psviderski/unregistry/internal/storage/docker"
+	"github.com/sirupsen/logrus"
+)
+
+// dockerNamespace is the containerd namespace of the images of the Docker daemon with the default configuration.
+const dockerNamespace = "moby"
+
+// errDockerMismatch is returned when the images pushed to the configured containerd namespace or socket would be
+// invisible to the Docker daemon on the host.
+var errDockerMismatch = errors.New("docker daemon doesn't read images from the configured containerd storage")
+
+// checkDockerDaemon checks that the Docker daemon on the host reads images from where the registry stores them. Docker
+// running with userns-remap keeps its images in a namespace named after the remapped IDs, e.g. "100000.100000", and
+// Docker with a non-default data root or its own managed containerd listens on another containerd socket. In both
+// cases the images pushed to the default "moby" namespace would never show up in 'docker images'.
+//
+// It returns the containerd namespace to use: the namespace of the Docker daemon if the configured one is
+// NamespaceAuto, otherwise the configured one. It returns errDockerMismatch if the registry targets the Docker
+// namespace but Docker reads images from elsewhere. The check is skipped if the Docker daemon isn't accessible, e.g.
+// on Kubernetes nodes without Docker.
+func checkDockerDaemon(ctx context.Context, cfg Config) (string, error) {
+	namespace := cfg.ContainerdNamespace
+	sock := strings.TrimPrefix(cfg.DockerSock, "unix://")
+	if sock == "" {
+		return namespace, nil
+	}
+	if _, err := os.Stat(sock); err != nil {
+		return namespace, nil
+	}
+	cli, err := docker.NewClient(sock)
+	if err != nil {
+		return namespace, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	info, err := cli.Info(ctx)
+	if err != nil {
+		logrus.WithError(err).Debug("Failed to check the Docker daemon configuration.")
+		return namespace, nil
+	}
+
+	// Docker older than 27.0 doesn't report its containerd namespace so only userns-remap can be detected.
+	if info.Containerd == nil || info.Containerd.Namespaces.Containers == "" {
+		if info.UsernsRemap() && (namespace == dockerNamespace || namespace == NamespaceAuto) {
+			logrus.WithField("data_root", info.DockerRootDir).Warn("Docker daemon runs with userns-remap so it " +
+				"likely stores images in another containerd namespace. Pushed images may not show up in " +
+				"'docker images': set --namespace to the namespace of Docker or use --backend docker.")
+		}
+		return namespace, nil
+	}
+
+	dockerNS := info.Containerd.Namespaces.Containers
+	if namespace == NamespaceAuto {
+		logrus.WithField("namespace", dockerNS).Info("Detected containerd namespace of the Docker daemon.")
+		namespace = dockerNS
+	}
+	// An explicit namespace other than the Docker default is intended for another runtime, e.g. Kubernetes.
+	if namespace != dockerNS && namespace != dockerNamespace {
+		return namespace, nil
+	}
+	if namespace != dockerNS {
+		reason := "a non-default configuration"
+		if info.UsernsRemap() {
+			reason = "userns-remap"
+		}
+		return namespace, fmt.Errorf("%w: Docker runs with %s and stores images in containerd namespace '%s' "+
+			"(data root '%s') but the registry is configured to use namespace '%s'; set --namespace %s",
+			errDockerMismatch, reason, dockerNS, info.DockerRootDir, namespace, dockerNS)
+	}
+
+	if same, ok := sameSocket(info.Containerd.Address, cfg.ContainerdSock); ok && !same {
+		return namespace, fmt.Errorf("%w: Docker (data root '%s') uses containerd socket '%s' but the registry is "+
+			"configured to use '%s'; set --sock %s", errDockerMismatch, info.DockerRootDir,
+			info.Containerd.Address, cfg.ContainerdSock, info.Containerd.Address)
+	}
+	return namespace, nil
+}
+
+// sameSocket returns true if the Docker containerd address and the configured containerd endpoint are the same
+// socket file. The result is only meaningful if ok is true: both are local sockets accessible by the registry. The
+// address reported by Docker is a path on the host that may not be mounted into the unregistry container.
+func sameSocket(dockerAddr, endpoint string) (same bool, ok bool) {
+	network, addr, err := containerd.ParseEndpoint(endpoint)
+	if err != nil || network != "unix" || strings.HasPrefix(addr, "@") || dockerAddr == "" {
+		return false, false
+	}
+	dockerFile, err := os.Stat(strings.TrimPrefix(dockerAddr, "unix://"))
+	if err != nil {
+		return false, false
+	}
+	file, err := os.Stat(addr)
+	if err != nil {
+		return false, false
+	}
+	return os.SameFile(dockerFile, file), true
+}
+
+// checkDockerOnFirstPush repeats the Docker daemon check after the first image is pushed as the daemon may have been
+// reconfigured, e.g. with userns-remap, after the registry started. A mismatch is logged as an error as the pushed
+// image won't be visible to Docker. It returns when ctx is canceled or after the check.
+func (r *Registry) checkDockerOnFirstPush(ctx context.Context) {
+	ch, unsubscribe := r.events.Subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			if e.Type != events.TypePush {
+				continue
+			}
+			if _, err := checkDockerDaemon(ctx, r.cfg); errors.Is(err, errDockerMismatch) {
+				logrus.WithError(err).WithField("image", e.Image).Error(
+					"Pushed image is not visible to the Docker daemon.")
+			}
+			return
+		}
+	}
+}
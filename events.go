@@ -0,0 +1,223 @@
+package unregistry
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/events"
+)
+
+// Event is a registry event delivered to the applications embedding the registry: ImageTagged, ImageDeleted,
+// BlobUploaded, or PullServed.
+type Event interface {
+	// EventTime returns the time the event occurred.
+	EventTime() time.Time
+}
+
+// ImageTagged is the event of an image pushed to the registry, that is, its manifest tagged in the containerd image
+// store.
+type ImageTagged struct {
+	// Image is the normalized image reference, e.g. "docker.io/library/ubuntu:latest".
+	Image     string
+	Digest    digest.Digest
+	MediaType string
+	// ArtifactType is the artifact type of the manifest if the image is an OCI artifact rather than a runnable image.
+	ArtifactType string
+	Time         time.Time
+}
+
+// ImageDeleted is the event of an image deleted from the registry, that is, its tag or manifest removed from
+// the containerd image store.
+type ImageDeleted struct {
+	// Image is the normalized image reference, e.g. "docker.io/library/ubuntu:latest" or
+	// "docker.io/library/ubuntu@sha256:..." for an image pushed by digest.
+	Image     string
+	Digest    digest.Digest
+	MediaType string
+	Time      time.Time
+}
+
+// BlobUploaded is the event of a completed blob upload.
+type BlobUploaded struct {
+	// Repository is the normalized name of the repository the blob was uploaded to, e.g. "docker.io/library/ubuntu".
+	Repository string
+	Digest     digest.Digest
+	Size       int64
+	Time       time.Time
+}
+
+// PullServed is the event of an image manifest served to a client.
+type PullServed struct {
+	// Image is the normalized image reference the client requested, e.g. "docker.io/library/ubuntu:latest" or
+	// "docker.io/library/ubuntu@sha256:...".
+	Image     string
+	Digest    digest.Digest
+	MediaType string
+	Size      int64
+	// RemoteAddr is the IP address of the client.
+	RemoteAddr string
+	// User is the authenticated user. It's empty if authentication is disabled.
+	User string
+	Time time.Time
+}
+
+func (e ImageTagged) EventTime() time.Time  { return e.Time }
+func (e ImageDeleted) EventTime() time.Time { return e.Time }
+func (e BlobUploaded) EventTime() time.Time { return e.Time }
+func (e PullServed) EventTime() time.Time   { return e.Time }
+
+// Subscribe returns a channel that receives the registry events published after the subscription so that
+// applications embedding the registry can react to them in-process. Events are dropped if the receiver doesn't keep
+// up. The returned function must be called to unsubscribe. It closes the channel.
+func (r *Registry) Subscribe() (<-chan Event, func()) {
+	ch, unsubscribe := r.events.Subscribe()
+	out := make(chan Event)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(out)
+		for e := range ch {
+			typed := typedEvent(e)
+			if typed == nil {
+				continue
+			}
+			select {
+			case out <- typed:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return out, func() {
+		once.Do(func() {
+			close(done)
+			unsubscribe()
+		})
+	}
+}
+
+// OnEvent calls fn for every registry event published after the call until the returned stop function is called.
+// fn is called sequentially from a separate goroutine and should return quickly as events are dropped while it
+// doesn't keep up.
+func (r *Registry) OnEvent(fn func(Event)) (stop func()) {
+	ch, stop := r.Subscribe()
+	go func() {
+		for e := range ch {
+			fn(e)
+		}
+	}()
+	return stop
+}
+
+// typedEvent converts the internal event to the corresponding exported event type. It returns nil for event types
+// that aren't exposed.
+func typedEvent(e events.Event) Event {
+	switch e.Type {
+	case events.TypePush:
+		return ImageTagged{
+			Image:        e.Image,
+			Digest:       e.Digest,
+			MediaType:    e.MediaType,
+			ArtifactType: e.ArtifactType,
+			Time:         e.Time,
+		}
+	case events.TypeDelete:
+		return ImageDeleted{
+			Image:     e.Image,
+			Digest:    e.Digest,
+			MediaType: e.MediaType,
+			Time:      e.Time,
+		}
+	case events.TypeBlobUpload:
+		return BlobUploaded{
+			Repository: e.Repository,
+			Digest:     e.Digest,
+			Size:       e.Size,
+			Time:       e.Time,
+		}
+	case events.TypePull:
+		return PullServed{
+			Image:      e.Image,
+			Digest:     e.Digest,
+			MediaType:  e.MediaType,
+			Size:       e.Size,
+			RemoteAddr: e.RemoteAddr,
+			User:       e.User,
+			Time:       e.Time,
+		}
+	}
+	return nil
+}
+
+// publishEventsHandler wraps the registry handler to publish events for completed blob uploads and served manifests.
+// Push events are published by the storage middleware when the manifest is tagged.
+func (r *Registry) publishEventsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !r.events.Subscribed() {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		var (
+			eventType events.Type
+			named     reference.Named
+		)
+		if ref, ok := parseManifestPath(req.URL.Path); ok && req.Method == http.MethodGet {
+			eventType, named = events.TypePull, ref
+		} else if m := uploadSessionPathRegexp.FindStringSubmatch(req.URL.Path); m != nil &&
+			req.Method == http.MethodPut {
+			eventType = events.TypeBlobUpload
+			named, _ = reference.ParseNormalizedNamed(m[1])
+		} else if isMonolithicUpload(req) {
+			eventType = events.TypeBlobUpload
+			named, _ = reference.ParseNormalizedNamed(uploadsPathRegexp.FindStringSubmatch(req.URL.Path)[1])
+		}
+		if named == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		rw := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, req)
+
+		dgst, err := digest.Parse(rw.Header().Get("Docker-Content-Digest"))
+		if err != nil {
+			return
+		}
+		switch eventType {
+		case events.TypePull:
+			if rw.status != http.StatusOK {
+				return
+			}
+			e := events.Event{
+				Type:       events.TypePull,
+				Image:      named.String(),
+				Digest:     dgst,
+				MediaType:  rw.Header().Get("Content-Type"),
+				Size:       rw.written,
+				RemoteAddr: remoteIP(req),
+			}
+			e.User = r.requestUser(req)
+			r.events.Publish(e)
+		case events.TypeBlobUpload:
+			if rw.status != http.StatusCreated {
+				return
+			}
+			e := events.Event{
+				Type:       events.TypeBlobUpload,
+				Repository: named.Name(),
+				Digest:     dgst,
+			}
+			// The blob is uploaded in chunks by multiple requests so get its size from the content store.
+			if size, err := r.blobSize(req.Context(), dgst); err == nil {
+				e.Size = size
+			}
+			r.events.Publish(e)
+		}
+	})
+}
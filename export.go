@@ -0,0 +1,107 @@
+package unregistry
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
+	"github.com/psviderski/unregistry/internal/archive"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/sirupsen/logrus"
+)
+
+// exportSuffix is the suffix of the admin API path of an image that exports it, e.g. /api/images/ubuntu:24.04/export.
+const exportSuffix = "/export"
+
+// imageHandler serves GET /api/images/<ref> with the platform manifests of the image and GET /api/images/<ref>/export
+// with the image tarball. The export path can't be a separate route as the {ref...} wildcard must be the last segment
+// of a pattern and references may contain slashes.
+func (r *Registry) imageHandler(w http.ResponseWriter, req *http.Request) {
+	if image, ok := strings.CutSuffix(req.PathValue("ref"), exportSuffix); ok {
+		r.exportImageHandler(w, req, image)
+		return
+	}
+	r.imageManifestsHandler(w, req)
+}
+
+// exportImageHandler streams the image as a tarball in the OCI image layout assembled directly from the containerd
+// content store. The optional "platform" query parameter, e.g. ?platform=linux/arm64, exports only the manifest of
+// the platform instead of the whole multi-platform image. The tarball is compressed with the content coding
+// negotiated from the Accept-Encoding header.
+func (r *Registry) exportImageHandler(w http.ResponseWriter, req *http.Request, image string) {
+	ref, err := parseImageRef(image)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid image reference: %v", err), http.StatusBadRequest)
+		return
+	}
+	var platform platforms.MatchComparer
+	if p := req.URL.Query().Get("platform"); p != "" {
+		parsed, err := platforms.Parse(p)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid platform: %v", err), http.StatusBadRequest)
+			return
+		}
+		platform = platforms.Only(parsed)
+	}
+
+	enc := archive.NegotiateEncoding(req.Header.Get("Accept-Encoding"))
+	// Headers are only sent with the first byte of the tarball, so errors before it can still change the status.
+	rw := &deferredHeaderWriter{ResponseWriter: w, writeHeader: func() {
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFilename(ref.String())))
+		if enc != archive.EncodingIdentity {
+			w.Header().Set("Content-Encoding", string(enc))
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+	}}
+	aw, err := archive.NewWriter(rw, enc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err = containerd.ExportImage(req.Context(), r.client, ref, platform, aw); err == nil {
+		err = aw.Close()
+	}
+	if err != nil {
+		if !rw.started {
+			status := http.StatusInternalServerError
+			if errdefs.IsNotFound(err) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		logrus.WithField("image", ref.String()).WithError(err).Error("Failed to export image.")
+		// Abort the response so the client doesn't mistake the truncated tarball for a complete one.
+		panic(http.ErrAbortHandler)
+	}
+	logrus.WithFields(logrus.Fields{
+		"image":    ref.String(),
+		"encoding": enc,
+	}).Info("Exported image.")
+}
+
+// exportFilename returns the name of the tarball file of the exported image, e.g. "myapp_1.0.tar" for
+// "docker.io/library/myapp:1.0".
+func exportFilename(image string) string {
+	name := image[strings.LastIndex(image, "/")+1:]
+	return strings.NewReplacer(":", "_", "@", "_").Replace(name) + ".tar"
+}
+
+// deferredHeaderWriter is an http.ResponseWriter that calls writeHeader before the first write to the response.
+type deferredHeaderWriter struct {
+	http.ResponseWriter
+	writeHeader func()
+	started     bool
+}
+
+func (w *deferredHeaderWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.writeHeader()
+	}
+	return w.ResponseWriter.Write(p)
+}
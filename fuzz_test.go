@@ -0,0 +1,42 @@
+package unregistry
+
+import (
+	"testing"
+
+	"github.com/distribution/reference"
+)
+
+func FuzzParseManifestPath(f *testing.F) {
+	seeds := []string{
+		"/v2/ubuntu/manifests/latest",
+		"/v2/library/ubuntu/manifests/sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+		"/v2/registry.example.com:5000/org/app/manifests/v1.0",
+		"/v2/ubuntu:latest/manifests/other",
+		"/v2/ubuntu@sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a/manifests/latest",
+		"/v2/UPPER/manifests/latest",
+		"/v2//manifests/latest",
+		"/v2/ubuntu/manifests/\xff",
+	}
+	for _, s := range seeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, path string) {
+		ref, ok := parseManifestPath(path)
+		if !ok {
+			return
+		}
+		_, tagged := ref.(reference.Tagged)
+		_, digested := ref.(reference.Digested)
+		if tagged == digested {
+			t.Fatalf("reference %q must be either tagged or digested", ref)
+		}
+		parsed, err := reference.ParseNormalizedNamed(ref.String())
+		if err != nil {
+			t.Fatalf("parse reference %q: %v", ref, err)
+		}
+		if parsed.String() != ref.String() {
+			t.Fatalf("reference %q parsed as %q", ref, parsed)
+		}
+	})
+}
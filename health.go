@@ -0,0 +1,59 @@
+package unregistry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/psviderski/unregistry/pkg/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// readinessTimeout is how long the readiness check waits for the image store of the backend to respond.
+const readinessTimeout = 5 * time.Second
+
+// healthHandler wraps the registry handler to serve the liveness and readiness probes:
+//   - /healthz responds with 200 OK as long as the registry serves requests.
+//   - /readyz responds with 200 OK if containerd responds to a namespace list call within readinessTimeout, and with
+//     503 Service Unavailable otherwise, so that a wedged containerd socket is detected rather than the registry
+//     silently failing every request. The cause of a failure is logged rather than returned.
+//
+// The probes aren't authenticated or rate limited and don't count as registry activity.
+func (r *Registry) healthHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		switch req.URL.Path {
+		case "/healthz":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = fmt.Fprintln(w, "ok")
+		case "/readyz":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			if err := r.checkBackend(req.Context()); err != nil {
+				logrus.WithError(err).Warn("Readiness check failed.")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = fmt.Fprintln(w, "not ready")
+				return
+			}
+			_, _ = fmt.Fprintln(w, "ok")
+		default:
+			next.ServeHTTP(w, req)
+		}
+	})
+}
+
+// checkBackend verifies that the image store of the storage backend, e.g. containerd, responds within
+// readinessTimeout. Backends that can't check their image store are always ready.
+func (r *Registry) checkBackend(ctx context.Context) error {
+	checker, ok := r.backend.(storage.HealthChecker)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	return checker.CheckHealth(ctx)
+}
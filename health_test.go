@@ -0,0 +1,35 @@
+package unregistry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+)
+
+// unhealthyBackend is a storage backend which image store doesn't respond.
+type unhealthyBackend struct {
+	distribution.Namespace
+}
+
+func (unhealthyBackend) CheckHealth(context.Context) error {
+	return errors.New("connect to containerd at /run/containerd/containerd.sock: permission denied")
+}
+
+func TestReadinessHidesCause(t *testing.T) {
+	r := &Registry{backend: unhealthyBackend{}}
+	handler := r.healthHandler(http.NotFoundHandler())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	if body := rec.Body.String(); strings.Contains(body, "containerd") {
+		t.Fatalf("expected the response not to reveal the cause, got %q", body)
+	}
+}
@@ -0,0 +1,217 @@
+package unregistry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/sirupsen/logrus"
+)
+
+// leaseNotFoundText is the error containerd returns when content is written or committed with a lease that no longer
+// exists, e.g. the upload lease expired and was deleted with the partial content.
+const leaseNotFoundText = "lease does not exist"
+
+// manifestPathRegexp matches the path of the manifest endpoint: /v2/<name>/manifests/<reference>
+var manifestPathRegexp = regexp.MustCompile(`^/v2/(.+)/manifests/([^/]+)$`)
+
+// parseManifestPath returns the normalized image reference of the manifest requested at the URL path, e.g.
+// "docker.io/library/ubuntu:latest" for "/v2/ubuntu/manifests/latest" or a digested reference if the manifest is
+// requested by digest. ok is false if the path isn't a manifest path or the repository name or the reference is
+// invalid. The path comes from untrusted clients before the registry app validates it, so the repository must be
+// a plain name without a tag or digest which would otherwise be silently mixed into the reference.
+func parseManifestPath(path string) (ref reference.Named, ok bool) {
+	m := manifestPathRegexp.FindStringSubmatch(path)
+	if m == nil {
+		return nil, false
+	}
+	named, err := reference.ParseNormalizedNamed(m[1])
+	if err != nil || !reference.IsNameOnly(named) {
+		return nil, false
+	}
+	if dgst, dErr := digest.Parse(m[2]); dErr == nil {
+		ref, err = reference.WithDigest(named, dgst)
+	} else {
+		ref, err = reference.WithTag(named, m[2])
+	}
+	if err != nil {
+		return nil, false
+	}
+	return ref, true
+}
+
+// parseManifestPathTag is like parseManifestPath but ok is false if the manifest isn't requested by tag.
+func parseManifestPathTag(path string) (reference.NamedTagged, bool) {
+	ref, ok := parseManifestPath(path)
+	if !ok {
+		return nil, false
+	}
+	tagged, ok := ref.(reference.NamedTagged)
+	return tagged, ok
+}
+
+// errorHintsHandler wraps the registry handler to add a remediation hint to the "detail.hint" field of error
+// responses for common failures. Clients such as docker-pussh can print the hint verbatim instead of retrying
+// a cryptic error. The hint is also logged so that it can be found in the registry logs. Hints are derived only from
+// the error itself so they don't reveal anything about the host beyond the failed request.
+func (r *Registry) errorHintsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// HEAD responses don't have a body to add a hint to.
+		if !strings.HasPrefix(req.URL.Path, "/v2/") || req.Method == http.MethodHead {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		hw := &hintResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(hw, req)
+		if hw.body == nil {
+			return
+		}
+
+		body := hw.body.Bytes()
+		if withHints, ok := r.addErrorHints(req, body); ok {
+			body = withHints
+		}
+		w.WriteHeader(hw.status)
+		_, _ = w.Write(body)
+	})
+}
+
+// errorResponse is the JSON body of a registry error response.
+type errorResponse struct {
+	Errors []struct {
+		Code    string          `json:"code"`
+		Message string          `json:"message"`
+		Detail  json.RawMessage `json:"detail,omitempty"`
+	} `json:"errors"`
+}
+
+// addErrorHints returns the error response body with the hints added to the errors that have one. It returns false
+// if no hints were added.
+func (r *Registry) addErrorHints(req *http.Request, body []byte) ([]byte, bool) {
+	var resp errorResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, false
+	}
+
+	added := false
+	for i, e := range resp.Errors {
+		hint := errorHint(e.Message + " " + string(e.Detail))
+		if hint == "" {
+			continue
+		}
+		logrus.WithFields(logrus.Fields{
+			"url":  req.URL.Path,
+			"code": e.Code,
+			"hint": hint,
+		}).Warn("Request failed with a known problem.")
+
+		// Keep the original detail as the hint cause unless it's already an object the hint can be added to.
+		detail := map[string]any{}
+		if len(e.Detail) > 0 && json.Unmarshal(e.Detail, &detail) != nil {
+			detail = map[string]any{"cause": e.Detail}
+		}
+		detail["hint"] = hint
+
+		raw, err := json.Marshal(detail)
+		if err != nil {
+			continue
+		}
+		resp.Errors[i].Detail = raw
+		added = true
+	}
+	if !added {
+		return nil, false
+	}
+
+	withHints, err := json.Marshal(resp)
+	if err != nil {
+		return nil, false
+	}
+	return withHints, true
+}
+
+// errorHint returns a remediation hint for the registry error with the given text (message and detail) if it's caused
+// by a common failure. Otherwise, it returns an empty string.
+func errorHint(text string) string {
+	switch {
+	case strings.Contains(text, "no space left on device"):
+		return "The remote host has run out of disk space. Free up space, for example, with " +
+			"'docker system prune', and retry."
+	case strings.Contains(text, "unexpected commit digest"):
+		return "The uploaded content doesn't match its digest, likely because it was corrupted in transit. " +
+			"Retry the push and check the network connection between the hosts if it keeps failing."
+	case strings.Contains(text, leaseNotFoundText):
+		return "The upload expired before it was completed and its data was removed by containerd garbage " +
+			"collection. Retry the push."
+	}
+	return ""
+}
+
+// namespaceHint returns a hint if the image is not found in the configured containerd namespace but exists in other
+// namespaces. It lists all containerd namespaces and reveals their names, so it's only used by the admin API rather
+// than for every unknown manifest requested by registry clients.
+func (r *Registry) namespaceHint(ctx context.Context, ref reference.Named) string {
+	// The Docker daemon uses a single image store.
+	if r.client == nil {
+		return ""
+	}
+
+	found, err := containerd.ImageNamespaces(ctx, r.client, ref.String())
+	if err != nil {
+		logrus.WithError(err).Debug("Failed to find image in other containerd namespaces.")
+		return ""
+	}
+	if len(found) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("The image is not found in containerd namespace '%s' used by unregistry but exists "+
+		"in namespace '%s'. Run unregistry with '--namespace %s' to use it.",
+		r.cfg.ContainerdNamespace, strings.Join(found, "', '"), found[0])
+}
+
+// hintResponseWriter buffers the body of JSON error responses so that hints can be added to it before it's written.
+type hintResponseWriter struct {
+	http.ResponseWriter
+	status int
+	// body is the buffered error response body. It's nil if the response is not buffered.
+	body *bytes.Buffer
+}
+
+func (w *hintResponseWriter) WriteHeader(status int) {
+	if status < 400 || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	w.body = &bytes.Buffer{}
+	w.Header().Del("Content-Length")
+}
+
+func (w *hintResponseWriter) Write(b []byte) (int, error) {
+	if w.body != nil {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *hintResponseWriter) Flush() {
+	if w.body != nil {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to access the underlying response writer.
+func (w *hintResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
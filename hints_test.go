@@ -0,0 +1,33 @@
+package unregistry
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestErrorHint(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "disk full", text: `unknown error "write /var/lib/containerd/ingest/data: no space left on device"`,
+			want: "run out of disk space"},
+		{name: "digest mismatch", text: `unknown error "unexpected commit digest sha256:aaa, expected sha256:bbb"`,
+			want: "doesn't match its digest"},
+		{name: "expired lease", text: `unknown error "lease does not exist: not found"`, want: "upload expired"},
+		{name: "other not found", text: `blob unknown to registry "content sha256:aaa not found, release lease"`},
+		{name: "unknown manifest", text: "manifest unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := errorHint(tt.text)
+			if tt.want == "" && got != "" {
+				t.Fatalf("expected no hint, got %q", got)
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Fatalf("expected a hint containing %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package unregistry
+
+import "net/http"
+
+// serverProtocols returns the protocols the registry server accepts. Besides HTTP/1.1, HTTP/2 is negotiated over TLS,
+// and cleartext HTTP/2 (h2c) is accepted from clients with prior knowledge so that many parallel blob transfers can be
+// multiplexed over a single connection, e.g. an SSH port forward, instead of opening a connection per transfer.
+func serverProtocols(cfg Config) *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	if !cfg.DisableHTTP2 {
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	return protocols
+}
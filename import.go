@@ -0,0 +1,142 @@
+package unregistry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/reference"
+	"github.com/psviderski/unregistry/internal/archive"
+	"github.com/psviderski/unregistry/internal/events"
+	"github.com/psviderski/unregistry/internal/metadata"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/sirupsen/logrus"
+)
+
+// importPath is the path of the endpoint importing image tarballs. It's served on the admin socket and, if enabled,
+// on the registry listener.
+const importPath = "/api/images/import"
+
+// importHandler serves image imports at POST /api/images/import on the registry listener to load image tarballs
+// through the registry port, e.g. on air-gapped hosts only reachable through it. The request is authorized like
+// the pushes. Other requests are passed to the next handler.
+func (r *Registry) importHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != importPath {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeOCIError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "only POST is supported")
+			return
+		}
+		if r.accessController != nil {
+			if _, err := r.accessController.Authorized(req); err != nil {
+				if challenge, ok := err.(auth.Challenge); ok {
+					challenge.SetHeaders(req, w)
+					writeOCIError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+					return
+				}
+				logrus.WithError(err).Error("Failed to authorize request.")
+				writeOCIError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+				return
+			}
+		}
+		r.importImagesHandler(w, req)
+	})
+}
+
+// importImagesHandler writes the images from the OCI layout or "docker save" tarball in the request body to
+// the containerd store the same way pushing them does. The body may be compressed with the content coding in
+// the Content-Encoding header. The optional "repo" query parameter is the repository to import the images named only
+// with a tag, or not named at all, to.
+func (r *Registry) importImagesHandler(w http.ResponseWriter, req *http.Request) {
+	if r.client == nil {
+		http.Error(w, fmt.Sprintf("importing images is not supported by the '%s' backend", r.cfg.Backend),
+			http.StatusNotImplemented)
+		return
+	}
+	// Imports don't go through the manifest pushes that record dry runs, check signatures, enforce quotas, and route
+	// images to other namespaces.
+	switch {
+	case r.dryRun != nil:
+		http.Error(w, "importing images is not supported in dry-run mode", http.StatusNotImplemented)
+		return
+	case len(r.cfg.SignatureKeys) > 0:
+		http.Error(w, "importing images is not allowed when signatures are required", http.StatusForbidden)
+		return
+	case r.cfg.RepoQuota != "":
+		http.Error(w, "importing images is not allowed when the repository quota is enforced", http.StatusForbidden)
+		return
+	case len(r.cfg.NamespaceRoutes) > 0 || r.cfg.NamespaceAnnotation != "":
+		http.Error(w, "importing images is not allowed when images are routed to other namespaces",
+			http.StatusForbidden)
+		return
+	}
+
+	opts := containerd.ImportOptions{Filter: r.repoFilter}
+	if repo := req.URL.Query().Get("repo"); repo != "" {
+		named, err := reference.ParseNormalizedNamed(repo)
+		if err != nil || !reference.IsNameOnly(named) {
+			http.Error(w, fmt.Sprintf("invalid repository '%s'", repo), http.StatusBadRequest)
+			return
+		}
+		opts.Repository = named
+	}
+	if r.cfg.Unpack {
+		opts.UnpackSnapshotter = r.cfg.Snapshotter
+		opts.UnpackPlatform = r.platform
+	}
+
+	body, err := archive.NewReader(req.Body, req.Header.Get("Content-Encoding"))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, archive.ErrUnsupportedEncoding) {
+			status = http.StatusUnsupportedMediaType
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer body.Close()
+
+	imported, err := containerd.ImportImages(req.Context(), r.client, body, opts)
+	// Report the images created before a failure too as they are in the store.
+	for _, img := range imported {
+		logrus.WithFields(logrus.Fields{
+			"image":  img.Image,
+			"digest": img.Digest,
+		}).Info("Imported image.")
+		if !strings.Contains(img.Image, "@") {
+			if err := metadata.RecordTag(r.metadata, img.Image, img.Digest); err != nil {
+				// The tag history is informational so failing to record it shouldn't fail the import.
+				logrus.WithField("image", img.Image).WithError(err).Warn("Failed to record tag history.")
+			}
+		}
+		e := events.Event{
+			Type:         events.TypePush,
+			Image:        img.Image,
+			Digest:       img.Digest,
+			MediaType:    img.MediaType,
+			ArtifactType: img.ArtifactType,
+			RemoteAddr:   remoteIP(req),
+		}
+		e.User = r.requestUser(req)
+		r.events.Publish(e)
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, containerd.ErrInvalidArchive):
+			status = http.StatusBadRequest
+		case errors.Is(err, containerd.ErrImportDenied):
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"images": imported})
+}
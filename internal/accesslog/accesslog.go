@@ -0,0 +1,137 @@
+// Package accesslog writes a structured log line per registry request to a sink separate from the application logs.
+package accesslog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Fields are the names of all fields an access log entry can have in the order they're documented.
+var Fields = []string{
+	"remote", "user", "method", "path", "status", "repo", "digest", "bytes_in", "bytes_out", "duration", "user_agent",
+}
+
+// Entry describes a served registry request.
+type Entry struct {
+	Time time.Time
+	// Remote is the IP address of the client.
+	Remote string
+	// User is the authenticated user name. It's empty if authentication is disabled or the request wasn't authorized.
+	User   string
+	Method string
+	Path   string
+	Status int
+	// Repo is the repository name the request is for if any.
+	Repo string
+	// Digest is the digest of the requested or uploaded manifest or blob if known.
+	Digest string
+	// BytesIn is the number of bytes read from the request body.
+	BytesIn int64
+	// BytesOut is the number of bytes written to the response body.
+	BytesOut  int64
+	Duration  time.Duration
+	UserAgent string
+}
+
+// Logger writes access log entries with the configured fields. It's safe for concurrent use and a nil Logger discards
+// all entries.
+type Logger struct {
+	log    *logrus.Logger
+	fields []string
+	w      io.Writer
+}
+
+// Validate checks that the format is either "json" or "text" and the fields are known Fields.
+func Validate(format string, fields []string) error {
+	if format != "json" && format != "text" {
+		return fmt.Errorf("invalid access log format: '%s'; expected 'json' or 'text'", format)
+	}
+	for _, f := range fields {
+		if !slices.Contains(Fields, f) {
+			return fmt.Errorf("invalid access log field: '%s'; expected one of: %s", f, strings.Join(Fields, ", "))
+		}
+	}
+	return nil
+}
+
+// Open creates a logger that writes to stdout if the sink is "-" or "stdout", otherwise it appends to the file at
+// the sink path creating it if needed. The format is either "json" or "text". Only the given fields are logged, or all
+// Fields if empty.
+func Open(sink, format string, fields []string) (*Logger, error) {
+	if err := Validate(format, fields); err != nil {
+		return nil, err
+	}
+	var formatter logrus.Formatter = &logrus.JSONFormatter{}
+	if format == "text" {
+		formatter = &logrus.TextFormatter{DisableColors: true, FullTimestamp: true}
+	}
+	if len(fields) == 0 {
+		fields = Fields
+	}
+
+	var w io.Writer = os.Stdout
+	if sink != "-" && sink != "stdout" {
+		f, err := os.OpenFile(sink, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+		if err != nil {
+			return nil, fmt.Errorf("open access log file: %w", err)
+		}
+		w = f
+	}
+
+	// The access log has its own logger so that it doesn't depend on the application log level.
+	log := logrus.New()
+	log.SetOutput(w)
+	log.SetFormatter(formatter)
+	log.SetLevel(logrus.InfoLevel)
+	return &Logger{log: log, fields: fields, w: w}, nil
+}
+
+// Log writes the entry. The entry time is set to now if not set.
+func (l *Logger) Log(e Entry) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	values := map[string]any{
+		"remote":     e.Remote,
+		"user":       e.User,
+		"method":     e.Method,
+		"path":       e.Path,
+		"status":     e.Status,
+		"repo":       e.Repo,
+		"digest":     e.Digest,
+		"bytes_in":   e.BytesIn,
+		"bytes_out":  e.BytesOut,
+		"duration":   e.Duration.Seconds(),
+		"user_agent": e.UserAgent,
+	}
+	fields := make(logrus.Fields, len(l.fields))
+	for _, f := range l.fields {
+		// Omit the empty string fields that don't apply to the request, e.g. the repo of the API version check.
+		if v, ok := values[f].(string); ok && v == "" {
+			continue
+		}
+		fields[f] = values[f]
+	}
+	l.log.WithTime(e.Time).WithFields(fields).Info("Request served.")
+}
+
+// Close closes the underlying file unless it's stdout.
+func (l *Logger) Close() error {
+	if l == nil || l.w == os.Stdout {
+		return nil
+	}
+	if c, ok := l.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
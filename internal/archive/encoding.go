@@ -0,0 +1,121 @@
+// Package archive provides the building blocks for transferring whole images as archives through the admin API.
+package archive
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encoding is an HTTP content coding used to compress archives on the wire.
+type Encoding string
+
+const (
+	EncodingIdentity Encoding = "identity"
+	EncodingGzip     Encoding = "gzip"
+	EncodingZstd     Encoding = "zstd"
+)
+
+// ErrUnsupportedEncoding is returned for a content coding other than gzip, zstd, or identity.
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// supportedEncodings are the content codings in the order of preference. zstd compresses image layers faster and
+// better than gzip.
+var supportedEncodings = []Encoding{EncodingZstd, EncodingGzip, EncodingIdentity}
+
+// NegotiateEncoding returns the most preferred content coding acceptable according to the Accept-Encoding header
+// value. It returns EncodingIdentity if the header is empty or no supported coding is acceptable.
+func NegotiateEncoding(acceptEncoding string) Encoding {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return EncodingIdentity
+	}
+
+	qualities := make(map[Encoding]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = parsed
+			}
+		}
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		qualities[Encoding(coding)] = q
+	}
+
+	best, bestQ := EncodingIdentity, 0.0
+	for _, enc := range supportedEncodings {
+		q, ok := qualities[enc]
+		if !ok {
+			q = wildcard
+			// Identity is acceptable unless explicitly excluded.
+			if enc == EncodingIdentity && q < 0 {
+				q = 0.001
+			}
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// NewWriter returns a writer that compresses data written to it with the content coding and writes it to w.
+// The writer must be closed to flush the compressed data. Closing it doesn't close w.
+func NewWriter(w io.Writer, enc Encoding) (io.WriteCloser, error) {
+	switch enc {
+	case EncodingIdentity, "":
+		return nopWriteCloser{w}, nil
+	case EncodingGzip:
+		return gzip.NewWriter(w), nil
+	case EncodingZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("create zstd writer: %w", err)
+		}
+		return zw, nil
+	default:
+		return nil, fmt.Errorf("%w: '%s'", ErrUnsupportedEncoding, enc)
+	}
+}
+
+// NewReader returns a reader that decompresses data read from r encoded with the content coding from
+// the Content-Encoding header value. Closing it doesn't close r.
+func NewReader(r io.Reader, contentEncoding string) (io.ReadCloser, error) {
+	switch enc := Encoding(strings.ToLower(strings.TrimSpace(contentEncoding))); enc {
+	case EncodingIdentity, "":
+		return io.NopCloser(r), nil
+	case EncodingGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("create gzip reader: %w", err)
+		}
+		return gr, nil
+	case EncodingZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("create zstd reader: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("%w: '%s'", ErrUnsupportedEncoding, contentEncoding)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Action is the audited registry operation.
+type Action string
+
+const (
+	// ActionManifestPut is recorded when a client pushes a manifest.
+	ActionManifestPut Action = "manifest.put"
+	// ActionManifestGet is recorded when a client pulls a manifest.
+	ActionManifestGet Action = "manifest.get"
+	// ActionManifestDelete is recorded when a client deletes a manifest or a tag.
+	ActionManifestDelete Action = "manifest.delete"
+	// ActionBlobCommit is recorded when a client completes a blob upload.
+	ActionBlobCommit Action = "blob.commit"
+	// ActionBlobDelete is recorded when a client deletes a blob.
+	ActionBlobDelete Action = "blob.delete"
+	// ActionImageImport is recorded when a client imports an image tarball through the registry listener.
+	ActionImageImport Action = "image.import"
+)
+
+// Result is the outcome of the audited operation.
+type Result string
+
+const (
+	ResultSuccess Result = "success"
+	ResultFailure Result = "failure"
+)
+
+// Record is a single audit record written as a JSON line.
+type Record struct {
+	Time   time.Time `json:"time"`
+	Action Action    `json:"action"`
+	// User is the authenticated user name. It's empty if authentication is disabled or the credentials are invalid.
+	User string `json:"user,omitempty"`
+	// RemoteAddr is the IP address of the client.
+	RemoteAddr string `json:"remoteAddr"`
+	Repo       string `json:"repo"`
+	// Tag is set if a manifest is pushed or pulled by tag.
+	Tag    string        `json:"tag,omitempty"`
+	Digest digest.Digest `json:"digest,omitempty"`
+	// Size is the size of the manifest or blob in bytes. It's 0 if unknown, e.g. when the operation failed.
+	Size   int64  `json:"size,omitempty"`
+	Status int    `json:"status"`
+	Result Result `json:"result"`
+}
+
+// Logger writes audit records to a sink separate from the registry logs. It's safe for concurrent use and a nil
+// Logger discards all records.
+type Logger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	w   io.Writer
+}
+
+// Open creates a logger that writes to stdout if the sink is "-" or "stdout", otherwise it appends to the file
+// at the sink path creating it if needed.
+func Open(sink string) (*Logger, error) {
+	if sink == "-" || sink == "stdout" {
+		return NewLogger(os.Stdout), nil
+	}
+	// Audit records may reveal who deployed what so the file is only readable by the owner.
+	f, err := os.OpenFile(sink, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log file: %w", err)
+	}
+	return NewLogger(f), nil
+}
+
+// NewLogger creates a logger that writes records to w. If w is an io.Closer, it's closed when the logger is closed.
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{
+		enc: json.NewEncoder(w),
+		w:   w,
+	}
+}
+
+// Log writes the record. The record time is set to now if not set.
+func (l *Logger) Log(r Record) error {
+	if l == nil {
+		return nil
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(r); err != nil {
+		return fmt.Errorf("write audit record: %w", err)
+	}
+	return nil
+}
+
+// Close closes the underlying writer unless it's stdout.
+func (l *Logger) Close() error {
+	if l == nil || l.w == os.Stdout {
+		return nil
+	}
+	if c, ok := l.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
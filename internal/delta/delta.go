@@ -0,0 +1,190 @@
+// Package delta implements reading binary deltas of blobs against similar blobs that already exist on the host so
+// that a client only has to transfer the difference when pushing a slightly changed layer.
+//
+// A delta is a zstd-compressed stream of operations that reconstruct the target blob: copying a range of the base blob
+// or inserting literal data. Clients are expected to find the matching ranges with a rolling checksum over fixed-size
+// blocks of the base like rsync does. The reconstructed blob is verified against its digest by the registry so
+// a corrupted or mismatching delta can't produce a wrong blob. Only the registry side is implemented, none of the
+// bundled clients encode deltas.
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// BaseHeader is the request header with the digest of the blob in the content store the uploaded data is
+	// a delta against.
+	BaseHeader = "Unregistry-Delta-Base"
+	// SizeHeader is the request header with the size of the target blob reconstructed from the uploaded delta.
+	SizeHeader = "Unregistry-Delta-Size"
+	// maxDecoderMemory is the maximum memory the zstd decoder may allocate for a delta so that a crafted frame header
+	// can't make it allocate an arbitrarily large window.
+	maxDecoderMemory = 64 << 20
+)
+
+// magic identifies the delta format version.
+var magic = []byte("UNRDELTA1")
+
+// Operation codes of the delta stream.
+const (
+	opCopy = 'C'
+	opData = 'D'
+	opEnd  = 'E'
+)
+
+// ErrInvalidDelta is returned when reading a malformed delta.
+var ErrInvalidDelta = errors.New("invalid delta")
+
+// NewReader returns a reader of the target blob of the given size reconstructed from the delta against the base of
+// the given size. Reading fails with ErrInvalidDelta as soon as the operations would reconstruct a blob of another
+// size so that a delta can't expand to more data than the client declared.
+func NewReader(delta io.Reader, base io.ReaderAt, baseSize, size int64) (io.ReadCloser, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("%w: negative target size", ErrInvalidDelta)
+	}
+	zr, err := zstd.NewReader(delta, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxDecoderMemory))
+	if err != nil {
+		return nil, fmt.Errorf("create zstd reader: %w", err)
+	}
+	r := &reader{zr: zr, ops: bufio.NewReader(zr), base: base, baseSize: baseSize, remaining: size}
+
+	header := make([]byte, len(magic))
+	if _, err = io.ReadFull(r.ops, header); err != nil || !bytes.Equal(header, magic) {
+		zr.Close()
+		return nil, fmt.Errorf("%w: unknown format", ErrInvalidDelta)
+	}
+	return r, nil
+}
+
+// reader reconstructs the target blob by applying the delta operations.
+type reader struct {
+	zr       *zstd.Decoder
+	ops      *bufio.Reader
+	base     io.ReaderAt
+	baseSize int64
+	// remaining is the size of the target blob that isn't covered by the operations read so far.
+	remaining int64
+	// current is the data of the operation being applied.
+	current io.Reader
+	done    bool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for {
+		if r.done {
+			return 0, io.EOF
+		}
+		if r.current != nil {
+			n, err := r.current.Read(p)
+			if errors.Is(err, io.EOF) {
+				r.current = nil
+				err = nil
+			}
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// next reads the next operation.
+func (r *reader) next() error {
+	op, err := r.ops.ReadByte()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidDelta, unexpectedEOF(err))
+	}
+
+	switch op {
+	case opCopy:
+		offset, err := binary.ReadUvarint(r.ops)
+		if err != nil {
+			return fmt.Errorf("%w: read copy offset: %w", ErrInvalidDelta, unexpectedEOF(err))
+		}
+		length, err := binary.ReadUvarint(r.ops)
+		if err != nil {
+			return fmt.Errorf("%w: read copy length: %w", ErrInvalidDelta, unexpectedEOF(err))
+		}
+		if offset > uint64(r.baseSize) || length > uint64(r.baseSize)-offset {
+			return fmt.Errorf("%w: copy range out of base bounds", ErrInvalidDelta)
+		}
+		if err = r.consume(length); err != nil {
+			return err
+		}
+		r.current = io.NewSectionReader(r.base, int64(offset), int64(length))
+	case opData:
+		length, err := binary.ReadUvarint(r.ops)
+		if err != nil {
+			return fmt.Errorf("%w: read data length: %w", ErrInvalidDelta, unexpectedEOF(err))
+		}
+		if err = r.consume(length); err != nil {
+			return err
+		}
+		r.current = &strictLimitedReader{r: r.ops, n: length}
+	case opEnd:
+		if r.remaining > 0 {
+			return fmt.Errorf("%w: reconstructed blob is %d bytes shorter than the target size", ErrInvalidDelta,
+				r.remaining)
+		}
+		r.done = true
+	default:
+		return fmt.Errorf("%w: unknown operation %q", ErrInvalidDelta, op)
+	}
+	return nil
+}
+
+// consume accounts for the length of the next operation failing if it exceeds the target size.
+func (r *reader) consume(length uint64) error {
+	if length > uint64(r.remaining) {
+		return fmt.Errorf("%w: reconstructed blob exceeds the target size", ErrInvalidDelta)
+	}
+	r.remaining -= int64(length)
+	return nil
+}
+
+func (r *reader) Close() error {
+	r.zr.Close()
+	return nil
+}
+
+// strictLimitedReader reads exactly n bytes failing with io.ErrUnexpectedEOF if the underlying reader ends earlier.
+type strictLimitedReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (l *strictLimitedReader) Read(p []byte) (int, error) {
+	if l.n == 0 {
+		return 0, io.EOF
+	}
+	if uint64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= uint64(n)
+	if errors.Is(err, io.EOF) && l.n > 0 {
+		return n, fmt.Errorf("%w: %w", ErrInvalidDelta, io.ErrUnexpectedEOF)
+	}
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return n, err
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,138 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// randomBytes returns n pseudo-random bytes that don't compress so that copies from the base are necessary to keep
+// a delta small.
+func randomBytes(rnd *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	rnd.Read(b)
+	return b
+}
+
+// roundTrip encodes the target against the base, reconstructs it from the delta, and returns the delta.
+func roundTrip(t *testing.T, target, base []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := encode(&buf, bytes.NewReader(target), bytes.NewReader(base), int64(len(base))); err != nil {
+		t.Fatal(err)
+	}
+	delta := buf.Bytes()
+
+	r, err := NewReader(bytes.NewReader(delta), bytes.NewReader(base), int64(len(base)), int64(len(target)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, target) {
+		t.Fatalf("reconstructed target of %d bytes doesn't match the original of %d bytes", len(got), len(target))
+	}
+	return delta
+}
+
+func TestRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	base := randomBytes(rnd, 64*blockSize+123)
+
+	// The target inserts data into the base, replaces some of its blocks, and drops its tail.
+	target := bytes.Join([][]byte{base[:20*blockSize+7], randomBytes(rnd, 100), base[20*blockSize+7 : 40*blockSize],
+		randomBytes(rnd, 3*blockSize), base[45*blockSize : 60*blockSize]}, nil)
+
+	tests := []struct {
+		name   string
+		target []byte
+		base   []byte
+	}{
+		{name: "similar", target: target, base: base},
+		{name: "identical", target: base, base: base},
+		{name: "empty target", target: []byte{}, base: base},
+		{name: "empty base", target: target, base: []byte{}},
+		{name: "shorter than a block", target: []byte("hello"), base: []byte("hello world")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roundTrip(t, tt.target, tt.base)
+		})
+	}
+
+	delta := roundTrip(t, target, base)
+	if len(delta) > len(target)/4 {
+		t.Fatalf("expected a delta of a similar blob to be much smaller than the blob, got %d of %d bytes",
+			len(delta), len(target))
+	}
+}
+
+func TestNewReaderInvalidDelta(t *testing.T) {
+	base := []byte("base")
+	if _, err := NewReader(bytes.NewReader([]byte("garbage")), bytes.NewReader(base), 4, 4); err == nil {
+		t.Fatal("expected an error for a delta that isn't zstd-compressed")
+	}
+
+	// A delta against a larger base refers to ranges out of the bounds of a smaller one.
+	rnd := rand.New(rand.NewSource(2))
+	largeBase := randomBytes(rnd, 4*blockSize)
+	var buf bytes.Buffer
+	if err := encode(&buf, bytes.NewReader(largeBase), bytes.NewReader(largeBase), int64(len(largeBase))); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(&buf, bytes.NewReader(base), int64(len(base)), int64(len(largeBase)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err = io.ReadAll(r); !errors.Is(err, ErrInvalidDelta) {
+		t.Fatalf("expected ErrInvalidDelta for a copy out of the base bounds, got %v", err)
+	}
+}
+
+func TestNewReaderTargetSize(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	base := randomBytes(rnd, 8*blockSize)
+	target := append(slices.Clone(base), randomBytes(rnd, 100)...)
+	var buf bytes.Buffer
+	if err := encode(&buf, bytes.NewReader(target), bytes.NewReader(base), int64(len(base))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		size int64
+		// wantRead is the maximum number of bytes read before the size mismatch is detected.
+		wantRead int
+	}{
+		{name: "larger than declared", size: int64(len(target)) - 1, wantRead: len(base)},
+		{name: "shorter than declared", size: int64(len(target)) + 1, wantRead: len(target)},
+		{name: "empty declared", size: 0, wantRead: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(buf.Bytes()), bytes.NewReader(base), int64(len(base)), tt.size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if !errors.Is(err, ErrInvalidDelta) {
+				t.Fatalf("expected ErrInvalidDelta, got %v", err)
+			}
+			if len(got) > tt.wantRead {
+				t.Fatalf("expected at most %d bytes read before the error, got %d", tt.wantRead, len(got))
+			}
+		})
+	}
+
+	if _, err := NewReader(bytes.NewReader(buf.Bytes()), bytes.NewReader(base), int64(len(base)), -1); err == nil {
+		t.Fatal("expected an error for a negative target size")
+	}
+}
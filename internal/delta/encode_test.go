@@ -0,0 +1,226 @@
+package delta
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// blockSize is the size of the base blob blocks matched in the target blob.
+	blockSize = 4 << 10
+	// maxLiteral is the maximum size of a literal data operation.
+	maxLiteral = 1 << 20
+)
+
+// encode writes the delta of the target against the base of the given size to w. It's a reference encoder used to
+// test the reader.
+func encode(w io.Writer, target io.Reader, base io.ReaderAt, baseSize int64) error {
+	index, err := indexBase(base, baseSize)
+	if err != nil {
+		return err
+	}
+
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return fmt.Errorf("create zstd writer: %w", err)
+	}
+	e := &encoder{w: bufio.NewWriter(zw), index: index}
+	if err = e.encode(target); err != nil {
+		_ = zw.Close()
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return fmt.Errorf("close zstd writer: %w", err)
+	}
+	return nil
+}
+
+// block is a block of the base blob.
+type block struct {
+	offset int64
+	strong [sha256.Size]byte
+}
+
+// indexBase returns the blocks of the base indexed by their rolling checksum. A trailing partial block isn't indexed.
+func indexBase(base io.ReaderAt, size int64) (map[uint32][]block, error) {
+	index := make(map[uint32][]block)
+	r := bufio.NewReaderSize(io.NewSectionReader(base, 0, size), 1<<20)
+	buf := make([]byte, blockSize)
+	for offset := int64(0); offset+blockSize <= size; offset += blockSize {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, fmt.Errorf("read base: %w", err)
+		}
+		weak := newRollingSum(buf).sum()
+		index[weak] = append(index[weak], block{offset: offset, strong: sha256.Sum256(buf)})
+	}
+	return index, nil
+}
+
+// encoder finds the blocks of the base in the target and writes the operations reconstructing it.
+type encoder struct {
+	w     *bufio.Writer
+	index map[uint32][]block
+
+	// copyOffset and copyLength is the pending copy operation that may be extended by the following matching blocks.
+	copyOffset int64
+	copyLength int64
+}
+
+func (e *encoder) encode(target io.Reader) error {
+	if _, err := e.w.Write(magic); err != nil {
+		return err
+	}
+
+	r := bufio.NewReaderSize(target, 1<<20)
+	// buf holds the target data that hasn't been written yet: the literal data before pos and the window at pos.
+	var buf []byte
+	pos := 0
+	eof := false
+	var rs *rollingSum
+
+	fill := func() error {
+		for !eof && len(buf)-pos < blockSize {
+			buf = slices.Grow(buf, 64<<10)
+			n, err := r.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+n]
+			if errors.Is(err, io.EOF) {
+				eof = true
+			} else if err != nil {
+				return fmt.Errorf("read target: %w", err)
+			}
+		}
+		return nil
+	}
+
+	for {
+		if err := fill(); err != nil {
+			return err
+		}
+		if len(buf)-pos < blockSize {
+			break
+		}
+		window := buf[pos : pos+blockSize]
+		if rs == nil {
+			rs = newRollingSum(window)
+		}
+
+		if offset, ok := e.match(rs.sum(), window); ok {
+			if err := e.writeData(buf[:pos]); err != nil {
+				return err
+			}
+			e.addCopy(offset, blockSize)
+			buf = buf[pos+blockSize:]
+			pos = 0
+			rs = nil
+			continue
+		}
+
+		if pos+blockSize < len(buf) {
+			rs.roll(buf[pos], buf[pos+blockSize])
+		} else {
+			rs = nil
+		}
+		pos++
+		if pos >= maxLiteral {
+			if err := e.writeData(buf[:pos]); err != nil {
+				return err
+			}
+			buf = buf[pos:]
+			pos = 0
+		}
+	}
+
+	if err := e.writeData(buf); err != nil {
+		return err
+	}
+	e.flushCopy()
+	if err := e.w.WriteByte(opEnd); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// match returns the offset of the base block matching the window.
+func (e *encoder) match(weak uint32, window []byte) (int64, bool) {
+	candidates, ok := e.index[weak]
+	if !ok {
+		return 0, false
+	}
+	strong := sha256.Sum256(window)
+	for _, b := range candidates {
+		if b.strong == strong {
+			return b.offset, true
+		}
+	}
+	return 0, false
+}
+
+// addCopy adds a copy of the base range extending the pending copy operation if the range is contiguous.
+func (e *encoder) addCopy(offset, length int64) {
+	if e.copyLength > 0 && e.copyOffset+e.copyLength == offset {
+		e.copyLength += length
+		return
+	}
+	e.flushCopy()
+	e.copyOffset, e.copyLength = offset, length
+}
+
+func (e *encoder) flushCopy() {
+	if e.copyLength == 0 {
+		return
+	}
+	e.writeOp(opCopy, uint64(e.copyOffset), uint64(e.copyLength))
+	e.copyLength = 0
+}
+
+// writeData writes the literal data flushing the pending copy operation first.
+func (e *encoder) writeData(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	e.flushCopy()
+	e.writeOp(opData, uint64(len(data)))
+	_, err := e.w.Write(data)
+	return err
+}
+
+// writeOp writes the operation code and its arguments. Write errors are sticky in bufio.Writer and returned when
+// the writer is flushed.
+func (e *encoder) writeOp(op byte, args ...uint64) {
+	_ = e.w.WriteByte(op)
+	for _, arg := range args {
+		_, _ = e.w.Write(binary.AppendUvarint(nil, arg))
+	}
+}
+
+// rollingSum is the rsync rolling checksum of a window that can be moved by one byte in constant time.
+type rollingSum struct {
+	a, b uint32
+	n    uint32
+}
+
+func newRollingSum(window []byte) *rollingSum {
+	rs := &rollingSum{n: uint32(len(window))}
+	for i, c := range window {
+		rs.a += uint32(c)
+		rs.b += uint32(len(window)-i) * uint32(c)
+	}
+	return rs
+}
+
+// roll moves the window by one byte removing out and adding in.
+func (rs *rollingSum) roll(out, in byte) {
+	rs.a += uint32(in) - uint32(out)
+	rs.b += rs.a - rs.n*uint32(out)
+}
+
+func (rs *rollingSum) sum() uint32 {
+	return (rs.a & 0xffff) | (rs.b << 16)
+}
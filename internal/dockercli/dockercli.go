@@ -0,0 +1,65 @@
+// Package dockercli runs the docker command of the local host for the Docker CLI plugins that transfer images to and
+// from remote hosts.
+package dockercli
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Output runs the docker command with the arguments and returns its combined output.
+func Output(ctx context.Context, args ...string) (string, error) {
+	output, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("docker %s: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// UsesContainerdStore reports whether Docker uses the containerd image store. Image IDs differ between
+// the containerd image store (digest of the image index or manifest) and the classic image store (digest of the image
+// config). Only the containerd image store supports multi-platform images.
+func UsesContainerdStore(ctx context.Context) bool {
+	output, err := Output(ctx, "info", "-f", "{{ .DriverStatus }}")
+	return err == nil && strings.Contains(output, "containerd.snapshotter")
+}
+
+// VMProxyNeeded reports whether Docker runs in a VM (Docker/Rancher Desktop, Colima, etc.) that can't reach a port
+// forwarded on the loopback interface of the host.
+func VMProxyNeeded(ctx context.Context) bool {
+	output, _ := Output(ctx, "info")
+	// macOS almost always needs a proxy for Docker running in a VM, however OrbStack works without it.
+	if runtime.GOOS == "darwin" {
+		return !strings.Contains(output, "OrbStack")
+	}
+	return strings.Contains(output, "Docker Desktop") || strings.Contains(output, "rancher-desktop") ||
+		strings.Contains(output, "colima")
+}
+
+// RunVMProxy runs a socat container with the name that proxies a port of the Docker VM bound to localhost to the port
+// on the host, and returns the proxy port Docker can push to or pull from. The caller removes the container.
+func RunVMProxy(ctx context.Context, name string, hostPort int) (int, error) {
+	var err error
+	for range 10 {
+		port := 55000 + rand.IntN(10536)
+		var output string
+		output, err = Output(ctx, "run", "-d", "--rm", "--name", name,
+			"-p", fmt.Sprintf("127.0.0.1:%d:5000", port),
+			"alpine/socat", "TCP-LISTEN:5000,fork,reuseaddr",
+			fmt.Sprintf("TCP-CONNECT:host.docker.internal:%d", hostPort),
+		)
+		if err == nil {
+			return port, nil
+		}
+		_, _ = Output(ctx, "rm", "-f", name)
+		// Retry with another port only if the port is already in use.
+		if !strings.Contains(strings.ToLower(output), "bind") {
+			break
+		}
+	}
+	return 0, fmt.Errorf("run proxy from Docker VM to localhost:%d: %w", hostPort, err)
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// subscriberBuffer is the size of the event channel buffer for each subscriber. Events are dropped for subscribers
+// that don't keep up so that they don't block the registry.
+const subscriberBuffer = 64
+
+// Type is the type of registry event.
+type Type string
+
+const (
+	// TypePush is published when an image is pushed, that is, its manifest is tagged.
+	TypePush Type = "push"
+	// TypeBlobUpload is published when a blob upload is completed.
+	TypeBlobUpload Type = "blob-upload"
+	// TypePull is published when a manifest is served to a client pulling an image.
+	TypePull Type = "pull"
+	// TypeDelete is published when an image is deleted, that is, its tag or manifest is removed from the image store.
+	TypeDelete Type = "delete"
+)
+
+// Event is a registry event that agents running on the same host, e.g. the uncloud daemon, can react to.
+type Event struct {
+	Type Type `json:"type"`
+	// Image is the normalized image reference, e.g. "docker.io/library/ubuntu:latest". It's empty for blob uploads.
+	// It's digest-addressed, e.g. "docker.io/library/ubuntu@sha256:...", for deleted images pushed by digest.
+	Image string `json:"image,omitempty"`
+	// Repository is the normalized repository name the blob was uploaded to. It's only set for blob uploads.
+	Repository string        `json:"repository,omitempty"`
+	Digest     digest.Digest `json:"digest"`
+	MediaType  string        `json:"mediaType"`
+	// ArtifactType is the artifact type of the manifest if the image is an OCI artifact rather than a runnable image.
+	ArtifactType string `json:"artifactType,omitempty"`
+	// Size is the size of the uploaded blob or the served manifest in bytes.
+	Size int64 `json:"size,omitempty"`
+	// RemoteAddr is the IP address of the client that pulled the image.
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// User is the authenticated user that pulled the image.
+	User string    `json:"user,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// Broker delivers published events to subscribers. It's safe for concurrent use and a nil Broker discards
+// all events.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+// NewBroker creates a broker without subscribers.
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish sends the event to all subscribers without blocking. The event time is set to now if not set.
+func (b *Broker) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// The subscriber is too slow, drop the event.
+		}
+	}
+}
+
+// Subscribed reports whether there are any subscribers, e.g. to skip collecting event details nobody receives.
+func (b *Broker) Subscribed() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers) > 0
+}
+
+// Subscribe returns a channel that receives events published after the subscription. The returned function must be
+// called to unsubscribe when the caller is no longer interested in events.
+func (b *Broker) Subscribe() (events <-chan Event, unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, subscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+
+	unsubscribe = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe
+}
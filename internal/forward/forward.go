@@ -0,0 +1,539 @@
+// Package forward implements store-and-forward delivery of pushed images to peer registries that may be unreachable
+// at the time of the push, e.g. edge devices behind flaky links. Pushed images are queued per peer and delivered with
+// retries when the peers become reachable again.
+package forward
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes/docker"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/psviderski/unregistry/internal/events"
+	"github.com/psviderski/unregistry/internal/metadata"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// pollInterval is how often the queue is checked for deliveries that are due.
+	pollInterval = 5 * time.Second
+	// minBackoff and maxBackoff bound the delay before retrying deliveries to an unreachable peer.
+	minBackoff = 5 * time.Second
+	maxBackoff = 10 * time.Minute
+)
+
+// Delivery is a pushed image pending delivery to a peer registry.
+type Delivery struct {
+	Peer string `json:"peer"`
+	// Image is the normalized image reference, e.g. "docker.io/library/ubuntu:latest".
+	Image     string        `json:"image"`
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType"`
+	Queued    time.Time     `json:"queued"`
+	// Expires is the time after which the delivery is dropped if it hasn't succeeded.
+	Expires  time.Time `json:"expires"`
+	Attempts int       `json:"attempts"`
+	// LastError is the error of the last failed attempt.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Replication states of an image on a peer registry.
+const (
+	// ReplicationDelivered means the latest push of the image has been delivered to the peer.
+	ReplicationDelivered = "delivered"
+	// ReplicationPending means the latest push of the image is queued for delivery to the peer.
+	ReplicationPending = "pending"
+	// ReplicationUnknown means the image hasn't been pushed since the queue was created or its delivery was dropped.
+	ReplicationUnknown = "unknown"
+)
+
+// Replication describes the replication state of a pushed image on a peer registry.
+type Replication struct {
+	Peer  string `json:"peer"`
+	State string `json:"state"`
+	// Digest is the digest of the latest pushed image that is delivered or pending delivery.
+	Digest    digest.Digest `json:"digest,omitempty"`
+	Queued    time.Time     `json:"queued,omitzero"`
+	Delivered time.Time     `json:"delivered,omitzero"`
+	// Attempts is the number of failed delivery attempts of a pending delivery.
+	Attempts  int    `json:"attempts,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// PeerStatus describes the delivery state of a peer registry.
+type PeerStatus struct {
+	URL string `json:"url"`
+	// Failures is the number of consecutive failed delivery attempts.
+	Failures int `json:"failures"`
+	// NextAttempt is the time of the next delivery attempt if the last one failed.
+	NextAttempt time.Time  `json:"nextAttempt,omitzero"`
+	LastError   string     `json:"lastError,omitempty"`
+	Pending     []Delivery `json:"pending"`
+}
+
+// peer is a peer registry images are delivered to.
+type peer struct {
+	url   string
+	host  string
+	hosts docker.RegistryHosts
+	// failures, nextAttempt, and lastError are guarded by Forwarder.mu.
+	failures    int
+	nextAttempt time.Time
+	lastError   string
+	// delivering is set while a delivery round to the peer is in progress.
+	delivering bool
+}
+
+// Forwarder queues the pushed images and delivers them to peer registries. A nil Forwarder is disabled.
+type Forwarder struct {
+	client *client.Client
+	store  metadata.Store
+	broker *events.Broker
+	ttl    time.Duration
+	peers  []*peer
+	// wake starts delivering the queued images without waiting for the next poll.
+	wake chan struct{}
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a forwarder delivering images pushed to the registry to the peer registry URLs, e.g.
+// "http://edge-1:5000". Credentials for a peer can be specified in the URL user info. Deliveries are dropped if they
+// don't succeed within ttl.
+func New(
+	cli *client.Client, store metadata.Store, broker *events.Broker, peerURLs []string, ttl time.Duration,
+) (*Forwarder, error) {
+	f := &Forwarder{client: cli, store: store, broker: broker, ttl: ttl, wake: make(chan struct{}, 1)}
+	for _, u := range peerURLs {
+		p, err := parsePeer(u)
+		if err != nil {
+			return nil, err
+		}
+		f.peers = append(f.peers, p)
+	}
+	return f, nil
+}
+
+// parsePeer parses the peer registry URL and creates the peer with a resolver configured for it.
+func parsePeer(rawURL string) (*peer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse peer URL '%s': %w", rawURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+		return nil, fmt.Errorf("invalid peer URL '%s': expected http(s)://host[:port]", rawURL)
+	}
+
+	var opts []docker.RegistryOpt
+	if u.Scheme == "http" {
+		opts = append(opts, docker.WithPlainHTTP(docker.MatchAllHosts))
+	}
+	if u.User != nil {
+		username := u.User.Username()
+		password, _ := u.User.Password()
+		opts = append(opts, docker.WithAuthorizer(docker.NewDockerAuthorizer(
+			docker.WithAuthCreds(func(string) (string, string, error) {
+				return username, password, nil
+			}),
+		)))
+	}
+	// Strip the credentials so that they don't leak to logs and the status API.
+	u.User = nil
+
+	return &peer{
+		url:   u.String(),
+		host:  u.Host,
+		hosts: docker.ConfigureDefaultRegistries(opts...),
+	}, nil
+}
+
+// Start starts queueing the pushed images and delivering them in the background until Stop is called.
+func (f *Forwarder) Start() {
+	if f == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+
+	pushes, unsubscribe := f.broker.Subscribe()
+	f.wg.Add(2)
+	go func() {
+		defer f.wg.Done()
+		defer unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-pushes:
+				if e.Type == events.TypePush {
+					f.enqueue(e)
+				}
+			}
+		}
+	}()
+	go func() {
+		defer f.wg.Done()
+		f.run(ctx)
+	}()
+
+	urls := make([]string, len(f.peers))
+	for i, p := range f.peers {
+		urls[i] = p.url
+	}
+	logrus.WithFields(logrus.Fields{
+		"peers": urls,
+		"ttl":   f.ttl,
+	}).Info("Forwarding pushed images to peer registries.")
+}
+
+// Stop stops the background delivery and waits for in-progress deliveries to be aborted. Pending deliveries stay
+// in the queue.
+func (f *Forwarder) Stop() {
+	if f == nil || f.cancel == nil {
+		return
+	}
+	f.cancel()
+	f.wg.Wait()
+}
+
+// enqueue queues the pushed image for delivery to all peers. A pending delivery of the same image reference is
+// replaced as only the latest pushed image needs to be delivered.
+func (f *Forwarder) enqueue(e events.Event) {
+	now := time.Now().UTC()
+	for _, p := range f.peers {
+		d := Delivery{
+			Peer:      p.url,
+			Image:     e.Image,
+			Digest:    e.Digest,
+			MediaType: e.MediaType,
+			Queued:    now,
+			Expires:   now.Add(f.ttl),
+		}
+		if err := f.store.Put(metadata.BucketDeliveries, deliveryKey(p.url, e.Image), d); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"peer":  p.url,
+				"image": e.Image,
+			}).WithError(err).Error("Failed to queue image delivery to peer registry.")
+		}
+	}
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+func deliveryKey(peer, image string) string {
+	return peer + " " + image
+}
+
+// run delivers the queued images to the peers that are due until the context is canceled.
+func (f *Forwarder) run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		f.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-f.wake:
+		}
+	}
+}
+
+// deliverDue drops the expired deliveries and starts delivering the pending images to each peer that isn't backing
+// off after a failure.
+func (f *Forwarder) deliverDue(ctx context.Context) {
+	pending, err := f.pending()
+	if err != nil {
+		logrus.WithError(err).Error("Failed to list pending image deliveries.")
+		return
+	}
+
+	now := time.Now()
+	byPeer := make(map[string][]Delivery)
+	for _, d := range pending {
+		if now.After(d.Expires) {
+			logrus.WithFields(logrus.Fields{
+				"peer":     d.Peer,
+				"image":    d.Image,
+				"attempts": d.Attempts,
+			}).Warn("Dropped image delivery to peer registry that didn't succeed before expiring.")
+			f.remove(d)
+			continue
+		}
+		byPeer[d.Peer] = append(byPeer[d.Peer], d)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, p := range f.peers {
+		deliveries := byPeer[p.url]
+		if len(deliveries) == 0 || p.delivering || now.Before(p.nextAttempt) {
+			continue
+		}
+		p.delivering = true
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.deliverAll(ctx, p, deliveries)
+		}()
+	}
+}
+
+// deliverAll delivers the images to the peer in the order they were queued. It stops at the first failure as
+// the peer is likely unreachable and backs off before the next attempt.
+func (f *Forwarder) deliverAll(ctx context.Context, p *peer, deliveries []Delivery) {
+	defer func() {
+		f.mu.Lock()
+		p.delivering = false
+		f.mu.Unlock()
+	}()
+
+	slices.SortFunc(deliveries, func(a, b Delivery) int {
+		return a.Queued.Compare(b.Queued)
+	})
+	for _, d := range deliveries {
+		log := logrus.WithFields(logrus.Fields{
+			"peer":  p.url,
+			"image": d.Image,
+		})
+		err := f.deliver(ctx, p, d)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			log.Info("Delivered image to peer registry.")
+			f.recordDelivery(d)
+			f.remove(d)
+			f.mu.Lock()
+			p.failures, p.nextAttempt, p.lastError = 0, time.Time{}, ""
+			f.mu.Unlock()
+			continue
+		}
+		if errdefs.IsNotFound(err) {
+			// The image content has been removed since the push so the delivery can never succeed.
+			log.WithError(err).Warn("Dropped image delivery to peer registry as the image content is missing.")
+			f.remove(d)
+			continue
+		}
+
+		f.mu.Lock()
+		p.failures++
+		p.nextAttempt = time.Now().Add(backoff(p.failures))
+		p.lastError = err.Error()
+		next := p.nextAttempt
+		f.mu.Unlock()
+		f.recordFailure(d, err)
+		log.WithError(err).WithField("retry", next.Format(time.RFC3339)).
+			Warn("Failed to deliver image to peer registry, will retry.")
+		return
+	}
+}
+
+// backoff returns the delay before the next delivery attempt after the given number of consecutive failures.
+func backoff(failures int) time.Duration {
+	d := minBackoff
+	for i := 1; i < failures && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+// deliver pushes the image content present in the content store to the peer.
+func (f *Forwarder) deliver(ctx context.Context, p *peer, d Delivery) error {
+	named, err := reference.ParseNormalizedNamed(d.Image)
+	if err != nil {
+		return fmt.Errorf("parse image reference '%s': %w", d.Image, err)
+	}
+	ref := p.host + "/" + reference.FamiliarName(named)
+	if tagged, ok := named.(reference.Tagged); ok {
+		ref += ":" + tagged.Tag()
+	}
+
+	desc := ocispec.Descriptor{MediaType: d.MediaType, Digest: d.Digest}
+	info, err := f.client.ContentStore().Info(ctx, d.Digest)
+	if err != nil {
+		return fmt.Errorf("get metadata for blob '%s' from containerd content store: %w", d.Digest, err)
+	}
+	desc.Size = info.Size
+
+	return f.client.Push(ctx, ref, desc,
+		client.WithResolver(docker.NewResolver(docker.ResolverOptions{Hosts: p.hosts})),
+		// Multi-platform images often have only some platforms present locally so push only the present content.
+		client.WithImageHandlerWrapper(presentChildren(f.client.ContentStore())),
+	)
+}
+
+// presentChildren wraps the image handler to only return the children that are present in the content store.
+func presentChildren(store content.Store) func(images.Handler) images.Handler {
+	return func(h images.Handler) images.Handler {
+		return images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+			children, err := h.Handle(ctx, desc)
+			if err != nil {
+				return nil, err
+			}
+			present := children[:0]
+			for _, child := range children {
+				if _, err = store.Info(ctx, child.Digest); err != nil {
+					if errdefs.IsNotFound(err) {
+						continue
+					}
+					return nil, err
+				}
+				present = append(present, child)
+			}
+			return present, nil
+		})
+	}
+}
+
+// recordFailure records the failed attempt in the queued delivery unless it has been replaced or removed meanwhile.
+func (f *Forwarder) recordFailure(d Delivery, deliverErr error) {
+	var stored Delivery
+	err := f.store.Update(metadata.BucketDeliveries, deliveryKey(d.Peer, d.Image), &stored, func() error {
+		if stored.Digest != d.Digest {
+			return errSkipUpdate
+		}
+		stored.Attempts++
+		stored.LastError = deliverErr.Error()
+		return nil
+	})
+	if err != nil && !errors.Is(err, errSkipUpdate) {
+		logrus.WithError(err).Debug("Failed to record failed image delivery attempt.")
+	}
+}
+
+// recordDelivery records the delivered image as the latest one replicated to the peer.
+func (f *Forwarder) recordDelivery(d Delivery) {
+	r := Replication{
+		Peer:      d.Peer,
+		State:     ReplicationDelivered,
+		Digest:    d.Digest,
+		Queued:    d.Queued,
+		Delivered: time.Now().UTC(),
+	}
+	if err := f.store.Put(metadata.BucketReplications, deliveryKey(d.Peer, d.Image), r); err != nil {
+		logrus.WithError(err).Debug("Failed to record image delivery to peer registry.")
+	}
+}
+
+// errSkipUpdate aborts a store update without changing the value.
+var errSkipUpdate = errors.New("skip update")
+
+// remove removes the delivery from the queue unless it has been replaced by a delivery of a newer push.
+func (f *Forwarder) remove(d Delivery) {
+	key := deliveryKey(d.Peer, d.Image)
+	var stored Delivery
+	if err := f.store.Get(metadata.BucketDeliveries, key, &stored); err != nil {
+		return
+	}
+	if stored.Digest != d.Digest || !stored.Queued.Equal(d.Queued) {
+		return
+	}
+	if err := f.store.Delete(metadata.BucketDeliveries, key); err != nil {
+		logrus.WithError(err).Debug("Failed to remove image delivery from queue.")
+	}
+}
+
+// pending returns the queued deliveries to the configured peers. Deliveries to peers that are no longer configured
+// are ignored.
+func (f *Forwarder) pending() ([]Delivery, error) {
+	var deliveries []Delivery
+	err := f.store.List(metadata.BucketDeliveries, func(_ string, value []byte) error {
+		var d Delivery
+		if err := json.Unmarshal(value, &d); err != nil {
+			return err
+		}
+		if slices.ContainsFunc(f.peers, func(p *peer) bool { return p.url == d.Peer }) {
+			deliveries = append(deliveries, d)
+		}
+		return nil
+	})
+	return deliveries, err
+}
+
+// Status returns the delivery state of each peer with its pending deliveries.
+func (f *Forwarder) Status() ([]PeerStatus, error) {
+	if f == nil {
+		return []PeerStatus{}, nil
+	}
+	pending, err := f.pending()
+	if err != nil {
+		return nil, fmt.Errorf("list pending image deliveries: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	statuses := make([]PeerStatus, 0, len(f.peers))
+	for _, p := range f.peers {
+		status := PeerStatus{
+			URL:         p.url,
+			Failures:    p.failures,
+			NextAttempt: p.nextAttempt,
+			LastError:   p.lastError,
+			Pending:     []Delivery{},
+		}
+		for _, d := range pending {
+			if d.Peer == p.url {
+				status.Pending = append(status.Pending, d)
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// ImageStatus returns the replication state of the pushed image with the normalized reference, e.g.
+// "docker.io/library/ubuntu:latest", on each peer. A pending delivery of a newer push takes precedence over
+// the delivery of an older one.
+func (f *Forwarder) ImageStatus(image string) ([]Replication, error) {
+	if f == nil {
+		return []Replication{}, nil
+	}
+
+	replications := make([]Replication, 0, len(f.peers))
+	for _, p := range f.peers {
+		key := deliveryKey(p.url, image)
+		var d Delivery
+		err := f.store.Get(metadata.BucketDeliveries, key, &d)
+		if err == nil {
+			replications = append(replications, Replication{
+				Peer:      p.url,
+				State:     ReplicationPending,
+				Digest:    d.Digest,
+				Queued:    d.Queued,
+				Attempts:  d.Attempts,
+				LastError: d.LastError,
+			})
+			continue
+		}
+		if !errors.Is(err, metadata.ErrNotFound) {
+			return nil, fmt.Errorf("get pending delivery of image '%s' to peer '%s': %w", image, p.url, err)
+		}
+
+		r := Replication{Peer: p.url, State: ReplicationUnknown}
+		if err = f.store.Get(metadata.BucketReplications, key, &r); err != nil &&
+			!errors.Is(err, metadata.ErrNotFound) {
+			return nil, fmt.Errorf("get delivery of image '%s' to peer '%s': %w", image, p.url, err)
+		}
+		replications = append(replications, r)
+	}
+	return replications, nil
+}
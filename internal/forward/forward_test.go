@@ -0,0 +1,109 @@
+package forward
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/handlers"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/psviderski/unregistry/internal/events"
+	"github.com/psviderski/unregistry/internal/metadata"
+	"github.com/psviderski/unregistry/internal/registrytest"
+)
+
+// newTestRegistry starts a registry with in-memory storage to deliver images to.
+func newTestRegistry(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handlers.NewApp(context.Background(), registrytest.AppConfig()))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDeliverFallsBackWhenPeerFails(t *testing.T) {
+	cli := registrytest.NewClient(t)
+	desc := registrytest.WriteImage(t, cli.ContentStore())
+
+	up := newTestRegistry(t)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	store := metadata.NewMemoryStore()
+	f, err := New(cli, store, nil, []string{down.URL, up.URL}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const image = "docker.io/library/myapp:latest"
+	f.enqueue(events.Event{Type: events.TypePush, Image: image, Digest: desc.Digest, MediaType: desc.MediaType})
+
+	ctx := context.Background()
+	f.deliverDue(ctx)
+	f.wg.Wait()
+
+	req, err := http.NewRequest(http.MethodHead, up.URL+"/v2/myapp/manifests/latest", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", ocispec.MediaTypeImageManifest)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the image to be delivered to the reachable peer, got status %d", resp.StatusCode)
+	}
+
+	replications, err := f.ImageStatus(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := map[string]Replication{}
+	for _, r := range replications {
+		states[r.Peer] = r
+	}
+	if r := states[down.URL]; r.State != ReplicationPending || r.Attempts != 1 || r.LastError == "" {
+		t.Fatalf("expected the delivery to the failed peer to stay pending with a failed attempt, got %+v", r)
+	}
+	if r := states[up.URL]; r.State != ReplicationDelivered || r.Digest != desc.Digest {
+		t.Fatalf("expected the image to be recorded as delivered to the reachable peer, got %+v", r)
+	}
+
+	statuses, err := f.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := statuses[0]
+	if failed.Failures != 1 || !failed.NextAttempt.After(time.Now()) || len(failed.Pending) != 1 {
+		t.Fatalf("expected the failed peer to back off with the delivery pending, got %+v", failed)
+	}
+	if len(statuses[1].Pending) != 0 {
+		t.Fatalf("expected no pending deliveries to the reachable peer, got %+v", statuses[1].Pending)
+	}
+
+	// The failed peer isn't retried before its backoff ends.
+	f.deliverDue(ctx)
+	f.wg.Wait()
+	if r, _ := f.ImageStatus(image); r[0].Attempts != 1 {
+		t.Fatalf("expected no delivery attempts during the backoff, got %d attempts", r[0].Attempts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: minBackoff},
+		{failures: 2, want: 2 * minBackoff},
+		{failures: 4, want: 8 * minBackoff},
+		{failures: 100, want: maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
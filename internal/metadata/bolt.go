@@ -0,0 +1,105 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltStore is a persistent implementation of Store backed by a bbolt database file.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens or creates a bbolt database file at the given path and returns a persistent store backed by it.
+func NewBoltStore(path string) (Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create directory for metadata database: %w", err)
+	}
+
+	// Fail fast instead of hanging forever if another unregistry process holds the lock on the database file.
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open metadata database '%s': %w", path, err)
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Get(bucket, key string, v any) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return ErrNotFound
+		}
+		data := b.Get([]byte(key))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, v)
+	})
+}
+
+func (s *boltStore) Put(bucket, key string, v any) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return boltPut(tx, bucket, key, v)
+	})
+}
+
+func (s *boltStore) Update(bucket, key string, v any, fn func() error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(bucket)); b != nil {
+			if data := b.Get([]byte(key)); data != nil {
+				if err := json.Unmarshal(data, v); err != nil {
+					return fmt.Errorf("unmarshal value of '%s' in bucket '%s': %w", key, bucket, err)
+				}
+			}
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+		return boltPut(tx, bucket, key, v)
+	})
+}
+
+func boltPut(tx *bolt.Tx, bucket, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal value of '%s' in bucket '%s': %w", key, bucket, err)
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return fmt.Errorf("create bucket '%s': %w", bucket, err)
+	}
+	return b.Put([]byte(key), data)
+}
+
+func (s *boltStore) Delete(bucket, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (s *boltStore) List(bucket string, fn func(key string, value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
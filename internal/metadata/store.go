@@ -0,0 +1,144 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrNotFound is returned when a key doesn't exist in the store.
+var ErrNotFound = errors.New("not found")
+
+// Buckets group keys of the same kind of state in the store.
+const (
+	// BucketTagHistory stores the history of digests a tag pointed to keyed by the image reference.
+	BucketTagHistory = "tag-history"
+	// BucketTransferReports stores the latest transfer report of a pushed image keyed by the image reference.
+	BucketTransferReports = "transfer-reports"
+	// BucketDeliveries stores the pushed images pending delivery to peer registries keyed by the peer URL and
+	// the image reference.
+	BucketDeliveries = "deliveries"
+	// BucketReplications stores the latest image delivered to peer registries keyed by the peer URL and the image
+	// reference.
+	BucketReplications = "replications"
+	// BucketUploads stores the state of unfinished blob upload sessions keyed by the upload ID.
+	BucketUploads = "uploads"
+	// BucketRegistry stores registry-wide settings that must survive restarts keyed by the setting name.
+	BucketRegistry = "registry"
+)
+
+// Store is a key-value store for registry-specific state that can't be held well in containerd, such as upload
+// sessions, tag history, pull counters, or quotas. Values are JSON-encoded and keys are grouped into buckets.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get decodes the value stored under the key in the bucket into v. It returns ErrNotFound if the key
+	// doesn't exist.
+	Get(bucket, key string, v any) error
+	// Put stores the JSON-encoded v under the key in the bucket.
+	Put(bucket, key string, v any) error
+	// Update atomically reads the value stored under the key into v, calls fn to modify it, and stores the result.
+	// v is left unchanged if the key doesn't exist.
+	Update(bucket, key string, v any, fn func() error) error
+	// Delete removes the key from the bucket. Deleting a non-existent key is not an error.
+	Delete(bucket, key string) error
+	// List calls fn for each key and its raw JSON value in the bucket in the key order.
+	List(bucket string, fn func(key string, value []byte) error) error
+	// Close releases resources held by the store.
+	Close() error
+}
+
+// memoryStore is an in-memory implementation of Store used when persistence is not configured.
+// The state is lost when the registry restarts.
+type memoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]map[string][]byte
+}
+
+// NewMemoryStore creates a new non-persistent in-memory store.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		buckets: make(map[string]map[string][]byte),
+	}
+}
+
+func (s *memoryStore) Get(bucket, key string, v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.buckets[bucket][key]
+	if !ok {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (s *memoryStore) Put(bucket, key string, v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.put(bucket, key, v)
+}
+
+func (s *memoryStore) Update(bucket, key string, v any, fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if data, ok := s.buckets[bucket][key]; ok {
+		if err := json.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("unmarshal value of '%s' in bucket '%s': %w", key, bucket, err)
+		}
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return s.put(bucket, key, v)
+}
+
+func (s *memoryStore) put(bucket, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal value of '%s' in bucket '%s': %w", key, bucket, err)
+	}
+
+	b, ok := s.buckets[bucket]
+	if !ok {
+		b = make(map[string][]byte)
+		s.buckets[bucket] = b
+	}
+	b[key] = data
+	return nil
+}
+
+func (s *memoryStore) Delete(bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.buckets[bucket], key)
+	return nil
+}
+
+func (s *memoryStore) List(bucket string, fn func(key string, value []byte) error) error {
+	s.mu.Lock()
+	b := s.buckets[bucket]
+	keys := make([]string, 0, len(b))
+	values := make(map[string][]byte, len(b))
+	for k, v := range b {
+		keys = append(keys, k)
+		values[k] = v
+	}
+	s.mu.Unlock()
+
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := fn(k, values[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,161 @@
+package metadata
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestStore(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(*testing.T) Store {
+			return NewMemoryStore()
+		},
+		"bolt": func(t *testing.T) Store {
+			store, err := NewBoltStore(filepath.Join(t.TempDir(), "metadata", "unregistry.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			t.Cleanup(func() {
+				_ = store.Close()
+			})
+
+			var v string
+			if err := store.Get("bucket", "missing", &v); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected ErrNotFound for a missing bucket, got %v", err)
+			}
+			for _, key := range []string{"b", "a", "c"} {
+				if err := store.Put("bucket", key, key+"-value"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := store.Get("bucket", "missing", &v); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected ErrNotFound for a missing key, got %v", err)
+			}
+			if err := store.Get("bucket", "a", &v); err != nil || v != "a-value" {
+				t.Fatalf("expected a-value, got %q, %v", v, err)
+			}
+
+			// A failed update doesn't change the stored value.
+			failed := errors.New("failed")
+			err := store.Update("bucket", "a", &v, func() error {
+				v = "changed"
+				return failed
+			})
+			if !errors.Is(err, failed) {
+				t.Fatalf("expected the error of the update function, got %v", err)
+			}
+			if err = store.Get("bucket", "a", &v); err != nil || v != "a-value" {
+				t.Fatalf("expected a-value after a failed update, got %q, %v", v, err)
+			}
+			var updated string
+			if err = store.Update("bucket", "a", &updated, func() error {
+				updated += "-updated"
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if err = store.Get("bucket", "a", &v); err != nil || v != "a-value-updated" {
+				t.Fatalf("expected a-value-updated, got %q, %v", v, err)
+			}
+
+			if err = store.Delete("bucket", "b"); err != nil {
+				t.Fatal(err)
+			}
+			if err = store.Delete("bucket", "b"); err != nil {
+				t.Fatalf("expected deleting a missing key to succeed, got %v", err)
+			}
+			if err = store.Delete("missing", "b"); err != nil {
+				t.Fatalf("expected deleting from a missing bucket to succeed, got %v", err)
+			}
+
+			var keys []string
+			if err = store.List("bucket", func(key string, value []byte) error {
+				keys = append(keys, key+"="+string(value))
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{`a="a-value-updated"`, `c="c-value"`}; !slices.Equal(keys, want) {
+				t.Fatalf("expected keys %v, got %v", want, keys)
+			}
+			if err = store.List("missing", func(string, []byte) error {
+				t.Fatal("expected no keys in a missing bucket")
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestBoltStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unregistry.db")
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst := digest.FromString("image")
+	if err = RecordTag(store, "docker.io/library/myapp:latest", dgst); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	history, err := TagHistory(store, "docker.io/library/myapp:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Digest != dgst {
+		t.Fatalf("expected the tag history to survive reopening the store, got %v", history)
+	}
+}
+
+func TestRecordTag(t *testing.T) {
+	store := NewMemoryStore()
+	ref := "docker.io/library/myapp:latest"
+	var want []digest.Digest
+	for i := range maxTagHistory + 2 {
+		dgst := digest.FromString(string(rune('a' + i)))
+		want = append(want, dgst)
+		if err := RecordTag(store, ref, dgst); err != nil {
+			t.Fatal(err)
+		}
+		// Recording the digest the tag already points to doesn't add an entry.
+		if err := RecordTag(store, ref, dgst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want = want[len(want)-maxTagHistory:]
+
+	history, err := TagHistory(store, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]digest.Digest, len(history))
+	for i, entry := range history {
+		got[i] = entry.Digest
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected the last %d digests from the oldest to the newest, got %v", maxTagHistory, got)
+	}
+
+	if history, err = TagHistory(store, "docker.io/library/other:latest"); err != nil || history != nil {
+		t.Fatalf("expected no history for an unknown tag, got %v, %v", history, err)
+	}
+}
@@ -0,0 +1,44 @@
+package metadata
+
+import (
+	"errors"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// maxTagHistory is the maximum number of history entries kept per tag. Older entries are discarded.
+const maxTagHistory = 50
+
+// TagHistoryEntry records a digest the tag pointed to.
+type TagHistoryEntry struct {
+	Digest   digest.Digest `json:"digest"`
+	TaggedAt time.Time     `json:"taggedAt"`
+}
+
+// RecordTag appends the digest to the history of the tagged image reference unless the tag already points to it.
+func RecordTag(store Store, ref string, dgst digest.Digest) error {
+	var history []TagHistoryEntry
+	return store.Update(BucketTagHistory, ref, &history, func() error {
+		if len(history) > 0 && history[len(history)-1].Digest == dgst {
+			return nil
+		}
+		history = append(history, TagHistoryEntry{Digest: dgst, TaggedAt: time.Now().UTC()})
+		if len(history) > maxTagHistory {
+			history = history[len(history)-maxTagHistory:]
+		}
+		return nil
+	})
+}
+
+// TagHistory returns the history of digests the tagged image reference pointed to, from the oldest to the newest.
+func TagHistory(store Store, ref string) ([]TagHistoryEntry, error) {
+	var history []TagHistoryEntry
+	if err := store.Get(BucketTagHistory, ref, &history); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return history, nil
+}
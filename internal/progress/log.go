@@ -0,0 +1,59 @@
+package progress
+
+import (
+	"context"
+	"time"
+
+	"github.com/psviderski/unregistry/internal/transfer"
+	"github.com/sirupsen/logrus"
+)
+
+// LogProgress periodically samples the progress of the uploads receiving data, updates their rates, and logs them
+// until the context is canceled. Unlike the debug logs of every write, it shows at a glance whether data of a slow
+// or repeatedly retried upload is actually flowing into containerd.
+func (t *Tracker) LogProgress(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, e := range t.sample(2 * interval) {
+			logrus.WithFields(logrus.Fields{
+				"id":       e.ID,
+				"repo":     e.Repo,
+				"received": transfer.HumanSize(e.Bytes),
+				"rate":     transfer.HumanSize(e.Rate) + "/s",
+				"duration": time.Since(e.StartedAt).Round(time.Second),
+				"idle":     time.Since(e.UpdatedAt).Round(time.Second),
+			}).Info("Blob upload in progress.")
+		}
+	}
+}
+
+// sample updates the rates of the uploads receiving data and returns the latest events of those that have been updated
+// within the recent period. A stalled upload is returned with a zero rate until it's idle for longer than recent so
+// that abandoned uploads aren't reported forever.
+func (t *Tracker) sample(recent time.Duration) []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var events []Event
+	for _, u := range t.uploads {
+		if u.last.State != StateReceiving || now.Sub(u.last.UpdatedAt) > recent {
+			continue
+		}
+		if elapsed := now.Sub(u.sampledAt); elapsed > 0 {
+			u.last.Rate = int64(float64(u.last.Bytes-u.sampleBytes) / elapsed.Seconds())
+		}
+		u.sampleBytes = u.last.Bytes
+		u.sampledAt = now
+		events = append(events, u.last)
+	}
+	return events
+}
@@ -0,0 +1,261 @@
+package progress
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a blob upload.
+type State string
+
+const (
+	// StateReceiving means the upload is in progress and the registry is receiving data from the client.
+	StateReceiving State = "receiving"
+	// StateVerifying means all data has been received and the content is being verified and committed.
+	StateVerifying State = "verifying"
+	// StateCommitted means the blob has been successfully committed to the content store.
+	StateCommitted State = "committed"
+	// StateFailed means the blob commit failed.
+	StateFailed State = "failed"
+	// StateCanceled means the upload was canceled by the client.
+	StateCanceled State = "canceled"
+)
+
+// Final returns true if the state is terminal and no more events will follow.
+func (s State) Final() bool {
+	return s == StateCommitted || s == StateFailed || s == StateCanceled
+}
+
+// finishedRetention is how long a finished upload is kept in the tracker so that clients subscribing after
+// the upload has finished can still observe its final state.
+const finishedRetention = 1 * time.Minute
+
+// idleRetention is how long an unfinished upload that doesn't receive data is kept in the tracker. Uploads abandoned
+// by clients are finished by the stale upload purge before that unless it's disabled.
+const idleRetention = 24 * time.Hour
+
+// subscriberBuffer is the size of the event channel buffer for each subscriber. Intermediate events are dropped for
+// slow subscribers but they will still receive the latest one eventually.
+const subscriberBuffer = 16
+
+// Event is a progress update of a blob upload.
+type Event struct {
+	// ID is the upload ID assigned by the registry.
+	ID string `json:"id"`
+	// Repo is the repository name the blob is being uploaded to.
+	Repo  string `json:"repo"`
+	State State  `json:"state"`
+	// Bytes is the number of bytes received and written to the containerd content store so far.
+	Bytes int64 `json:"bytes"`
+	// Rate is the number of bytes written per second since the previous progress sample. It's only computed when
+	// the progress is sampled periodically with Tracker.LogProgress.
+	Rate int64 `json:"rate"`
+	// Digest is the digest of the blob. It's only known when the upload is being committed.
+	Digest    string    `json:"digest,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Tracker keeps track of the progress of active blob uploads and notifies subscribers about updates.
+// It's safe for concurrent use.
+type Tracker struct {
+	mu      sync.Mutex
+	uploads map[string]*upload
+}
+
+type upload struct {
+	last        Event
+	subscribers map[chan Event]struct{}
+	// sampleBytes and sampledAt are the number of bytes and the time of the previous progress sample.
+	sampleBytes int64
+	sampledAt   time.Time
+}
+
+// NewTracker creates a new progress tracker for blob uploads.
+func NewTracker() *Tracker {
+	return &Tracker{
+		uploads: make(map[string]*upload),
+	}
+}
+
+// Start registers an upload with the given ID or reopens it if it's already tracked, for example, when the upload
+// is resumed with another PATCH request.
+func (t *Tracker) Start(id, repo string, bytes int64) {
+	t.update(id, func(e *Event) {
+		e.Repo = repo
+		e.State = StateReceiving
+		e.Bytes = bytes
+		e.Error = ""
+	})
+	if t == nil {
+		return
+	}
+
+	// Don't count the bytes received by the previous requests of a resumed upload towards the rate.
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if u, ok := t.uploads[id]; ok {
+		u.sampleBytes = bytes
+		u.sampledAt = now
+	}
+	t.evictIdle(now)
+}
+
+// Add increments the number of received bytes for the upload.
+func (t *Tracker) Add(id string, n int64) {
+	if n == 0 {
+		return
+	}
+	t.update(id, func(e *Event) {
+		e.Bytes += n
+	})
+}
+
+// Verifying marks the upload as being verified and committed with the given digest.
+func (t *Tracker) Verifying(id, digest string) {
+	t.update(id, func(e *Event) {
+		e.State = StateVerifying
+		e.Digest = digest
+	})
+}
+
+// Finish marks the upload as finished with the given final state. A non-nil err is reported in the event.
+func (t *Tracker) Finish(id string, state State, err error) {
+	if t == nil {
+		return
+	}
+	t.update(id, func(e *Event) {
+		e.State = state
+		if err != nil {
+			e.Error = err.Error()
+		}
+	})
+
+	time.AfterFunc(finishedRetention, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		if u, ok := t.uploads[id]; ok && u.last.State.Final() {
+			t.remove(id, u)
+		}
+	})
+}
+
+// evictIdle stops tracking the unfinished uploads that haven't been updated for longer than idleRetention, e.g.
+// abandoned by clients while the stale upload purge is disabled. t.mu must be held.
+func (t *Tracker) evictIdle(now time.Time) {
+	for id, u := range t.uploads {
+		if !u.last.State.Final() && now.Sub(u.last.UpdatedAt) > idleRetention {
+			t.remove(id, u)
+		}
+	}
+}
+
+// remove stops tracking the upload and closes the channels of its subscribers. t.mu must be held.
+func (t *Tracker) remove(id string, u *upload) {
+	for ch := range u.subscribers {
+		delete(u.subscribers, ch)
+		close(ch)
+	}
+	delete(t.uploads, id)
+}
+
+// Get returns the latest event for the upload and true if the upload is tracked.
+func (t *Tracker) Get(id string) (Event, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, ok := t.uploads[id]
+	if !ok {
+		return Event{}, false
+	}
+	return u.last, true
+}
+
+// List returns the latest events for all tracked uploads.
+func (t *Tracker) List() []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.evictIdle(time.Now())
+
+	events := make([]Event, 0, len(t.uploads))
+	for _, u := range t.uploads {
+		events = append(events, u.last)
+	}
+	return events
+}
+
+// Subscribe returns a channel that receives progress events for the upload starting with the latest one.
+// The channel is closed when the upload is no longer tracked. The returned function must be called to unsubscribe
+// when the caller is no longer interested in events. ok is false if the upload is not tracked.
+func (t *Tracker) Subscribe(id string) (events <-chan Event, unsubscribe func(), ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, ok := t.uploads[id]
+	if !ok {
+		return nil, nil, false
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	ch <- u.last
+	u.subscribers[ch] = struct{}{}
+
+	unsubscribe = func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		if _, ok := u.subscribers[ch]; ok {
+			delete(u.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, unsubscribe, true
+}
+
+func (t *Tracker) update(id string, fn func(e *Event)) {
+	// Allow using a nil tracker when progress tracking is not needed.
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, ok := t.uploads[id]
+	if !ok {
+		now := time.Now()
+		u = &upload{
+			last:        Event{ID: id, StartedAt: now},
+			subscribers: make(map[chan Event]struct{}),
+			sampledAt:   now,
+		}
+		t.uploads[id] = u
+	}
+	fn(&u.last)
+	u.last.UpdatedAt = time.Now()
+
+	for ch := range u.subscribers {
+		send(ch, u.last)
+	}
+}
+
+// send sends the event to the channel without blocking. If the channel buffer is full, the oldest event is dropped
+// to make room for the latest one so that a slow subscriber doesn't block the upload but still observes the final
+// state.
+func send(ch chan Event, e Event) {
+	for {
+		select {
+		case ch <- e:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
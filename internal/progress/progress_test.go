@@ -0,0 +1,42 @@
+package progress
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackerEvictsIdleUploads(t *testing.T) {
+	tracker := NewTracker()
+	tracker.Start("idle", "myapp", 0)
+	tracker.Start("finished", "myapp", 0)
+	tracker.Finish("finished", StateCommitted, nil)
+	events, _, ok := tracker.Subscribe("idle")
+	if !ok {
+		t.Fatal("expected the idle upload to be tracked")
+	}
+
+	// Pretend both uploads were last updated before the idle retention.
+	tracker.mu.Lock()
+	for _, u := range tracker.uploads {
+		u.last.UpdatedAt = time.Now().Add(-idleRetention - time.Minute)
+	}
+	tracker.mu.Unlock()
+
+	tracker.Start("active", "myapp", 0)
+	if _, ok = tracker.Get("idle"); ok {
+		t.Fatal("expected the idle unfinished upload to be evicted")
+	}
+	if _, ok = tracker.Get("finished"); !ok {
+		t.Fatal("expected the finished upload to be kept until its retention after finishing")
+	}
+	if _, ok = tracker.Get("active"); !ok {
+		t.Fatal("expected the started upload to be tracked")
+	}
+	if got := len(tracker.List()); got != 2 {
+		t.Fatalf("expected 2 tracked uploads, got %d", got)
+	}
+
+	// Subscribers of the evicted upload are notified that it's no longer tracked.
+	for range events {
+	}
+}
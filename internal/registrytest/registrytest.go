@@ -0,0 +1,323 @@
+// Package registrytest provides in-memory containerd services and registry configuration for tests that don't have
+// a running containerd.
+package registrytest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/distribution/v3/configuration"
+	// The in-memory storage driver of the registry app configured by AppConfig.
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// NewClient returns a containerd client backed by a local content store in a temporary directory with in-memory
+// content labels, and in-memory image store and lease manager. The image store has images with the names pointing to
+// manifests that aren't in the content store. The client has no namespaces and doesn't collect garbage.
+func NewClient(t testing.TB, names ...string) *client.Client {
+	t.Helper()
+	labels := &memoryLabels{labels: make(map[digest.Digest]map[string]string)}
+	contentStore, err := local.NewLabeledStore(t.TempDir(), labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageStore := &memoryImages{images: make(map[string]images.Image)}
+	for _, name := range names {
+		imageStore.images[name] = images.Image{
+			Name: name,
+			Target: ocispec.Descriptor{
+				MediaType: ocispec.MediaTypeImageManifest,
+				Digest:    digest.FromString(name),
+				Size:      1,
+			},
+		}
+	}
+	cli, err := client.New("", client.WithServices(
+		client.WithContentStore(contentStore),
+		client.WithImageStore(imageStore),
+		client.WithLeasesService(&memoryLeases{
+			leases:    make(map[string]leases.Lease),
+			resources: make(map[string][]leases.Resource),
+		}),
+		client.WithNamespaceService(noNamespaces{}),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cli
+}
+
+// WriteBlob writes the data to the content store and returns its descriptor.
+func WriteBlob(t testing.TB, store content.Store, mediaType string, data []byte) ocispec.Descriptor {
+	t.Helper()
+	desc := ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data))}
+	if err := content.WriteBlob(
+		context.Background(), store, desc.Digest.String(), bytes.NewReader(data), desc,
+	); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+// WriteImage writes an image manifest with a config and a layer to the content store and returns its descriptor.
+func WriteImage(t testing.TB, store content.Store) ocispec.Descriptor {
+	t.Helper()
+	config := WriteBlob(t, store, ocispec.MediaTypeImageConfig,
+		[]byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`))
+	layer := WriteBlob(t, store, ocispec.MediaTypeImageLayer, []byte("layer"))
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return WriteBlob(t, store, ocispec.MediaTypeImageManifest, manifest)
+}
+
+// AppConfig returns the configuration of a distribution registry app with in-memory storage that serves the catalog
+// in pages of up to 1000 repositories like the registry.
+func AppConfig() *configuration.Configuration {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory":    configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[any]any{"enabled": false}},
+		},
+		Catalog: configuration.Catalog{MaxEntries: 1000},
+	}
+	config.HTTP.Secret = "secret"
+	return config
+}
+
+// memoryLabels is an in-memory store of the content labels.
+type memoryLabels struct {
+	mu     sync.Mutex
+	labels map[digest.Digest]map[string]string
+}
+
+func (m *memoryLabels) Get(dgst digest.Digest) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return maps.Clone(m.labels[dgst]), nil
+}
+
+func (m *memoryLabels) Set(dgst digest.Digest, labels map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.labels[dgst] = maps.Clone(labels)
+	return nil
+}
+
+// Update replaces the given labels and removes the labels with empty values.
+func (m *memoryLabels) Update(dgst digest.Digest, update map[string]string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	labels := m.labels[dgst]
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	for key, value := range update {
+		if value == "" {
+			delete(labels, key)
+		} else {
+			labels[key] = value
+		}
+	}
+	m.labels[dgst] = labels
+	return maps.Clone(labels), nil
+}
+
+// memoryImages is an in-memory containerd image store. List ignores filters.
+type memoryImages struct {
+	mu     sync.Mutex
+	images map[string]images.Image
+}
+
+func (m *memoryImages) Get(_ context.Context, name string) (images.Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	img, ok := m.images[name]
+	if !ok {
+		return images.Image{}, errdefs.ErrNotFound
+	}
+	return img, nil
+}
+
+func (m *memoryImages) List(context.Context, ...string) ([]images.Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]images.Image, 0, len(m.images))
+	for _, img := range m.images {
+		list = append(list, img)
+	}
+	return list, nil
+}
+
+func (m *memoryImages) Create(_ context.Context, img images.Image) (images.Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.images[img.Name]; ok {
+		return images.Image{}, errdefs.ErrAlreadyExists
+	}
+	m.images[img.Name] = img
+	return img, nil
+}
+
+// Update replaces the image or only its labels with the "labels.<key>" field paths.
+func (m *memoryImages) Update(_ context.Context, img images.Image, fieldpaths ...string) (images.Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	existing, ok := m.images[img.Name]
+	if !ok {
+		return images.Image{}, errdefs.ErrNotFound
+	}
+	if len(fieldpaths) == 0 {
+		m.images[img.Name] = img
+		return img, nil
+	}
+	labels := maps.Clone(existing.Labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	for _, path := range fieldpaths {
+		key, ok := strings.CutPrefix(path, "labels.")
+		if !ok {
+			return images.Image{}, errdefs.ErrNotImplemented
+		}
+		labels[key] = img.Labels[key]
+	}
+	existing.Labels = labels
+	m.images[img.Name] = existing
+	return existing, nil
+}
+
+func (m *memoryImages) Delete(_ context.Context, name string, _ ...images.DeleteOpt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.images[name]; !ok {
+		return errdefs.ErrNotFound
+	}
+	delete(m.images, name)
+	return nil
+}
+
+// memoryLeases is an in-memory containerd lease manager. It only tracks the resources of the leases and doesn't
+// protect them from deletion.
+type memoryLeases struct {
+	mu        sync.Mutex
+	leases    map[string]leases.Lease
+	resources map[string][]leases.Resource
+}
+
+func (m *memoryLeases) Create(_ context.Context, opts ...leases.Opt) (leases.Lease, error) {
+	l := leases.Lease{CreatedAt: time.Now()}
+	for _, opt := range opts {
+		if err := opt(&l); err != nil {
+			return leases.Lease{}, err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.leases[l.ID]; ok {
+		return leases.Lease{}, errdefs.ErrAlreadyExists
+	}
+	m.leases[l.ID] = l
+	return l, nil
+}
+
+func (m *memoryLeases) Delete(_ context.Context, l leases.Lease, _ ...leases.DeleteOpt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.leases[l.ID]; !ok {
+		return errdefs.ErrNotFound
+	}
+	delete(m.leases, l.ID)
+	delete(m.resources, l.ID)
+	return nil
+}
+
+// List only supports the "id==<id>" filter.
+func (m *memoryLeases) List(_ context.Context, filters ...string) ([]leases.Lease, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []leases.Lease
+	for id, l := range m.leases {
+		if len(filters) > 0 && filters[0] != "id=="+id {
+			continue
+		}
+		list = append(list, l)
+	}
+	return list, nil
+}
+
+func (m *memoryLeases) AddResource(_ context.Context, l leases.Lease, r leases.Resource) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.leases[l.ID]; !ok {
+		return errdefs.ErrNotFound
+	}
+	if !slices.Contains(m.resources[l.ID], r) {
+		m.resources[l.ID] = append(m.resources[l.ID], r)
+	}
+	return nil
+}
+
+func (m *memoryLeases) DeleteResource(_ context.Context, l leases.Lease, r leases.Resource) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resources[l.ID] = slices.DeleteFunc(m.resources[l.ID], func(res leases.Resource) bool {
+		return res == r
+	})
+	return nil
+}
+
+func (m *memoryLeases) ListResources(_ context.Context, l leases.Lease) ([]leases.Resource, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.leases[l.ID]; !ok {
+		return nil, errdefs.ErrNotFound
+	}
+	return slices.Clone(m.resources[l.ID]), nil
+}
+
+// noNamespaces is a containerd namespace store without namespaces.
+type noNamespaces struct{}
+
+func (noNamespaces) Create(context.Context, string, map[string]string) error {
+	return errdefs.ErrNotImplemented
+}
+
+func (noNamespaces) Labels(context.Context, string) (map[string]string, error) {
+	return nil, errdefs.ErrNotFound
+}
+
+func (noNamespaces) SetLabel(context.Context, string, string, string) error {
+	return errdefs.ErrNotFound
+}
+
+func (noNamespaces) List(context.Context) ([]string, error) {
+	return nil, nil
+}
+
+func (noNamespaces) Delete(context.Context, string, ...namespaces.DeleteOpts) error {
+	return errdefs.ErrNotFound
+}
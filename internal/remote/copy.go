@@ -0,0 +1,184 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxManifestSize is the maximum size of a manifest read into memory to copy its children.
+const maxManifestSize = 4 << 20
+
+// copyConcurrency is the number of children of a manifest, e.g. layers, copied concurrently.
+const copyConcurrency = 4
+
+// Resolve returns the descriptor of the image manifest the tag of the repository in the registry points to.
+func (r *Registry) Resolve(ctx context.Context, repo, tag string) (ocispec.Descriptor, error) {
+	_, desc, err := r.resolver.Resolve(ctx, r.ref(repo)+":"+tag)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("resolve image '%s:%s' in registry '%s': %w", repo, tag, r.URL, err)
+	}
+	return desc, nil
+}
+
+// ResolveDigest returns the descriptor of the manifest with the digest in the repository of the registry.
+func (r *Registry) ResolveDigest(ctx context.Context, repo string, dgst digest.Digest) (ocispec.Descriptor, error) {
+	_, desc, err := r.resolver.Resolve(ctx, r.ref(repo)+"@"+dgst.String())
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("resolve image '%s@%s' in registry '%s': %w", repo, dgst, r.URL, err)
+	}
+	return desc, nil
+}
+
+// CopyImage copies the image manifest described by desc with all its content from the repository in the source
+// registry to the repository in the destination registry and tags it with the tag. The content that already exists in
+// the destination is skipped. Manifests of a multi-platform image missing in the source are skipped as well.
+func CopyImage(
+	ctx context.Context, src *Registry, srcRepo string, desc ocispec.Descriptor, dst *Registry, dstRepo, tag string,
+) error {
+	fetcher, err := src.resolver.Fetcher(ctx, src.ref(srcRepo)+"@"+desc.Digest.String())
+	if err != nil {
+		return fmt.Errorf("create fetcher for registry '%s': %w", src.URL, err)
+	}
+	// Only the root manifest is pushed by tag when the pusher reference has both the tag and digest.
+	dstRef := dst.ref(dstRepo) + "@" + desc.Digest.String()
+	if tag != "" {
+		dstRef = dst.ref(dstRepo) + ":" + tag + "@" + desc.Digest.String()
+	}
+	pusher, err := dst.pusher(ctx, dstRef)
+	if err != nil {
+		return fmt.Errorf("create pusher for registry '%s': %w", dst.URL, err)
+	}
+	return copyContent(ctx, fetcher, pusher, desc)
+}
+
+// copyContent copies the content described by desc from the fetcher to the pusher. The children of a manifest are
+// copied before the manifest itself as registries reject manifests referencing missing content.
+func copyContent(ctx context.Context, fetcher remotes.Fetcher, pusher remotes.Pusher, desc ocispec.Descriptor) error {
+	if !images.IsManifestType(desc.MediaType) && !images.IsIndexType(desc.MediaType) {
+		return copyBlob(ctx, fetcher, pusher, desc)
+	}
+
+	manifest, err := fetchManifest(ctx, fetcher, desc)
+	if err != nil {
+		return err
+	}
+	children, err := manifestChildren(desc, manifest)
+	if err != nil {
+		return err
+	}
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(copyConcurrency)
+	for _, child := range children {
+		g.Go(func() error {
+			err := copyContent(gctx, fetcher, pusher, child)
+			// An index served by unregistry may reference the manifests of platforms that were never pushed.
+			if errdefs.IsNotFound(err) && images.IsIndexType(desc.MediaType) {
+				logrus.WithField("digest", child.Digest).Debug("Skipped manifest missing in the source registry.")
+				return nil
+			}
+			return err
+		})
+	}
+	if err = g.Wait(); err != nil {
+		return err
+	}
+	return push(ctx, pusher, desc, bytes.NewReader(manifest))
+}
+
+// fetchManifest reads the manifest or index described by desc from the fetcher.
+func fetchManifest(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor) ([]byte, error) {
+	if desc.Size > maxManifestSize {
+		return nil, fmt.Errorf("manifest '%s' of size %d is too large", desc.Digest, desc.Size)
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, fmt.Errorf("fetch manifest '%s': %w", desc.Digest, err)
+	}
+	defer rc.Close()
+
+	manifest, err := io.ReadAll(io.LimitReader(rc, maxManifestSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read manifest '%s': %w", desc.Digest, err)
+	}
+	if dgst := digest.FromBytes(manifest); dgst != desc.Digest {
+		return nil, fmt.Errorf("manifest '%s' has unexpected digest '%s'", desc.Digest, dgst)
+	}
+	return manifest, nil
+}
+
+// manifestChildren returns the descriptors of the content the manifest or index references: the manifests of
+// an index, or the config and layers of a manifest. Non-distributable layers are skipped as registries don't store
+// them.
+func manifestChildren(desc ocispec.Descriptor, manifest []byte) ([]ocispec.Descriptor, error) {
+	if images.IsIndexType(desc.MediaType) {
+		var index ocispec.Index
+		if err := json.Unmarshal(manifest, &index); err != nil {
+			return nil, fmt.Errorf("unmarshal index '%s': %w", desc.Digest, err)
+		}
+		return index.Manifests, nil
+	}
+
+	var m ocispec.Manifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return nil, fmt.Errorf("unmarshal manifest '%s': %w", desc.Digest, err)
+	}
+	children := []ocispec.Descriptor{m.Config}
+	for _, layer := range m.Layers {
+		if !images.IsNonDistributable(layer.MediaType) {
+			children = append(children, layer)
+		}
+	}
+	return children, nil
+}
+
+// copyBlob copies the blob described by desc from the fetcher to the pusher unless it already exists there.
+func copyBlob(ctx context.Context, fetcher remotes.Fetcher, pusher remotes.Pusher, desc ocispec.Descriptor) error {
+	w, err := pusher.Push(ctx, desc)
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			return nil
+		}
+		return fmt.Errorf("push blob '%s': %w", desc.Digest, err)
+	}
+	defer w.Close()
+
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return fmt.Errorf("fetch blob '%s': %w", desc.Digest, err)
+	}
+	defer rc.Close()
+
+	if err = content.Copy(ctx, w, rc, desc.Size, desc.Digest); err != nil {
+		return fmt.Errorf("copy blob '%s': %w", desc.Digest, err)
+	}
+	return nil
+}
+
+// push pushes the content described by desc from r unless it already exists in the registry.
+func push(ctx context.Context, pusher remotes.Pusher, desc ocispec.Descriptor, r io.Reader) error {
+	w, err := pusher.Push(ctx, desc)
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			return nil
+		}
+		return fmt.Errorf("push manifest '%s': %w", desc.Digest, err)
+	}
+	defer w.Close()
+
+	if err = content.Copy(ctx, w, r, desc.Size, desc.Digest); err != nil {
+		return fmt.Errorf("push manifest '%s': %w", desc.Digest, err)
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+// Package remote implements copying images between registries over the distribution API, e.g. to keep the images
+// of a fleet of unregistry hosts in sync with a central registry or another host.
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/containerd/v2/core/remotes/docker"
+	"github.com/containerd/errdefs"
+)
+
+// listPageSize is the number of repositories or tags requested from a registry at a time.
+const listPageSize = 1000
+
+// Registry is a registry accessed over the distribution API, e.g. another unregistry or any OCI registry.
+type Registry struct {
+	// URL is the registry URL without credentials.
+	URL string
+	// host is the registry host with an optional port used in image references, e.g. "registry.example.com:5000".
+	host       string
+	scheme     string
+	authorizer docker.Authorizer
+	hosts      docker.RegistryHosts
+	resolver   remotes.Resolver
+	client     *http.Client
+}
+
+// RegistryOpt configures a registry created with NewRegistry.
+type RegistryOpt func(*registryOptions)
+
+type registryOptions struct {
+	username string
+	password string
+}
+
+// WithCredentials sets the credentials to access the registry with instead of the ones in the URL user info.
+func WithCredentials(username, password string) RegistryOpt {
+	return func(o *registryOptions) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// NewRegistry creates a registry from its URL, e.g. "https://registry.example.com" or "http://host:5000", or its host,
+// e.g. "localhost:5000". A host without a scheme is accessed over plain HTTP if it's localhost and over HTTPS
+// otherwise. Credentials can be specified in the URL user info.
+func NewRegistry(rawURL string, opts ...RegistryOpt) (*Registry, error) {
+	var o registryOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if !strings.Contains(rawURL, "://") {
+		scheme := "https"
+		if docker.IsLocalhost(rawURL) {
+			scheme = "http"
+		}
+		rawURL = scheme + "://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse registry URL '%s': %w", rawURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+		return nil, fmt.Errorf("invalid registry URL '%s': expected [http(s)://]host[:port]", rawURL)
+	}
+
+	if o.username == "" && u.User != nil {
+		o.username = u.User.Username()
+		o.password, _ = u.User.Password()
+	}
+	var authOpts []docker.AuthorizerOpt
+	if o.username != "" {
+		authOpts = append(authOpts, docker.WithAuthCreds(func(string) (string, string, error) {
+			return o.username, o.password, nil
+		}))
+	}
+	authorizer := docker.NewDockerAuthorizer(authOpts...)
+	registryOpts := []docker.RegistryOpt{docker.WithAuthorizer(authorizer)}
+	if u.Scheme == "http" {
+		registryOpts = append(registryOpts, docker.WithPlainHTTP(docker.MatchAllHosts))
+	}
+	// Strip the credentials so that they don't leak to logs and output.
+	u.User = nil
+
+	hosts := docker.ConfigureDefaultRegistries(registryOpts...)
+
+	return &Registry{
+		URL:        u.String(),
+		host:       u.Host,
+		scheme:     u.Scheme,
+		authorizer: authorizer,
+		hosts:      hosts,
+		resolver:   docker.NewResolver(docker.ResolverOptions{Hosts: hosts}),
+		client:     http.DefaultClient,
+	}, nil
+}
+
+// pusher returns a pusher to the reference with its own push status tracker. The tracker of the shared resolver
+// remembers the pushed manifests by digest and skips pushing them again, even to tag them with another tag.
+func (r *Registry) pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	return docker.NewResolver(docker.ResolverOptions{Hosts: r.hosts}).Pusher(ctx, ref)
+}
+
+// ref returns the reference of the repository in the registry, e.g. "registry.example.com/myapp", to append a tag or
+// digest to.
+func (r *Registry) ref(repo string) string {
+	return r.host + "/" + repo
+}
+
+// apiHost returns the host serving the registry API. Docker Hub images are referenced as "docker.io" while the API
+// is served by "registry-1.docker.io".
+func (r *Registry) apiHost() string {
+	if r.host == "docker.io" {
+		return "registry-1.docker.io"
+	}
+	return r.host
+}
+
+// Repositories returns the names of all repositories in the registry from its catalog. Registries that don't serve
+// the catalog, e.g. Docker Hub, return an error.
+func (r *Registry) Repositories(ctx context.Context) ([]string, error) {
+	ctx = docker.WithScope(ctx, "registry:catalog:*")
+	var repos []string
+	err := r.list(ctx, "/v2/_catalog", func(body []byte) (int, string, error) {
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return 0, "", err
+		}
+		repos = append(repos, page.Repositories...)
+		last := ""
+		if n := len(page.Repositories); n > 0 {
+			last = page.Repositories[n-1]
+		}
+		return len(page.Repositories), last, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list repositories of registry '%s': %w", r.URL, err)
+	}
+	return repos, nil
+}
+
+// Tags returns the tags of the repository in the registry.
+func (r *Registry) Tags(ctx context.Context, repo string) ([]string, error) {
+	ctx = docker.WithScope(ctx, "repository:"+repo+":pull")
+	var tags []string
+	err := r.list(ctx, "/v2/"+repo+"/tags/list", func(body []byte) (int, string, error) {
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return 0, "", err
+		}
+		tags = append(tags, page.Tags...)
+		last := ""
+		if n := len(page.Tags); n > 0 {
+			last = page.Tags[n-1]
+		}
+		return len(page.Tags), last, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list tags of repository '%s' in registry '%s': %w", repo, r.URL, err)
+	}
+	return tags, nil
+}
+
+// list requests the pages of the paginated list at the API path until a page is shorter than requested. The page
+// function decodes the page body and returns the number of entries in it and the last one to continue from.
+func (r *Registry) list(ctx context.Context, path string, page func([]byte) (int, string, error)) error {
+	last := ""
+	for {
+		query := url.Values{"n": {fmt.Sprint(listPageSize)}}
+		if last != "" {
+			query.Set("last", last)
+		}
+		u := url.URL{Scheme: r.scheme, Host: r.apiHost(), Path: path, RawQuery: query.Encode()}
+		body, err := r.get(ctx, u.String())
+		if err != nil {
+			return err
+		}
+		n, pageLast, err := page(body)
+		if err != nil {
+			return fmt.Errorf("decode response: %w", err)
+		}
+		if n < listPageSize || pageLast == "" {
+			return nil
+		}
+		last = pageLast
+	}
+}
+
+// get sends an authorized GET request to the registry API URL and returns the response body. The request is retried
+// once with the credentials or token the registry challenges for.
+func (r *Registry) get(ctx context.Context, rawURL string) ([]byte, error) {
+	resp, err := r.do(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		err = r.authorizer.AddResponses(ctx, []*http.Response{resp})
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("authorize request: %w", err)
+		}
+		if resp, err = r.do(ctx, rawURL); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", errdefs.ErrNotFound, resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	var body json.RawMessage
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return body, nil
+}
+
+// do sends a GET request to the registry API URL authorized with the credentials or token obtained so far.
+func (r *Registry) do(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.authorizer.Authorize(ctx, req); err != nil {
+		return nil, fmt.Errorf("authorize request: %w", err)
+	}
+	return r.client.Do(req)
+}
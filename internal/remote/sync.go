@@ -0,0 +1,149 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+)
+
+// SyncStatus is the outcome of syncing an image.
+type SyncStatus string
+
+const (
+	// SyncCopied means the image was copied to the destination.
+	SyncCopied SyncStatus = "copied"
+	// SyncUpToDate means the destination already has the tag pointing to the same image.
+	SyncUpToDate SyncStatus = "up-to-date"
+	// SyncPlanned means the image would be copied if it wasn't a dry run.
+	SyncPlanned SyncStatus = "planned"
+	// SyncFailed means the image couldn't be synced.
+	SyncFailed SyncStatus = "failed"
+)
+
+// SyncResult describes the outcome of syncing a tagged image to a destination registry.
+type SyncResult struct {
+	// Image is the image reference in the "<repo>:<tag>" form.
+	Image       string        `json:"image"`
+	Destination string        `json:"destination"`
+	Digest      digest.Digest `json:"digest,omitempty"`
+	Status      SyncStatus    `json:"status"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// SyncOptions select the images to sync and how.
+type SyncOptions struct {
+	// Images are the repositories, e.g. "myapp", or tagged images, e.g. "myapp:1.0", to sync. All tags of
+	// a repository are synced. All repositories in the source catalog are synced if empty.
+	Images []string
+	// Include and Exclude are the patterns of images to sync and skip respectively. A pattern is a glob as in
+	// path.Match, e.g. "myorg/*" or "myapp:v1.*", matching a "<repo>:<tag>" image if it contains a tag, and all
+	// tags of matching repositories otherwise. A pattern prefixed with "regex:" is a regular expression matching
+	// the whole "<repo>:<tag>" image.
+	Include []string
+	Exclude []string
+	// DryRun reports the images that would be copied without copying them.
+	DryRun bool
+}
+
+// Sync copies the selected tagged images from the source registry to each destination registry under the same names.
+// Images the destinations already have are skipped. It returns the result for each image and destination. An error
+// is only returned if the images to sync can't be determined.
+func Sync(ctx context.Context, src *Registry, dsts []*Registry, opts SyncOptions) ([]SyncResult, error) {
+	filter, err := containerd.NewRepositoryFilter(imagePatterns(opts.Include), imagePatterns(opts.Exclude))
+	if err != nil {
+		return nil, err
+	}
+	images, err := listImages(ctx, src, opts.Images)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SyncResult
+	for _, image := range images {
+		if !filter.Allowed(image) {
+			continue
+		}
+		repo, tag, _ := strings.Cut(image, ":")
+		desc, err := src.Resolve(ctx, repo, tag)
+		if err != nil {
+			for _, dst := range dsts {
+				results = append(results, SyncResult{
+					Image:       image,
+					Destination: dst.URL,
+					Status:      SyncFailed,
+					Error:       err.Error(),
+				})
+			}
+			continue
+		}
+
+		for _, dst := range dsts {
+			result := SyncResult{Image: image, Destination: dst.URL, Digest: desc.Digest}
+			existing, err := dst.Resolve(ctx, repo, tag)
+			switch {
+			case err == nil && existing.Digest == desc.Digest:
+				result.Status = SyncUpToDate
+			case err != nil && !errdefs.IsNotFound(err):
+				result.Status, result.Error = SyncFailed, err.Error()
+			case opts.DryRun:
+				result.Status = SyncPlanned
+			default:
+				if err = CopyImage(ctx, src, repo, desc, dst, repo, tag); err != nil {
+					result.Status, result.Error = SyncFailed, err.Error()
+				} else {
+					result.Status = SyncCopied
+				}
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// imagePatterns converts the image patterns to patterns matching the "<repo>:<tag>" images so that a pattern without
+// a tag matches all tags of the repositories.
+func imagePatterns(patterns []string) []string {
+	converted := make([]string, len(patterns))
+	for i, p := range patterns {
+		if !strings.HasPrefix(p, "regex:") && !strings.Contains(p, ":") {
+			p += ":*"
+		}
+		converted[i] = p
+	}
+	return converted
+}
+
+// listImages returns the selected images in the "<repo>:<tag>" form: the tagged images as is and all tags of
+// the repositories. All repositories in the registry catalog are selected if none are.
+func listImages(ctx context.Context, r *Registry, selected []string) ([]string, error) {
+	if len(selected) == 0 {
+		var err error
+		if selected, err = r.Repositories(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var images []string
+	for _, s := range selected {
+		if strings.Contains(s, ":") {
+			images = append(images, s)
+			continue
+		}
+		tags, err := r.Tags(ctx, s)
+		if err != nil {
+			// A repository without tags, e.g. with images pushed by digest only, has nothing to sync.
+			if errors.Is(err, errdefs.ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		for _, tag := range tags {
+			images = append(images, s+":"+tag)
+		}
+	}
+	return images, nil
+}
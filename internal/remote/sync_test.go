@@ -0,0 +1,122 @@
+package remote
+
+import (
+	"context"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/psviderski/unregistry/internal/registrytest"
+)
+
+// newTestRegistry starts a registry with in-memory storage and returns it accessed over plain HTTP.
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	srv := httptest.NewServer(handlers.NewApp(context.Background(), registrytest.AppConfig()))
+	t.Cleanup(srv.Close)
+	r, err := NewRegistry(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// pushImage pushes an image with a config and a layer to the registry with the tags and returns its descriptor.
+func pushImage(t *testing.T, r *Registry, repo string, tags ...string) ocispec.Descriptor {
+	t.Helper()
+	ctx := context.Background()
+	store := registrytest.NewClient(t).ContentStore()
+	desc := registrytest.WriteImage(t, store)
+	for _, tag := range tags {
+		pusher, err := r.pusher(ctx, r.ref(repo)+":"+tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = remotes.PushContent(ctx, pusher, desc, store, nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return desc
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	src := newTestRegistry(t)
+	desc := pushImage(t, src, "myapp", "1.0", "2.0")
+	pushImage(t, src, "other", "latest")
+	synced := newTestRegistry(t)
+	pushImage(t, synced, "myapp", "1.0")
+	empty := newTestRegistry(t)
+
+	tests := []struct {
+		name string
+		opts SyncOptions
+		want []SyncResult
+	}{
+		{
+			name: "dry run",
+			opts: SyncOptions{Exclude: []string{"other"}, DryRun: true},
+			want: []SyncResult{
+				{Image: "myapp:1.0", Destination: synced.URL, Digest: desc.Digest, Status: SyncUpToDate},
+				{Image: "myapp:1.0", Destination: empty.URL, Digest: desc.Digest, Status: SyncPlanned},
+				{Image: "myapp:2.0", Destination: synced.URL, Digest: desc.Digest, Status: SyncPlanned},
+				{Image: "myapp:2.0", Destination: empty.URL, Digest: desc.Digest, Status: SyncPlanned},
+			},
+		},
+		{
+			name: "selected images",
+			opts: SyncOptions{Images: []string{"myapp:2.0", "other"}},
+			want: []SyncResult{
+				{Image: "myapp:2.0", Destination: synced.URL, Digest: desc.Digest, Status: SyncCopied},
+				{Image: "myapp:2.0", Destination: empty.URL, Digest: desc.Digest, Status: SyncCopied},
+				{Image: "other:latest", Destination: synced.URL, Digest: desc.Digest, Status: SyncCopied},
+				{Image: "other:latest", Destination: empty.URL, Digest: desc.Digest, Status: SyncCopied},
+			},
+		},
+		{
+			name: "included tags",
+			opts: SyncOptions{Include: []string{"myapp:1.*"}},
+			want: []SyncResult{
+				{Image: "myapp:1.0", Destination: synced.URL, Digest: desc.Digest, Status: SyncUpToDate},
+				{Image: "myapp:1.0", Destination: empty.URL, Digest: desc.Digest, Status: SyncCopied},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := Sync(ctx, src, []*Registry{synced, empty}, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(results, tt.want) {
+				t.Fatalf("expected results %+v, got %+v", tt.want, results)
+			}
+		})
+	}
+
+	// The copied images are up to date in the destinations.
+	results, err := Sync(ctx, src, []*Registry{synced, empty}, SyncOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Status != SyncUpToDate {
+			t.Fatalf("expected all images to be up to date after syncing, got %+v", r)
+		}
+	}
+	if len(results) != 6 {
+		t.Fatalf("expected 3 images synced to 2 destinations, got %d results", len(results))
+	}
+
+	// A missing image fails to sync to every destination.
+	results, err = Sync(ctx, src, []*Registry{synced, empty}, SyncOptions{Images: []string{"myapp:missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Status != SyncFailed || results[1].Status != SyncFailed {
+		t.Fatalf("expected the missing image to fail for both destinations, got %+v", results)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package sshremote
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// dialAgent connects to the SSH agent listening on the SSH_AUTH_SOCK unix socket.
+func dialAgent() (net.Conn, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errors.New("SSH_AUTH_SOCK is not set")
+	}
+	return net.Dial("unix", sock)
+}
@@ -0,0 +1,27 @@
+package sshremote
+
+import (
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// openSSHAgentPipe is the named pipe of the OpenSSH agent service shipped with Windows.
+const openSSHAgentPipe = `\\.\pipe\openssh-ssh-agent`
+
+// dialAgent connects to the SSH agent at SSH_AUTH_SOCK, a named pipe or a unix socket, or to the named pipe of
+// the Windows OpenSSH agent service if it's not set.
+func dialAgent() (net.Conn, error) {
+	timeout := 2 * time.Second
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	switch {
+	case sock == "":
+		return winio.DialPipe(openSSHAgentPipe, &timeout)
+	case strings.HasPrefix(sock, `\\.\pipe\`):
+		return winio.DialPipe(sock, &timeout)
+	}
+	return net.Dial("unix", sock)
+}
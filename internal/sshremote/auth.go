@@ -0,0 +1,351 @@
+package sshremote
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key checking modes that match the values of the StrictHostKeyChecking option of ssh.
+const (
+	// HostKeyCheckingAsk asks the user to confirm the key of an unknown host and adds it to the known hosts.
+	HostKeyCheckingAsk = "ask"
+	// HostKeyCheckingAcceptNew adds the key of an unknown host to the known hosts without asking.
+	HostKeyCheckingAcceptNew = "accept-new"
+	// HostKeyCheckingYes only connects to known hosts.
+	HostKeyCheckingYes = "yes"
+	// HostKeyCheckingNo connects to any host without checking its key.
+	HostKeyCheckingNo = "no"
+)
+
+// defaultIdentityFiles are the private keys in ~/.ssh tried if no key is specified, like ssh does.
+var defaultIdentityFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// maxPassphraseAttempts is the number of times the passphrase of an encrypted private key is asked.
+const maxPassphraseAttempts = 3
+
+// authMethods returns the SSH authentication methods to log in as login, the USER@HOST the prompts are shown for.
+// The keys from the SSH agent are tried first, then the private key file if specified, otherwise the identity files
+// from ~/.ssh/config or, if there are none, the default keys in ~/.ssh. The identity files that don't exist or can't
+// be parsed are skipped like ssh does. If stdin is a terminal, the passphrases of encrypted keys are asked once
+// the server accepts them, and the keyboard-interactive and password authentication are tried last.
+func authMethods(key string, identityFiles []string, login string) ([]ssh.AuthMethod, error) {
+	interactive := stdinIsTerminal()
+	var agentSigners func() ([]ssh.Signer, error)
+	if conn, err := dialAgent(); err == nil {
+		agentSigners = agent.NewClient(conn).Signers
+	}
+
+	explicit := key != ""
+	if explicit {
+		identityFiles = []string{key}
+	} else if len(identityFiles) == 0 {
+		if home, err := os.UserHomeDir(); err == nil {
+			for _, name := range defaultIdentityFiles {
+				identityFiles = append(identityFiles, filepath.Join(home, ".ssh", name))
+			}
+		}
+	}
+	var signers []ssh.Signer
+	for _, path := range identityFiles {
+		signer, err := loadPrivateKey(path, interactive)
+		if err != nil {
+			var passphraseErr *ssh.PassphraseMissingError
+			if explicit && (agentSigners == nil || !errors.As(err, &passphraseErr)) {
+				return nil, err
+			}
+			continue
+		}
+		signers = append(signers, signer)
+	}
+
+	var methods []ssh.AuthMethod
+	if agentSigners != nil || len(signers) > 0 {
+		// The client tries only one method of each type so the keys from the agent and files are tried as one.
+		methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			var all []ssh.Signer
+			if agentSigners != nil {
+				if s, err := agentSigners(); err == nil {
+					all = append(all, s...)
+				}
+			}
+			return append(all, signers...), nil
+		}))
+	}
+	if interactive {
+		methods = append(methods,
+			ssh.KeyboardInteractive(keyboardInteractive),
+			ssh.PasswordCallback(func() (string, error) {
+				return readPassword(login + "'s password: ")
+			}),
+		)
+	}
+
+	if len(methods) == 0 {
+		return nil, errors.New("no SSH keys found, add a key to the SSH agent or specify the private key file")
+	}
+	return methods, nil
+}
+
+// loadPrivateKey returns the signer of the private key in the file. The passphrase of an encrypted key is asked
+// when it's used to sign if prompt is true, otherwise an error wrapping ssh.PassphraseMissingError is returned.
+func loadPrivateKey(path string, prompt bool) (ssh.Signer, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read SSH private key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	var passphraseErr *ssh.PassphraseMissingError
+	switch {
+	case err == nil:
+		return signer, nil
+	case !errors.As(err, &passphraseErr):
+		return nil, fmt.Errorf("parse SSH private key '%s': %w", path, err)
+	case !prompt:
+		return nil, fmt.Errorf("SSH private key '%s' is encrypted, add it to the SSH agent with ssh-add: %w",
+			path, err)
+	}
+
+	s := &encryptedSigner{path: path, pemBytes: pemBytes, pub: passphraseErr.PublicKey}
+	if s.pub == nil {
+		// Keys in the legacy PEM format don't include the public key, ssh reads it from the .pub file then.
+		if pub, err := os.ReadFile(path + ".pub"); err == nil {
+			s.pub, _, _, _, _ = ssh.ParseAuthorizedKey(pub)
+		}
+	}
+	if s.pub == nil {
+		// The public key is only known after decrypting the private key.
+		if err = s.decrypt(); err != nil {
+			return nil, err
+		}
+		return s.signer, nil
+	}
+	return s, nil
+}
+
+// encryptedSigner is the signer of an encrypted private key that asks for the passphrase the first time it signs,
+// so that it's not asked for the keys the server doesn't accept.
+type encryptedSigner struct {
+	path     string
+	pemBytes []byte
+	pub      ssh.PublicKey
+
+	mu     sync.Mutex
+	signer ssh.Signer
+}
+
+var _ ssh.AlgorithmSigner = &encryptedSigner{}
+
+// PublicKey returns the public key of the private key.
+func (s *encryptedSigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+// Sign signs the data with the decrypted private key.
+func (s *encryptedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+// SignWithAlgorithm signs the data with the decrypted private key using the signature algorithm.
+func (s *encryptedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	if err := s.decrypt(); err != nil {
+		return nil, err
+	}
+	if as, ok := s.signer.(ssh.AlgorithmSigner); ok {
+		return as.SignWithAlgorithm(rand, data, algorithm)
+	}
+	return s.signer.Sign(rand, data)
+}
+
+// decrypt asks for the passphrase of the private key until it's correct or the attempts run out.
+func (s *encryptedSigner) decrypt() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.signer != nil {
+		return nil
+	}
+	for range maxPassphraseAttempts {
+		passphrase, err := readPassword(fmt.Sprintf("Enter passphrase for key '%s': ", s.path))
+		if err != nil {
+			return fmt.Errorf("read passphrase for SSH private key '%s': %w", s.path, err)
+		}
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(s.pemBytes, []byte(passphrase))
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("parse SSH private key '%s': %w", s.path, err)
+		}
+		s.signer = signer
+		return nil
+	}
+	return fmt.Errorf("incorrect passphrase for SSH private key '%s'", s.path)
+}
+
+// keyboardInteractive is the ssh.KeyboardInteractiveChallenge that asks the user the questions of the server in
+// the terminal.
+func keyboardInteractive(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	for _, line := range []string{name, instruction} {
+		if line != "" {
+			fmt.Fprintln(os.Stderr, line)
+		}
+	}
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		var err error
+		if echos[i] {
+			fmt.Fprint(os.Stderr, question)
+			answers[i], err = readLine(os.Stdin)
+		} else {
+			answers[i], err = readPassword(question)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return answers, nil
+}
+
+// hostKeyChecker verifies the keys of remote hosts against the user's ~/.ssh/known_hosts like ssh does.
+type hostKeyChecker struct {
+	mode       string
+	knownHosts string
+	callback   ssh.HostKeyCallback
+}
+
+// newHostKeyChecker creates a host key checker with the mode, one of the HostKeyChecking* constants. An empty mode
+// defaults to HostKeyCheckingAsk.
+func newHostKeyChecker(mode string) (*hostKeyChecker, error) {
+	switch mode {
+	case "":
+		mode = HostKeyCheckingAsk
+	case HostKeyCheckingAsk, HostKeyCheckingAcceptNew, HostKeyCheckingYes, HostKeyCheckingNo:
+	default:
+		return nil, fmt.Errorf("invalid host key checking mode '%s': expected yes, no, ask, or accept-new", mode)
+	}
+	c := &hostKeyChecker{mode: mode}
+	if mode == HostKeyCheckingNo {
+		return c, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("get home directory: %w", err)
+	}
+	c.knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	var files []string
+	if _, err = os.Stat(c.knownHosts); err == nil {
+		files = append(files, c.knownHosts)
+	}
+	if c.callback, err = knownhosts.New(files...); err != nil {
+		return nil, fmt.Errorf("read known hosts: %w", err)
+	}
+	return c, nil
+}
+
+// check is the ssh.HostKeyCallback that verifies the key of the remote host with the address.
+func (c *hostKeyChecker) check(address string, remote net.Addr, key ssh.PublicKey) error {
+	if c.mode == HostKeyCheckingNo {
+		return nil
+	}
+	err := c.callback(address, remote, key)
+	var keyErr *knownhosts.KeyError
+	switch {
+	case err == nil:
+		return nil
+	case !errors.As(err, &keyErr):
+		return err
+	case len(keyErr.Want) > 0:
+		return fmt.Errorf("host key of '%s' has changed, it may be a man-in-the-middle attack, "+
+			"remove the old key from %s if the change is expected: %w", address, c.knownHosts, err)
+	}
+
+	// The host is unknown.
+	fingerprint := ssh.FingerprintSHA256(key)
+	switch c.mode {
+	case HostKeyCheckingYes:
+		return fmt.Errorf("host '%s' is not in %s and host key checking is strict", address, c.knownHosts)
+	case HostKeyCheckingAsk:
+		if !confirmHostKey(address, key.Type(), fingerprint) {
+			return fmt.Errorf("host key verification of '%s' failed", address)
+		}
+	}
+	return c.addKnownHost(address, key)
+}
+
+// hostKeyAlgorithms returns the key algorithms of the host with the address in the known hosts to negotiate a key
+// that can be verified, or nil if the host is unknown.
+func (c *hostKeyChecker) hostKeyAlgorithms(address string) []string {
+	if c.callback == nil {
+		return nil
+	}
+	// A placeholder key doesn't match any known key so the error lists the known keys of the host.
+	placeholder, _ := ssh.NewPublicKey(ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)).Public())
+	var keyErr *knownhosts.KeyError
+	if err := c.callback(address, &net.TCPAddr{}, placeholder); !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var algos []string
+	for _, known := range keyErr.Want {
+		typ := known.Key.Type()
+		if typ == ssh.KeyAlgoRSA {
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algos = append(algos, typ)
+	}
+	return algos
+}
+
+// addKnownHost appends the key of the host with the address to the known hosts file.
+func (c *hostKeyChecker) addKnownHost(address string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(c.knownHosts), 0o700); err != nil {
+		return fmt.Errorf("create SSH directory: %w", err)
+	}
+	f, err := os.OpenFile(c.knownHosts, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("open known hosts: %w", err)
+	}
+	defer f.Close()
+
+	if _, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(address)}, key)); err != nil {
+		return fmt.Errorf("add host to known hosts: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Permanently added '%s' (%s) to the list of known hosts.\n", address, key.Type())
+	return nil
+}
+
+// confirmHostKey asks the user whether to trust the key of an unknown host if stdin is a terminal.
+func confirmHostKey(address, keyType, fingerprint string) bool {
+	if !stdinIsTerminal() {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "The authenticity of host '%s' can't be established.\n", address)
+	fmt.Fprintf(os.Stderr, "%s key fingerprint is %s.\n", keyType, fingerprint)
+	stdin := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprint(os.Stderr, "Are you sure you want to continue connecting (yes/no)? ")
+		answer, err := stdin.ReadString('\n')
+		if err != nil {
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "yes":
+			return true
+		case "no":
+			return false
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package sshremote
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// withTerminal replaces the check whether stdin is a terminal for the test and disables the SSH agent.
+func withTerminal(t *testing.T, terminal bool) {
+	t.Helper()
+	t.Setenv("SSH_AUTH_SOCK", "")
+	orig := stdinIsTerminal
+	stdinIsTerminal = func() bool { return terminal }
+	t.Cleanup(func() { stdinIsTerminal = orig })
+}
+
+// writeKey generates an ed25519 private key, encrypted if passphrase isn't empty, and writes it to the file.
+func writeKey(t *testing.T, path, passphrase string) ssh.PublicKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sshPub
+}
+
+// authenticate authenticates with the methods to a local SSH server that accepts only the accepted key. It
+// returns the fingerprints of the public keys offered to the server.
+func authenticate(t *testing.T, methods []ssh.AuthMethod, accepted ssh.PublicKey) ([]string, error) {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu      sync.Mutex
+		offered []string
+	)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			fingerprint := ssh.FingerprintSHA256(key)
+			if len(offered) == 0 || offered[len(offered)-1] != fingerprint {
+				offered = append(offered, fingerprint)
+			}
+			if accepted != nil && fingerprint == ssh.FingerprintSHA256(accepted) {
+				return nil, nil
+			}
+			return nil, errors.New("key not accepted")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	// Both ends send their version first, which would block on an unbuffered net.Pipe.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		serverConn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer serverConn.Close()
+		if conn, _, _, err := ssh.NewServerConn(serverConn, config); err == nil {
+			_ = conn.Close()
+		}
+	}()
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+	c, _, _, err := ssh.NewClientConn(clientConn, "test", &ssh.ClientConfig{
+		User:            "user",
+		Auth:            methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		_ = c.Close()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return offered, err
+}
+
+func TestAuthMethods(t *testing.T) {
+	t.Run("no keys", func(t *testing.T) {
+		withTerminal(t, false)
+		t.Setenv("HOME", t.TempDir())
+		if _, err := authMethods("", nil, "user@host"); err == nil {
+			t.Fatal("expected error without keys")
+		}
+	})
+
+	t.Run("default keys", func(t *testing.T) {
+		withTerminal(t, false)
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		rsa := writeKey(t, filepath.Join(home, ".ssh", "id_rsa"), "")
+		ed := writeKey(t, filepath.Join(home, ".ssh", "id_ed25519"), "")
+
+		methods, err := authMethods("", nil, "user@host")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// All keys are offered even though they're in different files.
+		offered, err := authenticate(t, methods, rsa)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := []string{ssh.FingerprintSHA256(ed), ssh.FingerprintSHA256(rsa)}
+		if strings.Join(offered, ",") != strings.Join(want, ",") {
+			t.Fatalf("expected keys %v to be offered, got %v", want, offered)
+		}
+	})
+
+	t.Run("config identity files", func(t *testing.T) {
+		withTerminal(t, false)
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		writeKey(t, filepath.Join(home, ".ssh", "id_ed25519"), "")
+		key := writeKey(t, filepath.Join(home, ".ssh", "deploy_key"), "")
+		invalid := filepath.Join(home, ".ssh", "invalid_key")
+		if err := os.WriteFile(invalid, []byte("not a key"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		encrypted := filepath.Join(home, ".ssh", "encrypted_key")
+		writeKey(t, encrypted, "secret")
+
+		// Missing, invalid, and encrypted identity files are skipped and the default keys aren't used.
+		methods, err := authMethods("", []string{
+			filepath.Join(home, ".ssh", "missing_key"), invalid, encrypted, filepath.Join(home, ".ssh", "deploy_key"),
+		}, "user@host")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		offered, err := authenticate(t, methods, key)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(offered) != 1 || offered[0] != ssh.FingerprintSHA256(key) {
+			t.Fatalf("expected only the deploy key to be offered, got %v", offered)
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		withTerminal(t, false)
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		writeKey(t, filepath.Join(home, ".ssh", "id_ed25519"), "")
+		if _, err := authMethods(filepath.Join(home, "missing"), nil, "user@host"); err == nil {
+			t.Fatal("expected error for missing key")
+		}
+	})
+
+	t.Run("encrypted key without terminal", func(t *testing.T) {
+		withTerminal(t, false)
+		path := filepath.Join(t.TempDir(), "key")
+		writeKey(t, path, "secret")
+		_, err := authMethods(path, nil, "user@host")
+		if err == nil || !strings.Contains(err.Error(), "ssh-add") {
+			t.Fatalf("expected error suggesting ssh-add, got %v", err)
+		}
+	})
+
+	t.Run("encrypted key not accepted", func(t *testing.T) {
+		withTerminal(t, true)
+		path := filepath.Join(t.TempDir(), "key")
+		pub := writeKey(t, path, "secret")
+
+		methods, err := authMethods(path, nil, "user@host")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// The passphrase isn't asked as the server doesn't accept the key.
+		offered, err := authenticate(t, methods, nil)
+		if err == nil || strings.Contains(err.Error(), "passphrase") {
+			t.Fatalf("expected authentication to fail without asking the passphrase, got %v", err)
+		}
+		if len(offered) != 1 || offered[0] != ssh.FingerprintSHA256(pub) {
+			t.Fatalf("expected the encrypted key to be offered, got %v", offered)
+		}
+	})
+}
+
+func TestHostKeyChecker(t *testing.T) {
+	withTerminal(t, false)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	const address = "example.com:22"
+	remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+	key := writeKey(t, filepath.Join(t.TempDir(), "host_key"), "")
+	otherKey := writeKey(t, filepath.Join(t.TempDir(), "other_host_key"), "")
+
+	if _, err := newHostKeyChecker("maybe"); err == nil {
+		t.Fatal("expected error for invalid mode")
+	}
+
+	tests := []struct {
+		name    string
+		mode    string
+		key     ssh.PublicKey
+		wantErr string
+	}{
+		{name: "no checking", mode: HostKeyCheckingNo, key: key},
+		{name: "strict unknown host", mode: HostKeyCheckingYes, key: key, wantErr: "host key checking is strict"},
+		{name: "ask without terminal", mode: HostKeyCheckingAsk, key: key, wantErr: "verification"},
+		{name: "accept new host", mode: HostKeyCheckingAcceptNew, key: key},
+		{name: "strict known host", mode: HostKeyCheckingYes, key: key},
+		{name: "changed key", mode: HostKeyCheckingAcceptNew, key: otherKey, wantErr: "has changed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker, err := newHostKeyChecker(tt.mode)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err = checker.check(address, remote, tt.key)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	checker, err := newHostKeyChecker(HostKeyCheckingYes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if algos := checker.hostKeyAlgorithms(address); len(algos) != 1 || algos[0] != ssh.KeyAlgoED25519 {
+		t.Fatalf("expected the algorithm of the known host key, got %v", algos)
+	}
+	if algos := checker.hostKeyAlgorithms("unknown.example.com:22"); algos != nil {
+		t.Fatalf("expected no algorithms for unknown host, got %v", algos)
+	}
+}
@@ -0,0 +1,127 @@
+package sshremote
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// hostConfig is the subset of the OpenSSH client configuration for a host that is used to connect to it.
+type hostConfig struct {
+	hostName      string
+	user          string
+	port          string
+	identityFiles []string
+	// proxyJump is the comma-separated list of jump hosts to connect through, or "none".
+	proxyJump string
+	// proxyCommand is the command to connect with, or "none". It's not supported and only used to report it.
+	proxyCommand string
+}
+
+// lookupHostConfig returns the configuration for the host from the user's ~/.ssh/config so that the host aliases,
+// users, ports, keys, and jump hosts configured for the ssh command also work here. Only the Host blocks with
+// the HostName, User, Port, IdentityFile, ProxyJump, and ProxyCommand options are supported. Match blocks and Include
+// directives are ignored.
+func lookupHostConfig(host string) hostConfig {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return hostConfig{}
+	}
+	f, err := os.Open(filepath.Join(home, ".ssh", "config"))
+	if err != nil {
+		return hostConfig{}
+	}
+	defer f.Close()
+
+	var cfg hostConfig
+	// Options before the first Host block apply to all hosts.
+	matched := true
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value := parseConfigLine(scanner.Text())
+		switch key {
+		case "":
+			continue
+		case "host":
+			matched = matchHostPatterns(host, strings.Fields(value))
+			continue
+		case "match":
+			matched = false
+			continue
+		}
+		if !matched {
+			continue
+		}
+
+		// The first obtained value of an option is used, except for IdentityFile that can be specified multiple times.
+		switch key {
+		case "hostname":
+			if cfg.hostName == "" {
+				cfg.hostName = strings.ReplaceAll(value, "%h", host)
+			}
+		case "user":
+			if cfg.user == "" {
+				cfg.user = value
+			}
+		case "port":
+			if cfg.port == "" {
+				cfg.port = value
+			}
+		case "identityfile":
+			cfg.identityFiles = append(cfg.identityFiles, expandHome(value, home))
+		case "proxyjump", "proxycommand":
+			// ProxyJump and ProxyCommand are mutually exclusive, the first one obtained is used.
+			if cfg.proxyJump != "" || cfg.proxyCommand != "" {
+				continue
+			}
+			if key == "proxyjump" {
+				cfg.proxyJump = value
+			} else {
+				cfg.proxyCommand = value
+			}
+		}
+	}
+	return cfg
+}
+
+// parseConfigLine returns the lowercase option name and the unquoted value of the "Key value" or "Key=value" line.
+// The key is empty for blank lines and comments.
+func parseConfigLine(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", ""
+	}
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return strings.ToLower(line), ""
+	}
+	value := strings.TrimSpace(strings.TrimPrefix(strings.TrimLeft(line[i:], " \t"), "="))
+	return strings.ToLower(line[:i]), strings.Trim(value, `"`)
+}
+
+// matchHostPatterns reports whether the host matches any of the Host patterns and none of the negated ones.
+func matchHostPatterns(host string, patterns []string) bool {
+	matched := false
+	for _, p := range patterns {
+		negated := strings.HasPrefix(p, "!")
+		ok, _ := path.Match(strings.ToLower(strings.TrimPrefix(p, "!")), strings.ToLower(host))
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+// expandHome replaces the leading ~ in the path with the home directory.
+func expandHome(p, home string) string {
+	if p == "~" {
+		return home
+	}
+	if strings.HasPrefix(p, "~/") {
+		return filepath.Join(home, p[2:])
+	}
+	return p
+}
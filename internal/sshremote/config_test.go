@@ -0,0 +1,150 @@
+package sshremote
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseConfigLine(t *testing.T) {
+	tests := []struct {
+		line      string
+		wantKey   string
+		wantValue string
+	}{
+		{line: ""},
+		{line: "   "},
+		{line: "# HostName example.com"},
+		{line: "HostName example.com", wantKey: "hostname", wantValue: "example.com"},
+		{line: "  Port\t2222", wantKey: "port", wantValue: "2222"},
+		{line: "User=deploy", wantKey: "user", wantValue: "deploy"},
+		{line: "User = deploy", wantKey: "user", wantValue: "deploy"},
+		{line: `IdentityFile "~/.ssh/my key"`, wantKey: "identityfile", wantValue: "~/.ssh/my key"},
+		{line: "Host a b !c", wantKey: "host", wantValue: "a b !c"},
+		{line: "Compression", wantKey: "compression"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			key, value := parseConfigLine(tt.line)
+			if key != tt.wantKey || value != tt.wantValue {
+				t.Fatalf("expected %q = %q, got %q = %q", tt.wantKey, tt.wantValue, key, value)
+			}
+		})
+	}
+}
+
+func TestMatchHostPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		patterns []string
+		want     bool
+	}{
+		{name: "exact", host: "prod", patterns: []string{"prod"}, want: true},
+		{name: "case insensitive", host: "PROD", patterns: []string{"prod"}, want: true},
+		{name: "wildcard", host: "web1.example.com", patterns: []string{"*.example.com"}, want: true},
+		{name: "single character wildcard", host: "web1", patterns: []string{"web?"}, want: true},
+		{name: "any of patterns", host: "db", patterns: []string{"web", "db"}, want: true},
+		{name: "no match", host: "db", patterns: []string{"web"}, want: false},
+		{name: "negated", host: "db.example.com", patterns: []string{"*.example.com", "!db.*"}, want: false},
+		{name: "negated only", host: "web", patterns: []string{"!db"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchHostPatterns(tt.host, tt.patterns); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// writeSSHConfig writes the SSH config to ~/.ssh/config in a new home directory and returns the directory.
+func writeSSHConfig(t *testing.T, config string) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".ssh", "config"), []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return home
+}
+
+func TestLookupHostConfig(t *testing.T) {
+	home := writeSSHConfig(t, `
+IdentityFile ~/.ssh/global_key
+
+Host prod
+    HostName %h.example.com
+    User deploy
+    Port 2222
+    IdentityFile ~/.ssh/deploy_key
+    ProxyJump bastion
+
+Host legacy
+    ProxyCommand nc -X connect -x proxy:3128 %h %p
+    ProxyJump bastion
+
+Host direct
+    ProxyJump none
+
+Match host prod
+    User ignored
+
+Host *
+    User fallback
+    Port 22
+    ProxyJump other
+`)
+
+	tests := []struct {
+		host string
+		want hostConfig
+	}{
+		{
+			host: "prod",
+			want: hostConfig{
+				hostName: "prod.example.com",
+				user:     "deploy",
+				port:     "2222",
+				identityFiles: []string{
+					filepath.Join(home, ".ssh", "global_key"),
+					filepath.Join(home, ".ssh", "deploy_key"),
+				},
+				proxyJump: "bastion",
+			},
+		},
+		{
+			host: "legacy",
+			want: hostConfig{
+				user:          "fallback",
+				port:          "22",
+				identityFiles: []string{filepath.Join(home, ".ssh", "global_key")},
+				proxyCommand:  "nc -X connect -x proxy:3128 %h %p",
+			},
+		},
+		{
+			host: "direct",
+			want: hostConfig{
+				user:          "fallback",
+				port:          "22",
+				identityFiles: []string{filepath.Join(home, ".ssh", "global_key")},
+				proxyJump:     "none",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			got := lookupHostConfig(tt.host)
+			if got.hostName != tt.want.hostName || got.user != tt.want.user || got.port != tt.want.port ||
+				got.proxyJump != tt.want.proxyJump || got.proxyCommand != tt.want.proxyCommand ||
+				!slices.Equal(got.identityFiles, tt.want.identityFiles) {
+				t.Fatalf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
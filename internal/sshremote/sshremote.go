@@ -0,0 +1,547 @@
+// Package sshremote runs unregistry on a remote host over SSH and forwards its port to the local host, so images can
+// be transferred from or to the remote host without an external registry. It uses a native SSH client that honours
+// the user's SSH agent, known hosts, and the basic host options in ~/.ssh/config, so no ssh binary is required.
+package sshremote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/psviderski/unregistry/internal/version"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultUnregistryImage is the unregistry image started on the remote host. Release builds use the image of their
+// own version to avoid pulling the latest image every time it changes.
+var DefaultUnregistryImage = defaultUnregistryImage()
+
+func defaultUnregistryImage() string {
+	if version.Version == "dev" {
+		return "ghcr.io/psviderski/unregistry:latest"
+	}
+	return "ghcr.io/psviderski/unregistry:" + version.Version
+}
+
+// dialTimeout is the timeout of establishing the SSH connection.
+const dialTimeout = 15 * time.Second
+
+// dockerPaths are the common docker command paths checked on the remote host.
+var dockerPaths = []string{
+	"docker",
+	"/usr/bin/docker",
+	"/usr/local/bin/docker",
+	"/opt/docker/bin/docker",
+	"/snap/bin/docker",
+	"/home/linuxbrew/.linuxbrew/bin/docker",
+	"/usr/sbin/docker",
+	// CoreELEC: https://wiki.coreelec.org/coreelec:docker
+	"/storage/.docker/bin/docker",
+}
+
+// containerdSockets are the common containerd socket paths checked on the remote host.
+var containerdSockets = []string{
+	"/run/containerd/containerd.sock",
+	"/var/run/docker/containerd/containerd.sock",
+	"/var/run/containerd/containerd.sock",
+	"/run/docker/containerd/containerd.sock",
+	"/run/snap.docker/containerd/containerd.sock",
+}
+
+// Options configures the SSH connection.
+type Options struct {
+	// Key is the path to the SSH private key to log in with if it's not added to the SSH agent. Optional.
+	Key string
+	// HostKeyChecking is the host key checking mode, one of the HostKeyChecking* constants. Defaults to
+	// HostKeyCheckingAsk.
+	HostKeyChecking string
+}
+
+// maxJumps is the maximum number of jump hosts to connect through, including the jump hosts of the jump hosts.
+const maxJumps = 8
+
+// Conn is an SSH connection to the remote host.
+type Conn struct {
+	client *ssh.Client
+	// jumps are the connections to the jump hosts the connection goes through, in the order they were established.
+	jumps []*ssh.Client
+
+	mu        sync.Mutex
+	listeners []net.Listener
+}
+
+// Dial establishes an SSH connection to the remote host with the [USER@]HOST[:PORT] address. An IPv6 address with
+// a port must be enclosed in square brackets. The HOST can be an alias from ~/.ssh/config. The connection goes
+// through the jump hosts from the ProxyJump option of the host. ProxyCommand isn't supported.
+func Dial(ctx context.Context, address string, opts Options) (*Conn, error) {
+	conn := &Conn{}
+	client, err := dial(ctx, address, opts, 0, &conn.jumps)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.client = client
+	return conn, nil
+}
+
+// endpoint is an SSH server resolved with the options of its host from ~/.ssh/config.
+type endpoint struct {
+	// address is the [USER@]HOST[:PORT] address the endpoint is resolved from.
+	address string
+	user    string
+	// addr is the HOST:PORT network address of the server.
+	addr string
+	cfg  hostConfig
+}
+
+// resolveEndpoint resolves the [USER@]HOST[:PORT] address using the options of the host from ~/.ssh/config.
+func resolveEndpoint(address string) (endpoint, error) {
+	target, port, err := ParseAddress(address)
+	if err != nil {
+		return endpoint{}, err
+	}
+	username, host, ok := strings.Cut(target, "@")
+	if !ok {
+		username, host = "", target
+	}
+
+	cfg := lookupHostConfig(host)
+	hostName := host
+	if cfg.hostName != "" {
+		hostName = cfg.hostName
+	}
+	if username == "" {
+		username = cfg.user
+	}
+	if username == "" {
+		u, err := user.Current()
+		if err != nil {
+			return endpoint{}, fmt.Errorf("get current user: %w", err)
+		}
+		// Windows user names are prefixed with the domain.
+		_, username, _ = strings.Cut(u.Username, `\`)
+		if username == "" {
+			username = u.Username
+		}
+	}
+	if port == "" {
+		port = cfg.port
+	}
+	if port == "" {
+		port = "22"
+	}
+	return endpoint{address: address, user: username, addr: net.JoinHostPort(hostName, port), cfg: cfg}, nil
+}
+
+// dial connects to the host with the address through the jump hosts from its ProxyJump option, if any. The depth is
+// the number of jump hosts already used to reach the host. The connections to the jump hosts are appended to jumps.
+func dial(ctx context.Context, address string, opts Options, depth int, jumps *[]*ssh.Client) (*ssh.Client, error) {
+	ep, err := resolveEndpoint(address)
+	if err != nil {
+		return nil, err
+	}
+	if ep.cfg.proxyCommand != "" && !strings.EqualFold(ep.cfg.proxyCommand, "none") {
+		return nil, fmt.Errorf("ProxyCommand of host '%s' in ~/.ssh/config is not supported, "+
+			"use ProxyJump to connect through a jump host", address)
+	}
+
+	var via *ssh.Client
+	if ep.cfg.proxyJump != "" && !strings.EqualFold(ep.cfg.proxyJump, "none") {
+		hops := strings.Split(ep.cfg.proxyJump, ",")
+		if depth+len(hops) > maxJumps {
+			return nil, fmt.Errorf("connect to '%s' over SSH: more than %d jump hosts", address, maxJumps)
+		}
+		// The key of the target host doesn't apply to the jump hosts.
+		hopOpts := Options{HostKeyChecking: opts.HostKeyChecking}
+		for _, hop := range hops {
+			hop = strings.TrimPrefix(strings.TrimSpace(hop), "ssh://")
+			if via == nil {
+				// The first jump host is reached the way it's configured, possibly through its own jump hosts.
+				via, err = dial(ctx, hop, hopOpts, depth+len(hops), jumps)
+			} else {
+				// The next jump hosts are reached through the previous ones ignoring their ProxyJump options.
+				var hopEndpoint endpoint
+				if hopEndpoint, err = resolveEndpoint(hop); err == nil {
+					via, err = connect(ctx, hopEndpoint, hopOpts, via)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			*jumps = append(*jumps, via)
+		}
+	}
+	return connect(ctx, ep, opts, via)
+}
+
+// connect establishes an SSH connection to the endpoint, directly or through the via connection if it's not nil.
+func connect(ctx context.Context, ep endpoint, opts Options, via *ssh.Client) (*ssh.Client, error) {
+	host, _, _ := net.SplitHostPort(ep.addr)
+	auth, err := authMethods(opts.Key, ep.cfg.identityFiles, ep.user+"@"+host)
+	if err != nil {
+		return nil, err
+	}
+	checker, err := newHostKeyChecker(opts.HostKeyChecking)
+	if err != nil {
+		return nil, err
+	}
+
+	var netConn net.Conn
+	if via != nil {
+		dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+		netConn, err = via.DialContext(dialCtx, "tcp", ep.addr)
+		cancel()
+	} else {
+		dialer := net.Dialer{Timeout: dialTimeout}
+		netConn, err = dialer.DialContext(ctx, "tcp", ep.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect to '%s' over SSH: %w", ep.address, err)
+	}
+
+	// Bound the key exchange as well as a stalled server would block it forever. Connections through a jump host
+	// don't support deadlines so the connection is closed instead. The timer is stopped once the server sends its
+	// host key so that the user isn't rushed to confirm it or enter a password.
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(dialTimeout, func() {
+		timedOut.Store(true)
+		_ = netConn.Close()
+	})
+	config := &ssh.ClientConfig{
+		User: ep.user,
+		Auth: auth,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			timer.Stop()
+			if timedOut.Load() {
+				return errors.New("handshake timed out")
+			}
+			return checker.check(hostname, remote, key)
+		},
+		HostKeyAlgorithms: checker.hostKeyAlgorithms(ep.addr),
+	}
+	c, chans, reqs, err := ssh.NewClientConn(netConn, ep.addr, config)
+	if err != nil {
+		timer.Stop()
+		_ = netConn.Close()
+		return nil, fmt.Errorf("connect to '%s' over SSH: %w", ep.address, err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// ParseAddress splits the [USER@]HOST[:PORT] address into the SSH target [USER@]HOST and the port.
+func ParseAddress(address string) (target, port string, err error) {
+	user, host, ok := strings.Cut(address, "@")
+	if !ok {
+		user, host = "", address
+	}
+
+	switch {
+	case strings.HasPrefix(host, "["):
+		h, p, err := net.SplitHostPort(host)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid SSH address '%s': %w", address, err)
+		}
+		host, port = h, p
+	case strings.Count(host, ":") == 1:
+		host, port, _ = strings.Cut(host, ":")
+	}
+	if host == "" {
+		return "", "", fmt.Errorf("invalid SSH address '%s': host is empty", address)
+	}
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", "", fmt.Errorf("invalid SSH port '%s': expected a number between 1 and 65535", port)
+		}
+	}
+
+	if user != "" {
+		return user + "@" + host, port, nil
+	}
+	return host, port, nil
+}
+
+// Run runs the command on the remote host and returns its combined output.
+func (c *Conn) Run(ctx context.Context, command string) (string, error) {
+	var output bytes.Buffer
+	if err := c.Stream(ctx, command, &output, &output); err != nil {
+		return output.String(), fmt.Errorf("%w: %s", err, strings.TrimSpace(output.String()))
+	}
+	return output.String(), nil
+}
+
+// Stream runs the command on the remote host writing its output to stdout and stderr as it runs.
+func (c *Conn) Stream(ctx context.Context, command string, stdout, stderr io.Writer) error {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("open SSH session: %w", err)
+	}
+	defer session.Close()
+	session.Stdout, session.Stderr = stdout, stderr
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(command)
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGTERM)
+		_ = session.Close()
+		err = ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("run '%s' on remote host: %w", command, err)
+	}
+	return nil
+}
+
+// Forward forwards a free port on the local loopback interface to the address on the remote host until
+// the connection is closed or the context is done, and returns the local port.
+func (c *Conn) Forward(ctx context.Context, remoteAddr string) (int, error) {
+	// Listening on a port chosen by the system avoids races with other processes looking for a free port.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, fmt.Errorf("listen on local port to forward to remote address %s: %w", remoteAddr, err)
+	}
+	c.mu.Lock()
+	c.listeners = append(c.listeners, ln)
+	c.mu.Unlock()
+	context.AfterFunc(ctx, func() {
+		_ = ln.Close()
+	})
+
+	go func() {
+		for {
+			local, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go c.forwardConn(local, remoteAddr)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// forwardConn copies the data between the local connection and a new connection to the remote address.
+func (c *Conn) forwardConn(local net.Conn, remoteAddr string) {
+	defer local.Close()
+	remote, err := c.client.Dial("tcp", remoteAddr)
+	if err != nil {
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	// Closing both connections when either direction is finished unblocks the other one.
+	<-done
+}
+
+// Close stops the port forwarding and closes the SSH connection.
+func (c *Conn) Close() {
+	c.mu.Lock()
+	for _, ln := range c.listeners {
+		_ = ln.Close()
+	}
+	c.listeners = nil
+	c.mu.Unlock()
+	if c.client != nil {
+		_ = c.client.Close()
+	}
+	// Close the jump host connections after the connections through them.
+	for i := len(c.jumps) - 1; i >= 0; i-- {
+		_ = c.jumps[i].Close()
+	}
+}
+
+// Container is a container started on the remote host.
+type Container struct {
+	Name string
+	// Docker is the docker command on the remote host, possibly with sudo.
+	Docker string
+}
+
+// Remove removes the container from the remote host ignoring errors.
+func (c Container) Remove(ctx context.Context, conn *Conn) {
+	_, _ = conn.Run(ctx, c.Docker+" rm -f "+c.Name)
+}
+
+// RemoteDocker returns the docker command that works on the remote host, with sudo if the user isn't allowed to run
+// docker directly. The docker binary is looked up in the common locations unless the path is specified.
+func RemoteDocker(ctx context.Context, conn *Conn, path string) (string, error) {
+	paths := dockerPaths
+	if path != "" {
+		paths = []string{path}
+	}
+	found := ""
+	for _, p := range paths {
+		if _, err := conn.Run(ctx, fmt.Sprintf("test -x %[1]s || command -v %[1]s", ShellQuote(p))); err == nil {
+			found = ShellQuote(p)
+			break
+		}
+	}
+	if found == "" {
+		if path != "" {
+			return "", fmt.Errorf("docker command not found on remote host at '%s'", path)
+		}
+		return "", errors.New("docker command not found on remote host, please ensure Docker is installed")
+	}
+
+	_, err := conn.Run(ctx, found+" version")
+	if err == nil {
+		return found, nil
+	}
+	if _, sudoErr := conn.Run(ctx, "[ $(id -u) -ne 0 ] && sudo -n "+found+" version"); sudoErr == nil {
+		return "sudo -n " + found, nil
+	}
+	return "", fmt.Errorf("run docker on remote host, please ensure Docker is running and the SSH user is root, "+
+		"in the 'docker' group, or can run 'sudo docker' without a password: %w", err)
+}
+
+// UnregistryOptions configures the unregistry container started on the remote host.
+type UnregistryOptions struct {
+	// Image is the unregistry image to run.
+	Image string
+	// NamePrefix is the prefix of the container name.
+	NamePrefix string
+	// Docker is the docker command on the remote host. Detected if empty.
+	Docker string
+	// ContainerdSocket is the path to the containerd socket on the remote host. Detected if empty.
+	ContainerdSocket string
+	// DockerBackend makes unregistry store images through the Docker Engine API instead of containerd, e.g. when
+	// Docker on the remote host uses the classic image store.
+	DockerBackend bool
+	// Args are the extra arguments of the unregistry command.
+	Args []string
+	// PullOutput receives the progress of pulling the unregistry image if it's missing on the remote host. Discarded
+	// if nil.
+	PullOutput io.Writer
+}
+
+// StartUnregistry starts a temporary unregistry container on the remote host bound to a random port on its loopback
+// interface and returns the container and the port. The container removes itself if left idle.
+func StartUnregistry(ctx context.Context, conn *Conn, opts UnregistryOptions) (Container, int, error) {
+	dockerCmd := opts.Docker
+	if dockerCmd == "" {
+		var err error
+		if dockerCmd, err = RemoteDocker(ctx, conn, ""); err != nil {
+			return Container{}, 0, err
+		}
+	}
+
+	args := []string{"--idle-exit", "30m"}
+	var mount string
+	if opts.DockerBackend {
+		mount = "-v /var/run/docker.sock:/var/run/docker.sock"
+		args = append(args, "--backend", "docker")
+	} else {
+		sock := opts.ContainerdSocket
+		if sock == "" {
+			sock = findContainerdSocket(ctx, conn, dockerCmd)
+		}
+		mount = fmt.Sprintf("-v %s:/run/containerd/containerd.sock", ShellQuote(sock))
+	}
+	quotedArgs := make([]string, 0, len(args)+len(opts.Args))
+	for _, arg := range append(args, opts.Args...) {
+		quotedArgs = append(quotedArgs, ShellQuote(arg))
+	}
+
+	image := ShellQuote(opts.Image)
+	if _, err := conn.Run(ctx, dockerCmd+" image inspect "+image); err != nil {
+		pullOutput := opts.PullOutput
+		if pullOutput == nil {
+			pullOutput = io.Discard
+		}
+		if err = conn.Stream(ctx, dockerCmd+" pull "+image, pullOutput, pullOutput); err != nil {
+			return Container{}, 0, fmt.Errorf("pull unregistry image on remote host: %w", err)
+		}
+	}
+
+	var err error
+	for range 10 {
+		port := 55000 + rand.IntN(10536)
+		container := Container{
+			Name:   fmt.Sprintf("%s-%d-%d", opts.NamePrefix, os.Getpid(), port),
+			Docker: dockerCmd,
+		}
+		var output string
+		output, err = conn.Run(ctx, fmt.Sprintf(
+			"%s run -d --rm --name %s -p 127.0.0.1:%d:5000 %s --userns=host --user root:root %s %s",
+			dockerCmd, container.Name, port, mount, image, strings.Join(quotedArgs, " "),
+		))
+		if err == nil {
+			return container, port, nil
+		}
+		container.Remove(ctx, conn)
+		// Retry with another port only if the port is already in use.
+		if !strings.Contains(strings.ToLower(output), "bind") {
+			break
+		}
+	}
+	return Container{}, 0, fmt.Errorf("start unregistry container on remote host: %w", err)
+}
+
+// findContainerdSocket returns the path to the containerd socket on the remote host, or the default path if none of
+// the common paths exists.
+func findContainerdSocket(ctx context.Context, conn *Conn, dockerCmd string) string {
+	// OrbStack runs Docker in a lightweight VM where the socket is at a path that doesn't exist on the macOS host.
+	if info, err := conn.Run(ctx, dockerCmd+" info"); err == nil && strings.Contains(info, "OrbStack") {
+		return "/run/docker/containerd/containerd.sock"
+	}
+	for _, path := range containerdSockets {
+		test := fmt.Sprintf("test -S %[1]s || sudo -n test -S %[1]s", ShellQuote(path))
+		if _, err := conn.Run(ctx, test); err == nil {
+			return path
+		}
+	}
+	return containerdSockets[0]
+}
+
+// ShellQuote quotes the string for a POSIX shell.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// WaitRegistry waits until the registry at the host responds to the API version check.
+func WaitRegistry(ctx context.Context, host string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var err error
+	for {
+		var resp *http.Response
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/v2/", nil)
+		if resp, err = http.DefaultClient.Do(req); err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait for remote unregistry to become ready: %w", errors.Join(ctx.Err(), err))
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
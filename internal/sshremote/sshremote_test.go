@@ -0,0 +1,44 @@
+package sshremote
+
+import "testing"
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		address    string
+		wantTarget string
+		wantPort   string
+		wantErr    bool
+	}{
+		{address: "host", wantTarget: "host"},
+		{address: "user@host", wantTarget: "user@host"},
+		{address: "host:2222", wantTarget: "host", wantPort: "2222"},
+		{address: "user@host:2222", wantTarget: "user@host", wantPort: "2222"},
+		{address: "user@[::1]:2222", wantTarget: "user@::1", wantPort: "2222"},
+		{address: "[fe80::1]:22", wantTarget: "fe80::1", wantPort: "22"},
+		// An IPv6 address without a port doesn't need brackets.
+		{address: "user@fe80::1", wantTarget: "user@fe80::1"},
+		{address: "user@", wantErr: true},
+		{address: ":22", wantErr: true},
+		{address: "host:0", wantErr: true},
+		{address: "host:65536", wantErr: true},
+		{address: "host:ssh", wantErr: true},
+		{address: "[::1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			target, port, err := ParseAddress(tt.address)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got target %q and port %q", target, port)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if target != tt.wantTarget || port != tt.wantPort {
+				t.Fatalf("expected target %q and port %q, got %q and %q", tt.wantTarget, tt.wantPort, target, port)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package sshremote
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// stdinIsTerminal reports whether stdin is a terminal the user can be prompted in. It's a variable to be replaced
+// in tests.
+var stdinIsTerminal = func() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// readLine reads a line from r one byte at a time so that nothing after the line is consumed.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				return strings.TrimSuffix(string(line), "\r"), nil
+			}
+			line = append(line, buf[0])
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(line) > 0 {
+				return string(line), nil
+			}
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !windows && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package sshremote
+
+import "errors"
+
+// readPassword isn't supported as echo can't be disabled in the terminal on this platform.
+func readPassword(_ string) (string, error) {
+	return "", errors.New("reading passwords from the terminal is not supported on this platform")
+}
@@ -0,0 +1,34 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package sshremote
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// readPassword prints the prompt and reads a line from the terminal on stdin without echoing it.
+func readPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	state, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return "", fmt.Errorf("get terminal state: %w", err)
+	}
+	noEcho := *state
+	noEcho.Lflag &^= unix.ECHO
+	noEcho.Lflag |= unix.ICANON | unix.ISIG
+	noEcho.Iflag |= unix.ICRNL
+	if err = unix.IoctlSetTermios(fd, ioctlSetTermios, &noEcho); err != nil {
+		return "", fmt.Errorf("disable terminal echo: %w", err)
+	}
+	defer func() {
+		_ = unix.IoctlSetTermios(fd, ioctlSetTermios, state)
+	}()
+
+	fmt.Fprint(os.Stderr, prompt)
+	// The newline typed by the user isn't echoed.
+	defer fmt.Fprintln(os.Stderr)
+	return readLine(os.Stdin)
+}
@@ -0,0 +1,29 @@
+package sshremote
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// readPassword prints the prompt and reads a line from the console on stdin without echoing it.
+func readPassword(prompt string) (string, error) {
+	handle := windows.Handle(os.Stdin.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return "", fmt.Errorf("get console mode: %w", err)
+	}
+	noEcho := mode&^windows.ENABLE_ECHO_INPUT | windows.ENABLE_PROCESSED_INPUT | windows.ENABLE_LINE_INPUT
+	if err := windows.SetConsoleMode(handle, noEcho); err != nil {
+		return "", fmt.Errorf("disable console echo: %w", err)
+	}
+	defer func() {
+		_ = windows.SetConsoleMode(handle, mode)
+	}()
+
+	fmt.Fprint(os.Stderr, prompt)
+	// The newline typed by the user isn't echoed.
+	defer fmt.Fprintln(os.Stderr)
+	return readLine(os.Stdin)
+}
@@ -0,0 +1,85 @@
+package containerd
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// regexPatternPrefix is the prefix of a repository name pattern that is a regular expression rather than a glob.
+const regexPatternPrefix = "regex:"
+
+// RepositoryFilter restricts which repositories can be pushed to or pulled from by their names. A nil filter allows
+// all repositories.
+type RepositoryFilter struct {
+	allow []func(string) bool
+	deny  []func(string) bool
+}
+
+// NewRepositoryFilter creates a filter that allows repositories matching any of the allow patterns, or all
+// repositories if there are none, unless they match any of the deny patterns. A pattern is a glob as in path.Match,
+// e.g. "myorg/*", or a regular expression matching the whole name if prefixed with "regex:", e.g. "regex:myorg/.+".
+func NewRepositoryFilter(allow, deny []string) (*RepositoryFilter, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+
+	f := &RepositoryFilter{}
+	for _, p := range allow {
+		match, err := compileRepositoryPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		f.allow = append(f.allow, match)
+	}
+	for _, p := range deny {
+		match, err := compileRepositoryPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		f.deny = append(f.deny, match)
+	}
+
+	return f, nil
+}
+
+// Allowed reports whether the repository with the given name, e.g. "myorg/myapp", can be accessed.
+func (f *RepositoryFilter) Allowed(name string) bool {
+	if f == nil {
+		return true
+	}
+	for _, match := range f.deny {
+		if match(name) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, match := range f.allow {
+		if match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileRepositoryPattern(pattern string) (func(string) bool, error) {
+	if expr, ok := strings.CutPrefix(pattern, regexPatternPrefix); ok {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository pattern '%s': %w", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid repository pattern '%s': %w", pattern, err)
+	}
+	return func(name string) bool {
+		// The pattern is validated above so Match can't fail.
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}, nil
+}
@@ -0,0 +1,74 @@
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// artifactTypeLabel is the label of an image in the containerd image store with the artifact type of its manifest.
+// The containerd image store doesn't keep the artifactType of the target descriptor.
+const artifactTypeLabel = "unregistry.artifact-type"
+
+// referrersTagRegexp matches the tags of the referrers tag schema used by clients with registries that don't support
+// the referrers API, e.g. "sha256-<hex>.sig" for cosign signatures. The first group is the hex of the subject digest.
+var referrersTagRegexp = regexp.MustCompile(`^sha256-([a-f0-9]{64})(\..+)?$`)
+
+// manifestArtifactType returns the artifact type of the image manifest or index with the descriptor, or an empty
+// string if it's a regular image. As the OCI image spec defines, the artifact type of a manifest without
+// the artifactType field is the media type of its config unless it's an image config or the empty config.
+func manifestArtifactType(ctx context.Context, provider content.Provider, desc ocispec.Descriptor) (string, error) {
+	if !images.IsManifestType(desc.MediaType) && !images.IsIndexType(desc.MediaType) {
+		return "", nil
+	}
+	blob, err := content.ReadBlob(ctx, provider, desc)
+	if err != nil {
+		return "", fmt.Errorf("read manifest '%s' from containerd content store: %w", desc.Digest, err)
+	}
+
+	// Both image index and manifest have artifactType at the top level. Only manifests have config.
+	var manifest struct {
+		ArtifactType string              `json:"artifactType"`
+		Config       *ocispec.Descriptor `json:"config"`
+	}
+	if err = json.Unmarshal(blob, &manifest); err != nil {
+		return "", fmt.Errorf("unmarshal manifest '%s': %w", desc.Digest, err)
+	}
+	if manifest.ArtifactType != "" {
+		return manifest.ArtifactType, nil
+	}
+	if manifest.Config == nil || manifest.Config.MediaType == "" || isImageConfigType(manifest.Config.MediaType) ||
+		manifest.Config.MediaType == ocispec.MediaTypeEmptyJSON {
+		return "", nil
+	}
+	return manifest.Config.MediaType, nil
+}
+
+// manifestSubject returns the digest of the subject of the image manifest or index with the descriptor, or an empty
+// digest if it doesn't refer to another image.
+func manifestSubject(ctx context.Context, provider content.Provider, desc ocispec.Descriptor) (digest.Digest, error) {
+	if !images.IsManifestType(desc.MediaType) && !images.IsIndexType(desc.MediaType) {
+		return "", nil
+	}
+	blob, err := content.ReadBlob(ctx, provider, desc)
+	if err != nil {
+		return "", fmt.Errorf("read manifest '%s' from containerd content store: %w", desc.Digest, err)
+	}
+
+	var manifest struct {
+		Subject *ocispec.Descriptor `json:"subject"`
+	}
+	if err = json.Unmarshal(blob, &manifest); err != nil {
+		return "", fmt.Errorf("unmarshal manifest '%s': %w", desc.Digest, err)
+	}
+	if manifest.Subject == nil {
+		return "", nil
+	}
+	return manifest.Subject.Digest, nil
+}
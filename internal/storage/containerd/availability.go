@@ -0,0 +1,82 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Availability describes whether an image and all its content are present in the containerd image and content stores.
+type Availability struct {
+	// Image is the normalized image reference, e.g. "docker.io/library/ubuntu:latest".
+	Image string `json:"image"`
+	// Digest is the digest of the image manifest or index. It's empty if the image doesn't exist. It differs from
+	// the digest in the reference if the tag points to another digest.
+	Digest digest.Digest `json:"digest,omitempty"`
+	// Available is true if the image exists and none of its content is missing so it can be run or served right away.
+	Available bool `json:"available"`
+	// Missing is the list of blobs referenced by the image that are missing in the content store.
+	Missing []digest.Digest `json:"missing,omitempty"`
+}
+
+// ImageAvailability checks whether the image with the given normalized reference and all its content for
+// the platform are present in the containerd image and content stores. Manifests for other platforms of
+// a multi-platform image are not required to be present. A reference with both a tag and a digest is only
+// available if the tag points to the digest. A reference with only a digest is available if any image with
+// the digest is available.
+func ImageAvailability(
+	ctx context.Context, cli *client.Client, ref reference.Named, platform platforms.MatchComparer,
+) (Availability, error) {
+	availability := Availability{Image: ref.String()}
+
+	var target ocispec.Descriptor
+	if tagged, ok := ref.(reference.Tagged); ok {
+		// Shouldn't return an error as the tag is already valid.
+		tagRef, _ := reference.WithTag(reference.TrimNamed(ref), tagged.Tag())
+		img, err := cli.ImageService().Get(ctx, tagRef.String())
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				return availability, nil
+			}
+			return availability, fmt.Errorf("get image '%s' from containerd image store: %w", tagRef.String(), err)
+		}
+		target = img.Target
+	} else if digested, ok := ref.(reference.Digested); ok {
+		imgs, err := cli.ImageService().List(ctx, "target.digest=="+digested.Digest().String())
+		if err != nil {
+			return availability, fmt.Errorf(
+				"list images with digest '%s' in containerd image store: %w", digested.Digest(), err,
+			)
+		}
+		if len(imgs) == 0 {
+			return availability, nil
+		}
+		target = imgs[0].Target
+	} else {
+		return availability, fmt.Errorf("image reference '%s' must have a tag or digest", ref.String())
+	}
+	availability.Digest = target.Digest
+
+	if digested, ok := ref.(reference.Digested); ok && digested.Digest() != target.Digest {
+		return availability, nil
+	}
+
+	blobs, err := verifyContent(ctx, cli.ContentStore(), target, platform)
+	if err != nil {
+		return availability, fmt.Errorf("walk content of image '%s': %w", ref.String(), err)
+	}
+	for _, b := range blobs {
+		if !b.Verified {
+			availability.Missing = append(availability.Missing, b.Digest)
+		}
+	}
+	availability.Available = len(availability.Missing) == 0
+
+	return availability, nil
+}
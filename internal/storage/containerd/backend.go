@@ -0,0 +1,106 @@
+package containerd
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/platforms"
+	"github.com/distribution/distribution/v3"
+	"github.com/psviderski/unregistry/internal/events"
+	"github.com/psviderski/unregistry/internal/metadata"
+	"github.com/psviderski/unregistry/internal/progress"
+	"github.com/psviderski/unregistry/internal/transfer"
+	"github.com/psviderski/unregistry/pkg/storage"
+	"golang.org/x/sync/semaphore"
+)
+
+// BackendName is the name of the containerd storage backend.
+const BackendName = "containerd"
+
+func init() {
+	storage.MustRegister(BackendName, newRegistry)
+}
+
+// newRegistry is the storage backend factory function that creates an instance of registry backed by the containerd
+// image store. It uses the containerd client passed in the "client" option if set, otherwise it creates a new one
+// using the "sock" and "namespace" options.
+func newRegistry(_ context.Context, options storage.Options) (distribution.Namespace, error) {
+	// Progress tracker and metadata store are optional and only set when the registry is created programmatically.
+	tracker, _ := options["progress"].(*progress.Tracker)
+	store, ok := options["metadata"].(metadata.Store)
+	if !ok || store == nil {
+		store = metadata.NewMemoryStore()
+	}
+
+	// The registry doesn't store anything and only records what would be transferred if a dry-run collector is set.
+	dryRun, _ := options["dryrun"].(*transfer.DryRun)
+	reporter, _ := options["reporter"].(*transfer.Reporter)
+	broker, _ := options["events"].(*events.Broker)
+	verifier, _ := options["verifier"].(*transfer.Verifier)
+	namespaceAnnotation, _ := options["namespaceannotation"].(string)
+	repoFilter, _ := options["repofilter"].(*RepositoryFilter)
+	validateSchema, _ := options["validateschema"].(bool)
+	transactions, _ := options["transactions"].(*PushTransactions)
+	pullRewrites, _ := options["pullrewrites"].(*PullRewrites)
+	pullThrough, _ := options["pullthrough"].(*PullThrough)
+	signatures, _ := options["signatures"].(*SignaturePolicy)
+	unpackSnapshotter := options.String("unpack")
+	platform, _ := options["platform"].(platforms.MatchComparer)
+	if platform == nil {
+		platform = platforms.Default()
+	}
+	deletes, _ := options["delete"].(bool)
+	quota, _ := options["repoquota"].(int64)
+	leaseExpiration, _ := options["leaseexpiration"].(time.Duration)
+	if leaseExpiration <= 0 {
+		leaseExpiration = defaultLeaseExpiration
+	}
+
+	// Limit the number of concurrent copy and verification operations if configured.
+	var copyLimit *semaphore.Weighted
+	if n, ok := options["copylimit"].(int); ok && n > 0 {
+		copyLimit = semaphore.NewWeighted(int64(n))
+	}
+
+	cli, ok := options["client"].(*client.Client)
+	if !ok || cli == nil {
+		sock := options.String("sock")
+		namespace := options.String("namespace")
+
+		var err error
+		if cli, err = NewClient(sock, namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	// Push transactions release the content of tagged images themselves.
+	var uploads *uploadLeases
+	if transactions == nil {
+		uploads = newUploadLeases(cli, leaseExpiration)
+	}
+
+	return &registry{
+		client:              cli,
+		progress:            tracker,
+		metadata:            store,
+		copyLimit:           copyLimit,
+		dryRun:              dryRun,
+		reporter:            reporter,
+		events:              broker,
+		verifier:            verifier,
+		namespaceAnnotation: namespaceAnnotation,
+		repoFilter:          repoFilter,
+		validateSchema:      validateSchema,
+		transactions:        transactions,
+		pullRewrites:        pullRewrites,
+		pullThrough:         pullThrough,
+		signatures:          signatures,
+		leaseExpiration:     leaseExpiration,
+		uploadLeases:        uploads,
+		unpackSnapshotter:   unpackSnapshotter,
+		unpackPlatform:      platform,
+		deletes:             deletes,
+		quota:               newRepoQuota(cli, quota),
+	}, nil
+}
@@ -2,29 +2,103 @@ package containerd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/containerd/containerd/v2/client"
 	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
 	"github.com/containerd/errdefs"
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
 	"github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/psviderski/unregistry/internal/metadata"
+	"github.com/psviderski/unregistry/internal/progress"
+	"github.com/psviderski/unregistry/internal/tracing"
+	"github.com/psviderski/unregistry/internal/transfer"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/semaphore"
 )
 
+// errorCodeBlobInUse is returned when deleting a blob that is referenced by images or retained by pushes in progress.
+var errorCodeBlobInUse = errcode.Register("unregistry", errcode.ErrorDescriptor{
+	Value:   "BLOB_IN_USE",
+	Message: "blob is referenced by images or pushes in progress",
+	Description: "The blob can't be deleted as it's referenced by images or retained by pushes that haven't " +
+		"completed yet. Delete the images first or wait for the pushes to complete.",
+	HTTPStatusCode: http.StatusConflict,
+})
+
 // blobStore implements distribution.BlobStore backed by containerd image store.
 type blobStore struct {
-	client *client.Client
-	repo   reference.Named
+	client   *client.Client
+	repo     reference.Named
+	progress *progress.Tracker
+	// copyLimit limits the number of concurrent blob copy and verification operations. No limit if nil.
+	copyLimit *semaphore.Weighted
+	// dryRun disables uploads and makes all blobs appear to exist if set.
+	dryRun *transfer.DryRun
+	// reporter records committed uploads for transfer reports. Can be nil.
+	reporter *transfer.Reporter
+	// verifier verifies blob digests on write and read according to its policy. Can be nil.
+	verifier *transfer.Verifier
+	// transactions groups the content uploaded by pushes to clean up after failed ones. Disabled if nil.
+	transactions *PushTransactions
+	// metadata persists the sessions of unfinished uploads.
+	metadata metadata.Store
+	// leaseExpiration is the expiration of the containerd leases retaining the content of uploads.
+	leaseExpiration time.Duration
+	// uploadLeases tracks the leases of committed uploads if push transactions are disabled. Can be nil.
+	uploadLeases *uploadLeases
+	// pullThrough fetches the blobs missing in the containerd content store from upstream registries. Can be nil.
+	pullThrough *PullThrough
+	// deletes enables deleting blobs. Delete returns distribution.ErrUnsupported if disabled.
+	deletes bool
+	// quota limits the size of the images in the repository that the uploaded bytes count towards. No limit if nil.
+	quota *repoQuota
+}
+
+// startSpan starts a tracing span of a blob store operation annotated with the repository and the blob digest if known.
+func (b *blobStore) startSpan(
+	ctx context.Context, name string, dgst digest.Digest, attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("registry.repository", b.repo.Name()))
+	if dgst != "" {
+		attrs = append(attrs, attribute.String("registry.digest", dgst.String()))
+	}
+	return tracing.Start(ctx, name, attrs...)
 }
 
 // Stat returns metadata about a blob in the containerd content store by its digest.
+// If the blob doesn't exist, distribution.ErrBlobUnknown will be returned. In the dry-run mode, a missing blob
+// appears to exist so that clients don't upload it. What would be transferred is recorded when the manifest
+// referencing the blob is pushed.
+func (b *blobStore) Stat(ctx context.Context, dgst digest.Digest) (desc distribution.Descriptor, err error) {
+	ctx, span := b.startSpan(ctx, "containerd.BlobStore.Stat", dgst)
+	defer tracing.End(span, &err)
+
+	desc, err = b.stat(ctx, dgst)
+	if b.dryRun != nil && errors.Is(err, distribution.ErrBlobUnknown) {
+		return distribution.Descriptor{
+			MediaType: "application/octet-stream",
+			Digest:    dgst,
+		}, nil
+	}
+	return desc, err
+}
+
+// stat returns metadata about a blob in the containerd content store by its digest.
 // If the blob doesn't exist, distribution.ErrBlobUnknown will be returned.
-func (b *blobStore) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+func (b *blobStore) stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
 	info, err := b.client.ContentStore().Info(ctx, dgst)
 	if err != nil {
 		if errdefs.IsNotFound(err) {
@@ -44,8 +118,29 @@ func (b *blobStore) Stat(ctx context.Context, dgst digest.Digest) (distribution.
 
 // Get retrieves the content of a blob in the containerd content store by its digest.
 // If the blob doesn't exist, distribution.ErrBlobUnknown will be returned.
-func (b *blobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
-	blob, err := content.ReadBlob(ctx, b.client.ContentStore(), ocispec.Descriptor{Digest: dgst})
+func (b *blobStore) Get(ctx context.Context, dgst digest.Digest) (_ []byte, err error) {
+	ctx, span := b.startSpan(ctx, "containerd.BlobStore.Get", dgst)
+	defer tracing.End(span, &err)
+
+	release, err := LeaseContent(ctx, b.client, dgst)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	info, err := b.client.ContentStore().Info(ctx, dgst)
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil, distribution.ErrBlobUnknown
+		}
+		return nil, fmt.Errorf("get metadata for blob '%s' from containerd content store: %w", dgst, err)
+	}
+	// The blob is read into memory which isn't possible for blobs over 2GiB on 32-bit platforms.
+	if info.Size > math.MaxInt {
+		return nil, fmt.Errorf("blob '%s' of size %d is too large to read into memory", dgst, info.Size)
+	}
+
+	blob, err := content.ReadBlob(ctx, b.client.ContentStore(), ocispec.Descriptor{Digest: dgst, Size: info.Size})
 	if err != nil {
 		if errdefs.IsNotFound(err) {
 			return nil, distribution.ErrBlobUnknown
@@ -72,8 +167,12 @@ func (b *blobStore) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCl
 // Put stores a blob in the containerd content store with the given media type. If the blob already exists,
 // it will return the existing descriptor without re-uploading the content. It should be used for small objects,
 // such as manifests.
-func (b *blobStore) Put(ctx context.Context, mediaType string, blob []byte) (distribution.Descriptor, error) {
-	writer, err := newBlobWriter(ctx, b.client, b.repo, "")
+func (b *blobStore) Put(ctx context.Context, mediaType string, blob []byte) (_ distribution.Descriptor, err error) {
+	ctx, span := b.startSpan(ctx, "containerd.BlobStore.Put", "", attribute.Int("registry.size", len(blob)))
+	defer tracing.End(span, &err)
+
+	// Progress of small blobs put in one go is not tracked.
+	writer, err := newBlobWriter(ctx, b, "", nil)
 	if err != nil {
 		return distribution.Descriptor{}, err
 	}
@@ -101,16 +200,65 @@ func (b *blobStore) Put(ctx context.Context, mediaType string, blob []byte) (dis
 	return desc, nil
 }
 
-// Create creates a blob writer to add a blob to the containerd content store.
+// Create creates a blob writer to add a blob to the containerd content store. A monolithic upload, which is a POST
+// request with the digest and the whole blob in the body, is stored right away instead.
 func (b *blobStore) Create(ctx context.Context, _ ...distribution.BlobCreateOption) (
 	distribution.BlobWriter, error,
 ) {
-	return newBlobWriter(ctx, b.client, b.repo, "")
+	if b.dryRun != nil {
+		return nil, distribution.ErrUnsupported
+	}
+	if dgst, req, ok := monolithicUpload(ctx); ok {
+		return nil, b.putMonolithic(ctx, req, dgst)
+	}
+	return newBlobWriter(ctx, b, "", b.progress)
+}
+
+// putMonolithic stores the blob from the body of a monolithic upload request in a single pass without persisting
+// a resumable upload session, as clients like ORAS prefer for small blobs. It returns distribution.ErrBlobMounted with
+// the descriptor of the stored blob on success so that the registry app responds with 201 Created and the blob
+// location as the OCI distribution spec requires.
+func (b *blobStore) putMonolithic(ctx context.Context, req *http.Request, dgst digest.Digest) (err error) {
+	ctx, span := b.startSpan(ctx, "containerd.BlobStore.PutMonolithic", dgst)
+	defer func() {
+		// The registry app treats the mounted blob as a success.
+		if errors.As(err, new(distribution.ErrBlobMounted)) {
+			span.End()
+			return
+		}
+		tracing.End(span, &err)
+	}()
+
+	writer, err := newBlobWriter(ctx, b, "", b.progress)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	if _, err = writer.ReadFrom(req.Body); err != nil {
+		// The upload can't be resumed without a session so clean up resources occupied by the writer.
+		_ = writer.Cancel(ctx)
+		return err
+	}
+	// Commit cleans up after itself if it fails.
+	desc, err := writer.Commit(ctx, distribution.Descriptor{Digest: dgst})
+	if err != nil {
+		return err
+	}
+
+	canonical, err := reference.WithDigest(b.repo, dgst)
+	if err != nil {
+		return fmt.Errorf("create canonical reference for blob '%s': %w", dgst, err)
+	}
+	return distribution.ErrBlobMounted{From: canonical, Descriptor: desc}
 }
 
 // Resume creates a blob writer for resuming an upload with a specific ID.
 func (b *blobStore) Resume(ctx context.Context, id string) (distribution.BlobWriter, error) {
-	return newBlobWriter(ctx, b.client, b.repo, id)
+	if b.dryRun != nil {
+		return nil, distribution.ErrUnsupported
+	}
+	return newBlobWriter(ctx, b, id, b.progress)
 }
 
 // Mount is not supported for simplicity.
@@ -124,20 +272,40 @@ func (b *blobStore) Mount(ctx context.Context, sourceRepo reference.Named, dgst
 	return distribution.Descriptor{}, distribution.ErrUnsupported
 }
 
-// ServeBlob serves the blob from containerd content store over HTTP.
-func (b *blobStore) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
+// ServeBlob serves the blob from containerd content store over HTTP. The blob is leased while it's being served
+// so that it can't be garbage collected mid-download.
+func (b *blobStore) ServeBlob(
+	ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest,
+) (err error) {
+	ctx, span := b.startSpan(ctx, "containerd.BlobStore.ServeBlob", dgst)
+	defer tracing.End(span, &err)
+
+	if r.Method != http.MethodHead {
+		release, err := LeaseContent(ctx, b.client, dgst)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
 	// Get the blob info to check if it exists and populate the response headers.
 	desc, err := b.Stat(ctx, dgst)
+	if errors.Is(err, distribution.ErrBlobUnknown) && b.pullThrough != nil {
+		if fetchErr := b.pullThrough.FetchBlob(ctx, b.client, b.repo, dgst); fetchErr == nil {
+			desc, err = b.Stat(ctx, dgst)
+		} else if !errors.Is(fetchErr, errNotProxied) {
+			logrus.WithFields(logrus.Fields{
+				"repo":   b.repo.Name(),
+				"digest": dgst,
+			}).WithError(fetchErr).Warn("Failed to fetch blob from upstream registry.")
+		}
+	}
 	if err != nil {
 		return err
 	}
 
-	w.Header().Set("Content-Type", desc.MediaType)
-	w.Header().Set("Content-Length", strconv.FormatInt(desc.Size, 10))
-	w.Header().Set("Docker-Content-Digest", dgst.String())
-	w.Header().Set("Etag", dgst.String())
-
 	if r.Method == http.MethodHead {
+		setBlobHeaders(w, desc, dgst)
 		return nil
 	}
 
@@ -147,14 +315,108 @@ func (b *blobStore) ServeBlob(ctx context.Context, w http.ResponseWriter, r *htt
 	}
 	defer reader.Close()
 
+	// The content is verified before sending it as a corrupted blob can't be taken back once the response is sent.
+	if b.verifier.OnRead() {
+		if err = b.verifier.VerifyRead(reader, dgst); err != nil {
+			return err
+		}
+		if _, err = reader.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("seek blob '%s': %w", dgst, err)
+		}
+	}
+	setBlobHeaders(w, desc, dgst)
+
 	_, err = io.CopyN(w, reader, desc.Size)
 	return err
 }
 
-// Delete is not supported for simplicity.
-// Deletion can be done by deleting images in containerd, which will clean up the blobs.
+// setBlobHeaders sets the response headers describing the blob.
+func setBlobHeaders(w http.ResponseWriter, desc distribution.Descriptor, dgst digest.Digest) {
+	w.Header().Set("Content-Type", desc.MediaType)
+	w.Header().Set("Content-Length", strconv.FormatInt(desc.Size, 10))
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.Header().Set("Etag", dgst.String())
+}
+
+// Delete deletes the blob from the containerd content store if it's not referenced by any image nor retained by
+// the upload lease of a push in progress. Otherwise, it returns errorCodeBlobInUse as deleting the blob would break
+// the images or the pushes that are about to reference it. Blobs are usually cleaned up by deleting images which lets
+// the containerd garbage collection delete the content that is no longer referenced.
 func (b *blobStore) Delete(ctx context.Context, dgst digest.Digest) error {
-	return distribution.ErrUnsupported
+	if !b.deletes || b.dryRun != nil {
+		return distribution.ErrUnsupported
+	}
+	if _, err := b.stat(ctx, dgst); err != nil {
+		return err
+	}
+
+	imgs, err := referencingImages(ctx, b.client, dgst)
+	if err != nil {
+		return err
+	}
+	if len(imgs) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"digest": dgst,
+			"images": imgs,
+		}).Info("Refused to delete blob referenced by images.")
+		return errorCodeBlobInUse.WithDetail(map[string]any{"images": imgs})
+	}
+	// The uploads of a push in progress aren't referenced by an image until its manifest is pushed.
+	uploads, err := uploadLeasesRetaining(ctx, b.client, dgst)
+	if err != nil {
+		return err
+	}
+	if len(uploads) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"digest": dgst,
+			"leases": uploads,
+		}).Info("Refused to delete blob retained by pushes in progress.")
+		return errorCodeBlobInUse.WithDetail(map[string]any{"leases": uploads})
+	}
+
+	if err = b.client.ContentStore().Delete(ctx, dgst); err != nil {
+		if errdefs.IsNotFound(err) {
+			return distribution.ErrBlobUnknown
+		}
+		return fmt.Errorf("delete blob '%s' from containerd content store: %w", dgst, err)
+	}
+	logrus.WithField("digest", dgst).Info("Deleted blob from containerd content store.")
+
+	return nil
+}
+
+// errBlobReferenced stops walking an image once the blob is found.
+var errBlobReferenced = errors.New("blob is referenced")
+
+// referencingImages returns the names of the images in the containerd image store which content includes the blob.
+func referencingImages(ctx context.Context, cli *client.Client, dgst digest.Digest) ([]string, error) {
+	imgs, err := cli.ImageService().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list images in containerd image store: %w", err)
+	}
+
+	contentStore := cli.ContentStore()
+	find := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if desc.Digest == dgst {
+			return nil, errBlobReferenced
+		}
+		return nil, nil
+	})
+	handler := images.Handlers(find, presentChildrenHandler(contentStore))
+
+	var names []string
+	for _, img := range imgs {
+		err = images.Walk(ctx, handler, img.Target)
+		if errors.Is(err, errBlobReferenced) {
+			names = append(names, img.Name)
+			continue
+		}
+		// The manifest of the image itself may be missing, e.g. for a partially pulled image.
+		if err != nil && !errdefs.IsNotFound(err) {
+			return nil, fmt.Errorf("walk content of image '%s': %w", img.Name, err)
+		}
+	}
+	return names, nil
 }
 
 // blobReadSeekCloser is an io.ReadSeekCloser that wraps a content.ReaderAt.
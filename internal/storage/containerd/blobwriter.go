@@ -2,9 +2,12 @@ package containerd
 
 import (
 	"context"
+	"encoding"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,10 +18,23 @@ import (
 	"github.com/containerd/containerd/v2/core/leases"
 	"github.com/containerd/errdefs"
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
 	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/metadata"
+	"github.com/psviderski/unregistry/internal/progress"
+	"github.com/psviderski/unregistry/internal/tracing"
+	"github.com/psviderski/unregistry/internal/transfer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/semaphore"
 )
 
-const leaseExpiration = 1 * time.Hour
+// defaultLeaseExpiration is the expiration of the containerd leases retaining uploaded content if not configured.
+const defaultLeaseExpiration = 1 * time.Hour
+
+// ingestResourceType is the containerd lease resource type of content ingests.
+const ingestResourceType = "ingests"
 
 // blobWriter is a resumable blob uploader to the containerd content store.
 // Implements distribution.BlobWriter.
@@ -26,39 +42,85 @@ type blobWriter struct {
 	client *client.Client
 	repo   reference.Named
 	id     string
+	// ctx is the context of the request the writer was created or resumed in. It parents the tracing spans of
+	// the writes as io.ReaderFrom doesn't take a context.
+	ctx context.Context
 
 	// lease is a containerd lease for writer that prevents garbage collection of the content. It's intentionally not
 	// deleted on successful blob commit to keep it while the registry is uploading other blobs and manifests and
 	// creating an image referencing them. Otherwise, the blob would be garbage collected immediately after lease is
-	// deleted if the blob is not referenced by an image.
+	// deleted if the blob is not referenced by an image. If push transactions are enabled, the blob is handed over to
+	// the push transaction lease on commit and this lease is deleted.
 	// In the worst case, the lease and unreferenced blob will be garbage collected after leaseExpiration.
-	lease  leases.Lease
-	writer content.Writer
-	// size is the total number of bytes written to writer.
+	lease leases.Lease
+	// leaseExpiration is the expiration of the upload lease. The lease is renewed while the data is written so that
+	// a slow upload isn't garbage collected mid-transfer.
+	leaseExpiration time.Duration
+	writer          content.Writer
+	// size is the total number of bytes of the blob received from the client, including the skipped ones.
 	size int64
-	log  *logrus.Entry
+	// skip is the number of bytes to discard at the start of the written data as containerd already has them.
+	skip int64
+	// hash computes the SHA-256 digest of the data written to containerd while it's streamed so that a mismatching
+	// upload is rejected without committing it. It's nil if verification on write is disabled or the digest of
+	// a resumed upload can't be continued, e.g. its session was lost, and the digest is only verified by containerd
+	// on commit then.
+	hash      hash.Hash
+	startedAt time.Time
+	// metadata persists the upload session when the writer is closed before the upload is finished.
+	metadata metadata.Store
+	// persisted indicates whether the upload session is stored in metadata.
+	persisted bool
+	// finished is set when the upload is committed or canceled and its session must not be persisted anymore.
+	finished bool
+	// progress is an optional tracker for reporting the upload progress. Can be nil.
+	progress *progress.Tracker
+	// copyLimit limits the number of concurrent copy and verification operations. No limit if nil.
+	copyLimit *semaphore.Weighted
+	// reporter records committed uploads for transfer reports. Can be nil.
+	reporter *transfer.Reporter
+	// verifier records digest verifications on commit. Can be nil.
+	verifier *transfer.Verifier
+	// transactions groups the committed blob with the content of other uploads of the push. Disabled if nil.
+	transactions *PushTransactions
+	// uploadLeases tracks the lease of the committed upload to release it once the blob is tagged. Can be nil.
+	uploadLeases *uploadLeases
+	// quota limits the size of the repository the uploaded bytes count towards. No limit if nil.
+	quota *repoQuota
+	log   *logrus.Entry
 }
 
+// newBlobWriter creates a new or resumes an existing blob writer with the given ID in the blob store's repository.
+// The tracker is used to report the upload progress if not nil. Resuming an upload that doesn't exist or whose
+// content has been garbage collected returns distribution.ErrBlobUploadUnknown so that the client restarts it.
 func newBlobWriter(
-	ctx context.Context, client *client.Client, repo reference.Named, id string,
+	ctx context.Context, store *blobStore, id string, tracker *progress.Tracker,
 ) (distribution.BlobWriter, error) {
+	client, repo := store.client, store.repo
+	requestCtx := ctx
+	var (
+		session uploadSession
+		resumed bool
+		err     error
+	)
 	if id == "" {
 		id = uuid.NewString()
+	} else {
+		if session, err = loadUploadSession(ctx, client, store.metadata, repo.Name(), id); err != nil {
+			return nil, err
+		}
+		resumed = true
 	}
 
-	// Create a containerd lease to prevent garbage collection.
-	opts := []leases.Opt{
-		leases.WithRandomID(),
-		leases.WithExpiration(leaseExpiration),
-	}
-	lease, err := client.LeasesService().Create(ctx, opts...)
+	// Reuse the lease of the resumed upload if it still exists to not pile up a lease per request.
+	lease, err := uploadLease(ctx, client, session.LeaseID, store.leaseExpiration)
 	if err != nil {
-		return nil, fmt.Errorf("create containerd lease: %w", err)
+		return nil, err
 	}
 
 	// Open a containerd content writer with the lease.
 	ctx = leases.WithLease(ctx, lease.ID)
-	writer, err := content.OpenWriter(ctx, client.ContentStore(), content.WithRef("upload-"+id))
+	writer, err := content.OpenWriter(ctx, client.ContentStore(), content.WithRef(uploadRef(id)))
 	if err != nil {
 		_ = client.LeasesService().Delete(ctx, lease)
 		return nil, fmt.Errorf("create containerd content writer: %w", err)
@@ -67,6 +129,7 @@ func newBlobWriter(
 	// Get the status of the writer to get the written offset (size) if the writer was resumed.
 	status, err := writer.Status()
 	if err != nil {
+		_ = writer.Close()
 		return nil, fmt.Errorf("get containerd content writer status: %w", err)
 	}
 
@@ -76,16 +139,71 @@ func newBlobWriter(
 			"repo":      repo.Name(),
 		},
 	)
+	// The client resumes the upload at the offset from the previous response which may be behind the written data if
+	// the previous request was interrupted mid-transfer. The client resends the data from its offset then so the data
+	// containerd already has is skipped. The written data is verified against the digest on commit.
+	size, skip := status.Offset, int64(0)
+	expected := session.Offset
+	if requested, ok := requestedUploadOffset(ctx); resumed && ok {
+		expected = requested
+		if requested < status.Offset {
+			size, skip = requested, status.Offset-requested
+			log.WithFields(logrus.Fields{
+				"offset":           status.Offset,
+				"requested_offset": requested,
+			}).Debug("Resumed upload behind the written data, skipping the resent data.")
+		}
+	}
+	if resumed && status.Offset < expected {
+		// The ingest has been garbage collected, e.g. after its lease expired, so the written data is lost.
+		log.WithFields(logrus.Fields{
+			"offset":          status.Offset,
+			"expected_offset": expected,
+		}).Warn("Failed to resume upload as its content has been garbage collected.")
+		_ = writer.Close()
+		_ = client.ContentStore().Abort(ctx, uploadRef(id))
+		_ = client.LeasesService().Delete(ctx, lease)
+		if err = store.metadata.Delete(metadata.BucketUploads, id); err != nil {
+			log.WithError(err).Warn("Failed to delete upload session.")
+		}
+		return nil, distribution.ErrBlobUploadUnknown
+	}
+
+	var h hash.Hash
+	// The hash state can only be continued if it covers exactly the data containerd has.
+	if store.verifier.OnWrite() && (!resumed || (session.HashState != nil && session.Offset == status.Offset)) {
+		h = newUploadHash(session.HashState)
+	}
+
+	startedAt := session.StartedAt
+	if startedAt.IsZero() {
+		startedAt = lease.CreatedAt
+	}
 	log.WithField("size", status.Offset).Debug("Created new containerd blob writer.")
+	tracker.Start(id, repo.Name(), status.Offset)
 
 	return &blobWriter{
-		client: client,
-		repo:   repo,
-		id:     id,
-		lease:  lease,
-		writer: writer,
-		size:   status.Offset,
-		log:    log,
+		client:          client,
+		repo:            repo,
+		id:              id,
+		ctx:             requestCtx,
+		lease:           lease,
+		leaseExpiration: store.leaseExpiration,
+		writer:          writer,
+		size:            size,
+		skip:            skip,
+		hash:            h,
+		startedAt:       startedAt,
+		metadata:        store.metadata,
+		persisted:       resumed,
+		progress:        tracker,
+		copyLimit:       store.copyLimit,
+		reporter:        store.reporter,
+		verifier:        store.verifier,
+		transactions:    store.transactions,
+		uploadLeases:    store.uploadLeases,
+		quota:           store.quota,
+		log:             log,
 	}, nil
 }
 
@@ -96,7 +214,7 @@ func (bw *blobWriter) ID() string {
 
 // StartedAt returns the time the upload started.
 func (bw *blobWriter) StartedAt() time.Time {
-	return bw.lease.CreatedAt
+	return bw.startedAt
 }
 
 // Size returns the number of bytes written to the containerd blob writer.
@@ -105,9 +223,30 @@ func (bw *blobWriter) Size() int64 {
 }
 
 // ReadFrom reads from the provided reader and writes to the containerd blob writer.
-func (bw *blobWriter) ReadFrom(r io.Reader) (int64, error) {
-	n, err := io.Copy(bw.writer, r)
-	bw.size += n
+func (bw *blobWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	_, span := bw.startSpan(bw.ctx, "containerd.BlobWriter.ReadFrom")
+	defer func() {
+		span.SetAttributes(attribute.Int64("registry.written", n))
+		tracing.End(span, &err)
+	}()
+
+	defer bw.transactions.uploading(bw.repo.Name())()
+
+	var skipped int64
+	if bw.skip > 0 {
+		skipped, err = io.CopyN(io.Discard, r, bw.skip)
+		bw.skip -= skipped
+		bw.size += skipped
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			return skipped, err
+		}
+	}
+
+	n, err = io.Copy(writerFunc(bw.write), r)
+	n += skipped
 
 	log := bw.log.WithField("size", n)
 	if err != nil {
@@ -121,8 +260,14 @@ func (bw *blobWriter) ReadFrom(r io.Reader) (int64, error) {
 
 // Write writes data to the containerd blob writer.
 func (bw *blobWriter) Write(data []byte) (int, error) {
-	n, err := bw.writer.Write(data)
-	bw.size += int64(n)
+	defer bw.transactions.uploading(bw.repo.Name())()
+
+	skipped := int(min(bw.skip, int64(len(data))))
+	bw.skip -= int64(skipped)
+	bw.size += int64(skipped)
+
+	n, err := bw.write(data[skipped:])
+	n += skipped
 
 	log := bw.log.WithField("size", n)
 	if err != nil {
@@ -134,8 +279,90 @@ func (bw *blobWriter) Write(data []byte) (int, error) {
 	return n, err
 }
 
+// write writes data to the containerd content writer renewing the upload lease if it expires soon, and reports
+// the written bytes to the progress tracker. The data is rejected if it would make the repository exceed its quota.
+// A copy slot is only taken for the write itself rather than the whole request so that a slow client doesn't hold it
+// while its data is being received.
+func (bw *blobWriter) write(data []byte) (int, error) {
+	if err := bw.quota.check(bw.ctx, bw.repo, bw.size+int64(len(data))); err != nil {
+		return 0, err
+	}
+	bw.renewLease()
+	release, err := bw.acquireCopy(bw.ctx)
+	if err != nil {
+		return 0, err
+	}
+	n, err := bw.writer.Write(data)
+	release()
+	bw.size += int64(n)
+	if bw.hash != nil {
+		bw.hash.Write(data[:n])
+	}
+	// Report the progress as the data is written rather than once the request body is fully copied so that a long
+	// upload can be observed while it's flowing into containerd.
+	bw.progress.Add(bw.id, int64(n))
+	return n, err
+}
+
+// renewLease replaces the upload lease with a new one if less than half of its expiration is left. Containerd leases
+// can't be extended so the ingest of the upload is added to a new lease before the old one is deleted. The upload
+// continues with the old lease if the renewal fails.
+func (bw *blobWriter) renewLease() {
+	if time.Since(bw.lease.CreatedAt) < bw.leaseExpiration/2 {
+		return
+	}
+
+	ctx := context.Background()
+	leasesService := bw.client.LeasesService()
+	lease, err := leasesService.Create(ctx,
+		leases.WithRandomID(),
+		leases.WithExpiration(bw.leaseExpiration),
+		leases.WithLabel(leaseTypeLabel, leaseTypeUpload),
+	)
+	if err != nil {
+		bw.log.WithError(err).Warn("Failed to renew containerd lease of upload.")
+		return
+	}
+	ingest := leases.Resource{ID: uploadRef(bw.id), Type: ingestResourceType}
+	if err = leasesService.AddResource(ctx, lease, ingest); err != nil {
+		bw.log.WithError(err).Warn("Failed to renew containerd lease of upload.")
+		_ = leasesService.Delete(ctx, lease)
+		return
+	}
+	if err = leasesService.Delete(ctx, bw.lease); err != nil && !errdefs.IsNotFound(err) {
+		bw.log.WithError(err).Debug("Failed to delete renewed containerd lease of upload.")
+	}
+	bw.log.WithFields(logrus.Fields{
+		"lease":     lease.ID,
+		"old_lease": bw.lease.ID,
+	}).Debug("Renewed containerd lease of upload.")
+	bw.lease = lease
+}
+
+// writerFunc is an adapter to use a function as an io.Writer.
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// startSpan starts a tracing span of a blob writer operation annotated with the repository and the upload ID.
+func (bw *blobWriter) startSpan(
+	ctx context.Context, name string, attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	attrs = append(attrs,
+		attribute.String("registry.repository", bw.repo.Name()),
+		attribute.String("registry.upload_id", bw.id),
+	)
+	return tracing.Start(ctx, name, attrs...)
+}
+
 // Commit finalizes the blob upload.
-func (bw *blobWriter) Commit(ctx context.Context, desc distribution.Descriptor) (distribution.Descriptor, error) {
+func (bw *blobWriter) Commit(ctx context.Context, desc distribution.Descriptor) (_ distribution.Descriptor, err error) {
+	ctx, span := bw.startSpan(ctx, "containerd.BlobWriter.Commit",
+		attribute.String("registry.digest", desc.Digest.String()), attribute.Int64("registry.size", bw.size))
+	defer tracing.End(span, &err)
+
 	log := bw.log.WithFields(
 		logrus.Fields{
 			"digest":    desc.Digest,
@@ -145,19 +372,62 @@ func (bw *blobWriter) Commit(ctx context.Context, desc distribution.Descriptor)
 	)
 
 	log.Debug("Committing blob to containerd content store.")
+	bw.progress.Verifying(bw.id, desc.Digest.String())
+	if err = bw.verifyDigest(ctx, desc.Digest); err != nil {
+		log.WithError(err).Info("Rejected upload with mismatching digest.")
+		return distribution.Descriptor{}, err
+	}
+	release, err := bw.acquireCopy(ctx)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	// The upload may span multiple requests, each resuming the writer, so the start time is taken from the ingest.
+	startedAt := bw.startedAt
+	if status, sErr := bw.writer.Status(); sErr == nil && !status.StartedAt.IsZero() {
+		startedAt = status.StartedAt
+	}
 	// The caller may not provide a size in the descriptor if it doesn't know it so we use the calculated size from
-	// the writer.
-	if err := bw.writer.Commit(ctx, bw.size, desc.Digest); err != nil {
+	// the writer. Containerd verifies the digest computed while writing matches the expected one on commit.
+	commitStart := time.Now()
+	err = bw.writer.Commit(ctx, bw.size, desc.Digest)
+	release()
+	// The upload can't be resumed after a commit attempt as containerd verifies the data only on commit.
+	bw.finish()
+	digestMismatch := errdefs.IsFailedPrecondition(err) && strings.Contains(err.Error(), "unexpected commit digest")
+	if err == nil || digestMismatch {
+		bw.verifier.ObserveWrite(bw.size, time.Since(commitStart), digestMismatch)
+	}
+	if err != nil {
 		// The writer didn't create a new blob so we don't need to keep the lease.
 		_ = bw.client.LeasesService().Delete(ctx, bw.lease)
 
 		if errdefs.IsAlreadyExists(err) {
 			log.Debug("Blob already exists in containerd content store.")
 		} else {
-			return distribution.Descriptor{}, fmt.Errorf("commit blob to containerd content store: %w", err)
+			err = fmt.Errorf("commit blob to containerd content store: %w", err)
+			bw.progress.Finish(bw.id, progress.StateFailed, err)
+			if digestMismatch {
+				// Report the digest mismatch as DIGEST_INVALID rather than an unknown error.
+				return distribution.Descriptor{}, errcode.ErrorCodeDigestInvalid.WithDetail(err.Error())
+			}
+			return distribution.Descriptor{}, err
 		}
 	} else {
 		log.Debug("Successfully committed blob to containerd content store.")
+		bw.reporter.BlobCommitted(desc.Digest, bw.size, startedAt)
+		bw.uploadLeases.add(desc.Digest, bw.lease)
+	}
+	bw.progress.Finish(bw.id, progress.StateCommitted, nil)
+
+	// The blob may already exist but only be retained by the lease of another push that may fail so it's added to
+	// the push transaction as well.
+	if bw.transactions != nil {
+		if err = bw.transactions.add(ctx, bw.repo.Name(), desc.Digest); err != nil {
+			// The blob is still retained by the upload lease until it expires.
+			log.WithError(err).Warn("Failed to add blob to push transaction.")
+		} else if err = bw.client.LeasesService().Delete(ctx, bw.lease); err != nil && !errdefs.IsNotFound(err) {
+			log.WithError(err).Debug("Failed to delete containerd lease of committed upload.")
+		}
 	}
 
 	if desc.Size == 0 {
@@ -171,12 +441,61 @@ func (bw *blobWriter) Commit(ctx context.Context, desc distribution.Descriptor)
 	return desc, nil
 }
 
+// verifyDigest compares the digest computed while the data was streamed with the expected one. A mismatching upload is
+// canceled right away, deleting the written data, rather than committed to containerd to fail there.
+func (bw *blobWriter) verifyDigest(ctx context.Context, expected digest.Digest) error {
+	if bw.hash == nil || expected.Algorithm() != digest.SHA256 {
+		return nil
+	}
+	actual := digest.NewDigest(digest.SHA256, bw.hash)
+	if actual == expected {
+		return nil
+	}
+
+	err := fmt.Errorf("digest mismatch: expected %s, uploaded data has %s", expected, actual)
+	bw.verifier.ObserveWrite(bw.size, 0, true)
+	bw.progress.Finish(bw.id, progress.StateFailed, err)
+	bw.finish()
+	if aErr := bw.client.ContentStore().Abort(ctx, uploadRef(bw.id)); aErr != nil && !errdefs.IsNotFound(aErr) {
+		bw.log.WithError(aErr).Debug("Failed to abort containerd ingest of upload.")
+	}
+	_ = bw.client.LeasesService().Delete(ctx, bw.lease)
+	// DIGEST_INVALID is the error the OCI distribution spec defines for an upload that doesn't match its digest.
+	return errcode.ErrorCodeDigestInvalid.WithDetail(err.Error())
+}
+
+// acquireCopy waits until a copy or verification operation is allowed to run according to the configured limit.
+// The returned function must be called to release the slot once the operation is done.
+func (bw *blobWriter) acquireCopy(ctx context.Context) (func(), error) {
+	if bw.copyLimit == nil {
+		return func() {}, nil
+	}
+	if err := bw.copyLimit.Acquire(ctx, 1); err != nil {
+		return nil, fmt.Errorf("wait for concurrent copy limit: %w", err)
+	}
+	return func() { bw.copyLimit.Release(1) }, nil
+}
+
 // Cancel cancels the blob upload by deleting the containerd lease.
 func (bw *blobWriter) Cancel(ctx context.Context) error {
 	bw.log.Debug("Canceling upload: deleting containerd lease.")
+	bw.progress.Finish(bw.id, progress.StateCanceled, nil)
+	bw.finish()
 	return bw.client.LeasesService().Delete(ctx, bw.lease)
 }
 
+// finish marks the upload as finished and deletes its persisted session.
+func (bw *blobWriter) finish() {
+	bw.finished = true
+	if !bw.persisted {
+		return
+	}
+	if err := bw.metadata.Delete(metadata.BucketUploads, bw.id); err != nil {
+		bw.log.WithError(err).Warn("Failed to delete upload session.")
+	}
+	bw.persisted = false
+}
+
 // Close closes the containerd blob writer.
 func (bw *blobWriter) Close() error {
 	bw.log.Debug("Closing containerd blob writer.")
@@ -184,7 +503,24 @@ func (bw *blobWriter) Close() error {
 
 	if bw.size == 0 {
 		// It's safe to delete the lease if no data was written to the writer. Deletion is idempotent.
-		err = errors.Join(bw.client.LeasesService().Delete(context.Background(), bw.lease))
+		err = errors.Join(err, bw.client.LeasesService().Delete(context.Background(), bw.lease))
+	}
+	if !bw.finished {
+		// Persist the session so that the next request of the upload resumes it at the right offset.
+		session := uploadSession{
+			Repo:      bw.repo.Name(),
+			LeaseID:   bw.lease.ID,
+			StartedAt: bw.startedAt,
+			Offset:    bw.size,
+		}
+		if bw.hash != nil {
+			session.HashState, _ = bw.hash.(encoding.BinaryMarshaler).MarshalBinary()
+		}
+		if pErr := bw.metadata.Put(metadata.BucketUploads, bw.id, session); pErr != nil {
+			bw.log.WithError(pErr).Warn("Failed to persist upload session.")
+		} else {
+			bw.persisted = true
+		}
 	}
 
 	return err
@@ -0,0 +1,348 @@
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/metadata"
+	"github.com/psviderski/unregistry/internal/registrytest"
+	"github.com/psviderski/unregistry/internal/transfer"
+	"golang.org/x/sync/semaphore"
+)
+
+// newTestBlobStore returns a blob store backed by a local containerd content store and in-memory leases.
+func newTestBlobStore(t *testing.T) *blobStore {
+	t.Helper()
+	repo, err := reference.ParseNormalizedNamed("myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &blobStore{
+		client:          registrytest.NewClient(t),
+		repo:            repo,
+		metadata:        metadata.NewMemoryStore(),
+		leaseExpiration: time.Hour,
+	}
+}
+
+// expireLeases deletes all leases as if they expired.
+func expireLeases(t *testing.T, cli *client.Client) []leases.Lease {
+	t.Helper()
+	list, err := cli.LeasesService().List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range list {
+		if err = cli.LeasesService().Delete(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return list
+}
+
+// uploadRequestContext returns a context with the upload request resuming the upload at the offset the way
+// the distribution upload handler passes it. The HMAC of the upload state isn't checked by the blob writer.
+func uploadRequestContext(method string, offset int64) context.Context {
+	state, _ := json.Marshal(struct{ Offset int64 }{Offset: offset})
+	token := base64.URLEncoding.EncodeToString(append(make([]byte, sha256.Size), state...))
+	req := httptest.NewRequest(method, "/v2/myapp/blobs/uploads/id?_state="+token, nil)
+	// Distribution stores the request in the context under this string key.
+	return context.WithValue(context.Background(), "http.request", req)
+}
+
+// writeChunk resumes the upload at the offset, writes the data and closes the writer as a PATCH request does.
+func writeChunk(t *testing.T, store *blobStore, id string, offset int64, data []byte) distribution.BlobWriter {
+	t.Helper()
+	bw, err := newBlobWriter(uploadRequestContext(http.MethodPatch, offset), store, id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bw.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	return bw
+}
+
+func TestBlobWriterResumeBehindWrittenData(t *testing.T) {
+	store := newTestBlobStore(t)
+	data := []byte(strings.Repeat("unregistry", 10))
+
+	bw := writeChunk(t, store, "", 0, data[:60])
+	id := bw.ID()
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client didn't receive the response of the previous request and resends the data from offset 40.
+	bw, err := newBlobWriter(uploadRequestContext(http.MethodPatch, 40), store, id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bw.Size() != 40 {
+		t.Fatalf("expected the size to match the requested offset 40 so that Content-Range is accepted, got %d",
+			bw.Size())
+	}
+	if _, err = bw.ReadFrom(bytes.NewReader(data[40:])); err != nil {
+		t.Fatal(err)
+	}
+	if bw.Size() != int64(len(data)) {
+		t.Fatalf("expected size %d, got %d", len(data), bw.Size())
+	}
+
+	desc, err := bw.Commit(context.Background(), distribution.Descriptor{Digest: digest.FromBytes(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Size != int64(len(data)) {
+		t.Fatalf("expected committed size %d, got %d", len(data), desc.Size)
+	}
+}
+
+func TestBlobWriterResumeAheadOfWrittenData(t *testing.T) {
+	store := newTestBlobStore(t)
+
+	bw := writeChunk(t, store, "", 0, []byte("0123456789"))
+	id := bw.ID()
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Content-Range starting after the data containerd has can't be continued.
+	_, err := newBlobWriter(uploadRequestContext(http.MethodPatch, 20), store, id, nil)
+	if !errors.Is(err, distribution.ErrBlobUploadUnknown) {
+		t.Fatalf("expected ErrBlobUploadUnknown for an offset after the written data, got %v", err)
+	}
+}
+
+func TestBlobWriterStatusReportsWrittenSize(t *testing.T) {
+	store := newTestBlobStore(t)
+
+	bw := writeChunk(t, store, "", 0, []byte("0123456789"))
+	id := bw.ID()
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Status requests don't carry the client's offset and report what containerd has.
+	bw, err := newBlobWriter(uploadRequestContext(http.MethodGet, 4), store, id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bw.Close()
+	if bw.Size() != 10 {
+		t.Fatalf("expected the status to report the written size 10, got %d", bw.Size())
+	}
+}
+
+func TestBlobWriterResumeAfterLeaseExpired(t *testing.T) {
+	t.Run("content collected", func(t *testing.T) {
+		store := newTestBlobStore(t)
+
+		bw := writeChunk(t, store, "", 0, []byte("0123456789"))
+		id := bw.ID()
+		if err := bw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// The lease expired and the garbage collector deleted the ingest.
+		expireLeases(t, store.client)
+		if err := store.client.ContentStore().Abort(context.Background(), uploadRef(id)); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := newBlobWriter(uploadRequestContext(http.MethodPatch, 10), store, id, nil)
+		if !errors.Is(err, distribution.ErrBlobUploadUnknown) {
+			t.Fatalf("expected ErrBlobUploadUnknown for a collected upload, got %v", err)
+		}
+		var session uploadSession
+		if err = store.metadata.Get(metadata.BucketUploads, id, &session); !errors.Is(err, metadata.ErrNotFound) {
+			t.Fatalf("expected the session of the collected upload to be deleted, got %v", err)
+		}
+	})
+
+	t.Run("content retained", func(t *testing.T) {
+		store := newTestBlobStore(t)
+		data := []byte("0123456789abcdef")
+
+		bw := writeChunk(t, store, "", 0, data[:10])
+		id := bw.ID()
+		if err := bw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// The lease expired but the garbage collector hasn't run yet.
+		expireLeases(t, store.client)
+
+		bw = writeChunk(t, store, id, 10, data[10:])
+		if list := expireLeases(t, store.client); len(list) != 1 {
+			t.Fatalf("expected a new lease for the resumed upload, got %d leases", len(list))
+		}
+		if _, err := bw.Commit(context.Background(), distribution.Descriptor{Digest: digest.FromBytes(data)}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestBlobWriterCommitWrongDigest(t *testing.T) {
+	store := newTestBlobStore(t)
+
+	bw := writeChunk(t, store, "", 0, []byte("0123456789"))
+	id := bw.ID()
+	_, err := bw.Commit(context.Background(), distribution.Descriptor{Digest: digest.FromString("other")})
+	var codeErr errcode.Error
+	if !errors.As(err, &codeErr) || codeErr.Code != errcode.ErrorCodeDigestInvalid {
+		t.Fatalf("expected DIGEST_INVALID error, got %v", err)
+	}
+	if _, err = store.client.ContentStore().Status(context.Background(), uploadRef(id)); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected the ingest of the mismatching upload to be aborted, got %v", err)
+	}
+	if err = bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var session uploadSession
+	if err = store.metadata.Get(metadata.BucketUploads, id, &session); !errors.Is(err, metadata.ErrNotFound) {
+		t.Fatalf("expected no session to be persisted for the rejected upload, got %v", err)
+	}
+}
+
+func TestBlobWriterResumedUploadHashesFullContent(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789", 5))
+	tests := []struct {
+		name   string
+		resume func(t *testing.T, store *blobStore, id string) distribution.BlobWriter
+		// wantHash is whether the hash is continued rather than left to containerd to verify on commit.
+		wantHash bool
+	}{
+		{
+			name: "hash state persisted",
+			resume: func(t *testing.T, store *blobStore, id string) distribution.BlobWriter {
+				return writeChunk(t, store, id, 20, data[20:])
+			},
+			wantHash: true,
+		},
+		{
+			name: "resent data skipped",
+			resume: func(t *testing.T, store *blobStore, id string) distribution.BlobWriter {
+				return writeChunk(t, store, id, 10, data[10:])
+			},
+			wantHash: true,
+		},
+		{
+			name: "session lost",
+			resume: func(t *testing.T, store *blobStore, id string) distribution.BlobWriter {
+				if err := store.metadata.Delete(metadata.BucketUploads, id); err != nil {
+					t.Fatal(err)
+				}
+				return writeChunk(t, store, id, 20, data[20:])
+			},
+			wantHash: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, wantErr := range []bool{false, true} {
+				store := newTestBlobStore(t)
+				bw := writeChunk(t, store, "", 0, data[:20])
+				if err := bw.Close(); err != nil {
+					t.Fatal(err)
+				}
+
+				bw = tt.resume(t, store, bw.ID())
+				if hashed := bw.(*blobWriter).hash != nil; hashed != tt.wantHash {
+					t.Fatalf("expected hash continued: %t, got %t", tt.wantHash, hashed)
+				}
+				dgst := digest.FromBytes(data)
+				if wantErr {
+					// Only the digest of the resumed chunk doesn't match the full content.
+					dgst = digest.FromBytes(data[20:])
+				}
+				_, err := bw.Commit(context.Background(), distribution.Descriptor{Digest: dgst})
+				if wantErr && err == nil {
+					t.Fatal("expected the digest of the resumed chunk alone to be rejected")
+				}
+				if !wantErr && err != nil {
+					t.Fatalf("expected the digest of the full content to be accepted: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestBlobWriterVerifyOnWriteDisabled(t *testing.T) {
+	store := newTestBlobStore(t)
+	store.verifier = transfer.NewVerifier(false, false)
+
+	bw := writeChunk(t, store, "", 0, []byte("0123456789"))
+	if bw.(*blobWriter).hash != nil {
+		t.Fatal("expected the upload not to be hashed with verification on write disabled")
+	}
+	// Containerd still rejects the mismatching digest on commit.
+	_, err := bw.Commit(context.Background(), distribution.Descriptor{Digest: digest.FromString("other")})
+	var codeErr errcode.Error
+	if !errors.As(err, &codeErr) || codeErr.Code != errcode.ErrorCodeDigestInvalid {
+		t.Fatalf("expected DIGEST_INVALID error, got %v", err)
+	}
+	if stats := store.verifier.Stats(); stats.Write.Failures != 1 {
+		t.Fatalf("expected the failed verification on commit to be recorded, got %+v", stats.Write)
+	}
+}
+
+func TestBlobWriterCopyLimit(t *testing.T) {
+	store := newTestBlobStore(t)
+	store.copyLimit = semaphore.NewWeighted(1)
+
+	// A slow client receiving its data doesn't hold the only copy slot.
+	slow, err := newBlobWriter(uploadRequestContext(http.MethodPatch, 0), store, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := slow.ReadFrom(pr)
+		done <- err
+	}()
+	if _, err = pw.Write([]byte("slow")); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("0123456789")
+	bw := writeChunk(t, store, "", 0, data)
+	if _, err = bw.Commit(context.Background(), distribution.Descriptor{Digest: digest.FromBytes(data)}); err != nil {
+		t.Fatal(err)
+	}
+	_ = pw.Close()
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// A write waiting for a copy slot gives up when the request is canceled.
+	if err = store.copyLimit.Acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	defer store.copyLimit.Release(1)
+	ctx, cancel := context.WithCancel(uploadRequestContext(http.MethodPatch, 0))
+	waiting, err := newBlobWriter(ctx, store, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err = waiting.ReadFrom(bytes.NewReader(data)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the write to be canceled with the request, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// leaseTypeCheck is the type of lease retaining the test blob written by Check.
+	leaseTypeCheck = "check"
+	// checkLeaseExpiration is the expiration of the check lease. The lease is deleted by Check, the expiration only
+	// limits how long the test blob is retained if the check is interrupted.
+	checkLeaseExpiration = 5 * time.Minute
+)
+
+// CheckStep is a successfully passed step of Check.
+type CheckStep struct {
+	// Name describes what the step verified.
+	Name string
+	// Detail is additional information about the step result, e.g. the containerd version.
+	Detail string
+}
+
+// Check verifies that the registry can work with the containerd namespace, the default namespace of the client. It
+// connects to containerd, creates a lease, writes a tiny random test blob under the lease, reads it back, and deletes
+// both the blob and the lease. The step callback is called after each passed step. The returned error names the step
+// that failed.
+func Check(ctx context.Context, cli *client.Client, namespace string, step func(CheckStep)) error {
+	version, err := cli.Version(ctx)
+	if err != nil {
+		return fmt.Errorf("connect to containerd: %w", err)
+	}
+	step(CheckStep{Name: "Connect to containerd", Detail: "version " + version.Version})
+
+	namespaces, err := cli.NamespaceService().List(ctx)
+	if err != nil {
+		return fmt.Errorf("list containerd namespaces: %w", err)
+	}
+	detail := "exists"
+	if !slices.Contains(namespaces, namespace) {
+		detail = "doesn't exist yet, will be created on the first push"
+	}
+	step(CheckStep{Name: fmt.Sprintf("Namespace '%s'", namespace), Detail: detail})
+
+	leasesService := cli.LeasesService()
+	lease, err := leasesService.Create(ctx,
+		leases.WithRandomID(),
+		leases.WithExpiration(checkLeaseExpiration),
+		leases.WithLabel(leaseTypeLabel, leaseTypeCheck),
+	)
+	if err != nil {
+		return fmt.Errorf("create containerd lease: %w", err)
+	}
+	leaseDeleted := false
+	defer func() {
+		if !leaseDeleted {
+			// Clean up after a failed step but report the original error.
+			_ = leasesService.Delete(context.WithoutCancel(ctx), lease)
+		}
+	}()
+	step(CheckStep{Name: "Create lease", Detail: lease.ID})
+
+	// The random data guarantees the test blob doesn't exist in the content store so deleting it can't affect images.
+	data := make([]byte, 32)
+	if _, err = rand.Read(data); err != nil {
+		return fmt.Errorf("generate test blob: %w", err)
+	}
+	desc := ocispec.Descriptor{
+		MediaType: "application/octet-stream",
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	contentStore := cli.ContentStore()
+	leaseCtx := leases.WithLease(ctx, lease.ID)
+	if err = content.WriteBlob(
+		leaseCtx, contentStore, "unregistry-check-"+lease.ID, bytes.NewReader(data), desc,
+	); err != nil {
+		return fmt.Errorf("write test blob to containerd content store: %w", err)
+	}
+	blobDeleted := false
+	defer func() {
+		if !blobDeleted {
+			_ = contentStore.Delete(context.WithoutCancel(ctx), desc.Digest)
+		}
+	}()
+	step(CheckStep{Name: "Write test blob", Detail: desc.Digest.String()})
+
+	read, err := content.ReadBlob(ctx, contentStore, desc)
+	if err != nil {
+		return fmt.Errorf("read test blob from containerd content store: %w", err)
+	}
+	if !bytes.Equal(read, data) {
+		return fmt.Errorf("read test blob from containerd content store: content doesn't match the written data")
+	}
+	step(CheckStep{Name: "Read test blob"})
+
+	if err = contentStore.Delete(ctx, desc.Digest); err != nil {
+		return fmt.Errorf("delete test blob from containerd content store: %w", err)
+	}
+	blobDeleted = true
+	step(CheckStep{Name: "Delete test blob"})
+
+	if err = leasesService.Delete(ctx, lease); err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("delete containerd lease '%s': %w", lease.ID, err)
+	}
+	leaseDeleted = true
+	step(CheckStep{Name: "Delete lease"})
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package containerd
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/plugins"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+)
+
+// ClientOpt configures the containerd client created by NewClient.
+type ClientOpt func(*clientOptions)
+
+type clientOptions struct {
+	tlsConfig *tls.Config
+}
+
+// WithTLS makes the client connect to a TCP endpoint over TLS with the given configuration. Plaintext is used if nil.
+func WithTLS(tlsConfig *tls.Config) ClientOpt {
+	return func(o *clientOptions) {
+		o.tlsConfig = tlsConfig
+	}
+}
+
+// NewClient creates a containerd client connected to the given endpoint that uses the namespace by default.
+// The endpoint is a path to the unix socket optionally prefixed with "unix://", an abstract unix socket name prefixed
+// with "@", or "tcp://<host>:<port>" for containerd listening on a TCP address, e.g. in another VM.
+func NewClient(endpoint, namespace string, opts ...ClientOpt) (*client.Client, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("containerd socket path is required")
+	}
+	if namespace == "" {
+		return nil, fmt.Errorf("containerd namespace is required")
+	}
+	var o clientOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	network, addr, err := ParseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if o.tlsConfig != nil && network != "tcp" {
+		return nil, fmt.Errorf("TLS is only supported for TCP containerd endpoints")
+	}
+
+	copts := []client.Opt{
+		client.WithDefaultNamespace(namespace),
+		client.WithExtraDialOpts([]grpc.DialOption{grpc.WithChainUnaryInterceptor(retryUnavailable)}),
+	}
+	// The containerd client only dials unix socket paths itself.
+	if network != "unix" || strings.HasPrefix(addr, "@") {
+		creds := insecure.NewCredentials()
+		if o.tlsConfig != nil {
+			tlsConfig := o.tlsConfig.Clone()
+			if tlsConfig.ServerName == "" {
+				tlsConfig.ServerName, _, _ = net.SplitHostPort(addr)
+			}
+			creds = credentials.NewTLS(tlsConfig)
+		}
+		copts = append(copts, client.WithDialOpts([]grpc.DialOption{
+			grpc.WithTransportCredentials(creds),
+			// The client always dials a "unix://" target so resolve it to the endpoint address instead.
+			grpc.WithResolvers(staticResolver{addr: addr}),
+			grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			}),
+		}))
+	}
+
+	cli, err := client.New(addr, copts...)
+	if err != nil {
+		return nil, fmt.Errorf("create containerd client: %w", err)
+	}
+	return cli, nil
+}
+
+// ParseEndpoint returns the network and address of the containerd endpoint.
+func ParseEndpoint(endpoint string) (string, string, error) {
+	if addr, ok := strings.CutPrefix(endpoint, "tcp://"); ok {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return "", "", fmt.Errorf("invalid containerd endpoint '%s': %w", endpoint, err)
+		}
+		return "tcp", addr, nil
+	}
+	if scheme, _, ok := strings.Cut(endpoint, "://"); ok && scheme != "unix" {
+		return "", "", fmt.Errorf(
+			"invalid containerd endpoint '%s': expected a unix socket path or 'tcp://<host>:<port>'", endpoint)
+	}
+	return "unix", strings.TrimPrefix(endpoint, "unix://"), nil
+}
+
+// staticResolver is a gRPC resolver of the "unix" scheme that resolves any target to the address.
+type staticResolver struct {
+	addr string
+}
+
+func (r staticResolver) Build(
+	_ resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions,
+) (resolver.Resolver, error) {
+	err := cc.UpdateState(resolver.State{Addresses: []resolver.Address{{Addr: r.addr}}})
+	return r, err
+}
+
+func (r staticResolver) Scheme() string {
+	return "unix"
+}
+
+func (staticResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (staticResolver) Close() {}
+
+// ContentStoreRoot returns the directory of the containerd content store as reported by its content plugin.
+func ContentStoreRoot(ctx context.Context, cli *client.Client) (string, error) {
+	resp, err := cli.IntrospectionService().Plugins(ctx, "type=="+string(plugins.ContentPlugin))
+	if err != nil {
+		return "", fmt.Errorf("list containerd content plugins: %w", err)
+	}
+	for _, p := range resp.Plugins {
+		if root := p.Exports["root"]; root != "" {
+			return root, nil
+		}
+	}
+	return "", fmt.Errorf("containerd content plugin doesn't report its root directory")
+}
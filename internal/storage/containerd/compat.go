@@ -0,0 +1,108 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// ImageCompat describes how an image stored in containerd is identified by Docker depending on whether Docker uses
+// the containerd image store or a classic graphdriver storage. The same image has different IDs in these stores
+// which routinely confuses users comparing images across hosts with different storage configurations.
+type ImageCompat struct {
+	// Image is the normalized image reference, e.g. "docker.io/library/ubuntu:latest".
+	Image string `json:"image"`
+	// MediaType is the media type of the image target: an image index or manifest.
+	MediaType string `json:"mediaType"`
+	// ContainerdImageID is the image ID shown by Docker with the containerd image store. It's the digest of
+	// the image index or manifest.
+	ContainerdImageID digest.Digest `json:"containerdImageID"`
+	// GraphdriverImageID is the image ID shown by Docker with a classic graphdriver storage. It's the digest of
+	// the image config for the platform.
+	GraphdriverImageID digest.Digest `json:"graphdriverImageID"`
+	// ArtifactType is the artifact type of the manifest if the image is an OCI artifact rather than a runnable image.
+	ArtifactType string `json:"artifactType,omitempty"`
+	// Platform is the platform used to resolve the image config from an image index.
+	Platform string `json:"platform"`
+	// Platforms is the list of platforms available in the image.
+	Platforms []string `json:"platforms"`
+	// Warnings explain the differences users may observe between hosts with different storages.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Compat returns the compatibility report for the image with the given reference in the containerd image store.
+// The platform is used to resolve the image config from a multi-platform image.
+func Compat(
+	ctx context.Context, cli *client.Client, ref string, platform platforms.MatchComparer,
+) (ImageCompat, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ImageCompat{}, fmt.Errorf("invalid image reference '%s': %w", ref, err)
+	}
+	named = reference.TagNameOnly(named)
+
+	img, err := cli.ImageService().Get(ctx, named.String())
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return ImageCompat{}, fmt.Errorf("image '%s' not found in containerd image store: %w", named, err)
+		}
+		return ImageCompat{}, fmt.Errorf("get image '%s' from containerd image store: %w", named, err)
+	}
+
+	report := ImageCompat{
+		Image:             named.String(),
+		MediaType:         img.Target.MediaType,
+		ContainerdImageID: img.Target.Digest,
+	}
+
+	// Artifacts have no image config so Docker doesn't identify them by it and can't run them.
+	if report.ArtifactType = img.Labels[artifactTypeLabel]; report.ArtifactType != "" {
+		report.Warnings = append(report.Warnings, fmt.Sprintf(
+			"The image is an OCI artifact of type %s rather than a runnable image. Docker can't run it and a "+
+				"classic graphdriver storage can't store it.", report.ArtifactType,
+		))
+		return report, nil
+	}
+
+	contentStore := cli.ContentStore()
+	imgPlatforms, err := images.Platforms(ctx, contentStore, img.Target)
+	if err != nil {
+		return ImageCompat{}, fmt.Errorf("get platforms of image '%s': %w", named, err)
+	}
+	for _, p := range imgPlatforms {
+		report.Platforms = append(report.Platforms, platforms.Format(p))
+	}
+
+	config, err := images.Config(ctx, contentStore, img.Target, platform)
+	if err != nil {
+		return ImageCompat{}, fmt.Errorf("get config of image '%s': %w", named, err)
+	}
+	report.GraphdriverImageID = config.Digest
+	if config.Platform != nil {
+		report.Platform = platforms.Format(*config.Platform)
+	} else if p, err := images.ConfigPlatform(ctx, contentStore, config); err == nil {
+		report.Platform = platforms.Format(p)
+	}
+
+	// The manifest or index digest always differs from the config digest as they're digests of different blobs.
+	report.Warnings = append(report.Warnings, fmt.Sprintf(
+		"Docker with the containerd image store identifies this image as %s while Docker with a classic "+
+			"graphdriver storage identifies it as %s. The image content is the same, only the ID differs.",
+		report.ContainerdImageID.Encoded()[:12], report.GraphdriverImageID.Encoded()[:12],
+	))
+	if images.IsIndexType(img.Target.MediaType) && len(imgPlatforms) > 1 {
+		report.Warnings = append(report.Warnings, fmt.Sprintf(
+			"The image is multi-platform. Docker with a classic graphdriver storage only keeps the %s platform "+
+				"when pulling it, so pushing it back from such a host results in a different image.",
+			report.Platform,
+		))
+	}
+
+	return report, nil
+}
@@ -0,0 +1,50 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/images/archive"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
+	"github.com/distribution/reference"
+)
+
+// ExportImage writes the image with the reference in the containerd image store to w as a tarball in the OCI image
+// layout assembled directly from the content store. The image content is leased while it's being written, so removing
+// the image can't delete it in the meantime.
+//
+// If platform is nil, the index of a multi-platform image is exported with the manifests of all platforms and their
+// content present on the host. Otherwise, only the manifest matching the platform is exported along with the Docker
+// manifest.json, so "docker load" with a classic graphdriver storage can load the tarball too.
+func ExportImage(
+	ctx context.Context, cli *client.Client, ref reference.Named, platform platforms.MatchComparer, w io.Writer,
+) error {
+	img, err := cli.ImageService().Get(ctx, ref.String())
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return fmt.Errorf("image '%s' not found in containerd image store: %w", ref.String(), err)
+		}
+		return fmt.Errorf("get image '%s' from containerd image store: %w", ref.String(), err)
+	}
+
+	// The garbage collection labels of the index or manifest retain the rest of the image content.
+	release, err := LeaseContent(ctx, cli, img.Target.Digest)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	opts := []archive.ExportOpt{archive.WithManifest(img.Target, ref.String())}
+	if platform != nil {
+		opts = append(opts, archive.WithPlatform(platform))
+	} else {
+		opts = append(opts, archive.WithSkipMissing(cli.ContentStore()))
+	}
+	if err = cli.Export(ctx, w, opts...); err != nil {
+		return fmt.Errorf("export image '%s': %w", ref.String(), err)
+	}
+	return nil
+}
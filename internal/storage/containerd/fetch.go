@@ -0,0 +1,100 @@
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/platforms"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// dockerReferenceTypeAnnotation is the annotation of the index descriptors of the attestation manifests buildx
+	// attaches to multi-platform images.
+	dockerReferenceTypeAnnotation = "vnd.docker.reference.type"
+	// dockerReferenceDigestAnnotation is the annotation of the index descriptors of attestation manifests with the
+	// digest of the platform manifest they attest.
+	dockerReferenceDigestAnnotation = "vnd.docker.reference.digest"
+	// dockerAttestationManifestType is the value of dockerReferenceTypeAnnotation for attestation manifests.
+	dockerAttestationManifestType = "attestation-manifest"
+)
+
+// FetchImage fetches the image with the remote reference using the resolver and creates or updates the image with
+// the target reference in the containerd image store to point to it. Only the manifests of a multi-platform image
+// matching the platform are fetched with their content, along with their provenance and SBOM attestation manifests.
+func FetchImage(
+	ctx context.Context,
+	cli *client.Client,
+	resolver remotes.Resolver,
+	remoteRef string,
+	target reference.Named,
+	platform platforms.MatchComparer,
+) (ocispec.Descriptor, error) {
+	// Hold the fetched content with a lease until the image references it.
+	ctx, done, err := cli.WithLease(ctx)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("create containerd lease: %w", err)
+	}
+	defer func() {
+		_ = done(context.WithoutCancel(ctx))
+	}()
+
+	img, err := cli.Fetch(ctx, remoteRef,
+		client.WithResolver(resolver),
+		client.WithPlatformMatcher(platform),
+		client.WithImageHandlerWrapper(withAttestations(cli.ContentStore())),
+	)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("fetch image '%s': %w", remoteRef, err)
+	}
+	// Only the content for the platform is fetched.
+	if err = createImage(ctx, cli, target, img.Target, true); err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return img.Target, nil
+}
+
+// withAttestations returns a handler wrapper that adds the attestation manifests of the fetched platform manifests
+// to the children of an index. Buildx stores attestations as index manifests with the "unknown/unknown" platform
+// which the platform matcher filters out otherwise.
+func withAttestations(provider content.Provider) func(images.Handler) images.Handler {
+	return func(h images.Handler) images.Handler {
+		return images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+			children, err := h.Handle(ctx, desc)
+			if err != nil || !images.IsIndexType(desc.MediaType) {
+				return children, err
+			}
+
+			// The index has been fetched to the content store by the wrapped handler.
+			blob, err := content.ReadBlob(ctx, provider, desc)
+			if err != nil {
+				return nil, fmt.Errorf("read index '%s': %w", desc.Digest, err)
+			}
+			var index ocispec.Index
+			if err = json.Unmarshal(blob, &index); err != nil {
+				return nil, fmt.Errorf("unmarshal index '%s': %w", desc.Digest, err)
+			}
+
+			fetched := make(map[digest.Digest]bool, len(children))
+			for _, c := range children {
+				fetched[c.Digest] = true
+			}
+			for _, m := range index.Manifests {
+				if m.Annotations[dockerReferenceTypeAnnotation] != dockerAttestationManifestType || fetched[m.Digest] {
+					continue
+				}
+				if fetched[digest.Digest(m.Annotations[dockerReferenceDigestAnnotation])] {
+					children = append(children, m)
+				}
+			}
+			return children, nil
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// gcRootLabel marks content that containerd must never garbage collect.
+	gcRootLabel = "containerd.io/gc.root"
+	// gcRefContentLabelPrefix is the prefix of the content labels referencing other content that must be retained.
+	gcRefContentLabelPrefix = "containerd.io/gc.ref.content"
+)
+
+// Garbage kinds reported by CollectGarbage.
+const (
+	GarbageLease   = "lease"
+	GarbageIngest  = "ingest"
+	GarbageContent = "content"
+)
+
+// Garbage is a containerd resource left behind by failed or interrupted pushes.
+type Garbage struct {
+	// Kind is one of GarbageLease, GarbageIngest, or GarbageContent.
+	Kind string `json:"kind"`
+	// ID is the lease ID, ingest reference, or content digest.
+	ID string `json:"id"`
+	// Size is the number of bytes the resource occupies. It's 0 for leases as they don't hold data themselves.
+	Size int64 `json:"size"`
+	// UpdatedAt is when the lease expired or the ingest or content was last updated.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// GarbageReport describes the garbage found and collected by CollectGarbage.
+type GarbageReport struct {
+	Garbage []Garbage `json:"garbage"`
+	// Reclaimable is the total size of the garbage ingests and content.
+	Reclaimable int64 `json:"reclaimable"`
+	// Deleted is whether the garbage has been deleted or only found.
+	Deleted bool `json:"deleted"`
+}
+
+// CollectGarbage finds the garbage in the containerd namespace of the client left behind by failed or interrupted
+// pushes and deletes it if del is true:
+//   - unregistry leases that have expired but haven't been garbage collected by containerd yet,
+//   - upload ingests that haven't received data for longer than minAge,
+//   - content not updated for longer than minAge that isn't referenced by any image in the namespace or retained by
+//     a lease that hasn't expired.
+//
+// Leases are deleted before the content so that the content they retained can be collected in the same run.
+// The upload sessions of the aborted ingests are removed by the registry when it purges stale uploads.
+func CollectGarbage(ctx context.Context, cli *client.Client, minAge time.Duration, del bool) (GarbageReport, error) {
+	report := GarbageReport{Deleted: del}
+	now := time.Now()
+
+	// Content retained by the leases that are still alive isn't garbage.
+	leased := make(map[digest.Digest]struct{})
+	leasesService := cli.LeasesService()
+	allLeases, err := leasesService.List(ctx)
+	if err != nil {
+		return report, fmt.Errorf("list containerd leases: %w", err)
+	}
+	var expired []leases.Lease
+	for _, l := range allLeases {
+		expire, err := time.Parse(time.RFC3339Nano, l.Labels[leaseExpireLabel])
+		if _, ok := l.Labels[leaseTypeLabel]; ok && err == nil && expire.Before(now) {
+			expired = append(expired, l)
+			report.Garbage = append(report.Garbage, Garbage{Kind: GarbageLease, ID: l.ID, UpdatedAt: expire})
+			continue
+		}
+		resources, err := leasesService.ListResources(ctx, l)
+		if err != nil {
+			return report, fmt.Errorf("list resources of containerd lease '%s': %w", l.ID, err)
+		}
+		for _, r := range resources {
+			if r.Type == "content" {
+				if dgst, err := digest.Parse(r.ID); err == nil {
+					leased[dgst] = struct{}{}
+				}
+			}
+		}
+	}
+
+	contentStore := cli.ContentStore()
+	statuses, err := contentStore.ListStatuses(ctx)
+	if err != nil {
+		return report, fmt.Errorf("list ingests in containerd content store: %w", err)
+	}
+	var staleIngests []string
+	for _, status := range statuses {
+		if !strings.HasPrefix(status.Ref, uploadRef("")) || now.Sub(status.UpdatedAt) <= minAge {
+			continue
+		}
+		staleIngests = append(staleIngests, status.Ref)
+		report.Garbage = append(report.Garbage, Garbage{
+			Kind:      GarbageIngest,
+			ID:        status.Ref,
+			Size:      status.Offset,
+			UpdatedAt: status.UpdatedAt,
+		})
+		report.Reclaimable += status.Offset
+	}
+
+	orphaned, err := unreferencedContent(ctx, cli, leased)
+	if err != nil {
+		return report, err
+	}
+	var orphanedDigests []digest.Digest
+	for _, info := range orphaned {
+		if now.Sub(info.UpdatedAt) <= minAge {
+			continue
+		}
+		orphanedDigests = append(orphanedDigests, info.Digest)
+		report.Garbage = append(report.Garbage, Garbage{
+			Kind:      GarbageContent,
+			ID:        info.Digest.String(),
+			Size:      info.Size,
+			UpdatedAt: info.UpdatedAt,
+		})
+		report.Reclaimable += info.Size
+	}
+
+	if !del {
+		return report, nil
+	}
+	for _, l := range expired {
+		if err = leasesService.Delete(ctx, l); err != nil && !errdefs.IsNotFound(err) {
+			return report, fmt.Errorf("delete containerd lease '%s': %w", l.ID, err)
+		}
+	}
+	for _, ref := range staleIngests {
+		if err = contentStore.Abort(ctx, ref); err != nil && !errdefs.IsNotFound(err) {
+			return report, fmt.Errorf("abort ingest '%s' in containerd content store: %w", ref, err)
+		}
+	}
+	for _, dgst := range orphanedDigests {
+		if err = contentStore.Delete(ctx, dgst); err != nil && !errdefs.IsNotFound(err) {
+			return report, fmt.Errorf("delete blob '%s' from containerd content store: %w", dgst, err)
+		}
+	}
+	return report, nil
+}
+
+// unreferencedContent returns the content in the namespace that isn't reachable from any image, isn't retained by
+// the leased content, and isn't a garbage collection root.
+func unreferencedContent(
+	ctx context.Context, cli *client.Client, leased map[digest.Digest]struct{},
+) ([]content.Info, error) {
+	contentStore := cli.ContentStore()
+	infos := make(map[digest.Digest]content.Info)
+	err := contentStore.Walk(ctx, func(info content.Info) error {
+		infos[info.Digest] = info
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list content in containerd content store: %w", err)
+	}
+
+	imgs, err := cli.ImageService().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list images in containerd image store: %w", err)
+	}
+	referenced := make(map[digest.Digest]struct{})
+	mark := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		referenced[desc.Digest] = struct{}{}
+		return nil, nil
+	})
+	for _, img := range imgs {
+		if _, ok := infos[img.Target.Digest]; !ok {
+			continue
+		}
+		if err = images.Walk(ctx, images.Handlers(mark, presentChildrenHandler(contentStore)), img.Target); err != nil {
+			return nil, fmt.Errorf("walk content of image '%s': %w", img.Name, err)
+		}
+	}
+
+	// The retained content keeps the content referenced by its garbage collection labels, e.g. the layers of
+	// a manifest whose config can't be parsed, so follow the labels until no new content is marked.
+	var pending []digest.Digest
+	for dgst, info := range infos {
+		_, isRoot := info.Labels[gcRootLabel]
+		if _, ok := leased[dgst]; ok || isRoot {
+			referenced[dgst] = struct{}{}
+		}
+		if _, ok := referenced[dgst]; ok {
+			pending = append(pending, dgst)
+		}
+	}
+	for len(pending) > 0 {
+		dgst := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for key, value := range infos[dgst].Labels {
+			if !strings.HasPrefix(key, gcRefContentLabelPrefix) {
+				continue
+			}
+			ref, err := digest.Parse(value)
+			if err != nil {
+				continue
+			}
+			if _, ok := referenced[ref]; !ok {
+				referenced[ref] = struct{}{}
+				pending = append(pending, ref)
+			}
+		}
+	}
+
+	var unreferenced []content.Info
+	for dgst, info := range infos {
+		if _, ok := referenced[dgst]; !ok {
+			unreferenced = append(unreferenced, info)
+		}
+	}
+	slices.SortFunc(unreferenced, func(a, b content.Info) int {
+		return strings.Compare(a.Digest.String(), b.Digest.String())
+	})
+	return unreferenced, nil
+}
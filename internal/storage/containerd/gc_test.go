@@ -0,0 +1,118 @@
+package containerd
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/psviderski/unregistry/internal/registrytest"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	ctx := context.Background()
+	cli := registrytest.NewClient(t)
+	store := cli.ContentStore()
+
+	// The manifest, config, and layer of the image are referenced by the image.
+	manifest := registrytest.WriteImage(t, store)
+	img := images.Image{Name: "docker.io/library/myapp:latest", Target: manifest}
+	if _, err := cli.ImageService().Create(ctx, img); err != nil {
+		t.Fatal(err)
+	}
+	// A garbage collection root retains the content referenced by its labels.
+	child := registrytest.WriteBlob(t, store, ocispec.MediaTypeImageLayer, []byte("child"))
+	root := registrytest.WriteBlob(t, store, ocispec.MediaTypeImageLayer, []byte("root"))
+	if _, err := store.Update(ctx, content.Info{
+		Digest: root.Digest,
+		Labels: map[string]string{
+			gcRootLabel:                    time.Now().Format(time.RFC3339),
+			gcRefContentLabelPrefix + ".0": child.Digest.String(),
+		},
+	}, "labels."+gcRootLabel, "labels."+gcRefContentLabelPrefix+".0"); err != nil {
+		t.Fatal(err)
+	}
+	// Content retained by an alive lease isn't garbage, unlike content retained by an expired unregistry lease.
+	leased := registrytest.WriteBlob(t, store, ocispec.MediaTypeImageLayer, []byte("leased"))
+	alive, err := cli.LeasesService().Create(ctx, leases.WithID("alive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredLeased := registrytest.WriteBlob(t, store, ocispec.MediaTypeImageLayer, []byte("expired"))
+	expired, err := cli.LeasesService().Create(ctx, leases.WithID("expired"), leases.WithLabels(map[string]string{
+		leaseTypeLabel:   leaseTypeUpload,
+		leaseExpireLabel: time.Now().Add(-time.Minute).Format(time.RFC3339Nano),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lr := range []struct {
+		lease leases.Lease
+		desc  ocispec.Descriptor
+	}{{alive, leased}, {expired, expiredLeased}} {
+		resource := leases.Resource{ID: lr.desc.Digest.String(), Type: "content"}
+		if err = cli.LeasesService().AddResource(ctx, lr.lease, resource); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orphan := registrytest.WriteBlob(t, store, ocispec.MediaTypeImageLayer, []byte("orphan"))
+
+	// The content is too recent to be collected.
+	report, err := CollectGarbage(ctx, cli, time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Garbage{{Kind: GarbageLease, ID: "expired"}}
+	if got := garbageIDs(report); !slices.Equal(got, want) {
+		t.Fatalf("expected garbage %v, got %v", want, got)
+	}
+
+	report, err = CollectGarbage(ctx, cli, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []Garbage{
+		{Kind: GarbageLease, ID: "expired"},
+		{Kind: GarbageContent, ID: expiredLeased.Digest.String()},
+		{Kind: GarbageContent, ID: orphan.Digest.String()},
+	}
+	slices.SortFunc(want[1:], func(a, b Garbage) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	if got := garbageIDs(report); !slices.Equal(got, want) {
+		t.Fatalf("expected garbage %v, got %v", want, got)
+	}
+	if report.Reclaimable != expiredLeased.Size+orphan.Size {
+		t.Fatalf("expected %d reclaimable bytes, got %d", expiredLeased.Size+orphan.Size, report.Reclaimable)
+	}
+
+	for _, dgst := range []digest.Digest{expiredLeased.Digest, orphan.Digest} {
+		if _, err = store.Info(ctx, dgst); !errdefs.IsNotFound(err) {
+			t.Fatalf("expected garbage content %s to be deleted, got %v", dgst, err)
+		}
+	}
+	for _, dgst := range []digest.Digest{manifest.Digest, root.Digest, child.Digest, leased.Digest} {
+		if _, err = store.Info(ctx, dgst); err != nil {
+			t.Fatalf("expected retained content %s to be kept, got %v", dgst, err)
+		}
+	}
+	if list, _ := cli.LeasesService().List(ctx); len(list) != 1 || list[0].ID != "alive" {
+		t.Fatalf("expected only the alive lease to be kept, got %v", list)
+	}
+}
+
+// garbageIDs returns the garbage in the report with only the kind and ID to compare.
+func garbageIDs(report GarbageReport) []Garbage {
+	ids := make([]Garbage, len(report.Garbage))
+	for i, g := range report.Garbage {
+		ids[i] = Garbage{Kind: g.Kind, ID: g.ID}
+	}
+	return ids
+}
@@ -0,0 +1,354 @@
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ImageSummary describes an image in the containerd image store for browsing images on the host.
+type ImageSummary struct {
+	// Image is the normalized image reference, e.g. "docker.io/library/ubuntu:latest".
+	Image string `json:"image"`
+	// Repo is the repository name the way clients use to pull it, e.g. "ubuntu".
+	Repo string `json:"repo"`
+	// Tag is the image tag. It's empty for digest-addressed images.
+	Tag       string        `json:"tag,omitempty"`
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType"`
+	// ArtifactType is the artifact type of the manifest if the image is an OCI artifact, e.g. a Helm chart, rather
+	// than a runnable image.
+	ArtifactType string `json:"artifactType,omitempty"`
+	// Size is the total size of the image content present in the content store. Content for other platforms of
+	// a multi-platform image is often missing and not counted.
+	Size int64 `json:"size"`
+	// UnpackedSize is the disk usage of the image layers unpacked for the default platform in the snapshotter.
+	// It's 0 if the image isn't unpacked into the snapshotter.
+	UnpackedSize int64 `json:"unpackedSize,omitempty"`
+	// Platforms is the list of platforms available in the image.
+	Platforms []string  `json:"platforms,omitempty"`
+	Created   time.Time `json:"created"`
+	// Subject is the digest of the image the artifact refers to, e.g. a signature, SBOM, or attestation of the image.
+	// It's taken from the subject field of the manifest or from the "sha256-<hex>" tag of the referrers tag schema.
+	Subject digest.Digest `json:"subject,omitempty"`
+	// Referrers are the artifacts in the repository that refer to the image. They're listed under their subject image
+	// instead of as separate images.
+	Referrers []ImageSummary `json:"referrers,omitempty"`
+}
+
+// ListImages returns the summaries of the images in the containerd image store sorted by repository and tag.
+// Referrer artifacts are grouped under their subject image if it's in the same repository. The unpacked sizes are
+// reported for the layers of the platform unpacked into the snapshotter unless it's empty.
+func ListImages(
+	ctx context.Context, cli *client.Client, snapshotter string, platform platforms.MatchComparer,
+) ([]ImageSummary, error) {
+	imgs, err := cli.ImageService().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list images in containerd image store: %w", err)
+	}
+
+	contentStore := cli.ContentStore()
+	summaries := []ImageSummary{}
+	for _, img := range imgs {
+		// Docker keeps untagged images as "moby-dangling@<digest>" to prevent them from being garbage collected.
+		if strings.HasPrefix(img.Name, "moby-dangling@") {
+			continue
+		}
+		named, err := reference.ParseNormalizedNamed(img.Name)
+		if err != nil {
+			continue
+		}
+		summary := ImageSummary{
+			Image:        img.Name,
+			Repo:         reference.FamiliarName(named),
+			Digest:       img.Target.Digest,
+			MediaType:    img.Target.MediaType,
+			ArtifactType: img.Labels[artifactTypeLabel],
+			Created:      img.CreatedAt,
+		}
+		if tagged, ok := named.(reference.Tagged); ok {
+			summary.Tag = tagged.Tag()
+		}
+		// The subject is informational for grouping so an unreadable manifest isn't critical for the summary.
+		summary.Subject, _ = manifestSubject(ctx, contentStore, img.Target)
+		if m := referrersTagRegexp.FindStringSubmatch(summary.Tag); m != nil && summary.Subject == "" {
+			summary.Subject = digest.NewDigestFromEncoded(digest.SHA256, m[1])
+		}
+
+		if summary.Size, err = presentContentSize(ctx, cli, img.Target); err != nil {
+			return nil, fmt.Errorf("get size of image '%s': %w", img.Name, err)
+		}
+		if snapshotter != "" {
+			// The unpacked size is informational so failing to get it isn't critical for the summary.
+			summary.UnpackedSize, _ = unpackedSize(ctx, cli, img, snapshotter, platform)
+		}
+		// Platforms can't be determined if the manifests are missing which isn't critical for the summary.
+		if imgPlatforms, err := images.Platforms(ctx, contentStore, img.Target); err == nil {
+			for _, p := range imgPlatforms {
+				summary.Platforms = append(summary.Platforms, platforms.Format(p))
+			}
+			slices.Sort(summary.Platforms)
+			summary.Platforms = slices.Compact(summary.Platforms)
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	slices.SortFunc(summaries, func(a, b ImageSummary) int {
+		if c := strings.Compare(a.Repo, b.Repo); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Image, b.Image)
+	})
+	return groupReferrers(summaries), nil
+}
+
+// groupReferrers moves the summaries of referrer artifacts under the summary of their subject image in the same
+// repository. Referrers of referrers, e.g. a signature of an SBOM, are grouped under the root subject image.
+// Referrers which subject isn't on the host remain separate images.
+func groupReferrers(summaries []ImageSummary) []ImageSummary {
+	// The first image with the digest is the subject the referrers are grouped under. Tags sort before
+	// the digest-addressed image.
+	byDigest := make(map[string]int)
+	for i, s := range summaries {
+		key := s.Repo + "@" + s.Digest.String()
+		if _, ok := byDigest[key]; !ok {
+			byDigest[key] = i
+		}
+	}
+
+	parents := make([]int, len(summaries))
+	for i, s := range summaries {
+		parents[i] = -1
+		// Follow the subjects up to the image that isn't a referrer. The depth is limited to not loop forever on
+		// a cycle which can only be constructed with tags.
+		for j, depth := i, 0; s.Subject != "" && depth < 8; depth++ {
+			var ok bool
+			if j, ok = byDigest[s.Repo+"@"+summaries[j].Subject.String()]; !ok || j == i {
+				break
+			}
+			if summaries[j].Subject == "" {
+				parents[i] = j
+				break
+			}
+		}
+	}
+
+	grouped := make([]ImageSummary, 0, len(summaries))
+	index := make(map[int]int)
+	for i, s := range summaries {
+		if parents[i] == -1 {
+			index[i] = len(grouped)
+			grouped = append(grouped, s)
+		}
+	}
+	for i, s := range summaries {
+		if p := parents[i]; p != -1 {
+			parent := &grouped[index[p]]
+			parent.Referrers = append(parent.Referrers, s)
+		}
+	}
+	return grouped
+}
+
+// presentContentSize returns the total size of the unique content of the image with the target descriptor that is
+// present in the content store.
+func presentContentSize(ctx context.Context, cli *client.Client, target ocispec.Descriptor) (int64, error) {
+	blobs, err := presentContent(ctx, cli.ContentStore(), target)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, s := range blobs {
+		size += s
+	}
+	return size, nil
+}
+
+// PlatformManifest describes the manifest of an image for a single platform with its layers.
+type PlatformManifest struct {
+	// Platform is the platform of the manifest in an index, e.g. "linux/amd64". It's empty for a single-platform image.
+	Platform string        `json:"platform,omitempty"`
+	Digest   digest.Digest `json:"digest"`
+	Size     int64         `json:"size"`
+	// Present reports whether the manifest is present in the content store. Layers are unknown if it isn't.
+	Present bool           `json:"present"`
+	Layers  []LayerSummary `json:"layers,omitempty"`
+}
+
+// LayerSummary describes a layer of an image manifest.
+type LayerSummary struct {
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType"`
+	Size      int64         `json:"size"`
+	// Present reports whether the layer is present in the content store.
+	Present bool `json:"present"`
+}
+
+// ImageManifests returns the manifests of the image with the reference in the containerd image store with their
+// layers: the platform manifests of a multi-platform image, or the image manifest itself.
+func ImageManifests(ctx context.Context, cli *client.Client, ref reference.Named) ([]PlatformManifest, error) {
+	img, err := cli.ImageService().Get(ctx, ref.String())
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return nil, fmt.Errorf("image '%s' not found in containerd image store: %w", ref.String(), err)
+		}
+		return nil, fmt.Errorf("get image '%s' from containerd image store: %w", ref.String(), err)
+	}
+
+	contentStore := cli.ContentStore()
+	descs := []ocispec.Descriptor{img.Target}
+	if images.IsIndexType(img.Target.MediaType) {
+		if descs, err = images.Children(ctx, contentStore, img.Target); err != nil {
+			return nil, fmt.Errorf("read index '%s': %w", img.Target.Digest, err)
+		}
+	}
+
+	manifests := []PlatformManifest{}
+	for _, desc := range descs {
+		if !images.IsManifestType(desc.MediaType) {
+			continue
+		}
+		manifest := PlatformManifest{Digest: desc.Digest, Size: desc.Size}
+		if desc.Platform != nil {
+			manifest.Platform = platforms.Format(*desc.Platform)
+		}
+		blob, err := content.ReadBlob(ctx, contentStore, desc)
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				manifests = append(manifests, manifest)
+				continue
+			}
+			return nil, fmt.Errorf("read manifest '%s': %w", desc.Digest, err)
+		}
+		var m ocispec.Manifest
+		if err = json.Unmarshal(blob, &m); err != nil {
+			return nil, fmt.Errorf("unmarshal manifest '%s': %w", desc.Digest, err)
+		}
+
+		manifest.Present = true
+		for _, layer := range m.Layers {
+			_, err = contentStore.Info(ctx, layer.Digest)
+			if err != nil && !errdefs.IsNotFound(err) {
+				return nil, fmt.Errorf(
+					"get metadata for blob '%s' from containerd content store: %w", layer.Digest, err,
+				)
+			}
+			manifest.Layers = append(manifest.Layers, LayerSummary{
+				Digest:    layer.Digest,
+				MediaType: layer.MediaType,
+				Size:      layer.Size,
+				Present:   err == nil,
+			})
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
+
+// TagImage creates or updates the image with the target reference to point to the same content as the image with
+// the source reference, like "docker tag" does. The target reference must have a tag and no digest.
+func TagImage(ctx context.Context, cli *client.Client, source, target reference.Named) error {
+	_, tagged := target.(reference.Tagged)
+	_, digested := target.(reference.Digested)
+	if !tagged || digested {
+		return fmt.Errorf("target image reference '%s' must have a tag and no digest", target.String())
+	}
+
+	img, err := cli.ImageService().Get(ctx, source.String())
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return fmt.Errorf("image '%s' not found in containerd image store: %w", source.String(), err)
+		}
+		return fmt.Errorf("get image '%s' from containerd image store: %w", source.String(), err)
+	}
+
+	// The source image may be pulled only for some platforms.
+	return createImage(ctx, cli, target, img.Target, true)
+}
+
+// DeltaBases returns up to limit layers of the images in the repository that are present in the content store, from
+// the most recently created images first. These are the candidates a client can encode new layers of the repository
+// against to upload them as binary deltas.
+func DeltaBases(
+	ctx context.Context, cli *client.Client, repo reference.Named, limit int,
+) ([]ocispec.Descriptor, error) {
+	imgs, err := repositoryImages(ctx, cli, repo)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(imgs, func(a, b repositoryImage) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	contentStore := cli.ContentStore()
+	bases := []ocispec.Descriptor{}
+	seen := make(map[digest.Digest]struct{})
+	collect := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if !images.IsLayerType(desc.MediaType) {
+			return nil, nil
+		}
+		if _, ok := seen[desc.Digest]; ok || len(bases) >= limit {
+			return nil, nil
+		}
+		seen[desc.Digest] = struct{}{}
+		bases = append(bases, ocispec.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size})
+		return nil, nil
+	})
+	for _, img := range imgs {
+		if len(bases) >= limit {
+			break
+		}
+		if _, err = contentStore.Info(ctx, img.Target.Digest); err != nil {
+			if errdefs.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf(
+				"get metadata for blob '%s' from containerd content store: %w", img.Target.Digest, err,
+			)
+		}
+		handler := images.Handlers(collect, presentChildrenHandler(contentStore))
+		if err = images.Walk(ctx, handler, img.Target); err != nil {
+			return nil, fmt.Errorf("walk image '%s': %w", img.Name, err)
+		}
+	}
+	return bases, nil
+}
+
+// unpackedSize returns the disk usage of the layers of the image unpacked for the platform in the snapshotter.
+// It returns 0 if the image isn't fully unpacked into the snapshotter.
+func unpackedSize(
+	ctx context.Context, cli *client.Client, img images.Image, snapshotter string, platform platforms.MatchComparer,
+) (int64, error) {
+	diffIDs, err := client.NewImageWithPlatform(cli, img, platform).RootFS(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	sn := cli.SnapshotService(snapshotter)
+	var size int64
+	for i := range diffIDs {
+		usage, err := sn.Usage(ctx, identity.ChainID(diffIDs[:i+1]).String())
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				return 0, nil
+			}
+			return 0, fmt.Errorf("get usage of snapshot in snapshotter '%s': %w", snapshotter, err)
+		}
+		size += usage.Size
+	}
+	return size, nil
+}
@@ -0,0 +1,166 @@
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/images/archive"
+	"github.com/containerd/platforms"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrInvalidArchive is returned by ImportImages when the archive isn't a valid image tarball or its images can't be
+// named.
+var ErrInvalidArchive = errors.New("invalid image archive")
+
+// ErrImportDenied is returned by ImportImages when the repository filter doesn't allow an image in the archive.
+var ErrImportDenied = errors.New("repository is not allowed by the registry configuration")
+
+// anchoredTagRegexp matches a tag alone, e.g. the "org.opencontainers.image.ref.name" annotation of images in an OCI
+// layout created by tools that only record the tag.
+var anchoredTagRegexp = regexp.MustCompile(`^` + reference.TagRegexp.String() + `$`)
+
+// ImportOptions configures ImportImages.
+type ImportOptions struct {
+	// Repository is the repository of images in an OCI layout named only with a tag in the
+	// "org.opencontainers.image.ref.name" annotation, or with no name at all which are imported as digest-addressed
+	// images. Images with a full name keep it. Can be nil.
+	Repository reference.Named
+	// Filter restricts the repositories images can be imported to. Can be nil.
+	Filter *RepositoryFilter
+	// UnpackSnapshotter is the snapshotter to unpack imported images into. Unpacking is disabled if empty.
+	UnpackSnapshotter string
+	// UnpackPlatform is the platform of the multi-platform images to unpack. The host platform is used if nil.
+	UnpackPlatform platforms.MatchComparer
+}
+
+// ImportedImage is an image created in the containerd image store from an imported archive.
+type ImportedImage struct {
+	// Image is the reference of the image, either tagged or digest-addressed.
+	Image string `json:"image"`
+	// Digest is the digest of the image index or manifest.
+	Digest digest.Digest `json:"digest"`
+	// MediaType is the media type of the image index or manifest.
+	MediaType string `json:"mediaType"`
+	// ArtifactType is the artifact type of an OCI artifact, empty for images.
+	ArtifactType string `json:"artifactType,omitempty"`
+}
+
+// ImportImages writes the images from a tarball in the OCI image layout or created by "docker save" to the containerd
+// content store and creates or updates the images in the image store the same way pushing them does. The content is
+// held by a lease until the images referencing it are created, so a failed import leaves nothing behind once
+// the garbage collection runs. The names of all images are checked against the repository filter before any image is
+// created.
+func ImportImages(ctx context.Context, cli *client.Client, r io.Reader, opts ImportOptions) ([]ImportedImage, error) {
+	ctx, done, err := cli.WithLease(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("create containerd lease: %w", err)
+	}
+	defer func() {
+		_ = done(context.WithoutCancel(ctx))
+	}()
+
+	contentStore := cli.ContentStore()
+	indexDesc, err := archive.ImportIndex(ctx, contentStore, r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+	}
+	blob, err := content.ReadBlob(ctx, contentStore, indexDesc)
+	if err != nil {
+		return nil, fmt.Errorf("read index of image archive: %w", err)
+	}
+	var index ocispec.Index
+	if err = json.Unmarshal(blob, &index); err != nil {
+		return nil, fmt.Errorf("unmarshal index of image archive: %w", err)
+	}
+
+	type namedDesc struct {
+		ref  reference.Named
+		desc ocispec.Descriptor
+	}
+	var imgs []namedDesc
+	for _, desc := range index.Manifests {
+		ref, err := importedImageRef(desc, opts.Repository)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidArchive, err)
+		}
+		if !opts.Filter.Allowed(reference.FamiliarName(ref)) {
+			return nil, fmt.Errorf("import image '%s': %w", ref.String(), ErrImportDenied)
+		}
+		// The name annotations only describe the image in the archive.
+		desc.Annotations = nil
+		imgs = append(imgs, namedDesc{ref: ref, desc: desc})
+	}
+	if len(imgs) == 0 {
+		return nil, fmt.Errorf("%w: no images", ErrInvalidArchive)
+	}
+
+	unpackPlatform := opts.UnpackPlatform
+	if unpackPlatform == nil {
+		unpackPlatform = platforms.Default()
+	}
+	imported := make([]ImportedImage, 0, len(imgs))
+	for _, img := range imgs {
+		// Archives saved by docker may only include some platforms of the images.
+		if err = createImage(ctx, cli, img.ref, img.desc, true); err != nil {
+			return imported, err
+		}
+		if opts.UnpackSnapshotter != "" {
+			// The image is usable without unpacking so failing to unpack it shouldn't fail the import.
+			if err = unpackImage(ctx, cli, img.ref, img.desc, opts.UnpackSnapshotter, unpackPlatform); err != nil {
+				logrus.WithField("image", img.ref.String()).WithError(err).Warn("Failed to unpack imported image.")
+			}
+		}
+		// The artifact type is informational and the error has been logged when creating the image.
+		artifactType, _ := manifestArtifactType(ctx, contentStore, img.desc)
+		imported = append(imported, ImportedImage{
+			Image:        img.ref.String(),
+			Digest:       img.desc.Digest,
+			MediaType:    img.desc.MediaType,
+			ArtifactType: artifactType,
+		})
+	}
+	return imported, nil
+}
+
+// importedImageRef returns the reference to create the image with the descriptor from an archive index with:
+// the full name in the "io.containerd.image.name" annotation that "docker save" and "ctr export" record, the name or
+// tag in the "org.opencontainers.image.ref.name" annotation, or the digest in the repository if it has no name.
+func importedImageRef(desc ocispec.Descriptor, repo reference.Named) (reference.Named, error) {
+	name := desc.Annotations[images.AnnotationImageName]
+	if name == "" {
+		refName := desc.Annotations[ocispec.AnnotationRefName]
+		switch {
+		case refName == "" && repo == nil:
+			return nil, fmt.Errorf("image '%s' in the archive has no name, set the repository to import it to",
+				desc.Digest)
+		case refName == "":
+			return reference.WithDigest(repo, desc.Digest)
+		case anchoredTagRegexp.MatchString(refName) && repo == nil:
+			return nil, fmt.Errorf("image '%s' in the archive is only named with tag '%s', "+
+				"set the repository to import it to", desc.Digest, refName)
+		case anchoredTagRegexp.MatchString(refName):
+			return reference.WithTag(repo, refName)
+		}
+		name = refName
+	}
+
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name '%s' of image '%s' in the archive: %w", name, desc.Digest, err)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return named, nil
+	}
+	return reference.TagNameOnly(named), nil
+}
@@ -0,0 +1,168 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// leaseTypeLabel is the containerd lease label identifying leases created by unregistry and their purpose.
+	leaseTypeLabel = "unregistry.lease"
+	// leaseTypeUpload is the type of lease retaining uploaded content until an image referencing it is created.
+	leaseTypeUpload = "upload"
+	// leaseTypeRead is the type of lease retaining content while it's being served.
+	leaseTypeRead = "read"
+)
+
+// readLeaseExpiration is the expiration of a read lease. The lease is deleted as soon as the content is served so
+// the expiration only limits how long the content is retained if the registry dies while serving it.
+const readLeaseExpiration = 1 * time.Hour
+
+// LeaseContent creates a containerd lease referencing the blob with the given digest so that garbage collection or
+// removal of the image referencing the blob can't delete it while it's being read. The returned release function
+// must be called once the blob has been read to delete the lease.
+func LeaseContent(ctx context.Context, cli *client.Client, dgst digest.Digest) (func(), error) {
+	leasesService := cli.LeasesService()
+	lease, err := leasesService.Create(ctx,
+		leases.WithRandomID(),
+		leases.WithExpiration(readLeaseExpiration),
+		leases.WithLabel(leaseTypeLabel, leaseTypeRead),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create containerd read lease: %w", err)
+	}
+	release := func() {
+		// The request context may be already canceled when the client disconnects.
+		if err := leasesService.Delete(context.WithoutCancel(ctx), lease); err != nil {
+			logrus.WithField("lease", lease.ID).WithError(err).Debug("Failed to delete containerd read lease.")
+		}
+	}
+
+	resource := leases.Resource{ID: dgst.String(), Type: "content"}
+	if err = leasesService.AddResource(ctx, lease, resource); err != nil {
+		release()
+		return nil, fmt.Errorf("add blob '%s' to containerd read lease: %w", dgst, err)
+	}
+
+	return release, nil
+}
+
+// uploadLeasesRetaining returns the IDs of the unregistry upload leases retaining the blob, including the leases of
+// push transactions and of uploads in progress.
+func uploadLeasesRetaining(ctx context.Context, cli *client.Client, dgst digest.Digest) ([]string, error) {
+	leasesService := cli.LeasesService()
+	uploadLeases, err := leasesService.List(ctx, fmt.Sprintf(`labels."%s"==%s`, leaseTypeLabel, leaseTypeUpload))
+	if err != nil {
+		return nil, fmt.Errorf("list containerd upload leases: %w", err)
+	}
+
+	var ids []string
+	for _, lease := range uploadLeases {
+		resources, err := leasesService.ListResources(ctx, lease)
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("list resources of containerd lease '%s': %w", lease.ID, err)
+		}
+		for _, r := range resources {
+			if r.Type == "content" && r.ID == dgst.String() {
+				ids = append(ids, lease.ID)
+				break
+			}
+		}
+	}
+	return ids, nil
+}
+
+// uploadLeases tracks the containerd leases retaining committed uploads when push transactions are disabled. The leases
+// of the uploaded content are deleted once an image referencing it is created as the content is then retained by
+// the garbage collection labels of the image. Otherwise, every successful push would leave its upload leases around
+// until they expire. A nil uploadLeases disables tracking. It's safe for concurrent use.
+type uploadLeases struct {
+	client *client.Client
+	// expiration is the expiration of the upload leases after which they're no longer tracked.
+	expiration time.Duration
+
+	mu sync.Mutex
+	// leases are the upload leases retaining the committed blobs by blob digest.
+	leases map[digest.Digest][]trackedLease
+}
+
+type trackedLease struct {
+	lease     leases.Lease
+	committed time.Time
+}
+
+// newUploadLeases creates a tracker of upload leases that expire after the given expiration.
+func newUploadLeases(cli *client.Client, expiration time.Duration) *uploadLeases {
+	return &uploadLeases{
+		client:     cli,
+		expiration: expiration,
+		leases:     make(map[digest.Digest][]trackedLease),
+	}
+}
+
+// add tracks the upload lease retaining the committed blob with the given digest.
+func (u *uploadLeases) add(dgst digest.Digest, lease leases.Lease) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// Forget the leases of blobs that have never been referenced by an image as they have already expired.
+	now := time.Now()
+	for d, tracked := range u.leases {
+		if now.Sub(tracked[len(tracked)-1].committed) > u.expiration {
+			delete(u.leases, d)
+		}
+	}
+	u.leases[dgst] = append(u.leases[dgst], trackedLease{lease: lease, committed: now})
+}
+
+// release deletes the upload leases of the content of the image with the given target descriptor as it's now
+// retained by the image. Errors are logged but not returned as the leases expire anyway.
+func (u *uploadLeases) release(ctx context.Context, target ocispec.Descriptor) {
+	if u == nil {
+		return
+	}
+	referenced, err := imageContent(ctx, u.client, target)
+	if err != nil {
+		logrus.WithField("digest", target.Digest).WithError(err).
+			Warn("Failed to walk image content to release upload leases.")
+		return
+	}
+
+	u.mu.Lock()
+	var released []trackedLease
+	for _, dgst := range referenced {
+		released = append(released, u.leases[dgst]...)
+		delete(u.leases, dgst)
+	}
+	u.mu.Unlock()
+
+	leasesService := u.client.LeasesService()
+	for _, tracked := range released {
+		err = leasesService.Delete(context.WithoutCancel(ctx), tracked.lease)
+		if err != nil && !errdefs.IsNotFound(err) {
+			logrus.WithField("lease", tracked.lease.ID).WithError(err).
+				Debug("Failed to delete containerd upload lease.")
+		}
+	}
+	if len(released) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"digest": target.Digest,
+			"leases": len(released),
+		}).Debug("Released upload leases of content referenced by image.")
+	}
+}
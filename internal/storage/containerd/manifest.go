@@ -2,27 +2,60 @@ package containerd
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/errdefs"
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/manifest/manifestlist"
 	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
 	"github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/events"
+	"github.com/psviderski/unregistry/internal/tracing"
+	"github.com/psviderski/unregistry/internal/transfer"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // manifestService implements distribution.ManifestService backed by containerd content store.
 type manifestService struct {
-	repo      reference.Named
-	blobStore *blobStore
+	repo reference.Named
+	// canonicalRepo is the repository reference in a normalized form, the way containerd image store expects it,
+	// for example, "docker.io/library/ubuntu"
+	canonicalRepo reference.Named
+	client        *client.Client
+	blobStore     *blobStore
+	// dryRun records pushed manifests instead of storing them if set.
+	dryRun *transfer.DryRun
+	// validateSchema enables validating pushed manifests and image configs against the OCI JSON schemas.
+	validateSchema bool
+	// transactions completes the push of the image created for a manifest pushed by digest. Disabled if nil.
+	transactions *PushTransactions
+	// uploadLeases releases the upload leases of the image created for a manifest pushed by digest. Can be nil.
+	uploadLeases *uploadLeases
+	// events receives an event for each deleted image. No events are published if nil.
+	events *events.Broker
+	// pullThrough fetches the manifests missing in the containerd content store from upstream registries. Can be nil.
+	pullThrough *PullThrough
+	// signatures rejects manifests pushed by tag without a valid signature from the trusted keys. Can be nil.
+	signatures *signatureCheck
+	// deletes enables deleting manifests. Delete returns distribution.ErrUnsupported if disabled.
+	deletes bool
+	// quota caches the size of the repository which pushed and deleted manifests change. Can be nil.
+	quota *repoQuota
 }
 
 // Exists checks if a manifest exists in the blob store by digest.
 func (m *manifestService) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
-	_, err := m.blobStore.Stat(ctx, dgst)
+	// Check the actual content store as manifests don't appear to exist in the dry-run mode.
+	_, err := m.blobStore.stat(ctx, dgst)
 	if errors.Is(err, distribution.ErrBlobUnknown) {
 		return false, nil
 	}
@@ -32,8 +65,15 @@ func (m *manifestService) Exists(ctx context.Context, dgst digest.Digest) (bool,
 // Get retrieves a manifest from the blob store by its digest.
 func (m *manifestService) Get(
 	ctx context.Context, dgst digest.Digest, _ ...distribution.ManifestServiceOption,
-) (distribution.Manifest, error) {
+) (_ distribution.Manifest, err error) {
+	ctx, span := tracing.Start(ctx, "containerd.ManifestService.Get",
+		attribute.String("registry.repository", m.repo.Name()), attribute.String("registry.digest", dgst.String()))
+	defer tracing.End(span, &err)
+
 	blob, err := m.blobStore.Get(ctx, dgst)
+	if errors.Is(err, distribution.ErrBlobUnknown) && m.pullThrough != nil {
+		blob, err = m.fetchUpstream(ctx, dgst, err)
+	}
 	if err != nil {
 		if errors.Is(err, distribution.ErrBlobUnknown) {
 			return nil, distribution.ErrManifestUnknownRevision{
@@ -62,30 +102,273 @@ func (m *manifestService) Get(
 	return manifest, nil
 }
 
+// fetchUpstream fetches the manifest missing in the content store from the upstream registry of the repository,
+// e.g. the manifest of another platform of a multi-platform image fetched by tag, and returns its content. It returns
+// notFoundErr if the manifest can't be fetched.
+func (m *manifestService) fetchUpstream(ctx context.Context, dgst digest.Digest, notFoundErr error) ([]byte, error) {
+	ref, err := reference.WithDigest(m.canonicalRepo, dgst)
+	if err != nil {
+		return nil, notFoundErr
+	}
+	if _, err = m.pullThrough.FetchImage(ctx, m.client, ref); err != nil {
+		if !errors.Is(err, errNotProxied) {
+			logrus.WithField("image", ref.String()).WithError(err).Warn(
+				"Failed to fetch manifest from upstream registry.")
+		}
+		return nil, notFoundErr
+	}
+	return m.blobStore.Get(ctx, dgst)
+}
+
 // Put stores a manifest in the blob store and returns its digest.
+// If the manifest is pushed by digest rather than by tag, a digest-addressed image
+// (e.g. "docker.io/library/ubuntu@sha256:...") is created in the containerd image store to prevent the content from
+// being garbage collected, so it can be pulled by digest later. A new digest-addressed image is marked with
+// digestPushLabel so that it can be removed once an image index referencing it is pushed by tag.
 func (m *manifestService) Put(
-	ctx context.Context, manifest distribution.Manifest, _ ...distribution.ManifestServiceOption,
-) (digest.Digest, error) {
+	ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption,
+) (_ digest.Digest, err error) {
+	ctx, span := tracing.Start(ctx, "containerd.ManifestService.Put",
+		attribute.String("registry.repository", m.repo.Name()))
+	defer tracing.End(span, &err)
+
 	mediaType, payload, err := manifest.Payload()
 	if err != nil {
 		return "", fmt.Errorf("get manifest payload: %w", err)
 	}
+	if err = checkManifestJSON(payload); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"repo":  m.repo.Name(),
+			"error": err,
+		}).Info("Rejected malformed manifest.")
+		return "", errcode.ErrorCodeManifestInvalid.WithDetail(err.Error())
+	}
+	if m.validateSchema {
+		if err = m.validateSchemas(ctx, manifest, mediaType, payload); err != nil {
+			return "", err
+		}
+	}
+	if m.dryRun != nil {
+		return m.recordDryRun(ctx, manifest, mediaType, payload, options)
+	}
+	if err = m.verifySignature(ctx, mediaType, payload, options); err != nil {
+		return "", err
+	}
+	if err = m.quota.check(ctx, m.repo, 0); err != nil {
+		return "", err
+	}
+	// The manifest references new content that counts towards the quota.
+	defer m.quota.invalidate(m.repo)
 
 	desc, err := m.blobStore.Put(ctx, mediaType, payload)
 	if err != nil {
 		return "", fmt.Errorf("put manifest in blob store: %w", err)
 	}
 
+	tagged := slices.ContainsFunc(options, func(opt distribution.ManifestServiceOption) bool {
+		_, ok := opt.(distribution.WithTagOption)
+		return ok
+	})
+	if !tagged {
+		ref, err := reference.WithDigest(m.canonicalRepo, desc.Digest)
+		if err != nil {
+			return "", err
+		}
+		_, getErr := m.client.ImageService().Get(ctx, ref.String())
+		if err = createImage(ctx, m.client, ref, desc, false); err != nil {
+			return "", err
+		}
+		// Images that existed before, e.g. imported or pulled by digest, are not created by this push.
+		if errdefs.IsNotFound(getErr) {
+			markDigestPush(ctx, m.client, ref)
+		}
+		m.transactions.promote(ctx, m.repo.Name(), desc)
+		m.uploadLeases.release(ctx, desc)
+	}
+
 	return desc.Digest, nil
 }
 
-// Delete is not supported to keep things simple.
-func (m *manifestService) Delete(_ context.Context, _ digest.Digest) error {
-	return distribution.ErrUnsupported
+// verifySignature checks that the manifest pushed by tag has a valid signature in the repository if the signature
+// policy is enabled. Manifests pushed by digest, e.g. the platform manifests of an index or the image itself before
+// it's signed, are allowed. They're verified when they're tagged.
+func (m *manifestService) verifySignature(
+	ctx context.Context, mediaType string, payload []byte, options []distribution.ManifestServiceOption,
+) error {
+	tag := ""
+	for _, opt := range options {
+		if t, ok := opt.(distribution.WithTagOption); ok {
+			tag = t.Tag
+		}
+	}
+	if tag == "" {
+		return nil
+	}
+	return m.signatures.check(ctx, m.client, m.repo, m.canonicalRepo, tag, mediaType, payload)
+}
+
+// validateSchemas validates the manifest and its image config if any against the OCI JSON schemas. The config must be
+// pushed before the manifest, except in the dry-run mode where it isn't stored and therefore not validated.
+func (m *manifestService) validateSchemas(
+	ctx context.Context, manifest distribution.Manifest, mediaType string, payload []byte,
+) error {
+	if err := validateManifestSchema(mediaType, payload); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"repo":  m.repo.Name(),
+			"error": err,
+		}).Info("Rejected manifest not conforming to OCI schema.")
+		return err
+	}
+	if images.IsIndexType(mediaType) {
+		return nil
+	}
+
+	for _, ref := range manifest.References() {
+		if !isImageConfigType(ref.MediaType) {
+			continue
+		}
+		blob, err := m.blobStore.Get(ctx, ref.Digest)
+		if err != nil {
+			if errors.Is(err, distribution.ErrBlobUnknown) {
+				if m.dryRun != nil {
+					return nil
+				}
+				return distribution.ErrManifestVerification{distribution.ErrManifestBlobUnknown{Digest: ref.Digest}}
+			}
+			return fmt.Errorf("get image config: %w", err)
+		}
+		if err = validateConfigSchema(blob); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"repo":   m.repo.Name(),
+				"config": ref.Digest,
+				"error":  err,
+			}).Info("Rejected manifest with image config not conforming to OCI schema.")
+			return err
+		}
+	}
+	return nil
+}
+
+// recordDryRun records the manifest and the blobs it references to the dry-run collector noting which of them already
+// exist in the content store. The manifest isn't stored.
+func (m *manifestService) recordDryRun(
+	ctx context.Context,
+	manifest distribution.Manifest,
+	mediaType string,
+	payload []byte,
+	options []distribution.ManifestServiceOption,
+) (digest.Digest, error) {
+	dgst := digest.FromBytes(payload)
+	exists, err := m.Exists(ctx, dgst)
+	if err != nil {
+		return "", err
+	}
+	record := transfer.Manifest{
+		Repo:      m.canonicalRepo.Name(),
+		Reference: dgst.String(),
+		Digest:    dgst,
+		MediaType: mediaType,
+		Size:      int64(len(payload)),
+		Exists:    exists,
+	}
+	for _, opt := range options {
+		if tagOpt, ok := opt.(distribution.WithTagOption); ok {
+			record.Reference = tagOpt.Tag
+		}
+	}
+
+	for _, ref := range manifest.References() {
+		// Manifests referenced by an index are pushed and recorded separately.
+		if images.IsManifestType(ref.MediaType) || images.IsIndexType(ref.MediaType) {
+			continue
+		}
+		_, err = m.blobStore.stat(ctx, ref.Digest)
+		if err != nil && !errors.Is(err, distribution.ErrBlobUnknown) {
+			return "", err
+		}
+		record.Blobs = append(record.Blobs, transfer.Blob{
+			Digest:    ref.Digest,
+			MediaType: ref.MediaType,
+			Size:      ref.Size,
+			Exists:    err == nil,
+		})
+	}
+
+	m.dryRun.Add(record)
+	logrus.WithFields(
+		logrus.Fields{
+			"repo":      m.repo.Name(),
+			"reference": record.Reference,
+			"digest":    dgst,
+		},
+	).Info("Recorded manifest push in dry-run mode.")
+
+	return dgst, nil
+}
+
+// Delete deletes the images in the repository that point to the manifest from the containerd image store, both
+// the digest-addressed and tagged ones. The manifest and the content it references are deleted by the containerd
+// garbage collection unless they're referenced by other images. It returns distribution.ErrBlobUnknown if no image
+// in the repository points to the manifest, or distribution.ErrUnsupported if deletes are disabled.
+func (m *manifestService) Delete(ctx context.Context, dgst digest.Digest) error {
+	if !m.deletes {
+		return distribution.ErrUnsupported
+	}
+	imgs, err := repositoryImages(ctx, m.client, m.canonicalRepo)
+	if err != nil {
+		return err
+	}
+	imgs = slices.DeleteFunc(imgs, func(img repositoryImage) bool {
+		return img.Target.Digest != dgst
+	})
+	if len(imgs) == 0 {
+		return distribution.ErrBlobUnknown
+	}
+	if m.dryRun != nil {
+		logrus.WithFields(logrus.Fields{
+			"repo":   m.repo.Name(),
+			"digest": dgst,
+		}).Debug("Skipped deleting images in dry-run mode.")
+		return nil
+	}
+
+	defer m.quota.invalidate(m.repo)
+	imageService := m.client.ImageService()
+	for _, img := range imgs {
+		if err = imageService.Delete(ctx, img.Name); err != nil && !errdefs.IsNotFound(err) {
+			return fmt.Errorf("delete image '%s' from containerd image store: %w", img.Name, err)
+		}
+		logrus.WithField("image", img.Name).Info("Deleted image from containerd image store.")
+		m.events.Publish(events.Event{
+			Type:      events.TypeDelete,
+			Image:     img.Name,
+			Digest:    img.Target.Digest,
+			MediaType: img.Target.MediaType,
+		})
+	}
+
+	return nil
 }
 
 // unmarshalManifest attempts to unmarshal a manifest in various formats.
 func unmarshalManifest(blob []byte) (distribution.Manifest, error) {
+	if err := checkManifestJSON(blob); err != nil {
+		return nil, distribution.ErrManifestVerification{err}
+	}
+
+	// Try manifest list (OCI index or Docker manifest list) if the blob has manifests. The mediaType field is optional
+	// in an OCI index so the OCI manifest parser would otherwise accept an index as a manifest without layers.
+	var fields struct {
+		Manifests json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(blob, &fields); err == nil && fields.Manifests != nil {
+		var manifestList manifestlist.DeserializedManifestList
+		if err = manifestList.UnmarshalJSON(blob); err != nil {
+			return nil, distribution.ErrManifestVerification{err}
+		}
+		return &manifestList, nil
+	}
+
 	// Try OCI manifest.
 	var ociManifest ocischema.DeserializedManifest
 	if err := ociManifest.UnmarshalJSON(blob); err == nil {
@@ -98,11 +381,5 @@ func unmarshalManifest(blob []byte) (distribution.Manifest, error) {
 		return &schema2Manifest, nil
 	}
 
-	// Try manifest list (OCI index or Docker manifest list).
-	var manifestList manifestlist.DeserializedManifestList
-	if err := manifestList.UnmarshalJSON(blob); err == nil {
-		return &manifestList, nil
-	}
-
 	return nil, distribution.ErrManifestVerification{errors.New("unknown manifest format")}
 }
@@ -0,0 +1,46 @@
+package containerd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func FuzzUnmarshalManifest(f *testing.F) {
+	seeds := []string{
+		`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",` +
+			`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:` +
+			`44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2},"layers":[]}`,
+		`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[]}`,
+		`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json",` +
+			`"config":{"mediaType":"application/vnd.docker.container.image.v1+json","digest":"sha256:` +
+			`44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2},"layers":[]}`,
+		`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json","manifests":[],"manifests":[{}]}`,
+		"{\"schemaVersion\":2,\"annotations\":{\"a\":\"\xff\"}}",
+		`[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]`,
+	}
+	for _, s := range seeds {
+		f.Add([]byte(s))
+	}
+
+	f.Fuzz(func(t *testing.T, blob []byte) {
+		manifest, err := unmarshalManifest(blob)
+		if err != nil {
+			return
+		}
+		if err = checkManifestJSON(blob); err != nil {
+			t.Fatalf("accepted manifest that fails the JSON checks: %v", err)
+		}
+		if !json.Valid(blob) {
+			t.Fatal("accepted invalid JSON")
+		}
+		// The manifest must be stored and served byte for byte.
+		_, payload, err := manifest.Payload()
+		if err != nil {
+			t.Fatalf("get payload: %v", err)
+		}
+		if !bytes.Equal(payload, blob) {
+			t.Fatal("payload differs from the unmarshalled manifest")
+		}
+	})
+}
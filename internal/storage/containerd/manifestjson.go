@@ -0,0 +1,90 @@
+package containerd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+const (
+	// maxManifestSize is the maximum size of a manifest in bytes. It's the same limit the registry app applies to
+	// the body of manifest pushes.
+	maxManifestSize = 4 << 20
+	// maxManifestDepth is the maximum nesting depth of JSON objects and arrays in a manifest. Valid manifests are
+	// nested only a few levels deep.
+	maxManifestDepth = 32
+)
+
+// checkManifestJSON checks that the manifest is a well-formed JSON document that all JSON parsers interpret the same
+// way. Go's JSON decoder silently replaces invalid UTF-8 with the replacement character and keeps the last of
+// duplicate fields while other parsers may keep the first one, so a manifest crafted this way could reference
+// different content for the registry and for the clients verifying it.
+func checkManifestJSON(blob []byte) error {
+	if len(blob) > maxManifestSize {
+		return fmt.Errorf("manifest size %d exceeds the limit of %d bytes", len(blob), maxManifestSize)
+	}
+	if !utf8.Valid(blob) {
+		return errors.New("manifest is not valid UTF-8")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(blob))
+	dec.UseNumber()
+	if err := checkJSONValue(dec, 0); err != nil {
+		return fmt.Errorf("invalid manifest JSON: %w", err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return errors.New("invalid manifest JSON: unexpected data after the top-level value")
+	}
+	return nil
+}
+
+// checkJSONValue reads the next JSON value from the decoder and checks that its objects don't have duplicate fields
+// and it's not nested deeper than maxManifestDepth.
+func checkJSONValue(dec *json.Decoder, depth int) error {
+	if depth > maxManifestDepth {
+		return fmt.Errorf("nested deeper than %d levels", maxManifestDepth)
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return nil
+	}
+
+	switch delim {
+	case '{':
+		fields := make(map[string]struct{})
+		for dec.More() {
+			if tok, err = dec.Token(); err != nil {
+				return err
+			}
+			field, ok := tok.(string)
+			if !ok {
+				return fmt.Errorf("unexpected object key %v", tok)
+			}
+			if _, dup := fields[field]; dup {
+				return fmt.Errorf("duplicate field %q", field)
+			}
+			fields[field] = struct{}{}
+			if err = checkJSONValue(dec, depth+1); err != nil {
+				return err
+			}
+		}
+	case '[':
+		for dec.More() {
+			if err = checkJSONValue(dec, depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unexpected delimiter %v", delim)
+	}
+	// Consume the closing delimiter.
+	_, err = dec.Token()
+	return err
+}
@@ -0,0 +1,99 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// MigrationStatus is the outcome of migrating an image between containerd namespaces.
+type MigrationStatus string
+
+const (
+	// MigrationMigrated means the image has been created or updated in the target namespace.
+	MigrationMigrated MigrationStatus = "migrated"
+	// MigrationExists means the image already exists in the target namespace with the same digest.
+	MigrationExists MigrationStatus = "exists"
+	// MigrationConflict means an image with the same name but a different digest exists in the target namespace.
+	// It's only overwritten when forced.
+	MigrationConflict MigrationStatus = "conflict"
+	// MigrationFailed means the image couldn't be migrated, e.g. because some of its content is missing.
+	MigrationFailed MigrationStatus = "failed"
+)
+
+// MigrationResult describes the migration of an image between containerd namespaces.
+type MigrationResult struct {
+	Image  string          `json:"image"`
+	Digest digest.Digest   `json:"digest"`
+	Status MigrationStatus `json:"status"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// MigrateImages re-registers the images from one containerd namespace in another one making their content available
+// there and setting garbage collection labels on it, as if the images were pushed to the target namespace. Images
+// that already exist in the target namespace with a different digest are only overwritten if force is true.
+// A failure to migrate an image is reported in its result and doesn't stop the migration of other images.
+func MigrateImages(ctx context.Context, cli *client.Client, from, to string, force bool) ([]MigrationResult, error) {
+	if from == to {
+		return nil, fmt.Errorf("source and target namespaces must be different")
+	}
+	fromCtx := namespaces.WithNamespace(ctx, from)
+	toCtx := namespaces.WithNamespace(ctx, to)
+
+	imageService := cli.ImageService()
+	imgs, err := imageService.List(fromCtx)
+	if err != nil {
+		return nil, fmt.Errorf("list images in containerd namespace '%s': %w", from, err)
+	}
+
+	results := make([]MigrationResult, 0, len(imgs))
+	for _, img := range imgs {
+		result := MigrationResult{Image: img.Name, Digest: img.Target.Digest}
+
+		existing, err := imageService.Get(toCtx, img.Name)
+		switch {
+		case err == nil && existing.Target.Digest == img.Target.Digest:
+			result.Status = MigrationExists
+		case err == nil && !force:
+			result.Status = MigrationConflict
+			result.Error = fmt.Sprintf("image exists with different digest '%s'", existing.Target.Digest)
+		case err != nil && !errdefs.IsNotFound(err):
+			result.Status = MigrationFailed
+			result.Error = err.Error()
+		default:
+			if err = migrateImage(fromCtx, cli, img.Name, img.Target, to); err != nil {
+				result.Status = MigrationFailed
+				result.Error = err.Error()
+			} else {
+				result.Status = MigrationMigrated
+			}
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// migrateImage creates the image in the target namespace after making its content available there.
+func migrateImage(
+	ctx context.Context, cli *client.Client, name string, target ocispec.Descriptor, namespace string,
+) error {
+	ref, err := reference.Parse(name)
+	if err != nil {
+		return fmt.Errorf("parse image name: %w", err)
+	}
+
+	nsCtx, release, err := copyContentToNamespace(ctx, cli, target, namespace, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return createImage(nsCtx, cli, ref, target, true)
+}
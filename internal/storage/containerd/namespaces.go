@@ -0,0 +1,130 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// ImageNamespaces returns the containerd namespaces other than the client's default one that contain an image with
+// the given normalized reference, e.g. "docker.io/library/ubuntu:latest".
+func ImageNamespaces(ctx context.Context, cli *client.Client, ref string) ([]string, error) {
+	all, err := cli.NamespaceService().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list containerd namespaces: %w", err)
+	}
+
+	var found []string
+	for _, ns := range all {
+		if ns == cli.DefaultNamespace() {
+			continue
+		}
+		_, err = cli.ImageService().Get(namespaces.WithNamespace(ctx, ns), ref)
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("get image '%s' from containerd namespace '%s': %w", ref, ns, err)
+		}
+		found = append(found, ns)
+	}
+
+	return found, nil
+}
+
+// NamespaceImageCounts returns the number of images in each containerd namespace.
+func NamespaceImageCounts(ctx context.Context, cli *client.Client) (map[string]int, error) {
+	all, err := cli.NamespaceService().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list containerd namespaces: %w", err)
+	}
+
+	counts := make(map[string]int, len(all))
+	for _, ns := range all {
+		imgs, err := cli.ImageService().List(namespaces.WithNamespace(ctx, ns))
+		if err != nil {
+			return nil, fmt.Errorf("list images in containerd namespace '%s': %w", ns, err)
+		}
+		counts[ns] = len(imgs)
+	}
+	return counts, nil
+}
+
+// copyContentToNamespace makes the content of the image with the given target descriptor available in the containerd
+// namespace. Content missing in the current namespace, e.g. manifests for other platforms of a pulled multi-platform
+// image, is skipped if partial is true, otherwise it fails the copy. Blobs are shared with the namespace without copying the data if the containerd content sharing
+// policy allows it, otherwise they are copied. It returns a context for the namespace with a lease retaining
+// the content until an image referencing it is created and a function to release the lease that must be called then.
+func copyContentToNamespace(
+	ctx context.Context, cli *client.Client, target ocispec.Descriptor, namespace string, partial bool,
+) (context.Context, func(), error) {
+	nsCtx := namespaces.WithNamespace(ctx, namespace)
+	leasesService := cli.LeasesService()
+	lease, err := leasesService.Create(nsCtx,
+		leases.WithRandomID(),
+		leases.WithExpiration(defaultLeaseExpiration),
+		leases.WithLabel(leaseTypeLabel, leaseTypeUpload),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create containerd lease in namespace '%s': %w", namespace, err)
+	}
+	release := func() {
+		if err := leasesService.Delete(context.WithoutCancel(nsCtx), lease); err != nil {
+			logrus.WithField("lease", lease.ID).WithError(err).Debug("Failed to delete containerd lease.")
+		}
+	}
+	nsCtx = leases.WithLease(nsCtx, lease.ID)
+
+	contentStore := cli.ContentStore()
+	childrenHandler := images.ChildrenHandler(contentStore)
+	if partial {
+		childrenHandler = presentChildrenHandler(contentStore)
+	}
+	copyHandler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if err := copyBlob(ctx, nsCtx, contentStore, desc); err != nil {
+			return nil, err
+		}
+		return childrenHandler(ctx, desc)
+	})
+	if err = images.Walk(ctx, copyHandler, target); err != nil {
+		release()
+		return nil, nil, fmt.Errorf("copy image content to containerd namespace '%s': %w", namespace, err)
+	}
+
+	return nsCtx, release, nil
+}
+
+// copyBlob copies the blob from the namespace in srcCtx to the namespace in dstCtx unless it already exists there.
+func copyBlob(srcCtx, dstCtx context.Context, contentStore content.Store, desc ocispec.Descriptor) error {
+	// With the shared content policy (default), opening a writer for a blob that exists in another namespace makes
+	// it available in the destination namespace without copying and returns ErrAlreadyExists.
+	writer, err := content.OpenWriter(dstCtx, contentStore,
+		content.WithRef("copy-"+desc.Digest.String()), content.WithDescriptor(desc))
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			return nil
+		}
+		return fmt.Errorf("create containerd content writer: %w", err)
+	}
+	defer writer.Close()
+
+	ra, err := contentStore.ReaderAt(srcCtx, desc)
+	if err != nil {
+		return fmt.Errorf("open blob '%s': %w", desc.Digest, err)
+	}
+	defer ra.Close()
+
+	if err = content.Copy(dstCtx, writer, io.NewSectionReader(ra, 0, ra.Size()), desc.Size, desc.Digest); err != nil {
+		return fmt.Errorf("copy blob '%s': %w", desc.Digest, err)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package containerd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/containerd/v2/core/remotes/docker"
+	"github.com/containerd/platforms"
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// proxyFetchedLabel is the containerd image label with the time the image was fetched from an upstream registry
+	// by the pull-through proxy.
+	proxyFetchedLabel = "unregistry.proxy.fetched"
+	// leaseTypeProxy is the type of lease retaining blobs fetched by the pull-through proxy that aren't referenced by
+	// any image.
+	leaseTypeProxy = "proxy"
+	// proxyBlobLeaseExpiration is how long a blob fetched on its own by the pull-through proxy is retained unless an
+	// image referencing it is fetched or pushed meanwhile.
+	proxyBlobLeaseExpiration = 24 * time.Hour
+	// proxyFetchTimeout limits how long fetching an image or blob from an upstream registry may take.
+	proxyFetchTimeout = 30 * time.Minute
+)
+
+// errNotProxied is returned by PullThrough when the repository isn't served by any upstream registry.
+var errNotProxied = errors.New("repository isn't served by an upstream registry")
+
+// PullThrough fetches the images and blobs missing in the containerd store from upstream registries, e.g. Docker Hub
+// or GHCR, so that unregistry acts as a node-local pull-through cache. The upstream of a repository is selected by
+// the registry domain of its normalized name, e.g. "docker.io" for "nginx" or "ghcr.io" for "ghcr.io/org/app".
+// A nil PullThrough doesn't fetch anything.
+type PullThrough struct {
+	// upstreams are the upstream registries by their domain.
+	upstreams map[string]*upstream
+	// ttl is how long a fetched tag is served from the cache before it's resolved against the upstream again.
+	// Tags are never revalidated if zero.
+	ttl time.Duration
+	// platform is the platform of the multi-platform images to fetch the manifests and content of.
+	platform platforms.MatchComparer
+	// fetches deduplicates concurrent fetches of the same image or blob.
+	fetches singleflight.Group
+}
+
+// upstream is an upstream registry the pull-through proxy fetches from.
+type upstream struct {
+	url      string
+	resolver remotes.Resolver
+}
+
+// NewPullThrough creates a pull-through proxy fetching from the upstream registry URLs, e.g. "https://docker.io" or
+// "https://ghcr.io". Credentials for an upstream can be specified in the URL user info. Only the platform of
+// multi-platform images is fetched by tag, the host platform if nil. It returns nil if there are no upstreams.
+func NewPullThrough(
+	upstreamURLs []string, ttl time.Duration, platform platforms.MatchComparer,
+) (*PullThrough, error) {
+	if len(upstreamURLs) == 0 {
+		return nil, nil
+	}
+	if platform == nil {
+		platform = platforms.Default()
+	}
+
+	p := &PullThrough{upstreams: make(map[string]*upstream), ttl: ttl, platform: platform}
+	for _, rawURL := range upstreamURLs {
+		domain, up, err := parseUpstream(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.upstreams[domain]; ok {
+			return nil, fmt.Errorf("duplicate upstream registry '%s'", domain)
+		}
+		p.upstreams[domain] = up
+	}
+	return p, nil
+}
+
+// parseUpstream parses the upstream registry URL and returns the registry domain of the image references it serves
+// and the upstream with a resolver configured for it.
+func parseUpstream(rawURL string) (string, *upstream, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("parse upstream registry URL '%s': %w", rawURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+		return "", nil, fmt.Errorf("invalid upstream registry URL '%s': expected http(s)://host[:port]", rawURL)
+	}
+
+	var opts []docker.RegistryOpt
+	if u.Scheme == "http" {
+		opts = append(opts, docker.WithPlainHTTP(docker.MatchAllHosts))
+	}
+	if u.User != nil {
+		username := u.User.Username()
+		password, _ := u.User.Password()
+		opts = append(opts, docker.WithAuthorizer(docker.NewDockerAuthorizer(
+			docker.WithAuthCreds(func(string) (string, string, error) {
+				return username, password, nil
+			}),
+		)))
+	}
+	// Strip the credentials so that they don't leak to logs.
+	u.User = nil
+
+	// Docker Hub images are referenced as "docker.io" while the registry API is served by "registry-1.docker.io".
+	domain := u.Host
+	if domain == "registry-1.docker.io" || domain == "index.docker.io" {
+		domain = "docker.io"
+	}
+	return domain, &upstream{
+		url:      u.String(),
+		resolver: docker.NewResolver(docker.ResolverOptions{Hosts: docker.ConfigureDefaultRegistries(opts...)}),
+	}, nil
+}
+
+// upstream returns the upstream registry serving the repository or nil if there is none.
+func (p *PullThrough) upstream(repo reference.Named) *upstream {
+	if p == nil {
+		return nil
+	}
+	return p.upstreams[reference.Domain(repo)]
+}
+
+// Stale returns true if the image was fetched from an upstream registry longer than the TTL ago and should be
+// resolved against the upstream again. Images pushed to the registry are never stale.
+func (p *PullThrough) Stale(img images.Image) bool {
+	if p == nil || p.ttl <= 0 {
+		return false
+	}
+	fetched, err := time.Parse(time.RFC3339, img.Labels[proxyFetchedLabel])
+	if err != nil {
+		return false
+	}
+	return time.Since(fetched) > p.ttl
+}
+
+// FetchImage fetches the image with the tagged or canonical reference from the upstream registry of its repository
+// and creates or updates the image in the containerd image store. Only the manifests of a multi-platform image
+// matching the configured platform are fetched with their content, other platforms are fetched when pulled by digest.
+// It returns errNotProxied if the repository isn't served by any upstream.
+func (p *PullThrough) FetchImage(
+	ctx context.Context, cli *client.Client, ref reference.Named,
+) (distribution.Descriptor, error) {
+	up := p.upstream(ref)
+	if up == nil {
+		return distribution.Descriptor{}, errNotProxied
+	}
+
+	v, err, _ := p.fetches.Do(fmt.Sprintf("%p/%s", cli, ref.String()), func() (any, error) {
+		// Complete the fetch even if the client that triggered it disconnects as other clients may wait for it.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), proxyFetchTimeout)
+		defer cancel()
+
+		start := time.Now()
+		desc, err := FetchImage(ctx, cli, up.resolver, ref.String(), ref, p.platform)
+		if err != nil {
+			return nil, err
+		}
+		img := images.Image{
+			Name:   ref.String(),
+			Labels: map[string]string{proxyFetchedLabel: time.Now().UTC().Format(time.RFC3339)},
+		}
+		if _, err = cli.ImageService().Update(ctx, img, "labels."+proxyFetchedLabel); err != nil {
+			return nil, fmt.Errorf("label image '%s' fetched from upstream registry: %w", ref.String(), err)
+		}
+		logrus.WithFields(logrus.Fields{
+			"image":    ref.String(),
+			"upstream": up.url,
+			"digest":   desc.Digest,
+			"duration": time.Since(start).Round(time.Millisecond),
+		}).Info("Fetched image from upstream registry.")
+		return desc, nil
+	})
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return v.(distribution.Descriptor), nil
+}
+
+// FetchBlob fetches the blob from the upstream registry of the repository into the containerd content store. The blob
+// is retained by a lease for proxyBlobLeaseExpiration as it isn't referenced by any image. It returns errNotProxied if
+// the repository isn't served by any upstream.
+func (p *PullThrough) FetchBlob(
+	ctx context.Context, cli *client.Client, repo reference.Named, dgst digest.Digest,
+) error {
+	// The repository name of a blob store isn't normalized, e.g. "ubuntu" instead of "docker.io/library/ubuntu".
+	repo, err := reference.ParseNormalizedNamed(repo.Name())
+	if err != nil {
+		return errNotProxied
+	}
+	up := p.upstream(repo)
+	if up == nil {
+		return errNotProxied
+	}
+
+	_, err, _ = p.fetches.Do(fmt.Sprintf("%p/%s@%s", cli, repo.Name(), dgst), func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), proxyFetchTimeout)
+		defer cancel()
+
+		fetcher, err := up.resolver.Fetcher(ctx, repo.Name())
+		if err != nil {
+			return nil, fmt.Errorf("create fetcher for upstream repository '%s': %w", repo.Name(), err)
+		}
+		desc := ocispec.Descriptor{MediaType: "application/octet-stream", Digest: dgst}
+		rc, err := fetcher.Fetch(ctx, desc)
+		if err != nil {
+			return nil, fmt.Errorf("fetch blob '%s' from upstream repository '%s': %w", dgst, repo.Name(), err)
+		}
+		defer rc.Close()
+
+		lease, err := cli.LeasesService().Create(ctx,
+			leases.WithRandomID(),
+			leases.WithExpiration(proxyBlobLeaseExpiration),
+			leases.WithLabel(leaseTypeLabel, leaseTypeProxy),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("create containerd lease: %w", err)
+		}
+		ctx = leases.WithLease(ctx, lease.ID)
+		if err = content.WriteBlob(ctx, cli.ContentStore(), "proxy-"+dgst.String(), rc, desc); err != nil {
+			return nil, fmt.Errorf("write blob '%s' to containerd content store: %w", dgst, err)
+		}
+		logrus.WithFields(logrus.Fields{
+			"repo":     repo.Name(),
+			"digest":   dgst,
+			"upstream": up.url,
+		}).Debug("Fetched blob from upstream registry.")
+		return nil, nil
+	})
+	return err
+}
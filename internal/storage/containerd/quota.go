@@ -0,0 +1,141 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/reference"
+	"github.com/psviderski/unregistry/internal/transfer"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// repoSizeCacheTTL is how long a computed repository size is used to enforce the quota. Sizes are recomputed
+	// sooner after manifests are pushed to or deleted from the repository through the registry.
+	repoSizeCacheTTL = time.Minute
+	// maxCachedRepoSizes is the maximum number of repository sizes kept in the cache.
+	maxCachedRepoSizes = 1024
+)
+
+// errorCodeQuotaExceeded is returned when pushing to a repository that has reached its storage quota.
+var errorCodeQuotaExceeded = errcode.Register("unregistry", errcode.ErrorDescriptor{
+	Value:   "QUOTA_EXCEEDED",
+	Message: "repository exceeds its storage quota",
+	Description: "The push was rejected as the images in the repository take up its storage quota. Delete images " +
+		"from the repository to push again.",
+	HTTPStatusCode: http.StatusInsufficientStorage,
+})
+
+// QuotaChecker is implemented by the repositories that enforce a storage quota.
+type QuotaChecker interface {
+	// CheckQuota returns an error if pushing length more bytes to the repository would exceed its quota.
+	CheckQuota(ctx context.Context, length int64) error
+}
+
+// repoQuota enforces the maximum size of the images in each repository. The size of a repository is the size of
+// the content of its images present in the containerd content store. Sizes are cached for repoSizeCacheTTL.
+type repoQuota struct {
+	client *client.Client
+	limit  int64
+	mu     sync.Mutex
+	// sizes are the cached sizes by the normalized repository name.
+	sizes map[string]repoSize
+}
+
+type repoSize struct {
+	size     int64
+	computed time.Time
+}
+
+// newRepoQuota returns a quota of limit bytes for each repository. It returns nil if limit isn't positive.
+func newRepoQuota(cli *client.Client, limit int64) *repoQuota {
+	if limit <= 0 {
+		return nil
+	}
+	return &repoQuota{client: cli, limit: limit, sizes: make(map[string]repoSize)}
+}
+
+// check returns errorCodeQuotaExceeded if the repository has reached the quota or would exceed it with length more
+// bytes. A nil quota allows everything. The push is allowed if the size of the repository can't be computed.
+func (q *repoQuota) check(ctx context.Context, repo reference.Named, length int64) error {
+	if q == nil {
+		return nil
+	}
+	// Shouldn't return an error as repo is a valid reference.
+	repo, _ = reference.ParseNormalizedNamed(repo.Name())
+	size, err := q.size(ctx, repo)
+	if err != nil {
+		logrus.WithField("repo", repo.Name()).WithError(err).Warn("Failed to compute repository size for quota.")
+		return nil
+	}
+	if size < q.limit && size+length <= q.limit {
+		return nil
+	}
+	logrus.WithFields(logrus.Fields{
+		"repo":  repo.Name(),
+		"size":  transfer.HumanSize(size),
+		"quota": transfer.HumanSize(q.limit),
+	}).Warn("Rejected push to repository over quota.")
+	return errorCodeQuotaExceeded.WithMessage(fmt.Sprintf(
+		"repository '%s' exceeds its storage quota: %s stored, %s allowed",
+		reference.FamiliarName(repo), transfer.HumanSize(size), transfer.HumanSize(q.limit)))
+}
+
+// size returns the cached size of the normalized repository or computes it if it's missing or expired.
+func (q *repoQuota) size(ctx context.Context, repo reference.Named) (int64, error) {
+	q.mu.Lock()
+	cached, ok := q.sizes[repo.Name()]
+	q.mu.Unlock()
+	if ok && time.Since(cached.computed) < repoSizeCacheTTL {
+		return cached.size, nil
+	}
+
+	size, err := RepositorySize(ctx, q.client, repo)
+	if err != nil {
+		return 0, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.sizes) >= maxCachedRepoSizes {
+		q.evict()
+	}
+	q.sizes[repo.Name()] = repoSize{size: size, computed: time.Now()}
+	return size, nil
+}
+
+// evict deletes the expired sizes from the cache, or the oldest one if none has expired. The mutex must be held.
+func (q *repoQuota) evict() {
+	var (
+		oldest     string
+		oldestTime time.Time
+	)
+	for name, s := range q.sizes {
+		if time.Since(s.computed) >= repoSizeCacheTTL {
+			delete(q.sizes, name)
+			continue
+		}
+		if oldest == "" || s.computed.Before(oldestTime) {
+			oldest, oldestTime = name, s.computed
+		}
+	}
+	if len(q.sizes) >= maxCachedRepoSizes {
+		delete(q.sizes, oldest)
+	}
+}
+
+// invalidate drops the cached size of the repository so that it's recomputed on the next push. A nil quota does
+// nothing.
+func (q *repoQuota) invalidate(repo reference.Named) {
+	if q == nil {
+		return
+	}
+	repo, _ = reference.ParseNormalizedNamed(repo.Name())
+	q.mu.Lock()
+	delete(q.sizes, repo.Name())
+	q.mu.Unlock()
+}
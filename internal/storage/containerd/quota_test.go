@@ -0,0 +1,65 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/distribution/reference"
+)
+
+func TestRepoQuotaEvict(t *testing.T) {
+	q := newRepoQuota(nil, 1)
+	now := time.Now()
+	for i := range maxCachedRepoSizes {
+		q.sizes[fmt.Sprintf("docker.io/library/repo%d", i)] = repoSize{computed: now.Add(time.Duration(i) * time.Millisecond)}
+	}
+
+	q.evict()
+	if len(q.sizes) != maxCachedRepoSizes-1 {
+		t.Fatalf("expected one size to be evicted, got %d sizes", len(q.sizes))
+	}
+	if _, ok := q.sizes["docker.io/library/repo0"]; ok {
+		t.Fatal("expected the oldest size to be evicted")
+	}
+
+	q.sizes["docker.io/library/repo1"] = repoSize{computed: now.Add(-repoSizeCacheTTL)}
+	q.sizes["docker.io/library/repo2"] = repoSize{computed: now.Add(-2 * repoSizeCacheTTL)}
+	q.evict()
+	if len(q.sizes) != maxCachedRepoSizes-3 {
+		t.Fatalf("expected expired sizes to be evicted, got %d sizes", len(q.sizes))
+	}
+	if _, ok := q.sizes["docker.io/library/repo3"]; !ok {
+		t.Fatal("expected the sizes that haven't expired to be kept")
+	}
+}
+
+func TestRepoQuotaInvalidate(t *testing.T) {
+	q := newRepoQuota(nil, 1)
+	q.sizes["docker.io/library/ubuntu"] = repoSize{size: 1, computed: time.Now()}
+
+	repo, err := reference.WithName("ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.invalidate(repo)
+	if len(q.sizes) != 0 {
+		t.Fatalf("expected the size of the normalized repository to be invalidated, got %v", q.sizes)
+	}
+}
+
+func TestRepoQuotaDisabled(t *testing.T) {
+	q := newRepoQuota(nil, 0)
+	if q != nil {
+		t.Fatal("expected no quota for a zero limit")
+	}
+	repo, err := reference.WithName("ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = q.check(context.Background(), repo, 1<<40); err != nil {
+		t.Fatalf("expected a nil quota to allow pushes, got %v", err)
+	}
+	q.invalidate(repo)
+}
@@ -0,0 +1,120 @@
+package containerd
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// reconnectMinDelay is the delay before the first attempt to re-establish a broken connection to containerd.
+	reconnectMinDelay = 500 * time.Millisecond
+	// reconnectMaxDelay is the maximum delay between the attempts to re-establish a broken connection to containerd.
+	reconnectMaxDelay = 30 * time.Second
+	// unavailableRetryWait is how long a call that failed because containerd is unavailable waits for the connection
+	// to recover before it's retried.
+	unavailableRetryWait = 5 * time.Second
+)
+
+// KeepConnected watches the connection of the client to containerd and re-establishes it with exponential backoff
+// when it breaks, e.g. when containerd restarts and recreates its socket, so that the registry recovers without
+// a restart. It returns when ctx is canceled.
+func KeepConnected(ctx context.Context, cli *client.Client) {
+	var (
+		delay = reconnectMinDelay
+		lost  bool
+	)
+	for {
+		conn, ok := cli.Conn().(*grpc.ClientConn)
+		if !ok {
+			return
+		}
+
+		state := conn.GetState()
+		if state != connectivity.TransientFailure && state != connectivity.Shutdown {
+			if state == connectivity.Ready && lost {
+				logrus.Info("Reconnected to containerd.")
+				lost = false
+				delay = reconnectMinDelay
+			}
+			// Returns false when ctx is canceled.
+			if !conn.WaitForStateChange(ctx, state) {
+				return
+			}
+			continue
+		}
+
+		if !lost {
+			logrus.WithField("state", state).Warn("Lost connection to containerd, reconnecting.")
+			lost = true
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+		// The connection may have recovered on its own while waiting.
+		if state = conn.GetState(); state != connectivity.TransientFailure && state != connectivity.Shutdown {
+			continue
+		}
+		// A new connection dials the socket again rather than waiting for the backoff of the broken one.
+		if err := cli.Reconnect(); err != nil {
+			logrus.WithError(err).Debug("Failed to reconnect to containerd.")
+			continue
+		}
+		if conn, ok = cli.Conn().(*grpc.ClientConn); ok {
+			conn.Connect()
+		}
+	}
+}
+
+// readOnlyMethods are the names of the containerd gRPC methods without side effects that are safe to retry.
+var readOnlyMethods = map[string]bool{
+	"Get":           true,
+	"Info":          true,
+	"List":          true,
+	"ListResources": true,
+	"ListStatuses":  true,
+	"Plugins":       true,
+	"Server":        true,
+	"Stat":          true,
+	"Status":        true,
+	"Usage":         true,
+	"Version":       true,
+}
+
+// isReadOnlyMethod reports whether the full gRPC method name, e.g. "/containerd.services.images.v1.Images/Get", is
+// a read-only method of a containerd service.
+func isReadOnlyMethod(method string) bool {
+	return readOnlyMethods[path.Base(method)]
+}
+
+// retryUnavailable is a gRPC unary client interceptor that retries a read-only call failed because containerd is
+// unavailable once the connection recovers, waiting for it up to unavailableRetryWait. The call is retried only once.
+// Other calls aren't retried as they may have been applied by containerd before the connection broke.
+func retryUnavailable(
+	ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if status.Code(err) != codes.Unavailable || ctx.Err() != nil || !isReadOnlyMethod(method) {
+		return err
+	}
+
+	retryCtx, cancel := context.WithTimeout(ctx, unavailableRetryWait)
+	defer cancel()
+	retryErr := invoker(retryCtx, method, req, reply, cc, append(opts, grpc.WaitForReady(true))...)
+	// Report the original error if the connection hasn't recovered in time or was replaced by KeepConnected.
+	if code := status.Code(retryErr); (code == codes.DeadlineExceeded || code == codes.Canceled) && ctx.Err() == nil {
+		return err
+	}
+	return retryErr
+}
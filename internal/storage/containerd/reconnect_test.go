@@ -0,0 +1,83 @@
+package containerd
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryUnavailable(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		errs      []error
+		wantCalls int
+		wantCode  codes.Code
+	}{
+		{
+			name:      "read-only call recovers",
+			method:    "/containerd.services.images.v1.Images/Get",
+			errs:      []error{status.Error(codes.Unavailable, "connection refused"), nil},
+			wantCalls: 2,
+			wantCode:  codes.OK,
+		},
+		{
+			name:      "read-only call fails again",
+			method:    "/containerd.services.content.v1.Content/Info",
+			errs:      []error{status.Error(codes.Unavailable, "first"), status.Error(codes.Unavailable, "second")},
+			wantCalls: 2,
+			wantCode:  codes.Unavailable,
+		},
+		{
+			name:      "read-only call not found",
+			method:    "/containerd.services.images.v1.Images/Get",
+			errs:      []error{status.Error(codes.NotFound, "image not found")},
+			wantCalls: 1,
+			wantCode:  codes.NotFound,
+		},
+		{
+			name:      "mutating call",
+			method:    "/containerd.services.images.v1.Images/Create",
+			errs:      []error{status.Error(codes.Unavailable, "connection reset")},
+			wantCalls: 1,
+			wantCode:  codes.Unavailable,
+		},
+		{
+			name:      "content write",
+			method:    "/containerd.services.content.v1.Content/Delete",
+			errs:      []error{status.Error(codes.Unavailable, "connection reset")},
+			wantCalls: 1,
+			wantCode:  codes.Unavailable,
+		},
+		{
+			name:      "retry times out",
+			method:    "/containerd.services.leases.v1.Leases/List",
+			errs:      []error{status.Error(codes.Unavailable, "connection refused"), status.Error(codes.DeadlineExceeded, "")},
+			wantCalls: 2,
+			wantCode:  codes.Unavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			invoker := func(_ context.Context, method string, _, _ any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+				if method != tt.method {
+					t.Fatalf("expected method %s, got %s", tt.method, method)
+				}
+				err := tt.errs[calls]
+				calls++
+				return err
+			}
+			err := retryUnavailable(context.Background(), tt.method, nil, nil, nil, invoker)
+			if calls != tt.wantCalls {
+				t.Fatalf("expected %d calls, got %d", tt.wantCalls, calls)
+			}
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("expected code %s, got %v", tt.wantCode, err)
+			}
+		})
+	}
+}
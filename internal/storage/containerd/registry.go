@@ -2,34 +2,154 @@ package containerd
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/platforms"
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
 	"github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/events"
+	"github.com/psviderski/unregistry/internal/metadata"
+	"github.com/psviderski/unregistry/internal/progress"
+	"github.com/psviderski/unregistry/internal/transfer"
+	"github.com/psviderski/unregistry/pkg/storage"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
 )
 
 // registry implements distribution.Namespace backed by containerd image store.
 type registry struct {
 	client *client.Client
+	// progress is an optional tracker for reporting the progress of blob uploads.
+	progress *progress.Tracker
+	// metadata stores registry-specific state that containerd can't hold well.
+	metadata metadata.Store
+	// copyLimit limits the number of concurrent blob copy and verification operations. No limit if nil.
+	copyLimit *semaphore.Weighted
+	// dryRun records pushed manifests instead of storing them if set.
+	dryRun *transfer.DryRun
+	// reporter creates transfer reports for pushed images. No reports are created if nil.
+	reporter *transfer.Reporter
+	// events receives events about pushed images. No events are published if nil.
+	events *events.Broker
+	// verifier verifies blob digests and collects verification statistics. Can be nil.
+	verifier *transfer.Verifier
+	// namespaceAnnotation is the manifest annotation which value is the containerd namespace to tag pushed images in
+	// instead of the client's default one. Routing is disabled if empty.
+	namespaceAnnotation string
+	// repoFilter restricts which repositories can be accessed. All repositories are allowed if nil.
+	repoFilter *RepositoryFilter
+	// transactions groups the content uploaded by pushes to clean up after failed ones. Disabled if nil.
+	transactions *PushTransactions
+	// validateSchema enables validating pushed manifests and image configs against the OCI JSON schemas.
+	validateSchema bool
+	// pullRewrites rewrites the repository names of pulled tags not found under the requested name. Can be nil.
+	pullRewrites *PullRewrites
+	// pullThrough fetches the images and blobs missing in the containerd store from upstream registries. Can be nil.
+	pullThrough *PullThrough
+	// signatures rejects tagging images without a valid signature from the trusted keys. Can be nil.
+	signatures *SignaturePolicy
+	// leaseExpiration is the expiration of the containerd leases retaining the content of uploads.
+	leaseExpiration time.Duration
+	// uploadLeases releases the upload leases of tagged images if push transactions are disabled. Can be nil.
+	uploadLeases *uploadLeases
+	// unpackSnapshotter is the snapshotter to unpack pushed images into. Unpacking is disabled if empty.
+	unpackSnapshotter string
+	// unpackPlatform is the platform of the multi-platform images to unpack.
+	unpackPlatform platforms.MatchComparer
+	// deletes enables deleting manifests, tags, and blobs through the registry API.
+	deletes bool
+	// quota limits the size of the images in each repository. No limit if nil.
+	quota *repoQuota
 }
 
 // Ensure registry implements distribution.registry.
-var _ distribution.Namespace = &registry{}
+var (
+	_ distribution.Namespace = &registry{}
+	_ storage.HealthChecker  = &registry{}
+)
 
 // Scope returns the global scope for this registry.
 func (r *registry) Scope() distribution.Scope {
 	return distribution.GlobalScope
 }
 
-// Repository returns an instance of repository for the given name.
+// CheckHealth checks that containerd responds to API calls.
+func (r *registry) CheckHealth(ctx context.Context) error {
+	if _, err := r.client.NamespaceService().List(ctx); err != nil {
+		return fmt.Errorf("list containerd namespaces: %w", err)
+	}
+	return nil
+}
+
+// Repository returns an instance of repository for the given name. Access to the repositories not allowed by
+// the repository filter is denied before touching the storage.
 func (r *registry) Repository(_ context.Context, name reference.Named) (distribution.Repository, error) {
-	return newRepository(r.client, name), nil
+	// Match the familiar name so that aliases like "docker.io/library/ubuntu" can't bypass the patterns for "ubuntu".
+	// Shouldn't return an error as name is a valid reference.
+	canonicalName, _ := reference.ParseNormalizedNamed(name.String())
+	if !r.repoFilter.Allowed(reference.FamiliarName(canonicalName)) {
+		logrus.WithField("repo", name.Name()).Debug("Denied access to repository not allowed by configuration.")
+		return nil, errcode.ErrorCodeDenied.WithMessage(
+			fmt.Sprintf("access to repository '%s' is not allowed by the registry configuration", name.Name()))
+	}
+	return newRepository(r, name), nil
+}
+
+// Repositories fills repos with the sorted names of repositories that have images in the containerd image store
+// and come after last in the lexical order. It returns the number of filled names and io.EOF if there are no more
+// repositories after them.
+func (r *registry) Repositories(ctx context.Context, repos []string, last string) (int, error) {
+	names, err := repositoryNames(ctx, r.client)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, name := range names {
+		if name <= last || !r.repoFilter.Allowed(name) {
+			continue
+		}
+		if n == len(repos) {
+			return n, nil
+		}
+		repos[n] = name
+		n++
+	}
+	return n, io.EOF
 }
 
-// Repositories should return a list of repositories in the registry but it's not supported for simplicity.
-func (r *registry) Repositories(_ context.Context, _ []string, _ string) (int, error) {
-	return 0, distribution.ErrUnsupported
+// repositoryNames returns the sorted unique repository names of the images in the containerd image store in the form
+// clients use to pull them, e.g. "ubuntu" for "docker.io/library/ubuntu:latest".
+func repositoryNames(ctx context.Context, cli *client.Client) ([]string, error) {
+	imgs, err := cli.ImageService().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list images in containerd image store: %w", err)
+	}
+
+	unique := make(map[string]struct{})
+	for _, img := range imgs {
+		// Docker keeps untagged images as "moby-dangling@<digest>" to prevent them from being garbage collected.
+		if strings.HasPrefix(img.Name, "moby-dangling@") {
+			continue
+		}
+		named, err := reference.ParseNormalizedNamed(img.Name)
+		if err != nil {
+			continue
+		}
+		unique[reference.FamiliarName(named)] = struct{}{}
+	}
+
+	names := slices.Collect(maps.Keys(unique))
+	slices.Sort(names)
+	return names, nil
 }
 
 // Blobs returns a stub implementation of distribution.BlobEnumerator that doesn't support enumeration.
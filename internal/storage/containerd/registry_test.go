@@ -0,0 +1,120 @@
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/registrytest"
+	"github.com/psviderski/unregistry/pkg/storage"
+)
+
+// newTestApp returns a distribution registry app serving the registry API from the containerd backend.
+func newTestApp(t *testing.T, cli *client.Client) http.Handler {
+	t.Helper()
+	backend, err := newRegistry(context.Background(), storage.Options{"client": cli})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := registrytest.AppConfig()
+	config.Middleware = map[string][]configuration.Middleware{
+		"registry": {storage.Middleware(backend)},
+	}
+	return handlers.NewApp(context.Background(), config)
+}
+
+func TestRegistryRepositories(t *testing.T) {
+	cli := registrytest.NewClient(t,
+		"docker.io/library/ubuntu:22.04",
+		"docker.io/library/ubuntu:24.04",
+		"docker.io/myorg/api:latest",
+		"ghcr.io/myorg/web@"+digest.FromString("web").String(),
+		"moby-dangling@"+digest.FromString("dangling").String(),
+	)
+	filter, err := NewRepositoryFilter(nil, []string{"myorg/api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := &registry{client: cli, repoFilter: filter}
+
+	tests := []struct {
+		name    string
+		n       int
+		last    string
+		want    []string
+		wantEOF bool
+	}{
+		{name: "all", n: 10, want: []string{"ghcr.io/myorg/web", "ubuntu"}, wantEOF: true},
+		{name: "first page", n: 1, want: []string{"ghcr.io/myorg/web"}},
+		{name: "after last", n: 1, last: "ghcr.io/myorg/web", want: []string{"ubuntu"}, wantEOF: true},
+		{name: "after all", n: 1, last: "ubuntu", want: []string{}, wantEOF: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := make([]string, tt.n)
+			n, err := reg.Repositories(context.Background(), repos, tt.last)
+			if tt.wantEOF && err != io.EOF {
+				t.Fatalf("expected io.EOF, got %v", err)
+			}
+			if !tt.wantEOF && err != nil {
+				t.Fatal(err)
+			}
+			if got := repos[:n]; !slices.Equal(got, tt.want) {
+				t.Fatalf("expected repositories %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCatalogPagination(t *testing.T) {
+	app := newTestApp(t, registrytest.NewClient(t,
+		"docker.io/library/alpine:latest",
+		"docker.io/library/debian:latest",
+		"docker.io/library/ubuntu:latest",
+	))
+
+	get := func(path string) ([]string, string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d for %s, got %d: %s", http.StatusOK, path, rec.Code, rec.Body.String())
+		}
+		var body struct {
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Repositories, rec.Header().Get("Link")
+	}
+
+	repos, link := get("/v2/_catalog?n=2")
+	if !slices.Equal(repos, []string{"alpine", "debian"}) {
+		t.Fatalf("expected the first page [alpine debian], got %v", repos)
+	}
+	if want := `</v2/_catalog?last=debian&n=2>; rel="next"`; link != want {
+		t.Fatalf("expected Link header %q, got %q", want, link)
+	}
+
+	repos, link = get("/v2/_catalog?n=2&last=debian")
+	if !slices.Equal(repos, []string{"ubuntu"}) {
+		t.Fatalf("expected the last page [ubuntu], got %v", repos)
+	}
+	if link != "" {
+		t.Fatalf("expected no Link header on the last page, got %q", link)
+	}
+
+	repos, link = get("/v2/_catalog")
+	if len(repos) != 3 || link != "" {
+		t.Fatalf("expected all 3 repositories without a Link header, got %v and %q", repos, link)
+	}
+}
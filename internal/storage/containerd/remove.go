@@ -0,0 +1,198 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// CollectibleBlob is a blob of a removed image that isn't referenced by any other image.
+type CollectibleBlob struct {
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType,omitempty"`
+	Size      int64         `json:"size"`
+	// Removed indicates that the blob has been deleted by containerd garbage collection. A collectible blob may be
+	// still retained by a lease, e.g. of an upload in progress, until the lease expires.
+	Removed bool `json:"removed"`
+}
+
+// ImageRemoval describes the result of removing an image.
+type ImageRemoval struct {
+	Image  string        `json:"image"`
+	Digest digest.Digest `json:"digest"`
+	// Collectible are the image blobs that are not referenced by any other image.
+	Collectible []CollectibleBlob `json:"collectible"`
+	// CollectibleBytes is the total size of the collectible blobs.
+	CollectibleBytes int64 `json:"collectibleBytes"`
+	// RemovedImages are the digest-addressed images created by unregistry for the image manifests pushed by digest
+	// that have been removed along with the image.
+	RemovedImages []string `json:"removedImages,omitempty"`
+	// ReleasedLeases is the number of unregistry push leases of the repository that retained the collectible blobs.
+	ReleasedLeases int `json:"releasedLeases"`
+}
+
+// RemoveImage removes the image with the given normalized reference from the containerd image store along with
+// the garbage collection roots unregistry created for its content that isn't referenced by other images:
+// digest-addressed images of its manifests and the leases of pushes to its repository. It waits for the garbage
+// collection to complete and reports which blobs have become collectible and whether they have been deleted.
+func RemoveImage(ctx context.Context, cli *client.Client, ref reference.Named) (ImageRemoval, error) {
+	imageService := cli.ImageService()
+	img, err := imageService.Get(ctx, ref.String())
+	if err != nil {
+		return ImageRemoval{}, fmt.Errorf("get image '%s' from containerd image store: %w", ref.String(), err)
+	}
+
+	contentStore := cli.ContentStore()
+	blobs, err := verifyContent(ctx, contentStore, img.Target, nil)
+	if err != nil {
+		return ImageRemoval{}, fmt.Errorf("walk content of image '%s': %w", ref.String(), err)
+	}
+	exclusive := make(map[digest.Digest]CollectibleBlob, len(blobs))
+	for _, b := range blobs {
+		exclusive[b.Digest] = CollectibleBlob{Digest: b.Digest, MediaType: b.MediaType, Size: b.Size}
+	}
+
+	// Find the digest-addressed images of the image manifests and exclude content shared with other images.
+	all, err := imageService.List(ctx)
+	if err != nil {
+		return ImageRemoval{}, fmt.Errorf("list images in containerd image store: %w", err)
+	}
+	repo := reference.TrimNamed(ref).String()
+	candidates := make(map[string]digest.Digest)
+	for _, other := range all {
+		if other.Name == img.Name {
+			continue
+		}
+		if otherRef, err := reference.ParseNamed(other.Name); err == nil {
+			if digested, ok := otherRef.(reference.Digested); ok && reference.TrimNamed(otherRef).String() == repo {
+				if _, ok = exclusive[digested.Digest()]; ok {
+					candidates[other.Name] = digested.Digest()
+					continue
+				}
+			}
+		}
+
+		shared, err := verifyContent(ctx, contentStore, other.Target, nil)
+		if err != nil {
+			return ImageRemoval{}, fmt.Errorf("walk content of image '%s': %w", other.Name, err)
+		}
+		for _, b := range shared {
+			delete(exclusive, b.Digest)
+		}
+	}
+
+	// The digest-addressed images are only removed if their content isn't shared with other images, e.g. another
+	// tag of the same image.
+	var digestImages []string
+	for name, dgst := range candidates {
+		if _, ok := exclusive[dgst]; ok {
+			digestImages = append(digestImages, name)
+		}
+	}
+	slices.Sort(digestImages)
+
+	released, err := releaseUploadLeases(ctx, cli, reference.TrimNamed(ref), exclusive)
+	if err != nil {
+		return ImageRemoval{}, err
+	}
+
+	for _, name := range digestImages {
+		if err = imageService.Delete(ctx, name); err != nil && !errdefs.IsNotFound(err) {
+			return ImageRemoval{}, fmt.Errorf("delete image '%s' from containerd image store: %w", name, err)
+		}
+		logrus.WithField("image", name).Debug("Deleted digest-addressed image from containerd image store.")
+	}
+	// Wait for the garbage collection to check which blobs have been deleted.
+	if err = imageService.Delete(ctx, img.Name, images.SynchronousDelete()); err != nil {
+		return ImageRemoval{}, fmt.Errorf("delete image '%s' from containerd image store: %w", img.Name, err)
+	}
+
+	removal := ImageRemoval{
+		Image:          img.Name,
+		Digest:         img.Target.Digest,
+		Collectible:    []CollectibleBlob{},
+		RemovedImages:  digestImages,
+		ReleasedLeases: released,
+	}
+	// Keep the order of blobs in the image content.
+	for _, b := range blobs {
+		c, ok := exclusive[b.Digest]
+		if !ok {
+			continue
+		}
+		if _, err = contentStore.Info(ctx, c.Digest); errdefs.IsNotFound(err) {
+			c.Removed = true
+		}
+		removal.Collectible = append(removal.Collectible, c)
+		removal.CollectibleBytes += c.Size
+	}
+
+	return removal, nil
+}
+
+// releaseUploadLeases removes the given blobs from the leases of the push transactions to the repository and deletes
+// the leases that don't retain anything else. The leases of uploads and pushes to other repositories are kept as they
+// may retain the blobs for pushes in progress that share them. It returns the number of leases that retained any of
+// the blobs.
+func releaseUploadLeases(
+	ctx context.Context, cli *client.Client, repo reference.Named, blobs map[digest.Digest]CollectibleBlob,
+) (int, error) {
+	leasesService := cli.LeasesService()
+	pushLeases, err := leasesService.List(ctx,
+		fmt.Sprintf(`labels."%s"==%s,labels."%s"`, leaseTypeLabel, leaseTypeUpload, pushRepoLabel))
+	if err != nil {
+		return 0, fmt.Errorf("list containerd push leases: %w", err)
+	}
+	var repoLeases []leases.Lease
+	for _, lease := range pushLeases {
+		// The push transactions are labeled with the repository name as it's pushed to, e.g. "ubuntu".
+		named, err := reference.ParseNormalizedNamed(lease.Labels[pushRepoLabel])
+		if err == nil && named.Name() == repo.Name() {
+			repoLeases = append(repoLeases, lease)
+		}
+	}
+
+	released := 0
+	for _, lease := range repoLeases {
+		resources, err := leasesService.ListResources(ctx, lease)
+		if err != nil {
+			return released, fmt.Errorf("list resources of containerd lease '%s': %w", lease.ID, err)
+		}
+
+		var retained []leases.Resource
+		for _, r := range resources {
+			if r.Type != "content" {
+				continue
+			}
+			if _, ok := blobs[digest.Digest(r.ID)]; ok {
+				retained = append(retained, r)
+			}
+		}
+		if len(retained) == 0 {
+			continue
+		}
+		released++
+
+		if len(retained) == len(resources) {
+			if err = leasesService.Delete(ctx, lease); err != nil && !errdefs.IsNotFound(err) {
+				return released, fmt.Errorf("delete containerd lease '%s': %w", lease.ID, err)
+			}
+			continue
+		}
+		for _, r := range retained {
+			if err = leasesService.DeleteResource(ctx, lease, r); err != nil && !errdefs.IsNotFound(err) {
+				return released, fmt.Errorf("remove blob '%s' from containerd lease '%s': %w", r.ID, lease.ID, err)
+			}
+		}
+	}
+
+	return released, nil
+}
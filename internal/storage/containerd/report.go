@@ -0,0 +1,77 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/psviderski/unregistry/internal/transfer"
+)
+
+// reportTransfer creates a transfer report for the image with the given reference and target descriptor. It checks
+// that all the image content (manifests, configs, layers) is present in the containerd content store.
+func reportTransfer(
+	ctx context.Context,
+	client *client.Client,
+	reporter *transfer.Reporter,
+	ref reference.Reference,
+	target ocispec.Descriptor,
+) (transfer.Report, error) {
+	if reporter == nil {
+		return transfer.Report{}, nil
+	}
+
+	blobs, err := verifyContent(ctx, client.ContentStore(), target, nil)
+	if err != nil {
+		return transfer.Report{}, fmt.Errorf("walk content of image '%s': %w", ref.String(), err)
+	}
+
+	return reporter.Report(ref.String(), transfer.ReportBlob{Digest: target.Digest, MediaType: target.MediaType}, blobs)
+}
+
+// verifyContent walks the content tree of the target descriptor and checks that each blob is present in the content
+// store with the expected size. The children of a missing manifest can't be checked and aren't included. Only the
+// manifests matching the platform are checked in an index if the platform is not nil.
+func verifyContent(
+	ctx context.Context, contentStore content.Store, target ocispec.Descriptor, platform platforms.MatchComparer,
+) ([]transfer.ReportBlob, error) {
+	var blobs []transfer.ReportBlob
+	seen := make(map[digest.Digest]struct{})
+	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		if _, ok := seen[desc.Digest]; ok {
+			return nil, nil
+		}
+		seen[desc.Digest] = struct{}{}
+
+		info, err := contentStore.Info(ctx, desc.Digest)
+		if err != nil && !errdefs.IsNotFound(err) {
+			return nil, fmt.Errorf("get metadata for blob '%s' from containerd content store: %w", desc.Digest, err)
+		}
+		verified := err == nil && info.Size == desc.Size
+		blobs = append(blobs, transfer.ReportBlob{
+			Digest:    desc.Digest,
+			MediaType: desc.MediaType,
+			Size:      desc.Size,
+			Verified:  verified,
+		})
+		if !verified {
+			return nil, nil
+		}
+		return images.Children(ctx, contentStore, desc)
+	})
+	if platform != nil {
+		handler = images.FilterPlatforms(handler, platform)
+	}
+	if err := images.Walk(ctx, handler, target); err != nil {
+		return nil, err
+	}
+
+	return blobs, nil
+}
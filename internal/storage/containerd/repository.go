@@ -2,30 +2,135 @@ package containerd
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/events"
+	"github.com/psviderski/unregistry/internal/metadata"
+	"github.com/psviderski/unregistry/internal/transfer"
 )
 
 // repository implements distribution.Repository backed by the containerd content and image stores.
 type repository struct {
-	client    *client.Client
-	name      reference.Named
-	blobStore *blobStore
+	client *client.Client
+	name   reference.Named
+	// canonicalName is the repository reference in a normalized form, the way containerd image store expects it,
+	// for example, "docker.io/library/ubuntu".
+	canonicalName reference.Named
+	blobStore     *blobStore
+	metadata      metadata.Store
+	// dryRun records pushed manifests instead of storing them if set.
+	dryRun *transfer.DryRun
+	// reporter creates transfer reports for pushed images. No reports are created if nil.
+	reporter *transfer.Reporter
+	// events receives events about pushed and deleted images. No events are published if nil.
+	events *events.Broker
+	// namespaceAnnotation is the manifest annotation which value is the containerd namespace to tag pushed images in
+	// instead of the client's default one. Routing is disabled if empty.
+	namespaceAnnotation string
+	// validateSchema enables validating pushed manifests and image configs against the OCI JSON schemas.
+	validateSchema bool
+	// transactions groups the content uploaded by pushes to clean up after failed ones. Disabled if nil.
+	transactions *PushTransactions
+	// pullRewrites rewrites the repository names of pulled tags not found under the requested name. Can be nil.
+	pullRewrites *PullRewrites
+	// pullThrough fetches the images and blobs missing in the containerd store from upstream registries. Can be nil.
+	pullThrough *PullThrough
+	// signatures rejects tagging images without a valid signature from the trusted keys. Can be nil.
+	signatures *signatureCheck
+	// uploadLeases releases the upload leases of tagged images if push transactions are disabled. Can be nil.
+	uploadLeases *uploadLeases
+	// unpackSnapshotter is the snapshotter to unpack pushed images into. Unpacking is disabled if empty.
+	unpackSnapshotter string
+	// unpackPlatform is the platform of the multi-platform images to unpack.
+	unpackPlatform platforms.MatchComparer
+	// deletes enables deleting manifests and tags.
+	deletes bool
+	// quota limits the size of the images in the repository. No limit if nil.
+	quota *repoQuota
 }
 
-var _ distribution.Repository = &repository{}
+// TagResolver is implemented by the repositories that can resolve a tag in their own containerd image store.
+type TagResolver interface {
+	// CurrentTag returns the digest of the image the tag points to or an empty digest if the tag doesn't exist.
+	// Unlike the tag service, it doesn't look for the tag in rewritten repositories or upstream registries.
+	CurrentTag(ctx context.Context, tag string) (digest.Digest, error)
+}
+
+var (
+	_ distribution.Repository = &repository{}
+	_ QuotaChecker            = &repository{}
+	_ TagResolver             = &repository{}
+)
 
-func newRepository(client *client.Client, name reference.Named) *repository {
+// newRepository creates a repository with the given name sharing the configuration and state of the registry.
+func newRepository(reg *registry, name reference.Named) *repository {
+	// Shouldn't return an error as name is a valid reference.
+	canonicalName, _ := reference.ParseNormalizedNamed(name.String())
 	return &repository{
-		client: client,
-		name:   name,
+		client:        reg.client,
+		name:          name,
+		canonicalName: canonicalName,
 		blobStore: &blobStore{
-			client: client,
-			repo:   name,
+			client:          reg.client,
+			repo:            name,
+			progress:        reg.progress,
+			copyLimit:       reg.copyLimit,
+			dryRun:          reg.dryRun,
+			reporter:        reg.reporter,
+			verifier:        reg.verifier,
+			transactions:    reg.transactions,
+			metadata:        reg.metadata,
+			leaseExpiration: reg.leaseExpiration,
+			uploadLeases:    reg.uploadLeases,
+			pullThrough:     reg.pullThrough,
+			deletes:         reg.deletes,
+			quota:           reg.quota,
 		},
+		metadata:            reg.metadata,
+		dryRun:              reg.dryRun,
+		reporter:            reg.reporter,
+		events:              reg.events,
+		namespaceAnnotation: reg.namespaceAnnotation,
+		validateSchema:      reg.validateSchema,
+		transactions:        reg.transactions,
+		pullRewrites:        reg.pullRewrites,
+		pullThrough:         reg.pullThrough,
+		signatures:          newSignatureCheck(reg.signatures),
+		uploadLeases:        reg.uploadLeases,
+		unpackSnapshotter:   reg.unpackSnapshotter,
+		unpackPlatform:      reg.unpackPlatform,
+		deletes:             reg.deletes,
+		quota:               reg.quota,
+	}
+}
+
+// CheckQuota returns an error if the repository has reached its storage quota or would exceed it with length more
+// bytes. It returns nil if the quota isn't enforced.
+func (r *repository) CheckQuota(ctx context.Context, length int64) error {
+	return r.quota.check(ctx, r.canonicalName, length)
+}
+
+// CurrentTag returns the digest of the image the tag points to in the containerd image store or an empty digest if
+// the tag doesn't exist.
+func (r *repository) CurrentTag(ctx context.Context, tag string) (digest.Digest, error) {
+	ref, err := reference.WithTag(r.canonicalName, tag)
+	if err != nil {
+		return "", err
+	}
+	img, err := r.client.ImageService().Get(ctx, ref.String())
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("get image '%s' from containerd image store: %w", ref.String(), err)
 	}
+	return img.Target.Digest, nil
 }
 
 // Named returns the name of the repository.
@@ -38,8 +143,19 @@ func (r *repository) Manifests(
 	_ context.Context, _ ...distribution.ManifestServiceOption,
 ) (distribution.ManifestService, error) {
 	return &manifestService{
-		repo:      r.name,
-		blobStore: r.blobStore,
+		repo:           r.name,
+		canonicalRepo:  r.canonicalName,
+		client:         r.client,
+		blobStore:      r.blobStore,
+		dryRun:         r.dryRun,
+		validateSchema: r.validateSchema,
+		transactions:   r.transactions,
+		uploadLeases:   r.uploadLeases,
+		events:         r.events,
+		pullThrough:    r.pullThrough,
+		signatures:     r.signatures,
+		deletes:        r.deletes,
+		quota:          r.quota,
 	}, nil
 }
 
@@ -50,10 +166,23 @@ func (r *repository) Blobs(_ context.Context) distribution.BlobStore {
 
 // Tags returns the tag service for the repository backed by the containerd image store.
 func (r *repository) Tags(_ context.Context) distribution.TagService {
-	// Shouldn't return an error as r.name is a valid reference.
-	canonicalRepo, _ := reference.ParseNormalizedNamed(r.name.String())
 	return &tagService{
-		client:        r.client,
-		canonicalRepo: canonicalRepo,
+		client:              r.client,
+		repo:                r.name,
+		canonicalRepo:       r.canonicalName,
+		metadata:            r.metadata,
+		dryRun:              r.dryRun,
+		reporter:            r.reporter,
+		events:              r.events,
+		namespaceAnnotation: r.namespaceAnnotation,
+		transactions:        r.transactions,
+		pullRewrites:        r.pullRewrites,
+		pullThrough:         r.pullThrough,
+		uploadLeases:        r.uploadLeases,
+		signatures:          r.signatures,
+		unpackSnapshotter:   r.unpackSnapshotter,
+		unpackPlatform:      r.unpackPlatform,
+		deletes:             r.deletes,
+		quota:               r.quota,
 	}
 }
@@ -0,0 +1,67 @@
+package containerd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// PullRewrites rewrites the repository names requested by pulls to other names images may be stored under, e.g. when
+// nodes use unregistry as a registry mirror and request "docker.io/library/nginx" while the image was tagged as
+// "registry.example.com/nginx" on the host. A nil PullRewrites doesn't rewrite any names.
+type PullRewrites struct {
+	rules []pullRewrite
+}
+
+// pullRewrite replaces the from repository name prefix with to.
+type pullRewrite struct {
+	from string
+	to   string
+}
+
+// NewPullRewrites creates pull rewrites from the rules in the "<from>=<to>" form. A rule matches a repository name
+// that equals <from> or starts with <from> followed by "/", in either the normalized form, e.g.
+// "docker.io/library/nginx", or the familiar form, e.g. "nginx", and replaces the matched prefix with <to>.
+func NewPullRewrites(rules []string) (*PullRewrites, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	p := &PullRewrites{}
+	for _, rule := range rules {
+		from, to, ok := strings.Cut(rule, "=")
+		from, to = strings.Trim(from, "/ "), strings.Trim(to, "/ ")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid pull rewrite rule '%s': expected '<from>=<to>'", rule)
+		}
+		p.rules = append(p.rules, pullRewrite{from: from, to: to})
+	}
+	return p, nil
+}
+
+// Rewrite returns the normalized repository names the repository is rewritten to by the matching rules in the order
+// the rules were specified.
+func (p *PullRewrites) Rewrite(repo reference.Named) []reference.Named {
+	if p == nil {
+		return nil
+	}
+
+	var names []reference.Named
+	seen := map[string]bool{repo.Name(): true}
+	for _, rule := range p.rules {
+		for _, name := range []string{repo.Name(), reference.FamiliarName(repo)} {
+			suffix, ok := strings.CutPrefix(name, rule.from)
+			if !ok || (suffix != "" && !strings.HasPrefix(suffix, "/")) {
+				continue
+			}
+			rewritten, err := reference.ParseNormalizedNamed(rule.to + suffix)
+			if err == nil && !seen[rewritten.Name()] {
+				seen[rewritten.Name()] = true
+				names = append(names, rewritten)
+			}
+			break
+		}
+	}
+	return names
+}
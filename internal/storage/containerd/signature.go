@@ -0,0 +1,353 @@
+package containerd
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// cosignSimpleSigningMediaType is the media type of the layers of a cosign signature manifest with the payload
+	// that is signed.
+	cosignSimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// cosignSignatureAnnotation is the layer annotation of a cosign signature manifest with the base64-encoded
+	// signature of the layer payload.
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// cosignSignatureTagSuffix is the suffix of the "sha256-<hex>" tag cosign pushes the signatures of a manifest to.
+	cosignSignatureTagSuffix = ".sig"
+	// cosignSignatureType is the type of the simple signing payload of cosign image signatures.
+	cosignSignatureType = "cosign container image signature"
+)
+
+// signatureLayerTypes are the media types of the layers of cosign signature and attestation manifests. None of them
+// can be run as a container.
+var signatureLayerTypes = []string{
+	cosignSimpleSigningMediaType,
+	// In-toto attestations in DSSE envelopes made by "cosign attest".
+	"application/vnd.dsse.envelope.v1+json",
+	// Sigstore bundles cosign pushes as referrers with --new-bundle-format.
+	"application/vnd.dev.sigstore.bundle.v0.3+json",
+}
+
+// errSignatureMissing is returned by SignaturePolicy when the repository has no valid signature of a manifest.
+var errSignatureMissing = errors.New("no valid signature from the trusted keys")
+
+// SignaturePolicy only allows tagging images that have a valid cosign signature made with one of the trusted keys
+// in the same repository, so that only signed images land on the host. Signatures must be pushed before the image is
+// tagged, either to the "sha256-<hex>.sig" tag cosign uses by default or as referrers of the manifest. Signatures and
+// attestations themselves are allowed to be tagged. Keyless signatures aren't supported. A nil SignaturePolicy allows
+// all images.
+type SignaturePolicy struct {
+	keys []trustedKey
+}
+
+// trustedKey is a public key signatures are verified with.
+type trustedKey struct {
+	path string
+	key  crypto.PublicKey
+}
+
+// NewSignaturePolicy creates a signature policy trusting the ECDSA, RSA, or Ed25519 public keys in the PEM files,
+// e.g. cosign.pub generated by "cosign generate-key-pair". It returns nil if there are no keys.
+func NewSignaturePolicy(keyPaths []string) (*SignaturePolicy, error) {
+	if len(keyPaths) == 0 {
+		return nil, nil
+	}
+
+	p := &SignaturePolicy{}
+	for _, path := range keyPaths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read signature key: %w", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("invalid signature key '%s': expected a PEM-encoded public key", path)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse signature key '%s': %w", path, err)
+		}
+		switch key.(type) {
+		case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		default:
+			return nil, fmt.Errorf("unsupported signature key '%s' of type %T", path, key)
+		}
+		p.keys = append(p.keys, trustedKey{path: path, key: key})
+	}
+	return p, nil
+}
+
+// Verify checks that the repository in the containerd store has a cosign signature of the manifest digest made with
+// one of the trusted keys. It returns an error wrapping errSignatureMissing if there is none.
+func (p *SignaturePolicy) Verify(
+	ctx context.Context, cli *client.Client, repo reference.Named, dgst digest.Digest,
+) error {
+	if p == nil {
+		return nil
+	}
+
+	signatures, err := signatureManifests(ctx, cli, repo, dgst)
+	if err != nil {
+		return err
+	}
+	contentStore := cli.ContentStore()
+	for _, desc := range signatures {
+		blob, err := content.ReadBlob(ctx, contentStore, desc)
+		if err != nil {
+			return fmt.Errorf("read signature manifest '%s' from containerd content store: %w", desc.Digest, err)
+		}
+		var manifest ocispec.Manifest
+		if err = json.Unmarshal(blob, &manifest); err != nil {
+			logrus.WithField("digest", desc.Digest).WithError(err).Debug("Skipped invalid signature manifest.")
+			continue
+		}
+		for _, layer := range manifest.Layers {
+			if layer.MediaType != cosignSimpleSigningMediaType {
+				continue
+			}
+			key, err := p.verifyLayer(ctx, contentStore, layer, dgst)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"signature": desc.Digest,
+					"digest":    dgst,
+				}).WithError(err).Debug("Skipped invalid signature.")
+				continue
+			}
+			logrus.WithFields(logrus.Fields{
+				"repo":   repo.Name(),
+				"digest": dgst,
+				"key":    key,
+			}).Debug("Verified image signature.")
+			return nil
+		}
+	}
+	return fmt.Errorf("manifest '%s' in repository '%s': %w", dgst, repo.Name(), errSignatureMissing)
+}
+
+// VerifyImage checks that the image with the reference in the containerd image store can be tagged: it's either
+// a signature or attestation, or the repository of the image has a valid signature of it. It returns an error wrapping
+// errSignatureMissing if there is none.
+func (p *SignaturePolicy) VerifyImage(ctx context.Context, cli *client.Client, ref reference.Named) error {
+	if p == nil {
+		return nil
+	}
+	img, err := cli.ImageService().Get(ctx, ref.String())
+	if err != nil {
+		return fmt.Errorf("get image '%s' from containerd image store: %w", ref.String(), err)
+	}
+	payload, err := content.ReadBlob(ctx, cli.ContentStore(), img.Target)
+	if err != nil {
+		return fmt.Errorf("read manifest '%s' from containerd content store: %w", img.Target.Digest, err)
+	}
+	if isSignatureArtifact(img.Target.MediaType, payload) {
+		return nil
+	}
+	return p.Verify(ctx, cli, reference.TrimNamed(ref), img.Target.Digest)
+}
+
+// IsSignatureMissing returns true if the error means that an image has no valid signature from the trusted keys.
+func IsSignatureMissing(err error) bool {
+	return errors.Is(err, errSignatureMissing)
+}
+
+// isSignatureArtifact reports whether the manifest with the media type and payload is a cosign signature or
+// attestation rather than a runnable image, i.e. an image manifest which layers all are signatures or attestations.
+// The referrers tag of a manifest is not a proof as any image can be pushed to a tag like "sha256-<hex>.sig".
+func isSignatureArtifact(mediaType string, payload []byte) bool {
+	if !images.IsManifestType(mediaType) {
+		return false
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(payload, &manifest); err != nil || len(manifest.Layers) == 0 {
+		return false
+	}
+	for _, layer := range manifest.Layers {
+		if !slices.Contains(signatureLayerTypes, layer.MediaType) {
+			return false
+		}
+	}
+	return true
+}
+
+// signatureCheck enforces the signature policy for the manifests tagged in a repository. It's shared by the manifest
+// and tag services of a repository so that a manifest pushed by tag is only verified once.
+type signatureCheck struct {
+	policy   *SignaturePolicy
+	mu       sync.Mutex
+	verified map[digest.Digest]bool
+}
+
+// newSignatureCheck returns a signature check for the policy or nil if the policy is nil.
+func newSignatureCheck(policy *SignaturePolicy) *signatureCheck {
+	if policy == nil {
+		return nil
+	}
+	return &signatureCheck{policy: policy, verified: make(map[digest.Digest]bool)}
+}
+
+// check returns a denied error if the manifest with the media type and payload can't be tagged in the repository
+// because it's neither signed with a trusted key nor a signature or attestation itself.
+func (c *signatureCheck) check(
+	ctx context.Context,
+	cli *client.Client,
+	repo, canonicalRepo reference.Named,
+	tag, mediaType string,
+	payload []byte,
+) error {
+	if c == nil {
+		return nil
+	}
+	dgst := digest.FromBytes(payload)
+	if c.isVerified(dgst) || isSignatureArtifact(mediaType, payload) {
+		return nil
+	}
+
+	err := c.policy.Verify(ctx, cli, canonicalRepo, dgst)
+	if err != nil {
+		if !errors.Is(err, errSignatureMissing) {
+			return fmt.Errorf("verify manifest signature: %w", err)
+		}
+		logrus.WithFields(logrus.Fields{
+			"repo":   repo.Name(),
+			"tag":    tag,
+			"digest": dgst,
+		}).Info("Rejected unsigned image.")
+		return errcode.ErrorCodeDenied.WithMessage(fmt.Sprintf(
+			"image '%s:%s' has no valid signature from the trusted keys: push the image by digest and sign it "+
+				"before tagging", repo.Name(), tag))
+	}
+	c.mu.Lock()
+	c.verified[dgst] = true
+	c.mu.Unlock()
+	return nil
+}
+
+// isVerified returns true if the manifest with the digest has already been verified in the repository or the signature
+// policy is disabled.
+func (c *signatureCheck) isVerified(dgst digest.Digest) bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.verified[dgst]
+}
+
+// verifyLayer verifies the signature in the annotation of the simple signing layer with the trusted keys and checks
+// that the signed payload refers to the manifest digest. It returns the path of the key that made the signature.
+func (p *SignaturePolicy) verifyLayer(
+	ctx context.Context, provider content.Provider, layer ocispec.Descriptor, dgst digest.Digest,
+) (string, error) {
+	sig, err := base64.StdEncoding.DecodeString(layer.Annotations[cosignSignatureAnnotation])
+	if err != nil || len(sig) == 0 {
+		return "", errors.New("missing or malformed signature annotation")
+	}
+	payload, err := content.ReadBlob(ctx, provider, layer)
+	if err != nil {
+		return "", fmt.Errorf("read signature payload '%s': %w", layer.Digest, err)
+	}
+
+	signedBy := ""
+	for _, k := range p.keys {
+		if verifySignature(k.key, payload, sig) {
+			signedBy = k.path
+			break
+		}
+	}
+	if signedBy == "" {
+		return "", errors.New("signature doesn't match any trusted key")
+	}
+
+	// The payload is only trusted after its signature has been verified.
+	var simpleSigning struct {
+		Critical struct {
+			Image struct {
+				DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+			} `json:"image"`
+			Type string `json:"type"`
+		} `json:"critical"`
+	}
+	if err = json.Unmarshal(payload, &simpleSigning); err != nil {
+		return "", fmt.Errorf("unmarshal signature payload: %w", err)
+	}
+	if simpleSigning.Critical.Type != cosignSignatureType {
+		return "", fmt.Errorf("unexpected signature payload type '%s'", simpleSigning.Critical.Type)
+	}
+	if simpleSigning.Critical.Image.DockerManifestDigest != dgst {
+		return "", fmt.Errorf("signature is for another manifest '%s'",
+			simpleSigning.Critical.Image.DockerManifestDigest)
+	}
+	return signedBy, nil
+}
+
+// verifySignature reports whether sig is a valid signature of the payload made with the private key of the public
+// key the way cosign signs: ECDSA and RSA PKCS #1 v1.5 over the SHA-256 hash, and Ed25519 over the payload itself.
+func verifySignature(key crypto.PublicKey, payload, sig []byte) bool {
+	hash := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, hash[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, sig)
+	}
+	return false
+}
+
+// signatureManifests returns the descriptors of the manifests in the repository that may hold cosign signatures of
+// the manifest digest: the "sha256-<hex>.sig" tag and the referrers of the manifest.
+func signatureManifests(
+	ctx context.Context, cli *client.Client, repo reference.Named, dgst digest.Digest,
+) ([]ocispec.Descriptor, error) {
+	imgs, err := repositoryImages(ctx, cli, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	sigTag := "sha256-" + dgst.Encoded() + cosignSignatureTagSuffix
+	var descs []ocispec.Descriptor
+	for _, img := range imgs {
+		if tagged, ok := img.ref.(reference.Tagged); ok {
+			if tagged.Tag() == sigTag {
+				descs = append(descs, img.Target)
+			}
+			continue
+		}
+		// Referrers are pushed by digest.
+		subject, err := manifestSubject(ctx, cli.ContentStore(), img.Target)
+		if err != nil {
+			if errdefs.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if subject == dgst {
+			descs = append(descs, img.Target)
+		}
+	}
+	return descs, nil
+}
@@ -0,0 +1,299 @@
+package containerd
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// memoryProvider is a content.Provider serving blobs from memory.
+type memoryProvider map[digest.Digest][]byte
+
+func (p memoryProvider) ReaderAt(_ context.Context, desc ocispec.Descriptor) (content.ReaderAt, error) {
+	blob, ok := p[desc.Digest]
+	if !ok {
+		return nil, fmt.Errorf("blob %s: %w", desc.Digest, errdefs.ErrNotFound)
+	}
+	return memoryReaderAt{Reader: bytes.NewReader(blob)}, nil
+}
+
+type memoryReaderAt struct {
+	*bytes.Reader
+}
+
+func (r memoryReaderAt) Close() error {
+	return nil
+}
+
+// testSigner signs payloads the way cosign does with the private key of a trusted key.
+type testSigner struct {
+	name string
+	key  crypto.PublicKey
+	sign func(payload []byte) []byte
+}
+
+func testSigners(t *testing.T) []testSigner {
+	t.Helper()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []testSigner{
+		{name: "ecdsa", key: &ecKey.PublicKey, sign: func(payload []byte) []byte {
+			hash := sha256.Sum256(payload)
+			sig, err := ecdsa.SignASN1(rand.Reader, ecKey, hash[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		}},
+		{name: "rsa", key: &rsaKey.PublicKey, sign: func(payload []byte) []byte {
+			hash := sha256.Sum256(payload)
+			sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, hash[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		}},
+		{name: "ed25519", key: edPub, sign: func(payload []byte) []byte {
+			return ed25519.Sign(edKey, payload)
+		}},
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	payload := []byte(`{"critical":{}}`)
+	signers := testSigners(t)
+	for i, s := range signers {
+		other := signers[(i+1)%len(signers)]
+		t.Run(s.name, func(t *testing.T) {
+			sig := s.sign(payload)
+			if !verifySignature(s.key, payload, sig) {
+				t.Fatal("valid signature rejected")
+			}
+			if verifySignature(s.key, []byte(`{"critical":{"tampered":true}}`), sig) {
+				t.Fatal("signature of another payload accepted")
+			}
+			if verifySignature(other.key, payload, sig) {
+				t.Fatal("signature made with another key accepted")
+			}
+			if verifySignature(s.key, payload, nil) {
+				t.Fatal("empty signature accepted")
+			}
+		})
+	}
+	if verifySignature("unsupported", payload, []byte("sig")) {
+		t.Fatal("signature with unsupported key type accepted")
+	}
+}
+
+// simpleSigningPayload returns a cosign simple signing payload of the manifest digest.
+func simpleSigningPayload(t *testing.T, typ string, dgst digest.Digest) []byte {
+	t.Helper()
+	payload, err := json.Marshal(map[string]any{
+		"critical": map[string]any{
+			"identity": map[string]string{"docker-reference": "example.com/app"},
+			"image":    map[string]string{"docker-manifest-digest": dgst.String()},
+			"type":     typ,
+		},
+		"optional": nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestVerifyLayer(t *testing.T) {
+	signers := testSigners(t)
+	trusted, untrusted := signers[0], signers[1]
+	policy := &SignaturePolicy{keys: []trustedKey{{path: "cosign.pub", key: trusted.key}}}
+	manifestDigest := digest.FromString("manifest")
+
+	tests := []struct {
+		name    string
+		payload []byte
+		// sign returns the signature annotation of the payload.
+		sign    func(payload []byte) string
+		missing bool
+		wantErr bool
+	}{
+		{
+			name:    "valid signature",
+			payload: simpleSigningPayload(t, cosignSignatureType, manifestDigest),
+			sign: func(payload []byte) string {
+				return base64.StdEncoding.EncodeToString(trusted.sign(payload))
+			},
+		},
+		{
+			name:    "untrusted key",
+			payload: simpleSigningPayload(t, cosignSignatureType, manifestDigest),
+			sign: func(payload []byte) string {
+				return base64.StdEncoding.EncodeToString(untrusted.sign(payload))
+			},
+			wantErr: true,
+		},
+		{
+			name:    "signature of another manifest",
+			payload: simpleSigningPayload(t, cosignSignatureType, digest.FromString("other")),
+			sign: func(payload []byte) string {
+				return base64.StdEncoding.EncodeToString(trusted.sign(payload))
+			},
+			wantErr: true,
+		},
+		{
+			name:    "unexpected payload type",
+			payload: simpleSigningPayload(t, "attestation", manifestDigest),
+			sign: func(payload []byte) string {
+				return base64.StdEncoding.EncodeToString(trusted.sign(payload))
+			},
+			wantErr: true,
+		},
+		{
+			name:    "missing annotation",
+			payload: simpleSigningPayload(t, cosignSignatureType, manifestDigest),
+			sign: func([]byte) string {
+				return ""
+			},
+			wantErr: true,
+		},
+		{
+			name:    "malformed annotation",
+			payload: simpleSigningPayload(t, cosignSignatureType, manifestDigest),
+			sign: func([]byte) string {
+				return "not base64!"
+			},
+			wantErr: true,
+		},
+		{
+			name:    "missing payload",
+			payload: simpleSigningPayload(t, cosignSignatureType, manifestDigest),
+			sign: func(payload []byte) string {
+				return base64.StdEncoding.EncodeToString(trusted.sign(payload))
+			},
+			missing: true,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layer := ocispec.Descriptor{
+				MediaType:   cosignSimpleSigningMediaType,
+				Digest:      digest.FromBytes(tt.payload),
+				Size:        int64(len(tt.payload)),
+				Annotations: map[string]string{cosignSignatureAnnotation: tt.sign(tt.payload)},
+			}
+			provider := memoryProvider{}
+			if !tt.missing {
+				provider[layer.Digest] = tt.payload
+			}
+
+			key, err := policy.verifyLayer(context.Background(), provider, layer, manifestDigest)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if key != "cosign.pub" {
+				t.Fatalf("expected signature made with 'cosign.pub', got '%s'", key)
+			}
+		})
+	}
+}
+
+func TestIsSignatureArtifact(t *testing.T) {
+	manifest := func(layerTypes ...string) []byte {
+		m := ocispec.Manifest{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig},
+		}
+		for _, typ := range layerTypes {
+			m.Layers = append(m.Layers, ocispec.Descriptor{MediaType: typ})
+		}
+		payload, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return payload
+	}
+
+	tests := []struct {
+		name      string
+		mediaType string
+		payload   []byte
+		want      bool
+	}{
+		{
+			name:      "cosign signature",
+			mediaType: ocispec.MediaTypeImageManifest,
+			payload:   manifest(cosignSimpleSigningMediaType, cosignSimpleSigningMediaType),
+			want:      true,
+		},
+		{
+			name:      "cosign attestation",
+			mediaType: ocispec.MediaTypeImageManifest,
+			payload:   manifest("application/vnd.dsse.envelope.v1+json"),
+			want:      true,
+		},
+		{
+			name:      "image",
+			mediaType: ocispec.MediaTypeImageManifest,
+			payload:   manifest(ocispec.MediaTypeImageLayerGzip),
+		},
+		{
+			name:      "image with signature layer",
+			mediaType: ocispec.MediaTypeImageManifest,
+			payload:   manifest(cosignSimpleSigningMediaType, ocispec.MediaTypeImageLayerGzip),
+		},
+		{
+			name:      "no layers",
+			mediaType: ocispec.MediaTypeImageManifest,
+			payload:   manifest(),
+		},
+		{
+			name:      "index",
+			mediaType: ocispec.MediaTypeImageIndex,
+			payload:   manifest(cosignSimpleSigningMediaType),
+		},
+		{
+			name:      "invalid JSON",
+			mediaType: ocispec.MediaTypeImageManifest,
+			payload:   []byte("{"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSignatureArtifact(tt.mediaType, tt.payload); got != tt.want {
+				t.Fatalf("expected %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ContentStats describes how much disk space the images on the host consume in the containerd content store.
+type ContentStats struct {
+	// Images is the number of images including digest-addressed ones.
+	Images int `json:"images"`
+	Tags   int `json:"tags"`
+	// Blobs is the number of blobs in the content store including the ones not referenced by any image.
+	Blobs int `json:"blobs"`
+	// ContentBytes is the total size of the blobs in the content store including the ones not referenced by any
+	// image, e.g. left by interrupted pushes.
+	ContentBytes int64 `json:"contentBytes"`
+	// TotalBytes is the sum of the sizes of all images as if they didn't share any content.
+	TotalBytes int64 `json:"totalBytes"`
+	// UniqueBytes is the size of the content referenced by the images with blobs shared between them counted once.
+	UniqueBytes int64 `json:"uniqueBytes"`
+	// DeduplicationRatio is TotalBytes divided by UniqueBytes. It's 0 if there is no image content.
+	DeduplicationRatio float64 `json:"deduplicationRatio"`
+	// Repositories are the stats of each repository sorted by name.
+	Repositories []RepositoryStats `json:"repositories"`
+}
+
+// RepositoryStats describes how much disk space the images of a repository consume.
+type RepositoryStats struct {
+	// Repo is the repository name the way clients use to pull it, e.g. "ubuntu".
+	Repo   string `json:"repo"`
+	Images int    `json:"images"`
+	Tags   int    `json:"tags"`
+	// Size is the size of the content referenced by the images of the repository with blobs shared between them
+	// counted once. Blobs shared with other repositories are counted in each of them.
+	Size int64 `json:"size"`
+}
+
+// Stats computes the content store usage of the images in the containerd image store from the content metadata.
+// Only the content present in the content store is counted.
+func Stats(ctx context.Context, cli *client.Client) (ContentStats, error) {
+	stats := ContentStats{Repositories: []RepositoryStats{}}
+	contentStore := cli.ContentStore()
+	err := contentStore.Walk(ctx, func(info content.Info) error {
+		stats.Blobs++
+		stats.ContentBytes += info.Size
+		return nil
+	})
+	if err != nil {
+		return ContentStats{}, fmt.Errorf("walk containerd content store: %w", err)
+	}
+
+	imgs, err := cli.ImageService().List(ctx)
+	if err != nil {
+		return ContentStats{}, fmt.Errorf("list images in containerd image store: %w", err)
+	}
+	unique := make(map[digest.Digest]int64)
+	repos := make(map[string]*RepositoryStats)
+	repoContent := make(map[string]map[digest.Digest]int64)
+	for _, img := range imgs {
+		// Docker keeps untagged images as "moby-dangling@<digest>" to prevent them from being garbage collected.
+		if strings.HasPrefix(img.Name, "moby-dangling@") {
+			continue
+		}
+		named, err := reference.ParseNormalizedNamed(img.Name)
+		if err != nil {
+			continue
+		}
+		blobs, err := presentContent(ctx, contentStore, img.Target)
+		if err != nil {
+			return ContentStats{}, fmt.Errorf("walk image '%s': %w", img.Name, err)
+		}
+
+		repo := reference.FamiliarName(named)
+		if repos[repo] == nil {
+			repos[repo] = &RepositoryStats{Repo: repo}
+			repoContent[repo] = make(map[digest.Digest]int64)
+		}
+		stats.Images++
+		repos[repo].Images++
+		if _, ok := named.(reference.Tagged); ok {
+			stats.Tags++
+			repos[repo].Tags++
+		}
+		for dgst, size := range blobs {
+			stats.TotalBytes += size
+			unique[dgst] = size
+			repoContent[repo][dgst] = size
+		}
+	}
+
+	for _, size := range unique {
+		stats.UniqueBytes += size
+	}
+	if stats.UniqueBytes > 0 {
+		stats.DeduplicationRatio = float64(stats.TotalBytes) / float64(stats.UniqueBytes)
+	}
+	for repo, s := range repos {
+		for _, size := range repoContent[repo] {
+			s.Size += size
+		}
+		stats.Repositories = append(stats.Repositories, *s)
+	}
+	slices.SortFunc(stats.Repositories, func(a, b RepositoryStats) int {
+		return strings.Compare(a.Repo, b.Repo)
+	})
+	return stats, nil
+}
+
+// presentContent returns the sizes of the unique blobs of the image with the target descriptor that are present in
+// the content store by their digests.
+func presentContent(
+	ctx context.Context, store content.Store, target ocispec.Descriptor,
+) (map[digest.Digest]int64, error) {
+	blobs := make(map[digest.Digest]int64)
+	if _, err := store.Info(ctx, target.Digest); err != nil {
+		if errdefs.IsNotFound(err) {
+			return blobs, nil
+		}
+		return nil, fmt.Errorf("get metadata for blob '%s' from containerd content store: %w", target.Digest, err)
+	}
+
+	collect := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		blobs[desc.Digest] = desc.Size
+		return nil, nil
+	})
+	if err := images.Walk(ctx, images.Handlers(collect, presentChildrenHandler(store)), target); err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+// RepositorySize returns the size of the content referenced by the images of the repository that is present in
+// the content store with blobs shared between the images counted once.
+func RepositorySize(ctx context.Context, cli *client.Client, repo reference.Named) (int64, error) {
+	imgs, err := repositoryImages(ctx, cli, repo)
+	if err != nil {
+		return 0, err
+	}
+	blobs := make(map[digest.Digest]int64)
+	for _, img := range imgs {
+		imgBlobs, err := presentContent(ctx, cli.ContentStore(), img.Target)
+		if err != nil {
+			return 0, fmt.Errorf("walk image '%s': %w", img.Name, err)
+		}
+		maps.Copy(blobs, imgBlobs)
+	}
+
+	var size int64
+	for _, s := range blobs {
+		size += s
+	}
+	return size, nil
+}
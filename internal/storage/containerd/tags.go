@@ -2,28 +2,140 @@ package containerd
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
 	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/pkg/identifiers"
 	"github.com/containerd/errdefs"
+	"github.com/containerd/platforms"
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
 	"github.com/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/psviderski/unregistry/internal/events"
+	"github.com/psviderski/unregistry/internal/metadata"
+	"github.com/psviderski/unregistry/internal/tracing"
+	"github.com/psviderski/unregistry/internal/transfer"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // tagService implements distribution.TagService backed by the containerd image store.
 type tagService struct {
 	client *client.Client
+	repo   reference.Named
 	// canonicalRepo is the repository reference in a normalized form, the way containerd image store expects it,
 	// for example, "docker.io/library/ubuntu"
 	canonicalRepo reference.Named
+	metadata      metadata.Store
+	// dryRun disables tagging if set.
+	dryRun *transfer.DryRun
+	// reporter creates transfer reports for tagged images. No reports are created if nil.
+	reporter *transfer.Reporter
+	// events receives an event for each pushed and deleted image. No events are published if nil.
+	events *events.Broker
+	// namespaceAnnotation is the manifest annotation which value is the containerd namespace to tag pushed images in
+	// instead of the client's default one. Routing is disabled if empty.
+	namespaceAnnotation string
+	// transactions completes the push of the tagged image. Disabled if nil.
+	transactions *PushTransactions
+	// uploadLeases releases the upload leases of the tagged image if push transactions are disabled. Can be nil.
+	uploadLeases *uploadLeases
+	// pullRewrites rewrites the repository name to look up the tag under if it's not found in the repository.
+	// Can be nil.
+	pullRewrites *PullRewrites
+	// pullThrough fetches the tags missing in the containerd image store from upstream registries and revalidates
+	// the fetched ones. Can be nil.
+	pullThrough *PullThrough
+	// signatures rejects tagging images without a valid signature from the trusted keys. Can be nil.
+	signatures *signatureCheck
+	// unpackSnapshotter is the snapshotter to unpack tagged images into. Unpacking is disabled if empty.
+	unpackSnapshotter string
+	// unpackPlatform is the platform of the multi-platform images to unpack.
+	unpackPlatform platforms.MatchComparer
+	// deletes enables deleting tags. Untag returns distribution.ErrUnsupported if disabled.
+	deletes bool
+	// quota caches the size of the repository which deleted tags change. Can be nil.
+	quota *repoQuota
 }
 
-// Get retrieves an image descriptor by its tag from the containerd image store.
-func (t *tagService) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
+// Get retrieves an image descriptor by its tag from the containerd image store. If the tag isn't found in
+// the repository, it's looked up in the repositories the pull rewrite rules map the repository to, and then fetched
+// from the upstream registry of the repository if the pull-through proxy is enabled.
+func (t *tagService) Get(ctx context.Context, tag string) (desc distribution.Descriptor, err error) {
+	ctx, span := tracing.Start(ctx, "containerd.TagService.Get",
+		attribute.String("registry.repository", t.repo.Name()), attribute.String("registry.tag", tag))
+	defer tracing.End(span, &err)
+
+	desc, err = t.get(ctx, t.canonicalRepo, tag)
+	if err == nil {
+		return t.revalidate(ctx, tag, desc), nil
+	}
+	if !errors.As(err, new(distribution.ErrTagUnknown)) {
+		return desc, err
+	}
+	for _, repo := range t.pullRewrites.Rewrite(t.canonicalRepo) {
+		if rewrittenDesc, rewrittenErr := t.get(ctx, repo, tag); rewrittenErr == nil {
+			logrus.WithFields(logrus.Fields{
+				"repo":      t.canonicalRepo.Name(),
+				"rewritten": repo.Name(),
+				"tag":       tag,
+			}).Debug("Found pulled tag in rewritten repository.")
+			return rewrittenDesc, nil
+		} else if !errors.As(rewrittenErr, new(distribution.ErrTagUnknown)) {
+			return distribution.Descriptor{}, rewrittenErr
+		}
+	}
+	if ref, refErr := reference.WithTag(t.canonicalRepo, tag); refErr == nil {
+		fetchedDesc, fetchErr := t.pullThrough.FetchImage(ctx, t.client, ref)
+		if fetchErr == nil {
+			return fetchedDesc, nil
+		}
+		if !errors.Is(fetchErr, errNotProxied) {
+			logrus.WithField("image", ref.String()).WithError(fetchErr).Warn(
+				"Failed to fetch image from upstream registry.")
+		}
+	}
+	return desc, err
+}
+
+// revalidate fetches the tag from the upstream registry again if the image was fetched by the pull-through proxy
+// longer than its TTL ago and returns the descriptor of the fetched image. The cached image is served if
+// the upstream is unreachable.
+func (t *tagService) revalidate(ctx context.Context, tag string, desc distribution.Descriptor) distribution.Descriptor {
+	if t.pullThrough == nil {
+		return desc
+	}
 	ref, err := reference.WithTag(t.canonicalRepo, tag)
+	if err != nil {
+		return desc
+	}
+	img, err := t.client.ImageService().Get(ctx, ref.String())
+	if err != nil || !t.pullThrough.Stale(img) {
+		return desc
+	}
+
+	fetchedDesc, err := t.pullThrough.FetchImage(ctx, t.client, ref)
+	if err != nil {
+		if !errors.Is(err, errNotProxied) {
+			logrus.WithField("image", ref.String()).WithError(err).Warn(
+				"Failed to revalidate image with upstream registry, serving the cached one.")
+		}
+		return desc
+	}
+	return fetchedDesc
+}
+
+// get retrieves an image descriptor by its tag in the repository from the containerd image store.
+func (t *tagService) get(ctx context.Context, repo reference.Named, tag string) (distribution.Descriptor, error) {
+	ref, err := reference.WithTag(repo, tag)
 	if err != nil {
 		return distribution.Descriptor{}, distribution.ErrManifestUnknown{
 			Name: t.canonicalRepo.Name(),
@@ -56,12 +168,132 @@ func (t *tagService) Get(ctx context.Context, tag string) (distribution.Descript
 // that is already present in the containerd content store.
 // It also sets garbage collection labels on the image content in the containerd content store to prevent it from being
 // deleted by garbage collection.
-func (t *tagService) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
+func (t *tagService) Tag(ctx context.Context, tag string, desc distribution.Descriptor) (err error) {
+	ctx, span := tracing.Start(ctx, "containerd.TagService.Tag",
+		attribute.String("registry.repository", t.repo.Name()),
+		attribute.String("registry.tag", tag),
+		attribute.String("registry.digest", desc.Digest.String()),
+	)
+	defer tracing.End(span, &err)
+
 	ref, err := reference.WithTag(t.canonicalRepo, tag)
 	if err != nil {
 		return err
 	}
+	if t.dryRun != nil {
+		logrus.WithField("image", ref.String()).Debug("Skipped tagging image in dry-run mode.")
+		return nil
+	}
+	// Every tagged manifest is checked. The manifest pushed by tag has been verified when it was stored and is skipped
+	// without reading it again, so only the manifests pushed by digest are verified here before being tagged.
+	if !t.signatures.isVerified(desc.Digest) {
+		payload, err := content.ReadBlob(ctx, t.client.ContentStore(), desc)
+		if err != nil {
+			return fmt.Errorf("read manifest '%s' from containerd content store: %w", desc.Digest, err)
+		}
+		if err = t.signatures.check(ctx, t.client, t.repo, t.canonicalRepo, tag, desc.MediaType, payload); err != nil {
+			return err
+		}
+	}
+	defer t.quota.invalidate(t.repo)
+
+	imageCtx := ctx
+	namespace, err := t.routedNamespace(ctx, desc)
+	if err != nil {
+		return err
+	}
+	if namespace != "" {
+		nsCtx, release, err := copyContentToNamespace(ctx, t.client, desc, namespace, false)
+		if err != nil {
+			return err
+		}
+		defer release()
+		imageCtx = nsCtx
+		logrus.WithFields(logrus.Fields{
+			"image":     ref.String(),
+			"namespace": namespace,
+		}).Info("Routed image to containerd namespace from manifest annotation.")
+	}
+
+	if err = createImage(imageCtx, t.client, ref, desc, false); err != nil {
+		return err
+	}
+	t.transactions.promote(ctx, t.repo.Name(), desc)
+	t.uploadLeases.release(ctx, desc)
+	if t.unpackSnapshotter != "" {
+		// Unpacking large images takes a while and the push is complete once the image is created.
+		unpackImageInBackground(imageCtx, t.client, ref, desc, t.unpackSnapshotter, t.unpackPlatform)
+	}
+	if err = metadata.RecordTag(t.metadata, ref.String(), desc.Digest); err != nil {
+		// The tag history is informational so failing to record it shouldn't fail the push.
+		logrus.WithField("image", ref.String()).WithError(err).Warn("Failed to record tag history.")
+	}
+	report, err := reportTransfer(ctx, t.client, t.reporter, ref, desc)
+	if err != nil {
+		// The report is informational so failing to create it shouldn't fail the push.
+		logrus.WithField("image", ref.String()).WithError(err).Warn("Failed to create transfer report.")
+	} else if t.reporter != nil && !report.Verified() {
+		logrus.WithField("image", ref.String()).Warn("Some content of the pushed image is missing.")
+	}
+
+	// The artifact type is informational in the event and the error has been logged when creating the image.
+	artifactType, _ := manifestArtifactType(ctx, t.client.ContentStore(), desc)
+	t.events.Publish(events.Event{
+		Type:         events.TypePush,
+		Image:        ref.String(),
+		Digest:       desc.Digest,
+		MediaType:    desc.MediaType,
+		ArtifactType: artifactType,
+	})
 
+	// The manifests of a multi-platform image are pushed by digest before the index is pushed by tag. Digest-addressed
+	// images created for them are no longer needed as their content is now referenced by the tagged index.
+	if images.IsIndexType(desc.MediaType) {
+		removeChildDigestImages(ctx, t.client, t.canonicalRepo, desc)
+	}
+
+	return nil
+}
+
+// routedNamespace returns the containerd namespace from the routing annotation of the manifest with the given
+// descriptor. It returns an empty string if routing is disabled, the annotation is not set, or it's the client's
+// default namespace.
+func (t *tagService) routedNamespace(ctx context.Context, desc distribution.Descriptor) (string, error) {
+	if t.namespaceAnnotation == "" {
+		return "", nil
+	}
+
+	blob, err := content.ReadBlob(ctx, t.client.ContentStore(), desc)
+	if err != nil {
+		return "", fmt.Errorf("read manifest '%s' from containerd content store: %w", desc.Digest, err)
+	}
+	// Both image index and manifest have annotations at the top level.
+	var manifest struct {
+		Annotations map[string]string `json:"annotations"`
+	}
+	if err = json.Unmarshal(blob, &manifest); err != nil {
+		return "", fmt.Errorf("unmarshal manifest '%s': %w", desc.Digest, err)
+	}
+
+	namespace := manifest.Annotations[t.namespaceAnnotation]
+	if namespace == "" || namespace == t.client.DefaultNamespace() {
+		return "", nil
+	}
+	if err = identifiers.Validate(namespace); err != nil {
+		return "", errcode.ErrorCodeManifestInvalid.WithDetail(
+			fmt.Sprintf("invalid containerd namespace in annotation '%s': %v", t.namespaceAnnotation, err))
+	}
+	return namespace, nil
+}
+
+// createImage creates or updates the image with the given reference in the containerd image store. The descriptor must
+// be an image/index manifest that is already present in the containerd content store.
+// It also sets garbage collection labels on the image content in the containerd content store to prevent it from being
+// deleted by garbage collection. The content of a partial image, e.g. pulled or saved for some platforms only, may be
+// missing in the content store, otherwise all content must be present.
+func createImage(
+	ctx context.Context, client *client.Client, ref reference.Reference, desc distribution.Descriptor, partial bool,
+) error {
 	img := images.Image{
 		Name:   ref.String(),
 		Target: desc,
@@ -73,18 +305,22 @@ func (t *tagService) Tag(ctx context.Context, tag string, desc distribution.Desc
 	// See for more details:
 	// https://github.com/containerd/containerd/blob/main/docs/garbage-collection.md#garbage-collection-labels
 	//
-	// TODO: delete unnecessary leases after setting the GC labels. It seems to be non-trivial to do so, because we need
-	//  to keep track of which leases were used to upload which content and share this info between
-	//  the blobStore/blobWriter and tagService. The downside of keeping them around is the image content will be kept
-	//  in the store even if the image is deleted, until the leases expire (default is leaseExpiration).
+	// The leases of the uploaded content are released after creating the image by PushTransactions if enabled, or
+	// by uploadLeases otherwise. Content uploaded before a restart of the registry is kept in the store even if
+	// the image is deleted, until its upload leases expire (defaultLeaseExpiration unless configured).
 
-	contentStore := t.client.ContentStore()
-	// Get all the children descriptors (manifests, config, layers) for an image index or manifest.
+	contentStore := client.ContentStore()
+	// Get all the children descriptors (manifests, config, layers) for an image index or manifest. Missing children,
+	// e.g. manifests for other platforms of a pulled multi-platform image being migrated, are only skipped for partial
+	// images so that a push can't create an image with missing content.
 	childrenHandler := images.ChildrenHandler(contentStore)
+	if partial {
+		childrenHandler = presentChildrenHandler(contentStore)
+	}
 	// Recursively set garbage collection labels on each descriptor for the content of its children to prevent them
 	// from being deleted by GC.
 	setGCLabelsHandler := images.SetChildrenMappedLabels(contentStore, childrenHandler, nil)
-	if err = images.Dispatch(ctx, setGCLabelsHandler, nil, desc); err != nil {
+	if err := images.Dispatch(ctx, setGCLabelsHandler, nil, desc); err != nil {
 		return fmt.Errorf(
 			"set garbage collection labels for content of image '%s' in containerd content store: %w", ref.String(),
 			err,
@@ -98,14 +334,23 @@ func (t *tagService) Tag(ctx context.Context, tag string, desc distribution.Desc
 	)
 	log.Debug("Set garbage collection labels for image content in containerd content store.")
 
-	imageService := t.client.ImageService()
-	if _, err = imageService.Create(ctx, img); err != nil {
+	// OCI artifacts, e.g. Helm charts or WASM modules, are stored like images. Their artifact type is kept in a label
+	// as the image target descriptor can't hold it.
+	artifactType, err := manifestArtifactType(ctx, contentStore, desc)
+	if err != nil {
+		log.WithError(err).Warn("Failed to get artifact type of image manifest.")
+	} else if artifactType != "" {
+		img.Labels = map[string]string{artifactTypeLabel: artifactType}
+		log = log.WithField("artifacttype", artifactType)
+	}
+
+	imageService := client.ImageService()
+	if _, err := imageService.Create(ctx, img); err != nil {
 		if !errdefs.IsAlreadyExists(err) {
 			return fmt.Errorf("create image '%s' in containerd image store: %w", ref.String(), err)
 		}
 
-		_, err = imageService.Update(ctx, img)
-		if err != nil {
+		if _, err = imageService.Update(ctx, img); err != nil {
 			return fmt.Errorf("update image '%s' in containerd image store: %w", ref.String(), err)
 		}
 
@@ -117,18 +362,182 @@ func (t *tagService) Tag(ctx context.Context, tag string, desc distribution.Desc
 	return nil
 }
 
-// Untag is not supported for simplicity.
-// An image could be untagged by deleting the image in containerd.
+// presentChildrenHandler returns a handler that returns the children descriptors of the descriptor that are present
+// in the content store.
+func presentChildrenHandler(store content.Store) images.HandlerFunc {
+	return func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		children, err := images.Children(ctx, store, desc)
+		if err != nil {
+			return nil, err
+		}
+
+		present := children[:0]
+		for _, child := range children {
+			if _, err = store.Info(ctx, child.Digest); err != nil {
+				if errdefs.IsNotFound(err) {
+					continue
+				}
+				return nil, fmt.Errorf(
+					"get metadata for blob '%s' from containerd content store: %w", child.Digest, err,
+				)
+			}
+			present = append(present, child)
+		}
+		return present, nil
+	}
+}
+
+// digestPushLabel is the containerd image label that marks a digest-addressed image created by a manifest push by
+// digest, e.g. a platform manifest of a multi-platform image pushed before its index.
+const digestPushLabel = "unregistry.push.digest"
+
+// markDigestPush marks the digest-addressed image created by a manifest push with digestPushLabel. Errors are logged
+// but not returned as an unmarked image is only kept longer than needed.
+func markDigestPush(ctx context.Context, client *client.Client, ref reference.Canonical) {
+	img := images.Image{Name: ref.String(), Labels: map[string]string{digestPushLabel: "true"}}
+	if _, err := client.ImageService().Update(ctx, img, "labels."+digestPushLabel); err != nil {
+		logrus.WithField("image", ref.String()).WithError(err).Warn(
+			"Failed to label digest-addressed image in containerd image store.")
+	}
+}
+
+// removeChildDigestImages removes the digest-addressed images in the repository for the manifests referenced by
+// the index that were created by pushes by digest. Digest-addressed images created otherwise, e.g. imported or pulled
+// by digest, are kept. Errors are logged but not returned as the removal is best-effort.
+func removeChildDigestImages(
+	ctx context.Context, client *client.Client, repo reference.Named, index ocispec.Descriptor,
+) {
+	children, err := images.Children(ctx, client.ContentStore(), index)
+	if err != nil {
+		logrus.WithField("descriptor", index).WithError(err).Warn("Failed to get manifests of image index.")
+		return
+	}
+
+	imageService := client.ImageService()
+	for _, child := range children {
+		if !images.IsManifestType(child.MediaType) && !images.IsIndexType(child.MediaType) {
+			continue
+		}
+		ref, err := reference.WithDigest(repo, child.Digest)
+		if err != nil {
+			continue
+		}
+		img, err := imageService.Get(ctx, ref.String())
+		if err != nil || img.Labels[digestPushLabel] != "true" {
+			continue
+		}
+		if err = imageService.Delete(ctx, ref.String()); err != nil {
+			if !errdefs.IsNotFound(err) {
+				logrus.WithField("image", ref.String()).WithError(err).Warn(
+					"Failed to delete digest-addressed image from containerd image store.")
+			}
+			continue
+		}
+		logrus.WithField("image", ref.String()).Debug(
+			"Deleted digest-addressed image referenced by image index from containerd image store.")
+	}
+}
+
+// Untag deletes the image with the tag from the containerd image store. Its content is deleted by the containerd
+// garbage collection unless it's referenced by other images.
 func (t *tagService) Untag(ctx context.Context, tag string) error {
-	return distribution.ErrUnsupported
+	if !t.deletes {
+		return distribution.ErrUnsupported
+	}
+	defer t.quota.invalidate(t.repo)
+	ref, err := reference.WithTag(t.canonicalRepo, tag)
+	if err != nil {
+		return distribution.ErrTagUnknown{Tag: tag}
+	}
+	if t.dryRun != nil {
+		logrus.WithField("image", ref.String()).Debug("Skipped deleting image in dry-run mode.")
+		return nil
+	}
+
+	imageService := t.client.ImageService()
+	img, err := imageService.Get(ctx, ref.String())
+	if err == nil {
+		err = imageService.Delete(ctx, ref.String())
+	}
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return distribution.ErrTagUnknown{Tag: tag}
+		}
+		return fmt.Errorf("delete image '%s' from containerd image store: %w", ref.String(), err)
+	}
+	logrus.WithField("image", ref.String()).Info("Deleted image from containerd image store.")
+	t.events.Publish(events.Event{
+		Type:      events.TypeDelete,
+		Image:     ref.String(),
+		Digest:    img.Target.Digest,
+		MediaType: img.Target.MediaType,
+	})
+
+	return nil
 }
 
-// All should return all tags associated with the repository but discovery operations are not supported for simplicity.
+// All returns the sorted tags of the images in the repository from the containerd image store. It returns
+// distribution.ErrRepositoryUnknown if there are no images in the repository, neither tagged nor digest-addressed.
 func (t *tagService) All(ctx context.Context) ([]string, error) {
-	return nil, distribution.ErrUnsupported
+	imgs, err := repositoryImages(ctx, t.client, t.canonicalRepo)
+	if err != nil {
+		return nil, err
+	}
+	if len(imgs) == 0 {
+		return nil, distribution.ErrRepositoryUnknown{Name: t.repo.Name()}
+	}
+
+	tags := []string{}
+	for _, img := range imgs {
+		if tagged, ok := img.ref.(reference.Tagged); ok {
+			tags = append(tags, tagged.Tag())
+		}
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags), nil
 }
 
-// Lookup should find tags associated with a descriptor but discovery operations are not supported for simplicity.
+// Lookup returns the sorted tags of the images in the repository that point to the descriptor.
 func (t *tagService) Lookup(ctx context.Context, desc distribution.Descriptor) ([]string, error) {
-	return nil, distribution.ErrUnsupported
+	imgs, err := repositoryImages(ctx, t.client, t.canonicalRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for _, img := range imgs {
+		if tagged, ok := img.ref.(reference.Tagged); ok && img.Target.Digest == desc.Digest {
+			tags = append(tags, tagged.Tag())
+		}
+	}
+	slices.Sort(tags)
+	return tags, nil
+}
+
+// repositoryImage is an image in the containerd image store with its parsed reference.
+type repositoryImage struct {
+	images.Image
+	ref reference.Named
+}
+
+// repositoryImages returns the tagged and digest-addressed images in the repository from the containerd image store.
+func repositoryImages(ctx context.Context, client *client.Client, repo reference.Named) ([]repositoryImage, error) {
+	imgs, err := client.ImageService().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list images in containerd image store: %w", err)
+	}
+
+	var res []repositoryImage
+	for _, img := range imgs {
+		// Fast path to skip images from other repositories before parsing the reference.
+		if !strings.HasPrefix(img.Name, repo.Name()) {
+			continue
+		}
+		ref, err := reference.ParseNamed(img.Name)
+		if err != nil || ref.Name() != repo.Name() {
+			continue
+		}
+		res = append(res, repositoryImage{Image: img, ref: ref})
+	}
+	return res, nil
 }
@@ -0,0 +1,167 @@
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/psviderski/unregistry/internal/registrytest"
+)
+
+func TestTagServiceAll(t *testing.T) {
+	cli := registrytest.NewClient(t,
+		"docker.io/library/ubuntu:24.04",
+		"docker.io/library/ubuntu:22.04",
+		"docker.io/library/ubuntu@"+digest.FromString("untagged").String(),
+		"docker.io/library/ubuntu-base:latest",
+		"docker.io/myorg/ubuntu:latest",
+		"docker.io/myorg/digest-only@"+digest.FromString("digest-only").String(),
+	)
+	reg := &registry{client: cli}
+
+	tests := []struct {
+		repo    string
+		want    []string
+		wantErr bool
+	}{
+		{repo: "ubuntu", want: []string{"22.04", "24.04"}},
+		{repo: "docker.io/library/ubuntu", want: []string{"22.04", "24.04"}},
+		{repo: "myorg/digest-only", want: []string{}},
+		{repo: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.repo, func(t *testing.T) {
+			named, err := reference.WithName(tt.repo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo, err := reg.Repository(context.Background(), named)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tags, err := repo.Tags(context.Background()).All(context.Background())
+			if tt.wantErr {
+				var unknown distribution.ErrRepositoryUnknown
+				if !errors.As(err, &unknown) {
+					t.Fatalf("expected ErrRepositoryUnknown, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(tags, tt.want) {
+				t.Fatalf("expected tags %v, got %v", tt.want, tags)
+			}
+		})
+	}
+}
+
+func TestTagsListPagination(t *testing.T) {
+	app := newTestApp(t, registrytest.NewClient(t,
+		"docker.io/library/ubuntu:20.04",
+		"docker.io/library/ubuntu:22.04",
+		"docker.io/library/ubuntu:24.04",
+	))
+
+	get := func(path string) ([]string, string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d for %s, got %d: %s", http.StatusOK, path, rec.Code, rec.Body.String())
+		}
+		var body struct {
+			Name string   `json:"name"`
+			Tags []string `json:"tags"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Name != "ubuntu" {
+			t.Fatalf("expected repository name ubuntu, got %q", body.Name)
+		}
+		return body.Tags, rec.Header().Get("Link")
+	}
+
+	tags, link := get("/v2/ubuntu/tags/list?n=2")
+	if !slices.Equal(tags, []string{"20.04", "22.04"}) {
+		t.Fatalf("expected the first page [20.04 22.04], got %v", tags)
+	}
+	if want := `</v2/ubuntu/tags/list?last=22.04&n=2>; rel="next"`; link != want {
+		t.Fatalf("expected Link header %q, got %q", want, link)
+	}
+
+	tags, link = get("/v2/ubuntu/tags/list?n=2&last=22.04")
+	if !slices.Equal(tags, []string{"24.04"}) {
+		t.Fatalf("expected the last page [24.04], got %v", tags)
+	}
+	if link != "" {
+		t.Fatalf("expected no Link header on the last page, got %q", link)
+	}
+
+	tags, link = get("/v2/ubuntu/tags/list")
+	if len(tags) != 3 || link != "" {
+		t.Fatalf("expected all 3 tags without a Link header, got %v and %q", tags, link)
+	}
+
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v2/missing/tags/list", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d for an unknown repository, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestRemoveChildDigestImages(t *testing.T) {
+	ctx := context.Background()
+	cli := registrytest.NewClient(t)
+	pushed := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("pushed"), Size: 1}
+	imported := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("imported"), Size: 1,
+	}
+	index, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{pushed, imported},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexDesc := registrytest.WriteBlob(t, cli.ContentStore(), ocispec.MediaTypeImageIndex, index)
+
+	repo, err := reference.ParseNormalizedNamed("myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := map[digest.Digest]reference.Canonical{}
+	for _, desc := range []ocispec.Descriptor{pushed, imported} {
+		ref, err := reference.WithDigest(repo, desc.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = cli.ImageService().Create(ctx, images.Image{Name: ref.String(), Target: desc}); err != nil {
+			t.Fatal(err)
+		}
+		refs[desc.Digest] = ref
+	}
+	markDigestPush(ctx, cli, refs[pushed.Digest])
+
+	removeChildDigestImages(ctx, cli, repo, indexDesc)
+	if _, err = cli.ImageService().Get(ctx, refs[pushed.Digest].String()); !errdefs.IsNotFound(err) {
+		t.Fatalf("expected the image created by the push by digest to be removed, got %v", err)
+	}
+	if _, err = cli.ImageService().Get(ctx, refs[imported.Digest].String()); err != nil {
+		t.Fatalf("expected the image not created by a push to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// pushRepoLabel is the containerd lease label with the repository name of the push transaction lease.
+	pushRepoLabel = "unregistry.push.repo"
+	// pushLeaseExpiration is the expiration of a push transaction lease. The lease is deleted by the registry when
+	// the push completes or times out so the expiration only limits how long the content is retained if the registry
+	// dies in the middle of a push.
+	pushLeaseExpiration = 24 * time.Hour
+)
+
+// PushTransactions groups the content uploaded by pushes to each repository into a transaction backed by a single
+// containerd lease. When an image referencing the content is created, the content is promoted: removed from the lease
+// as it's now retained by the garbage collection labels of the image. If no blobs or manifests are uploaded to
+// the repository for the timeout and the uploaded content isn't referenced by an image, the push is considered failed
+// and its content is deleted right away rather than lingering until the lease expires.
+// A nil PushTransactions disables transactions and the uploaded content is retained by the upload leases until they
+// expire. It's safe for concurrent use.
+type PushTransactions struct {
+	client  *client.Client
+	timeout time.Duration
+
+	mu   sync.Mutex
+	txns map[string]*pushTransaction
+	// uploads is the number of in-progress uploads per repository. A transaction isn't aborted while its blobs are
+	// still being uploaded.
+	uploads map[string]int
+}
+
+// pushTransaction is the content uploaded by an in-progress push to a repository.
+type pushTransaction struct {
+	repo  string
+	lease leases.Lease
+	blobs map[digest.Digest]struct{}
+	// last is the time of the last upload activity.
+	last  time.Time
+	timer *time.Timer
+}
+
+// NewPushTransactions creates push transactions that are aborted after the given timeout of inactivity. It returns
+// nil if the timeout is 0 which disables transactions.
+func NewPushTransactions(cli *client.Client, timeout time.Duration) *PushTransactions {
+	if timeout <= 0 {
+		return nil
+	}
+	return &PushTransactions{
+		client:  cli,
+		timeout: timeout,
+		txns:    make(map[string]*pushTransaction),
+		uploads: make(map[string]int),
+	}
+}
+
+// add adds the committed blob to the transaction of the push to the repository starting a new transaction if needed.
+func (t *PushTransactions) add(ctx context.Context, repo string, dgst digest.Digest) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	txn, ok := t.txns[repo]
+	if !ok {
+		lease, err := t.client.LeasesService().Create(ctx,
+			leases.WithRandomID(),
+			leases.WithExpiration(pushLeaseExpiration),
+			leases.WithLabel(leaseTypeLabel, leaseTypeUpload),
+			leases.WithLabel(pushRepoLabel, repo),
+		)
+		if err != nil {
+			return fmt.Errorf("create containerd push lease: %w", err)
+		}
+		txn = &pushTransaction{
+			repo:  repo,
+			lease: lease,
+			blobs: make(map[digest.Digest]struct{}),
+		}
+		txn.timer = time.AfterFunc(t.timeout, func() { t.expire(txn) })
+		t.txns[repo] = txn
+		logrus.WithFields(logrus.Fields{
+			"repo":  repo,
+			"lease": lease.ID,
+		}).Debug("Started push transaction.")
+	}
+
+	resource := leases.Resource{ID: dgst.String(), Type: "content"}
+	if err := t.client.LeasesService().AddResource(ctx, txn.lease, resource); err != nil {
+		return fmt.Errorf("add blob '%s' to containerd push lease: %w", dgst, err)
+	}
+	txn.blobs[dgst] = struct{}{}
+	txn.last = time.Now()
+	return nil
+}
+
+// uploading marks an upload of blob data to the repository as in progress so that the transaction of the push isn't
+// aborted while a large blob is being uploaded. The returned function must be called when the upload is done.
+func (t *PushTransactions) uploading(repo string) func() {
+	if t == nil {
+		return func() {}
+	}
+	t.mu.Lock()
+	t.uploads[repo]++
+	t.mu.Unlock()
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.uploads[repo]--; t.uploads[repo] <= 0 {
+			delete(t.uploads, repo)
+		}
+		if txn, ok := t.txns[repo]; ok {
+			txn.last = time.Now()
+		}
+	}
+}
+
+// promote removes the content of the image with the given target descriptor from the transaction of the push to
+// the repository as it's now retained by the image. The transaction is completed once all its content is promoted.
+// Errors are logged but not returned as the content would be retained by the lease until it expires in the worst
+// case.
+func (t *PushTransactions) promote(ctx context.Context, repo string, target ocispec.Descriptor) {
+	if t == nil {
+		return
+	}
+	referenced, err := imageContent(ctx, t.client, target)
+	if err != nil {
+		logrus.WithField("repo", repo).WithError(err).Warn("Failed to walk image content to complete push transaction.")
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	txn, ok := t.txns[repo]
+	if !ok {
+		return
+	}
+	leasesService := t.client.LeasesService()
+	for _, dgst := range referenced {
+		if _, ok = txn.blobs[dgst]; !ok {
+			continue
+		}
+		resource := leases.Resource{ID: dgst.String(), Type: "content"}
+		if err := leasesService.DeleteResource(ctx, txn.lease, resource); err != nil && !errdefs.IsNotFound(err) {
+			logrus.WithFields(logrus.Fields{
+				"repo":   repo,
+				"digest": dgst,
+			}).WithError(err).Warn("Failed to remove blob from containerd push lease.")
+			continue
+		}
+		delete(txn.blobs, dgst)
+	}
+
+	if len(txn.blobs) > 0 {
+		return
+	}
+	txn.timer.Stop()
+	delete(t.txns, repo)
+	if err := leasesService.Delete(context.WithoutCancel(ctx), txn.lease); err != nil && !errdefs.IsNotFound(err) {
+		logrus.WithField("lease", txn.lease.ID).WithError(err).Debug("Failed to delete containerd push lease.")
+	}
+	logrus.WithField("repo", repo).Debug("Completed push transaction: all uploaded content is referenced by images.")
+}
+
+// expire aborts the transaction if it's been inactive for the timeout, otherwise it reschedules the check.
+func (t *PushTransactions) expire(txn *pushTransaction) {
+	t.mu.Lock()
+	if t.txns[txn.repo] != txn {
+		t.mu.Unlock()
+		return
+	}
+	if t.uploads[txn.repo] > 0 {
+		txn.timer.Reset(t.timeout)
+		t.mu.Unlock()
+		return
+	}
+	if remaining := t.timeout - time.Since(txn.last); remaining > 0 {
+		txn.timer.Reset(remaining)
+		t.mu.Unlock()
+		return
+	}
+	// A new push to the repository starts a new transaction while the content of this one is being deleted.
+	delete(t.txns, txn.repo)
+	t.mu.Unlock()
+
+	log := logrus.WithFields(logrus.Fields{
+		"repo":  txn.repo,
+		"blobs": len(txn.blobs),
+	})
+	// Delete the lease synchronously to run the garbage collection and delete the content right away.
+	if err := t.client.LeasesService().Delete(context.Background(), txn.lease, leases.SynchronousDelete); err != nil &&
+		!errdefs.IsNotFound(err) {
+		log.WithError(err).Warn("Failed to delete containerd lease of timed out push.")
+		return
+	}
+	log.Warn("Push timed out without a manifest referencing the uploaded content, deleted unreferenced content.")
+}
+
+// imageContent returns the digests of the image with the given target descriptor and all its content present in
+// the containerd content store.
+func imageContent(ctx context.Context, cli *client.Client, target ocispec.Descriptor) ([]digest.Digest, error) {
+	var referenced []digest.Digest
+	collect := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+		referenced = append(referenced, desc.Digest)
+		return nil, nil
+	})
+	handler := images.Handlers(collect, presentChildrenHandler(cli.ContentStore()))
+	if err := images.Walk(ctx, handler, target); err != nil {
+		return nil, err
+	}
+	return referenced, nil
+}
@@ -0,0 +1,99 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/containerd/v2/plugins"
+	"github.com/containerd/platforms"
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// leaseTypeUnpack is the type of lease retaining the snapshots of an image while it's unpacked in the background.
+	leaseTypeUnpack = "unpack"
+	// unpackLeaseExpiration is the expiration of an unpack lease. The lease is deleted as soon as the image is
+	// unpacked so the expiration only limits how long the snapshots are retained if the registry dies meanwhile.
+	unpackLeaseExpiration = 1 * time.Hour
+)
+
+// unpackImageInBackground unpacks the image with unpackImage without delaying the push that created it. The unpack
+// isn't canceled when ctx is done and is retained by its own lease instead of the leases of the push that may be
+// deleted before it finishes. Failures are only logged as the image is usable without unpacking.
+func unpackImageInBackground(
+	ctx context.Context, cli *client.Client, ref reference.Reference, desc distribution.Descriptor, snapshotter string,
+	platform platforms.MatchComparer,
+) {
+	ctx = context.WithoutCancel(ctx)
+	log := logrus.WithField("image", ref.String())
+	go func() {
+		leasesService := cli.LeasesService()
+		lease, err := leasesService.Create(ctx,
+			leases.WithRandomID(),
+			leases.WithExpiration(unpackLeaseExpiration),
+			leases.WithLabel(leaseTypeLabel, leaseTypeUnpack),
+		)
+		if err != nil {
+			log.WithError(err).Warn("Failed to unpack pushed image: create containerd lease.")
+			return
+		}
+		defer func() {
+			if err := leasesService.Delete(ctx, lease); err != nil {
+				log.WithField("lease", lease.ID).WithError(err).Debug("Failed to delete containerd unpack lease.")
+			}
+		}()
+
+		if err = unpackImage(leases.WithLease(ctx, lease.ID), cli, ref, desc, snapshotter, platform); err != nil {
+			log.WithError(err).Warn("Failed to unpack pushed image.")
+		}
+	}()
+}
+
+// unpackImage unpacks the layers of the image for the platform into the snapshotter so that containers can be started
+// from it right away instead of unpacking it on the first run.
+func unpackImage(
+	ctx context.Context, cli *client.Client, ref reference.Reference, desc distribution.Descriptor, snapshotter string,
+	platform platforms.MatchComparer,
+) error {
+	img := client.NewImageWithPlatform(cli, images.Image{Name: ref.String(), Target: desc}, platform)
+	unpacked, err := img.IsUnpacked(ctx, snapshotter)
+	if err != nil {
+		return fmt.Errorf("check if image '%s' is unpacked into snapshotter '%s': %w", ref.String(), snapshotter, err)
+	}
+	if unpacked {
+		return nil
+	}
+
+	start := time.Now()
+	if err = img.Unpack(ctx, snapshotter); err != nil {
+		return fmt.Errorf("unpack image '%s' into snapshotter '%s': %w", ref.String(), snapshotter, err)
+	}
+	logrus.WithFields(logrus.Fields{
+		"image":       ref.String(),
+		"snapshotter": snapshotter,
+		"duration":    time.Since(start).Round(time.Millisecond),
+	}).Info("Unpacked pushed image.")
+	return nil
+}
+
+// CheckSnapshotter returns an error if the snapshotter isn't available in containerd.
+func CheckSnapshotter(ctx context.Context, cli *client.Client, snapshotter string) error {
+	resp, err := cli.IntrospectionService().Plugins(ctx,
+		"type=="+string(plugins.SnapshotPlugin)+",id=="+snapshotter)
+	if err != nil {
+		return fmt.Errorf("list containerd snapshotter plugins: %w", err)
+	}
+	if len(resp.Plugins) == 0 {
+		return fmt.Errorf("containerd snapshotter '%s' not found", snapshotter)
+	}
+	if initErr := resp.Plugins[0].InitErr; initErr != nil {
+		return fmt.Errorf("containerd snapshotter '%s' failed to initialize: %s", snapshotter, initErr.Message)
+	}
+	return nil
+}
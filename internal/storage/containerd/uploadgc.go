@@ -0,0 +1,118 @@
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/errdefs"
+	"github.com/psviderski/unregistry/internal/metadata"
+)
+
+// PurgedUpload is an unfinished blob upload aborted because it hasn't received data for too long.
+type PurgedUpload struct {
+	ID string `json:"id"`
+	// Repo is the repository the blob was uploaded to if the upload session was known.
+	Repo      string    `json:"repo,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// Size is the number of bytes written to the containerd ingest that have been discarded.
+	Size int64 `json:"size"`
+}
+
+// UploadPurge describes the result of purging stale blob uploads.
+type UploadPurge struct {
+	Purged []PurgedUpload `json:"purged"`
+	// PurgedBytes is the total size of the discarded data of the purged uploads.
+	PurgedBytes int64 `json:"purgedBytes"`
+	// Sessions is the number of persisted upload sessions removed whose containerd ingest no longer exists.
+	Sessions int `json:"sessions"`
+}
+
+// PurgeStaleUploads aborts the containerd content ingests of the blob uploads that haven't received data for longer
+// than maxAge, e.g. left behind by crashed or interrupted pushes, and deletes their upload sessions and leases.
+// It also deletes the upload sessions that have lost their ingest and were started longer than maxAge ago.
+// A purged upload can't be resumed so the client has to start it over.
+func PurgeStaleUploads(
+	ctx context.Context, cli *client.Client, store metadata.Store, maxAge time.Duration,
+) (UploadPurge, error) {
+	contentStore := cli.ContentStore()
+	statuses, err := contentStore.ListStatuses(ctx)
+	if err != nil {
+		return UploadPurge{}, fmt.Errorf("list ingests in containerd content store: %w", err)
+	}
+
+	var purge UploadPurge
+	ingests := make(map[string]struct{}, len(statuses))
+	for _, status := range statuses {
+		id, ok := strings.CutPrefix(status.Ref, uploadRef(""))
+		if !ok {
+			continue
+		}
+		ingests[id] = struct{}{}
+		if time.Since(status.UpdatedAt) <= maxAge {
+			continue
+		}
+
+		if err = contentStore.Abort(ctx, status.Ref); err != nil && !errdefs.IsNotFound(err) {
+			return purge, fmt.Errorf("abort ingest '%s' in containerd content store: %w", status.Ref, err)
+		}
+		upload := PurgedUpload{
+			ID:        id,
+			StartedAt: status.StartedAt,
+			UpdatedAt: status.UpdatedAt,
+			Size:      status.Offset,
+		}
+		var session uploadSession
+		if err = store.Get(metadata.BucketUploads, id, &session); err == nil {
+			upload.Repo = session.Repo
+		}
+		if err = deleteUploadSession(ctx, cli, store, id, session.LeaseID); err != nil {
+			return purge, err
+		}
+		purge.Purged = append(purge.Purged, upload)
+		purge.PurgedBytes += upload.Size
+	}
+
+	// Collect the orphaned sessions first as the store can't be modified while listing.
+	orphaned := make(map[string]string)
+	err = store.List(metadata.BucketUploads, func(id string, value []byte) error {
+		if _, ok := ingests[id]; ok {
+			return nil
+		}
+		var session uploadSession
+		if err := json.Unmarshal(value, &session); err != nil || time.Since(session.StartedAt) > maxAge {
+			orphaned[id] = session.LeaseID
+		}
+		return nil
+	})
+	if err != nil {
+		return purge, fmt.Errorf("list upload sessions: %w", err)
+	}
+	for id, leaseID := range orphaned {
+		if err = deleteUploadSession(ctx, cli, store, id, leaseID); err != nil {
+			return purge, err
+		}
+		purge.Sessions++
+	}
+
+	return purge, nil
+}
+
+// deleteUploadSession deletes the persisted session of the upload with the ID and its containerd lease if known.
+func deleteUploadSession(ctx context.Context, cli *client.Client, store metadata.Store, id, leaseID string) error {
+	if leaseID != "" {
+		err := cli.LeasesService().Delete(ctx, leases.Lease{ID: leaseID})
+		if err != nil && !errdefs.IsNotFound(err) {
+			return fmt.Errorf("delete containerd lease '%s' of upload '%s': %w", leaseID, id, err)
+		}
+	}
+	if err := store.Delete(metadata.BucketUploads, id); err != nil {
+		return fmt.Errorf("delete upload session '%s': %w", id, err)
+	}
+	return nil
+}
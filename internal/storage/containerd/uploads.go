@@ -0,0 +1,156 @@
+package containerd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"time"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/metadata"
+)
+
+// leaseExpireLabel is the containerd lease label with the expiration time set by leases.WithExpiration.
+const leaseExpireLabel = "containerd.io/gc.expire"
+
+// uploadSession is the persisted state of an unfinished blob upload. It lets the upload resume at the right offset
+// with the same containerd lease in the next request, and after the registry restarts if the metadata store is
+// persistent.
+type uploadSession struct {
+	// Repo is the normalized name of the repository the blob is uploaded to.
+	Repo string `json:"repo"`
+	// LeaseID is the containerd lease that retains the ingest of the upload.
+	LeaseID   string    `json:"leaseID"`
+	StartedAt time.Time `json:"startedAt"`
+	// Offset is the number of bytes written when the upload request finished.
+	Offset int64 `json:"offset"`
+	// HashState is the marshalled state of the SHA-256 hash of the written data used to continue computing the digest
+	// of the upload in the next request.
+	HashState []byte `json:"hashState,omitempty"`
+}
+
+// uploadRef returns the containerd content ingest reference of the upload with the ID.
+func uploadRef(id string) string {
+	return "upload-" + id
+}
+
+// loadUploadSession returns the persisted session of the upload with the ID in the repository. If the session isn't
+// persisted, e.g. the registry restarted with a non-persistent metadata store, the upload is resumed from its
+// containerd ingest if it still exists. It returns distribution.ErrBlobUploadUnknown if the upload can't be resumed.
+func loadUploadSession(
+	ctx context.Context, cli *client.Client, store metadata.Store, repo, id string,
+) (uploadSession, error) {
+	var session uploadSession
+	err := store.Get(metadata.BucketUploads, id, &session)
+	if err == nil {
+		if session.Repo != repo {
+			return uploadSession{}, distribution.ErrBlobUploadUnknown
+		}
+		return session, nil
+	}
+	if !errors.Is(err, metadata.ErrNotFound) {
+		return uploadSession{}, fmt.Errorf("get upload session '%s': %w", id, err)
+	}
+
+	status, err := cli.ContentStore().Status(ctx, uploadRef(id))
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return uploadSession{}, distribution.ErrBlobUploadUnknown
+		}
+		return uploadSession{}, fmt.Errorf("get status of upload '%s' from containerd content store: %w", id, err)
+	}
+	return uploadSession{Repo: repo, StartedAt: status.StartedAt, Offset: status.Offset}, nil
+}
+
+// newUploadHash returns the SHA-256 hash of the upload data restored from the marshalled state. It returns a new hash
+// if the state is empty, or nil if the state can't be restored.
+func newUploadHash(state []byte) hash.Hash {
+	h := sha256.New()
+	if len(state) == 0 {
+		return h
+	}
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil
+	}
+	return h
+}
+
+// uploadLease returns the containerd lease with the ID if it still exists and doesn't expire soon, otherwise it creates
+// a new lease for an upload. The ingest of a resumed upload is added to the new lease when the writer is opened with
+// it so that a long upload isn't garbage collected when its first lease expires.
+func uploadLease(ctx context.Context, cli *client.Client, id string, expiration time.Duration) (leases.Lease, error) {
+	if id != "" {
+		existing, err := cli.LeasesService().List(ctx, "id=="+id)
+		if err != nil {
+			return leases.Lease{}, fmt.Errorf("list containerd leases: %w", err)
+		}
+		if len(existing) > 0 {
+			expire, err := time.Parse(time.RFC3339Nano, existing[0].Labels[leaseExpireLabel])
+			if err == nil && time.Until(expire) > expiration/2 {
+				return existing[0], nil
+			}
+		}
+	}
+
+	lease, err := cli.LeasesService().Create(ctx,
+		leases.WithRandomID(),
+		leases.WithExpiration(expiration),
+		leases.WithLabel(leaseTypeLabel, leaseTypeUpload),
+	)
+	if err != nil {
+		return leases.Lease{}, fmt.Errorf("create containerd lease: %w", err)
+	}
+	return lease, nil
+}
+
+// requestedUploadOffset returns the offset the client resumes the upload at from the upload state in the request URL.
+// The state is signed by the distribution upload handler which verifies it before resuming the upload, so it's only
+// decoded here. ok is false if the request doesn't carry the state, e.g. the upload status requests.
+func requestedUploadOffset(ctx context.Context) (offset int64, ok bool) {
+	req, _ := ctx.Value("http.request").(*http.Request)
+	if req == nil || req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return 0, false
+	}
+	token, err := base64.URLEncoding.DecodeString(req.URL.Query().Get("_state"))
+	// The token is the HMAC-SHA256 of the JSON-encoded state followed by the state.
+	if err != nil || len(token) <= sha256.Size {
+		return 0, false
+	}
+	var state struct {
+		Offset int64
+	}
+	if err = json.Unmarshal(token[sha256.Size:], &state); err != nil {
+		return 0, false
+	}
+	return state.Offset, true
+}
+
+// monolithicUpload returns the expected digest and the request of a monolithic blob upload: a POST request starting
+// an upload with the digest in the query and the whole blob in the body. ok is false for regular POST requests that
+// start an upload session and for cross-repository mounts.
+func monolithicUpload(ctx context.Context) (dgst digest.Digest, req *http.Request, ok bool) {
+	req, _ = ctx.Value("http.request").(*http.Request)
+	if req == nil || req.Method != http.MethodPost || req.ContentLength == 0 || req.Body == nil ||
+		req.Body == http.NoBody {
+		return "", nil, false
+	}
+	query := req.URL.Query()
+	if query.Get("mount") != "" {
+		return "", nil, false
+	}
+	dgst, err := digest.Parse(query.Get("digest"))
+	if err != nil {
+		return "", nil, false
+	}
+	return dgst, req, true
+}
@@ -0,0 +1,391 @@
+package containerd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The patterns from the OCI image spec JSON schemas (defs-descriptor.json).
+var (
+	schemaMediaTypeRegexp = regexp.MustCompile(
+		`^[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}/[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}$`)
+	schemaDigestRegexp = regexp.MustCompile(`^[a-z0-9]+(?:[+._-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+)
+
+// schemaValidator validates decoded JSON documents against the rules of the OCI image spec JSON schemas and collects
+// all violations with JSON paths to the invalid values, e.g. "layers[2].digest: invalid digest format".
+// Docker schema2 manifests, manifest lists and image configs are validated against the equivalent OCI schemas as
+// they are structurally compatible.
+type schemaValidator struct {
+	errs []string
+}
+
+// validateManifestSchema validates the image manifest or index payload of the given media type. It returns
+// a MANIFEST_INVALID error with all violations as the detail if the payload is invalid.
+func validateManifestSchema(mediaType string, payload []byte) error {
+	v := &schemaValidator{}
+	doc, ok := v.decode(payload)
+	if !ok {
+		return v.err()
+	}
+
+	switch {
+	case images.IsIndexType(mediaType):
+		v.index(doc)
+	case images.IsManifestType(mediaType):
+		v.manifest(doc)
+	}
+	return v.err()
+}
+
+// validateConfigSchema validates the image config payload. It returns a MANIFEST_INVALID error with all violations
+// as the detail if the payload is invalid.
+func validateConfigSchema(payload []byte) error {
+	v := &schemaValidator{}
+	doc, ok := v.decode(payload)
+	if !ok {
+		return v.err()
+	}
+	v.config(doc)
+	return v.err()
+}
+
+// isImageConfigType returns true if the media type is an OCI or Docker image config that has a JSON schema.
+func isImageConfigType(mediaType string) bool {
+	return mediaType == ocispec.MediaTypeImageConfig || mediaType == images.MediaTypeDockerSchema2Config
+}
+
+func (v *schemaValidator) decode(payload []byte) (any, bool) {
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		v.fail("", "invalid JSON: %v", err)
+		return nil, false
+	}
+	return doc, true
+}
+
+func (v *schemaValidator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return errcode.ErrorCodeManifestInvalid.WithMessage(
+		"manifest invalid: " + strings.Join(v.errs, "; ")).WithDetail(v.errs)
+}
+
+func (v *schemaValidator) fail(path, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if path != "" {
+		msg = path + ": " + msg
+	}
+	v.errs = append(v.errs, msg)
+}
+
+func (v *schemaValidator) manifest(doc any) {
+	obj, ok := v.object("", doc)
+	if !ok {
+		return
+	}
+	v.schemaVersion(obj)
+	v.optional(obj, "", "mediaType", v.mediaType)
+	v.optional(obj, "", "artifactType", v.mediaType)
+	v.required(obj, "", "config", v.descriptor)
+	v.optional(obj, "", "subject", v.descriptor)
+	v.required(obj, "", "layers", func(path string, val any) {
+		layers, ok := v.array(path, val)
+		if !ok {
+			return
+		}
+		if len(layers) == 0 {
+			v.fail(path, "must have at least one layer")
+		}
+		for i, layer := range layers {
+			v.descriptor(fmt.Sprintf("%s[%d]", path, i), layer)
+		}
+	})
+	v.optional(obj, "", "annotations", v.stringMap)
+}
+
+func (v *schemaValidator) index(doc any) {
+	obj, ok := v.object("", doc)
+	if !ok {
+		return
+	}
+	v.schemaVersion(obj)
+	v.optional(obj, "", "mediaType", v.mediaType)
+	v.optional(obj, "", "artifactType", v.mediaType)
+	v.optional(obj, "", "subject", v.descriptor)
+	v.required(obj, "", "manifests", func(path string, val any) {
+		manifests, ok := v.array(path, val)
+		if !ok {
+			return
+		}
+		for i, m := range manifests {
+			mpath := fmt.Sprintf("%s[%d]", path, i)
+			v.descriptor(mpath, m)
+			if mobj, ok := m.(map[string]any); ok {
+				v.optional(mobj, mpath, "platform", v.platform)
+			}
+		}
+	})
+	v.optional(obj, "", "annotations", v.stringMap)
+}
+
+func (v *schemaValidator) config(doc any) {
+	obj, ok := v.object("", doc)
+	if !ok {
+		return
+	}
+	v.required(obj, "", "architecture", v.string)
+	v.required(obj, "", "os", v.string)
+	for _, key := range []string{"created", "author", "variant", "os.version"} {
+		v.optional(obj, "", key, v.string)
+	}
+	v.optional(obj, "", "os.features", v.stringArray)
+	v.required(obj, "", "rootfs", func(path string, val any) {
+		rootfs, ok := v.object(path, val)
+		if !ok {
+			return
+		}
+		v.required(rootfs, path, "type", func(path string, val any) {
+			if s, ok := v.asString(path, val); ok && s != "layers" {
+				v.fail(path, "must be \"layers\", got %q", s)
+			}
+		})
+		v.required(rootfs, path, "diff_ids", v.stringArray)
+	})
+	v.optional(obj, "", "config", func(path string, val any) {
+		cfg, ok := v.object(path, val)
+		if !ok {
+			return
+		}
+		for _, key := range []string{"User", "WorkingDir", "StopSignal"} {
+			v.optional(cfg, path, key, v.string)
+		}
+		v.optional(cfg, path, "Env", v.stringArray)
+		v.optional(cfg, path, "Entrypoint", v.nullable(v.stringArray))
+		v.optional(cfg, path, "Cmd", v.nullable(v.stringArray))
+		v.optional(cfg, path, "ExposedPorts", v.objectMap)
+		v.optional(cfg, path, "Volumes", v.nullable(v.objectMap))
+		v.optional(cfg, path, "Labels", v.nullable(v.stringMap))
+		v.optional(cfg, path, "ArgsEscaped", v.bool)
+	})
+	v.optional(obj, "", "history", func(path string, val any) {
+		history, ok := v.array(path, val)
+		if !ok {
+			return
+		}
+		for i, h := range history {
+			hpath := fmt.Sprintf("%s[%d]", path, i)
+			hobj, ok := v.object(hpath, h)
+			if !ok {
+				continue
+			}
+			for _, key := range []string{"created", "author", "created_by", "comment"} {
+				v.optional(hobj, hpath, key, v.string)
+			}
+			v.optional(hobj, hpath, "empty_layer", v.bool)
+		}
+	})
+}
+
+func (v *schemaValidator) schemaVersion(obj map[string]any) {
+	v.required(obj, "", "schemaVersion", func(path string, val any) {
+		if n, ok := v.integer(path, val); ok && n != 2 {
+			v.fail(path, "must be 2, got %d", n)
+		}
+	})
+}
+
+func (v *schemaValidator) descriptor(path string, val any) {
+	obj, ok := v.object(path, val)
+	if !ok {
+		return
+	}
+	v.required(obj, path, "mediaType", v.mediaType)
+	v.required(obj, path, "size", func(path string, val any) {
+		v.integer(path, val)
+	})
+	v.required(obj, path, "digest", v.digest)
+	v.optional(obj, path, "urls", v.stringArray)
+	v.optional(obj, path, "data", func(path string, val any) {
+		if s, ok := v.asString(path, val); ok {
+			if _, err := base64.StdEncoding.DecodeString(s); err != nil {
+				v.fail(path, "invalid base64 data: %v", err)
+			}
+		}
+	})
+	v.optional(obj, path, "artifactType", v.mediaType)
+	v.optional(obj, path, "annotations", v.stringMap)
+}
+
+func (v *schemaValidator) platform(path string, val any) {
+	obj, ok := v.object(path, val)
+	if !ok {
+		return
+	}
+	v.required(obj, path, "architecture", v.string)
+	v.required(obj, path, "os", v.string)
+	v.optional(obj, path, "os.version", v.string)
+	v.optional(obj, path, "os.features", v.stringArray)
+	v.optional(obj, path, "variant", v.string)
+}
+
+func (v *schemaValidator) mediaType(path string, val any) {
+	if s, ok := v.asString(path, val); ok && !schemaMediaTypeRegexp.MatchString(s) {
+		v.fail(path, "invalid media type %q", s)
+	}
+}
+
+func (v *schemaValidator) digest(path string, val any) {
+	if s, ok := v.asString(path, val); ok && !schemaDigestRegexp.MatchString(s) {
+		v.fail(path, "invalid digest format %q", s)
+	}
+}
+
+// required validates the value of the key in the object with the given path or records a violation if it's missing.
+func (v *schemaValidator) required(obj map[string]any, path, key string, validate func(string, any)) {
+	if _, ok := obj[key]; !ok {
+		v.fail(joinPath(path, key), "required field is missing")
+		return
+	}
+	v.optional(obj, path, key, validate)
+}
+
+// optional validates the value of the key in the object with the given path if it's present.
+func (v *schemaValidator) optional(obj map[string]any, path, key string, validate func(string, any)) {
+	if val, ok := obj[key]; ok {
+		validate(joinPath(path, key), val)
+	}
+}
+
+// nullable wraps the validation function to also accept null.
+func (v *schemaValidator) nullable(validate func(string, any)) func(string, any) {
+	return func(path string, val any) {
+		if val != nil {
+			validate(path, val)
+		}
+	}
+}
+
+func (v *schemaValidator) object(path string, val any) (map[string]any, bool) {
+	obj, ok := val.(map[string]any)
+	if !ok {
+		v.fail(path, "must be an object, got %s", jsonType(val))
+	}
+	return obj, ok
+}
+
+func (v *schemaValidator) array(path string, val any) ([]any, bool) {
+	arr, ok := val.([]any)
+	if !ok {
+		v.fail(path, "must be an array, got %s", jsonType(val))
+	}
+	return arr, ok
+}
+
+func (v *schemaValidator) string(path string, val any) {
+	v.asString(path, val)
+}
+
+func (v *schemaValidator) asString(path string, val any) (string, bool) {
+	s, ok := val.(string)
+	if !ok {
+		v.fail(path, "must be a string, got %s", jsonType(val))
+	}
+	return s, ok
+}
+
+func (v *schemaValidator) bool(path string, val any) {
+	if _, ok := val.(bool); !ok {
+		v.fail(path, "must be a boolean, got %s", jsonType(val))
+	}
+}
+
+func (v *schemaValidator) integer(path string, val any) (int64, bool) {
+	num, ok := val.(json.Number)
+	if !ok {
+		v.fail(path, "must be an integer, got %s", jsonType(val))
+		return 0, false
+	}
+	n, err := num.Int64()
+	if err != nil {
+		if f, ferr := num.Float64(); ferr == nil && f == math.Trunc(f) {
+			v.fail(path, "integer %s is out of range", num)
+		} else {
+			v.fail(path, "must be an integer, got %s", num)
+		}
+		return 0, false
+	}
+	return n, true
+}
+
+func (v *schemaValidator) stringArray(path string, val any) {
+	arr, ok := v.array(path, val)
+	if !ok {
+		return
+	}
+	for i, item := range arr {
+		v.asString(fmt.Sprintf("%s[%d]", path, i), item)
+	}
+}
+
+func (v *schemaValidator) stringMap(path string, val any) {
+	obj, ok := v.object(path, val)
+	if !ok {
+		return
+	}
+	for key, item := range obj {
+		v.asString(joinPath(path, key), item)
+	}
+}
+
+func (v *schemaValidator) objectMap(path string, val any) {
+	obj, ok := v.object(path, val)
+	if !ok {
+		return
+	}
+	for key, item := range obj {
+		v.object(joinPath(path, key), item)
+	}
+}
+
+// joinPath appends the object key to the JSON path quoting keys that contain dots.
+func joinPath(path, key string) string {
+	if strings.ContainsAny(key, ".[]") {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func jsonType(val any) string {
+	switch val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", val)
+	}
+}
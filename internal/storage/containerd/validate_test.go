@@ -0,0 +1,161 @@
+package containerd
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	validDescriptor = `{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "size": 1, ` +
+		`"digest": "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b"}`
+	validConfig = `{"mediaType": "application/vnd.oci.image.config.v1+json", "size": 1, ` +
+		`"digest": "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b"}`
+)
+
+// schemaViolations returns the violations in the detail of the MANIFEST_INVALID error returned by the validation.
+func schemaViolations(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var e errcode.Error
+	if !errors.As(err, &e) || e.Code != errcode.ErrorCodeManifestInvalid {
+		t.Fatalf("expected MANIFEST_INVALID error, got %v", err)
+	}
+	violations, ok := e.Detail.([]string)
+	if !ok {
+		t.Fatalf("expected the violations as the error detail, got %#v", e.Detail)
+	}
+	return violations
+}
+
+func TestValidateManifestSchema(t *testing.T) {
+	tests := []struct {
+		name      string
+		mediaType string
+		payload   string
+		want      []string
+	}{
+		{
+			name:      "valid manifest",
+			mediaType: ocispec.MediaTypeImageManifest,
+			payload:   `{"schemaVersion": 2, "config": ` + validConfig + `, "layers": [` + validDescriptor + `]}`,
+		},
+		{
+			name:      "valid Docker manifest",
+			mediaType: images.MediaTypeDockerSchema2Manifest,
+			payload: `{"schemaVersion": 2, "mediaType": "` + images.MediaTypeDockerSchema2Manifest + `", ` +
+				`"config": ` + validConfig + `, "layers": [` + validDescriptor + `]}`,
+		},
+		{
+			name:      "invalid JSON",
+			mediaType: ocispec.MediaTypeImageManifest,
+			payload:   `{"schemaVersion": 2`,
+			want:      []string{"invalid JSON: unexpected EOF"},
+		},
+		{
+			name:      "all violations of manifest",
+			mediaType: ocispec.MediaTypeImageManifest,
+			payload: `{"schemaVersion": 1, "mediaType": "invalid", "layers": [` + validDescriptor + `, ` +
+				`{"mediaType": "application/vnd.oci.image.layer.v1.tar", "size": 1.5, "digest": "sha256"}], ` +
+				`"annotations": {"org.opencontainers.image.version": 1}}`,
+			want: []string{
+				"schemaVersion: must be 2, got 1",
+				`mediaType: invalid media type "invalid"`,
+				"config: required field is missing",
+				"layers[1].size: must be an integer, got 1.5",
+				`layers[1].digest: invalid digest format "sha256"`,
+				`annotations["org.opencontainers.image.version"]: must be a string, got number`,
+			},
+		},
+		{
+			name:      "no layers",
+			mediaType: ocispec.MediaTypeImageManifest,
+			payload:   `{"schemaVersion": 2, "config": ` + validConfig + `, "layers": []}`,
+			want:      []string{"layers: must have at least one layer"},
+		},
+		{
+			name:      "size out of range",
+			mediaType: ocispec.MediaTypeImageManifest,
+			payload: `{"schemaVersion": 2, "config": {"mediaType": "application/vnd.oci.image.config.v1+json", ` +
+				`"size": 1e30, "digest": "sha256:abc"}, "layers": [` + validDescriptor + `]}`,
+			want: []string{"config.size: integer 1e30 is out of range"},
+		},
+		{
+			name:      "valid index",
+			mediaType: ocispec.MediaTypeImageIndex,
+			payload: `{"schemaVersion": 2, "manifests": [{"mediaType": "application/vnd.oci.image.manifest.v1+json", ` +
+				`"size": 1, "digest": "sha256:abc", "platform": {"architecture": "amd64", "os": "linux"}}]}`,
+		},
+		{
+			name:      "invalid index",
+			mediaType: ocispec.MediaTypeImageIndex,
+			payload: `{"schemaVersion": 2, "manifests": [{"mediaType": "application/vnd.oci.image.manifest.v1+json", ` +
+				`"size": 1, "digest": "sha256:abc", "data": "!", "platform": {"os": "linux"}}, "manifest"]}`,
+			want: []string{
+				"manifests[0].data: invalid base64 data: illegal base64 data at input byte 0",
+				"manifests[0].platform.architecture: required field is missing",
+				"manifests[1]: must be an object, got string",
+			},
+		},
+		{
+			name:      "not an object",
+			mediaType: ocispec.MediaTypeImageIndex,
+			payload:   `[]`,
+			want:      []string{"must be an object, got array"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := schemaViolations(t, validateManifestSchema(tt.mediaType, []byte(tt.payload)))
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("expected violations %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateConfigSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    []string
+	}{
+		{
+			name: "valid config",
+			payload: `{"architecture": "amd64", "os": "linux", "rootfs": {"type": "layers", "diff_ids": []}, ` +
+				`"config": {"Env": ["PATH=/bin"], "Cmd": null, "Volumes": null, "ExposedPorts": {"80/tcp": {}}}, ` +
+				`"history": [{"created_by": "COPY . /", "empty_layer": true}]}`,
+		},
+		{
+			name: "all violations",
+			payload: `{"os": "linux", "os.features": "sse4", "rootfs": {"type": "layer", "diff_ids": [1]}, ` +
+				`"config": {"Env": "PATH=/bin", "ExposedPorts": {"80/tcp": true}, "ArgsEscaped": "true"}, ` +
+				`"history": [{"empty_layer": 1}, null]}`,
+			want: []string{
+				"architecture: required field is missing",
+				`["os.features"]: must be an array, got string`,
+				`rootfs.type: must be "layers", got "layer"`,
+				"rootfs.diff_ids[0]: must be a string, got number",
+				"config.Env: must be an array, got string",
+				"config.ExposedPorts.80/tcp: must be an object, got boolean",
+				"config.ArgsEscaped: must be a boolean, got string",
+				"history[0].empty_layer: must be a boolean, got number",
+				"history[1]: must be an object, got null",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := schemaViolations(t, validateConfigSchema([]byte(tt.payload)))
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("expected violations %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
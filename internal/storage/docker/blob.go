@@ -0,0 +1,230 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+	"github.com/google/uuid"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// blobStore implements distribution.BlobStore backed by the local blob store. Blobs are only handed over to the Docker
+// daemon when an image referencing them is tagged.
+type blobStore struct {
+	store *Store
+	repo  reference.Named
+}
+
+var _ distribution.BlobStore = &blobStore{}
+
+// Stat returns metadata about a blob in the store by its digest. If the blob doesn't exist,
+// distribution.ErrBlobUnknown will be returned.
+func (b *blobStore) Stat(_ context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	size, err := b.store.Stat(dgst)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return distribution.Descriptor{
+		MediaType: "application/octet-stream",
+		Digest:    dgst,
+		Size:      size,
+	}, nil
+}
+
+// Get retrieves the content of a blob in the store by its digest.
+func (b *blobStore) Get(_ context.Context, dgst digest.Digest) ([]byte, error) {
+	return b.store.Get(dgst)
+}
+
+// Open opens the blob in the store for reading.
+func (b *blobStore) Open(_ context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	return b.store.Open(dgst)
+}
+
+// Put stores the blob in the store.
+func (b *blobStore) Put(_ context.Context, mediaType string, blob []byte) (distribution.Descriptor, error) {
+	dgst := digest.FromBytes(blob)
+	if _, err := b.store.Put(dgst, bytes.NewReader(blob)); err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return distribution.Descriptor{
+		MediaType: mediaType,
+		Digest:    dgst,
+		Size:      int64(len(blob)),
+	}, nil
+}
+
+// Create creates a blob writer to add a blob to the store.
+func (b *blobStore) Create(_ context.Context, _ ...distribution.BlobCreateOption) (distribution.BlobWriter, error) {
+	return b.openWriter(uuid.NewString(), true)
+}
+
+// Resume creates a blob writer for resuming an upload with a specific ID.
+func (b *blobStore) Resume(_ context.Context, id string) (distribution.BlobWriter, error) {
+	return b.openWriter(id, false)
+}
+
+// openWriter opens the data file of the upload with the given ID for appending. The file must exist unless create
+// is true.
+func (b *blobStore) openWriter(id string, create bool) (distribution.BlobWriter, error) {
+	path, err := b.store.uploadPath(id)
+	if err != nil {
+		return nil, err
+	}
+	flags := os.O_WRONLY | os.O_APPEND
+	if create {
+		flags |= os.O_CREATE | os.O_EXCL
+	}
+	f, err := os.OpenFile(path, flags, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, distribution.ErrBlobUploadUnknown
+		}
+		return nil, fmt.Errorf("open upload data: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("stat upload data: %w", err)
+	}
+	startedAt := fi.ModTime()
+	if create {
+		startedAt = time.Now()
+	}
+	return &blobWriter{
+		store:     b.store,
+		repo:      b.repo,
+		id:        id,
+		file:      f,
+		size:      fi.Size(),
+		startedAt: startedAt,
+	}, nil
+}
+
+// Mount is not supported. Blobs aren't repository-namespaced in the store so they already exist in every repository.
+func (b *blobStore) Mount(_ context.Context, _ reference.Named, _ digest.Digest) (distribution.Descriptor, error) {
+	return distribution.Descriptor{}, distribution.ErrUnsupported
+}
+
+// ServeBlob serves the blob from the store over HTTP.
+func (b *blobStore) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
+	desc, err := b.Stat(ctx, dgst)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", desc.MediaType)
+	w.Header().Set("Content-Length", strconv.FormatInt(desc.Size, 10))
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.Header().Set("Etag", dgst.String())
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	f, err := b.store.Open(dgst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(w, f, desc.Size)
+	return err
+}
+
+// Delete is not supported as images are deleted from the Docker image store instead.
+func (b *blobStore) Delete(_ context.Context, _ digest.Digest) error {
+	return distribution.ErrUnsupported
+}
+
+// blobWriter implements distribution.BlobWriter appending the uploaded data to a file in the store.
+type blobWriter struct {
+	store     *Store
+	repo      reference.Named
+	id        string
+	file      *os.File
+	size      int64
+	startedAt time.Time
+}
+
+// ID returns the identifier for this blob upload.
+func (bw *blobWriter) ID() string {
+	return bw.id
+}
+
+// StartedAt returns the time the upload started.
+func (bw *blobWriter) StartedAt() time.Time {
+	return bw.startedAt
+}
+
+// Size returns the number of bytes written to the upload.
+func (bw *blobWriter) Size() int64 {
+	return bw.size
+}
+
+// Write appends data to the upload.
+func (bw *blobWriter) Write(data []byte) (int, error) {
+	n, err := bw.file.Write(data)
+	bw.size += int64(n)
+	return n, err
+}
+
+// ReadFrom appends all data from r to the upload.
+func (bw *blobWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(bw.file, r)
+	bw.size += n
+	return n, err
+}
+
+// Commit verifies the uploaded data against the digest of desc and moves it into the store.
+func (bw *blobWriter) Commit(_ context.Context, desc distribution.Descriptor) (distribution.Descriptor, error) {
+	if err := bw.file.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return distribution.Descriptor{}, fmt.Errorf("close upload data: %w", err)
+	}
+	if desc.Size > 0 && desc.Size != bw.size {
+		return distribution.Descriptor{}, distribution.ErrBlobInvalidLength
+	}
+	size, err := bw.store.commitUpload(bw.id, desc.Digest)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"repo":   bw.repo.Name(),
+		"digest": desc.Digest,
+		"size":   size,
+	}).Debug("Committed blob upload to docker backend blob store.")
+
+	return distribution.Descriptor{
+		MediaType: "application/octet-stream",
+		Digest:    desc.Digest,
+		Size:      size,
+	}, nil
+}
+
+// Cancel deletes the uploaded data.
+func (bw *blobWriter) Cancel(_ context.Context) error {
+	_ = bw.file.Close()
+	path, err := bw.store.uploadPath(bw.id)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete upload data: %w", err)
+	}
+	return nil
+}
+
+// Close closes the data file keeping the upload so that it can be resumed.
+func (bw *blobWriter) Close() error {
+	if err := bw.file.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+// Package docker implements the registry storage backed by the Docker daemon. Unlike the containerd backend, it talks
+// to the Docker Engine API instead of the containerd socket and moves images in and out of the daemon with image load
+// and save. It works with any Docker image store, including the classic one, userns-remap, and rootless setups where
+// the containerd socket and namespace of the daemon aren't accessible or aren't what unregistry expects.
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/errdefs"
+)
+
+// minAPIVersion is the minimum Docker Engine API version that saves images in the OCI image layout, Docker 25.0.
+const minAPIVersion = "1.44"
+
+// Client is a minimal Docker Engine API client for the image endpoints the backend needs.
+type Client struct {
+	sock string
+	http *http.Client
+}
+
+// NewClient creates a Docker Engine API client connected to the unix socket at sock, e.g. /var/run/docker.sock.
+// The "unix://" prefix of the DOCKER_HOST form is accepted as well.
+func NewClient(sock string) (*Client, error) {
+	sock = strings.TrimPrefix(sock, "unix://")
+	if sock == "" {
+		return nil, fmt.Errorf("docker socket path is required")
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		},
+	}
+	return &Client{sock: sock, http: &http.Client{Transport: transport}}, nil
+}
+
+// Image describes an image in the Docker image store.
+type Image struct {
+	ID       string   `json:"Id"`
+	RepoTags []string `json:"RepoTags"`
+}
+
+// Info is the system information of the Docker daemon relevant to where it stores images.
+type Info struct {
+	// DockerRootDir is the data root of the daemon. It's a subdirectory named after the remapped user and group IDs,
+	// e.g. "/var/lib/docker/100000.100000", if the daemon runs with userns-remap.
+	DockerRootDir   string   `json:"DockerRootDir"`
+	SecurityOptions []string `json:"SecurityOptions"`
+	// Containerd is the containerd instance the daemon uses. It's reported by Docker 27.0 (API 1.46) and newer.
+	Containerd *ContainerdInfo `json:"Containerd"`
+}
+
+// ContainerdInfo describes the containerd instance the Docker daemon uses.
+type ContainerdInfo struct {
+	// Address is the path to the containerd socket.
+	Address    string `json:"Address"`
+	Namespaces struct {
+		// Containers is the containerd namespace of the containers and images of the daemon.
+		Containers string `json:"Containers"`
+	} `json:"Namespaces"`
+}
+
+// UsernsRemap returns true if the daemon runs with user namespace remapping.
+func (i Info) UsernsRemap() bool {
+	for _, opt := range i.SecurityOptions {
+		if strings.Contains(opt, "name=userns") {
+			return true
+		}
+	}
+	return false
+}
+
+// Info returns the system information of the Docker daemon.
+func (c *Client) Info(ctx context.Context) (Info, error) {
+	var info Info
+	resp, err := c.do(ctx, http.MethodGet, "/info", nil, nil)
+	if err != nil {
+		return info, fmt.Errorf("get docker daemon info: %w", err)
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return info, fmt.Errorf("decode docker daemon info: %w", err)
+	}
+	return info, nil
+}
+
+// Ping checks that the Docker daemon responds and its API version is recent enough to save images in the OCI image
+// layout. It returns the API version of the daemon.
+func (c *Client) Ping(ctx context.Context) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/_ping", nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("ping docker daemon at '%s': %w", c.sock, err)
+	}
+	_ = resp.Body.Close()
+
+	version := resp.Header.Get("Api-Version")
+	if !apiVersionAtLeast(version, minAPIVersion) {
+		return version, fmt.Errorf("docker daemon API version %s is not supported; Docker Engine 25.0 (API %s) "+
+			"or newer is required", version, minAPIVersion)
+	}
+	return version, nil
+}
+
+// ImageInspect returns the image with the given reference. It returns an errdefs.ErrNotFound error if the image
+// doesn't exist.
+func (c *Client) ImageInspect(ctx context.Context, ref string) (Image, error) {
+	var img Image
+	resp, err := c.do(ctx, http.MethodGet, "/images/"+ref+"/json", nil, nil)
+	if err != nil {
+		return img, fmt.Errorf("inspect docker image '%s': %w", ref, err)
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(&img); err != nil {
+		return img, fmt.Errorf("decode docker image '%s': %w", ref, err)
+	}
+	return img, nil
+}
+
+// ImageList returns all images in the Docker image store.
+func (c *Client) ImageList(ctx context.Context) ([]Image, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/images/json", nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("list docker images: %w", err)
+	}
+	defer resp.Body.Close()
+	var imgs []Image
+	if err = json.NewDecoder(resp.Body).Decode(&imgs); err != nil {
+		return nil, fmt.Errorf("decode docker images: %w", err)
+	}
+	return imgs, nil
+}
+
+// ImageSave exports the image with the given reference as a tar archive in the OCI image layout. The caller must
+// close the returned reader.
+func (c *Client) ImageSave(ctx context.Context, ref string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/images/get", url.Values{"names": {ref}}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("save docker image '%s': %w", ref, err)
+	}
+	return resp.Body, nil
+}
+
+// ImageLoad imports the images from a tar archive in the OCI image layout into the Docker image store.
+func (c *Client) ImageLoad(ctx context.Context, archive io.Reader) error {
+	resp, err := c.do(ctx, http.MethodPost, "/images/load", url.Values{"quiet": {"1"}}, archive)
+	if err != nil {
+		return fmt.Errorf("load docker image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// The daemon streams JSON messages and reports a failure in the last one after responding with 200 OK.
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Error       string `json:"error"`
+			ErrorDetail struct {
+				Message string `json:"message"`
+			} `json:"errorDetail"`
+		}
+		if err = dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("load docker image: read response: %w", err)
+		}
+		if msg.ErrorDetail.Message != "" {
+			return fmt.Errorf("load docker image: %s", msg.ErrorDetail.Message)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("load docker image: %s", msg.Error)
+		}
+	}
+}
+
+// ImageRemove removes the image reference from the Docker image store. The image is deleted if it was its last
+// reference.
+func (c *Client) ImageRemove(ctx context.Context, ref string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/images/"+ref, nil, nil)
+	if err != nil {
+		return fmt.Errorf("remove docker image '%s': %w", ref, err)
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+// do sends a request to the Docker Engine API and returns the response if it's successful. Otherwise, it returns
+// the error message of the daemon, wrapping errdefs.ErrNotFound for 404 responses.
+func (c *Client) do(
+	ctx context.Context, method, path string, query url.Values, body io.Reader,
+) (*http.Response, error) {
+	u := url.URL{Scheme: "http", Host: "docker", Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-tar")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var msg struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &msg) != nil || msg.Message == "" {
+		msg.Message = strings.TrimSpace(string(data))
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", errdefs.ErrNotFound, msg.Message)
+	}
+	return nil, fmt.Errorf("docker daemon responded with %s: %s", resp.Status, msg.Message)
+}
+
+// apiVersionAtLeast returns true if the API version in the "major.minor" form is at least the minimum version.
+func apiVersionAtLeast(version, minimum string) bool {
+	parse := func(v string) (int, int, bool) {
+		major, minor, ok := strings.Cut(v, ".")
+		if !ok {
+			return 0, 0, false
+		}
+		ma, err1 := strconv.Atoi(major)
+		mi, err2 := strconv.Atoi(minor)
+		return ma, mi, err1 == nil && err2 == nil
+	}
+	ma, mi, ok := parse(version)
+	if !ok {
+		return false
+	}
+	minMa, minMi, _ := parse(minimum)
+	return ma > minMa || (ma == minMa && mi >= minMi)
+}
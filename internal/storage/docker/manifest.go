@@ -0,0 +1,99 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// manifestService implements distribution.ManifestService backed by the local blob store. Manifests become images in
+// the Docker image store only when they're tagged.
+type manifestService struct {
+	repo  reference.Named
+	store *Store
+}
+
+var _ distribution.ManifestService = &manifestService{}
+
+// Exists checks if a manifest exists in the blob store by digest.
+func (m *manifestService) Exists(_ context.Context, dgst digest.Digest) (bool, error) {
+	_, err := m.store.Stat(dgst)
+	if errors.Is(err, distribution.ErrBlobUnknown) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Get retrieves a manifest from the blob store by its digest.
+func (m *manifestService) Get(
+	_ context.Context, dgst digest.Digest, _ ...distribution.ManifestServiceOption,
+) (distribution.Manifest, error) {
+	blob, err := m.store.Get(dgst)
+	if err != nil {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
+			return nil, distribution.ErrManifestUnknownRevision{
+				Name:     m.repo.Name(),
+				Revision: dgst,
+			}
+		}
+		return nil, err
+	}
+
+	manifest, err := unmarshalManifest(blob)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// Put stores a manifest in the blob store. The image isn't loaded into the Docker image store until it's tagged.
+func (m *manifestService) Put(
+	_ context.Context, manifest distribution.Manifest, _ ...distribution.ManifestServiceOption,
+) (digest.Digest, error) {
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return "", fmt.Errorf("get manifest payload: %w", err)
+	}
+	dgst := digest.FromBytes(payload)
+	if _, err = m.store.Put(dgst, bytes.NewReader(payload)); err != nil {
+		return "", fmt.Errorf("store manifest: %w", err)
+	}
+	logrus.WithFields(logrus.Fields{
+		"repo":      m.repo.Name(),
+		"digest":    dgst,
+		"mediatype": mediaType,
+	}).Debug("Stored manifest in docker backend blob store.")
+
+	return dgst, nil
+}
+
+// Delete is not supported as images are deleted from the Docker image store by untagging them.
+func (m *manifestService) Delete(_ context.Context, _ digest.Digest) error {
+	return distribution.ErrUnsupported
+}
+
+// unmarshalManifest attempts to unmarshal a manifest in any of the supported formats.
+func unmarshalManifest(blob []byte) (distribution.Manifest, error) {
+	var ociManifest ocischema.DeserializedManifest
+	if err := ociManifest.UnmarshalJSON(blob); err == nil {
+		return &ociManifest, nil
+	}
+	var schema2Manifest schema2.DeserializedManifest
+	if err := schema2Manifest.UnmarshalJSON(blob); err == nil {
+		return &schema2Manifest, nil
+	}
+	var manifestList manifestlist.DeserializedManifestList
+	if err := manifestList.UnmarshalJSON(blob); err == nil {
+		return &manifestList, nil
+	}
+	return nil, distribution.ErrManifestVerification{errors.New("unknown manifest format")}
+}
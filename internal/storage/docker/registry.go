@@ -0,0 +1,250 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/pkg/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// BackendName is the name of the storage backend backed by the Docker Engine API.
+const BackendName = "docker"
+
+func init() {
+	storage.MustRegister(BackendName, newRegistry)
+}
+
+// repositoryFilter restricts which repositories can be accessed.
+type repositoryFilter interface {
+	Allowed(name string) bool
+}
+
+// newRegistry is the storage backend factory function that creates an instance of registry backed by the Docker
+// daemon. It requires the Docker client in the "client" option and the blob store in the "store" option. Removing
+// tags is enabled with the "delete" option.
+func newRegistry(_ context.Context, options storage.Options) (distribution.Namespace, error) {
+	cli, ok := options["client"].(*Client)
+	if !ok || cli == nil {
+		return nil, fmt.Errorf("docker client is required")
+	}
+	store, ok := options["store"].(*Store)
+	if !ok || store == nil {
+		return nil, fmt.Errorf("docker backend blob store is required")
+	}
+	repoFilter, _ := options["repofilter"].(repositoryFilter)
+	deletes, _ := options["delete"].(bool)
+
+	return &registry{
+		client:     cli,
+		store:      store,
+		repoFilter: repoFilter,
+		deletes:    deletes,
+		images:     make(map[string]savedImage),
+	}, nil
+}
+
+// registry implements distribution.Namespace backed by the Docker daemon.
+type registry struct {
+	client *Client
+	store  *Store
+	// repoFilter restricts which repositories can be accessed. All repositories are allowed if nil.
+	repoFilter repositoryFilter
+	// deletes enables removing tags through the registry API.
+	deletes bool
+
+	// locks serializes saving and loading the same image as saves are expensive and a pull of a multi-platform image
+	// requests the same tag a few times. Different images are saved and loaded concurrently.
+	locks refLocks
+	// mu guards images.
+	mu sync.Mutex
+	// images maps the references of the images saved from or loaded into the daemon to their root descriptors.
+	images map[string]savedImage
+}
+
+// refLocks is a set of mutexes keyed by image reference. The mutex of a reference is deleted when it's unlocked and
+// no one else waits for it.
+type refLocks struct {
+	mu    sync.Mutex
+	locks map[string]*refLock
+}
+
+type refLock struct {
+	sync.Mutex
+	// waiters is the number of holders of and waiters for the mutex.
+	waiters int
+}
+
+// lock locks the mutex of the reference and returns the function to unlock it.
+func (l *refLocks) lock(ref string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*refLock)
+	}
+	rl, ok := l.locks[ref]
+	if !ok {
+		rl = &refLock{}
+		l.locks[ref] = rl
+	}
+	rl.waiters++
+	l.mu.Unlock()
+
+	rl.Lock()
+	return func() {
+		rl.Unlock()
+		l.mu.Lock()
+		if rl.waiters--; rl.waiters == 0 {
+			delete(l.locks, ref)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// savedImage is an image whose blobs are present in the store.
+type savedImage struct {
+	// id is the image ID in the Docker image store. The image is saved again if the reference points to another ID.
+	id   string
+	desc distribution.Descriptor
+}
+
+var (
+	_ distribution.Namespace = &registry{}
+	_ storage.HealthChecker  = &registry{}
+)
+
+// savedImage returns the saved or loaded image with the reference.
+func (r *registry) savedImage(ref string) (savedImage, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	img, ok := r.images[ref]
+	return img, ok
+}
+
+// setSavedImage records the image with the reference saved from or loaded into the daemon.
+func (r *registry) setSavedImage(ref string, img savedImage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.images[ref] = img
+}
+
+// Scope returns the global scope for this registry.
+func (r *registry) Scope() distribution.Scope {
+	return distribution.GlobalScope
+}
+
+// CheckHealth checks that the Docker daemon responds.
+func (r *registry) CheckHealth(ctx context.Context) error {
+	_, err := r.client.Ping(ctx)
+	return err
+}
+
+// Repository returns an instance of repository for the given name. Access to the repositories not allowed by
+// the repository filter is denied before touching the daemon.
+func (r *registry) Repository(_ context.Context, name reference.Named) (distribution.Repository, error) {
+	// Shouldn't return an error as name is a valid reference.
+	canonicalName, _ := reference.ParseNormalizedNamed(name.String())
+	// Match the familiar name so that aliases like "docker.io/library/ubuntu" can't bypass the patterns for "ubuntu".
+	if r.repoFilter != nil && !r.repoFilter.Allowed(reference.FamiliarName(canonicalName)) {
+		logrus.WithField("repo", name.Name()).Debug("Denied access to repository not allowed by configuration.")
+		return nil, errcode.ErrorCodeDenied.WithMessage(
+			fmt.Sprintf("access to repository '%s' is not allowed by the registry configuration", name.Name()))
+	}
+	return &repository{
+		registry:      r,
+		name:          name,
+		canonicalName: canonicalName,
+	}, nil
+}
+
+// Repositories fills repos with the sorted names of repositories that have tagged images in the Docker image store
+// and come after last in the lexical order. It returns the number of filled names and io.EOF if there are no more
+// repositories after them.
+func (r *registry) Repositories(ctx context.Context, repos []string, last string) (int, error) {
+	imgs, err := r.client.ImageList(ctx)
+	if err != nil {
+		return 0, err
+	}
+	unique := make(map[string]struct{})
+	for _, img := range imgs {
+		for _, tag := range img.RepoTags {
+			named, err := reference.ParseNormalizedNamed(tag)
+			if err != nil {
+				continue
+			}
+			unique[reference.FamiliarName(named)] = struct{}{}
+		}
+	}
+	names := slices.Sorted(maps.Keys(unique))
+
+	n := 0
+	for _, name := range names {
+		if name <= last || (r.repoFilter != nil && !r.repoFilter.Allowed(name)) {
+			continue
+		}
+		if n == len(repos) {
+			return n, nil
+		}
+		repos[n] = name
+		n++
+	}
+	return n, io.EOF
+}
+
+// Blobs returns a stub implementation of distribution.BlobEnumerator that doesn't support enumeration.
+func (r *registry) Blobs() distribution.BlobEnumerator {
+	return unsupportedBlobEnumerator{}
+}
+
+// BlobStatter returns a blob statter of the blob store.
+func (r *registry) BlobStatter() distribution.BlobStatter {
+	return &blobStore{store: r.store}
+}
+
+// unsupportedBlobEnumerator implements distribution.BlobEnumerator but doesn't support enumeration.
+type unsupportedBlobEnumerator struct{}
+
+// Enumerate is not supported as the blob store is only a staging area and cache of the Docker image store.
+func (unsupportedBlobEnumerator) Enumerate(_ context.Context, _ func(digest.Digest) error) error {
+	return distribution.ErrUnsupported
+}
+
+// repository implements distribution.Repository backed by the Docker daemon.
+type repository struct {
+	registry *registry
+	name     reference.Named
+	// canonicalName is the repository reference in a normalized form, the way the Docker daemon names images,
+	// for example, "docker.io/library/ubuntu".
+	canonicalName reference.Named
+}
+
+var _ distribution.Repository = &repository{}
+
+// Named returns the name of the repository.
+func (r *repository) Named() reference.Named {
+	return r.name
+}
+
+// Manifests returns the manifest service for the repository backed by the blob store.
+func (r *repository) Manifests(
+	_ context.Context, _ ...distribution.ManifestServiceOption,
+) (distribution.ManifestService, error) {
+	return &manifestService{repo: r.name, store: r.registry.store}, nil
+}
+
+// Blobs returns the blob store for the repository.
+func (r *repository) Blobs(_ context.Context) distribution.BlobStore {
+	return &blobStore{store: r.registry.store, repo: r.name}
+}
+
+// Tags returns the tag service for the repository backed by the Docker image store.
+func (r *repository) Tags(_ context.Context) distribution.TagService {
+	return &tagService{registry: r.registry, repo: r.name, canonicalRepo: r.canonicalName}
+}
@@ -0,0 +1,35 @@
+package docker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/reference"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+)
+
+func TestRegistryRepositoryFilterAliases(t *testing.T) {
+	filter, err := containerd.NewRepositoryFilter(nil, []string{"ubuntu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := &registry{repoFilter: filter}
+
+	for _, name := range []string{"ubuntu", "library/ubuntu", "docker.io/library/ubuntu"} {
+		named, err := reference.WithName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = reg.Repository(context.Background(), named); err == nil {
+			t.Errorf("expected access to denied repository '%s' to be denied", name)
+		}
+	}
+
+	named, err := reference.WithName("docker.io/library/debian")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reg.Repository(context.Background(), named); err != nil {
+		t.Errorf("expected access to repository '%s' to be allowed: %v", named.Name(), err)
+	}
+}
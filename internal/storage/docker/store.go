@@ -0,0 +1,431 @@
+package docker
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/distribution/distribution/v3"
+	"github.com/google/uuid"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Store is a content-addressed blob store in a local directory that holds the blobs pushed to the registry until
+// their image is loaded into the Docker daemon, and the blobs of the images saved from the daemon to serve pulls.
+// The blobs are only staged in the store so they're deleted by PurgeIdleBlobs once they haven't been used for a while.
+// The modification time of a blob is its last use. The layout of the directory is:
+//
+//	blobs/sha256/<hex>  committed blobs
+//	uploads/<id>        data of unfinished blob uploads
+type Store struct {
+	dir string
+}
+
+// NewStore creates a blob store in the directory creating it if needed.
+func NewStore(dir string) (*Store, error) {
+	for _, d := range []string{filepath.Join(dir, "blobs", "sha256"), filepath.Join(dir, "uploads")} {
+		if err := os.MkdirAll(d, 0o700); err != nil {
+			return nil, fmt.Errorf("create docker backend data directory: %w", err)
+		}
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir returns the directory of the store.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// blobPath returns the path of the blob with the given digest.
+func (s *Store) blobPath(dgst digest.Digest) (string, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", err
+	}
+	if dgst.Algorithm() != digest.SHA256 {
+		return "", fmt.Errorf("unsupported digest algorithm: %s", dgst.Algorithm())
+	}
+	return filepath.Join(s.dir, "blobs", "sha256", dgst.Encoded()), nil
+}
+
+// uploadPath returns the path of the data of the upload with the given ID. The ID must be a UUID so that it can't
+// point outside the uploads directory.
+func (s *Store) uploadPath(id string) (string, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return "", distribution.ErrBlobUploadUnknown
+	}
+	return filepath.Join(s.dir, "uploads", id), nil
+}
+
+// Stat returns the size of the blob. It returns distribution.ErrBlobUnknown if the blob doesn't exist.
+func (s *Store) Stat(dgst digest.Digest) (int64, error) {
+	path, err := s.blobPath(dgst)
+	if err != nil {
+		return 0, distribution.ErrBlobUnknown
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, distribution.ErrBlobUnknown
+		}
+		return 0, fmt.Errorf("stat blob '%s': %w", dgst, err)
+	}
+	// Pushes check which blobs exist before pushing the manifest referencing them so keep them until it's pushed.
+	touch(path)
+	return fi.Size(), nil
+}
+
+// Open opens the blob for reading. It returns distribution.ErrBlobUnknown if the blob doesn't exist.
+func (s *Store) Open(dgst digest.Digest) (*os.File, error) {
+	path, err := s.blobPath(dgst)
+	if err != nil {
+		return nil, distribution.ErrBlobUnknown
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, distribution.ErrBlobUnknown
+		}
+		return nil, fmt.Errorf("open blob '%s': %w", dgst, err)
+	}
+	touch(path)
+	return f, nil
+}
+
+// touch records the use of the blob at path so that PurgeIdleBlobs doesn't delete it while it's being used.
+func touch(path string) {
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
+
+// Get reads the whole blob. It returns distribution.ErrBlobUnknown if the blob doesn't exist.
+func (s *Store) Get(dgst digest.Digest) ([]byte, error) {
+	f, err := s.Open(dgst)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Put writes the blob from r to the store verifying its digest. The blob is written to a temporary file and renamed
+// so that readers never see partially written blobs.
+func (s *Store) Put(dgst digest.Digest, r io.Reader) (int64, error) {
+	path, err := s.blobPath(dgst)
+	if err != nil {
+		return 0, fmt.Errorf("invalid blob digest '%s': %w", dgst, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return 0, fmt.Errorf("create blob file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	verifier := dgst.Verifier()
+	n, err := io.Copy(io.MultiWriter(tmp, verifier), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, fmt.Errorf("write blob '%s': %w", dgst, err)
+	}
+	if !verifier.Verified() {
+		return n, distribution.ErrBlobInvalidDigest{Digest: dgst, Reason: errors.New("content doesn't match digest")}
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return n, fmt.Errorf("commit blob '%s': %w", dgst, err)
+	}
+	return n, nil
+}
+
+// commitUpload moves the data of the upload into the store as the blob with the given digest after verifying it.
+// It returns the size of the blob.
+func (s *Store) commitUpload(id string, dgst digest.Digest) (int64, error) {
+	path, err := s.uploadPath(id)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, distribution.ErrBlobUploadUnknown
+		}
+		return 0, fmt.Errorf("open upload data: %w", err)
+	}
+	defer f.Close()
+
+	n, err := s.Put(dgst, f)
+	if err != nil {
+		return n, err
+	}
+	_ = os.Remove(path)
+	return n, nil
+}
+
+// StaleUpload is an unfinished upload deleted by PurgeStaleUploads.
+type StaleUpload struct {
+	ID        string
+	UpdatedAt time.Time
+	// Size is the number of bytes of the upload data that have been deleted.
+	Size int64
+}
+
+// PurgeStaleUploads deletes the data of the unfinished uploads that haven't received data for longer than maxAge.
+func (s *Store) PurgeStaleUploads(maxAge time.Duration) ([]StaleUpload, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, "uploads"))
+	if err != nil {
+		return nil, fmt.Errorf("list uploads: %w", err)
+	}
+	var purged []StaleUpload
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) <= maxAge {
+			continue
+		}
+		err = os.Remove(filepath.Join(s.dir, "uploads", e.Name()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return purged, fmt.Errorf("delete upload '%s': %w", e.Name(), err)
+		}
+		purged = append(purged, StaleUpload{ID: e.Name(), UpdatedAt: info.ModTime(), Size: info.Size()})
+	}
+	return purged, nil
+}
+
+// IdleBlob is a blob deleted by PurgeIdleBlobs.
+type IdleBlob struct {
+	Digest digest.Digest
+	// UsedAt is when the blob was last written or read.
+	UsedAt time.Time
+	Size   int64
+}
+
+// PurgeIdleBlobs deletes the blobs that haven't been used for longer than maxAge. The blobs of a pushed image are no
+// longer needed once it's loaded into the Docker daemon, and the blobs of an image saved from the daemon once the pull
+// that requested it completes. A pull of an image whose blobs have been deleted saves the image from the daemon again.
+func (s *Store) PurgeIdleBlobs(maxAge time.Duration) ([]IdleBlob, error) {
+	dir := filepath.Join(s.dir, "blobs", "sha256")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("list blobs: %w", err)
+	}
+	var purged []IdleBlob
+	for _, e := range entries {
+		// Skip the temporary files of blobs being written.
+		dgst := digest.NewDigestFromEncoded(digest.SHA256, e.Name())
+		if dgst.Validate() != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) <= maxAge {
+			continue
+		}
+		err = os.Remove(filepath.Join(dir, e.Name()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return purged, fmt.Errorf("delete blob '%s': %w", dgst, err)
+		}
+		purged = append(purged, IdleBlob{Digest: dgst, UsedAt: info.ModTime(), Size: info.Size()})
+	}
+	return purged, nil
+}
+
+// importArchive extracts the blobs of an image tar archive in the OCI image layout, as saved by the Docker daemon,
+// into the store and returns its index.
+func (s *Store) importArchive(r io.Reader) (ocispec.Index, error) {
+	var index ocispec.Index
+	haveIndex := false
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return index, fmt.Errorf("read image archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := strings.TrimPrefix(hdr.Name, "./")
+		if name == ocispec.ImageIndexFile {
+			if err = json.NewDecoder(tr).Decode(&index); err != nil {
+				return index, fmt.Errorf("decode index of image archive: %w", err)
+			}
+			haveIndex = true
+			continue
+		}
+		encoded, ok := strings.CutPrefix(name, "blobs/sha256/")
+		if !ok {
+			continue
+		}
+		dgst := digest.NewDigestFromEncoded(digest.SHA256, encoded)
+		if _, err = s.Stat(dgst); err == nil {
+			continue
+		}
+		if _, err = s.Put(dgst, tr); err != nil {
+			return index, err
+		}
+	}
+	if !haveIndex {
+		return index, errors.New("image archive is not in the OCI image layout; Docker Engine 25.0 or newer " +
+			"is required")
+	}
+	return index, nil
+}
+
+// exportArchive writes a tar archive in the OCI image layout with the image described by desc and named ref to w.
+// The annotations make the Docker daemon tag the image with ref when it's loaded. Only the blobs present in the store
+// are included so a multi-platform image can be loaded with a subset of its platforms.
+func (s *Store) exportArchive(w io.Writer, desc ocispec.Descriptor, ref, tag string) error {
+	blobs, err := s.imageBlobs(desc)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	writeFile := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	layout, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return fmt.Errorf("encode image layout: %w", err)
+	}
+	if err = writeFile(ocispec.ImageLayoutFile, layout); err != nil {
+		return fmt.Errorf("write image archive: %w", err)
+	}
+	desc.Annotations = map[string]string{
+		ocispec.AnnotationRefName:  tag,
+		"io.containerd.image.name": ref,
+	}
+	index, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{desc},
+	})
+	if err != nil {
+		return fmt.Errorf("encode index of image archive: %w", err)
+	}
+	if err = writeFile(ocispec.ImageIndexFile, index); err != nil {
+		return fmt.Errorf("write image archive: %w", err)
+	}
+
+	for _, dgst := range blobs {
+		if err = s.writeBlobEntry(tw, dgst); err != nil {
+			return err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return fmt.Errorf("write image archive: %w", err)
+	}
+	return nil
+}
+
+// writeBlobEntry writes the blob to the tar archive under blobs/sha256/.
+func (s *Store) writeBlobEntry(tw *tar.Writer, dgst digest.Digest) error {
+	f, err := s.Open(dgst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat blob '%s': %w", dgst, err)
+	}
+	hdr := &tar.Header{
+		Name:     "blobs/sha256/" + dgst.Encoded(),
+		Mode:     0o644,
+		Size:     fi.Size(),
+		Typeflag: tar.TypeReg,
+	}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write image archive: %w", err)
+	}
+	if _, err = io.Copy(tw, f); err != nil {
+		return fmt.Errorf("write blob '%s' to image archive: %w", dgst, err)
+	}
+	return nil
+}
+
+// imageBlobs returns the digests of the blobs of the image described by desc present in the store: the index and
+// the manifests of the platforms that have been pushed, and their configs and layers. A manifest must have all its
+// blobs except non-distributable layers that are never pushed.
+func (s *Store) imageBlobs(desc ocispec.Descriptor) ([]digest.Digest, error) {
+	var (
+		blobs []digest.Digest
+		seen  = make(map[digest.Digest]struct{})
+	)
+	var walk func(desc ocispec.Descriptor, optional bool) error
+	walk = func(desc ocispec.Descriptor, optional bool) error {
+		if _, ok := seen[desc.Digest]; ok {
+			return nil
+		}
+		if _, err := s.Stat(desc.Digest); err != nil {
+			if errors.Is(err, distribution.ErrBlobUnknown) && (optional || isNonDistributable(desc.MediaType)) {
+				return nil
+			}
+			if errors.Is(err, distribution.ErrBlobUnknown) {
+				return distribution.ErrManifestBlobUnknown{Digest: desc.Digest}
+			}
+			return err
+		}
+		seen[desc.Digest] = struct{}{}
+		blobs = append(blobs, desc.Digest)
+
+		if !images.IsManifestType(desc.MediaType) && !images.IsIndexType(desc.MediaType) {
+			return nil
+		}
+		data, err := s.Get(desc.Digest)
+		if err != nil {
+			return err
+		}
+		var m struct {
+			Manifests []ocispec.Descriptor `json:"manifests"`
+			Config    *ocispec.Descriptor  `json:"config"`
+			Layers    []ocispec.Descriptor `json:"layers"`
+		}
+		if err = json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("decode manifest '%s': %w", desc.Digest, err)
+		}
+		for _, child := range m.Manifests {
+			// Only some of the platforms of a multi-platform image may have been pushed.
+			if err = walk(child, true); err != nil {
+				return err
+			}
+		}
+		if m.Config != nil {
+			if err = walk(*m.Config, false); err != nil {
+				return err
+			}
+		}
+		for _, layer := range m.Layers {
+			if err = walk(layer, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(desc, false); err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+// isNonDistributable returns true if the layer media type is a non-distributable (foreign) layer that clients don't
+// push to registries.
+func isNonDistributable(mediaType string) bool {
+	return strings.Contains(mediaType, "foreign") || strings.Contains(mediaType, "nondistributable")
+}
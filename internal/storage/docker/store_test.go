@@ -0,0 +1,313 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/google/uuid"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// putBlob writes the data to the store and returns its descriptor.
+func putBlob(t *testing.T, store *Store, mediaType string, data []byte) ocispec.Descriptor {
+	t.Helper()
+	dgst := digest.FromBytes(data)
+	if _, err := store.Put(dgst, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	return ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(data))}
+}
+
+// putManifest writes an image manifest with the config and layers to the store and returns its descriptor.
+func putManifest(
+	t *testing.T, store *Store, config ocispec.Descriptor, layers ...ocispec.Descriptor,
+) ocispec.Descriptor {
+	t.Helper()
+	data, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    layers,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return putBlob(t, store, ocispec.MediaTypeImageManifest, data)
+}
+
+// setUsedAt sets the last use time of the blob.
+func setUsedAt(t *testing.T, store *Store, dgst digest.Digest, usedAt time.Time) {
+	t.Helper()
+	path, err := store.blobPath(dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(path, usedAt, usedAt); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorePut(t *testing.T) {
+	store := newTestStore(t)
+	data := []byte("blob")
+
+	t.Run("valid digest", func(t *testing.T) {
+		dgst := digest.FromBytes(data)
+		if _, err := store.Put(dgst, bytes.NewReader(data)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := store.Get(dgst)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("expected %q, got %q", data, got)
+		}
+	})
+
+	t.Run("mismatched digest", func(t *testing.T) {
+		dgst := digest.FromString("other")
+		_, err := store.Put(dgst, bytes.NewReader(data))
+		var invalid distribution.ErrBlobInvalidDigest
+		if !errors.As(err, &invalid) {
+			t.Fatalf("expected ErrBlobInvalidDigest, got %v", err)
+		}
+		if _, err = store.Stat(dgst); !errors.Is(err, distribution.ErrBlobUnknown) {
+			t.Fatalf("expected ErrBlobUnknown for the rejected blob, got %v", err)
+		}
+	})
+
+	t.Run("no temporary files left", func(t *testing.T) {
+		entries, err := os.ReadDir(filepath.Join(store.Dir(), "blobs", "sha256"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), ".tmp-") {
+				t.Fatalf("temporary file %s left in the store", e.Name())
+			}
+		}
+	})
+}
+
+func TestStoreCommitUpload(t *testing.T) {
+	store := newTestStore(t)
+	data := []byte("uploaded blob")
+	id := uuid.NewString()
+	path, err := store.uploadPath(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	dgst := digest.FromBytes(data)
+	size, err := store.commitUpload(id, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if size != int64(len(data)) {
+		t.Fatalf("expected size %d, got %d", len(data), size)
+	}
+	if _, err = os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected upload data to be deleted, got %v", err)
+	}
+	if _, err = store.commitUpload(id, dgst); !errors.Is(err, distribution.ErrBlobUploadUnknown) {
+		t.Fatalf("expected ErrBlobUploadUnknown for committed upload, got %v", err)
+	}
+	if _, err = store.commitUpload("../blobs", dgst); !errors.Is(err, distribution.ErrBlobUploadUnknown) {
+		t.Fatalf("expected ErrBlobUploadUnknown for invalid upload ID, got %v", err)
+	}
+}
+
+func TestStorePurgeIdleBlobs(t *testing.T) {
+	store := newTestStore(t)
+	idle := putBlob(t, store, "", []byte("idle"))
+	recent := putBlob(t, store, "", []byte("recent"))
+	used := putBlob(t, store, "", []byte("used"))
+	setUsedAt(t, store, idle.Digest, time.Now().Add(-2*time.Hour))
+	setUsedAt(t, store, used.Digest, time.Now().Add(-2*time.Hour))
+	// Checking a blob exists, e.g. by a push before pushing the manifest referencing it, is a use of the blob.
+	if _, err := store.Stat(used.Digest); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := store.PurgeIdleBlobs(time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(purged) != 1 || purged[0].Digest != idle.Digest || purged[0].Size != idle.Size {
+		t.Fatalf("expected only %s to be purged, got %+v", idle.Digest, purged)
+	}
+	if _, err = store.Stat(idle.Digest); !errors.Is(err, distribution.ErrBlobUnknown) {
+		t.Fatalf("expected idle blob to be deleted, got %v", err)
+	}
+	for _, desc := range []ocispec.Descriptor{recent, used} {
+		if _, err = store.Stat(desc.Digest); err != nil {
+			t.Fatalf("expected blob %s to be kept, got %v", desc.Digest, err)
+		}
+	}
+}
+
+func TestStoreImageBlobs(t *testing.T) {
+	store := newTestStore(t)
+	config := putBlob(t, store, ocispec.MediaTypeImageConfig, []byte("{}"))
+	layer := putBlob(t, store, ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	manifest := putManifest(t, store, config, layer)
+	foreign := ocispec.Descriptor{
+		MediaType: "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip",
+		Digest:    digest.FromString("foreign"),
+		Size:      7,
+	}
+	withForeign := putManifest(t, store, config, layer, foreign)
+	missingLayer := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    digest.FromString("missing"),
+		Size:      7,
+	}
+	incomplete := putManifest(t, store, config, missingLayer)
+
+	indexData, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{
+			manifest,
+			// Only some platforms of a multi-platform image may have been pushed.
+			{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("other platform"), Size: 14},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := putBlob(t, store, ocispec.MediaTypeImageIndex, indexData)
+
+	tests := []struct {
+		name    string
+		desc    ocispec.Descriptor
+		want    []digest.Digest
+		wantErr bool
+	}{
+		{
+			name: "manifest",
+			desc: manifest,
+			want: []digest.Digest{manifest.Digest, config.Digest, layer.Digest},
+		},
+		{
+			name: "manifest with non-distributable layer",
+			desc: withForeign,
+			want: []digest.Digest{withForeign.Digest, config.Digest, layer.Digest},
+		},
+		{
+			name: "index with missing platform",
+			desc: index,
+			want: []digest.Digest{index.Digest, manifest.Digest, config.Digest, layer.Digest},
+		},
+		{name: "manifest with missing layer", desc: incomplete, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.imageBlobs(tt.desc)
+			if tt.wantErr {
+				var unknown distribution.ErrManifestBlobUnknown
+				if !errors.As(err, &unknown) {
+					t.Fatalf("expected ErrManifestBlobUnknown, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStoreArchiveRoundTrip(t *testing.T) {
+	src := newTestStore(t)
+	config := putBlob(t, src, ocispec.MediaTypeImageConfig, []byte("{}"))
+	layer := putBlob(t, src, ocispec.MediaTypeImageLayerGzip, []byte("layer"))
+	manifest := putManifest(t, src, config, layer)
+
+	var archive bytes.Buffer
+	ref := "docker.io/library/app:latest"
+	if err := src.exportArchive(&archive, manifest, ref, "latest"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	dst := newTestStore(t)
+	index, err := dst.importArchive(&archive)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != manifest.Digest {
+		t.Fatalf("expected index with manifest %s, got %+v", manifest.Digest, index.Manifests)
+	}
+	if name := index.Manifests[0].Annotations["io.containerd.image.name"]; name != ref {
+		t.Fatalf("expected image name annotation %s, got %s", ref, name)
+	}
+	for _, desc := range []ocispec.Descriptor{manifest, config, layer} {
+		if _, err = dst.Stat(desc.Digest); err != nil {
+			t.Fatalf("expected blob %s to be imported, got %v", desc.Digest, err)
+		}
+	}
+}
+
+func TestRefLocks(t *testing.T) {
+	var locks refLocks
+
+	// Different references are locked independently.
+	unlockA := locks.lock("a")
+	unlockB := locks.lock("b")
+	unlockB()
+
+	// The same reference is locked exclusively.
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		acquired bool
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		unlock := locks.lock("a")
+		mu.Lock()
+		acquired = true
+		mu.Unlock()
+		unlock()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	if acquired {
+		t.Fatal("lock of the same reference acquired while held")
+	}
+	mu.Unlock()
+	unlockA()
+	wg.Wait()
+
+	if len(locks.locks) != 0 {
+		t.Fatalf("expected unlocked mutexes to be deleted, got %d", len(locks.locks))
+	}
+}
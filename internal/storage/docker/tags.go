@@ -0,0 +1,214 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/containerd/errdefs"
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// tagService implements distribution.TagService backed by the Docker image store.
+type tagService struct {
+	registry *registry
+	repo     reference.Named
+	// canonicalRepo is the repository reference in a normalized form, the way the Docker daemon names images,
+	// for example, "docker.io/library/ubuntu".
+	canonicalRepo reference.Named
+}
+
+var _ distribution.TagService = &tagService{}
+
+// Get retrieves an image descriptor by its tag from the Docker image store. The image is saved from the daemon into
+// the blob store unless it has already been saved or pushed, the tag still points to the same image, and its blobs
+// haven't been purged from the blob store.
+func (t *tagService) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
+	ref, err := reference.WithTag(t.canonicalRepo, tag)
+	if err != nil {
+		return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
+	}
+	img, err := t.registry.client.ImageInspect(ctx, ref.String())
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
+		}
+		return distribution.Descriptor{}, err
+	}
+
+	unlock := t.registry.locks.lock(ref.String())
+	defer unlock()
+
+	// The classic Docker image store doesn't keep the original manifests so saving a pushed image generates new ones
+	// with different digests. Return the pushed descriptor while the tag points to the same image.
+	if saved, ok := t.registry.savedImage(ref.String()); ok && saved.id == img.ID {
+		if _, err = t.registry.store.imageBlobs(saved.desc); err == nil {
+			return saved.desc, nil
+		}
+	}
+
+	desc, err := t.save(ctx, ref)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	t.registry.setSavedImage(ref.String(), savedImage{id: img.ID, desc: desc})
+	logrus.WithFields(logrus.Fields{
+		"image":  ref.String(),
+		"digest": desc.Digest,
+	}).Debug("Saved image from Docker image store.")
+
+	return desc, nil
+}
+
+// save exports the image from the Docker daemon into the blob store and returns its root descriptor.
+func (t *tagService) save(ctx context.Context, ref reference.NamedTagged) (distribution.Descriptor, error) {
+	archive, err := t.registry.client.ImageSave(ctx, ref.String())
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	defer archive.Close()
+
+	index, err := t.registry.store.importArchive(archive)
+	if err != nil {
+		return distribution.Descriptor{}, fmt.Errorf("import image '%s' saved from docker: %w", ref.String(), err)
+	}
+	for _, desc := range index.Manifests {
+		name := desc.Annotations["io.containerd.image.name"]
+		if name == ref.String() || name == reference.FamiliarString(ref) ||
+			desc.Annotations[ocispec.AnnotationRefName] == ref.Tag() {
+			return withoutAnnotations(desc), nil
+		}
+	}
+	if len(index.Manifests) == 1 {
+		return withoutAnnotations(index.Manifests[0]), nil
+	}
+	return distribution.Descriptor{}, fmt.Errorf("image '%s' not found in the archive saved from docker",
+		ref.String())
+}
+
+// withoutAnnotations returns the descriptor without the annotations of the image archive index.
+func withoutAnnotations(desc ocispec.Descriptor) distribution.Descriptor {
+	desc.Annotations = nil
+	return desc
+}
+
+// Tag loads the image described by desc into the Docker image store and tags it with the given tag. All the image
+// blobs must be present in the blob store.
+func (t *tagService) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
+	ref, err := reference.WithTag(t.canonicalRepo, tag)
+	if err != nil {
+		return err
+	}
+	// Check the image is complete before streaming it to the daemon to return a registry error instead of
+	// a failed load.
+	if _, err = t.registry.store.imageBlobs(desc); err != nil {
+		return err
+	}
+
+	unlock := t.registry.locks.lock(ref.String())
+	defer unlock()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(t.registry.store.exportArchive(pw, desc, ref.String(), tag))
+	}()
+	err = t.registry.client.ImageLoad(ctx, pr)
+	// Unblock the archive writer if the daemon stopped reading early.
+	_ = pr.CloseWithError(errors.New("image load finished"))
+	if err != nil {
+		return fmt.Errorf("load image '%s' into docker: %w", ref.String(), err)
+	}
+
+	img, err := t.registry.client.ImageInspect(ctx, ref.String())
+	if err != nil {
+		if errdefs.IsNotFound(err) {
+			return fmt.Errorf("docker daemon loaded the image but it doesn't appear as '%s' in its image store",
+				ref.String())
+		}
+		return err
+	}
+	t.registry.setSavedImage(ref.String(), savedImage{id: img.ID, desc: desc})
+	logrus.WithFields(logrus.Fields{
+		"image":  ref.String(),
+		"digest": desc.Digest,
+	}).Info("Loaded image into Docker image store.")
+
+	return nil
+}
+
+// Untag removes the tag from the Docker image store. The image is deleted by the daemon if it has no other tags.
+// It returns distribution.ErrUnsupported if deletes are disabled.
+func (t *tagService) Untag(ctx context.Context, tag string) error {
+	if !t.registry.deletes {
+		return distribution.ErrUnsupported
+	}
+	ref, err := reference.WithTag(t.canonicalRepo, tag)
+	if err != nil {
+		return distribution.ErrTagUnknown{Tag: tag}
+	}
+	if err = t.registry.client.ImageRemove(ctx, ref.String()); err != nil {
+		if errdefs.IsNotFound(err) {
+			return distribution.ErrTagUnknown{Tag: tag}
+		}
+		return err
+	}
+
+	t.registry.mu.Lock()
+	delete(t.registry.images, ref.String())
+	t.registry.mu.Unlock()
+	logrus.WithField("image", ref.String()).Info("Removed image from Docker image store.")
+
+	return nil
+}
+
+// All returns the sorted tags of the images in the repository from the Docker image store. It returns
+// distribution.ErrRepositoryUnknown if there are no tagged images in the repository.
+func (t *tagService) All(ctx context.Context) ([]string, error) {
+	imgs, err := t.registry.client.ImageList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for _, img := range imgs {
+		for _, repoTag := range img.RepoTags {
+			named, err := reference.ParseNormalizedNamed(repoTag)
+			if err != nil || named.Name() != t.canonicalRepo.Name() {
+				continue
+			}
+			if tagged, ok := named.(reference.Tagged); ok {
+				tags = append(tags, tagged.Tag())
+			}
+		}
+	}
+	if len(tags) == 0 {
+		return nil, distribution.ErrRepositoryUnknown{Name: t.repo.Name()}
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags), nil
+}
+
+// Lookup returns the sorted tags in the repository that point to the descriptor. Only the images that have been
+// pushed or pulled through the registry are known by their descriptors.
+func (t *tagService) Lookup(_ context.Context, desc distribution.Descriptor) ([]string, error) {
+	t.registry.mu.Lock()
+	defer t.registry.mu.Unlock()
+
+	var tags []string
+	for name, img := range t.registry.images {
+		named, err := reference.ParseNormalizedNamed(name)
+		if err != nil || named.Name() != t.canonicalRepo.Name() || img.desc.Digest != desc.Digest {
+			continue
+		}
+		if tagged, ok := named.(reference.Tagged); ok {
+			tags = append(tags, tagged.Tag())
+		}
+	}
+	slices.Sort(tags)
+	return tags, nil
+}
@@ -0,0 +1,398 @@
+// Package fallback implements a registry storage wrapper that serves reads of images missing in the primary storage
+// from a chain of fallback storages, e.g. other containerd namespaces or OCI image layout directories on the same
+// host. Writes always go to the primary storage.
+package fallback
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// Backend is a read-only fallback storage consulted when an image isn't found in the primary storage.
+type Backend struct {
+	// Name identifies the backend in logs, e.g. "containerd:k8s.io".
+	Name      string
+	Namespace distribution.Namespace
+}
+
+// New wraps the primary registry to fall back to the backends in order for reads. It returns primary as is if there
+// are no backends.
+func New(primary distribution.Namespace, backends []Backend) distribution.Namespace {
+	if len(backends) == 0 {
+		return primary
+	}
+	return &registry{Namespace: primary, backends: backends}
+}
+
+// registry implements distribution.Namespace that falls back to the backends for reads of the images, tags and blobs
+// missing in the primary registry. Repository listing merges the repositories of the primary registry and the backends.
+// Blob enumeration is served by the primary registry only.
+type registry struct {
+	distribution.Namespace
+	backends []Backend
+}
+
+// Repository returns the repository of the primary registry falling back to the repositories of the backends for
+// reads. Access to the repositories denied by the primary registry is denied for the backends as well.
+func (r *registry) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+	primary, err := r.Namespace.Repository(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	repo := &repository{Repository: primary}
+	for _, b := range r.backends {
+		fr, err := b.Namespace.Repository(ctx, name)
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"backend": b.Name,
+				"repo":    name.Name(),
+			}).Debug("Skipped fallback backend for repository.")
+			continue
+		}
+		repo.fallbacks = append(repo.fallbacks, fallbackRepository{name: b.Name, repo: fr})
+	}
+	return repo, nil
+}
+
+// Repositories fills repos with the sorted names of repositories in the primary registry and all backends that come
+// after last in the lexical order. It returns the number of filled names and io.EOF if there are no more repositories
+// after them. Backends that don't support listing repositories are skipped.
+func (r *registry) Repositories(ctx context.Context, repos []string, last string) (int, error) {
+	names, err := listRepositories(ctx, r.Namespace)
+	if err != nil {
+		return 0, err
+	}
+	for _, b := range r.backends {
+		bnames, err := listRepositories(ctx, b.Namespace)
+		if err != nil {
+			if !errors.Is(err, distribution.ErrUnsupported) {
+				logrus.WithError(err).WithField("backend", b.Name).Warn(
+					"Failed to list repositories of fallback backend.")
+			}
+			continue
+		}
+		names = append(names, bnames...)
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	n := 0
+	for _, name := range names {
+		if name <= last {
+			continue
+		}
+		if n == len(repos) {
+			return n, nil
+		}
+		repos[n] = name
+		n++
+	}
+	return n, io.EOF
+}
+
+// listRepositoriesPageSize is the number of repository names requested from a registry at a time.
+const listRepositoriesPageSize = 100
+
+// listRepositories returns the names of all repositories in the registry.
+func listRepositories(ctx context.Context, ns distribution.Namespace) ([]string, error) {
+	var (
+		names []string
+		last  string
+	)
+	page := make([]string, listRepositoriesPageSize)
+	for {
+		n, err := ns.Repositories(ctx, page, last)
+		names = append(names, page[:n]...)
+		if errors.Is(err, io.EOF) {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return names, nil
+		}
+		last = page[n-1]
+	}
+}
+
+// BlobStatter returns a blob statter that falls back to the backends for the blobs missing in the primary registry.
+func (r *registry) BlobStatter() distribution.BlobStatter {
+	statters := []distribution.BlobStatter{r.Namespace.BlobStatter()}
+	for _, b := range r.backends {
+		statters = append(statters, b.Namespace.BlobStatter())
+	}
+	return chainStatter(statters)
+}
+
+// chainStatter stats a blob in the first statter that has it. Only the first statter is consulted for uploads,
+// see blobStore.Stat.
+type chainStatter []distribution.BlobStatter
+
+func (c chainStatter) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	statters := c
+	if !downloadRequest(ctx) {
+		statters = c[:1]
+	}
+	var (
+		desc distribution.Descriptor
+		err  error
+	)
+	for _, s := range statters {
+		if desc, err = s.Stat(ctx, dgst); !errors.Is(err, distribution.ErrBlobUnknown) {
+			return desc, err
+		}
+	}
+	return desc, err
+}
+
+// fallbackRepository is a repository in a fallback backend.
+type fallbackRepository struct {
+	name string
+	repo distribution.Repository
+}
+
+// repository implements distribution.Repository that falls back to the repositories of the backends for reads.
+type repository struct {
+	distribution.Repository
+	fallbacks []fallbackRepository
+}
+
+// Manifests returns the manifest service of the primary repository falling back to the backends for reads.
+func (r *repository) Manifests(
+	ctx context.Context, options ...distribution.ManifestServiceOption,
+) (distribution.ManifestService, error) {
+	primary, err := r.Repository.Manifests(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	ms := &manifestService{ManifestService: primary}
+	for _, f := range r.fallbacks {
+		fms, err := f.repo.Manifests(ctx, options...)
+		if err != nil {
+			logrus.WithError(err).WithField("backend", f.name).Debug("Skipped manifests of fallback backend.")
+			continue
+		}
+		ms.fallbacks = append(ms.fallbacks, namedManifestService{name: f.name, ManifestService: fms})
+	}
+	return ms, nil
+}
+
+// Blobs returns the blob store of the primary repository falling back to the backends for reads.
+func (r *repository) Blobs(ctx context.Context) distribution.BlobStore {
+	bs := &blobStore{BlobStore: r.Repository.Blobs(ctx)}
+	for _, f := range r.fallbacks {
+		bs.fallbacks = append(bs.fallbacks, namedBlobStore{name: f.name, BlobStore: f.repo.Blobs(ctx)})
+	}
+	return bs
+}
+
+// Tags returns the tag service of the primary repository falling back to the backends for reads.
+func (r *repository) Tags(ctx context.Context) distribution.TagService {
+	ts := &tagService{TagService: r.Repository.Tags(ctx)}
+	for _, f := range r.fallbacks {
+		ts.fallbacks = append(ts.fallbacks, namedTagService{name: f.name, TagService: f.repo.Tags(ctx)})
+	}
+	return ts
+}
+
+type namedManifestService struct {
+	name string
+	distribution.ManifestService
+}
+
+// manifestService implements distribution.ManifestService that falls back to the backends for the manifests
+// missing in the primary storage.
+type manifestService struct {
+	distribution.ManifestService
+	fallbacks []namedManifestService
+}
+
+// Exists checks if a manifest exists in the primary storage or any of the backends.
+func (m *manifestService) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	ok, err := m.ManifestService.Exists(ctx, dgst)
+	if ok || err != nil {
+		return ok, err
+	}
+	for _, f := range m.fallbacks {
+		if ok, err = f.Exists(ctx, dgst); ok && err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Get retrieves a manifest from the primary storage or the first backend that has it.
+func (m *manifestService) Get(
+	ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption,
+) (distribution.Manifest, error) {
+	manifest, err := m.ManifestService.Get(ctx, dgst, options...)
+	if !isManifestUnknown(err) {
+		return manifest, err
+	}
+	for _, f := range m.fallbacks {
+		fm, ferr := f.Get(ctx, dgst, options...)
+		if ferr == nil {
+			logrus.WithFields(logrus.Fields{
+				"backend": f.name,
+				"digest":  dgst,
+			}).Debug("Served manifest from fallback backend.")
+			return fm, nil
+		}
+		if !isManifestUnknown(ferr) {
+			logrus.WithError(ferr).WithField("backend", f.name).Debug("Failed to get manifest from fallback backend.")
+		}
+	}
+	return manifest, err
+}
+
+// isManifestUnknown returns true if the error means that the manifest doesn't exist.
+func isManifestUnknown(err error) bool {
+	var unknownRevision distribution.ErrManifestUnknownRevision
+	var unknown distribution.ErrManifestUnknown
+	return errors.As(err, &unknownRevision) || errors.As(err, &unknown) ||
+		errors.Is(err, distribution.ErrBlobUnknown)
+}
+
+type namedBlobStore struct {
+	name string
+	distribution.BlobStore
+}
+
+// blobStore implements distribution.BlobStore that falls back to the backends for the blobs missing in the primary
+// storage. Uploads always go to the primary storage.
+type blobStore struct {
+	distribution.BlobStore
+	fallbacks []namedBlobStore
+}
+
+// Stat returns metadata about a blob in the primary storage or the first backend that has it. Blobs are only looked up
+// in the backends for downloads. A client checks if a blob exists with a HEAD request before uploading it and skips
+// the upload if it does, so a blob found in a backend would be missing in the image written to the primary storage.
+func (b *blobStore) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	desc, err := b.BlobStore.Stat(ctx, dgst)
+	if !errors.Is(err, distribution.ErrBlobUnknown) || !downloadRequest(ctx) {
+		return desc, err
+	}
+	for _, f := range b.fallbacks {
+		if fdesc, ferr := f.Stat(ctx, dgst); ferr == nil {
+			return fdesc, nil
+		}
+	}
+	return desc, err
+}
+
+// Get retrieves the content of a blob from the primary storage or the first backend that has it.
+func (b *blobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	data, err := b.BlobStore.Get(ctx, dgst)
+	if !errors.Is(err, distribution.ErrBlobUnknown) {
+		return data, err
+	}
+	for _, f := range b.fallbacks {
+		if fdata, ferr := f.Get(ctx, dgst); ferr == nil {
+			return fdata, nil
+		}
+	}
+	return data, err
+}
+
+// Open opens the blob from the primary storage or the first backend that has it for reading.
+func (b *blobStore) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	rsc, err := b.BlobStore.Open(ctx, dgst)
+	if !errors.Is(err, distribution.ErrBlobUnknown) {
+		return rsc, err
+	}
+	for _, f := range b.fallbacks {
+		if frsc, ferr := f.Open(ctx, dgst); ferr == nil {
+			return frsc, nil
+		}
+	}
+	return rsc, err
+}
+
+// ServeBlob serves the blob from the primary storage or the first backend that has it. A blob is looked up with Stat
+// first so that nothing is written to the response by a storage that doesn't have it.
+func (b *blobStore) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
+	_, err := b.BlobStore.Stat(ctx, dgst)
+	if !errors.Is(err, distribution.ErrBlobUnknown) {
+		return b.BlobStore.ServeBlob(ctx, w, r, dgst)
+	}
+	for _, f := range b.fallbacks {
+		if _, ferr := f.Stat(ctx, dgst); ferr == nil {
+			logrus.WithFields(logrus.Fields{
+				"backend": f.name,
+				"digest":  dgst,
+			}).Debug("Serving blob from fallback backend.")
+			return f.ServeBlob(ctx, w, r, dgst)
+		}
+	}
+	return err
+}
+
+// downloadRequest returns true if the registry request in the context downloads a blob or there is no registry
+// request, e.g. the blob is read by an admin API handler.
+func downloadRequest(ctx context.Context) bool {
+	req, _ := ctx.Value("http.request").(*http.Request)
+	return req == nil || req.Method == http.MethodGet
+}
+
+type namedTagService struct {
+	name string
+	distribution.TagService
+}
+
+// tagService implements distribution.TagService that falls back to the backends for the tags missing in the primary
+// storage. Tagging and untagging only affect the primary storage.
+type tagService struct {
+	distribution.TagService
+	fallbacks []namedTagService
+}
+
+// Get retrieves the descriptor of the tag from the primary storage or the first backend that has it.
+func (t *tagService) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
+	desc, err := t.TagService.Get(ctx, tag)
+	var unknown distribution.ErrTagUnknown
+	if !errors.As(err, &unknown) {
+		return desc, err
+	}
+	for _, f := range t.fallbacks {
+		fdesc, ferr := f.Get(ctx, tag)
+		if ferr == nil {
+			logrus.WithFields(logrus.Fields{
+				"backend": f.name,
+				"tag":     tag,
+				"digest":  fdesc.Digest,
+			}).Debug("Resolved tag in fallback backend.")
+			return fdesc, nil
+		}
+	}
+	return desc, err
+}
+
+// All returns the sorted tags of the repository in the primary storage and all backends.
+func (t *tagService) All(ctx context.Context) ([]string, error) {
+	tags, err := t.TagService.All(ctx)
+	var unknown distribution.ErrRepositoryUnknown
+	if err != nil && !errors.As(err, &unknown) {
+		return nil, err
+	}
+	for _, f := range t.fallbacks {
+		ftags, ferr := f.All(ctx)
+		if ferr == nil {
+			tags = append(tags, ftags...)
+		}
+	}
+	if len(tags) == 0 && err != nil {
+		return nil, err
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags), nil
+}
@@ -0,0 +1,229 @@
+package fallback
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/psviderski/unregistry/internal/registrytest"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/psviderski/unregistry/pkg/storage"
+)
+
+// mapBlobStore is a blob store that only implements Stat for the blobs in the map.
+type mapBlobStore struct {
+	distribution.BlobStore
+	blobs map[digest.Digest]int64
+}
+
+func (m mapBlobStore) Stat(_ context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	size, ok := m.blobs[dgst]
+	if !ok {
+		return distribution.Descriptor{}, distribution.ErrBlobUnknown
+	}
+	return distribution.Descriptor{Digest: dgst, Size: size}, nil
+}
+
+func TestBlobStoreStat(t *testing.T) {
+	primaryBlob := digest.FromString("primary")
+	fallbackBlob := digest.FromString("fallback")
+	store := &blobStore{
+		BlobStore: mapBlobStore{blobs: map[digest.Digest]int64{primaryBlob: 1}},
+		fallbacks: []namedBlobStore{
+			{name: "other", BlobStore: mapBlobStore{blobs: map[digest.Digest]int64{fallbackBlob: 2}}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		dgst    digest.Digest
+		wantErr bool
+	}{
+		{name: "primary blob on download", method: http.MethodGet, dgst: primaryBlob},
+		{name: "primary blob before upload", method: http.MethodHead, dgst: primaryBlob},
+		{name: "fallback blob on download", method: http.MethodGet, dgst: fallbackBlob},
+		{name: "fallback blob without request", dgst: fallbackBlob},
+		{name: "fallback blob before upload", method: http.MethodHead, dgst: fallbackBlob, wantErr: true},
+		{name: "fallback blob on upload", method: http.MethodPut, dgst: fallbackBlob, wantErr: true},
+		{name: "missing blob", method: http.MethodGet, dgst: digest.FromString("missing"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.method != "" {
+				req, err := http.NewRequest(tt.method, "/v2/app/blobs/"+tt.dgst.String(), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// The registry app stores the request in the context under this key.
+				ctx = context.WithValue(ctx, "http.request", req)
+			}
+
+			desc, err := store.Stat(ctx, tt.dgst)
+			if tt.wantErr {
+				if !errors.Is(err, distribution.ErrBlobUnknown) {
+					t.Fatalf("expected ErrBlobUnknown, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if desc.Digest != tt.dgst {
+				t.Fatalf("expected digest %s, got %s", tt.dgst, desc.Digest)
+			}
+		})
+	}
+}
+
+// createImage writes an image with the layer to the content store of the client, names it with the references, and
+// returns the descriptors of its manifest and layer.
+func createImage(
+	t *testing.T, cli *client.Client, layer string, refs ...string,
+) (ocispec.Descriptor, ocispec.Descriptor) {
+	t.Helper()
+	store := cli.ContentStore()
+	config := registrytest.WriteBlob(t, store, ocispec.MediaTypeImageConfig,
+		[]byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`))
+	layerDesc := registrytest.WriteBlob(t, store, ocispec.MediaTypeImageLayer, []byte(layer))
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layerDesc},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := registrytest.WriteBlob(t, store, ocispec.MediaTypeImageManifest, manifest)
+	for _, ref := range refs {
+		if _, err = cli.ImageService().Create(context.Background(), images.Image{Name: ref, Target: desc}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return desc, layerDesc
+}
+
+// newContainerdNamespace returns the containerd registry storage of the client.
+func newContainerdNamespace(t *testing.T, cli *client.Client) distribution.Namespace {
+	t.Helper()
+	ns, err := storage.New(context.Background(), containerd.BackendName, storage.Options{"client": cli})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ns
+}
+
+func TestFallbackChain(t *testing.T) {
+	ctx := context.Background()
+	primaryCli := registrytest.NewClient(t)
+	primaryManifest, _ := createImage(t, primaryCli, "primary", "docker.io/library/myapp:latest")
+	firstCli := registrytest.NewClient(t)
+	firstManifest, _ := createImage(t, firstCli, "first",
+		"docker.io/library/myapp:latest", "docker.io/library/myapp:1.0")
+	secondCli := registrytest.NewClient(t)
+	_, shadowedLayer := createImage(t, secondCli, "second", "docker.io/library/myapp:1.0")
+	secondManifest, secondLayer := createImage(t, secondCli, "other",
+		"docker.io/library/myapp:2.0", "docker.io/library/other:latest")
+
+	ns := New(newContainerdNamespace(t, primaryCli), []Backend{
+		{Name: "containerd:first", Namespace: newContainerdNamespace(t, firstCli)},
+		{Name: "containerd:second", Namespace: newContainerdNamespace(t, secondCli)},
+	})
+	name, err := reference.WithName("myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := ns.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("tags resolve in the first storage that has them", func(t *testing.T) {
+		want := map[string]digest.Digest{
+			"latest": primaryManifest.Digest,
+			"1.0":    firstManifest.Digest,
+			"2.0":    secondManifest.Digest,
+		}
+		for tag, dgst := range want {
+			desc, err := repo.Tags(ctx).Get(ctx, tag)
+			if err != nil {
+				t.Fatalf("get tag %s: %v", tag, err)
+			}
+			if desc.Digest != dgst {
+				t.Fatalf("expected tag %s to resolve to %s, got %s", tag, dgst, desc.Digest)
+			}
+		}
+		if _, err = repo.Tags(ctx).Get(ctx, "missing"); !errors.As(err, &distribution.ErrTagUnknown{}) {
+			t.Fatalf("expected ErrTagUnknown for a missing tag, got %v", err)
+		}
+	})
+
+	t.Run("tags are merged", func(t *testing.T) {
+		tags, err := repo.Tags(ctx).All(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"1.0", "2.0", "latest"}; !slices.Equal(tags, want) {
+			t.Fatalf("expected tags %v, got %v", want, tags)
+		}
+	})
+
+	t.Run("manifests fall back", func(t *testing.T) {
+		ms, err := repo.Manifests(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, dgst := range []digest.Digest{primaryManifest.Digest, firstManifest.Digest, secondManifest.Digest} {
+			if ok, err := ms.Exists(ctx, dgst); !ok || err != nil {
+				t.Fatalf("expected manifest %s to exist, got %v, %v", dgst, ok, err)
+			}
+			if _, err = ms.Get(ctx, dgst); err != nil {
+				t.Fatalf("get manifest %s: %v", dgst, err)
+			}
+		}
+		if ok, _ := ms.Exists(ctx, digest.FromString("missing")); ok {
+			t.Fatal("expected a missing manifest not to exist")
+		}
+	})
+
+	t.Run("blobs fall back for downloads", func(t *testing.T) {
+		for _, layer := range []ocispec.Descriptor{secondLayer, shadowedLayer} {
+			rc, err := repo.Blobs(ctx).Open(ctx, layer.Digest)
+			if err != nil {
+				t.Fatalf("open blob %s: %v", layer.Digest, err)
+			}
+			data, err := io.ReadAll(rc)
+			_ = rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if digest.FromBytes(data) != layer.Digest {
+				t.Fatalf("expected the content of blob %s, got %q", layer.Digest, data)
+			}
+		}
+	})
+
+	t.Run("repositories are merged", func(t *testing.T) {
+		repos := make([]string, 10)
+		n, err := ns.Repositories(ctx, repos, "")
+		if !errors.Is(err, io.EOF) {
+			t.Fatalf("expected io.EOF after the last repository, got %v", err)
+		}
+		if want := []string{"myapp", "other"}; !slices.Equal(repos[:n], want) {
+			t.Fatalf("expected repositories %v, got %v", want, repos[:n])
+		}
+	})
+}
@@ -0,0 +1,389 @@
+// Package ocilayout implements a read-only registry storage backed by a directory in the OCI image layout, e.g.
+// created with "skopeo copy" or "oras copy --to-oci-layout", or an extracted "docker save" archive.
+package ocilayout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// containerdImageNameAnnotation is the annotation of the full image reference set by containerd and Docker when
+// exporting images.
+const containerdImageNameAnnotation = "io.containerd.image.name"
+
+// Registry implements a read-only distribution.Namespace serving the images of an OCI image layout directory.
+// The index is read on every tag lookup so images added to the directory are served without a restart.
+type Registry struct {
+	dir string
+}
+
+var _ distribution.Namespace = &Registry{}
+
+// New creates a registry serving the OCI image layout in dir. It fails if dir isn't an OCI image layout.
+func New(dir string) (*Registry, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ocispec.ImageLayoutFile))
+	if err != nil {
+		return nil, fmt.Errorf("read OCI image layout '%s': %w", dir, err)
+	}
+	var layout ocispec.ImageLayout
+	if err = json.Unmarshal(data, &layout); err != nil {
+		return nil, fmt.Errorf("decode OCI image layout '%s': %w", dir, err)
+	}
+	if layout.Version != ocispec.ImageLayoutVersion {
+		return nil, fmt.Errorf("unsupported OCI image layout version '%s' in '%s'", layout.Version, dir)
+	}
+	return &Registry{dir: dir}, nil
+}
+
+// Scope returns the global scope for this registry.
+func (r *Registry) Scope() distribution.Scope {
+	return distribution.GlobalScope
+}
+
+// Repository returns an instance of repository for the given name. All repositories share the blobs of the layout.
+func (r *Registry) Repository(_ context.Context, name reference.Named) (distribution.Repository, error) {
+	// Shouldn't return an error as name is a valid reference.
+	canonicalName, _ := reference.ParseNormalizedNamed(name.String())
+	return &repository{registry: r, name: name, canonicalName: canonicalName}, nil
+}
+
+// Repositories is not supported as images in an OCI image layout aren't necessarily named after repositories.
+func (r *Registry) Repositories(_ context.Context, _ []string, _ string) (int, error) {
+	return 0, distribution.ErrUnsupported
+}
+
+// Blobs returns a stub implementation of distribution.BlobEnumerator that doesn't support enumeration.
+func (r *Registry) Blobs() distribution.BlobEnumerator {
+	return unsupportedBlobEnumerator{}
+}
+
+// BlobStatter returns a blob statter of the layout blobs.
+func (r *Registry) BlobStatter() distribution.BlobStatter {
+	return &blobStore{registry: r}
+}
+
+// index reads the index of the layout.
+func (r *Registry) index() (ocispec.Index, error) {
+	var index ocispec.Index
+	data, err := os.ReadFile(filepath.Join(r.dir, ocispec.ImageIndexFile))
+	if err != nil {
+		return index, fmt.Errorf("read index of OCI image layout '%s': %w", r.dir, err)
+	}
+	if err = json.Unmarshal(data, &index); err != nil {
+		return index, fmt.Errorf("decode index of OCI image layout '%s': %w", r.dir, err)
+	}
+	return index, nil
+}
+
+// blobPath returns the path to the blob file in the layout.
+func (r *Registry) blobPath(dgst digest.Digest) (string, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", distribution.ErrBlobInvalidDigest{Digest: dgst, Reason: err}
+	}
+	return filepath.Join(r.dir, ocispec.ImageBlobsDir, dgst.Algorithm().String(), dgst.Encoded()), nil
+}
+
+// open opens the blob file in the layout. It returns distribution.ErrBlobUnknown if the blob doesn't exist.
+func (r *Registry) open(dgst digest.Digest) (*os.File, error) {
+	path, err := r.blobPath(dgst)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, distribution.ErrBlobUnknown
+		}
+		return nil, fmt.Errorf("open blob '%s': %w", dgst, err)
+	}
+	return f, nil
+}
+
+// unsupportedBlobEnumerator implements distribution.BlobEnumerator but doesn't support enumeration.
+type unsupportedBlobEnumerator struct{}
+
+// Enumerate is not supported for an OCI image layout.
+func (unsupportedBlobEnumerator) Enumerate(_ context.Context, _ func(digest.Digest) error) error {
+	return distribution.ErrUnsupported
+}
+
+// repository implements a read-only distribution.Repository backed by the OCI image layout.
+type repository struct {
+	registry *Registry
+	name     reference.Named
+	// canonicalName is the repository reference in a normalized form, for example, "docker.io/library/ubuntu".
+	canonicalName reference.Named
+}
+
+// Named returns the name of the repository.
+func (r *repository) Named() reference.Named {
+	return r.name
+}
+
+// Manifests returns the read-only manifest service for the repository.
+func (r *repository) Manifests(
+	_ context.Context, _ ...distribution.ManifestServiceOption,
+) (distribution.ManifestService, error) {
+	return &manifestService{registry: r.registry, repo: r.name}, nil
+}
+
+// Blobs returns the read-only blob store for the repository.
+func (r *repository) Blobs(_ context.Context) distribution.BlobStore {
+	return &blobStore{registry: r.registry}
+}
+
+// Tags returns the read-only tag service for the repository.
+func (r *repository) Tags(_ context.Context) distribution.TagService {
+	return &tagService{registry: r.registry, repo: r.name, canonicalRepo: r.canonicalName}
+}
+
+// blobStore implements a read-only distribution.BlobStore backed by the blobs of the OCI image layout.
+type blobStore struct {
+	registry *Registry
+}
+
+// Stat returns metadata about a blob in the layout by its digest.
+func (b *blobStore) Stat(_ context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	f, err := b.registry.open(dgst)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return distribution.Descriptor{}, fmt.Errorf("stat blob '%s': %w", dgst, err)
+	}
+	return distribution.Descriptor{
+		MediaType: "application/octet-stream",
+		Digest:    dgst,
+		Size:      fi.Size(),
+	}, nil
+}
+
+// Get retrieves the content of a blob in the layout by its digest.
+func (b *blobStore) Get(_ context.Context, dgst digest.Digest) ([]byte, error) {
+	f, err := b.registry.open(dgst)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Open opens the blob in the layout for reading.
+func (b *blobStore) Open(_ context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	return b.registry.open(dgst)
+}
+
+// ServeBlob serves the blob from the layout over HTTP.
+func (b *blobStore) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
+	desc, err := b.Stat(ctx, dgst)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", desc.MediaType)
+	w.Header().Set("Content-Length", strconv.FormatInt(desc.Size, 10))
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.Header().Set("Etag", dgst.String())
+	if r.Method == http.MethodHead {
+		return nil
+	}
+
+	f, err := b.registry.open(dgst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.CopyN(w, f, desc.Size)
+	return err
+}
+
+// Put is not supported as the layout is read-only.
+func (b *blobStore) Put(_ context.Context, _ string, _ []byte) (distribution.Descriptor, error) {
+	return distribution.Descriptor{}, distribution.ErrUnsupported
+}
+
+// Create is not supported as the layout is read-only.
+func (b *blobStore) Create(_ context.Context, _ ...distribution.BlobCreateOption) (distribution.BlobWriter, error) {
+	return nil, distribution.ErrUnsupported
+}
+
+// Resume is not supported as the layout is read-only.
+func (b *blobStore) Resume(_ context.Context, _ string) (distribution.BlobWriter, error) {
+	return nil, distribution.ErrUnsupported
+}
+
+// Mount is not supported as the layout is read-only.
+func (b *blobStore) Mount(_ context.Context, _ reference.Named, _ digest.Digest) (distribution.Descriptor, error) {
+	return distribution.Descriptor{}, distribution.ErrUnsupported
+}
+
+// Delete is not supported as the layout is read-only.
+func (b *blobStore) Delete(_ context.Context, _ digest.Digest) error {
+	return distribution.ErrUnsupported
+}
+
+// manifestService implements a read-only distribution.ManifestService backed by the blobs of the OCI image layout.
+type manifestService struct {
+	registry *Registry
+	repo     reference.Named
+}
+
+// Exists checks if a manifest exists in the layout by digest.
+func (m *manifestService) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	_, err := (&blobStore{registry: m.registry}).Stat(ctx, dgst)
+	if errors.Is(err, distribution.ErrBlobUnknown) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Get retrieves a manifest from the layout by its digest.
+func (m *manifestService) Get(
+	ctx context.Context, dgst digest.Digest, _ ...distribution.ManifestServiceOption,
+) (distribution.Manifest, error) {
+	blob, err := (&blobStore{registry: m.registry}).Get(ctx, dgst)
+	if err != nil {
+		if errors.Is(err, distribution.ErrBlobUnknown) {
+			return nil, distribution.ErrManifestUnknownRevision{Name: m.repo.Name(), Revision: dgst}
+		}
+		return nil, err
+	}
+
+	var ociManifest ocischema.DeserializedManifest
+	if err = ociManifest.UnmarshalJSON(blob); err == nil {
+		return &ociManifest, nil
+	}
+	var schema2Manifest schema2.DeserializedManifest
+	if err = schema2Manifest.UnmarshalJSON(blob); err == nil {
+		return &schema2Manifest, nil
+	}
+	var manifestList manifestlist.DeserializedManifestList
+	if err = manifestList.UnmarshalJSON(blob); err == nil {
+		return &manifestList, nil
+	}
+	return nil, fmt.Errorf("unmarshal manifest: %w",
+		distribution.ErrManifestVerification{errors.New("unknown manifest format")})
+}
+
+// Put is not supported as the layout is read-only.
+func (m *manifestService) Put(
+	_ context.Context, _ distribution.Manifest, _ ...distribution.ManifestServiceOption,
+) (digest.Digest, error) {
+	return "", distribution.ErrUnsupported
+}
+
+// Delete is not supported as the layout is read-only.
+func (m *manifestService) Delete(_ context.Context, _ digest.Digest) error {
+	return distribution.ErrUnsupported
+}
+
+// tagService implements a read-only distribution.TagService backed by the index of the OCI image layout.
+type tagService struct {
+	registry      *Registry
+	repo          reference.Named
+	canonicalRepo reference.Named
+}
+
+// Get returns the descriptor of the index entry tagged with the tag in the repository.
+func (t *tagService) Get(_ context.Context, tag string) (distribution.Descriptor, error) {
+	index, err := t.registry.index()
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	for _, desc := range index.Manifests {
+		if entryTag, ok := t.entryTag(desc); ok && entryTag == tag {
+			desc.Annotations = nil
+			return desc, nil
+		}
+	}
+	return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
+}
+
+// All returns the sorted tags of the index entries in the repository.
+func (t *tagService) All(_ context.Context) ([]string, error) {
+	index, err := t.registry.index()
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, desc := range index.Manifests {
+		if tag, ok := t.entryTag(desc); ok {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		return nil, distribution.ErrRepositoryUnknown{Name: t.repo.Name()}
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags), nil
+}
+
+// Lookup returns the sorted tags of the index entries in the repository that point to the descriptor.
+func (t *tagService) Lookup(_ context.Context, desc distribution.Descriptor) ([]string, error) {
+	index, err := t.registry.index()
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, entry := range index.Manifests {
+		if tag, ok := t.entryTag(entry); ok && entry.Digest == desc.Digest {
+			tags = append(tags, tag)
+		}
+	}
+	slices.Sort(tags)
+	return tags, nil
+}
+
+// Tag is not supported as the layout is read-only.
+func (t *tagService) Tag(_ context.Context, _ string, _ distribution.Descriptor) error {
+	return distribution.ErrUnsupported
+}
+
+// Untag is not supported as the layout is read-only.
+func (t *tagService) Untag(_ context.Context, _ string) error {
+	return distribution.ErrUnsupported
+}
+
+// entryTag returns the tag of the index entry if it belongs to the repository. An entry belongs to the repository if
+// its "io.containerd.image.name" or "org.opencontainers.image.ref.name" annotation is a reference in the repository.
+// An entry whose ref name annotation is a bare tag, as set by skopeo and oras, belongs to every repository.
+func (t *tagService) entryTag(desc ocispec.Descriptor) (string, bool) {
+	for _, name := range []string{desc.Annotations[containerdImageNameAnnotation],
+		desc.Annotations[ocispec.AnnotationRefName]} {
+		if name == "" {
+			continue
+		}
+		named, err := reference.ParseNormalizedNamed(name)
+		if err != nil {
+			continue
+		}
+		if tagged, ok := named.(reference.Tagged); ok && named.Name() == t.canonicalRepo.Name() {
+			return tagged.Tag(), true
+		}
+	}
+
+	refName := desc.Annotations[ocispec.AnnotationRefName]
+	if refName != "" && desc.Annotations[containerdImageNameAnnotation] == "" &&
+		!strings.ContainsAny(refName, ":/") && reference.TagRegexp.FindString(refName) == refName {
+		return refName, true
+	}
+	return "", false
+}
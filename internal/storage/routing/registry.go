@@ -0,0 +1,134 @@
+// Package routing implements a registry storage wrapper that routes repositories to different storages by their name,
+// e.g. the repositories under "k8s/" to the "k8s.io" containerd namespace and the rest to the "moby" namespace.
+package routing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+)
+
+// Route routes the repositories matching the pattern to the storage.
+type Route struct {
+	// Pattern is either a repository name, e.g. "myapp", or a repository name prefix followed by "/*", e.g. "k8s/*",
+	// that matches all repositories under the prefix.
+	Pattern   string
+	Namespace distribution.Namespace
+}
+
+// Match returns true if the repository name matches the route pattern.
+func (r Route) Match(name string) bool {
+	if prefix, ok := strings.CutSuffix(r.Pattern, "/*"); ok {
+		return strings.HasPrefix(name, prefix+"/")
+	}
+	return name == r.Pattern
+}
+
+// New wraps the default registry to serve the repositories matching the routes from the storages of the routes.
+// The first matching route wins and the repositories that don't match any route are served by the default registry.
+// It returns def as is if there are no routes.
+func New(def distribution.Namespace, routes []Route) distribution.Namespace {
+	if len(routes) == 0 {
+		return def
+	}
+	return &registry{Namespace: def, routes: routes}
+}
+
+// registry implements distribution.Namespace that routes repositories to the storages of the matching routes.
+// Blob statting and enumeration are served by the default registry.
+type registry struct {
+	distribution.Namespace
+	routes []Route
+}
+
+// storages returns the default registry followed by the storages of the routes in order.
+func (r *registry) storages() []distribution.Namespace {
+	storages := []distribution.Namespace{r.Namespace}
+	for _, route := range r.routes {
+		storages = append(storages, route.Namespace)
+	}
+	return storages
+}
+
+// route returns the index of the storage the repository is routed to in storages.
+func (r *registry) route(name string) int {
+	for i, route := range r.routes {
+		if route.Match(name) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Repository returns the repository from the storage it's routed to. The routes match the familiar name of
+// the repository, e.g. "k8s/foo" for "docker.io/k8s/foo", so that aliases of a repository are routed the same way.
+func (r *registry) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+	familiar := name.Name()
+	if normalized, err := reference.ParseNormalizedNamed(name.Name()); err == nil {
+		familiar = reference.FamiliarName(normalized)
+	}
+	return r.storages()[r.route(familiar)].Repository(ctx, name)
+}
+
+// Repositories fills repos with the sorted names of repositories that come after last in the lexical order. Each
+// storage contributes only the repositories routed to it. It returns the number of filled names and io.EOF if there
+// are no more repositories after them.
+func (r *registry) Repositories(ctx context.Context, repos []string, last string) (int, error) {
+	var names []string
+	for i, ns := range r.storages() {
+		all, err := listRepositories(ctx, ns)
+		if err != nil {
+			return 0, err
+		}
+		for _, name := range all {
+			if r.route(name) == i {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+
+	n := 0
+	for _, name := range names {
+		if name <= last {
+			continue
+		}
+		if n == len(repos) {
+			return n, nil
+		}
+		repos[n] = name
+		n++
+	}
+	return n, io.EOF
+}
+
+// listRepositoriesPageSize is the number of repository names requested from a registry at a time.
+const listRepositoriesPageSize = 100
+
+// listRepositories returns the names of all repositories in the registry.
+func listRepositories(ctx context.Context, ns distribution.Namespace) ([]string, error) {
+	var (
+		names []string
+		last  string
+	)
+	page := make([]string, listRepositoriesPageSize)
+	for {
+		n, err := ns.Repositories(ctx, page, last)
+		names = append(names, page[:n]...)
+		if errors.Is(err, io.EOF) {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return names, nil
+		}
+		last = page[n-1]
+	}
+}
@@ -0,0 +1,58 @@
+package routing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+)
+
+// namedNamespace is a storage that only returns repositories marked with its name.
+type namedNamespace struct {
+	distribution.Namespace
+	name string
+}
+
+type namedRepository struct {
+	distribution.Repository
+	storage string
+}
+
+func (n *namedNamespace) Repository(_ context.Context, _ reference.Named) (distribution.Repository, error) {
+	return &namedRepository{storage: n.name}, nil
+}
+
+func TestRepositoryRoutesAliases(t *testing.T) {
+	reg := New(&namedNamespace{name: "default"}, []Route{
+		{Pattern: "k8s/*", Namespace: &namedNamespace{name: "k8s"}},
+		{Pattern: "ubuntu", Namespace: &namedNamespace{name: "ubuntu"}},
+	})
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "k8s/foo", want: "k8s"},
+		{name: "docker.io/k8s/foo", want: "k8s"},
+		{name: "ubuntu", want: "ubuntu"},
+		{name: "library/ubuntu", want: "ubuntu"},
+		{name: "docker.io/library/ubuntu", want: "ubuntu"},
+		{name: "ghcr.io/k8s/foo", want: "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			named, err := reference.WithName(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo, err := reg.Repository(context.Background(), named)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := repo.(*namedRepository).storage; got != tt.want {
+				t.Fatalf("expected repository to be routed to '%s', got '%s'", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Package tracing exports OpenTelemetry traces of registry requests and the containerd storage operations they make
+// to an OTLP collector so that a slow or failing push can be followed from the HTTP request down to the content store.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// serviceName is the name of the service the spans are reported for.
+const serviceName = "unregistry"
+
+// instrumentationName is the name of the tracer creating the spans of unregistry.
+const instrumentationName = "github.com/psviderski/unregistry"
+
+// Init sets up the global OpenTelemetry tracer provider exporting spans to the OTLP collector at the endpoint URL.
+// The scheme selects the protocol: "grpc" for OTLP over gRPC, e.g. "grpc://collector:4317", and "http" or "https"
+// for OTLP over HTTP, e.g. "http://collector:4318". The given ratio of traces is sampled unless the parent span of
+// the incoming request is sampled. The returned function flushes the pending spans and shuts down the exporter.
+func Init(ctx context.Context, endpoint string, sampleRatio float64) (shutdown func(context.Context) error, err error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint '%s': expected URL like grpc://host:4317 or http://host:4318",
+			endpoint)
+	}
+
+	var exporter sdktrace.SpanExporter
+	switch u.Scheme {
+	case "grpc":
+		exporter, err = otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(u.Host), otlptracegrpc.WithInsecure())
+	case "http", "https":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+		if u.Path != "" && u.Path != "/" {
+			opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+		}
+		if u.Scheme == "http" {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("invalid OTLP endpoint '%s': unsupported scheme '%s'; expected grpc, http, or https",
+			endpoint, u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP trace exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{},
+		propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span with the name and attributes as a child of the span in the context if any. It's a no-op
+// unless Init has been called.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records the error in the span if not nil and ends it. It's meant to be deferred with a pointer to the named
+// error result of the traced function.
+func End(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
@@ -0,0 +1,138 @@
+package transfer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Blob describes a blob referenced by a pushed manifest.
+type Blob struct {
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType,omitempty"`
+	Size      int64         `json:"size"`
+	// Exists indicates that the blob is already present in the content store and doesn't need to be transferred.
+	Exists bool `json:"exists"`
+}
+
+// Manifest describes a pushed image manifest or index and the blobs it references. Manifests referenced by an index
+// are described separately as they're pushed separately.
+type Manifest struct {
+	Repo string `json:"repo"`
+	// Reference is the tag or digest the manifest is pushed by.
+	Reference string        `json:"reference"`
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType"`
+	Size      int64         `json:"size"`
+	// Exists indicates that the manifest is already present in the content store.
+	Exists bool   `json:"exists"`
+	Blobs  []Blob `json:"blobs,omitempty"`
+}
+
+// DryRun collects the manifests pushed to the registry running in the dry-run mode which doesn't store anything.
+// It's safe for concurrent use.
+type DryRun struct {
+	mu        sync.Mutex
+	manifests []Manifest
+}
+
+// NewDryRun creates an empty dry-run collector.
+func NewDryRun() *DryRun {
+	return &DryRun{}
+}
+
+// Add records a pushed manifest.
+func (d *DryRun) Add(m Manifest) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.manifests = append(d.manifests, m)
+}
+
+// Plan returns what would be transferred by the pushes recorded so far.
+func (d *DryRun) Plan() Plan {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	plan := Plan{Manifests: make([]Manifest, len(d.manifests))}
+	copy(plan.Manifests, d.manifests)
+
+	// The same blob can be referenced by multiple manifests, e.g. a base layer shared by platforms.
+	seen := make(map[digest.Digest]struct{})
+	count := func(dgst digest.Digest, size int64, exists bool) {
+		if _, ok := seen[dgst]; ok {
+			return
+		}
+		seen[dgst] = struct{}{}
+		if exists {
+			plan.ExistingBlobs++
+			plan.ExistingBytes += size
+		} else {
+			plan.TransferBlobs++
+			plan.TransferBytes += size
+		}
+	}
+	for _, m := range plan.Manifests {
+		count(m.Digest, m.Size, m.Exists)
+		for _, b := range m.Blobs {
+			count(b.Digest, b.Size, b.Exists)
+		}
+	}
+
+	return plan
+}
+
+// Plan describes what would be transferred by a push.
+type Plan struct {
+	Manifests []Manifest `json:"manifests"`
+	// TransferBlobs is the number of unique blobs (including manifests) missing in the content store.
+	TransferBlobs int `json:"transferBlobs"`
+	// TransferBytes is the total size of the blobs missing in the content store.
+	TransferBytes int64 `json:"transferBytes"`
+	// ExistingBlobs is the number of unique blobs (including manifests) already present in the content store.
+	ExistingBlobs int `json:"existingBlobs"`
+	// ExistingBytes is the total size of the blobs already present in the content store.
+	ExistingBytes int64 `json:"existingBytes"`
+}
+
+// String returns a human-readable description of the plan.
+func (p Plan) String() string {
+	var sb strings.Builder
+	for _, m := range p.Manifests {
+		sep := ":"
+		if _, err := digest.Parse(m.Reference); err == nil {
+			sep = "@"
+		}
+		fmt.Fprintf(&sb, "%s%s%s (%s)\n", m.Repo, sep, m.Reference, m.MediaType)
+		fmt.Fprintf(&sb, "  %s  %10s  %s\n", m.Digest, HumanSize(m.Size), action(m.Exists))
+		for _, b := range m.Blobs {
+			fmt.Fprintf(&sb, "  %s  %10s  %s\n", b.Digest, HumanSize(b.Size), action(b.Exists))
+		}
+	}
+	fmt.Fprintf(&sb, "Would transfer %d blob(s) (%s), %d blob(s) (%s) already exist.\n",
+		p.TransferBlobs, HumanSize(p.TransferBytes), p.ExistingBlobs, HumanSize(p.ExistingBytes))
+
+	return sb.String()
+}
+
+func action(exists bool) string {
+	if exists {
+		return "exists"
+	}
+	return "transfer"
+}
+
+// HumanSize returns a human-readable representation of the size in bytes using binary units, e.g. "1.5 MiB".
+func HumanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,273 @@
+package transfer
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/metadata"
+)
+
+// uploadRetention is how long committed uploads are remembered to be included in a report of the image referencing
+// them. It matches the lifetime of the upload leases.
+const uploadRetention = 1 * time.Hour
+
+// ReportBlob describes a blob of a pushed image in a transfer report.
+type ReportBlob struct {
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType,omitempty"`
+	Size      int64         `json:"size"`
+	// Verified indicates that the blob is present in the content store with the expected size. The blob digest is
+	// verified by containerd when the blob is committed.
+	Verified bool `json:"verified"`
+	// Transferred indicates that the blob was uploaded recently rather than already present in the content store.
+	Transferred bool `json:"transferred"`
+}
+
+// Report describes the content of a pushed image and how it was transferred. It can be signed to provide an audit
+// trail of what exactly was deployed to the host.
+type Report struct {
+	Image     string        `json:"image"`
+	Digest    digest.Digest `json:"digest"`
+	MediaType string        `json:"mediaType"`
+	Blobs     []ReportBlob  `json:"blobs"`
+	// TotalBytes is the total size of all image blobs.
+	TotalBytes int64 `json:"totalBytes"`
+	// TransferredBytes is the total size of the blobs uploaded for the push.
+	TransferredBytes int64 `json:"transferredBytes"`
+	// StartedAt is when the first transferred blob started uploading. It equals CompletedAt if nothing
+	// was transferred.
+	StartedAt   time.Time  `json:"startedAt"`
+	CompletedAt time.Time  `json:"completedAt"`
+	Signature   *Signature `json:"signature,omitempty"`
+}
+
+// Signature is an ed25519 signature of the JSON-encoded report without the signature.
+type Signature struct {
+	Algorithm string `json:"algorithm"`
+	// PublicKey is the base64-encoded public key to verify the signature with.
+	PublicKey string `json:"publicKey"`
+	// Value is the base64-encoded signature.
+	Value string `json:"value"`
+}
+
+// Verified reports whether all image blobs are verified.
+func (r Report) Verified() bool {
+	for _, b := range r.Blobs {
+		if !b.Verified {
+			return false
+		}
+	}
+	return true
+}
+
+// Duration returns how long the transfer took.
+func (r Report) Duration() time.Duration {
+	return r.CompletedAt.Sub(r.StartedAt)
+}
+
+// VerifySignature verifies the report signature with the public key embedded in it.
+func (r Report) VerifySignature() error {
+	if r.Signature == nil {
+		return errors.New("report is not signed")
+	}
+	pub, err := base64.StdEncoding.DecodeString(r.Signature.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errors.New("invalid public key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(r.Signature.Value)
+	if err != nil {
+		return fmt.Errorf("decode signature: %w", err)
+	}
+
+	payload, err := r.payload()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, payload, sig) {
+		return errors.New("signature doesn't match the report")
+	}
+	return nil
+}
+
+// payload returns the signed content of the report.
+func (r Report) payload() ([]byte, error) {
+	r.Signature = nil
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("marshal report: %w", err)
+	}
+	return payload, nil
+}
+
+// String returns a human-readable description of the report.
+func (r Report) String() string {
+	var sb strings.Builder
+	transferred := 0
+	for _, b := range r.Blobs {
+		if b.Transferred {
+			transferred++
+		}
+	}
+	verified := "all blobs verified"
+	if !r.Verified() {
+		verified = "SOME BLOBS ARE MISSING"
+	}
+
+	fmt.Fprintf(&sb, "Transfer report for %s\n", r.Image)
+	fmt.Fprintf(&sb, "  Digest:      %s\n", r.Digest)
+	fmt.Fprintf(&sb, "  Transferred: %d of %d blob(s), %s of %s in %s\n", transferred, len(r.Blobs),
+		HumanSize(r.TransferredBytes), HumanSize(r.TotalBytes), r.Duration().Round(time.Millisecond))
+	fmt.Fprintf(&sb, "  Integrity:   %s\n", verified)
+	for _, b := range r.Blobs {
+		status := "missing"
+		if b.Verified {
+			status = "verified"
+		}
+		if b.Transferred {
+			status += ", transferred"
+		}
+		fmt.Fprintf(&sb, "    %s  %10s  %s\n", b.Digest, HumanSize(b.Size), status)
+	}
+	if r.Signature != nil {
+		fmt.Fprintf(&sb, "  Signature:   %s %s (public key %s)\n",
+			r.Signature.Algorithm, r.Signature.Value, r.Signature.PublicKey)
+	}
+
+	return sb.String()
+}
+
+// upload is a committed blob upload.
+type upload struct {
+	size        int64
+	startedAt   time.Time
+	committedAt time.Time
+}
+
+// Reporter creates transfer reports for pushed images and stores them in the metadata store. It remembers recently
+// committed uploads to tell which image blobs were transferred. It's safe for concurrent use and a nil Reporter
+// doesn't record or report anything.
+type Reporter struct {
+	store metadata.Store
+	// key signs the reports if set.
+	key ed25519.PrivateKey
+
+	mu      sync.Mutex
+	uploads map[digest.Digest]upload
+}
+
+// NewReporter creates a reporter that stores the reports in the store and signs them with the key if not nil.
+func NewReporter(store metadata.Store, key ed25519.PrivateKey) *Reporter {
+	return &Reporter{
+		store:   store,
+		key:     key,
+		uploads: make(map[digest.Digest]upload),
+	}
+}
+
+// BlobCommitted records a blob upload that has been committed to the content store.
+func (r *Reporter) BlobCommitted(dgst digest.Digest, size int64, startedAt time.Time) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now().UTC()
+	for d, u := range r.uploads {
+		if now.Sub(u.committedAt) > uploadRetention {
+			delete(r.uploads, d)
+		}
+	}
+	r.uploads[dgst] = upload{size: size, startedAt: startedAt.UTC(), committedAt: now}
+}
+
+// Report creates, signs, and stores a transfer report for the image with the given reference and content blobs.
+// The transferred blobs are determined from the recently committed uploads.
+func (r *Reporter) Report(image string, target ReportBlob, blobs []ReportBlob) (Report, error) {
+	if r == nil {
+		return Report{}, nil
+	}
+
+	now := time.Now().UTC()
+	report := Report{
+		Image:       image,
+		Digest:      target.Digest,
+		MediaType:   target.MediaType,
+		Blobs:       blobs,
+		StartedAt:   now,
+		CompletedAt: now,
+	}
+
+	r.mu.Lock()
+	for i, b := range report.Blobs {
+		report.TotalBytes += b.Size
+		u, ok := r.uploads[b.Digest]
+		if !ok {
+			continue
+		}
+		report.Blobs[i].Transferred = true
+		report.TransferredBytes += b.Size
+		if u.startedAt.Before(report.StartedAt) {
+			report.StartedAt = u.startedAt
+		}
+	}
+	r.mu.Unlock()
+
+	if r.key != nil {
+		payload, err := report.payload()
+		if err != nil {
+			return Report{}, err
+		}
+		report.Signature = &Signature{
+			Algorithm: "ed25519",
+			PublicKey: base64.StdEncoding.EncodeToString(r.key.Public().(ed25519.PublicKey)),
+			Value:     base64.StdEncoding.EncodeToString(ed25519.Sign(r.key, payload)),
+		}
+	}
+
+	if err := r.store.Put(metadata.BucketTransferReports, image, report); err != nil {
+		return Report{}, fmt.Errorf("store transfer report: %w", err)
+	}
+	return report, nil
+}
+
+// GetReport returns the latest transfer report for the image with the given reference.
+func GetReport(store metadata.Store, image string) (Report, error) {
+	var report Report
+	if err := store.Get(metadata.BucketTransferReports, image, &report); err != nil {
+		return Report{}, err
+	}
+	return report, nil
+}
+
+// LoadSigningKey loads an ed25519 private key in the PEM-encoded PKCS #8 format from the file, for example,
+// generated with "openssl genpkey -algorithm ed25519".
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in signing key file '%s'", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key must be an ed25519 key, got %T", key)
+	}
+	return edKey, nil
+}
@@ -0,0 +1,119 @@
+package transfer
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// VerificationStats are the statistics of blob digest verifications of one kind.
+type VerificationStats struct {
+	Count    int64 `json:"count"`
+	Failures int64 `json:"failures"`
+	Bytes    int64 `json:"bytes"`
+	// Duration is the total time spent on verifications. It's encoded in nanoseconds in JSON.
+	Duration time.Duration `json:"duration"`
+}
+
+// observe records a verification.
+func (s *VerificationStats) observe(size int64, d time.Duration, failed bool) {
+	s.Count++
+	s.Bytes += size
+	s.Duration += d
+	if failed {
+		s.Failures++
+	}
+}
+
+// Verifier verifies blob digests according to the configured policy and collects the verification statistics.
+// Verification on write hashes the content while it's being uploaded to reject a mismatching upload before it's
+// committed. Containerd verifies the digest on commit regardless because its content store hashes the content while
+// it's being written, so disabling it only saves hashing the content twice. Verification on read rehashes the blob
+// content every time it's served to detect content corrupted on disk at the cost of reading it twice. It's safe for
+// concurrent use and a nil Verifier verifies writes but doesn't verify reads or collect statistics.
+type Verifier struct {
+	onRead  bool
+	onWrite bool
+
+	mu    sync.Mutex
+	write VerificationStats
+	read  VerificationStats
+}
+
+// NewVerifier creates a verifier that verifies digests on read if onRead is true and on write if onWrite is true.
+func NewVerifier(onRead, onWrite bool) *Verifier {
+	return &Verifier{onRead: onRead, onWrite: onWrite}
+}
+
+// OnRead reports whether digests are verified on read.
+func (v *Verifier) OnRead() bool {
+	return v != nil && v.onRead
+}
+
+// OnWrite reports whether uploaded content is hashed to verify digests before it's committed.
+func (v *Verifier) OnWrite() bool {
+	return v == nil || v.onWrite
+}
+
+// ObserveWrite records a digest verification on commit that took the given duration.
+func (v *Verifier) ObserveWrite(size int64, d time.Duration, failed bool) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.write.observe(size, d, failed)
+}
+
+// VerifyRead reads the content from r and checks that it matches the digest if verification on read is enabled.
+func (v *Verifier) VerifyRead(r io.Reader, dgst digest.Digest) error {
+	if !v.OnRead() {
+		return nil
+	}
+	if err := dgst.Validate(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	verifier := dgst.Verifier()
+	n, err := io.Copy(verifier, r)
+	if err != nil {
+		return fmt.Errorf("read blob '%s' to verify digest: %w", dgst, err)
+	}
+	ok := verifier.Verified()
+
+	v.mu.Lock()
+	v.read.observe(n, time.Since(start), !ok)
+	v.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("content of blob '%s' doesn't match its digest", dgst)
+	}
+	return nil
+}
+
+// VerifierStats is a snapshot of the verification statistics.
+type VerifierStats struct {
+	OnRead  bool              `json:"onRead"`
+	OnWrite bool              `json:"onWrite"`
+	Write   VerificationStats `json:"write"`
+	Read    VerificationStats `json:"read"`
+}
+
+// Stats returns the verification statistics collected so far.
+func (v *Verifier) Stats() VerifierStats {
+	if v == nil {
+		return VerifierStats{OnWrite: true}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return VerifierStats{
+		OnRead:  v.onRead,
+		OnWrite: v.onWrite,
+		Write:   v.write,
+		Read:    v.read,
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !darwin
+
+package tui
+
+import (
+	"errors"
+	"os"
+)
+
+// makeRaw is only supported on Linux and macOS.
+func makeRaw(int) (func(), error) {
+	return nil, errors.New("interactive terminal UI is only supported on Linux and macOS")
+}
+
+func terminalSize(int) (int, int, error) {
+	return 0, 0, errors.New("terminal size is only supported on Linux and macOS")
+}
+
+func notifyResize(chan<- os.Signal) {}
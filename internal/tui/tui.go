@@ -0,0 +1,611 @@
+// Package tui implements an interactive terminal UI for browsing and managing the images on a host running unregistry
+// through its admin API.
+package tui
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/psviderski/unregistry/internal/transfer"
+)
+
+// Client is the admin API of an unregistry instance the UI manages images through.
+type Client interface {
+	// Images returns the summaries of all images on the host.
+	Images(ctx context.Context) ([]containerd.ImageSummary, error)
+	// RemoveImage removes the image with the reference from the host.
+	RemoveImage(ctx context.Context, image string) error
+	// TagImage tags the source image with the target reference.
+	TagImage(ctx context.Context, source, target string) error
+}
+
+const (
+	keyUp        = "up"
+	keyDown      = "down"
+	keyLeft      = "left"
+	keyRight     = "right"
+	keyHome      = "home"
+	keyEnd       = "end"
+	keyPageUp    = "pgup"
+	keyPageDown  = "pgdown"
+	keyEnter     = "enter"
+	keyEsc       = "esc"
+	keyBackspace = "backspace"
+	keyInterrupt = "ctrl+c"
+)
+
+// escapeSequences maps the escape sequences sent by terminals for special keys to key names.
+var escapeSequences = map[string]string{
+	"\x1b[A":  keyUp,
+	"\x1bOA":  keyUp,
+	"\x1b[B":  keyDown,
+	"\x1bOB":  keyDown,
+	"\x1b[C":  keyRight,
+	"\x1bOC":  keyRight,
+	"\x1b[D":  keyLeft,
+	"\x1bOD":  keyLeft,
+	"\x1b[H":  keyHome,
+	"\x1bOH":  keyHome,
+	"\x1b[1~": keyHome,
+	"\x1b[F":  keyEnd,
+	"\x1bOF":  keyEnd,
+	"\x1b[4~": keyEnd,
+	"\x1b[5~": keyPageUp,
+	"\x1b[6~": keyPageDown,
+}
+
+// key is a key press. It's either a special key with a name or a printable character.
+type key struct {
+	name string
+	r    rune
+}
+
+// mode is the interaction mode of the UI.
+type mode int
+
+const (
+	modeBrowse mode = iota
+	// modeConfirmDelete waits for the user to confirm the deletion of the selected item.
+	modeConfirmDelete
+	// modeInputTag reads the new tag for the selected image.
+	modeInputTag
+)
+
+// repoSummary is a repository with its images.
+type repoSummary struct {
+	name   string
+	images []containerd.ImageSummary
+	// size is the total size of the unique images in the repository. Layers shared between images are counted
+	// multiple times.
+	size int64
+	tags int
+}
+
+// model is the state of the UI.
+type model struct {
+	client Client
+	out    io.Writer
+
+	repos []repoSummary
+	// openRepo is the name of the repository which images are shown. The list of repositories is shown if empty.
+	openRepo string
+	cursor   int
+	offset   int
+	// repoCursor is the cursor position in the list of repositories to restore when going back from a repository.
+	repoCursor int
+
+	mode   mode
+	input  []rune
+	status string
+	width  int
+	height int
+}
+
+// Run runs the UI on the terminal until the user quits or the context is cancelled.
+func Run(ctx context.Context, client Client, in *os.File, out io.Writer) error {
+	fd := int(in.Fd())
+	restore, err := makeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	// Use the alternate screen buffer to restore the terminal contents on exit and hide the cursor while browsing.
+	_, _ = fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+	m := &model{client: client, out: out}
+	m.resize(fd)
+	m.status = "Loading images..."
+	m.render()
+	m.status = ""
+	m.refresh(ctx)
+
+	keys := make(chan key)
+	go readKeys(in, keys)
+	resize := make(chan os.Signal, 1)
+	notifyResize(resize)
+	defer signal.Stop(resize)
+
+	for {
+		m.render()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-resize:
+			m.resize(fd)
+		case k, ok := <-keys:
+			if !ok || m.handle(ctx, k) {
+				return nil
+			}
+		}
+	}
+}
+
+// readKeys reads key presses from the terminal and sends them to the channel until reading fails.
+func readKeys(r io.Reader, keys chan<- key) {
+	defer close(keys)
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, k := range parseKeys(buf[:n]) {
+			keys <- k
+		}
+	}
+}
+
+// parseKeys parses the key presses from the bytes read from the terminal in raw mode.
+func parseKeys(b []byte) []key {
+	var keys []key
+	for len(b) > 0 {
+		switch {
+		case b[0] == 0x1b:
+			name, n := parseEscapeSequence(b)
+			if name != "" {
+				keys = append(keys, key{name: name})
+			}
+			b = b[n:]
+			continue
+		case b[0] == '\r' || b[0] == '\n':
+			keys = append(keys, key{name: keyEnter})
+		case b[0] == 0x7f || b[0] == 0x08:
+			keys = append(keys, key{name: keyBackspace})
+		case b[0] == 0x03:
+			keys = append(keys, key{name: keyInterrupt})
+		case b[0] < 0x20:
+			// Ignore other control characters.
+		default:
+			r, n := utf8.DecodeRune(b)
+			keys = append(keys, key{r: r})
+			b = b[n:]
+			continue
+		}
+		b = b[1:]
+	}
+	return keys
+}
+
+// parseEscapeSequence parses the escape sequence at the beginning of b and returns the key name and the length of
+// the sequence. A lone escape character is the Esc key. The name is empty for unknown sequences.
+func parseEscapeSequence(b []byte) (string, int) {
+	for seq, name := range escapeSequences {
+		if strings.HasPrefix(string(b), seq) {
+			return name, len(seq)
+		}
+	}
+	if len(b) == 1 || (b[1] != '[' && b[1] != 'O') {
+		return keyEsc, 1
+	}
+	// Skip an unknown control sequence up to its final byte.
+	for i := 2; i < len(b); i++ {
+		if b[i] >= 0x40 && b[i] <= 0x7e {
+			return "", i + 1
+		}
+	}
+	return "", len(b)
+}
+
+// resize updates the terminal size falling back to the classic 80x24 if it can't be determined.
+func (m *model) resize(fd int) {
+	w, h, err := terminalSize(fd)
+	if err != nil || w <= 0 || h <= 0 {
+		w, h = 80, 24
+	}
+	m.width, m.height = w, h
+}
+
+// refresh reloads the images from the host keeping the selection where possible. An error is shown in the status
+// line.
+func (m *model) refresh(ctx context.Context) {
+	imgs, err := m.client.Images(ctx)
+	if err != nil {
+		m.status = fmt.Sprintf("Error: %v", err)
+		return
+	}
+
+	var repos []repoSummary
+	for _, img := range imgs {
+		if len(repos) == 0 || repos[len(repos)-1].name != img.Repo {
+			repos = append(repos, repoSummary{name: img.Repo})
+		}
+		repo := &repos[len(repos)-1]
+		repo.images = append(repo.images, img)
+		if img.Tag != "" {
+			repo.tags++
+		}
+	}
+	for i := range repos {
+		seen := make(map[digest.Digest]struct{})
+		for _, img := range repos[i].images {
+			for _, sized := range append([]containerd.ImageSummary{img}, img.Referrers...) {
+				if _, ok := seen[sized.Digest]; !ok {
+					seen[sized.Digest] = struct{}{}
+					repos[i].size += sized.Size
+				}
+			}
+		}
+	}
+	m.repos = repos
+
+	if m.openRepo != "" && m.currentRepo() == nil {
+		m.back()
+	}
+	m.cursor = min(m.cursor, max(m.rows()-1, 0))
+}
+
+// currentRepo returns the open repository or nil if the list of repositories is shown.
+func (m *model) currentRepo() *repoSummary {
+	if m.openRepo == "" {
+		return nil
+	}
+	for i := range m.repos {
+		if m.repos[i].name == m.openRepo {
+			return &m.repos[i]
+		}
+	}
+	return nil
+}
+
+// rows returns the number of rows in the current list.
+func (m *model) rows() int {
+	if repo := m.currentRepo(); repo != nil {
+		return len(repo.images)
+	}
+	return len(m.repos)
+}
+
+// selectedImage returns the image under the cursor if a repository is open.
+func (m *model) selectedImage() (containerd.ImageSummary, bool) {
+	repo := m.currentRepo()
+	if repo == nil || m.cursor >= len(repo.images) {
+		return containerd.ImageSummary{}, false
+	}
+	return repo.images[m.cursor], true
+}
+
+func (m *model) open() {
+	if m.openRepo != "" || m.cursor >= len(m.repos) {
+		return
+	}
+	m.openRepo = m.repos[m.cursor].name
+	m.repoCursor = m.cursor
+	m.cursor, m.offset = 0, 0
+}
+
+func (m *model) back() {
+	if m.openRepo == "" {
+		return
+	}
+	m.openRepo = ""
+	m.cursor, m.offset = min(m.repoCursor, max(len(m.repos)-1, 0)), 0
+}
+
+// visibleRows returns the number of list rows that fit on the screen below the title and the column headers and
+// above the status and help lines.
+func (m *model) visibleRows() int {
+	return max(m.height-4, 1)
+}
+
+// moveCursor moves the cursor by delta rows keeping it within the list.
+func (m *model) moveCursor(delta int) {
+	m.cursor = max(min(m.cursor+delta, m.rows()-1), 0)
+}
+
+// handle handles the key press and returns true if the user quits.
+func (m *model) handle(ctx context.Context, k key) bool {
+	switch m.mode {
+	case modeConfirmDelete:
+		m.mode = modeBrowse
+		if k.r == 'y' || k.r == 'Y' {
+			m.delete(ctx)
+		} else {
+			m.status = "Deletion cancelled."
+		}
+		return false
+	case modeInputTag:
+		m.handleInput(ctx, k)
+		return false
+	}
+
+	m.status = ""
+	switch {
+	case k.name == keyInterrupt || k.r == 'q':
+		return true
+	case k.name == keyUp || k.r == 'k':
+		m.moveCursor(-1)
+	case k.name == keyDown || k.r == 'j':
+		m.moveCursor(1)
+	case k.name == keyPageUp:
+		m.moveCursor(-m.visibleRows())
+	case k.name == keyPageDown:
+		m.moveCursor(m.visibleRows())
+	case k.name == keyHome || k.r == 'g':
+		m.cursor = 0
+	case k.name == keyEnd || k.r == 'G':
+		m.cursor = max(m.rows()-1, 0)
+	case k.name == keyEnter || k.name == keyRight || k.r == 'l':
+		m.open()
+	case k.name == keyEsc || k.name == keyLeft || k.name == keyBackspace || k.r == 'h':
+		m.back()
+	case k.r == 'r':
+		m.refresh(ctx)
+	case k.r == 'd':
+		if m.rows() > 0 {
+			m.mode = modeConfirmDelete
+		}
+	case k.r == 't':
+		if _, ok := m.selectedImage(); ok {
+			m.mode = modeInputTag
+			m.input = m.input[:0]
+		}
+	}
+	return false
+}
+
+// handleInput handles the key press while reading the new tag for the selected image.
+func (m *model) handleInput(ctx context.Context, k key) {
+	switch k.name {
+	case keyEnter:
+		m.mode = modeBrowse
+		if len(m.input) > 0 {
+			m.tag(ctx, string(m.input))
+		}
+	case keyEsc, keyInterrupt:
+		m.mode = modeBrowse
+		m.status = "Tagging cancelled."
+	case keyBackspace:
+		if len(m.input) > 0 {
+			m.input = m.input[:len(m.input)-1]
+		}
+	case "":
+		m.input = append(m.input, k.r)
+	}
+}
+
+// delete removes the selected image or all images of the selected repository.
+func (m *model) delete(ctx context.Context) {
+	var imgs []containerd.ImageSummary
+	var what string
+	if img, ok := m.selectedImage(); ok {
+		imgs, what = []containerd.ImageSummary{img}, img.Image
+	} else if m.openRepo == "" && m.cursor < len(m.repos) {
+		repo := m.repos[m.cursor]
+		imgs, what = repo.images, fmt.Sprintf("%s of %s", plural(len(repo.images), "image"), repo.name)
+	}
+	if len(imgs) == 0 {
+		return
+	}
+
+	m.status = fmt.Sprintf("Deleting %s...", what)
+	m.render()
+	for _, img := range imgs {
+		if err := m.client.RemoveImage(ctx, img.Image); err != nil {
+			m.status = fmt.Sprintf("Error: %v", err)
+			m.refresh(ctx)
+			return
+		}
+	}
+	m.status = fmt.Sprintf("Deleted %s.", what)
+	m.refresh(ctx)
+}
+
+// tag tags the selected image with the target. A target without a repository, e.g. "1.2", is a tag in the same
+// repository.
+func (m *model) tag(ctx context.Context, target string) {
+	img, ok := m.selectedImage()
+	if !ok {
+		return
+	}
+	target = strings.TrimSpace(target)
+	if !strings.ContainsAny(target, ":/@") {
+		target = img.Repo + ":" + target
+	}
+
+	if err := m.client.TagImage(ctx, img.Image, target); err != nil {
+		m.status = fmt.Sprintf("Error: %v", err)
+		return
+	}
+	m.status = fmt.Sprintf("Tagged %s as %s.", img.Image, target)
+	m.refresh(ctx)
+}
+
+// render draws the whole screen.
+func (m *model) render() {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+
+	title := fmt.Sprintf("Repositories (%d)", len(m.repos))
+	header, lines := m.repoLines()
+	if repo := m.currentRepo(); repo != nil {
+		title = fmt.Sprintf("Repository %s (%s, %s)", repo.name, plural(len(repo.images), "image"),
+			transfer.HumanSize(repo.size))
+		header, lines = m.imageLines(repo)
+	}
+	m.writeLine(&b, "\x1b[1m", " unregistry: "+title)
+	m.writeLine(&b, "\x1b[1;4m", header)
+
+	visible := m.visibleRows()
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	} else if m.cursor >= m.offset+visible {
+		m.offset = m.cursor - visible + 1
+	}
+	for i := 0; i < visible; i++ {
+		row := m.offset + i
+		switch {
+		case row >= len(lines):
+			b.WriteString("\r\n")
+		case row == m.cursor:
+			m.writeLine(&b, "\x1b[7m", lines[row])
+		default:
+			m.writeLine(&b, "", lines[row])
+		}
+	}
+
+	m.writeLine(&b, "", m.statusLine())
+	m.writeLine(&b, "\x1b[2m", m.helpLine())
+	_, _ = io.WriteString(m.out, strings.TrimSuffix(b.String(), "\r\n"))
+}
+
+// writeLine writes the line truncated and padded to the terminal width in the given style. The last column is left
+// empty so that the terminal doesn't wrap the line.
+func (m *model) writeLine(b *strings.Builder, style, line string) {
+	width := max(m.width-1, 1)
+	runes := []rune(line)
+	if len(runes) > width {
+		runes = runes[:width]
+	}
+	line = string(runes) + strings.Repeat(" ", width-len(runes))
+	if style != "" {
+		line = style + line + "\x1b[0m"
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// repoLines returns the column headers and the lines of the list of repositories.
+func (m *model) repoLines() (string, []string) {
+	rows := [][]string{{"REPOSITORY", "TAGS", "IMAGES", "SIZE"}}
+	for _, repo := range m.repos {
+		rows = append(rows, []string{
+			repo.name,
+			fmt.Sprint(repo.tags),
+			fmt.Sprint(len(repo.images)),
+			transfer.HumanSize(repo.size),
+		})
+	}
+	lines := formatTable(rows)
+	return lines[0], lines[1:]
+}
+
+// imageLines returns the column headers and the lines of the list of images in the repository.
+func (m *model) imageLines(repo *repoSummary) (string, []string) {
+	rows := [][]string{{"TAG", "DIGEST", "SIZE", "PLATFORMS", "REFERRERS", "CREATED"}}
+	for _, img := range repo.images {
+		tag := img.Tag
+		if tag == "" {
+			tag = "<none>"
+		}
+		referrers := ""
+		if len(img.Referrers) > 0 {
+			referrers = fmt.Sprint(len(img.Referrers))
+		}
+		rows = append(rows, []string{
+			tag,
+			shortDigest(img.Digest),
+			transfer.HumanSize(img.Size),
+			strings.Join(img.Platforms, ","),
+			referrers,
+			since(img.Created),
+		})
+	}
+	lines := formatTable(rows)
+	return lines[0], lines[1:]
+}
+
+func (m *model) statusLine() string {
+	switch m.mode {
+	case modeConfirmDelete:
+		if img, ok := m.selectedImage(); ok {
+			return fmt.Sprintf(" Delete %s? [y/N]", img.Image)
+		}
+		if m.cursor < len(m.repos) {
+			repo := m.repos[m.cursor]
+			return fmt.Sprintf(" Delete all %s of %s? [y/N]", plural(len(repo.images), "image"), repo.name)
+		}
+	case modeInputTag:
+		img, _ := m.selectedImage()
+		return fmt.Sprintf(" Tag %s as: %s_", img.Image, string(m.input))
+	}
+	if m.status != "" {
+		return " " + m.status
+	}
+	if img, ok := m.selectedImage(); ok {
+		return fmt.Sprintf(" %s  %s", img.Image, img.Digest)
+	}
+	return ""
+}
+
+func (m *model) helpLine() string {
+	if m.mode == modeInputTag {
+		return " enter: tag  esc: cancel  Use a tag, e.g. 1.2, or a full image reference."
+	}
+	if m.openRepo != "" {
+		return " ↑/↓: move  esc: back  t: tag  d: delete  r: refresh  q: quit"
+	}
+	return " ↑/↓: move  enter: open  d: delete all images  r: refresh  q: quit"
+}
+
+// formatTable aligns the cells of the rows in columns.
+func formatTable(rows [][]string) []string {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 8, 3, ' ', 0)
+	for _, row := range rows {
+		_, _ = fmt.Fprintln(tw, " "+strings.Join(row, "\t"))
+	}
+	_ = tw.Flush()
+	return strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+}
+
+// shortDigest returns the first 12 characters of the encoded digest like Docker shows image IDs.
+func shortDigest(dgst digest.Digest) string {
+	_, encoded, _ := strings.Cut(dgst.String(), ":")
+	return encoded[:min(len(encoded), 12)]
+}
+
+// since returns the human-readable time elapsed since t.
+func since(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d.Minutes()), "minute") + " ago"
+	case d < 48*time.Hour:
+		return plural(int(d.Hours()), "hour") + " ago"
+	default:
+		return plural(int(d.Hours()/24), "day") + " ago"
+	}
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,58 @@
+// Package version provides the build metadata of unregistry injected at build time with -ldflags, e.g.:
+//
+//	go build -ldflags "-X github.com/psviderski/unregistry/internal/version.Version=0.5.0" ./cmd/unregistry
+package version
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Version, Commit, and BuildDate are set at build time. If not set, Commit and BuildDate fall back to the commit
+// hash and time embedded by the Go toolchain when the binary is built from a git checkout.
+var (
+	// Version is the release version, e.g. "0.5.0".
+	Version = "dev"
+	// Commit is the git commit hash the binary is built from.
+	Commit = ""
+	// BuildDate is the time the binary was built in RFC 3339 format.
+	BuildDate = ""
+)
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if Commit == "" {
+				Commit = s.Value
+			}
+		case "vcs.time":
+			if BuildDate == "" {
+				BuildDate = s.Value
+			}
+		}
+	}
+}
+
+// String returns the version with the commit and build date if known, e.g. "0.5.0 (commit 1a2b3c4, built ...)".
+func String() string {
+	s := Version
+	switch {
+	case Commit != "" && BuildDate != "":
+		s += fmt.Sprintf(" (commit %s, built %s)", shortCommit(), BuildDate)
+	case Commit != "":
+		s += fmt.Sprintf(" (commit %s)", shortCommit())
+	case BuildDate != "":
+		s += fmt.Sprintf(" (built %s)", BuildDate)
+	}
+	return s
+}
+
+// shortCommit returns the abbreviated commit hash.
+func shortCommit() string {
+	return Commit[:min(7, len(Commit))]
+}
@@ -0,0 +1,82 @@
+package unregistry
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// activityTracker tracks in-flight registry requests and the time of the last one to detect when the registry
+// is idle.
+type activityTracker struct {
+	inflight atomic.Int64
+	// last is the time of the last finished request in Unix nanoseconds.
+	last atomic.Int64
+}
+
+func newActivityTracker() *activityTracker {
+	a := &activityTracker{}
+	a.last.Store(time.Now().UnixNano())
+	return a
+}
+
+// handler wraps the registry handler to track its requests.
+func (a *activityTracker) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.inflight.Add(1)
+		defer func() {
+			a.last.Store(time.Now().UnixNano())
+			a.inflight.Add(-1)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// idleFor returns how long the registry has been idle. It's 0 while there are in-flight requests.
+func (a *activityTracker) idleFor() time.Duration {
+	if a.inflight.Load() > 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, a.last.Load()))
+}
+
+// ShutdownRequested returns a channel that is closed when the registry asks to be shut down, either after being idle
+// for the configured duration or through the admin API. The caller is expected to call Shutdown then.
+func (r *Registry) ShutdownRequested() <-chan struct{} {
+	return r.shutdownCh
+}
+
+// requestShutdown asks the registry to be shut down. Subsequent calls have no effect.
+func (r *Registry) requestShutdown(reason string) {
+	r.shutdownOnce.Do(func() {
+		logrus.WithField("reason", reason).Info("Registry shutdown requested.")
+		close(r.shutdownCh)
+	})
+}
+
+// watchIdle requests the registry shutdown once it has been idle for the given duration.
+func (r *Registry) watchIdle(timeout time.Duration) {
+	// Check often enough to not overshoot the timeout by much.
+	ticker := time.NewTicker(max(min(timeout/10, time.Second), time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.shutdownCh:
+			return
+		case <-ticker.C:
+			if r.activity.idleFor() >= timeout {
+				r.requestShutdown("idle for " + timeout.String())
+				return
+			}
+		}
+	}
+}
+
+// shutdownHandler requests the registry shutdown. The shutdown is graceful so in-flight requests are completed.
+func (r *Registry) shutdownHandler(w http.ResponseWriter, _ *http.Request) {
+	r.requestShutdown("admin API request")
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,183 @@
+package unregistry
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/semaphore"
+)
+
+// uploadSessionPathRegexp matches the path of a blob upload session: /v2/<name>/blobs/uploads/<uuid>
+var uploadSessionPathRegexp = regexp.MustCompile(`^/v2/(.+)/blobs/uploads/([^/]+)$`)
+
+// smallPlatformCopyLimit is the default limit of concurrent blob copy and verification operations on 32-bit
+// platforms. These are mostly small ARM and riscv64 boards with little memory and slow storage, e.g. SD cards, where
+// copying many layers in parallel only thrashes the storage and risks running out of memory.
+const smallPlatformCopyLimit = 2
+
+// copyLimit returns the limit of concurrent blob copy and verification operations. If not configured, it's unlimited
+// on 64-bit platforms and limited to smallPlatformCopyLimit on 32-bit ones.
+func copyLimit(cfg Config) int {
+	if cfg.MaxConcurrentCopies > 0 || strconv.IntSize == 64 {
+		return cfg.MaxConcurrentCopies
+	}
+	logrus.WithFields(logrus.Fields{
+		"arch":  runtime.GOARCH,
+		"limit": smallPlatformCopyLimit,
+	}).Debug("Limited the number of concurrent blob copies by default on a 32-bit platform.")
+	return smallPlatformCopyLimit
+}
+
+// applyResourceLimits configures the process to not starve other workloads running on the same host, for example,
+// when unregistry runs on a production server and receives a big push.
+func applyResourceLimits(cfg Config) error {
+	if cfg.MaxProcs > 0 {
+		runtime.GOMAXPROCS(cfg.MaxProcs)
+		logrus.WithField("procs", cfg.MaxProcs).Debug("Limited the number of CPUs used by the registry.")
+	}
+
+	if cfg.MemoryLimit != "" {
+		limit, err := parseSize(cfg.MemoryLimit)
+		if err != nil {
+			return fmt.Errorf("invalid memory limit: %w", err)
+		}
+		// It's a soft limit that makes the garbage collector more aggressive as the heap approaches it.
+		debug.SetMemoryLimit(limit)
+		logrus.WithField("limit", cfg.MemoryLimit).Debug("Set soft memory limit for the registry.")
+	}
+
+	if cfg.LowPriority {
+		if err := lowerPriority(); err != nil {
+			return fmt.Errorf("lower CPU and IO priority: %w", err)
+		}
+		logrus.Debug("Lowered CPU and IO priority of the registry process.")
+	}
+
+	return nil
+}
+
+// uploadLimitHandler wraps the registry handler to limit the number of requests transferring blob data (PATCH and PUT
+// to an upload session, and monolithic uploads) at the same time. Excess requests wait for a free slot instead of
+// failing so that clients on constrained links upload layers one by one rather than competing for bandwidth and timing
+// out on large layers.
+// If maxWait is positive, requests that haven't got a slot within it are rejected with 429 Too Many Requests and
+// a Retry-After header instead so that clients back off rather than hold connections open until they time out.
+func uploadLimitHandler(next http.Handler, limit int, maxWait time.Duration) http.Handler {
+	sem := semaphore.NewWeighted(int64(limit))
+	retryAfter := strconv.Itoa(max(int(math.Ceil(maxWait.Seconds())), 1))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		transfer := (r.Method == http.MethodPatch || r.Method == http.MethodPut) &&
+			uploadSessionPathRegexp.MatchString(r.URL.Path)
+		if !transfer && !isMonolithicUpload(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		if maxWait > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, maxWait)
+			defer cancel()
+		}
+		if err := sem.Acquire(ctx, 1); err != nil {
+			if r.Context().Err() != nil {
+				// The client has gone away while waiting.
+				return
+			}
+			w.Header().Set("Retry-After", retryAfter)
+			writeOCIError(w, http.StatusTooManyRequests, "TOOMANYREQUESTS",
+				fmt.Sprintf("too many concurrent blob uploads (limit %d), retry later", limit))
+			logrus.WithField("path", r.URL.Path).Debug("Rejected blob upload waiting too long for an upload slot.")
+			return
+		}
+		defer sem.Release(1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxManifestBodySize is the maximum size of a pushed manifest the registry app accepts.
+const maxManifestBodySize = 4 << 20
+
+// manifestSize returns the configured maximum size of a pushed manifest in bytes.
+func manifestSize(cfg Config) (int64, error) {
+	if cfg.MaxManifestSize == "" {
+		return maxManifestBodySize, nil
+	}
+	size, err := parseSize(cfg.MaxManifestSize)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max manifest size: %w", err)
+	}
+	if size <= 0 || size > maxManifestBodySize {
+		return 0, fmt.Errorf("invalid max manifest size '%s': must be between 1 byte and 4MiB", cfg.MaxManifestSize)
+	}
+	return size, nil
+}
+
+// manifestSizeHandler wraps the registry handler to reject manifest pushes larger than maxSize before the registry app
+// buffers them in memory. Pushes with a larger Content-Length are rejected right away with 413 Payload Too Large.
+// The body of pushes without a Content-Length is cut off at maxSize so that the registry app fails to read it.
+func manifestSizeHandler(next http.Handler, maxSize int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || !manifestPathRegexp.MatchString(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength > maxSize {
+			writeOCIError(w, http.StatusRequestEntityTooLarge, "MANIFEST_INVALID",
+				fmt.Sprintf("manifest size %d exceeds the limit of %d bytes", r.ContentLength, maxSize))
+			logrus.WithFields(logrus.Fields{
+				"path": r.URL.Path,
+				"size": r.ContentLength,
+			}).Debug("Rejected oversized manifest push.")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseSize parses a human-readable size such as "512MiB", "1G" or "1048576" into the number of bytes.
+// Both decimal (KB, MB, GB, TB) and binary (KiB, MiB, GiB, TiB) suffixes are supported. Single-letter suffixes
+// (K, M, G, T) are treated as binary.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+		{"B", 1},
+	}
+
+	value := strings.TrimSpace(s)
+	multiplier := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(strings.ToUpper(value), strings.ToUpper(u.suffix)) {
+			value = strings.TrimSpace(value[:len(value)-len(u.suffix)])
+			multiplier = u.multiplier
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	size := n * multiplier
+	if size > math.MaxInt64 {
+		return 0, fmt.Errorf("size '%s' is too large", s)
+	}
+
+	return int64(size), nil
+}
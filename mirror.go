@@ -0,0 +1,64 @@
+package unregistry
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// mirrorAccessControllerName is the name of the access controller that authenticates users with an htpasswd file
+// like the "htpasswd" one but allows anonymous pulls of Docker daemons using unregistry as a registry mirror.
+const mirrorAccessControllerName = "unregistry-mirror"
+
+func init() {
+	if err := auth.Register(mirrorAccessControllerName, newMirrorAccessController); err != nil {
+		panic(err)
+	}
+}
+
+// mirrorAccessController wraps the htpasswd access controller to answer the requests of Docker daemons configured
+// with unregistry in "registry-mirrors". The daemon doesn't send credentials to mirrors: it pings /v2/ and gives up on
+// the mirror falling back to Docker Hub when challenged, so the ping and pulls without credentials are allowed.
+// Requests with credentials, pushes, and the catalog are still authenticated with the htpasswd file.
+type mirrorAccessController struct {
+	auth.AccessController
+}
+
+func newMirrorAccessController(options map[string]interface{}) (auth.AccessController, error) {
+	ac, err := auth.GetAccessController("htpasswd", options)
+	if err != nil {
+		return nil, fmt.Errorf("create htpasswd access controller: %w", err)
+	}
+	return &mirrorAccessController{AccessController: ac}, nil
+}
+
+func (ac *mirrorAccessController) Authorized(req *http.Request, access ...auth.Access) (*auth.Grant, error) {
+	if _, _, ok := req.BasicAuth(); !ok && anonymousPull(req, access) {
+		return &auth.Grant{Resources: resources(access)}, nil
+	}
+	return ac.AccessController.Authorized(req, access...)
+}
+
+// anonymousPull returns true if the request only reads from the registry: the /v2/ ping or a pull of a manifest,
+// blob, or tag list. The access records are empty for the ping and the endpoints served outside the registry app.
+func anonymousPull(req *http.Request, access []auth.Access) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	for _, a := range access {
+		if a.Type != "repository" || a.Action != "pull" {
+			return false
+		}
+	}
+	return true
+}
+
+// resources returns the resources of the access records.
+func resources(access []auth.Access) []auth.Resource {
+	res := make([]auth.Resource, len(access))
+	for i, a := range access {
+		res[i] = a.Resource
+	}
+	return res
+}
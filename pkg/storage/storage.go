@@ -0,0 +1,136 @@
+// Package storage is the extension point for the image storage backends of unregistry. A backend is an implementation
+// of distribution.Namespace serving the repositories, manifests, tags and blobs of the registry API from an image
+// store, e.g. the containerd image store. Third-party backends register a factory under a unique name in an init
+// function and are selected with the Backend option of the registry configuration:
+//
+//	func init() {
+//		storage.MustRegister("ignite", func(ctx context.Context, opts storage.Options) (distribution.Namespace, error) {
+//			return newIgniteStore(opts.String("dir"))
+//		})
+//	}
+//
+// The registry closes a backend that implements io.Closer when it shuts down and checks the readiness of a backend
+// that implements HealthChecker.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	middleware "github.com/distribution/distribution/v3/registry/middleware/registry"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// Options are the options the registry passes to a backend factory. Besides the backend-specific options from
+// the registry configuration, the registry sets "repofilter" to a value with an Allowed(name string) bool method
+// restricting the repositories that can be accessed.
+type Options map[string]any
+
+// String returns the string option with the given key or an empty string if it's not set or not a string.
+func (o Options) String(key string) string {
+	s, _ := o[key].(string)
+	return s
+}
+
+// Factory creates an instance of a storage backend from the options.
+type Factory func(ctx context.Context, options Options) (distribution.Namespace, error)
+
+// HealthChecker is implemented by the backends that can check if their image store is available. The registry reports
+// itself as not ready when the check fails.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// middlewareName is the name of the registry middleware that plugs a backend instance into the distribution registry
+// app.
+const middlewareName = "unregistry-storage"
+
+func init() {
+	// The distribution registry app only supports custom storage implementations as registry middlewares that
+	// replace the registry instance they're applied to. This middleware replaces it with the backend instance passed
+	// in the "backend" option.
+	err := middleware.Register(middlewareName, func(
+		_ context.Context, _ distribution.Namespace, _ storagedriver.StorageDriver, options map[string]interface{},
+	) (distribution.Namespace, error) {
+		ns, ok := options["backend"].(distribution.Namespace)
+		if !ok || ns == nil {
+			return nil, fmt.Errorf("storage backend instance is required")
+		}
+		return ns, nil
+	})
+	if err != nil {
+		panic(fmt.Sprintf("failed to register storage middleware: %v", err))
+	}
+}
+
+// Middleware returns the configuration of the registry middleware that makes the distribution registry app serve
+// the backend instance.
+func Middleware(backend distribution.Namespace) configuration.Middleware {
+	return configuration.Middleware{
+		Name:    middlewareName,
+		Options: configuration.Parameters{"backend": backend},
+	}
+}
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register registers the backend factory under the name. It fails if a backend with the same name is already
+// registered.
+func Register(name string, factory Factory) error {
+	if name == "" || factory == nil {
+		return fmt.Errorf("storage backend name and factory are required")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := factories[name]; ok {
+		return fmt.Errorf("storage backend '%s' is already registered", name)
+	}
+
+	factories[name] = factory
+	return nil
+}
+
+// MustRegister is like Register but panics if the backend can't be registered.
+func MustRegister(name string, factory Factory) {
+	if err := Register(name, factory); err != nil {
+		panic(err)
+	}
+}
+
+// Registered returns true if a backend with the name is registered.
+func Registered(name string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := factories[name]
+	return ok
+}
+
+// Backends returns the sorted names of the registered backends.
+func Backends() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// New creates an instance of the registered backend with the name.
+func New(ctx context.Context, name string, options Options) (distribution.Namespace, error) {
+	mu.RLock()
+	factory, ok := factories[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("storage backend '%s' is not registered", name)
+	}
+	return factory(ctx, options)
+}
@@ -0,0 +1,135 @@
+package unregistry
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/sirupsen/logrus"
+)
+
+// tagLocks is the number of locks conditional tag updates are striped over.
+const tagLocks = 64
+
+// tagLockSet serialises conditional updates of the same tag so that checking the precondition and moving the tag
+// happen atomically with respect to other conditional updates.
+type tagLockSet [tagLocks]sync.Mutex
+
+func (s *tagLockSet) lock(ref string) func() {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(ref))
+	mu := &s[h.Sum32()%tagLocks]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// tagPreconditionHandler wraps the registry handler to support conditional manifest pushes by tag for optimistic
+// concurrency between deployments from multiple CI jobs:
+//   - If-Match: "<digest>"[, ...] only moves the tag if it currently points to one of the digests. "*" requires
+//     the tag to exist. The digest of a tag is returned in the ETag header of manifest GET and HEAD responses.
+//   - If-None-Match: * only creates the tag if it doesn't exist.
+//
+// A push that doesn't satisfy the precondition is rejected with 412 Precondition Failed.
+func (r *Registry) tagPreconditionHandler(next http.Handler) http.Handler {
+	var locks tagLockSet
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ifMatch, ifNoneMatch := req.Header.Get("If-Match"), req.Header.Get("If-None-Match")
+		if req.Method != http.MethodPut || (ifMatch == "" && ifNoneMatch == "") {
+			next.ServeHTTP(w, req)
+			return
+		}
+		// Only pushes by tag can be conditional.
+		ref, ok := parseManifestPathTag(req.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, req)
+			return
+		}
+		// Let the registry app reject unauthorized requests without revealing the tag state.
+		if r.accessController != nil {
+			if _, err := r.accessController.Authorized(req); err != nil {
+				next.ServeHTTP(w, req)
+				return
+			}
+		}
+
+		// The namespace a manifest is routed to by its annotation is only known once the manifest is read, so the tag
+		// to check can't be resolved beforehand.
+		if r.cfg.NamespaceAnnotation != "" {
+			writeOCIError(w, http.StatusNotImplemented, "UNSUPPORTED",
+				"conditional manifest pushes are not supported with routing by manifest annotation")
+			return
+		}
+		// Resolve the tag in the storage backend the repository is routed to, e.g. another containerd namespace.
+		repository, err := r.namespace.Repository(req.Context(), reference.TrimNamed(ref))
+		if err != nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		resolver, ok := repository.(containerd.TagResolver)
+		if !ok {
+			writeOCIError(w, http.StatusNotImplemented, "UNSUPPORTED", fmt.Sprintf(
+				"conditional manifest pushes are not supported by the '%s' backend", r.cfg.backendName()))
+			return
+		}
+
+		unlock := locks.lock(ref.String())
+		defer unlock()
+
+		current, err := resolver.CurrentTag(req.Context(), ref.Tag())
+		if err != nil {
+			writeOCIError(w, http.StatusInternalServerError, "UNKNOWN", err.Error())
+			return
+		}
+
+		if ok, reason := tagPreconditionMet(current, ifMatch, ifNoneMatch); !ok {
+			logrus.WithFields(logrus.Fields{
+				"image":   ref.String(),
+				"current": current,
+				"reason":  reason,
+			}).Info("Rejected conditional manifest push as precondition failed.")
+			if current != "" {
+				w.Header().Set("ETag", fmt.Sprintf(`"%s"`, current))
+			}
+			writeOCIError(w, http.StatusPreconditionFailed, "PRECONDITION_FAILED", reason)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
+
+// tagPreconditionMet checks the If-Match and If-None-Match header values against the current digest of the tag which
+// is empty if the tag doesn't exist. It returns the reason if the precondition isn't met.
+func tagPreconditionMet(current digest.Digest, ifMatch, ifNoneMatch string) (bool, string) {
+	if ifMatch != "" {
+		if current == "" {
+			return false, "tag doesn't exist"
+		}
+		if !etagsMatch(ifMatch, current) {
+			return false, fmt.Sprintf("tag points to '%s'", current)
+		}
+	}
+	if ifNoneMatch != "" && current != "" && etagsMatch(ifNoneMatch, current) {
+		return false, fmt.Sprintf("tag already exists and points to '%s'", current)
+	}
+	return true, ""
+}
+
+// etagsMatch returns true if the comma-separated list of entity tags contains "*" or the digest. Both quoted and bare
+// digests are accepted as some clients don't quote them.
+func etagsMatch(header string, dgst digest.Digest) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		tag = strings.TrimPrefix(tag, "W/")
+		tag = strings.Trim(tag, `"`)
+		if tag == "*" || tag == dgst.String() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package unregistry
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// pressureCheckInterval is how long the measured host pressure is reused before reading it again.
+	pressureCheckInterval = time.Second
+	// pressureRetryAfter is the Retry-After value in seconds for upload sessions rejected due to host pressure.
+	// PSI averages over 10 seconds so the pressure can't drop much sooner.
+	pressureRetryAfter = 10
+)
+
+// pressureFiles are the Linux pressure stall information (PSI) files of the resources watched for saturation.
+var pressureFiles = map[string]string{
+	"cpu": "/proc/pressure/cpu",
+	"io":  "/proc/pressure/io",
+}
+
+// pressureMonitor watches the CPU and IO pressure of the host to detect when it's saturated. The pressure of
+// a resource is the share of time in percent some tasks were stalled waiting for it over the last 10 seconds.
+type pressureMonitor struct {
+	threshold float64
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	// resource is the saturated resource at the last check. It's empty if the host isn't saturated.
+	resource string
+	pressure float64
+}
+
+// newPressureMonitor creates a pressure monitor that considers the host saturated when the pressure of any watched
+// resource exceeds the threshold in percent. It fails if the pressure information isn't available, e.g. on
+// non-Linux hosts or kernels without PSI support.
+func newPressureMonitor(threshold float64) (*pressureMonitor, error) {
+	if threshold <= 0 || threshold > 100 {
+		return nil, fmt.Errorf("invalid max pressure %v: expected a percentage between 0 and 100", threshold)
+	}
+	for _, path := range pressureFiles {
+		if _, err := readPressure(path); err != nil {
+			return nil, fmt.Errorf("pressure stall information (PSI) is not available: %w", err)
+		}
+	}
+	return &pressureMonitor{threshold: threshold}, nil
+}
+
+// saturated returns the resource which pressure exceeds the threshold and its pressure, or an empty resource if
+// the host isn't saturated. The pressure is read at most once per pressureCheckInterval.
+func (m *pressureMonitor) saturated() (string, float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if time.Since(m.checkedAt) < pressureCheckInterval {
+		return m.resource, m.pressure
+	}
+	m.checkedAt = time.Now()
+
+	resource, pressure := "", 0.0
+	for name, path := range pressureFiles {
+		p, err := readPressure(path)
+		if err != nil {
+			logrus.WithError(err).WithField("resource", name).Debug("Failed to read host pressure.")
+			continue
+		}
+		if p > m.threshold && p > pressure {
+			resource, pressure = name, p
+		}
+	}
+
+	if resource != "" && m.resource == "" {
+		logrus.WithFields(logrus.Fields{
+			"resource": resource,
+			"pressure": pressure,
+		}).Warn("Host is saturated, rejecting new uploads until the pressure drops.")
+	} else if resource == "" && m.resource != "" {
+		logrus.Info("Host is no longer saturated, accepting new uploads.")
+	}
+	m.resource, m.pressure = resource, pressure
+	return resource, pressure
+}
+
+// readPressure returns the "some" avg10 value from the PSI file, e.g. 1.23 from the line
+// "some avg10=1.23 avg60=0.50 avg300=0.10 total=123456".
+func readPressure(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "some" {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if value, ok := strings.CutPrefix(field, "avg10="); ok {
+				return strconv.ParseFloat(value, 64)
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, fmt.Errorf("read '%s': %w", path, err)
+	}
+	return 0, fmt.Errorf("no 'some avg10' value in '%s'", path)
+}
+
+// pressureHandler wraps the registry handler to reject new upload sessions with 503 Service Unavailable and
+// a Retry-After header while the host is saturated. Uploads already in progress continue so that clients don't lose
+// the transferred data, and pulls aren't affected.
+func pressureHandler(next http.Handler, monitor *pressureMonitor) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !uploadsPathRegexp.MatchString(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if resource, pressure := monitor.saturated(); resource != "" {
+			w.Header().Set("Retry-After", strconv.Itoa(pressureRetryAfter))
+			writeOCIError(w, http.StatusServiceUnavailable, "UNAVAILABLE",
+				fmt.Sprintf("host is saturated (%s pressure %.1f%%), retry later", resource, pressure))
+			logrus.WithFields(logrus.Fields{
+				"resource": resource,
+				"pressure": pressure,
+			}).Debug("Rejected upload session due to host pressure.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,50 @@
+package unregistry
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// lowNiceValue is the CPU scheduling priority for the registry process when running with low priority.
+	lowNiceValue = 10
+	// ioprioWhoProcess and ioprioClassBestEffort are from linux/ioprio.h.
+	ioprioWhoProcess      = 1
+	ioprioClassBestEffort = 2
+	ioprioClassShift      = 13
+	// ioprioLowest is the lowest priority level within the best-effort IO scheduling class.
+	ioprioLowest = 7
+)
+
+// lowerPriority lowers the CPU (nice) and IO (ioprio) scheduling priority of all threads of the process.
+// On Linux, both priorities are per-thread so they're set on every existing thread. New threads inherit
+// the priority of the thread that creates them. It doesn't affect containerd which writes the content of pushed
+// images to disk in its own process.
+func lowerPriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("list process threads: %w", err)
+	}
+
+	ioprio := ioprioClassBestEffort<<ioprioClassShift | ioprioLowest
+	var errs []error
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err = unix.Setpriority(unix.PRIO_PROCESS, tid, lowNiceValue); err != nil {
+			errs = append(errs, fmt.Errorf("set nice value for thread %d: %w", tid, err))
+		}
+		_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(ioprio))
+		if errno != 0 {
+			errs = append(errs, fmt.Errorf("set IO priority for thread %d: %w", tid, errno))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package unregistry
+
+import "errors"
+
+// lowerPriority is only supported on Linux.
+func lowerPriority() error {
+	return errors.New("lowering process priority is only supported on Linux")
+}
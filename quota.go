@@ -0,0 +1,74 @@
+package unregistry
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/reference"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+)
+
+// repoQuota returns the configured maximum size of the images in each repository in bytes. It's 0 if unlimited.
+func repoQuota(cfg Config) (int64, error) {
+	if cfg.RepoQuota == "" {
+		return 0, nil
+	}
+	quota, err := parseSize(cfg.RepoQuota)
+	if err != nil {
+		return 0, fmt.Errorf("invalid repository quota: %w", err)
+	}
+	if quota <= 0 {
+		return 0, fmt.Errorf("invalid repository quota '%s': must be positive", cfg.RepoQuota)
+	}
+	return quota, nil
+}
+
+// repoQuotaHandler wraps the registry handler to reject blob uploads to repositories over the storage quota with
+// 507 Insufficient Storage before the registry app accepts them. The quota is enforced by the containerd backend of
+// the namespace the repository is routed to, which also counts the uploaded bytes and rejects manifest pushes.
+// Checking uploads here returns the quota error to the client instead of the generic error the registry app returns
+// when writing the upload fails. Unauthorized requests are passed through for the registry app to reject them
+// without revealing the repository size.
+func (r *Registry) repoQuotaHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var name string
+		switch req.Method {
+		case http.MethodPost:
+			if m := uploadsPathRegexp.FindStringSubmatch(req.URL.Path); m != nil {
+				name = m[1]
+			}
+		case http.MethodPatch, http.MethodPut:
+			if m := uploadSessionPathRegexp.FindStringSubmatch(req.URL.Path); m != nil {
+				name = m[1]
+			}
+		}
+		repo, err := reference.WithName(name)
+		if name == "" || err != nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if r.accessController != nil {
+			if _, err = r.accessController.Authorized(req); err != nil {
+				next.ServeHTTP(w, req)
+				return
+			}
+		}
+
+		repository, err := r.namespace.Repository(req.Context(), repo)
+		if err != nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		checker, ok := repository.(containerd.QuotaChecker)
+		if !ok {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if err = checker.CheckQuota(req.Context(), max(req.ContentLength, 0)); err != nil {
+			_ = errcode.ServeJSON(w, err)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
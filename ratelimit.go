@@ -0,0 +1,288 @@
+package unregistry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// rateLimitIdleTTL is how long the limits of a client that doesn't send requests are kept before they are forgotten.
+const rateLimitIdleTTL = 10 * time.Minute
+
+// maxChunkSize is the maximum number of bytes a throttled transfer waits for at once.
+const maxChunkSize = 1 << 20
+
+// tokenBucket is a token bucket that refills at rate tokens per second up to burst tokens. It's not safe for
+// concurrent use.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// allow takes a token if available. Otherwise, it returns false and how long to wait until a token is available.
+func (b *tokenBucket) allow(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// reserve takes n tokens going into debt if not enough are available and returns how long to wait until the debt is
+// repaid.
+func (b *tokenBucket) reserve(now time.Time, n float64) time.Duration {
+	b.refill(now)
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// full returns true if the bucket has refilled completely meaning the client has been idle.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// clientLimits are the token buckets of a single client. A bucket is nil if the corresponding limit is disabled.
+type clientLimits struct {
+	requests  *tokenBucket
+	bandwidth *tokenBucket
+}
+
+// rateLimiter limits the request rate and the data transfer rate of each client.
+type rateLimiter struct {
+	// requestRate is the number of requests per second a client can make on average. Disabled if 0.
+	requestRate float64
+	// requestBurst is the number of requests a client can make at once.
+	requestBurst float64
+	// bandwidth is the number of bytes per second a client can upload and download. Disabled if 0.
+	bandwidth float64
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimits
+	lastSweep time.Time
+}
+
+// newRateLimiter creates a rate limiter from the configuration. It returns nil if rate limiting is disabled.
+func newRateLimiter(cfg Config) (*rateLimiter, error) {
+	if cfg.RateLimit < 0 {
+		return nil, errors.New("invalid rate limit: must not be negative")
+	}
+	var bandwidth int64
+	if cfg.RateLimitBandwidth != "" {
+		var err error
+		if bandwidth, err = parseSize(cfg.RateLimitBandwidth); err != nil {
+			return nil, fmt.Errorf("invalid rate limit bandwidth: %w", err)
+		}
+	}
+	if cfg.RateLimit == 0 && bandwidth == 0 {
+		return nil, nil
+	}
+
+	burst := float64(cfg.RateLimitBurst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(cfg.RateLimit))
+	}
+	return &rateLimiter{
+		requestRate:  cfg.RateLimit,
+		requestBurst: burst,
+		bandwidth:    float64(bandwidth),
+		clients:      make(map[string]*clientLimits),
+		lastSweep:    time.Now(),
+	}, nil
+}
+
+// allow checks if the client can make another request. Otherwise, it returns false and how long the client should
+// wait before retrying.
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	if l.requestRate == 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	return l.limits(client, now).requests.allow(now)
+}
+
+// waitBandwidth blocks until the client is allowed to transfer n more bytes or the context is done.
+func (l *rateLimiter) waitBandwidth(ctx context.Context, client string, n int) error {
+	if l.bandwidth == 0 || n == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	delay := l.limits(client, now).bandwidth.reserve(now, float64(n))
+	l.mu.Unlock()
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// chunkSize is the maximum number of bytes to transfer at once so that the transfer is smooth rather than bursty.
+// It's capped to not overflow int on 32-bit platforms with a huge bandwidth limit.
+func (l *rateLimiter) chunkSize() int {
+	return int(max(min(l.bandwidth/10, maxChunkSize), 1))
+}
+
+// limits returns the token buckets of the client creating them if needed. It must be called with the mutex held.
+func (l *rateLimiter) limits(client string, now time.Time) *clientLimits {
+	l.sweep(now)
+	limits, ok := l.clients[client]
+	if !ok {
+		limits = &clientLimits{}
+		if l.requestRate > 0 {
+			limits.requests = newTokenBucket(l.requestRate, l.requestBurst, now)
+		}
+		if l.bandwidth > 0 {
+			// Allow transferring up to a second worth of data at once.
+			limits.bandwidth = newTokenBucket(l.bandwidth, l.bandwidth, now)
+		}
+		l.clients[client] = limits
+	}
+	return limits
+}
+
+// sweep forgets the clients which buckets have refilled completely to not grow the map indefinitely. A forgotten
+// client gets full buckets again on the next request so it's indistinguishable from keeping it. It must be called
+// with the mutex held.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitIdleTTL {
+		return
+	}
+	l.lastSweep = now
+	for client, limits := range l.clients {
+		if (limits.requests == nil || limits.requests.full(now)) &&
+			(limits.bandwidth == nil || limits.bandwidth.full(now)) {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// rateLimitHandler wraps the registry handler to limit the request rate and the data transfer rate of each client
+// so that parallel pushes from a fast client don't overwhelm a small host. Requests over the rate limit are rejected
+// with 429 Too Many Requests and a Retry-After header. Transfers over the bandwidth limit are slowed down.
+// Clients are identified by the authenticated user if authentication is enabled, otherwise by the remote IP.
+func (r *Registry) rateLimitHandler(next http.Handler, limiter *rateLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.URL.Path, "/v2/") {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		client := r.rateLimitClient(req)
+		if ok, retryAfter := limiter.allow(client); !ok {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			writeOCIError(w, http.StatusTooManyRequests, "TOOMANYREQUESTS", "too many requests, retry later")
+			logrus.WithFields(logrus.Fields{
+				"client":      client,
+				"retry_after": seconds,
+			}).Debug("Rejected request over the rate limit.")
+			return
+		}
+
+		if limiter.bandwidth > 0 {
+			if req.Body != nil && req.Body != http.NoBody {
+				req.Body = &throttledReader{ReadCloser: req.Body, ctx: req.Context(), limiter: limiter, client: client}
+			}
+			w = &throttledResponseWriter{ResponseWriter: w, ctx: req.Context(), limiter: limiter, client: client}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// rateLimitClient returns the key identifying the client of the request for rate limiting. It's the name of
+// the authenticated user if authentication is enabled and the request has valid credentials, otherwise the remote
+// IP address. Unauthenticated requests can't pick an arbitrary user name to evade the per-IP limit.
+func (r *Registry) rateLimitClient(req *http.Request) string {
+	if user := r.requestUser(req); user != "" {
+		return "user:" + user
+	}
+	return "ip:" + remoteIP(req)
+}
+
+// throttledReader limits the rate of reading the request body of a client.
+type throttledReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rateLimiter
+	client  string
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.chunkSize() {
+		p = p[:r.limiter.chunkSize()]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if werr := r.limiter.waitBandwidth(r.ctx, r.client, n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+// throttledResponseWriter limits the rate of writing the response body to a client.
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	limiter *rateLimiter
+	client  string
+}
+
+func (w *throttledResponseWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), w.limiter.chunkSize())]
+		if err := w.limiter.waitBandwidth(w.ctx, w.client, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *throttledResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *throttledResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
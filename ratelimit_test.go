@@ -0,0 +1,96 @@
+package unregistry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(2, 3, now)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.allow(now); !ok {
+			t.Fatalf("expected request %d within the burst to be allowed", i+1)
+		}
+	}
+	ok, wait := b.allow(now)
+	if ok {
+		t.Fatal("expected the request over the burst to be rejected")
+	}
+	if wait != 500*time.Millisecond {
+		t.Fatalf("expected to wait 500ms for a token, got %s", wait)
+	}
+
+	// A token is refilled after the wait.
+	now = now.Add(wait)
+	if ok, _ = b.allow(now); !ok {
+		t.Fatal("expected the request after the wait to be allowed")
+	}
+
+	// The bucket doesn't refill over the burst.
+	now = now.Add(time.Hour)
+	if !b.full(now) || b.tokens != 3 {
+		t.Fatalf("expected the bucket to be full with 3 tokens, got %f", b.tokens)
+	}
+
+	// Reserving more than available goes into debt.
+	if wait = b.reserve(now, 7); wait != 2*time.Second {
+		t.Fatalf("expected to wait 2s to repay the debt, got %s", wait)
+	}
+	if ok, _ = b.allow(now.Add(time.Second)); ok {
+		t.Fatal("expected the request to be rejected until the debt is repaid")
+	}
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	limiter, err := newRateLimiter(Config{RateLimit: 0.5, RateLimitBurst: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := (&Registry{}).rateLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), limiter)
+
+	serve := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := serve("/v2/", "192.0.2.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("expected request %d within the burst to succeed, got status %d", i+1, rec.Code)
+		}
+	}
+
+	// Requests from another port of the same IP count against the same limit.
+	rec := serve("/v2/myapp/tags/list", "192.0.2.1:5678")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("expected Retry-After 2, got %q", got)
+	}
+	var body struct {
+		Errors []ociError `json:"errors"`
+	}
+	if err = json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Errors) != 1 || body.Errors[0].Code != "TOOMANYREQUESTS" {
+		t.Fatalf("expected TOOMANYREQUESTS error, got %s", rec.Body.String())
+	}
+
+	if rec = serve("/v2/", "192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("expected a request from another client to succeed, got status %d", rec.Code)
+	}
+	if rec = serve("/healthz", "192.0.2.1:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("expected a request outside the registry API not to be limited, got status %d", rec.Code)
+	}
+}
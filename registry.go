@@ -2,26 +2,111 @@ package unregistry
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"net"
 	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
 
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/platforms"
+	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/auth"
+	// Register htpasswd access controller.
+	_ "github.com/distribution/distribution/v3/registry/auth/htpasswd"
 	"github.com/distribution/distribution/v3/registry/handlers"
 	// Register filesystem storage driver.
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
+	"github.com/psviderski/unregistry/internal/accesslog"
+	"github.com/psviderski/unregistry/internal/audit"
+	"github.com/psviderski/unregistry/internal/events"
+	"github.com/psviderski/unregistry/internal/forward"
+	"github.com/psviderski/unregistry/internal/metadata"
+	"github.com/psviderski/unregistry/internal/progress"
 	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/psviderski/unregistry/internal/storage/docker"
+	"github.com/psviderski/unregistry/internal/storage/fallback"
+	"github.com/psviderski/unregistry/internal/storage/routing"
+	"github.com/psviderski/unregistry/internal/tracing"
+	"github.com/psviderski/unregistry/internal/transfer"
+	"github.com/psviderski/unregistry/internal/version"
+	"github.com/psviderski/unregistry/pkg/storage"
 	"github.com/sirupsen/logrus"
 )
 
 // Registry represents a complete instance of the registry.
 type Registry struct {
-	app    *handlers.App
-	server *http.Server
+	cfg Config
+	// client is the containerd client. It's nil if the registry uses the "docker" backend.
+	client *client.Client
+	// docker is the Docker Engine API client of the "docker" backend. It's nil for the containerd backend.
+	docker *docker.Client
+	// dockerStore keeps the blobs of the "docker" backend. It's nil for the containerd backend.
+	dockerStore *docker.Store
+	// backend is the primary storage backend serving the registry API.
+	backend distribution.Namespace
+	// namespace routes the repositories to the storage backends of their containerd namespaces.
+	namespace distribution.Namespace
+	// namespaceClients are the containerd clients of the other containerd namespaces used by the read fallbacks and
+	// namespace routes.
+	namespaceClients []*client.Client
+	app              *handlers.App
+	server           *http.Server
+	// adminServer serves the admin API on a unix socket. It's nil if the admin API is disabled.
+	adminServer *http.Server
+	// accessController authorizes requests to the endpoints served outside the registry app. It's nil if
+	// authentication is disabled.
+	accessController auth.AccessController
+	// progress tracks the progress of active blob uploads.
+	progress *progress.Tracker
+	// repoFilter restricts which repositories can be accessed. All repositories are allowed if nil.
+	repoFilter *containerd.RepositoryFilter
+	// metadata stores registry-specific state that containerd can't hold well.
+	metadata metadata.Store
+	// platform is the default platform used to resolve multi-platform images.
+	platform platforms.MatchComparer
+	// dryRun collects pushed manifests in the dry-run mode. It's nil if the dry-run mode is disabled.
+	dryRun *transfer.DryRun
+	// events delivers registry events to the admin API and embedding application subscribers.
+	events *events.Broker
+	// forwarder delivers pushed images to peer registries. It's nil if forwarding is disabled.
+	forwarder *forward.Forwarder
+	// verifier verifies blob digests and collects verification statistics.
+	verifier *transfer.Verifier
+	// signatures rejects tagging images without a valid signature from the trusted keys. It's nil if signatures
+	// aren't required.
+	signatures *containerd.SignaturePolicy
+	// accessLog writes a log line per registry request. It's nil if access logging is disabled.
+	accessLog *accesslog.Logger
+	// audit writes audit records of pushes and pulls. It's nil if auditing is disabled.
+	audit *audit.Logger
+	// activity tracks registry requests to detect when the registry is idle.
+	activity *activityTracker
+	// shutdownTracing flushes the pending trace spans and stops exporting them. It's nil if tracing is disabled.
+	shutdownTracing func(context.Context) error
+	// stopBackground stops the background tasks, such as purging stale uploads, and background waits for them.
+	stopBackground context.CancelFunc
+	background     sync.WaitGroup
+	// shutdownCh is closed when the registry asks to be shut down.
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
 }
 
 // NewRegistry creates a new registry from the given configuration.
 func NewRegistry(cfg Config) (*Registry, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Configure logging.
 	level, err := logrus.ParseLevel(cfg.LogLevel)
 	if err != nil {
@@ -38,6 +123,166 @@ func NewRegistry(cfg Config) (*Registry, error) {
 		return nil, fmt.Errorf("invalid log formatter: '%s'; expected 'json' or 'text'", cfg.LogFormatter)
 	}
 
+	if err = applyResourceLimits(cfg); err != nil {
+		return nil, err
+	}
+
+	platform := platforms.Default()
+	if cfg.DefaultPlatform != "" {
+		p, err := platforms.Parse(cfg.DefaultPlatform)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default platform: %w", err)
+		}
+		platform = platforms.Only(p)
+	}
+
+	// The htpasswd access controller creates a file with a random user if it doesn't exist which is unexpected
+	// for a misspelled path.
+	if cfg.Htpasswd != "" {
+		if _, err = os.Stat(cfg.Htpasswd); err != nil {
+			return nil, fmt.Errorf("invalid htpasswd file: %w", err)
+		}
+	}
+
+	var requestLogFilter *requestLogFilter
+	if cfg.LogRequests {
+		if requestLogFilter, err = newRequestLogFilter(cfg.LogRequestsInclude, cfg.LogRequestsExclude); err != nil {
+			return nil, err
+		}
+		if !logrus.IsLevelEnabled(logrus.DebugLevel) {
+			logrus.Warn("Request logging is enabled but requests are only logged at the debug log level.")
+		}
+	}
+
+	limiter, err := newRateLimiter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	maxManifestSize, err := manifestSize(cfg)
+	if err != nil {
+		return nil, err
+	}
+	quota, err := repoQuota(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var pressure *pressureMonitor
+	if cfg.MaxPressure > 0 {
+		if pressure, err = newPressureMonitor(cfg.MaxPressure); err != nil {
+			return nil, err
+		}
+		logrus.WithField("max_pressure", cfg.MaxPressure).Info("Load shedding based on host pressure is enabled.")
+	}
+
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	repoFilter, err := containerd.NewRepositoryFilter(cfg.AllowRepos, cfg.DenyRepos)
+	if err != nil {
+		return nil, err
+	}
+	pullRewrites, err := containerd.NewPullRewrites(cfg.PullRewrites)
+	if err != nil {
+		return nil, err
+	}
+	pullThrough, err := containerd.NewPullThrough(cfg.ProxyUpstreams, cfg.ProxyTTL, platform)
+	if err != nil {
+		return nil, err
+	}
+	if pullThrough != nil {
+		logrus.WithFields(logrus.Fields{
+			"upstreams": len(cfg.ProxyUpstreams),
+			"ttl":       cfg.ProxyTTL,
+		}).Info("Fetching pulled images missing in containerd from upstream registries.")
+	}
+
+	signatures, err := containerd.NewSignaturePolicy(cfg.SignatureKeys)
+	if err != nil {
+		return nil, err
+	}
+	if signatures != nil {
+		logrus.WithField("keys", cfg.SignatureKeys).Info(
+			"Only images signed with the trusted keys can be pushed by tag.")
+	}
+
+	var (
+		cli         *client.Client
+		dockerCli   *docker.Client
+		dockerStore *docker.Store
+	)
+	switch cfg.backendName() {
+	case BackendContainerd:
+		if cfg.ContainerdNamespace, err = checkDockerDaemon(context.Background(), cfg); err != nil {
+			return nil, err
+		}
+		cfg.ContainerdNamespace = resolveNamespace(cfg)
+		// The detected namespace may be one of the merged ones.
+		cfg.MergeNamespaces = slices.DeleteFunc(slices.Clone(cfg.MergeNamespaces), func(ns string) bool {
+			return ns == cfg.ContainerdNamespace
+		})
+		if cli, err = newContainerdClient(cfg, cfg.ContainerdNamespace); err != nil {
+			return nil, err
+		}
+	case BackendDocker:
+		if dockerCli, dockerStore, err = newDockerBackend(cfg); err != nil {
+			return nil, err
+		}
+	}
+	fallbacks, namespaceClients, err := newReadFallbacks(cfg, repoFilter)
+	if err != nil {
+		if cli != nil {
+			_ = cli.Close()
+		}
+		return nil, err
+	}
+	closeClient := func() {
+		if cli != nil {
+			_ = cli.Close()
+		}
+		for _, c := range namespaceClients {
+			_ = c.Close()
+		}
+	}
+
+	store := metadata.NewMemoryStore()
+	if cfg.MetadataDB != "" {
+		if store, err = metadata.NewBoltStore(cfg.MetadataDB); err != nil {
+			closeClient()
+			return nil, err
+		}
+	}
+
+	var signingKey ed25519.PrivateKey
+	if cfg.ReportSigningKey != "" {
+		if signingKey, err = transfer.LoadSigningKey(cfg.ReportSigningKey); err != nil {
+			closeClient()
+			_ = store.Close()
+			return nil, err
+		}
+	}
+	reporter := transfer.NewReporter(store, signingKey)
+
+	tracker := progress.NewTracker()
+	broker := events.NewBroker()
+	var forwarder *forward.Forwarder
+	if len(cfg.ForwardPeers) > 0 {
+		if forwarder, err = forward.New(cli, store, broker, cfg.ForwardPeers, cfg.ForwardTTL); err != nil {
+			closeClient()
+			_ = store.Close()
+			return nil, err
+		}
+		if cfg.MetadataDB == "" {
+			logrus.Warn("Queued image deliveries to peer registries will be lost on restart without --metadata-db.")
+		}
+	}
+	verifier := transfer.NewVerifier(cfg.VerifyOnRead, cfg.VerifyOnWrite)
+	var dryRun *transfer.DryRun
+	if cfg.DryRun {
+		dryRun = transfer.NewDryRun()
+		logrus.Warn("Running in dry-run mode: pushed images are not stored.")
+	}
 	distConfig := &configuration.Configuration{
 		Storage: configuration.Storage{
 			"filesystem": configuration.Parameters{
@@ -49,44 +294,402 @@ func NewRegistry(cfg Config) (*Registry, error) {
 				},
 			},
 		},
-		Middleware: map[string][]configuration.Middleware{
-			"registry": {
-				{
-					Name: containerd.MiddlewareName,
-					Options: configuration.Parameters{
-						"namespace": cfg.ContainerdNamespace,
-						"sock":      cfg.ContainerdSock,
-					},
-				},
-			},
+		Catalog: configuration.Catalog{
+			MaxEntries: 1000,
 		},
 	}
+	var unpackSnapshotter string
+	if cfg.Unpack {
+		unpackSnapshotter = cfg.Snapshotter
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := containerd.CheckSnapshotter(ctx, cli, cfg.Snapshotter); err != nil {
+			logrus.WithError(err).Warn("Pushed images may fail to unpack.")
+		}
+		cancel()
+		logrus.WithField("snapshotter", cfg.Snapshotter).Info("Unpacking pushed images is enabled.")
+	}
+	// containerdOptions returns the options of a containerd backend storing images in the default namespace of cli.
+	containerdOptions := func(cli *client.Client) storage.Options {
+		return storage.Options{
+			"client":              cli,
+			"progress":            tracker,
+			"metadata":            store,
+			"copylimit":           copyLimit(cfg),
+			"dryrun":              dryRun,
+			"reporter":            reporter,
+			"events":              broker,
+			"verifier":            verifier,
+			"namespaceannotation": cfg.NamespaceAnnotation,
+			"repofilter":          repoFilter,
+			"validateschema":      cfg.ValidateSchema,
+			"transactions":        containerd.NewPushTransactions(cli, cfg.PushTimeout),
+			"pullrewrites":        pullRewrites,
+			"pullthrough":         pullThrough,
+			"signatures":          signatures,
+			"leaseexpiration":     cfg.LeaseTTL,
+			"unpack":              unpackSnapshotter,
+			"platform":            platform,
+			"repoquota":           quota,
+			"delete":              cfg.EnableDelete,
+		}
+	}
+	var backendOptions storage.Options
+	switch cfg.backendName() {
+	case BackendContainerd:
+		backendOptions = containerdOptions(cli)
+	case BackendDocker:
+		backendOptions = storage.Options{
+			"client":     dockerCli,
+			"store":      dockerStore,
+			"repofilter": repoFilter,
+			"delete":     cfg.EnableDelete,
+		}
+	default:
+		backendOptions = maps.Clone(cfg.BackendOptions)
+		if backendOptions == nil {
+			backendOptions = storage.Options{}
+		}
+		backendOptions["repofilter"] = repoFilter
+	}
+	backend, err := storage.New(context.Background(), cfg.backendName(), backendOptions)
+	if err != nil {
+		closeClient()
+		_ = store.Close()
+		return nil, fmt.Errorf("create storage backend '%s': %w", cfg.backendName(), err)
+	}
+	var routes []routing.Route
+	if cfg.backendName() == BackendContainerd {
+		var routeClients []*client.Client
+		if routes, routeClients, err = newNamespaceRoutes(cfg, backend, containerdOptions); err != nil {
+			closeClient()
+			_ = store.Close()
+			return nil, err
+		}
+		namespaceClients = append(namespaceClients, routeClients...)
+	}
+	namespace := routing.New(backend, routes)
+	distConfig.Middleware = map[string][]configuration.Middleware{
+		"registry": {storage.Middleware(fallback.New(namespace, fallbacks))},
+	}
+	if distConfig.HTTP.Secret, err = uploadStateSecret(store); err != nil {
+		closeClient()
+		_ = store.Close()
+		return nil, err
+	}
+	var accessController auth.AccessController
+	if cfg.Htpasswd != "" {
+		// The registry app and the endpoints served outside the app share the access controller and its cache of
+		// verified credentials.
+		if accessController, err = newAccessController(cfg); err != nil {
+			closeClient()
+			_ = store.Close()
+			return nil, fmt.Errorf("create htpasswd access controller: %w", err)
+		}
+		distConfig.Auth = appAuthConfig(accessController)
+		logrus.WithField("htpasswd", cfg.Htpasswd).Info("HTTP Basic authentication is enabled.")
+		if cfg.MirrorCompat {
+			logrus.Info("Registry mirror compatibility is enabled: pulls without credentials are allowed.")
+		}
+	}
+	var auditLogger *audit.Logger
+	if cfg.AuditLog != "" {
+		if auditLogger, err = audit.Open(cfg.AuditLog); err != nil {
+			closeClient()
+			_ = store.Close()
+			return nil, err
+		}
+		logrus.WithField("sink", cfg.AuditLog).Info("Audit logging is enabled.")
+	}
+	var accessLogger *accesslog.Logger
+	if cfg.AccessLog != "" {
+		if accessLogger, err = accesslog.Open(cfg.AccessLog, cfg.AccessLogFormat, cfg.AccessLogFields); err != nil {
+			closeClient()
+			_ = store.Close()
+			_ = auditLogger.Close()
+			return nil, err
+		}
+		// The access log replaces the access logs of the registry app.
+		logrus.SetFormatter(accessLogFilterFormatter{logrus.StandardLogger().Formatter})
+		logrus.WithField("sink", cfg.AccessLog).Info("Access logging is enabled.")
+	}
+	var shutdownTracing func(context.Context) error
+	if cfg.OTLPEndpoint != "" {
+		shutdownTracing, err = tracing.Init(context.Background(), cfg.OTLPEndpoint, cfg.TraceSampleRatio)
+		if err != nil {
+			closeClient()
+			_ = store.Close()
+			_ = auditLogger.Close()
+			_ = accessLogger.Close()
+			return nil, err
+		}
+		logrus.WithFields(logrus.Fields{
+			"endpoint":     cfg.OTLPEndpoint,
+			"sample_ratio": cfg.TraceSampleRatio,
+		}).Info("OpenTelemetry tracing is enabled.")
+	}
+
 	app := handlers.NewApp(context.Background(), distConfig)
-	server := &http.Server{
-		Addr:    cfg.Addr,
-		Handler: app,
+
+	reg := &Registry{
+		cfg:              cfg,
+		client:           cli,
+		docker:           dockerCli,
+		backend:          backend,
+		namespace:        namespace,
+		namespaceClients: namespaceClients,
+		dockerStore:      dockerStore,
+		app:              app,
+		accessController: accessController,
+		repoFilter:       repoFilter,
+		progress:         tracker,
+		metadata:         store,
+		platform:         platform,
+		dryRun:           dryRun,
+		events:           broker,
+		forwarder:        forwarder,
+		verifier:         verifier,
+		signatures:       signatures,
+		audit:            auditLogger,
+		accessLog:        accessLogger,
+		activity:         newActivityTracker(),
+		shutdownTracing:  shutdownTracing,
+		shutdownCh:       make(chan struct{}),
+	}
+
+	handler := reg.tagPreconditionHandler(reg.errorHintsHandler(app))
+	if !cfg.EnableDelete {
+		handler = untagUnsupportedHandler(handler)
+	}
+	if cfg.GlobalBlobs {
+		handler = reg.globalBlobsHandler(handler)
+	}
+	if cfg.Deltas {
+		handler = reg.deltaHandler(handler)
+	}
+	if cfg.EnableImport {
+		handler = reg.importHandler(handler)
+	}
+	if cfg.MaxConcurrentUploads > 0 {
+		handler = uploadLimitHandler(handler, cfg.MaxConcurrentUploads, cfg.MaxUploadWait)
+	}
+	// Check the disk space before waiting for an upload slot so that the client doesn't wait only to be rejected.
+	var dir string
+	if dockerStore != nil {
+		dir = dockerStore.Dir()
+	} else if cli != nil {
+		dir = contentStoreDir(context.Background(), cfg, cli)
+	}
+	if dir != "" {
+		handler = diskSpaceHandler(handler, dir)
+		logrus.WithField("dir", dir).Debug("Checking available disk space before accepting blob uploads.")
+	}
+	if quota > 0 {
+		handler = reg.repoQuotaHandler(handler)
+		logrus.WithField("quota", cfg.RepoQuota).Info("Enforcing storage quota for each repository.")
+	}
+	handler = manifestSizeHandler(handler, maxManifestSize)
+	handler = reg.publishEventsHandler(handler)
+	// The spec-strict handler must wrap the handlers above as it translates some requests into a sequence of requests.
+	if cfg.SpecStrict {
+		handler = specStrictHandler(handler)
+	}
+	if auditLogger != nil {
+		handler = reg.auditHandler(handler)
+	}
+	if pressure != nil {
+		handler = pressureHandler(handler, pressure)
+	}
+	// Rate limit the requests as the clients make them, before the spec-strict handler multiplies them.
+	if limiter != nil {
+		handler = reg.rateLimitHandler(handler, limiter)
+	}
+	if requestLogFilter != nil {
+		handler = requestLogHandler(handler, requestLogFilter)
+	}
+	if accessLogger != nil {
+		handler = reg.accessLogHandler(handler)
+	}
+	if shutdownTracing != nil {
+		handler = tracingHandler(handler)
+	}
+	// Health probes are served outside the activity tracker so that they don't keep an idle registry running.
+	reg.server = &http.Server{
+		Addr:      cfg.Addr,
+		Handler:   versionHandler(reg.healthHandler(reg.activity.handler(handler))),
+		TLSConfig: tlsConfig,
+		Protocols: serverProtocols(cfg),
+		// The timeouts are disabled by default as uploads and downloads of large layers over slow links can take many
+		// minutes and there is no way to tell how long a request should take.
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	reg.server.SetKeepAlivesEnabled(!cfg.DisableKeepAlives)
+	if cfg.AdminSock != "" {
+		reg.adminServer = &http.Server{
+			Handler: reg.adminHandler(),
+		}
+	}
+
+	return reg, nil
+}
+
+// accessControllerConfig returns the name and parameters of the access controller that authenticates requests with
+// the htpasswd file. Clients are challenged with HTTP Basic authentication.
+func accessControllerConfig(cfg Config) (string, configuration.Parameters) {
+	name := "htpasswd"
+	if cfg.MirrorCompat {
+		name = mirrorAccessControllerName
+	}
+	return name, configuration.Parameters{
+		"realm": "unregistry",
+		"path":  cfg.Htpasswd,
 	}
+}
+
+// uploadStateSecret returns the secret that signs the upload state in the upload URLs returned to clients. It's kept in
+// the metadata store so that uploads can be resumed after the registry restarts if the metadata store is persistent.
+func uploadStateSecret(store metadata.Store) (string, error) {
+	const key = "upload-state-secret"
 
-	return &Registry{
-		app:    app,
-		server: server,
-	}, nil
+	var secret string
+	err := store.Get(metadata.BucketRegistry, key, &secret)
+	if err == nil && secret != "" {
+		return secret, nil
+	}
+	if err != nil && !errors.Is(err, metadata.ErrNotFound) {
+		return "", fmt.Errorf("get upload state secret: %w", err)
+	}
+
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate upload state secret: %w", err)
+	}
+	secret = hex.EncodeToString(b)
+	if err = store.Put(metadata.BucketRegistry, key, secret); err != nil {
+		return "", fmt.Errorf("store upload state secret: %w", err)
+	}
+	return secret, nil
 }
 
-// ListenAndServe starts the HTTP server for the registry.
+// ListenAndServe starts the HTTP server for the registry and the admin API server if enabled.
 func (r *Registry) ListenAndServe() error {
-	logrus.WithField("addr", r.server.Addr).Info("Starting registry server.")
-	if err := r.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	if r.adminServer != nil {
+		ln, err := listenUnix(r.cfg.AdminSock)
+		if err != nil {
+			return fmt.Errorf("listen admin API socket: %w", err)
+		}
+
+		logrus.WithField("sock", r.cfg.AdminSock).Info("Starting admin API server.")
+		go func() {
+			if err := r.adminServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logrus.WithError(err).Error("Admin API server failed.")
+			}
+		}()
+	}
+
+	r.forwarder.Start()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.stopBackground = cancel
+	if r.cfg.StaleUploadAge > 0 {
+		logrus.WithField("max_age", r.cfg.StaleUploadAge).Info("Purging stale blob uploads in the background.")
+		r.background.Add(1)
+		go func() {
+			defer r.background.Done()
+			r.purgeStaleUploadsLoop(ctx, r.cfg.StaleUploadAge)
+		}()
+	}
+	for _, cli := range append([]*client.Client{r.client}, r.namespaceClients...) {
+		if cli == nil {
+			continue
+		}
+		r.background.Add(1)
+		go func() {
+			defer r.background.Done()
+			containerd.KeepConnected(ctx, cli)
+		}()
+	}
+	if r.client != nil {
+		r.background.Add(1)
+		go func() {
+			defer r.background.Done()
+			r.checkDockerOnFirstPush(ctx)
+		}()
+	}
+	if r.cfg.ProgressLogInterval > 0 {
+		r.background.Add(1)
+		go func() {
+			defer r.background.Done()
+			r.progress.LogProgress(ctx, r.cfg.ProgressLogInterval)
+		}()
+	}
+
+	if r.cfg.IdleExit > 0 {
+		logrus.WithField("timeout", r.cfg.IdleExit).Info("Registry will shut down when idle.")
+		go r.watchIdle(r.cfg.IdleExit)
+	}
+
+	addr := r.server.Addr
+	if addr == "" {
+		addr = ":http"
+		if r.server.TLSConfig != nil {
+			addr = ":https"
+		}
+	}
+	// TCP keep-alive probes detect dead connections of clients that disappeared without closing them, e.g. when
+	// an SSH tunnel is killed.
+	lc := net.ListenConfig{KeepAlive: r.cfg.TCPKeepAlive}
+	ln, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen registry address: %w", err)
+	}
+	if r.server.TLSConfig != nil {
+		logrus.WithFields(logrus.Fields{
+			"addr":    r.server.Addr,
+			"version": version.Version,
+		}).Info("Starting registry server with TLS.")
+		// The certificate is provided by TLSConfig.GetCertificate.
+		err = r.server.ServeTLS(ln, "", "")
+	} else {
+		logrus.WithFields(logrus.Fields{
+			"addr":    r.server.Addr,
+			"version": version.Version,
+		}).Info("Starting registry server.")
+		err = r.server.Serve(ln)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 	return nil
 }
 
-// Shutdown gracefully shuts down the registry's HTTP server and application object.
+// Shutdown gracefully shuts down the registry's HTTP servers and application object.
 func (r *Registry) Shutdown(ctx context.Context) error {
 	err := r.server.Shutdown(ctx)
+	r.forwarder.Stop()
+	if r.stopBackground != nil {
+		r.stopBackground()
+		r.background.Wait()
+	}
+	if r.adminServer != nil {
+		err = errors.Join(err, r.adminServer.Shutdown(ctx))
+	}
 	if appErr := r.app.Shutdown(); appErr != nil {
 		err = errors.Join(err, appErr)
 	}
-	return err
+	// Flush the spans of the requests served during the shutdown.
+	if r.shutdownTracing != nil {
+		err = errors.Join(err, r.shutdownTracing(ctx))
+	}
+	if closer, ok := r.backend.(io.Closer); ok {
+		err = errors.Join(err, closer.Close())
+	}
+	if r.client != nil {
+		err = errors.Join(err, r.client.Close())
+	}
+	for _, c := range r.namespaceClients {
+		err = errors.Join(err, c.Close())
+	}
+	return errors.Join(err, r.metadata.Close(), r.audit.Close(), r.accessLog.Close())
 }
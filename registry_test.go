@@ -0,0 +1,262 @@
+package unregistry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/v2/client"
+	"github.com/opencontainers/go-digest"
+	"github.com/psviderski/unregistry/internal/registrytest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeHtpasswd writes an htpasswd file with the user "alice" with the password "secret" and returns its path.
+func writeHtpasswd(t *testing.T) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err = os.WriteFile(path, []byte("alice:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newAuthTestHandler returns the handler of a registry created with NewRegistry that stores images in in-memory
+// containerd services and authenticates requests with htpasswd. The endpoints served outside the registry app and
+// a 1KB repository quota are enabled to check that they authenticate requests the same way as the app.
+func newAuthTestHandler(t *testing.T, mirrorCompat bool) http.Handler {
+	t.Helper()
+	cli := registrytest.NewClient(t)
+	reg, err := NewRegistry(Config{
+		ContainerdSock:      "/run/containerd/containerd.sock",
+		ContainerdNamespace: "default",
+		ContentStoreDir:     t.TempDir(),
+		Htpasswd:            writeHtpasswd(t),
+		MirrorCompat:        mirrorCompat,
+		GlobalBlobs:         true,
+		Deltas:              true,
+		EnableImport:        true,
+		RepoQuota:           "1KB",
+		LogLevel:            "error",
+		LogFormatter:        "text",
+		dialContainerd: func(string) (*client.Client, error) {
+			return cli, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = reg.Shutdown(context.Background())
+	})
+	return reg.server.Handler
+}
+
+func TestHtpasswdAuthentication(t *testing.T) {
+	app := newAuthTestHandler(t, false)
+	unknownDigest := digest.FromString("unknown").String()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		auth   func(req *http.Request)
+		want   int
+	}{
+		{name: "ping without credentials", method: http.MethodGet, path: "/v2/", want: http.StatusUnauthorized},
+		{
+			name:   "pull without credentials",
+			method: http.MethodGet,
+			path:   "/v2/myapp/tags/list",
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "wrong password",
+			method: http.MethodGet,
+			path:   "/v2/",
+			auth:   func(req *http.Request) { req.SetBasicAuth("alice", "wrong") },
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "unknown user",
+			method: http.MethodGet,
+			path:   "/v2/",
+			auth:   func(req *http.Request) { req.SetBasicAuth("bob", "secret") },
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "bearer token",
+			method: http.MethodGet,
+			path:   "/v2/",
+			auth:   func(req *http.Request) { req.Header.Set("Authorization", "Bearer token") },
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "ping with credentials",
+			method: http.MethodGet,
+			path:   "/v2/",
+			auth:   func(req *http.Request) { req.SetBasicAuth("alice", "secret") },
+			want:   http.StatusOK,
+		},
+		{
+			name:   "push with credentials",
+			method: http.MethodPost,
+			path:   "/v2/myapp/blobs/uploads/",
+			auth:   func(req *http.Request) { req.SetBasicAuth("alice", "secret") },
+			want:   http.StatusAccepted,
+		},
+		{
+			name:   "global blob without credentials",
+			method: http.MethodGet,
+			path:   "/v2/_blobs/" + unknownDigest,
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "global blob with credentials",
+			method: http.MethodGet,
+			path:   "/v2/_blobs/" + unknownDigest,
+			auth:   func(req *http.Request) { req.SetBasicAuth("alice", "secret") },
+			want:   http.StatusNotFound,
+		},
+		{
+			name:   "delta bases without credentials",
+			method: http.MethodGet,
+			path:   "/v2/myapp/_deltas/bases",
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "delta bases with credentials",
+			method: http.MethodGet,
+			path:   "/v2/myapp/_deltas/bases",
+			auth:   func(req *http.Request) { req.SetBasicAuth("alice", "secret") },
+			want:   http.StatusOK,
+		},
+		{
+			name:   "import without credentials",
+			method: http.MethodPost,
+			path:   "/api/images/import",
+			want:   http.StatusUnauthorized,
+		},
+		// Imports are only rejected because of the quota after they're authenticated.
+		{
+			name:   "import with credentials",
+			method: http.MethodPost,
+			path:   "/api/images/import",
+			auth:   func(req *http.Request) { req.SetBasicAuth("alice", "secret") },
+			want:   http.StatusForbidden,
+		},
+		// The quota isn't checked for unauthenticated pushes so that they don't reveal the repository size.
+		{
+			name:   "push over quota without credentials",
+			method: http.MethodPost,
+			path:   "/v2/myapp/blobs/uploads/",
+			body:   strings.Repeat("x", 2048),
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "push over quota with credentials",
+			method: http.MethodPost,
+			path:   "/v2/myapp/blobs/uploads/",
+			body:   strings.Repeat("x", 2048),
+			auth:   func(req *http.Request) { req.SetBasicAuth("alice", "secret") },
+			want:   http.StatusInsufficientStorage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.auth != nil {
+				tt.auth(req)
+			}
+			rec := httptest.NewRecorder()
+			app.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+			if tt.want != http.StatusUnauthorized {
+				return
+			}
+
+			// Clients can only authenticate with HTTP Basic credentials, even if they offered a bearer token.
+			if got := rec.Header().Get("WWW-Authenticate"); got != `Basic realm="unregistry"` {
+				t.Fatalf("expected a Basic challenge for realm unregistry, got %q", got)
+			}
+			var body struct {
+				Errors []ociError `json:"errors"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Errors) != 1 || body.Errors[0].Code != "UNAUTHORIZED" {
+				t.Fatalf("expected UNAUTHORIZED error, got %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestMirrorCompatAuthentication(t *testing.T) {
+	app := newAuthTestHandler(t, true)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   func(req *http.Request)
+		want   int
+	}{
+		{name: "ping without credentials", method: http.MethodGet, path: "/v2/", want: http.StatusOK},
+		{
+			name:   "pull without credentials",
+			method: http.MethodGet,
+			path:   "/v2/myapp/tags/list",
+			want:   http.StatusNotFound,
+		},
+		{
+			name:   "push without credentials",
+			method: http.MethodPost,
+			path:   "/v2/myapp/blobs/uploads/",
+			want:   http.StatusUnauthorized,
+		},
+		{name: "catalog without credentials", method: http.MethodGet, path: "/v2/_catalog", want: http.StatusUnauthorized},
+		{
+			name:   "pull with wrong password",
+			method: http.MethodGet,
+			path:   "/v2/myapp/tags/list",
+			auth:   func(req *http.Request) { req.SetBasicAuth("alice", "wrong") },
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "push with credentials",
+			method: http.MethodPost,
+			path:   "/v2/myapp/blobs/uploads/",
+			auth:   func(req *http.Request) { req.SetBasicAuth("alice", "secret") },
+			want:   http.StatusAccepted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.auth != nil {
+				tt.auth(req)
+			}
+			rec := httptest.NewRecorder()
+			app.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != `Basic realm="unregistry"` {
+				t.Fatalf("expected a Basic challenge for realm unregistry, got %q", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
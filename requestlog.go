@@ -0,0 +1,103 @@
+package unregistry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sensitiveHeaders are the request headers which values are redacted in request dumps.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// requestLogFilter selects the requests to dump by their URL path.
+type requestLogFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// newRequestLogFilter compiles the include and exclude regular expressions of request paths.
+func newRequestLogFilter(include, exclude []string) (*requestLogFilter, error) {
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		var res []*regexp.Regexp
+		for _, p := range patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid request log path pattern '%s': %w", p, err)
+			}
+			res = append(res, re)
+		}
+		return res, nil
+	}
+
+	var f requestLogFilter
+	var err error
+	if f.include, err = compile(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compile(exclude); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// match returns true if the path matches any include pattern or there are none, and doesn't match any exclude pattern.
+func (f *requestLogFilter) match(path string) bool {
+	matches := func(patterns []*regexp.Regexp) bool {
+		for _, re := range patterns {
+			if re.MatchString(path) {
+				return true
+			}
+		}
+		return false
+	}
+	return (len(f.include) == 0 || matches(f.include)) && !matches(f.exclude)
+}
+
+// requestLogHandler wraps the registry handler to dump the headers of requests and responses at the debug level
+// for debugging client behaviour, e.g. retry storms of blob uploads. Bodies aren't dumped as they're mostly blob data.
+func requestLogHandler(next http.Handler, filter *requestLogFilter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !logrus.IsLevelEnabled(logrus.DebugLevel) || !filter.match(req.URL.Path) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		log := logrus.WithFields(logrus.Fields{
+			"method": req.Method,
+			"path":   req.URL.Path,
+			"remote": req.RemoteAddr,
+		})
+		redacted := req.Clone(req.Context())
+		for _, h := range sensitiveHeaders {
+			if redacted.Header.Get(h) != "" {
+				redacted.Header.Set(h, "REDACTED")
+			}
+		}
+		if dump, err := httputil.DumpRequest(redacted, false); err == nil {
+			log.Debugf("HTTP request:\n%s", formatDump(string(dump)))
+		}
+
+		rw := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rw, req)
+
+		var headers strings.Builder
+		_ = rw.Header().Write(&headers)
+		log.WithFields(logrus.Fields{
+			"status":   rw.status,
+			"bytes":    rw.written,
+			"duration": time.Since(start),
+		}).Debugf("HTTP response:\n%s %d %s\n%s",
+			req.Proto, rw.status, http.StatusText(rw.status), formatDump(headers.String()))
+	})
+}
+
+// formatDump trims the HTTP message dump and uses LF line endings to make it readable in the logs.
+func formatDump(dump string) string {
+	return strings.TrimSpace(strings.ReplaceAll(dump, "\r\n", "\n"))
+}
@@ -25,14 +25,29 @@ cleanup() {
 }
 trap cleanup INT TERM EXIT
 
+daemon_config=""
 if [ "${DOCKER_CONTAINERD_STORE:-true}" = "true" ]; then
     echo "Using containerd image store for Docker."
-    mkdir -p /etc/docker
-    echo '{"features": {"containerd-snapshotter": true}}' > /etc/docker/daemon.json
+    daemon_config='"features": {"containerd-snapshotter": true}'
 else
     echo "Using the default Docker image store."
 fi
 
+if [ "${DOCKER_USERNS_REMAP:-false}" = "true" ]; then
+    echo "Using user namespace remapping for Docker."
+    # Create the default remap user upfront as dockerd can't create it with the BusyBox adduser.
+    if ! id dockremap >/dev/null 2>&1; then
+        addgroup -S dockremap
+        adduser -S -D -H -G dockremap dockremap
+        echo "dockremap:165536:65536" >> /etc/subuid
+        echo "dockremap:165536:65536" >> /etc/subgid
+    fi
+    daemon_config="${daemon_config:+${daemon_config}, }\"userns-remap\": \"default\""
+fi
+
+mkdir -p /etc/docker
+echo "{${daemon_config}}" > /etc/docker/daemon.json
+
 dind dockerd --host unix:///run/docker.sock --host=tcp://0.0.0.0:2375 --tls=false &
 /usr/sbin/sshd -o AllowTcpForwarding=yes
 
@@ -35,10 +35,7 @@ echo "Preparing to bump version to ${NEW_VERSION}..."
 echo "Updating version in relevant files..."
 
 # Update version in README.md using backreference
-perl -pi -e 's|(https://raw.githubusercontent.com/psviderski/unregistry/v)[0-9]+\.[0-9]+\.[0-9]+(/docker-pussh)|${1}'"${NEW_VERSION}"'${2}|' README.md
-
-# Update VERSION field in docker-pussh
-perl -pi -e "s|^VERSION=\"[0-9]+\.[0-9]+\.[0-9]+\"|VERSION=\"${NEW_VERSION}\"|" docker-pussh
+perl -pi -e 's|(https://github.com/psviderski/unregistry/releases/download/v)[0-9]+\.[0-9]+\.[0-9]+(/docker-pussh_)[0-9]+\.[0-9]+\.[0-9]+|${1}'"${NEW_VERSION}"'${2}'"${NEW_VERSION}"'|' README.md
 
 echo -e "Changes pending:\n---"
 git diff
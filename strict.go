@@ -0,0 +1,151 @@
+package unregistry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// uploadsPathRegexp matches the path of the blob upload endpoint: /v2/<name>/blobs/uploads/
+var uploadsPathRegexp = regexp.MustCompile(`^/v2/(.+)/blobs/uploads/?$`)
+
+// isMonolithicUpload returns true if the request is a monolithic blob upload: a POST upload request with the digest in
+// the query and the whole blob in the body. It's completed in a single request by the storage middleware.
+func isMonolithicUpload(r *http.Request) bool {
+	if r.Method != http.MethodPost || !uploadsPathRegexp.MatchString(r.URL.Path) {
+		return false
+	}
+	query := r.URL.Query()
+	return query.Get("digest") != "" && query.Get("mount") == ""
+}
+
+// specStrictHandler wraps the registry handler to follow the exact OCI distribution spec behaviours where
+// the distribution implementation is lenient or behaves like Docker Hub:
+//   - A monolithic upload is translated into starting an upload session and finishing it with a PUT request so that
+//     a failed upload responds with the exact error code, e.g. 400 DIGEST_INVALID, rather than 500 UNKNOWN as
+//     the registry app reports errors of the storage middleware completing it in a single request.
+//   - Client error responses always have an OCI error JSON body, even for unknown endpoints.
+func specStrictHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/v2/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w = &strictErrorResponseWriter{ResponseWriter: w}
+		if isMonolithicUpload(r) {
+			monolithicUpload(next, w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// monolithicUpload completes a monolithic blob upload (POST with a digest and blob in the body) by starting an upload
+// session and immediately finishing it with a PUT request carrying the blob using the wrapped handler.
+func monolithicUpload(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	// Start an upload session without the digest and body.
+	startReq := r.Clone(r.Context())
+	startReq.URL.RawQuery = ""
+	startReq.Body = http.NoBody
+	startReq.ContentLength = 0
+	startReq.Header.Del("Content-Length")
+	startReq.Header.Del("Content-Type")
+
+	rec := httptest.NewRecorder()
+	next.ServeHTTP(rec, startReq)
+	if rec.Code != http.StatusAccepted {
+		copyRecordedResponse(w, rec)
+		return
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || location.Path == "" {
+		writeOCIError(w, http.StatusInternalServerError, "UNKNOWN", "invalid upload location")
+		return
+	}
+	query := location.Query()
+	query.Set("digest", r.URL.Query().Get("digest"))
+
+	// Finish the upload session with the blob from the original request body.
+	putReq := r.Clone(r.Context())
+	putReq.Method = http.MethodPut
+	putReq.URL.Path = location.Path
+	putReq.URL.RawPath = ""
+	putReq.URL.RawQuery = query.Encode()
+	putReq.RequestURI = putReq.URL.RequestURI()
+
+	next.ServeHTTP(w, putReq)
+}
+
+// copyRecordedResponse writes the recorded response to w.
+func copyRecordedResponse(w http.ResponseWriter, rec *httptest.ResponseRecorder) {
+	for k, v := range rec.Header() {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.Code)
+	_, _ = w.Write(rec.Body.Bytes())
+}
+
+// strictErrorResponseWriter replaces non-JSON bodies of client error responses (4xx) with an OCI error JSON body.
+type strictErrorResponseWriter struct {
+	http.ResponseWriter
+	// discard is set when the original response body should be dropped because it has been replaced.
+	discard bool
+}
+
+func (w *strictErrorResponseWriter) WriteHeader(status int) {
+	if status < 400 || status >= 500 || strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	w.discard = true
+	code := "UNSUPPORTED"
+	switch status {
+	case http.StatusUnauthorized:
+		code = "UNAUTHORIZED"
+	case http.StatusForbidden:
+		code = "DENIED"
+	case http.StatusTooManyRequests:
+		code = "TOOMANYREQUESTS"
+	}
+	writeOCIError(w.ResponseWriter, status, code, http.StatusText(status))
+}
+
+func (w *strictErrorResponseWriter) Write(b []byte) (int, error) {
+	if w.discard {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *strictErrorResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows http.ResponseController to access the underlying response writer.
+func (w *strictErrorResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// ociError is an error in the format defined by the OCI distribution spec.
+type ociError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Detail  any    `json:"detail,omitempty"`
+}
+
+// writeOCIError writes an error response with the OCI error JSON body.
+func writeOCIError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Del("Content-Length")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	writeJSON(w, status, map[string][]ociError{
+		"errors": {{Code: code, Message: message}},
+	})
+}
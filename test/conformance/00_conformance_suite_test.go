@@ -21,11 +21,11 @@ func TestConformance(t *testing.T) {
 	// Configure environment variables for conformance tests.
 	os.Setenv("OCI_ROOT_URL", url)
 	os.Setenv("OCI_NAMESPACE", "conformance")
-	// Enable push and pull tests only. Discover and management are not supported yet.
+	// Enable push, pull, and content management tests. Discovery is not supported yet.
 	os.Setenv("OCI_TEST_PULL", "1")
 	os.Setenv("OCI_TEST_PUSH", "1")
 	//os.Setenv("OCI_TEST_CONTENT_DISCOVERY", "1")
-	//os.Setenv("OCI_TEST_CONTENT_MANAGEMENT", "1")
+	os.Setenv("OCI_TEST_CONTENT_MANAGEMENT", "1")
 	// Set debug mode for better logging.
 	//os.Setenv("OCI_DEBUG", "1")
 
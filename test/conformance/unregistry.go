@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -16,6 +18,10 @@ import (
 	"github.com/testcontainers/testcontainers-go/wait"
 )
 
+// specStrict indicates whether unregistry is started in the spec-strict mode that follows the OCI distribution spec
+// exactly. Set UNREGISTRY_SPEC_STRICT=true to run the conformance tests in this mode.
+var specStrict, _ = strconv.ParseBool(os.Getenv("UNREGISTRY_SPEC_STRICT"))
+
 // SetupUnregistry starts unregistry in a Docker-in-Docker testcontainer.
 func SetupUnregistry(t *testing.T) (testcontainers.Container, string) {
 	ctx := context.Background()
@@ -31,7 +37,9 @@ func SetupUnregistry(t *testing.T) (testcontainers.Container, string) {
 				},
 			},
 			Env: map[string]string{
-				"UNREGISTRY_LOG_LEVEL": "debug",
+				"UNREGISTRY_ENABLE_DELETE": "true",
+				"UNREGISTRY_LOG_LEVEL":     "debug",
+				"UNREGISTRY_SPEC_STRICT":   strconv.FormatBool(specStrict),
 			},
 			Privileged:   true,
 			ExposedPorts: []string{"5000"},
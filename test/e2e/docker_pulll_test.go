@@ -0,0 +1,88 @@
+package e2e
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/psviderski/unregistry/test/e2e/harness"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDockerPulllPlugin tests the happy path of pulling an image from a remote host using the docker-pulll plugin.
+// It tests both the enabled and disabled containerd image store on the remote host.
+func TestDockerPulllPlugin(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	localCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+	defer localCli.Close()
+
+	dockerPulllPath := filepath.Join(t.TempDir(), "docker-pulll")
+	build := exec.Command("go", "build", "-o", dockerPulllPath, "./cmd/docker-pulll")
+	build.Dir = projectRoot()
+	output, err := build.CombinedOutput()
+	require.NoError(t, err, "Failed to build docker-pulll: %s", string(output))
+
+	tests := []struct {
+		name      string
+		imageName string
+		opts      harness.Options
+	}{
+		{
+			name:      "native image store",
+			imageName: "traefik/whoami:v1.10.2",
+			opts:      harness.Options{RegistryPort: 50003},
+		},
+		{
+			name:      "containerd image store",
+			imageName: "traefik/whoami:v1.10.1",
+			opts:      harness.Options{RegistryPort: 50004, ContainerdStore: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			unregistry := harness.Run(t, tt.opts)
+			remoteCli := unregistry.DockerClient(t)
+
+			require.NoError(
+				t, harness.PullImage(ctx, remoteCli, tt.imageName, image.PullOptions{Platform: "linux/amd64"}),
+				"Failed to pull image '%s' on remote", tt.imageName,
+			)
+			t.Cleanup(func() {
+				_, err := localCli.ImageRemove(ctx, tt.imageName, image.RemoveOptions{PruneChildren: true})
+				if !client.IsErrNotFound(err) {
+					assert.NoError(t, err)
+				}
+			})
+
+			// Ensure image doesn't exist locally before pulling.
+			_, _, err := localCli.ImageInspectWithRaw(ctx, tt.imageName)
+			require.Error(t, err, "Image should not exist locally before pulling")
+
+			cmd := exec.Command(dockerPulllPath,
+				"-i", harness.SSHKeyPath(t),
+				"--no-host-key-check",
+				"--platform", "linux/amd64",
+				"root@localhost:"+unregistry.SSHPort,
+				tt.imageName,
+			)
+			t.Logf("Running docker-pulll command: %s", cmd.String())
+
+			output, err := cmd.CombinedOutput()
+			t.Logf("docker-pulll output:\n%s", string(output))
+			require.NoError(t, err, "Failed to run docker-pulll")
+
+			_, _, err = localCli.ImageInspectWithRaw(ctx, tt.imageName)
+			require.NoError(t, err, "Pulled image should appear in the local Docker")
+		})
+	}
+}
@@ -3,7 +3,6 @@ package e2e
 import (
 	"context"
 	"fmt"
-	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
@@ -12,6 +11,7 @@ import (
 
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
+	"github.com/psviderski/unregistry/test/e2e/harness"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -34,6 +34,12 @@ func TestDockerPusshPlugin(t *testing.T) {
 		fmt.Sprintf("%s", info.DriverStatus), "containerd.snapshotter",
 	)
 
+	dockerPusshPath := filepath.Join(t.TempDir(), "docker-pussh")
+	build := exec.Command("go", "build", "-o", dockerPusshPath, "./cmd/docker-pussh")
+	build.Dir = projectRoot()
+	output, err := build.CombinedOutput()
+	require.NoError(t, err, "Failed to build docker-pussh: %s", string(output))
+
 	imageName := "traefik/whoami:v1.10.3"
 	platform := "linux/amd64"
 	indexDigest := "sha256:43a68d10b9dfcfc3ffbfe4dd42100dc9aeaf29b3a5636c856337a5940f1b4f1c"
@@ -49,7 +55,7 @@ func TestDockerPusshPlugin(t *testing.T) {
 	})
 
 	require.NoError(
-		t, pullImage(ctx, localCli, imageName, image.PullOptions{Platform: platform}),
+		t, harness.PullImage(ctx, localCli, imageName, image.PullOptions{Platform: platform}),
 		"Failed to pull image '%s' locally", imageName,
 	)
 	img, _, err := localCli.ImageInspectWithRaw(ctx, imageName)
@@ -61,19 +67,16 @@ func TestDockerPusshPlugin(t *testing.T) {
 	}
 
 	tests := []struct {
-		name            string
-		registryPort    int
-		containerdStore bool
+		name string
+		opts harness.Options
 	}{
 		{
-			name:            "native image store",
-			registryPort:    50001,
-			containerdStore: false,
+			name: "native image store",
+			opts: harness.Options{RegistryPort: 50001},
 		},
 		{
-			name:            "containerd image store",
-			registryPort:    50002,
-			containerdStore: true,
+			name: "containerd image store",
+			opts: harness.Options{RegistryPort: 50002, ContainerdStore: true},
 		},
 	}
 
@@ -81,32 +84,20 @@ func TestDockerPusshPlugin(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			dockerPort, sshPort := runUnregistryDinD(t, tt.registryPort, tt.containerdStore)
-
-			// Create remote Docker client.
-			remoteCli, err := client.NewClientWithOpts(
-				client.WithHost("tcp://localhost:"+dockerPort),
-				client.WithAPIVersionNegotiation(),
-			)
-			require.NoError(t, err)
-			defer remoteCli.Close()
+			unregistry := harness.Run(t, tt.opts)
+			remoteCli := unregistry.DockerClient(t)
 
 			// Ensure image doesn't exist on remote before pushing.
 			_, _, err = remoteCli.ImageInspectWithRaw(ctx, imageName)
 			require.Error(t, err, "Image should not exist on remote before pushing")
 
-			root := projectRoot()
-			dockerPusshPath := filepath.Join(root, "docker-pussh")
-			sshKeyPath := filepath.Join(root, "test/e2e/ssh/test_key")
-
-			// Change permission of the ssh key to 0600 to avoid SSH WARNING: UNPROTECTED PRIVATE KEY FILE!
-			require.NoError(t, os.Chmod(sshKeyPath, 0600), "Failed to change permission of SSH key")
+			sshKeyPath := harness.SSHKeyPath(t)
 
 			cmd := exec.Command(dockerPusshPath,
 				"-i", sshKeyPath,
 				"--no-host-key-check",
 				imageName,
-				fmt.Sprintf("root@localhost:%s", sshPort),
+				"root@localhost:"+unregistry.SSHPort,
 			)
 
 			t.Logf("Running docker-pussh command: %s", cmd.String())
@@ -120,7 +111,7 @@ func TestDockerPusshPlugin(t *testing.T) {
 			require.NoError(t, err, "Pushed image should appear in the remote Docker")
 
 			// Verify the image details match expectations based on containerd store.
-			if tt.containerdStore {
+			if tt.opts.ContainerdStore {
 				assert.Equal(t, platformDigest, remoteImg.ID, "Image ID should match platform-specific image digest")
 			} else {
 				assert.Equal(t, dockerLocalDigest, remoteImg.ID, "Image ID should match Docker local image digest")
@@ -131,7 +122,7 @@ func TestDockerPusshPlugin(t *testing.T) {
 				"-i", sshKeyPath,
 				"--no-host-key-check",
 				imageName,
-				fmt.Sprintf("root@localhost:%s", sshPort),
+				"root@localhost:"+unregistry.SSHPort,
 			)
 
 			output, err = cmd.CombinedOutput()
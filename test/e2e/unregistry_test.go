@@ -1,8 +1,10 @@
 package e2e
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,11 +15,14 @@ import (
 	"testing"
 
 	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
-	"github.com/pkg/errors"
+	"github.com/psviderski/unregistry/test/e2e/harness"
 	"github.com/regclient/regclient"
 	"github.com/regclient/regclient/config"
+	"github.com/regclient/regclient/types/descriptor"
+	"github.com/regclient/regclient/types/manifest"
 	"github.com/regclient/regclient/types/ref"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -29,18 +34,9 @@ import (
 func TestUnregistryPushPull(t *testing.T) {
 	ctx := context.Background()
 
-	registryPort := 50000
-	dockerPort, _ := runUnregistryDinD(t, registryPort, true)
-
-	remoteCli, err := client.NewClientWithOpts(
-		client.WithHost("tcp://localhost:"+dockerPort),
-		client.WithAPIVersionNegotiation(),
-	)
-	require.NoError(t, err)
-	defer remoteCli.Close()
-
-	registryAddr := fmt.Sprintf("localhost:%d", registryPort)
-	t.Logf("Unregistry started at %s", registryAddr)
+	unregistry := harness.Run(t, harness.Options{RegistryPort: 50000, ContainerdStore: true})
+	remoteCli := unregistry.DockerClient(t)
+	registryAddr := unregistry.RegistryAddr
 
 	localCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	require.NoError(t, err)
@@ -81,7 +77,7 @@ func TestUnregistryPushPull(t *testing.T) {
 		)
 
 		require.NoError(
-			t, pullImage(ctx, localCli, imageName, image.PullOptions{Platform: platform}),
+			t, harness.PullImage(ctx, localCli, imageName, image.PullOptions{Platform: platform}),
 			"Failed to pull image '%s' locally", imageName,
 		)
 		img, _, err := localCli.ImageInspectWithRaw(ctx, imageName)
@@ -98,7 +94,7 @@ func TestUnregistryPushPull(t *testing.T) {
 			imageName,
 			registryImage,
 		)
-		output, err := pushImage(ctx, localCli, registryImage, image.PushOptions{Platform: &ociPlatform})
+		output, err := harness.PushImage(ctx, localCli, registryImage, image.PushOptions{Platform: &ociPlatform})
 		require.NoError(t, err, "Failed to push image '%s' to unregistry", registryImage)
 		assert.NotContains(t, output, "Layer already exists")
 
@@ -107,7 +103,7 @@ func TestUnregistryPushPull(t *testing.T) {
 		assert.Equal(t, platformDigest, img.ID, "Image ID should match platform-specific image digest")
 
 		// Push the same image to test that it doesn't push the same layer again.
-		output, err = pushImage(ctx, localCli, registryImage, image.PushOptions{Platform: &ociPlatform})
+		output, err = harness.PushImage(ctx, localCli, registryImage, image.PushOptions{Platform: &ociPlatform})
 		require.NoError(t, err, "Failed to push image '%s' to unregistry", registryImage)
 		assert.Contains(t, output, "Layer already exists", "Image should not be pushed again if it already exists")
 
@@ -119,7 +115,7 @@ func TestUnregistryPushPull(t *testing.T) {
 
 		// Pull the image back from unregistry.
 		require.NoError(
-			t, pullImage(ctx, localCli, registryImage, image.PullOptions{Platform: platform}),
+			t, harness.PullImage(ctx, localCli, registryImage, image.PullOptions{Platform: platform}),
 			"Failed to pull image '%s' from unregistry", registryImage,
 		)
 		img, _, err = localCli.ImageInspectWithRaw(ctx, registryImage)
@@ -136,7 +132,7 @@ func TestUnregistryPushPull(t *testing.T) {
 
 		// This is a bit weird, but it's the default behavior of the distribution registry.
 		require.NoError(
-			t, pullImage(ctx, localCli, registryImage, image.PullOptions{Platform: "linux/any-platform"}),
+			t, harness.PullImage(ctx, localCli, registryImage, image.PullOptions{Platform: "linux/any-platform"}),
 			"Pulling arbitrary platform should pull the existing platform-specific image",
 		)
 
@@ -157,7 +153,7 @@ func TestUnregistryPushPull(t *testing.T) {
 		require.NoError(t, err, "Failed to remove image '%s' from remote Docker", imageName)
 
 		require.ErrorContains(
-			t, pullImage(ctx, localCli, registryImage, image.PullOptions{Platform: platform}),
+			t, harness.PullImage(ctx, localCli, registryImage, image.PullOptions{Platform: platform}),
 			"not found",
 			"Pulling image '%s' should fail after removing it from remote Docker", registryImage,
 		)
@@ -193,7 +189,7 @@ func TestUnregistryPushPull(t *testing.T) {
 		// Pull the image locally for all platforms.
 		for _, platform := range platforms {
 			require.NoError(
-				t, pullImage(ctx, localCli, imageName, image.PullOptions{Platform: platform}),
+				t, harness.PullImage(ctx, localCli, imageName, image.PullOptions{Platform: platform}),
 				"Failed to pull image '%s' locally for platform '%s'", imageName, platform,
 			)
 		}
@@ -220,7 +216,7 @@ func TestUnregistryPushPull(t *testing.T) {
 			t, localCli.ImageTag(ctx, imageName, registryImage),
 			"Failed to tag image '%s' as '%s' locally", imageName, registryImage,
 		)
-		output, err := pushImage(ctx, localCli, registryImage, image.PushOptions{}) // all platforms
+		output, err := harness.PushImage(ctx, localCli, registryImage, image.PushOptions{}) // all platforms
 		require.NoError(t, err, "Failed to push multi-platform image '%s' to unregistry", registryImage)
 		assert.Contains(t, output, "Pushed", "Layers should be pushed to unregistry")
 		assert.NotContains(t, output, "Layer already exists")
@@ -252,7 +248,7 @@ func TestUnregistryPushPull(t *testing.T) {
 		)
 
 		// Push the same image to test that it doesn't push the same layer again.
-		output, err = pushImage(ctx, localCli, registryImage, image.PushOptions{})
+		output, err = harness.PushImage(ctx, localCli, registryImage, image.PushOptions{})
 		require.NoError(t, err, "Failed to push multi-platform image '%s' to unregistry", registryImage)
 		assert.Contains(
 			t, output, "Layer already exists", "Layers should not be pushed again if they already exists",
@@ -289,14 +285,14 @@ func TestUnregistryPushPull(t *testing.T) {
 		// First, pull only the selected platforms to remote Docker.
 		for _, platform := range availablePlatforms {
 			require.NoError(
-				t, pullImage(ctx, remoteCli, imageName, image.PullOptions{Platform: platform}),
+				t, harness.PullImage(ctx, remoteCli, imageName, image.PullOptions{Platform: platform}),
 				"Failed to pull image '%s' to remote Docker for platform '%s'", imageName, platform,
 			)
 		}
 
 		// Test 1: Pull available platforms - should succeed.
 		for _, platform := range availablePlatforms {
-			err = pullImage(ctx, localCli, registryImage, image.PullOptions{Platform: platform})
+			err = harness.PullImage(ctx, localCli, registryImage, image.PullOptions{Platform: platform})
 			require.NoError(t, err, "Failed to pull available platform '%s' from unregistry", platform)
 
 			// Verify the image was pulled successfully if not using containerd image store.
@@ -344,7 +340,7 @@ func TestUnregistryPushPull(t *testing.T) {
 		}
 
 		// Test 2: Pull missing platform - should fail with "not found".
-		err = pullImage(ctx, localCli, registryImage, image.PullOptions{Platform: missingPlatform})
+		err = harness.PullImage(ctx, localCli, registryImage, image.PullOptions{Platform: missingPlatform})
 		assert.ErrorContains(t, err, "not found", "Pulling missing platform '%s' should fail with 'not found'")
 	})
 
@@ -369,13 +365,13 @@ func TestUnregistryPushPull(t *testing.T) {
 			},
 		)
 
-		require.NoError(t, pullImage(ctx, localCli, imageName, image.PullOptions{}),
+		require.NoError(t, harness.PullImage(ctx, localCli, imageName, image.PullOptions{}),
 			"Failed to pull image '%s' locally", imageName)
 
 		// Tag the image with external registry prefix and push it to unregistry.
 		require.NoError(t, localCli.ImageTag(ctx, imageName, registryImage),
 			"Failed to tag image '%s' as '%s' locally", imageName, registryImage)
-		_, err := pushImage(ctx, localCli, registryImage, image.PushOptions{})
+		_, err := harness.PushImage(ctx, localCli, registryImage, image.PushOptions{})
 		require.NoError(t, err, "Failed to push image '%s' to unregistry", registryImage)
 
 		// Verify the image appears in remote Docker with the external registry prefix.
@@ -389,7 +385,7 @@ func TestUnregistryPushPull(t *testing.T) {
 		}
 
 		// Pull the image back from unregistry using the full path with external prefix.
-		require.NoError(t, pullImage(ctx, localCli, registryImage, image.PullOptions{}),
+		require.NoError(t, harness.PullImage(ctx, localCli, registryImage, image.PullOptions{}),
 			"Failed to pull image '%s' from unregistry", registryImage)
 	})
 
@@ -501,95 +497,120 @@ func TestUnregistryPushPull(t *testing.T) {
 			require.NoError(t, err, "Failed to pull image '%s' from unregistry", tt.image)
 		})
 	}
-}
 
-func pullImage(ctx context.Context, cli *client.Client, imageName string, opts image.PullOptions) error {
-	respBody, err := cli.ImagePull(ctx, imageName, opts)
-	if err != nil {
-		return err
-	}
-	defer respBody.Close()
-
-	decoder := json.NewDecoder(respBody)
-	errCh := make(chan error, 1)
-
-	go func() {
-		var jm jsonmessage.JSONMessage
-		for {
-			if err = decoder.Decode(&jm); err != nil {
-				if errors.Is(err, io.EOF) {
-					errCh <- nil
-					return
-				}
-				errCh <- fmt.Errorf("decode image pull message: %v", err)
-				return
+	t.Run("push/pull image by digest with regclient", func(t *testing.T) {
+		t.Parallel()
+
+		tarPath := filepath.Join("images", "busybox:1.36.1-musl-amd64_oci.tar")
+		imageDigest := "sha256:e56bc0f7fc7d4452b17eb4ac0a9261ff4c9a469afa45d2b673e03650716d095d"
+		// Use a separate repository to not interfere with the tarball image tests pushing the same image by tag.
+		imageName := "busybox-by-digest@" + imageDigest
+		registryImage := fmt.Sprintf("%s/%s", registryAddr, imageName)
+
+		t.Cleanup(func() {
+			_, err := remoteCli.ImageRemove(ctx, imageName, image.RemoveOptions{PruneChildren: true})
+			if !client.IsErrNotFound(err) {
+				assert.NoError(t, err)
 			}
+		})
+
+		rc, err := newRegClient(registryImage)
+		require.NoError(t, err, "Failed to create regclient for registry image '%s'", registryImage)
+		defer rc.Close(ctx)
+
+		err = rc.pushTarballImage(ctx, tarPath)
+		require.NoError(t, err, "Failed to push tarball image to unregistry by digest")
+
+		// The image pushed by digest should be stored as a digest-addressed image in remote Docker.
+		img, _, err := remoteCli.ImageInspectWithRaw(ctx, imageName)
+		require.NoError(t, err, "Image pushed by digest should appear in remote Docker")
+		assert.Equal(t, imageDigest, img.ID, "Image ID should match the pushed manifest digest")
+
+		// Verify the pushed image can be pulled by digest using regclient.
+		m, err := rc.ManifestGet(ctx, rc.Ref)
+		require.NoError(t, err, "Failed to get manifest for '%s' from unregistry", imageName)
+		assert.Equal(t, imageDigest, m.GetDescriptor().Digest.String())
 
-			if jm.Error != nil {
-				errCh <- fmt.Errorf("pull failed for '%s': %s", imageName, jm.Error.Message)
-				return
+		err = rc.ImageExport(ctx, rc.Ref, io.Discard)
+		require.NoError(t, err, "Failed to pull image '%s' from unregistry by digest", imageName)
+	})
+
+	t.Run("push/pull multi-platform image with attestations with regclient", func(t *testing.T) {
+		t.Parallel()
+
+		imageName := "attested:latest"
+		registryImage := fmt.Sprintf("%s/%s", registryAddr, imageName)
+
+		t.Cleanup(func() {
+			_, err := remoteCli.ImageRemove(ctx, imageName, image.RemoveOptions{PruneChildren: true})
+			if !client.IsErrNotFound(err) {
+				assert.NoError(t, err)
 			}
+		})
+
+		rc, err := newRegClient(registryImage)
+		require.NoError(t, err, "Failed to create regclient for registry image '%s'", registryImage)
+		defer rc.Close(ctx)
+
+		img := newAttestedImage(t)
+		for _, b := range img.blobs {
+			_, err = rc.BlobPut(ctx, rc.Ref, toDescriptor(b.desc), bytes.NewReader(b.data))
+			require.NoError(t, err, "Failed to push blob '%s'", b.desc.Digest)
 		}
-	}()
-
-	for {
-		select {
-		case err = <-errCh:
-			return err
-		case <-ctx.Done():
-			return ctx.Err()
+		// Push the platform and attestation manifests by digest before the index referencing them.
+		for _, b := range []testBlob{img.manifest, img.attestation, img.index} {
+			r := rc.Ref.SetDigest(b.desc.Digest.String())
+			if b.desc.Digest == img.index.desc.Digest {
+				r = rc.Ref
+			}
+			m, err := manifest.New(manifest.WithRaw(b.data), manifest.WithDesc(toDescriptor(b.desc)), manifest.WithRef(r))
+			require.NoError(t, err)
+			require.NoError(t, rc.ManifestPut(ctx, r, m), "Failed to push manifest '%s'", b.desc.Digest)
 		}
-	}
-}
 
-func pushImage(ctx context.Context, cli *client.Client, imageName string, opts image.PushOptions) (string, error) {
-	if opts.RegistryAuth == "" {
-		opts.RegistryAuth = base64.URLEncoding.EncodeToString([]byte("{}"))
-	}
+		// Verify the index, attestation manifest, and in-toto statement are pulled back unchanged.
+		m, err := rc.ManifestGet(ctx, rc.Ref)
+		require.NoError(t, err, "Failed to get index for '%s' from unregistry", imageName)
+		assert.Equal(t, img.index.desc.Digest.String(), m.GetDescriptor().Digest.String())
+		raw, err := m.RawBody()
+		require.NoError(t, err)
+		assert.Equal(t, img.index.data, raw, "Index should be returned unchanged")
 
-	respBody, err := cli.ImagePush(ctx, imageName, opts)
-	if err != nil {
-		return "", err
-	}
-	defer respBody.Close()
-
-	decoder := json.NewDecoder(respBody)
-	errCh := make(chan error, 1)
-
-	var output []string
-	go func() {
-		var jm jsonmessage.JSONMessage
-		for {
-			if err = decoder.Decode(&jm); err != nil {
-				if errors.Is(err, io.EOF) {
-					errCh <- nil
-					return
-				}
-				errCh <- fmt.Errorf("decode image push message: %v", err)
-				return
-			}
+		m, err = rc.ManifestGet(ctx, rc.Ref.SetDigest(img.attestation.desc.Digest.String()))
+		require.NoError(t, err, "Failed to get attestation manifest from unregistry")
+		raw, err = m.RawBody()
+		require.NoError(t, err)
+		assert.Equal(t, img.attestation.data, raw, "Attestation manifest should be returned unchanged")
 
-			if jm.Error != nil {
-				errCh <- fmt.Errorf("push failed for '%s': %s", imageName, jm.Error.Message)
-				return
-			}
+		statement := img.blobs[len(img.blobs)-1]
+		br, err := rc.BlobGet(ctx, rc.Ref, toDescriptor(statement.desc))
+		require.NoError(t, err, "Failed to get in-toto statement from unregistry")
+		raw, err = br.RawBody()
+		require.NoError(t, err)
+		assert.Equal(t, statement.data, raw, "In-toto statement should be returned unchanged")
 
-			if jm.ID != "" {
-				output = append(output, fmt.Sprintf("%s: %s", jm.ID, jm.Status))
-			} else {
-				output = append(output, jm.Status)
+		// Verify remote Docker recognizes the attestation of the platform image.
+		remoteSummary, err := remoteCli.ImageList(ctx, image.ListOptions{
+			Filters:   filters.NewArgs(filters.Arg("reference", imageName)),
+			Manifests: true,
+		})
+		require.NoError(t, err, "Failed to list image in remote Docker")
+		require.Len(t, remoteSummary, 1, "Image should be available in remote Docker")
+
+		var attestations []string
+		for _, rm := range remoteSummary[0].Manifests {
+			if rm.Kind == image.ManifestKindAttestation {
+				require.NotNil(t, rm.AttestationData)
+				assert.True(t, rm.Available, "Attestation manifest %s should be available", rm.ID)
+				assert.Equal(t, img.manifest.desc.Digest, rm.AttestationData.For)
+				attestations = append(attestations, rm.ID)
 			}
 		}
-	}()
-
-	for {
-		select {
-		case err = <-errCh:
-			return strings.Join(output, "\n"), err
-		case <-ctx.Done():
-			return "", ctx.Err()
-		}
-	}
+		assert.Equal(t, []string{img.attestation.desc.Digest.String()}, attestations)
+
+		err = rc.ImageExport(ctx, rc.Ref, io.Discard)
+		require.NoError(t, err, "Failed to pull image '%s' from unregistry", imageName)
+	})
 }
 
 // regClient is a wrapper around regclient.RegClient to work with a specific repository reference.
@@ -634,3 +655,105 @@ func (rc *regClient) pushTarballImage(ctx context.Context, tarPath string) error
 
 	return nil
 }
+
+// testBlob is a blob or manifest of a generated test image.
+type testBlob struct {
+	desc ocispec.Descriptor
+	data []byte
+}
+
+func newTestBlob(t *testing.T, mediaType string, v any) testBlob {
+	data, ok := v.([]byte)
+	if !ok {
+		var err error
+		data, err = json.Marshal(v)
+		require.NoError(t, err)
+	}
+	return testBlob{
+		desc: ocispec.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(data), Size: int64(len(data))},
+		data: data,
+	}
+}
+
+func toDescriptor(desc ocispec.Descriptor) descriptor.Descriptor {
+	return descriptor.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size}
+}
+
+// attestedImage is a multi-platform image laid out the way buildx pushes it with provenance attestations: an index
+// with a linux/amd64 image manifest and an unknown/unknown attestation manifest referring to it.
+type attestedImage struct {
+	// blobs are the layers and configs of the manifests with the in-toto statement last.
+	blobs       []testBlob
+	manifest    testBlob
+	attestation testBlob
+	index       testBlob
+}
+
+func newAttestedImage(t *testing.T) attestedImage {
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	content := []byte("attested\n")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "hello.txt", Mode: 0o644, Size: int64(len(content))}))
+	_, err := tw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	var gzBuf bytes.Buffer
+	gw := gzip.NewWriter(&gzBuf)
+	_, err = gw.Write(tarBuf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	layer := newTestBlob(t, ocispec.MediaTypeImageLayerGzip, gzBuf.Bytes())
+	config := newTestBlob(t, ocispec.MediaTypeImageConfig, ocispec.Image{
+		Platform: ocispec.Platform{Architecture: "amd64", OS: "linux"},
+		RootFS:   ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{digest.FromBytes(tarBuf.Bytes())}},
+	})
+	imageManifest := newTestBlob(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config.desc,
+		Layers:    []ocispec.Descriptor{layer.desc},
+	})
+
+	statement := newTestBlob(t, "application/vnd.in-toto+json", map[string]any{
+		"_type":         "https://in-toto.io/Statement/v0.1",
+		"predicateType": "https://slsa.dev/provenance/v0.2",
+		"subject": []map[string]any{{
+			"name":   "pkg:docker/attested@latest?platform=linux%2Famd64",
+			"digest": map[string]string{"sha256": imageManifest.desc.Digest.Encoded()},
+		}},
+		"predicate": map[string]any{"buildType": "https://mobyproject.org/buildkit@v1"},
+	})
+	statement.desc.Annotations = map[string]string{"in-toto.io/predicate-type": "https://slsa.dev/provenance/v0.2"}
+	attestationConfig := newTestBlob(t, ocispec.MediaTypeImageConfig, ocispec.Image{
+		Platform: ocispec.Platform{Architecture: "unknown", OS: "unknown"},
+		RootFS:   ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{statement.desc.Digest}},
+	})
+	attestation := newTestBlob(t, ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    attestationConfig.desc,
+		Layers:    []ocispec.Descriptor{statement.desc},
+	})
+
+	imageDesc := imageManifest.desc
+	imageDesc.Platform = &ocispec.Platform{Architecture: "amd64", OS: "linux"}
+	attestationDesc := attestation.desc
+	attestationDesc.Platform = &ocispec.Platform{Architecture: "unknown", OS: "unknown"}
+	attestationDesc.Annotations = map[string]string{
+		"vnd.docker.reference.digest": imageManifest.desc.Digest.String(),
+		"vnd.docker.reference.type":   "attestation-manifest",
+	}
+	index := newTestBlob(t, ocispec.MediaTypeImageIndex, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{imageDesc, attestationDesc},
+	})
+
+	return attestedImage{
+		blobs:       []testBlob{layer, config, attestationConfig, statement},
+		manifest:    imageManifest,
+		attestation: attestation,
+		index:       index,
+	}
+}
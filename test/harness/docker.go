@@ -0,0 +1,107 @@
+package harness
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// PullImage pulls the image with the Docker client and waits until the pull completes.
+func PullImage(ctx context.Context, cli *client.Client, imageName string, opts image.PullOptions) error {
+	respBody, err := cli.ImagePull(ctx, imageName, opts)
+	if err != nil {
+		return err
+	}
+	defer respBody.Close()
+
+	decoder := json.NewDecoder(respBody)
+	errCh := make(chan error, 1)
+
+	go func() {
+		var jm jsonmessage.JSONMessage
+		for {
+			if err = decoder.Decode(&jm); err != nil {
+				if errors.Is(err, io.EOF) {
+					errCh <- nil
+					return
+				}
+				errCh <- fmt.Errorf("decode image pull message: %v", err)
+				return
+			}
+
+			if jm.Error != nil {
+				errCh <- fmt.Errorf("pull failed for '%s': %s", imageName, jm.Error.Message)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case err = <-errCh:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// PushImage pushes the image with the Docker client and waits until the push completes. It returns the push
+// progress messages.
+func PushImage(ctx context.Context, cli *client.Client, imageName string, opts image.PushOptions) (string, error) {
+	if opts.RegistryAuth == "" {
+		opts.RegistryAuth = base64.URLEncoding.EncodeToString([]byte("{}"))
+	}
+
+	respBody, err := cli.ImagePush(ctx, imageName, opts)
+	if err != nil {
+		return "", err
+	}
+	defer respBody.Close()
+
+	decoder := json.NewDecoder(respBody)
+	errCh := make(chan error, 1)
+
+	var output []string
+	go func() {
+		var jm jsonmessage.JSONMessage
+		for {
+			if err = decoder.Decode(&jm); err != nil {
+				if errors.Is(err, io.EOF) {
+					errCh <- nil
+					return
+				}
+				errCh <- fmt.Errorf("decode image push message: %v", err)
+				return
+			}
+
+			if jm.Error != nil {
+				errCh <- fmt.Errorf("push failed for '%s': %s", imageName, jm.Error.Message)
+				return
+			}
+
+			if jm.ID != "" {
+				output = append(output, fmt.Sprintf("%s: %s", jm.ID, jm.Status))
+			} else {
+				output = append(output, jm.Status)
+			}
+		}
+	}()
+
+	for {
+		select {
+		case err = <-errCh:
+			return strings.Join(output, "\n"), err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
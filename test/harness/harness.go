@@ -0,0 +1,271 @@
+// Package harness runs unregistry in a Docker-in-Docker container for end-to-end tests. It's used by the unregistry
+// e2e tests and can be reused by integrators and backend contributors to test against the same environments:
+//
+//	func TestPush(t *testing.T) {
+//		harness.RunMatrix(t, harness.Matrix, func(t *testing.T, u *harness.Unregistry) {
+//			remote := u.DockerClient(t)
+//			// Push to u.RegistryAddr and check the image in remote.
+//		})
+//	}
+package harness
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+const (
+	// htpasswdPath is the path to the htpasswd file in the container when authentication is enabled.
+	htpasswdPath = "/etc/unregistry/htpasswd"
+	// tlsCertPath and tlsKeyPath are the paths to the TLS certificate and key in the container when TLS is enabled.
+	tlsCertPath = "/etc/unregistry/tls.crt"
+	tlsKeyPath  = "/etc/unregistry/tls.key"
+	// logLines is the number of the last lines of the container logs printed when the test finishes.
+	logLines = 20
+)
+
+// Options configures the environment unregistry runs in.
+type Options struct {
+	// Image is a prebuilt unregistry Docker-in-Docker image to run. If empty, the image is built from the
+	// unregistry-dind target of Dockerfile.test in the unregistry source tree.
+	Image string
+	// RegistryPort is the host port to map the registry port to. A random port is used if 0. Docker Desktop may be
+	// unable to push to an automatically mapped port, so set it explicitly for tests pushing with the local Docker.
+	RegistryPort int
+	// ContainerdStore enables the containerd image store in Docker. Docker uses its native image store otherwise.
+	ContainerdStore bool
+	// UsernsRemap enables user namespace remapping in Docker with the default "dockremap" user. Docker may keep
+	// images in a separate containerd namespace then, so set UNREGISTRY_CONTAINERD_NAMESPACE in Env accordingly.
+	UsernsRemap bool
+	// Users are the usernames and passwords required to access the registry with HTTP Basic authentication.
+	// Authentication is disabled if empty.
+	Users map[string]string
+	// TLS serves the registry over HTTPS with a self-signed certificate for localhost.
+	TLS bool
+	// Env sets additional environment variables for unregistry and the entrypoint, e.g. UNREGISTRY_* settings.
+	Env map[string]string
+}
+
+// Environment is a named set of options to run unregistry with.
+type Environment struct {
+	Name    string
+	Options Options
+}
+
+// Matrix is the set of Docker image stores on the remote host that unregistry is expected to work with.
+var Matrix = []Environment{
+	{Name: "native image store"},
+	{Name: "containerd image store", Options: Options{ContainerdStore: true}},
+}
+
+// Unregistry is unregistry running in a Docker-in-Docker container.
+type Unregistry struct {
+	testcontainers.Container
+	// RegistryAddr is the host address of the registry, e.g. "localhost:50000".
+	RegistryAddr string
+	// DockerHost is the address of the Docker daemon in the container, e.g. "tcp://localhost:32768".
+	DockerHost string
+	// SSHPort is the host port mapped to the SSH server in the container. The server accepts the root user with
+	// the key at SSHKeyPath.
+	SSHPort string
+	// CACert is the PEM-encoded self-signed certificate of the registry if TLS is enabled.
+	CACert []byte
+}
+
+// Run starts unregistry in a Docker-in-Docker container with the options. The container is terminated and the last
+// lines of its logs are printed when the test finishes.
+func Run(t testing.TB, opts Options) *Unregistry {
+	t.Helper()
+	ctx := context.Background()
+
+	registryPort := "5000"
+	if opts.RegistryPort != 0 {
+		registryPort = fmt.Sprintf("%d:5000", opts.RegistryPort)
+	}
+	env := map[string]string{
+		"DOCKER_CONTAINERD_STORE": strconv.FormatBool(opts.ContainerdStore),
+		"DOCKER_USERNS_REMAP":     strconv.FormatBool(opts.UsernsRemap),
+		"UNREGISTRY_LOG_LEVEL":    "debug",
+	}
+	var files []testcontainers.ContainerFile
+	if len(opts.Users) > 0 {
+		htpasswd, err := Htpasswd(opts.Users)
+		require.NoError(t, err)
+		files = append(files, testcontainers.ContainerFile{
+			Reader:            bytes.NewReader(htpasswd),
+			ContainerFilePath: htpasswdPath,
+			FileMode:          0o644,
+		})
+		env["UNREGISTRY_HTPASSWD"] = htpasswdPath
+	}
+	var caCert []byte
+	if opts.TLS {
+		cert, key, err := SelfSignedCert("localhost", "127.0.0.1")
+		require.NoError(t, err)
+		files = append(files,
+			testcontainers.ContainerFile{
+				Reader:            bytes.NewReader(cert),
+				ContainerFilePath: tlsCertPath,
+				FileMode:          0o644,
+			},
+			testcontainers.ContainerFile{
+				Reader:            bytes.NewReader(key),
+				ContainerFilePath: tlsKeyPath,
+				FileMode:          0o600,
+			},
+		)
+		env["UNREGISTRY_TLS_CERT"] = tlsCertPath
+		env["UNREGISTRY_TLS_KEY"] = tlsKeyPath
+		caCert = cert
+	}
+	for k, v := range opts.Env {
+		env[k] = v
+	}
+
+	req := testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image: opts.Image,
+			Env:   env,
+			Files: files,
+			// Docker-in-Docker requires a privileged container.
+			Privileged:   true,
+			ExposedPorts: []string{"22", "2375", registryPort},
+			WaitingFor: wait.ForAll(
+				wait.ForListeningPort("22"),
+				wait.ForListeningPort("2375"),
+				wait.ForListeningPort("5000"),
+			).WithStartupTimeoutDefault(30 * time.Second),
+		},
+		Started: true,
+	}
+	if opts.Image == "" {
+		req.FromDockerfile = testcontainers.FromDockerfile{
+			Context:    projectRoot(),
+			Dockerfile: "Dockerfile.test",
+			BuildOptionsModifier: func(buildOptions *types.ImageBuildOptions) {
+				buildOptions.Target = "unregistry-dind"
+			},
+		}
+	}
+	ctr, err := testcontainers.GenericContainer(ctx, req)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		printLogs(t, ctr)
+		// Ensure the container is terminated after the test.
+		assert.NoError(t, ctr.Terminate(ctx))
+	})
+
+	mappedRegistryPort, err := ctr.MappedPort(ctx, nat.Port("5000"))
+	require.NoError(t, err)
+	mappedDockerPort, err := ctr.MappedPort(ctx, nat.Port("2375"))
+	require.NoError(t, err)
+	mappedSSHPort, err := ctr.MappedPort(ctx, nat.Port("22"))
+	require.NoError(t, err)
+
+	u := &Unregistry{
+		Container:    ctr,
+		RegistryAddr: "localhost:" + mappedRegistryPort.Port(),
+		DockerHost:   "tcp://localhost:" + mappedDockerPort.Port(),
+		SSHPort:      mappedSSHPort.Port(),
+		CACert:       caCert,
+	}
+	t.Logf("Unregistry started at %s", u.URL())
+	return u
+}
+
+// RunMatrix runs fn as a parallel subtest for each environment with unregistry started in it.
+func RunMatrix(t *testing.T, envs []Environment, fn func(t *testing.T, u *Unregistry)) {
+	for _, env := range envs {
+		t.Run(env.Name, func(t *testing.T) {
+			t.Parallel()
+			fn(t, Run(t, env.Options))
+		})
+	}
+}
+
+// URL returns the base URL of the registry.
+func (u *Unregistry) URL() string {
+	if u.CACert != nil {
+		return "https://" + u.RegistryAddr
+	}
+	return "http://" + u.RegistryAddr
+}
+
+// TLSConfig returns the TLS configuration trusting the self-signed certificate of the registry. It returns nil if
+// TLS is disabled.
+func (u *Unregistry) TLSConfig() *tls.Config {
+	if u.CACert == nil {
+		return nil
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(u.CACert)
+	return &tls.Config{RootCAs: pool}
+}
+
+// DockerClient returns a client for the Docker daemon in the container. The client is closed when the test finishes.
+func (u *Unregistry) DockerClient(t testing.TB) *client.Client {
+	t.Helper()
+	cli, err := client.NewClientWithOpts(client.WithHost(u.DockerHost), client.WithAPIVersionNegotiation())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = cli.Close()
+	})
+	return cli
+}
+
+// SSHKeyPath returns the path to the private SSH key authorized for the root user in the container.
+func SSHKeyPath(t testing.TB) string {
+	t.Helper()
+	path := filepath.Join(projectRoot(), "test", "e2e", "ssh", "test_key")
+	// SSH refuses to use a private key accessible by others: WARNING: UNPROTECTED PRIVATE KEY FILE!
+	require.NoError(t, os.Chmod(path, 0o600), "Failed to change permission of SSH key")
+	return path
+}
+
+// printLogs prints the last lines of the container logs.
+func printLogs(t testing.TB, ctr testcontainers.Container) {
+	logs, err := ctr.Logs(context.Background())
+	if !assert.NoError(t, err, "Failed to get logs from unregistry container.") {
+		return
+	}
+	defer logs.Close()
+	content, err := io.ReadAll(logs)
+	if !assert.NoError(t, err, "Failed to read logs from unregistry container.") {
+		return
+	}
+
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	t.Logf("=== Last %d lines of unregistry container logs ===", logLines)
+	for _, line := range lines[max(0, len(lines)-logLines):] {
+		if line != "" {
+			t.Log(line)
+		}
+	}
+	t.Log("=== End of unregistry container logs ===")
+}
+
+// projectRoot returns the root directory of the unregistry source tree.
+func projectRoot() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(filepath.Dir(filepath.Dir(file)))
+}
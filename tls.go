@@ -0,0 +1,152 @@
+package unregistry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newTLSConfig creates the TLS configuration for the registry server using either the certificate files or
+// certificates automatically obtained from Let's Encrypt. It returns nil if TLS is disabled.
+func newTLSConfig(cfg Config) (*tls.Config, error) {
+	if len(cfg.ACMEDomains) > 0 {
+		if cfg.TLSCert != "" || cfg.TLSKey != "" {
+			return nil, fmt.Errorf("TLS certificate files can't be used with automatic TLS for ACME domains")
+		}
+		if cfg.ACMECacheDir == "" {
+			return nil, fmt.Errorf("ACME cache directory must be set")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cfg.ACMECacheDir),
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+			Email:      cfg.ACMEEmail,
+		}
+		logrus.WithField("domains", cfg.ACMEDomains).Info(
+			"TLS certificates are obtained automatically from Let's Encrypt.")
+		// The returned config answers TLS-ALPN-01 challenges and renews certificates before they expire.
+		tlsConfig := m.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return tlsConfig, nil
+	}
+
+	if cfg.TLSCert == "" && cfg.TLSKey == "" {
+		return nil, nil
+	}
+	if cfg.TLSCert == "" || cfg.TLSKey == "" {
+		return nil, fmt.Errorf("both TLS certificate and key must be set")
+	}
+	certs, err := newCertReloader(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}, nil
+}
+
+// containerdTLSConfig creates the TLS configuration for connecting to containerd on a TCP endpoint. It returns nil if
+// neither the CA nor the client certificate is set.
+func containerdTLSConfig(cfg Config) (*tls.Config, error) {
+	if cfg.ContainerdTLSCA == "" && cfg.ContainerdTLSCert == "" && cfg.ContainerdTLSKey == "" {
+		return nil, nil
+	}
+	if (cfg.ContainerdTLSCert == "") != (cfg.ContainerdTLSKey == "") {
+		return nil, fmt.Errorf("both containerd TLS certificate and key must be set")
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.ContainerdTLSCA != "" {
+		ca, err := os.ReadFile(cfg.ContainerdTLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("read containerd TLS CA certificate: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in containerd TLS CA file '%s'", cfg.ContainerdTLSCA)
+		}
+	}
+	if cfg.ContainerdTLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ContainerdTLSCert, cfg.ContainerdTLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("load containerd TLS client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// certReloader loads a TLS certificate and key pair from files and reloads them when the files change, e.g. when
+// the certificate is renewed, without restarting the server.
+type certReloader struct {
+	certPath string
+	keyPath  string
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+	// certModTime and keyModTime are the modification times of the files the current certificate was loaded from.
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// newCertReloader creates a certificate reloader and loads the initial certificate.
+func newCertReloader(certPath, keyPath string) (*certReloader, error) {
+	r := &certReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate reloading it first if the files have changed. If reloading fails,
+// the previous certificate is used until the files are fixed. It implements tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if err := r.reload(); err != nil {
+		logrus.WithError(err).Warn("Failed to reload TLS certificate, using the previous one.")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
+
+// reload loads the certificate and key pair if the files have changed since the last load.
+func (r *certReloader) reload() error {
+	certInfo, err := os.Stat(r.certPath)
+	if err != nil {
+		return fmt.Errorf("stat TLS certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyPath)
+	if err != nil {
+		return fmt.Errorf("stat TLS key: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cert != nil && certInfo.ModTime().Equal(r.certModTime) && keyInfo.ModTime().Equal(r.keyModTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate and key: %w", err)
+	}
+	if r.cert != nil {
+		logrus.WithField("cert", r.certPath).Info("Reloaded TLS certificate.")
+	}
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+
+	return nil
+}
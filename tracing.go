@@ -0,0 +1,53 @@
+package unregistry
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingHandler wraps the registry handler to start a span for every request, continuing the trace propagated by
+// the client if any. The spans of the containerd storage operations made while serving the request are its children.
+func tracingHandler(next http.Handler) http.Handler {
+	annotated := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if repo, _ := requestRoute(r.URL.Path); repo != "" {
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("registry.repository", repo))
+		}
+		next.ServeHTTP(w, r)
+	})
+	return otelhttp.NewHandler(annotated, "registry",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			_, route := requestRoute(r.URL.Path)
+			return r.Method + " " + route
+		}),
+	)
+}
+
+// requestRoute returns the repository name of the registry request path and the route the path matches with
+// the variable parts replaced by placeholders, e.g. "/v2/{name}/blobs/uploads/{uuid}", to name the request spans
+// without creating a distinct span name for every repository and digest.
+func requestRoute(path string) (repo, route string) {
+	switch {
+	case uploadSessionPathRegexp.MatchString(path):
+		return uploadSessionPathRegexp.FindStringSubmatch(path)[1], "/v2/{name}/blobs/uploads/{uuid}"
+	case uploadsPathRegexp.MatchString(path):
+		return uploadsPathRegexp.FindStringSubmatch(path)[1], "/v2/{name}/blobs/uploads/"
+	case globalBlobPathRegexp.MatchString(path):
+		return "", "/v2/_blobs/{digest}"
+	case blobPathRegexp.MatchString(path):
+		return blobPathRegexp.FindStringSubmatch(path)[1], "/v2/{name}/blobs/{digest}"
+	case manifestPathRegexp.MatchString(path):
+		return manifestPathRegexp.FindStringSubmatch(path)[1], "/v2/{name}/manifests/{reference}"
+	case deltaBasesPathRegexp.MatchString(path):
+		return deltaBasesPathRegexp.FindStringSubmatch(path)[1], "/v2/{name}/_deltas/bases"
+	case strings.HasSuffix(path, "/tags/list") && strings.HasPrefix(path, "/v2/"):
+		return strings.TrimSuffix(strings.TrimPrefix(path, "/v2/"), "/tags/list"), "/v2/{name}/tags/list"
+	case path == "/v2/" || path == "/v2" || path == "/v2/_catalog":
+		return "", path
+	default:
+		return "", "other"
+	}
+}
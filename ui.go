@@ -0,0 +1,22 @@
+package unregistry
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// uiPage is the single-page web UI for browsing and deleting the images on the host. It's built on the admin API
+// endpoints and has no dependencies.
+//
+//go:embed ui/index.html
+var uiPage []byte
+
+// uiHandler serves the embedded web UI.
+func uiHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Security-Policy",
+		"default-src 'self'; style-src 'unsafe-inline'; script-src 'unsafe-inline'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	_, _ = w.Write(uiPage)
+}
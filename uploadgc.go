@@ -0,0 +1,129 @@
+package unregistry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/psviderski/unregistry/internal/progress"
+	"github.com/psviderski/unregistry/internal/storage/containerd"
+	"github.com/psviderski/unregistry/internal/transfer"
+	"github.com/sirupsen/logrus"
+)
+
+// maxStaleUploadsInterval is the maximum interval between the background purges of stale uploads.
+const maxStaleUploadsInterval = time.Hour
+
+// purgeStaleUploads aborts the blob uploads that haven't received data for longer than maxAge and logs the result.
+func (r *Registry) purgeStaleUploads(ctx context.Context, maxAge time.Duration) (containerd.UploadPurge, error) {
+	var (
+		purge containerd.UploadPurge
+		err   error
+	)
+	switch {
+	case r.dockerStore != nil:
+		purge, err = r.purgeDockerUploads(maxAge)
+	case r.client != nil:
+		purge, err = containerd.PurgeStaleUploads(ctx, r.client, r.metadata, maxAge)
+	}
+	// Finish the progress of the aborted uploads so that their subscribers are notified and they aren't tracked
+	// forever. It's done before checking the error as some uploads may have been purged before it occurred.
+	for _, u := range purge.Purged {
+		if _, ok := r.progress.Get(u.ID); ok {
+			r.progress.Finish(u.ID, progress.StateFailed,
+				fmt.Errorf("upload purged after not receiving data for longer than %s", maxAge))
+		}
+	}
+	if err != nil {
+		return purge, err
+	}
+	if len(purge.Purged) > 0 || purge.Sessions > 0 {
+		logrus.WithFields(logrus.Fields{
+			"uploads":  len(purge.Purged),
+			"freed":    transfer.HumanSize(purge.PurgedBytes),
+			"sessions": purge.Sessions,
+			"max_age":  maxAge,
+		}).Info("Purged stale blob uploads.")
+	}
+	return purge, nil
+}
+
+// purgeDockerUploads deletes the data of the stale uploads in the blob store of the "docker" backend along with
+// the blobs that haven't been used for longer than maxAge as they're only staged there.
+func (r *Registry) purgeDockerUploads(maxAge time.Duration) (containerd.UploadPurge, error) {
+	var purge containerd.UploadPurge
+	stale, err := r.dockerStore.PurgeStaleUploads(maxAge)
+	for _, u := range stale {
+		purge.Purged = append(purge.Purged, containerd.PurgedUpload{
+			ID:        u.ID,
+			StartedAt: u.UpdatedAt,
+			UpdatedAt: u.UpdatedAt,
+			Size:      u.Size,
+		})
+		purge.PurgedBytes += u.Size
+	}
+	if err != nil {
+		return purge, err
+	}
+
+	idle, err := r.dockerStore.PurgeIdleBlobs(maxAge)
+	if len(idle) > 0 {
+		var size int64
+		for _, b := range idle {
+			size += b.Size
+		}
+		logrus.WithFields(logrus.Fields{
+			"blobs":   len(idle),
+			"freed":   transfer.HumanSize(size),
+			"max_age": maxAge,
+		}).Info("Deleted idle blobs from docker backend blob store.")
+	}
+	return purge, err
+}
+
+// purgeStaleUploadsLoop periodically purges the stale uploads until the context is canceled. It checks a few times
+// per maxAge so that a stale upload isn't kept for much longer than maxAge.
+func (r *Registry) purgeStaleUploadsLoop(ctx context.Context, maxAge time.Duration) {
+	ticker := time.NewTicker(min(max(maxAge/4, time.Second), maxStaleUploadsInterval))
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.purgeStaleUploads(ctx, maxAge); err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Error("Failed to purge stale blob uploads.")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeUploadsHandler purges the stale uploads on request. The "age" query parameter overrides the configured age
+// after which an upload without new data is considered stale.
+func (r *Registry) purgeUploadsHandler(w http.ResponseWriter, req *http.Request) {
+	maxAge := r.cfg.StaleUploadAge
+	if v := req.URL.Query().Get("age"); v != "" {
+		var err error
+		if maxAge, err = time.ParseDuration(v); err != nil || maxAge <= 0 {
+			http.Error(w, fmt.Sprintf("invalid 'age' query parameter: '%s'", v), http.StatusBadRequest)
+			return
+		}
+	}
+	if maxAge <= 0 {
+		http.Error(w, "'age' query parameter is required as stale upload purging is disabled",
+			http.StatusBadRequest)
+		return
+	}
+
+	purge, err := r.purgeStaleUploads(req.Context(), maxAge)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if purge.Purged == nil {
+		purge.Purged = []containerd.PurgedUpload{}
+	}
+	writeJSON(w, http.StatusOK, purge)
+}
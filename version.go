@@ -0,0 +1,25 @@
+package unregistry
+
+import (
+	"net/http"
+
+	"github.com/psviderski/unregistry/internal/version"
+)
+
+// versionHandler wraps the registry handler to report the unregistry version in the X-Unregistry-Version header of
+// every response so that clients such as docker-pussh can detect an incompatible server. The API version check
+// at /v2/ also reports the commit and build date.
+func versionHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Unregistry-Version", version.Version)
+		if req.URL.Path == "/v2/" || req.URL.Path == "/v2" {
+			if version.Commit != "" {
+				w.Header().Set("X-Unregistry-Commit", version.Commit)
+			}
+			if version.BuildDate != "" {
+				w.Header().Set("X-Unregistry-Build-Date", version.BuildDate)
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}